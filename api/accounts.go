@@ -17,6 +17,7 @@ import (
 	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/dvote/util"
 	"go.vocdoni.io/dvote/vochain/indexer/indexertypes"
+	"go.vocdoni.io/dvote/vochain/state"
 	"go.vocdoni.io/proto/build/go/models"
 	"google.golang.org/protobuf/proto"
 )
@@ -34,6 +35,14 @@ func (a *API) enableAccountHandlers() error {
 	); err != nil {
 		return err
 	}
+	if err := a.endpoint.RegisterMethod(
+		"/accounts/{address}/proof",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		a.accountProofHandler,
+	); err != nil {
+		return err
+	}
 	if err := a.endpoint.RegisterMethod(
 		"/accounts",
 		"POST",
@@ -42,6 +51,14 @@ func (a *API) enableAccountHandlers() error {
 	); err != nil {
 		return err
 	}
+	if err := a.endpoint.RegisterMethod(
+		"/accounts/search",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		a.organizationSearchHandler,
+	); err != nil {
+		return err
+	}
 	if err := a.endpoint.RegisterMethod(
 		"/accounts/treasurer",
 		"GET",
@@ -106,18 +123,39 @@ func (a *API) enableAccountHandlers() error {
 	); err != nil {
 		return err
 	}
+	if err := a.endpoint.RegisterMethod(
+		"/accounts/{accountID}/transactions/page/{page}",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		a.accountTransactionsHandler,
+	); err != nil {
+		return err
+	}
+	if err := a.endpoint.RegisterMethod(
+		"/accounts/{accountID}/transactions",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		a.accountTransactionsHandler,
+	); err != nil {
+		return err
+	}
 
 	return nil
 }
 
 // /account/{address}
 // get the account information
+// with the optional height query parameter, the account committed at that height
 func (a *API) accountHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
 	if len(util.TrimHex(ctx.URLParam("address"))) != common.AddressLength*2 {
 		return fmt.Errorf("address malformed")
 	}
 	addr := common.HexToAddress(ctx.URLParam("address"))
-	acc, err := a.vocapp.State.GetAccount(addr, true)
+	st, err := a.stateAt(ctx)
+	if err != nil {
+		return err
+	}
+	acc, err := st.GetAccount(addr, true)
 	if err != nil || acc == nil {
 		return fmt.Errorf("account %s does not exist", addr.Hex())
 	}
@@ -137,20 +175,70 @@ func (a *API) accountHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext)
 		}
 	}
 
+	// Multisig policy, if any
+	var multisig *AccountMultisig
+	policy, err := st.MultisigPolicy(addr, true)
+	if err != nil {
+		return fmt.Errorf("cannot get multisig policy: %w", err)
+	}
+	if policy != nil {
+		multisig = &AccountMultisig{Threshold: policy.Threshold}
+		for _, signer := range policy.Signers {
+			multisig.Signers = append(multisig.Signers, signer.Bytes())
+		}
+	}
+
+	// Account rotations, if any
+	var rotatedTo types.HexBytes
+	to, err := st.AccountRotation(addr, true)
+	if err != nil {
+		return fmt.Errorf("cannot get account rotation: %w", err)
+	}
+	if to != nil {
+		rotatedTo = to.Bytes()
+	}
+	var previous []types.HexBytes
+	if a.indexer != nil {
+		if previous, err = a.indexer.PreviousAccounts(addr.Bytes()); err != nil {
+			return fmt.Errorf("cannot get previous account addresses: %w", err)
+		}
+	}
+
 	var data []byte
 	if data, err = json.Marshal(Account{
-		Address:       addr.Bytes(),
-		Nonce:         acc.GetNonce(),
-		Balance:       acc.GetBalance(),
-		ElectionIndex: acc.GetProcessIndex(),
-		InfoURL:       acc.GetInfoURI(),
-		Metadata:      accMetadata,
+		Address:           addr.Bytes(),
+		Nonce:             acc.GetNonce(),
+		Balance:           acc.GetBalance(),
+		ElectionIndex:     acc.GetProcessIndex(),
+		InfoURL:           acc.GetInfoURI(),
+		Metadata:          accMetadata,
+		Multisig:          multisig,
+		RotatedTo:         rotatedTo,
+		PreviousAddresses: previous,
 	}); err != nil {
 		return err
 	}
 	return ctx.Send(data, apirest.HTTPstatusCodeOK)
 }
 
+// GET /accounts/<address>/proof
+// get the merkle proof of the account up to the state root
+func (a *API) accountProofHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	if len(util.TrimHex(ctx.URLParam("address"))) != common.AddressLength*2 {
+		return fmt.Errorf("address malformed")
+	}
+	addr := common.HexToAddress(ctx.URLParam("address"))
+	st, err := a.stateAt(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := a.stateProof(st, state.TreeAccounts, addr.Bytes())
+	if err != nil {
+		return fmt.Errorf("account %s does not exist: %w", addr.Hex(), err)
+	}
+	return ctx.Send(data, apirest.HTTPstatusCodeOK)
+}
+
 // POST /account
 // set account information
 func (a *API) accountSetHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
@@ -267,6 +355,10 @@ func (a *API) electionListHandler(msg *apirest.APIdata, ctx *httprouter.HTTPCont
 	if err != nil || organizationID == nil {
 		return fmt.Errorf("organizationID (%q) cannot be decoded", ctx.URLParam("organizationID"))
 	}
+	// a rotated organization is listed by its current address
+	if organizationID, err = a.indexer.ResolveAccount(organizationID); err != nil {
+		return fmt.Errorf("cannot resolve organization: %w", err)
+	}
 
 	page := 0
 	if ctx.URLParam("page") != "" {
@@ -333,7 +425,12 @@ func (a *API) electionCountHandler(msg *apirest.APIdata, ctx *httprouter.HTTPCon
 	if err != nil || organizationID == nil {
 		return fmt.Errorf("organizationID (%q) cannot be decoded", ctx.URLParam("organizationID"))
 	}
-	acc, err := a.vocapp.State.GetAccount(common.BytesToAddress(organizationID), true)
+	// a rotated organization is counted by its current address
+	address, err := a.vocapp.State.ResolveAccount(common.BytesToAddress(organizationID), true)
+	if err != nil {
+		return fmt.Errorf("cannot resolve organization: %w", err)
+	}
+	acc, err := a.vocapp.State.GetAccount(address, true)
 	if acc == nil {
 		return fmt.Errorf("organization not found")
 	}
@@ -387,3 +484,34 @@ func (a *API) tokenTransfersHandler(msg *apirest.APIdata, ctx *httprouter.HTTPCo
 	}
 	return ctx.Send(data, apirest.HTTPstatusCodeOK)
 }
+
+// /accounts/<accountID>/transactions/page/<page>
+// Returns the transactions signed by an account, or related to the elections
+// of an organization, newest first
+func (a *API) accountTransactionsHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	accountID, err := hex.DecodeString(util.TrimHex(ctx.URLParam("accountID")))
+	if err != nil || len(accountID) != common.AddressLength {
+		return fmt.Errorf("accountID (%q) cannot be decoded", ctx.URLParam("accountID"))
+	}
+	page := 0
+	if ctx.URLParam("page") != "" {
+		page, err = strconv.Atoi(ctx.URLParam("page"))
+		if err != nil {
+			return fmt.Errorf("cannot parse page number")
+		}
+	}
+	page = page * MaxPageSize
+	txs, err := a.indexer.GetTxReferencesByAccount(accountID, MaxPageSize, int32(page))
+	if err != nil {
+		return fmt.Errorf("cannot fetch transactions: %w", err)
+	}
+	data, err := json.Marshal(
+		struct {
+			Transactions []*indexertypes.TxReference `json:"transactions"`
+		}{Transactions: txs},
+	)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %w", err)
+	}
+	return ctx.Send(data, apirest.HTTPstatusCodeOK)
+}
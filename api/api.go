@@ -36,6 +36,7 @@ type API struct {
 	vocinfo      *vochaininfo.VochainInfo
 	censusdb     *censusdb.CensusDB
 	db           db.Database // used for internal db operations
+	events       *eventBroker
 }
 
 // NewAPI creates a new instance of the API.  Attach must be called next.
@@ -111,6 +112,11 @@ func (a *API) EnableHandlers(handlers ...string) error {
 				return fmt.Errorf("missing modules attached for enabling account handler")
 			}
 			a.enableAccountHandlers()
+		case EventsHandler:
+			if a.vocapp == nil {
+				return fmt.Errorf("missing modules attached for enabling events handler")
+			}
+			a.enableEventHandlers()
 		case CensusHandler:
 			a.enableCensusHandlers()
 			if a.censusdb == nil {
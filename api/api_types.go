@@ -6,7 +6,9 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"go.vocdoni.io/dvote/api/censusdb"
 	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/vochain/indexer/indexertypes"
 	"go.vocdoni.io/proto/build/go/models"
 	"google.golang.org/protobuf/encoding/protojson"
 )
@@ -23,6 +25,35 @@ type OrganizationList struct {
 	ElectionCount  uint64         `json:"electionCount"`
 }
 
+// ElectionSearchResult is an election whose metadata matches a full-text
+// search, with the texts of the best matching language.
+type ElectionSearchResult struct {
+	ElectionID     types.HexBytes `json:"electionId"`
+	OrganizationID types.HexBytes `json:"organizationId"`
+	Status         string         `json:"status"`
+	Language       string         `json:"language"`
+	Title          string         `json:"title"`
+	Description    string         `json:"description"`
+	Score          float64        `json:"score"`
+}
+
+// OrganizationSearchResult is an organization whose metadata matches a
+// full-text search, with the texts of the best matching language.
+type OrganizationSearchResult struct {
+	OrganizationID types.HexBytes `json:"organizationId"`
+	Language       string         `json:"language"`
+	Name           string         `json:"name"`
+	Description    string         `json:"description"`
+	Score          float64        `json:"score"`
+}
+
+// SearchResults holds the results of a full-text search, from the best to the
+// worst ranked.
+type SearchResults struct {
+	Elections     []*ElectionSearchResult     `json:"elections,omitempty"`
+	Organizations []*OrganizationSearchResult `json:"organizations,omitempty"`
+}
+
 type ElectionSummary struct {
 	ElectionID   types.HexBytes    `json:"electionId"`
 	Status       string            `json:"status"`
@@ -33,6 +64,15 @@ type ElectionSummary struct {
 	Results      [][]*types.BigInt `json:"result,omitempty"`
 }
 
+// ElectionStats is the participation analytics of an election, with its
+// votes grouped in buckets of BlockBucket blocks and of TimeBucket seconds.
+type ElectionStats struct {
+	ElectionID  types.HexBytes `json:"electionId"`
+	BlockBucket uint32         `json:"blockBucket"`
+	TimeBucket  uint32         `json:"timeBucket"`
+	*indexertypes.ElectionStats
+}
+
 // ElectionResults is the struct used to wrap the results of an election
 type ElectionResults struct {
 	// ABIEncoded is the abi encoded election results
@@ -47,11 +87,15 @@ type ElectionResults struct {
 	Results [][]*big.Int `json:"results"`
 	// SourceContractAddress is the address of the smart contract containing the census
 	SourceContractAddress types.HexBytes `json:"sourceContractAddress,omitempty"`
+	// RankedRounds is the list of tally rounds, only for ranked choice elections
+	RankedRounds []*indexertypes.RankedRound `json:"rankedRounds,omitempty"`
 }
 
 type Election struct {
 	ElectionSummary
 	ElectionCount uint32            `json:"electionCount"`
+	StartBlock    uint32            `json:"startBlock"`
+	EndBlock      uint32            `json:"endBlock"`
 	Census        *ElectionCensus   `json:"census,omitempty"`
 	MetadataURL   string            `json:"metadataURL"`
 	CreationTime  time.Time         `json:"creationTime"`
@@ -111,6 +155,45 @@ type Vote struct {
 	OverwriteCount       *uint32        `json:"overwriteCount,omitempty"`
 }
 
+// VoteProof is the merkle proof of a vote in the state, from the votes tree
+// of the election up to the state root, which is the AppHash of the block
+// header at BlockHeight.  See state.VoteProof.
+type VoteProof struct {
+	ElectionID        types.HexBytes `json:"electionID"`
+	VoteID            types.HexBytes `json:"voteID"`
+	Height            uint32         `json:"height"`
+	BlockHeight       uint32         `json:"blockHeight"`
+	Root              types.HexBytes `json:"root"`
+	Vote              types.HexBytes `json:"vote"`
+	VoteSiblings      types.HexBytes `json:"voteSiblings"`
+	Process           types.HexBytes `json:"election"`
+	ProcessSiblings   types.HexBytes `json:"electionSiblings"`
+	Processes         types.HexBytes `json:"elections"`
+	ProcessesSiblings types.HexBytes `json:"electionsSiblings"`
+}
+
+// StateProof is the merkle proof of a leaf of a state tree, such as an account
+// or an election, up to the state root, which is the AppHash of the block
+// header at BlockHeight.  See state.LeafProof.
+type StateProof struct {
+	Tree         string         `json:"tree"`
+	Key          types.HexBytes `json:"key"`
+	Height       uint32         `json:"height"`
+	BlockHeight  uint32         `json:"blockHeight"`
+	Root         types.HexBytes `json:"root"`
+	Value        types.HexBytes `json:"value"`
+	Siblings     types.HexBytes `json:"siblings"`
+	TreeRoot     types.HexBytes `json:"treeRoot"`
+	TreeSiblings types.HexBytes `json:"treeSiblings"`
+}
+
+// LightBlock is the signed header and the validator set of a block, encoded
+// as a tendermint LightBlock protobuf message.
+type LightBlock struct {
+	Height     uint32         `json:"height"`
+	LightBlock types.HexBytes `json:"lightBlock"`
+}
+
 type CensusTypeDescription struct {
 	Type      string         `json:"type"`
 	URL       string         `json:"url,omitempty"`
@@ -127,6 +210,23 @@ type CensusParticipant struct {
 	Weight *types.BigInt  `json:"weight"`
 }
 
+// CensusUpdate is a list of changes to apply to an existing weighted census.
+// Participants are identified by their key, as when they were added.
+type CensusUpdate struct {
+	Add    []CensusParticipant `json:"add,omitempty"`
+	Update []CensusParticipant `json:"update,omitempty"`
+	Remove []types.HexBytes    `json:"remove,omitempty"`
+}
+
+// CensusDiff holds the differences between two censuses.  Keys are the census
+// tree keys, which are the hash of the participant keys.  The updated and
+// removed participants hold the new and the old weight respectively.
+type CensusDiff struct {
+	Added   []CensusParticipant `json:"added"`
+	Updated []CensusParticipant `json:"updated"`
+	Removed []CensusParticipant `json:"removed"`
+}
+
 type VoteType struct {
 	UniqueChoices     bool `json:"uniqueChoices"`
 	MaxVoteOverwrites int  `json:"maxVoteOverwrites"`
@@ -142,6 +242,9 @@ type ElectionType struct {
 	DynamicCensus     bool `json:"dynamicCensus"`
 	SecretUntilTheEnd bool `json:"secretUntilTheEnd"`
 	Anonymous         bool `json:"anonymous"`
+	// TimeBounded elections start and end at the exact StartDate and EndDate,
+	// checked against the block time, instead of at their estimated blocks.
+	TimeBounded bool `json:"timeBounded,omitempty"`
 }
 
 type Transaction struct {
@@ -174,6 +277,18 @@ type Account struct {
 	InfoURL       string           `json:"infoURL,omitempty"`
 	Token         *uuid.UUID       `json:"token,omitempty"`
 	Metadata      *AccountMetadata `json:"metadata,omitempty"`
+	Multisig      *AccountMultisig `json:"multisig,omitempty"`
+	// RotatedTo is the new address of the account, if it was rotated.
+	RotatedTo types.HexBytes `json:"rotatedTo,omitempty"`
+	// PreviousAddresses are the addresses the account was rotated from.
+	PreviousAddresses []types.HexBytes `json:"previousAddresses,omitempty"`
+}
+
+// AccountMultisig is the multisig policy of an account, which requires the
+// approval of threshold of the signers for its organization transactions.
+type AccountMultisig struct {
+	Threshold uint32           `json:"threshold"`
+	Signers   []types.HexBytes `json:"signers"`
 }
 
 type AccountSet struct {
@@ -193,6 +308,8 @@ type Census struct {
 	Size     uint64         `json:"size,omitempty"`
 	Valid    bool           `json:"valid,omitempty"`
 	URI      string         `json:"uri,omitempty"`
+	// Provenance is the origin of a census built from a token holders snapshot
+	Provenance *censusdb.CensusProvenance `json:"provenance,omitempty"`
 }
 
 type File struct {
@@ -230,6 +347,13 @@ func (e ElectionMode) MarshalJSON() ([]byte, error) {
 	return m.Marshal(&e)
 }
 
+// UnmarshalJSON decodes the protojson encoding of MarshalJSON, which encodes
+// the 64-bit timestamps as strings.
+func (e *ElectionMode) UnmarshalJSON(data []byte) error {
+	e.ProcessMode = &models.ProcessMode{}
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, e.ProcessMode)
+}
+
 type TallyMode struct {
 	*models.ProcessVoteOptions
 }
@@ -260,3 +384,14 @@ func CensusTypeToOrigin(ctype CensusTypeDescription) (models.CensusOrigin, []byt
 	}
 	return origin, root, nil
 }
+
+// Event is a vochain event sent by the events stream endpoints.
+type Event struct {
+	Type           string         `json:"type"`
+	Height         uint32         `json:"height"`
+	ElectionID     types.HexBytes `json:"electionId,omitempty"`
+	OrganizationID types.HexBytes `json:"organizationId,omitempty"`
+	VoteID         types.HexBytes `json:"voteId,omitempty"`
+	Status         string         `json:"status,omitempty"`
+	TxIndex        int32          `json:"txIndex,omitempty"`
+}
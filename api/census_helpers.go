@@ -3,7 +3,10 @@ package api
 import (
 	"encoding/hex"
 	"fmt"
+	"mime"
+	"strings"
 
+	"go.vocdoni.io/dvote/api/censusdb"
 	"go.vocdoni.io/dvote/util"
 	"go.vocdoni.io/proto/build/go/models"
 )
@@ -32,3 +35,38 @@ func censusKeyParse(key string) ([]byte, error) {
 	key = util.TrimHex(key)
 	return hex.DecodeString(key)
 }
+
+// censusFormatContentTypes maps the census export and import formats to
+// their content type.
+var censusFormatContentTypes = map[string]string{
+	censusdb.FormatDump:  "application/json",
+	censusdb.FormatCSV:   "text/csv",
+	censusdb.FormatJSONL: "application/jsonl",
+}
+
+// censusFormat returns the census export or import format.  If the format URL
+// parameter is empty, it is negotiated using the given Accept or Content-Type
+// header value.  The census dump format is used by default.
+func censusFormat(param, header string) (string, error) {
+	if param != "" {
+		if _, ok := censusFormatContentTypes[param]; !ok {
+			return "", fmt.Errorf("unsupported census format %q", param)
+		}
+		return param, nil
+	}
+	for _, value := range strings.Split(header, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "text/csv":
+			return censusdb.FormatCSV, nil
+		case "application/jsonl", "application/x-ndjson", "application/x-jsonlines":
+			return censusdb.FormatJSONL, nil
+		case "application/json":
+			return censusdb.FormatDump, nil
+		}
+	}
+	return censusdb.FormatDump, nil
+}
@@ -3,12 +3,17 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"math/big"
+	"net/http"
 	"net/url"
 	"path"
+	"strings"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	qt "github.com/frankban/quicktest"
 	"github.com/google/uuid"
 	"github.com/iden3/go-iden3-crypto/babyjub"
@@ -317,3 +322,423 @@ func TestCensusZk(t *testing.T) {
 	qt.Assert(t, json.Unmarshal(resp, censusData), qt.IsNil)
 	qt.Assert(t, censusData.Weight.String(), qt.Equals, "1")
 }
+
+func TestCensusUpdate(t *testing.T) {
+	router := httprouter.HTTProuter{}
+	router.Init("127.0.0.1", 0)
+	addr, err := url.Parse("http://" + path.Join(router.Address().String(), "censuses"))
+	qt.Assert(t, err, qt.IsNil)
+
+	api, err := NewAPI(&router, "/", t.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+	// Create local key value database
+	db, err := metadb.New(db.TypePebble, t.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+	censusDB := censusdb.NewCensusDB(db)
+
+	api.Attach(nil, nil, nil, nil, censusDB)
+	qt.Assert(t, api.EnableHandlers(CensusHandler), qt.IsNil)
+
+	token1 := uuid.New()
+	c := testutil.NewTestHTTPclient(t, addr, &token1)
+
+	// create a new census and add a bunch of keys and values (weights)
+	resp, code := c.Request("POST", nil, CensusTypeWeighted)
+	qt.Assert(t, code, qt.Equals, 200)
+	censusData := &Census{}
+	qt.Assert(t, json.Unmarshal(resp, censusData), qt.IsNil)
+	id1 := censusData.CensusID.String()
+
+	rnd := testutil.NewRandom(1)
+	cparts := CensusParticipants{}
+	for i := 1; i < 11; i++ {
+		cparts.Participants = append(cparts.Participants, CensusParticipant{
+			Key:    rnd.RandomBytes(32),
+			Weight: (*types.BigInt)(big.NewInt(int64(i))),
+		})
+	}
+	_, code = c.Request("POST", &cparts, id1, "participants")
+	qt.Assert(t, code, qt.Equals, 200)
+
+	resp, code = c.Request("POST", nil, id1, "publish")
+	qt.Assert(t, code, qt.Equals, 200)
+	qt.Assert(t, json.Unmarshal(resp, censusData), qt.IsNil)
+	root1 := censusData.CensusID.String()
+
+	// add two participants, update the weight of the first one and remove
+	// the second and third ones
+	p := cparts.Participants
+	added := []CensusParticipant{
+		{Key: rnd.RandomBytes(32), Weight: (*types.BigInt)(big.NewInt(20))},
+		{Key: rnd.RandomBytes(32), Weight: (*types.BigInt)(big.NewInt(30))},
+	}
+	update := &CensusUpdate{
+		Add:    added,
+		Update: []CensusParticipant{{Key: p[0].Key, Weight: (*types.BigInt)(big.NewInt(5))}},
+		Remove: []types.HexBytes{p[1].Key, p[2].Key},
+	}
+	resp, code = c.Request("POST", update, id1, "update")
+	qt.Assert(t, code, qt.Equals, 200, qt.Commentf("response: %s", resp))
+	qt.Assert(t, json.Unmarshal(resp, censusData), qt.IsNil)
+	qt.Assert(t, censusData.Size, qt.Equals, uint64(10))
+	// 55 + 20 + 30 + (5 - 1) - 2 - 3
+	qt.Assert(t, censusData.Weight.String(), qt.Equals, "104")
+
+	// the census must be the same as a census built from scratch
+	resp, code = c.Request("POST", nil, CensusTypeWeighted)
+	qt.Assert(t, code, qt.Equals, 200)
+	qt.Assert(t, json.Unmarshal(resp, censusData), qt.IsNil)
+	id2 := censusData.CensusID.String()
+	final := append([]CensusParticipant{update.Update[0]}, p[3:]...)
+	final = append(final, added...)
+	_, code = c.Request("POST", &CensusParticipants{Participants: final}, id2, "participants")
+	qt.Assert(t, code, qt.Equals, 200)
+	resp, code = c.Request("GET", nil, id2, "root")
+	qt.Assert(t, code, qt.Equals, 200)
+	qt.Assert(t, json.Unmarshal(resp, censusData), qt.IsNil)
+	expectedRoot := censusData.Root
+
+	resp, code = c.Request("POST", nil, id1, "publish")
+	qt.Assert(t, code, qt.Equals, 200)
+	qt.Assert(t, json.Unmarshal(resp, censusData), qt.IsNil)
+	qt.Assert(t, censusData.CensusID, qt.DeepEquals, expectedRoot)
+	root2 := censusData.CensusID.String()
+
+	// the removed participants must not be in the new census
+	_, code = c.Request("GET", nil, root2, "proof", p[1].Key.String())
+	qt.Assert(t, code, qt.Equals, 400)
+	_, code = c.Request("GET", nil, root1, "proof", p[1].Key.String())
+	qt.Assert(t, code, qt.Equals, 200)
+
+	// check the diff between both published roots
+	resp, code = c.Request("GET", nil, root1, "diff", root2)
+	qt.Assert(t, code, qt.Equals, 200, qt.Commentf("response: %s", resp))
+	diff := &CensusDiff{}
+	qt.Assert(t, json.Unmarshal(resp, diff), qt.IsNil)
+	diffKeys := func(list []CensusParticipant) map[string]string {
+		m := make(map[string]string)
+		for _, p := range list {
+			m[p.Key.String()] = p.Weight.String()
+		}
+		return m
+	}
+	hashKey := func(key []byte) string {
+		h, err := arbo.HashFunctionBlake2b.Hash(key)
+		qt.Assert(t, err, qt.IsNil)
+		return fmt.Sprintf("%x", h)
+	}
+	qt.Assert(t, diffKeys(diff.Added), qt.DeepEquals, map[string]string{
+		hashKey(added[0].Key): "20",
+		hashKey(added[1].Key): "30",
+	})
+	qt.Assert(t, diffKeys(diff.Updated), qt.DeepEquals, map[string]string{
+		hashKey(p[0].Key): "5",
+	})
+	qt.Assert(t, diffKeys(diff.Removed), qt.DeepEquals, map[string]string{
+		hashKey(p[1].Key): "2",
+		hashKey(p[2].Key): "3",
+	})
+
+	// invalid changes must not modify the census
+	_, code = c.Request("POST", &CensusUpdate{
+		Add:    []CensusParticipant{{Key: rnd.RandomBytes(32)}},
+		Remove: []types.HexBytes{p[1].Key},
+	}, id1, "update")
+	qt.Assert(t, code, qt.Equals, 400)
+	_, code = c.Request("POST", &CensusUpdate{
+		Add: []CensusParticipant{{Key: p[0].Key}},
+	}, id1, "update")
+	qt.Assert(t, code, qt.Equals, 400)
+	resp, code = c.Request("GET", nil, id1, "root")
+	qt.Assert(t, code, qt.Equals, 200)
+	qt.Assert(t, json.Unmarshal(resp, censusData), qt.IsNil)
+	qt.Assert(t, censusData.Root, qt.DeepEquals, expectedRoot)
+}
+
+func TestCensusExportImportEntries(t *testing.T) {
+	router := httprouter.HTTProuter{}
+	router.Init("127.0.0.1", 0)
+	addr, err := url.Parse("http://" + path.Join(router.Address().String(), "censuses"))
+	qt.Assert(t, err, qt.IsNil)
+
+	api, err := NewAPI(&router, "/", t.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+	// Create local key value database
+	db, err := metadb.New(db.TypePebble, t.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+	censusDB := censusdb.NewCensusDB(db)
+
+	api.Attach(nil, nil, nil, nil, censusDB)
+	qt.Assert(t, api.EnableHandlers(CensusHandler), qt.IsNil)
+
+	token1 := uuid.New()
+	c := testutil.NewTestHTTPclient(t, addr, &token1)
+
+	// rawRequest sends a non JSON request body with the given headers
+	rawRequest := func(method, body string, headers map[string]string,
+		urlPath ...string) ([]byte, int) {
+		u := addr.String() + "/" + path.Join(urlPath...)
+		req, err := http.NewRequest(method, u, strings.NewReader(body))
+		qt.Assert(t, err, qt.IsNil)
+		req.Header.Set("Authorization", "Bearer "+token1.String())
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		qt.Assert(t, err, qt.IsNil)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		qt.Assert(t, err, qt.IsNil)
+		return data, resp.StatusCode
+	}
+	newCensus := func(censusType string) string {
+		resp, code := c.Request("POST", nil, censusType)
+		qt.Assert(t, code, qt.Equals, 200)
+		censusData := &Census{}
+		qt.Assert(t, json.Unmarshal(resp, censusData), qt.IsNil)
+		return censusData.CensusID.String()
+	}
+	censusRoot := func(censusID string) types.HexBytes {
+		resp, code := c.Request("GET", nil, censusID, "root")
+		qt.Assert(t, code, qt.Equals, 200)
+		censusData := &Census{}
+		qt.Assert(t, json.Unmarshal(resp, censusData), qt.IsNil)
+		return censusData.Root
+	}
+
+	// create a weighted census with a bunch of participants
+	rnd := testutil.NewRandom(1)
+	id1 := newCensus(CensusTypeWeighted)
+	cparts := CensusParticipants{}
+	for i := 1; i < 11; i++ {
+		cparts.Participants = append(cparts.Participants, CensusParticipant{
+			Key:    rnd.RandomBytes(20),
+			Weight: (*types.BigInt)(big.NewInt(int64(i))),
+		})
+	}
+	_, code := c.Request("POST", &cparts, id1, "participants")
+	qt.Assert(t, code, qt.Equals, 200)
+
+	// export it as CSV, using the Accept header
+	csvData, code := rawRequest("GET", "", map[string]string{"Accept": "text/csv"}, id1, "export")
+	qt.Assert(t, code, qt.Equals, 200, qt.Commentf("response: %s", csvData))
+	lines := strings.Split(strings.TrimSpace(string(csvData)), "\n")
+	qt.Assert(t, lines, qt.HasLen, 11)
+	qt.Assert(t, lines[0], qt.Equals, "keyHash,weight,index")
+
+	// export it as JSON Lines, using the format parameter
+	jsonlData, code := rawRequest("GET", "", nil, id1, "export", "jsonl")
+	qt.Assert(t, code, qt.Equals, 200, qt.Commentf("response: %s", jsonlData))
+	qt.Assert(t, strings.Split(strings.TrimSpace(string(jsonlData)), "\n"), qt.HasLen, 10)
+
+	// import both exports into new censuses, which must have the same root
+	for _, imp := range []struct{ data, format string }{
+		{string(csvData), "csv"},
+		{string(jsonlData), "jsonl"},
+	} {
+		id := newCensus(CensusTypeWeighted)
+		resp, code := rawRequest("POST", imp.data, nil, id, "import", imp.format)
+		qt.Assert(t, code, qt.Equals, 200, qt.Commentf("response: %s", resp))
+		result := &censusdb.ImportResult{}
+		qt.Assert(t, json.Unmarshal(resp, result), qt.IsNil)
+		qt.Assert(t, result.Imported, qt.Equals, uint64(10))
+		qt.Assert(t, result.Errors, qt.HasLen, 0)
+		qt.Assert(t, censusRoot(id), qt.DeepEquals, censusRoot(id1))
+	}
+
+	// import a spreadsheet with the participant keys and some invalid lines
+	p := cparts.Participants
+	spreadsheet := "key, weight\n" +
+		fmt.Sprintf("%x,%s\n", p[0].Key, p[0].Weight) +
+		"0xzz,1\n" +
+		"a\"b,1\n" + // malformed CSV line
+		fmt.Sprintf("%x,-1\n", p[1].Key) +
+		fmt.Sprintf("%x,%s,5\n", p[1].Key, p[1].Weight) +
+		fmt.Sprintf("0x%x,%s\n", p[2].Key, p[2].Weight) +
+		fmt.Sprintf("%x,%s\n", p[0].Key, p[0].Weight)
+	id2 := newCensus(CensusTypeWeighted)
+	resp, code := rawRequest("POST", spreadsheet,
+		map[string]string{"Content-Type": "text/csv"}, id2, "import")
+	qt.Assert(t, code, qt.Equals, 200, qt.Commentf("response: %s", resp))
+	result := &censusdb.ImportResult{}
+	qt.Assert(t, json.Unmarshal(resp, result), qt.IsNil)
+	qt.Assert(t, result.Imported, qt.Equals, uint64(2))
+	qt.Assert(t, result.Failed, qt.Equals, uint64(5))
+	errLines := []int{}
+	for _, e := range result.Errors {
+		errLines = append(errLines, e.Line)
+	}
+	qt.Assert(t, errLines, qt.DeepEquals, []int{3, 4, 5, 6, 8})
+
+	id3 := newCensus(CensusTypeWeighted)
+	_, code = c.Request("POST", &CensusParticipants{Participants: []CensusParticipant{p[0], p[2]}},
+		id3, "participants")
+	qt.Assert(t, code, qt.Equals, 200)
+	qt.Assert(t, censusRoot(id2), qt.DeepEquals, censusRoot(id3))
+
+	// a CSV file without key column cannot be imported
+	_, code = rawRequest("POST", "weight\n1\n", nil, id2, "import", "csv")
+	qt.Assert(t, code, qt.Equals, 400)
+
+	// the census dump remains the default format
+	resp, code = c.Request("GET", nil, id1, "export")
+	qt.Assert(t, code, qt.Equals, 200)
+	censusDump := &censusdb.CensusDump{}
+	qt.Assert(t, json.Unmarshal(resp, censusDump), qt.IsNil)
+	id4 := newCensus(CensusTypeWeighted)
+	resp, code = c.Request("POST", censusDump, id4, "import")
+	qt.Assert(t, code, qt.Equals, 200, qt.Commentf("response: %s", resp))
+
+	// indexed censuses are exported with their index
+	id5 := newCensus(CensusTypeZK)
+	_, code = c.Request("POST", &CensusParticipants{Participants: []CensusParticipant{
+		{Key: p[0].Key}, {Key: p[1].Key},
+	}}, id5, "participants")
+	qt.Assert(t, code, qt.Equals, 200)
+	csvData, code = rawRequest("GET", "", nil, id5, "export", "csv")
+	qt.Assert(t, code, qt.Equals, 200, qt.Commentf("response: %s", csvData))
+	qt.Assert(t, string(csvData), qt.Contains, ",1,0\n")
+	qt.Assert(t, string(csvData), qt.Contains, ",1,1\n")
+}
+
+func TestCensusERC20(t *testing.T) {
+	router := httprouter.HTTProuter{}
+	router.Init("127.0.0.1", 0)
+	addr, err := url.Parse("http://" + path.Join(router.Address().String(), "censuses"))
+	qt.Assert(t, err, qt.IsNil)
+
+	api, err := NewAPI(&router, "/", t.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+	// Create local key value database
+	db, err := metadb.New(db.TypePebble, t.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+	censusDB := censusdb.NewCensusDB(db)
+
+	storage := data.MockIPFS(t)
+	api.Attach(nil, nil, nil, storage, censusDB)
+	qt.Assert(t, api.EnableHandlers(CensusHandler), qt.IsNil)
+
+	token := uuid.New()
+	c := testutil.NewTestHTTPclient(t, addr, &token)
+
+	// mint some tokens to three holders and make some transfers, the last
+	// one after the snapshot block
+	rnd := testutil.NewRandom(1)
+	contract := common.BytesToAddress(rnd.RandomBytes(20))
+	holders := []common.Address{}
+	for i := 0; i < 3; i++ {
+		holders = append(holders, common.BytesToAddress(rnd.RandomBytes(20)))
+	}
+	transferTopic := crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+	logs := []*ethtypes.Log{}
+	transfer := func(block uint64, from, to common.Address, value int64) {
+		logs = append(logs, &ethtypes.Log{
+			Address: contract,
+			Topics: []common.Hash{transferTopic,
+				common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())},
+			Data:        common.BigToHash(big.NewInt(value)).Bytes(),
+			BlockNumber: block,
+			TxHash:      common.BytesToHash(rnd.RandomBytes(32)),
+			Index:       uint(len(logs)),
+		})
+	}
+	transfer(10, common.Address{}, holders[0], 100)
+	transfer(11, common.Address{}, holders[1], 50)
+	transfer(12, holders[0], holders[2], 30)
+	transfer(13, holders[1], common.Address{}, 50)
+	transfer(20, holders[2], holders[1], 30)
+	transfers, err := json.Marshal(logs)
+	qt.Assert(t, err, qt.IsNil)
+
+	resp, code := c.Request("POST", json.RawMessage(transfers),
+		"erc20", contract.Hex(), "15", censusdb.ERC20FormatTransfers)
+	qt.Assert(t, code, qt.Equals, 200, qt.Commentf("response: %s", resp))
+	census := &Census{}
+	qt.Assert(t, json.Unmarshal(resp, census), qt.IsNil)
+	qt.Assert(t, census.URI, qt.Not(qt.Equals), "")
+	qt.Assert(t, census.Provenance, qt.DeepEquals, &censusdb.CensusProvenance{
+		Contract:    contract,
+		Block:       15,
+		Format:      censusdb.ERC20FormatTransfers,
+		InputHash:   crypto.Keccak256(transfers),
+		Holders:     2,
+		TotalWeight: new(types.BigInt).SetUint64(100),
+	})
+
+	// the provenance is recorded with the census
+	resp, code = c.Request("GET", nil, census.CensusID.String(), "provenance")
+	qt.Assert(t, code, qt.Equals, 200, qt.Commentf("response: %s", resp))
+	provenance := &Census{}
+	qt.Assert(t, json.Unmarshal(resp, provenance), qt.IsNil)
+	qt.Assert(t, provenance.Provenance, qt.DeepEquals, census.Provenance)
+
+	// the holders can prove their balance with their address
+	resp, code = c.Request("GET", nil, census.CensusID.String(), "proof", holders[2].Hex())
+	qt.Assert(t, code, qt.Equals, 200, qt.Commentf("response: %s", resp))
+	proof := &Census{}
+	qt.Assert(t, json.Unmarshal(resp, proof), qt.IsNil)
+	qt.Assert(t, proof.Weight.String(), qt.Equals, "30")
+	electionID := rnd.RandomBytes(32)
+	valid, weight, err := transaction.VerifyProof(
+		&models.Process{
+			ProcessId:    electionID,
+			CensusRoot:   census.CensusID,
+			CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE_WEIGHTED,
+		},
+		&models.Proof{
+			Payload: &models.Proof_Arbo{
+				Arbo: &models.ProofArbo{
+					Type:     models.ProofArbo_BLAKE2B,
+					Siblings: proof.Proof,
+					Value:    proof.Value,
+					KeyType:  models.ProofArbo_ADDRESS,
+				},
+			},
+		},
+		models.CensusOrigin_OFF_CHAIN_TREE_WEIGHTED,
+		census.CensusID,
+		electionID,
+		nil,
+		holders[2],
+	)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, valid, qt.IsTrue)
+	qt.Assert(t, weight.Uint64(), qt.Equals, uint64(30))
+	_, code = c.Request("GET", nil, census.CensusID.String(), "proof", holders[1].Hex())
+	qt.Assert(t, code, qt.Not(qt.Equals), 200)
+
+	// the same snapshot can be requested again
+	resp, code = c.Request("POST", json.RawMessage(transfers),
+		"erc20", contract.Hex(), "15", censusdb.ERC20FormatTransfers)
+	qt.Assert(t, code, qt.Equals, 200, qt.Commentf("response: %s", resp))
+	census2 := &Census{}
+	qt.Assert(t, json.Unmarshal(resp, census2), qt.IsNil)
+	qt.Assert(t, census2.CensusID, qt.DeepEquals, census.CensusID)
+
+	// the same balances build the same census, but its provenance is kept
+	balances := map[string]string{
+		holders[0].Hex(): "70",
+		holders[2].Hex(): "0x1e",
+	}
+	resp, code = c.Request("POST", balances,
+		"erc20", contract.Hex(), "15", censusdb.ERC20FormatBalances)
+	qt.Assert(t, code, qt.Equals, 400)
+	qt.Assert(t, string(resp), qt.Contains, censusdb.ErrProvenanceExists.Error())
+	resp, code = c.Request("GET", nil, census.CensusID.String(), "provenance")
+	qt.Assert(t, code, qt.Equals, 200, qt.Commentf("response: %s", resp))
+	qt.Assert(t, json.Unmarshal(resp, provenance), qt.IsNil)
+	qt.Assert(t, provenance.Provenance.Format, qt.Equals, censusdb.ERC20FormatTransfers)
+
+	// the auth token is required
+	resp, code = testutil.NewTestHTTPclient(t, addr, nil).Request("POST", balances,
+		"erc20", contract.Hex(), "15", censusdb.ERC20FormatBalances)
+	qt.Assert(t, code, qt.Equals, 400, qt.Commentf("response: %s", resp))
+
+	// incomplete transfer logs are rejected
+	transfers, err = json.Marshal(logs[2:])
+	qt.Assert(t, err, qt.IsNil)
+	resp, code = c.Request("POST", json.RawMessage(transfers),
+		"erc20", contract.Hex(), "15", censusdb.ERC20FormatTransfers)
+	qt.Assert(t, code, qt.Equals, 400)
+	qt.Assert(t, string(resp), qt.Contains, "transfer logs are incomplete")
+}
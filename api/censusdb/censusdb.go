@@ -6,8 +6,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"sync"
 
 	"github.com/google/uuid"
+	"github.com/vocdoni/arbo"
 	"go.vocdoni.io/dvote/censustree"
 	storagelayer "go.vocdoni.io/dvote/data"
 	"go.vocdoni.io/dvote/data/compressor"
@@ -61,10 +64,40 @@ type CensusDump struct {
 	Indexed  bool               `json:"indexed"`
 }
 
+// CensusLeaf is a key/value pair of a census tree.  The key is the hashed
+// participant key and the value its encoded weight.
+type CensusLeaf struct {
+	Key   []byte
+	Value []byte
+}
+
+// newCensusLeaf returns a CensusLeaf holding a copy of the key and value.
+func newCensusLeaf(key, value []byte) *CensusLeaf {
+	return &CensusLeaf{Key: append([]byte{}, key...), Value: append([]byte{}, value...)}
+}
+
+// CensusChanges is a list of changes to apply to an existing census.
+type CensusChanges struct {
+	Add    []*CensusLeaf
+	Update []*CensusLeaf
+	Remove [][]byte
+}
+
+// CensusDiff holds the leaves which differ between two censuses.  The updated
+// and removed leaves hold the new and the old values respectively.
+type CensusDiff struct {
+	Added   []*CensusLeaf
+	Updated []*CensusLeaf
+	Removed []*CensusLeaf
+}
+
 // CensusDB is a safe and persistent database of census trees.  It allows
 // authentication control over the census if a UUID token is provided.
 type CensusDB struct {
 	db db.Database
+	// provenanceLock serializes the provenance records, so they cannot be
+	// overwritten by concurrent requests
+	provenanceLock sync.Mutex
 }
 
 // NewCensusDB creates a new CensusDB object.
@@ -136,6 +169,9 @@ func (c *CensusDB) Del(censusID []byte) error {
 	if err := wtx.Delete(append([]byte(censusDBreferencePrefix), censusID...)); err != nil {
 		return err
 	}
+	if err := wtx.Delete(append([]byte(censusDBprovenancePrefix), censusID...)); err != nil {
+		return err
+	}
 	// the removal of the tree from the disk is done in a separate goroutine.
 	// This is because the tree is locked and we don't want to block the operations,
 	// and depending on the size of the tree, it can take a while to delete it.
@@ -148,6 +184,117 @@ func (c *CensusDB) Del(censusID []byte) error {
 	return wtx.Commit()
 }
 
+// Update applies a list of changes to an existing weighted census.  The changes
+// are validated before applying them, so the census is not modified if a key to
+// add already exists, if a key to update or remove does not exist, or if a key
+// is found more than once.  The changes are applied in place on the census
+// merkle tree within a single database transaction.
+func (c *CensusDB) Update(censusID []byte, authToken *uuid.UUID,
+	changes *CensusChanges) (*CensusRef, error) {
+	ref, err := c.Load(censusID, authToken)
+	if err != nil {
+		return nil, err
+	}
+	if ref.Indexed {
+		return nil, fmt.Errorf("indexed census cannot be updated")
+	}
+
+	// validate the changes
+	seen := make(map[string]bool)
+	checkKey := func(key []byte, exists bool) error {
+		if seen[string(key)] {
+			return fmt.Errorf("key %x found more than once", key)
+		}
+		seen[string(key)] = true
+		_, err := ref.Tree().Get(key)
+		if exists && err != nil {
+			return fmt.Errorf("key %x not found in census: %w", key, err)
+		}
+		if !exists {
+			if err == nil {
+				return fmt.Errorf("key %x already exists in census", key)
+			}
+			if !errors.Is(err, arbo.ErrKeyNotFound) {
+				return err
+			}
+		}
+		return nil
+	}
+	treeChanges := &censustree.Changes{}
+	for _, leaf := range changes.Add {
+		if err := checkKey(leaf.Key, false); err != nil {
+			return nil, err
+		}
+		treeChanges.AddKeys = append(treeChanges.AddKeys, leaf.Key)
+		treeChanges.AddValues = append(treeChanges.AddValues, leaf.Value)
+	}
+	for _, leaf := range changes.Update {
+		if err := checkKey(leaf.Key, true); err != nil {
+			return nil, err
+		}
+		treeChanges.UpdateKeys = append(treeChanges.UpdateKeys, leaf.Key)
+		treeChanges.UpdateValues = append(treeChanges.UpdateValues, leaf.Value)
+	}
+	for _, key := range changes.Remove {
+		if err := checkKey(key, true); err != nil {
+			return nil, err
+		}
+		treeChanges.RemoveKeys = append(treeChanges.RemoveKeys, key)
+	}
+
+	if err := ref.Tree().ApplyChanges(treeChanges); err != nil {
+		return nil, fmt.Errorf("cannot update census: %w", err)
+	}
+	log.Infof("updated census %x: %d added, %d updated, %d removed",
+		censusID, len(changes.Add), len(changes.Update), len(changes.Remove))
+	return ref, nil
+}
+
+// Diff returns the leaves added, updated and removed from the census fromID to
+// the census toID, sorted by key.
+func (c *CensusDB) Diff(fromID, toID []byte) (*CensusDiff, error) {
+	from, err := c.Load(fromID, nil)
+	if err != nil {
+		return nil, err
+	}
+	to, err := c.Load(toID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if from.CensusType != to.CensusType || from.Indexed != to.Indexed {
+		return nil, fmt.Errorf("census types do not match")
+	}
+	leaves := make(map[string][]byte)
+	if err := from.Tree().IterateLeaves(func(key, value []byte) bool {
+		leaves[string(key)] = append([]byte{}, value...)
+		return false
+	}); err != nil {
+		return nil, err
+	}
+	diff := &CensusDiff{}
+	if err := to.Tree().IterateLeaves(func(key, value []byte) bool {
+		oldValue, ok := leaves[string(key)]
+		if !ok {
+			diff.Added = append(diff.Added, newCensusLeaf(key, value))
+			return false
+		}
+		if !bytes.Equal(oldValue, value) {
+			diff.Updated = append(diff.Updated, newCensusLeaf(key, value))
+		}
+		delete(leaves, string(key))
+		return false
+	}); err != nil {
+		return nil, err
+	}
+	for key, value := range leaves {
+		diff.Removed = append(diff.Removed, &CensusLeaf{Key: []byte(key), Value: value})
+	}
+	for _, list := range [][]*CensusLeaf{diff.Added, diff.Updated, diff.Removed} {
+		sort.Slice(list, func(i, j int) bool { return bytes.Compare(list[i].Key, list[j].Key) < 0 })
+	}
+	return diff, nil
+}
+
 // BuildExportDump builds a census serialization that can be used for import.
 func BuildExportDump(root, data []byte, typ models.Census_Type, isIndexed bool) ([]byte, error) {
 	export := CensusDump{
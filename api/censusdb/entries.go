@@ -0,0 +1,385 @@
+package censusdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+)
+
+const (
+	// FormatDump is the census dump format, as built by BuildExportDump.
+	FormatDump = "dump"
+	// FormatCSV is the comma separated values format, with a header line.
+	FormatCSV = "csv"
+	// FormatJSONL is the JSON Lines format, one JSON encoded CensusEntry per line.
+	FormatJSONL = "jsonl"
+
+	// importBatchSize is the number of imported entries added to the census
+	// tree on each batch.
+	importBatchSize = 8192
+	// maxImportErrors is the maximum number of line errors reported by an import.
+	maxImportErrors = 100
+	// maxJSONLineSize is the maximum size of a JSON Lines line.
+	maxJSONLineSize = 1 << 16
+)
+
+// CSV column names
+const (
+	csvColumnKey     = "key"
+	csvColumnKeyHash = "keyHash"
+	csvColumnWeight  = "weight"
+	csvColumnIndex   = "index"
+)
+
+// CensusEntry is a census participant, as exported and imported using the CSV
+// and JSON Lines formats.  Exported entries hold the census tree key hash, since
+// the participant keys are not stored in the census.  Imported entries can hold
+// either the participant key, which is hashed as when adding participants, or
+// the key hash (i.e from an export).  The weight of indexed censuses is always
+// 1 and the index is only set for indexed censuses.
+type CensusEntry struct {
+	Key     types.HexBytes `json:"key,omitempty"`
+	KeyHash types.HexBytes `json:"keyHash,omitempty"`
+	Weight  *types.BigInt  `json:"weight,omitempty"`
+	Index   *uint64        `json:"index,omitempty"`
+}
+
+// ImportError is the error found on a line of an imported census file.
+type ImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ImportResult is the result of importing census entries.  The entries with
+// errors are skipped, and up to maxImportErrors of them are reported.
+type ImportResult struct {
+	Imported uint64         `json:"imported"`
+	Failed   uint64         `json:"failed"`
+	Errors   []*ImportError `json:"errors,omitempty"`
+}
+
+// ExportEntries writes the entries of a census to w using the CSV or the JSON
+// Lines format.
+func (c *CensusDB) ExportEntries(censusID []byte, authToken *uuid.UUID,
+	format string, w io.Writer) error {
+	ref, err := c.Load(censusID, authToken)
+	if err != nil {
+		return err
+	}
+	var write func(*CensusEntry) error
+	var csvWriter *csv.Writer
+	switch format {
+	case FormatCSV:
+		csvWriter = csv.NewWriter(w)
+		header := []string{csvColumnKeyHash, csvColumnWeight, csvColumnIndex}
+		if err := csvWriter.Write(header); err != nil {
+			return err
+		}
+		write = func(e *CensusEntry) error {
+			index := ""
+			if e.Index != nil {
+				index = fmt.Sprintf("%d", *e.Index)
+			}
+			return csvWriter.Write([]string{e.KeyHash.String(), e.Weight.String(), index})
+		}
+	case FormatJSONL:
+		enc := json.NewEncoder(w)
+		write = func(e *CensusEntry) error {
+			return enc.Encode(e)
+		}
+	default:
+		return fmt.Errorf("unsupported census export format %q", format)
+	}
+
+	var writeErr error
+	if err := ref.Tree().IterateLeaves(func(key, value []byte) bool {
+		entry := &CensusEntry{
+			KeyHash: key,
+			Weight:  (*types.BigInt)(ref.Tree().BytesToBigInt(value)),
+		}
+		if ref.Indexed {
+			// the tree key is the index and the value is the key hash
+			index := binary.LittleEndian.Uint64(key)
+			entry = &CensusEntry{
+				KeyHash: value,
+				Weight:  new(types.BigInt).SetUint64(1),
+				Index:   &index,
+			}
+		}
+		writeErr = write(entry)
+		return writeErr != nil
+	}); err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	if csvWriter != nil {
+		csvWriter.Flush()
+		return csvWriter.Error()
+	}
+	return nil
+}
+
+// ImportEntries adds the entries read from r, using the CSV or the JSON Lines
+// format, to a census.  The entries are added in batches while they are read,
+// so the whole input is never loaded in memory.  The entries that cannot be
+// validated or added are skipped and reported, with their line number, in the
+// returned result.  An error is only returned if the input cannot be read.
+//
+// The CSV input must start with a header line naming the columns: either key
+// or keyHash, and optionally weight and index.
+func (c *CensusDB) ImportEntries(censusID []byte, authToken *uuid.UUID,
+	format string, r io.Reader) (*ImportResult, error) {
+	ref, err := c.Load(censusID, authToken)
+	if err != nil {
+		return nil, err
+	}
+	index, err := ref.Tree().GetCensusIndex()
+	if err != nil {
+		return nil, err
+	}
+	imp := &entriesImporter{
+		ref:       ref,
+		result:    &ImportResult{},
+		nextIndex: uint64(index),
+		// the weight is encoded using the same length as the hash
+		maxWeightBits: 8 * len(ref.Tree().BigIntToBytes(big.NewInt(0))),
+	}
+	switch format {
+	case FormatCSV:
+		err = readCSVEntries(r, imp.add)
+	case FormatJSONL:
+		err = readJSONLEntries(r, imp.add)
+	default:
+		err = fmt.Errorf("unsupported census import format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := imp.flush(); err != nil {
+		return nil, err
+	}
+	return imp.result, nil
+}
+
+// entriesImporter validates census entries and adds them to the census tree
+// in batches.
+type entriesImporter struct {
+	ref           *CensusRef
+	result        *ImportResult
+	nextIndex     uint64
+	maxWeightBits int
+
+	keys   [][]byte
+	values [][]byte
+	lines  []int
+}
+
+func (imp *entriesImporter) fail(line int, err error) {
+	imp.result.Failed++
+	if len(imp.result.Errors) < maxImportErrors {
+		imp.result.Errors = append(imp.result.Errors,
+			&ImportError{Line: line, Error: err.Error()})
+	}
+}
+
+// add validates and queues an entry, or records the error found while parsing it.
+func (imp *entriesImporter) add(line int, entry *CensusEntry, err error) error {
+	if err == nil {
+		err = imp.queue(line, entry)
+	}
+	if err != nil {
+		imp.fail(line, err)
+		return nil
+	}
+	if len(imp.keys) >= importBatchSize {
+		return imp.flush()
+	}
+	return nil
+}
+
+func (imp *entriesImporter) queue(line int, entry *CensusEntry) error {
+	key := entry.KeyHash
+	switch {
+	case entry.Key != nil && entry.KeyHash != nil:
+		return fmt.Errorf("key and keyHash cannot be both set")
+	case entry.Key != nil:
+		var err error
+		if key, err = imp.ref.Tree().Hash(entry.Key); err != nil {
+			return fmt.Errorf("could not compute key hash: %w", err)
+		}
+	case entry.KeyHash == nil:
+		return fmt.Errorf("missing key")
+	}
+	weight := big.NewInt(1)
+	if entry.Weight != nil {
+		weight = entry.Weight.ToInt()
+	}
+	if weight.Sign() < 0 || weight.BitLen() > imp.maxWeightBits {
+		return fmt.Errorf("invalid weight %s", weight)
+	}
+
+	if imp.ref.Indexed {
+		if weight.Cmp(big.NewInt(1)) != 0 {
+			return fmt.Errorf("indexed census cannot use weight")
+		}
+		if entry.Index != nil && *entry.Index != imp.nextIndex {
+			return fmt.Errorf("expected index %d, got %d", imp.nextIndex, *entry.Index)
+		}
+		imp.nextIndex++
+	} else {
+		if entry.Index != nil {
+			return fmt.Errorf("index can only be set on indexed censuses")
+		}
+		imp.values = append(imp.values, imp.ref.Tree().BigIntToBytes(weight))
+	}
+	imp.keys = append(imp.keys, key)
+	imp.lines = append(imp.lines, line)
+	return nil
+}
+
+// flush adds the queued entries to the census tree.
+func (imp *entriesImporter) flush() error {
+	if len(imp.keys) == 0 {
+		return nil
+	}
+	var values [][]byte
+	if !imp.ref.Indexed {
+		values = imp.values
+	}
+	failed, err := imp.ref.Tree().AddBatch(imp.keys, values)
+	if err != nil {
+		return fmt.Errorf("cannot add entries to census: %w", err)
+	}
+	for _, i := range failed {
+		imp.fail(imp.lines[i], fmt.Errorf("cannot add key %x to census", imp.keys[i]))
+	}
+	imp.result.Imported += uint64(len(imp.keys) - len(failed))
+	if len(failed) > 0 && imp.ref.Indexed {
+		index, err := imp.ref.Tree().GetCensusIndex()
+		if err != nil {
+			return err
+		}
+		imp.nextIndex = uint64(index)
+	}
+	imp.keys, imp.values, imp.lines = nil, nil, nil
+	return nil
+}
+
+// readCSVEntries reads the census entries of a CSV input, calling add for each
+// one of them with its line number and parsing error, if any.
+func readCSVEntries(r io.Reader, add func(int, *CensusEntry, error) error) error {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("cannot read CSV header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	_, hasKey := columns[csvColumnKey]
+	_, hasKeyHash := columns[csvColumnKeyHash]
+	if hasKey == hasKeyHash {
+		return fmt.Errorf("CSV header must have either a %q or a %q column",
+			csvColumnKey, csvColumnKeyHash)
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			if err := add(parseErr.Line, nil, parseErr.Err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := reader.FieldPos(0)
+		entry, err := parseCSVEntry(field(record, csvColumnKey), field(record, csvColumnKeyHash),
+			field(record, csvColumnWeight), field(record, csvColumnIndex))
+		if err := add(line, entry, err); err != nil {
+			return err
+		}
+	}
+}
+
+func parseCSVEntry(key, keyHash, weight, index string) (*CensusEntry, error) {
+	entry := &CensusEntry{}
+	var err error
+	if key != "" {
+		if entry.Key, err = hex.DecodeString(util.TrimHex(key)); err != nil {
+			return nil, fmt.Errorf("cannot decode key: %w", err)
+		}
+	}
+	if keyHash != "" {
+		if entry.KeyHash, err = hex.DecodeString(util.TrimHex(keyHash)); err != nil {
+			return nil, fmt.Errorf("cannot decode key hash: %w", err)
+		}
+	}
+	if weight != "" {
+		if entry.Weight, err = new(types.BigInt).SetString(weight); err != nil {
+			return nil, fmt.Errorf("cannot decode weight: %w", err)
+		}
+	}
+	if index != "" {
+		i, err := strconv.ParseUint(index, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode index: %w", err)
+		}
+		entry.Index = &i
+	}
+	return entry, nil
+}
+
+// readJSONLEntries reads the census entries of a JSON Lines input, calling add
+// for each one of them with its line number and parsing error, if any.  Empty
+// lines are ignored.
+func readJSONLEntries(r io.Reader, add func(int, *CensusEntry, error) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), maxJSONLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := scanner.Bytes()
+		if len(strings.TrimSpace(string(data))) == 0 {
+			continue
+		}
+		entry := &CensusEntry{}
+		err := json.Unmarshal(data, entry)
+		if err != nil {
+			entry = nil
+		}
+		if err := add(line, entry, err); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("cannot read line %d: %w", line+1, err)
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package censusdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+const (
+	// ERC20FormatTransfers is a JSON array of the token Transfer event logs,
+	// as returned by the eth_getLogs method of the Ethereum JSON-RPC API.
+	ERC20FormatTransfers = "transfers"
+	// ERC20FormatBalances is a JSON object mapping each holder address to its
+	// balance, as a decimal or 0x prefixed hexadecimal string.
+	ERC20FormatBalances = "balances"
+
+	censusDBprovenancePrefix = "cp_"
+)
+
+// ErrProvenanceNotFound is returned when a census has no provenance record.
+var ErrProvenanceNotFound = fmt.Errorf("census provenance not found")
+
+// ErrProvenanceExists is returned when a census already has a different
+// provenance record.
+var ErrProvenanceExists = fmt.Errorf("census provenance already recorded")
+
+// erc20TransferTopic is the topic of the ERC20 Transfer(address,address,uint256) event.
+var erc20TransferTopic = ethcrypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+// CensusProvenance records the origin of a census built from a snapshot of the
+// holders of an ERC20 token, so anyone can rebuild the census and check it.
+type CensusProvenance struct {
+	Contract common.Address `json:"contract"`
+	Block    uint64         `json:"block"`
+	Format   string         `json:"format"`
+	// InputHash is the keccak256 hash of the snapshot input
+	InputHash   types.HexBytes `json:"inputHash"`
+	Holders     uint64         `json:"holders"`
+	TotalWeight *types.BigInt  `json:"totalWeight"`
+}
+
+// ERC20Holders returns the balance of each holder of the token contract at
+// the block, computed from the snapshot input of the given format.  The
+// holders without balance are not included.
+func ERC20Holders(contract common.Address, block uint64, format string,
+	input []byte) (map[common.Address]*big.Int, error) {
+	var balances map[common.Address]*big.Int
+	var err error
+	switch format {
+	case ERC20FormatTransfers:
+		balances, err = erc20TransfersBalances(contract, block, input)
+	case ERC20FormatBalances:
+		balances, err = erc20Balances(input)
+	default:
+		err = fmt.Errorf("unsupported ERC20 snapshot format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for addr, balance := range balances {
+		if balance.Sign() == 0 || addr == (common.Address{}) {
+			delete(balances, addr)
+		}
+	}
+	return balances, nil
+}
+
+// erc20TransfersBalances replays the Transfer event logs of the contract up
+// to the block.  Tokens transferred from the zero address are minted.
+func erc20TransfersBalances(contract common.Address, block uint64,
+	input []byte) (map[common.Address]*big.Int, error) {
+	logs := []*ethtypes.Log{}
+	if err := json.Unmarshal(input, &logs); err != nil {
+		return nil, fmt.Errorf("cannot parse transfer logs: %w", err)
+	}
+	// replay the transfers in order, the dump might not be sorted
+	sort.SliceStable(logs, func(i, j int) bool {
+		if logs[i].BlockNumber != logs[j].BlockNumber {
+			return logs[i].BlockNumber < logs[j].BlockNumber
+		}
+		return logs[i].Index < logs[j].Index
+	})
+	balances := make(map[common.Address]*big.Int)
+	balance := func(addr common.Address) *big.Int {
+		if balances[addr] == nil {
+			balances[addr] = new(big.Int)
+		}
+		return balances[addr]
+	}
+	for _, l := range logs {
+		if l.Removed || l.Address != contract || l.BlockNumber > block ||
+			len(l.Topics) != 3 || l.Topics[0] != erc20TransferTopic {
+			continue
+		}
+		if len(l.Data) != common.HashLength {
+			return nil, fmt.Errorf("invalid transfer value on tx %s", l.TxHash)
+		}
+		from := common.BytesToAddress(l.Topics[1].Bytes())
+		to := common.BytesToAddress(l.Topics[2].Bytes())
+		value := new(big.Int).SetBytes(l.Data)
+		if from != (common.Address{}) {
+			if balance(from).Sub(balance(from), value).Sign() < 0 {
+				return nil, fmt.Errorf("negative balance of %s on tx %s, transfer logs are incomplete",
+					from, l.TxHash)
+			}
+		}
+		balance(to).Add(balance(to), value)
+	}
+	return balances, nil
+}
+
+// erc20Balances parses the balances of the holders.
+func erc20Balances(input []byte) (map[common.Address]*big.Int, error) {
+	holders := make(map[string]*types.BigInt)
+	if err := json.Unmarshal(input, &holders); err != nil {
+		return nil, fmt.Errorf("cannot parse balances: %w", err)
+	}
+	balances := make(map[common.Address]*big.Int, len(holders))
+	for addr, balance := range holders {
+		if !common.IsHexAddress(addr) {
+			return nil, fmt.Errorf("invalid holder address %q", addr)
+		}
+		if balance == nil || balance.ToInt().Sign() < 0 {
+			return nil, fmt.Errorf("invalid balance of holder %s", addr)
+		}
+		if _, ok := balances[common.HexToAddress(addr)]; ok {
+			return nil, fmt.Errorf("holder %s found more than once", addr)
+		}
+		balances[common.HexToAddress(addr)] = balance.ToInt()
+	}
+	return balances, nil
+}
+
+// NewERC20Census creates a new weighted census with the holders of the token
+// contract at the block, computed from the snapshot input of the given format.
+// The census keys are the holder addresses and the weights their balances.
+// The returned provenance record can be stored with SetProvenance once the
+// census is published.
+func (c *CensusDB) NewERC20Census(censusID []byte, authToken *uuid.UUID,
+	contract common.Address, block uint64, format string,
+	input []byte) (*CensusRef, *CensusProvenance, error) {
+	holders, err := ERC20Holders(contract, block, format, input)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(holders) == 0 {
+		return nil, nil, fmt.Errorf("no token holders found")
+	}
+	addrs := make([]common.Address, 0, len(holders))
+	for addr := range holders {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i][:], addrs[j][:]) < 0 })
+
+	ref, err := c.New(censusID, models.Census_ARBO_BLAKE2B, false, "", authToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	provenance := &CensusProvenance{
+		Contract:    contract,
+		Block:       block,
+		Format:      format,
+		InputHash:   ethereum.HashRaw(input),
+		Holders:     uint64(len(addrs)),
+		TotalWeight: new(types.BigInt),
+	}
+	if err := addERC20Holders(ref, addrs, holders, provenance.TotalWeight.ToInt()); err != nil {
+		if err := c.Del(censusID); err != nil {
+			log.Warnf("could not delete census %x: %v", censusID, err)
+		}
+		return nil, nil, err
+	}
+	log.Infof("created census %x with %d holders of token %s at block %d",
+		censusID, provenance.Holders, contract, block)
+	return ref, provenance, nil
+}
+
+// addERC20Holders adds the holders to the census in batches, sorted by
+// address, and sums their balances to totalWeight.
+func addERC20Holders(ref *CensusRef, addrs []common.Address,
+	holders map[common.Address]*big.Int, totalWeight *big.Int) error {
+	maxWeightBits := 8 * len(ref.Tree().BigIntToBytes(big.NewInt(0)))
+	for len(addrs) > 0 {
+		n := len(addrs)
+		if n > importBatchSize {
+			n = importBatchSize
+		}
+		var keys, values [][]byte
+		for _, addr := range addrs[:n] {
+			balance := holders[addr]
+			if balance.BitLen() > maxWeightBits {
+				return fmt.Errorf("balance of %s too large", addr)
+			}
+			keyHash, err := ref.Tree().Hash(addr.Bytes())
+			if err != nil {
+				return fmt.Errorf("could not compute key hash: %w", err)
+			}
+			keys = append(keys, keyHash)
+			values = append(values, ref.Tree().BigIntToBytes(balance))
+			totalWeight.Add(totalWeight, balance)
+		}
+		failed, err := ref.Tree().AddBatch(keys, values)
+		if err != nil {
+			return fmt.Errorf("cannot add holders to census: %w", err)
+		}
+		if len(failed) > 0 {
+			return fmt.Errorf("cannot add %d holders to census", len(failed))
+		}
+		addrs = addrs[n:]
+	}
+	return nil
+}
+
+// SetProvenance stores the provenance record of a census.  A census keeps its
+// first record, so ErrProvenanceExists is returned if it is a different one.
+func (c *CensusDB) SetProvenance(censusID []byte, provenance *CensusProvenance) error {
+	data, err := json.Marshal(provenance)
+	if err != nil {
+		return err
+	}
+	c.provenanceLock.Lock()
+	defer c.provenanceLock.Unlock()
+	key := append([]byte(censusDBprovenancePrefix), censusID...)
+	wtx := c.db.WriteTx()
+	defer wtx.Discard()
+	current, err := wtx.Get(key)
+	if err == nil {
+		if bytes.Equal(current, data) {
+			return nil
+		}
+		return ErrProvenanceExists
+	} else if !errors.Is(err, db.ErrKeyNotFound) {
+		return err
+	}
+	if err := wtx.Set(key, data); err != nil {
+		return err
+	}
+	return wtx.Commit()
+}
+
+// Provenance returns the provenance record of a census.
+func (c *CensusDB) Provenance(censusID []byte) (*CensusProvenance, error) {
+	rtx := c.db.ReadTx()
+	defer rtx.Discard()
+	data, err := rtx.Get(append([]byte(censusDBprovenancePrefix), censusID...))
+	if errors.Is(err, db.ErrKeyNotFound) {
+		return nil, ErrProvenanceNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	provenance := &CensusProvenance{}
+	return provenance, json.Unmarshal(data, provenance)
+}
@@ -6,8 +6,12 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/google/uuid"
 	"go.vocdoni.io/dvote/api/censusdb"
 	"go.vocdoni.io/dvote/data/compressor"
@@ -28,6 +32,7 @@ const (
 	CensusTypeCSP        = "csp"
 
 	MaxCensusAddBatchSize = 8192
+	MaxERC20SnapshotSize  = 1024 * 1024 * 64 // 64MB
 
 	censusIDsize  = 32
 	censusKeysize = 32
@@ -42,6 +47,14 @@ func (a *API) enableCensusHandlers() error {
 	); err != nil {
 		return err
 	}
+	if err := a.endpoint.RegisterStreamMethod(
+		"/censuses/erc20/{contract}/{block}/{format}",
+		"POST",
+		apirest.MethodAccessTypePublic,
+		a.censusERC20Handler,
+	); err != nil {
+		return err
+	}
 	if err := a.endpoint.RegisterMethod(
 		"/censuses/{censusID}/participants",
 		"POST",
@@ -50,6 +63,22 @@ func (a *API) enableCensusHandlers() error {
 	); err != nil {
 		return err
 	}
+	if err := a.endpoint.RegisterMethod(
+		"/censuses/{censusID}/update",
+		"POST",
+		apirest.MethodAccessTypePublic,
+		a.censusUpdateHandler,
+	); err != nil {
+		return err
+	}
+	if err := a.endpoint.RegisterMethod(
+		"/censuses/{censusID}/diff/{targetCensusID}",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		a.censusDiffHandler,
+	); err != nil {
+		return err
+	}
 	if err := a.endpoint.RegisterMethod(
 		"/censuses/{censusID}/root",
 		"GET",
@@ -67,6 +96,14 @@ func (a *API) enableCensusHandlers() error {
 		return err
 	}
 	if err := a.endpoint.RegisterMethod(
+		"/censuses/{censusID}/export/{format}",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		a.censusDumpHandler,
+	); err != nil {
+		return err
+	}
+	if err := a.endpoint.RegisterStreamMethod(
 		"/censuses/{censusID}/import",
 		"POST",
 		apirest.MethodAccessTypePublic,
@@ -74,6 +111,14 @@ func (a *API) enableCensusHandlers() error {
 	); err != nil {
 		return err
 	}
+	if err := a.endpoint.RegisterStreamMethod(
+		"/censuses/{censusID}/import/{format}",
+		"POST",
+		apirest.MethodAccessTypePublic,
+		a.censusImportHandler,
+	); err != nil {
+		return err
+	}
 	if err := a.endpoint.RegisterMethod(
 		"/censuses/{censusID}/weight",
 		"GET",
@@ -106,6 +151,14 @@ func (a *API) enableCensusHandlers() error {
 	); err != nil {
 		return err
 	}
+	if err := a.endpoint.RegisterMethod(
+		"/censuses/{censusID}/provenance",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		a.censusProvenanceHandler,
+	); err != nil {
+		return err
+	}
 	if err := a.endpoint.RegisterMethod(
 		"/censuses/{censusID}",
 		"DELETE",
@@ -161,6 +214,79 @@ func (a *API) censusCreateHandler(msg *apirest.APIdata, ctx *httprouter.HTTPCont
 	return ctx.Send(data, apirest.HTTPstatusCodeOK)
 }
 
+// POST /censuses/erc20/{contract}/{block}/{format}
+// Builds and publishes a weighted census with the holders of an ERC20 token at
+// the block, from the Transfer event logs or the balances of the request body.
+// The provenance of the census is recorded, so it can be verified, and it
+// cannot be replaced by the one of another snapshot building the same census.
+func (a *API) censusERC20Handler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	token, err := uuid.Parse(msg.AuthToken)
+	if err != nil {
+		return err
+	}
+	contract := ctx.URLParam("contract")
+	if !common.IsHexAddress(contract) {
+		return fmt.Errorf("invalid contract address")
+	}
+	block, err := strconv.ParseUint(ctx.URLParam("block"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid block number")
+	}
+	// the request body is not read in advance by stream methods
+	body, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, MaxERC20SnapshotSize))
+	if err != nil {
+		return err
+	}
+	// the census is built on a working census, removed once published
+	censusID := util.RandomBytes(32)
+	ref, provenance, err := a.censusdb.NewERC20Census(censusID, &token,
+		common.HexToAddress(contract), block, ctx.URLParam("format"), body)
+	if err != nil {
+		return err
+	}
+	root, uri, err := a.publishCensus(ref)
+	if err := a.censusdb.Del(censusID); err != nil {
+		log.Warnf("could not delete census %x: %v", censusID, err)
+	}
+	if err != nil {
+		return err
+	}
+	if err := a.censusdb.SetProvenance(root, provenance); err != nil {
+		return err
+	}
+
+	var data []byte
+	if data, err = json.Marshal(&Census{
+		CensusID:   root,
+		URI:        uri,
+		Provenance: provenance,
+	}); err != nil {
+		return err
+	}
+	return ctx.Send(data, apirest.HTTPstatusCodeOK)
+}
+
+// GET /censuses/{censusID}/provenance
+// Returns the provenance record of a census built from a token holders snapshot
+func (a *API) censusProvenanceHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	censusID, err := censusIDparse(ctx.URLParam("censusID"))
+	if err != nil {
+		return err
+	}
+	provenance, err := a.censusdb.Provenance(censusID)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if data, err = json.Marshal(&Census{
+		CensusID:   censusID,
+		Provenance: provenance,
+	}); err != nil {
+		return err
+	}
+	return ctx.Send(data, apirest.HTTPstatusCodeOK)
+}
+
 // POST /censuses/participants/{censusID}
 // Adds one or multiple key/weights to the census
 func (a *API) censusAddHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
@@ -239,6 +365,144 @@ func (a *API) censusAddHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext
 	return ctx.Send(nil, apirest.HTTPstatusCodeOK)
 }
 
+// POST /censuses/{censusID}/update
+// Adds, removes and updates the weight of participants of a weighted census
+func (a *API) censusUpdateHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	token, err := uuid.Parse(msg.AuthToken)
+	if err != nil {
+		return err
+	}
+	censusID, err := censusIDparse(ctx.URLParam("censusID"))
+	if err != nil {
+		return err
+	}
+
+	cdata := CensusUpdate{}
+	if err := json.Unmarshal(msg.Data, &cdata); err != nil {
+		return err
+	}
+	count := len(cdata.Add) + len(cdata.Update) + len(cdata.Remove)
+	if count == 0 {
+		return fmt.Errorf("missing census changes")
+	}
+	if count > MaxCensusAddBatchSize {
+		return fmt.Errorf("maximum number of changes per call is %d (received %d)",
+			MaxCensusAddBatchSize, count)
+	}
+
+	ref, err := a.censusdb.Load(censusID, &token)
+	if err != nil {
+		return err
+	}
+	// build the list of changes, hashing the participant keys
+	leaves := func(participants []CensusParticipant) ([]*censusdb.CensusLeaf, error) {
+		list := []*censusdb.CensusLeaf{}
+		for i, p := range participants {
+			if p.Key == nil {
+				return nil, fmt.Errorf("missing participant key number %d", i)
+			}
+			if p.Weight == nil {
+				p.Weight = new(types.BigInt).SetUint64(1)
+			}
+			keyHash, err := ref.Tree().Hash(p.Key)
+			if err != nil {
+				return nil, fmt.Errorf("could not compute key hash: %w", err)
+			}
+			list = append(list, &censusdb.CensusLeaf{
+				Key:   keyHash,
+				Value: ref.Tree().BigIntToBytes(p.Weight.ToInt()),
+			})
+		}
+		return list, nil
+	}
+	changes := &censusdb.CensusChanges{}
+	if changes.Add, err = leaves(cdata.Add); err != nil {
+		return err
+	}
+	if changes.Update, err = leaves(cdata.Update); err != nil {
+		return err
+	}
+	for _, key := range cdata.Remove {
+		keyHash, err := ref.Tree().Hash(key)
+		if err != nil {
+			return fmt.Errorf("could not compute key hash: %w", err)
+		}
+		changes.Remove = append(changes.Remove, keyHash)
+	}
+
+	ref, err = a.censusdb.Update(censusID, &token, changes)
+	if err != nil {
+		return err
+	}
+	root, err := ref.Tree().Root()
+	if err != nil {
+		return err
+	}
+	size, err := ref.Tree().Size()
+	if err != nil {
+		return err
+	}
+	weight, err := ref.Tree().GetCensusWeight()
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if data, err = json.Marshal(Census{
+		Root:   root,
+		Size:   size,
+		Weight: (*types.BigInt)(weight),
+	}); err != nil {
+		return err
+	}
+	return ctx.Send(data, apirest.HTTPstatusCodeOK)
+}
+
+// GET /censuses/{censusID}/diff/{targetCensusID}
+// Returns the participants added, updated and removed from the census to the
+// target census, usually two published roots of the same census
+func (a *API) censusDiffHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	censusID, err := censusIDparse(ctx.URLParam("censusID"))
+	if err != nil {
+		return err
+	}
+	targetCensusID, err := censusIDparse(ctx.URLParam("targetCensusID"))
+	if err != nil {
+		return err
+	}
+	ref, err := a.censusdb.Load(censusID, nil)
+	if err != nil {
+		return err
+	}
+	if ref.Indexed {
+		return fmt.Errorf("indexed census diff is not supported")
+	}
+	diff, err := a.censusdb.Diff(censusID, targetCensusID)
+	if err != nil {
+		return err
+	}
+	participants := func(leaves []*censusdb.CensusLeaf) []CensusParticipant {
+		list := []CensusParticipant{}
+		for _, leaf := range leaves {
+			list = append(list, CensusParticipant{
+				Key:    leaf.Key,
+				Weight: (*types.BigInt)(ref.Tree().BytesToBigInt(leaf.Value)),
+			})
+		}
+		return list
+	}
+
+	var data []byte
+	if data, err = json.Marshal(CensusDiff{
+		Added:   participants(diff.Added),
+		Updated: participants(diff.Updated),
+		Removed: participants(diff.Removed),
+	}); err != nil {
+		return err
+	}
+	return ctx.Send(data, apirest.HTTPstatusCodeOK)
+}
+
 // /censuses/{censusID}/root
 func (a *API) censusRootHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
 	censusID, err := censusIDparse(ctx.URLParam("censusID"))
@@ -264,7 +528,10 @@ func (a *API) censusRootHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContex
 	return ctx.Send(data, apirest.HTTPstatusCodeOK)
 }
 
-// /censuses/{censusID}/export
+// GET /censuses/{censusID}/export
+// GET /censuses/{censusID}/export/{format}
+// exports the census as a dump (default), CSV or JSON Lines, depending on the
+// format URL parameter or the Accept header
 func (a *API) censusDumpHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
 	token, err := uuid.Parse(msg.AuthToken)
 	if err != nil {
@@ -274,6 +541,19 @@ func (a *API) censusDumpHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContex
 	if err != nil {
 		return err
 	}
+	format, err := censusFormat(ctx.URLParam("format"), ctx.Request.Header.Get("Accept"))
+	if err != nil {
+		return err
+	}
+	if format != censusdb.FormatDump {
+		// check the census exists and the token is valid before streaming it
+		if _, err := a.censusdb.Load(censusID, &token); err != nil {
+			return err
+		}
+		return ctx.SendStream(censusFormatContentTypes[format], func(w io.Writer) error {
+			return a.censusdb.ExportEntries(censusID, &token, format, w)
+		})
+	}
 	ref, err := a.censusdb.Load(censusID, &token)
 	if err != nil {
 		return err
@@ -299,7 +579,10 @@ func (a *API) censusDumpHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContex
 	return ctx.Send(data, apirest.HTTPstatusCodeOK)
 }
 
-// /censuses/{censusID}/import
+// POST /censuses/{censusID}/import
+// POST /censuses/{censusID}/import/{format}
+// imports a census dump (default), or adds the participants of a CSV or JSON
+// Lines file, depending on the format URL parameter or the Content-Type header
 func (a *API) censusImportHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
 	token, err := uuid.Parse(msg.AuthToken)
 	if err != nil {
@@ -309,9 +592,29 @@ func (a *API) censusImportHandler(msg *apirest.APIdata, ctx *httprouter.HTTPCont
 	if err != nil {
 		return err
 	}
+	format, err := censusFormat(ctx.URLParam("format"), ctx.Request.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	if format != censusdb.FormatDump {
+		result, err := a.censusdb.ImportEntries(censusID, &token, format, ctx.Request.Body)
+		if err != nil {
+			return err
+		}
+		var data []byte
+		if data, err = json.Marshal(result); err != nil {
+			return err
+		}
+		return ctx.Send(data, apirest.HTTPstatusCodeOK)
+	}
 
+	// the request body is not read in advance by stream methods
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		return err
+	}
 	cdata := censusdb.CensusDump{}
-	if err := json.Unmarshal(msg.Data, &cdata); err != nil {
+	if err := json.Unmarshal(body, &cdata); err != nil {
 		return err
 	}
 	if cdata.Data == nil || cdata.RootHash == nil {
@@ -442,33 +745,45 @@ func (a *API) censusPublishHandler(msg *apirest.APIdata, ctx *httprouter.HTTPCon
 		ref.SetTree(t)
 	}
 
+	root, uri, err := a.publishCensus(ref)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if data, err = json.Marshal(&Census{
+		CensusID: root,
+		URI:      uri,
+	}); err != nil {
+		return err
+	}
+
+	return ctx.Send(data, apirest.HTTPstatusCodeOK)
+}
+
+// publishCensus publishes the census tree of ref as a new census identified by
+// its root, exporting it to the remote storage.  If the census is already
+// published, it returns its root and URI.
+func (a *API) publishCensus(ref *censusdb.CensusRef) ([]byte, string, error) {
 	// the root hash is used as censusID for the new published census
 	// check if a census with censusID=root already exist
 	root, err := ref.Tree().Root()
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 
 	// if the census already exists, return the URI and the root
 	if a.censusdb.Exists(root) {
 		ref, err := a.censusdb.Load(root, nil)
 		if err != nil {
-			return err
-		}
-		var data []byte
-		if data, err = json.Marshal(&Census{
-			CensusID: root,
-			URI:      ref.URI,
-		}); err != nil {
-			return err
+			return nil, "", err
 		}
-		return ctx.Send(data, apirest.HTTPstatusCodeOK)
+		return root, ref.URI, nil
 	}
 
 	// dump the current tree to import them after
 	dump, err := ref.Tree().Dump()
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 
 	// export the tree to the remote storage (IPFS)
@@ -481,7 +796,7 @@ func (a *API) censusPublishHandler(msg *apirest.APIdata, ctx *httprouter.HTTPCon
 			ref.Indexed,
 		)
 		if err != nil {
-			return err
+			return nil, "", err
 		}
 		sctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
@@ -497,22 +812,13 @@ func (a *API) censusPublishHandler(msg *apirest.APIdata, ctx *httprouter.HTTPCon
 		root, models.Census_Type(ref.CensusType),
 		ref.Indexed, uri, nil)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 	if err := newRef.Tree().ImportDump(dump); err != nil {
-		return err
+		return nil, "", err
 	}
 	newRef.Tree().Publish()
-
-	var data []byte
-	if data, err = json.Marshal(&Census{
-		CensusID: root,
-		URI:      uri,
-	}); err != nil {
-		return err
-	}
-
-	return ctx.Send(data, apirest.HTTPstatusCodeOK)
+	return root, uri, nil
 }
 
 // /censuses/{censusID}/proof/{key}
@@ -135,6 +135,14 @@ func (a *API) enableChainHandlers() error {
 	); err != nil {
 		return err
 	}
+	if err := a.endpoint.RegisterMethod(
+		"/chain/blocks/{height}/light",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		a.chainLightBlockHandler,
+	); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -255,9 +263,12 @@ func (a *API) chainTxCostHandler(msg *apirest.APIdata, ctx *httprouter.HTTPConte
 	txCosts := &Transaction{
 		Costs: make(map[string]uint64),
 	}
-	var err error
+	st, err := a.stateAt(ctx)
+	if err != nil {
+		return err
+	}
 	for k, v := range vochain.TxCostNameToTxTypeMap {
-		txCosts.Costs[k], err = a.vocapp.State.TxCost(v, true)
+		txCosts.Costs[k], err = st.TxCost(v, true)
 		if err != nil {
 			return err
 		}
@@ -342,7 +353,11 @@ func (a *API) chainTxByIndexHandler(msg *apirest.APIdata, ctx *httprouter.HTTPCo
 // GET /chain/validators
 // returns the list of validators
 func (a *API) chainValidatorsHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
-	stateValidators, err := a.vocapp.State.Validators(true)
+	st, err := a.stateAt(ctx)
+	if err != nil {
+		return err
+	}
+	stateValidators, err := st.Validators(true)
 	if err != nil {
 		return err
 	}
@@ -381,6 +396,36 @@ func (a *API) chainBlockHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContex
 	return ctx.Send(convertKeysToCamel(data), apirest.HTTPstatusCodeOK)
 }
 
+// GET /chain/blocks/<height>/light
+// returns the signed header and the validator set of the block, to verify the
+// block AppHash as a light client
+func (a *API) chainLightBlockHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	height, err := strconv.ParseInt(ctx.URLParam("height"), 10, 64)
+	if err != nil {
+		return err
+	}
+	lightBlock, err := a.vocapp.GetLightBlock(height)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBlockNotFound, err)
+	}
+	lightBlockProto, err := lightBlock.ToProto()
+	if err != nil {
+		return err
+	}
+	lightBlockBytes, err := lightBlockProto.Marshal()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&LightBlock{
+		Height:     uint32(height),
+		LightBlock: lightBlockBytes,
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, apirest.HTTPstatusCodeOK)
+}
+
 // GET /chain/blocks/hash/<hash>
 // returns the block from the given hash
 func (a *API) chainBlockByHashHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
@@ -16,8 +16,11 @@ import (
 	"go.vocdoni.io/dvote/httprouter"
 	"go.vocdoni.io/dvote/httprouter/apirest"
 	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain/indexer/indexertypes"
 	"go.vocdoni.io/dvote/vochain/processid"
+	"go.vocdoni.io/dvote/vochain/state"
 	"go.vocdoni.io/proto/build/go/models"
 	"google.golang.org/protobuf/proto"
 )
@@ -28,6 +31,14 @@ const (
 )
 
 func (a *API) enableElectionHandlers() error {
+	if err := a.endpoint.RegisterMethod(
+		"/elections/search",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		a.electionSearchHandler,
+	); err != nil {
+		return err
+	}
 	if err := a.endpoint.RegisterMethod(
 		"/elections/{electionID}",
 		"GET",
@@ -36,6 +47,14 @@ func (a *API) enableElectionHandlers() error {
 	); err != nil {
 		return err
 	}
+	if err := a.endpoint.RegisterMethod(
+		"/elections/{electionID}/proof",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		a.electionProofHandler,
+	); err != nil {
+		return err
+	}
 	if err := a.endpoint.RegisterMethod(
 		"/elections/{electionID}/keys",
 		"GET",
@@ -60,6 +79,14 @@ func (a *API) enableElectionHandlers() error {
 	); err != nil {
 		return err
 	}
+	if err := a.endpoint.RegisterMethod(
+		"/elections/{electionID}/stats",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		a.electionStatsHandler,
+	); err != nil {
+		return err
+	}
 	if err := a.endpoint.RegisterMethod(
 		"/elections/{electionID}/votes/page/{page}",
 		"GET",
@@ -68,6 +95,22 @@ func (a *API) enableElectionHandlers() error {
 	); err != nil {
 		return err
 	}
+	if err := a.endpoint.RegisterMethod(
+		"/elections/{electionID}/transactions/page/{page}",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		a.electionTransactionsHandler,
+	); err != nil {
+		return err
+	}
+	if err := a.endpoint.RegisterMethod(
+		"/elections/{electionID}/transactions",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		a.electionTransactionsHandler,
+	); err != nil {
+		return err
+	}
 	if err := a.endpoint.RegisterMethod(
 		"/elections/{electionID}/scrutiny",
 		"GET",
@@ -98,6 +141,8 @@ func (a *API) enableElectionHandlers() error {
 
 // GET /elections/<electionID>
 // get election information
+// with the optional height query parameter, the status, census and final
+// results are the ones committed at that height
 func (a *API) electionHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
 	electionID, err := hex.DecodeString(util.TrimHex(ctx.URLParam("electionID")))
 	if err != nil {
@@ -116,13 +161,13 @@ func (a *API) electionHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext)
 		ElectionSummary: ElectionSummary{
 			ElectionID:   electionID,
 			Status:       models.ProcessStatus_name[proc.Status],
-			StartDate:    a.vocinfo.HeightTime(int64(proc.StartBlock)),
-			EndDate:      a.vocinfo.HeightTime(int64(proc.EndBlock)),
 			FinalResults: proc.FinalResults,
 			VoteCount:    count,
 		},
 		MetadataURL:   proc.Metadata,
 		ElectionCount: proc.EntityIndex,
+		StartBlock:    proc.StartBlock,
+		EndBlock:      proc.EndBlock,
 		CreationTime:  proc.CreationTime,
 		VoteMode:      VoteMode{EnvelopeType: proc.Envelope},
 		ElectionMode:  ElectionMode{ProcessMode: proc.Mode},
@@ -134,7 +179,8 @@ func (a *API) electionHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext)
 			CensusURL:              proc.CensusURI,
 		},
 	}
-	election.Status = models.ProcessStatus_name[proc.Status]
+	election.Status = models.ProcessStatus_name[proc.CurrentStatus(a.vocapp.TimestampStartBlock())]
+	election.StartDate, election.EndDate = a.electionDates(proc)
 
 	if proc.HaveResults {
 		results, err := a.indexer.GetResults(electionID)
@@ -144,6 +190,38 @@ func (a *API) electionHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext)
 		election.Results = results.Votes
 	}
 
+	// The election as committed at the requested height, if any
+	st, err := a.stateAt(ctx)
+	if err != nil {
+		return err
+	}
+	if st.ReadOnly() {
+		process, err := st.Process(electionID, true)
+		if err != nil {
+			return fmt.Errorf("cannot fetch electionID %x: %w", electionID, err)
+		}
+		election.Status = models.ProcessStatus_name[int32(process.Status)]
+		election.VoteCount = uint64(st.CountVotes(electionID, true))
+		election.StartBlock = process.StartBlock
+		election.EndBlock = process.StartBlock + process.BlockCount
+		election.VoteMode = VoteMode{EnvelopeType: process.EnvelopeType}
+		election.Census.CensusRoot = process.CensusRoot
+		election.Census.PostRegisterCensusRoot = process.RollingCensusRoot
+		election.Census.CensusURL = process.GetCensusURI()
+		election.FinalResults = false
+		election.Results = nil
+		if result := state.LastProcessResults(process); result != nil {
+			election.FinalResults = true
+			for _, question := range result.GetVotes() {
+				votes := []*types.BigInt{}
+				for _, v := range question.Question {
+					votes = append(votes, new(types.BigInt).SetBytes(v))
+				}
+				election.Results = append(election.Results, votes)
+			}
+		}
+	}
+
 	// Try to retrieve the election metadata
 	if a.storage != nil {
 		stgCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
@@ -173,7 +251,11 @@ func (a *API) electionVotesCountHandler(msg *apirest.APIdata, ctx *httprouter.HT
 	if err != nil || electionID == nil {
 		return fmt.Errorf("electionID (%s) cannot be decoded", ctx.URLParam("electionID"))
 	}
-	count := a.vocapp.State.CountVotes(electionID, true)
+	st, err := a.stateAt(ctx)
+	if err != nil {
+		return err
+	}
+	count := st.CountVotes(electionID, true)
 	data, err := json.Marshal(
 		struct {
 			Count uint32 `json:"count"`
@@ -185,6 +267,44 @@ func (a *API) electionVotesCountHandler(msg *apirest.APIdata, ctx *httprouter.HT
 	return ctx.Send(data, apirest.HTTPstatusCodeOK)
 }
 
+// GET /elections/<electionID>/stats?blockBucket=<blocks>&timeBucket=<seconds>
+// get the participation analytics of an election, with its votes grouped in
+// buckets of blocks and of seconds, one block and one hour by default, which
+// fails if there are more than indexer.MaxStatsBuckets buckets
+func (a *API) electionStatsHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	electionID, err := hex.DecodeString(util.TrimHex(ctx.URLParam("electionID")))
+	if err != nil || electionID == nil {
+		return fmt.Errorf("electionID (%q) cannot be decoded", ctx.URLParam("electionID"))
+	}
+	params := ctx.Request.URL.Query()
+	blockBucket, timeBucket := uint64(1), uint64(time.Hour/time.Second)
+	if param := params.Get("blockBucket"); param != "" {
+		if blockBucket, err = strconv.ParseUint(param, 10, 32); err != nil || blockBucket == 0 {
+			return fmt.Errorf("blockBucket (%q) must be a positive number of blocks", param)
+		}
+	}
+	if param := params.Get("timeBucket"); param != "" {
+		if timeBucket, err = strconv.ParseUint(param, 10, 32); err != nil || timeBucket == 0 {
+			return fmt.Errorf("timeBucket (%q) must be a positive number of seconds", param)
+		}
+	}
+	stats, err := a.indexer.ElectionStats(electionID, uint32(blockBucket),
+		time.Duration(timeBucket)*time.Second)
+	if err != nil {
+		return fmt.Errorf("cannot get election stats: %w", err)
+	}
+	data, err := json.Marshal(&ElectionStats{
+		ElectionID:    electionID,
+		BlockBucket:   uint32(blockBucket),
+		TimeBucket:    uint32(timeBucket),
+		ElectionStats: stats,
+	})
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %w", err)
+	}
+	return ctx.Send(data, apirest.HTTPstatusCodeOK)
+}
+
 // GET /elections/<electionID>/keys
 // returns the list of public/private encryption keys
 func (a *API) electionKeysHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
@@ -192,8 +312,11 @@ func (a *API) electionKeysHandler(msg *apirest.APIdata, ctx *httprouter.HTTPCont
 	if err != nil || electionID == nil {
 		return fmt.Errorf("electionID (%q) cannot be decoded", ctx.URLParam("electionID"))
 	}
-
-	process, err := a.vocapp.State.Process(electionID, true)
+	st, err := a.stateAt(ctx)
+	if err != nil {
+		return err
+	}
+	process, err := st.Process(electionID, true)
 	if err != nil {
 		return fmt.Errorf("cannot get election keys: %w", err)
 	}
@@ -261,6 +384,37 @@ func (a *API) electionVotesHandler(msg *apirest.APIdata, ctx *httprouter.HTTPCon
 	return ctx.Send(data, apirest.HTTPstatusCodeOK)
 }
 
+// GET elections/<electionID>/transactions/page/<page>
+// returns the transactions related to an election, newest first (paginated)
+func (a *API) electionTransactionsHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	electionID, err := hex.DecodeString(util.TrimHex(ctx.URLParam("electionID")))
+	if err != nil || electionID == nil {
+		return fmt.Errorf("electionID (%q) cannot be decoded", ctx.URLParam("electionID"))
+	}
+	page := 0
+	if ctx.URLParam("page") != "" {
+		page, err = strconv.Atoi(ctx.URLParam("page"))
+		if err != nil {
+			return fmt.Errorf("cannot parse page number")
+		}
+	}
+	page = page * MaxPageSize
+
+	txs, err := a.indexer.GetTxReferencesByProcessID(electionID, MaxPageSize, int32(page))
+	if err != nil {
+		return fmt.Errorf("cannot fetch transactions: %w", err)
+	}
+	data, err := json.Marshal(
+		struct {
+			Transactions []*indexertypes.TxReference `json:"transactions"`
+		}{Transactions: txs},
+	)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %w", err)
+	}
+	return ctx.Send(data, apirest.HTTPstatusCodeOK)
+}
+
 // GET /elections/<electionID>/scrutiny
 // returns the consensus results of an election
 func (a *API) electionScrutinyHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
@@ -283,11 +437,10 @@ func (a *API) electionScrutinyHandler(msg *apirest.APIdata, ctx *httprouter.HTTP
 	}
 	// check process results are the same
 	var results [][]*big.Int
-	if len(process.Results) > 0 {
-		firstResult := process.Results[0]
-		for _, processResult := range process.Results[1:] {
-			if processResult == nil {
-				log.Errorf("invalid process result")
+	if firstResult := state.LastProcessResults(process); firstResult != nil {
+		for _, processResult := range process.Results {
+			// the results slice is allocated with empty items
+			if len(processResult.GetVotes()) == 0 {
 				continue
 			}
 			if len(firstResult.Votes) != len(processResult.Votes) {
@@ -340,6 +493,14 @@ func (a *API) electionScrutinyHandler(msg *apirest.APIdata, ctx *httprouter.HTTP
 		OrganizationID:        process.EntityId,
 		Results:               results,
 	}
+	// for ranked elections, attach the ranked rounds computed by the indexer
+	if process.EnvelopeType.GetRankedChoice() {
+		indexerResults, err := a.indexer.GetResults(electionID)
+		if err != nil {
+			return fmt.Errorf("cannot get election ranked rounds: %w", err)
+		}
+		electionResults.RankedRounds = indexerResults.RankedRounds
+	}
 
 	electionResults.ABIEncoded, err = encodeEVMResultsArgs(
 		common.BytesToHash(electionID),
@@ -359,6 +520,25 @@ func (a *API) electionScrutinyHandler(msg *apirest.APIdata, ctx *httprouter.HTTP
 	return ctx.Send(data, apirest.HTTPstatusCodeOK)
 }
 
+// GET /elections/<electionID>/proof
+// returns the merkle proof of the election, including its results, up to the
+// state root
+func (a *API) electionProofHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	electionID, err := hex.DecodeString(util.TrimHex(ctx.URLParam("electionID")))
+	if err != nil || len(electionID) != types.ProcessIDsize {
+		return fmt.Errorf("electionID (%q) cannot be decoded", ctx.URLParam("electionID"))
+	}
+	st, err := a.stateAt(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := a.stateProof(st, state.TreeProcess, electionID)
+	if err != nil {
+		return fmt.Errorf("cannot fetch election %x: %w", electionID, err)
+	}
+	return ctx.Send(data, apirest.HTTPstatusCodeOK)
+}
+
 // POST elections
 // creates a new election
 func (a *API) electionCreateHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
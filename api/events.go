@@ -0,0 +1,376 @@
+package api
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/apirest"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain/state"
+	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+const (
+	EventsHandler = "events"
+
+	// EventTypeBlock is sent on every new committed block
+	EventTypeBlock = "block"
+	// EventTypeVote is sent for every new vote
+	EventTypeVote = "vote"
+	// EventTypeElection is sent for every new election
+	EventTypeElection = "election"
+	// EventTypeElectionStatus is sent when the status of an election changes
+	EventTypeElectionStatus = "electionStatus"
+	// EventTypeElectionResults is sent when the results of an election are set
+	EventTypeElectionResults = "electionResults"
+
+	// maxBufferedEvents is the number of recent events kept in memory, so
+	// clients can resume their event stream from a past height.
+	maxBufferedEvents = 10000
+	// HTTPstatusCodeGone is returned when the events to resume a stream from
+	// are no longer buffered.
+	HTTPstatusCodeGone = 410
+	// eventsStreamDuration is the maximum duration of an event stream.  It must
+	// be smaller than the HTTP server write timeout.  Once the stream is
+	// closed, clients are expected to reconnect and resume from the last
+	// received event ID (which is the block height).
+	eventsStreamDuration = 8 * time.Second
+)
+
+// eventBroker is a vochain state event listener that keeps the latest events
+// and notifies the API event streams when a new block is committed.
+// Events are only published on Commit, so rolled back events are never sent.
+type eventBroker struct {
+	state *state.State
+
+	lock    sync.RWMutex
+	pending []*Event
+	events  []*Event
+	// oldest is the lowest height whose events are all buffered
+	oldest uint32
+	// notify is closed and replaced on every commit
+	notify chan struct{}
+}
+
+// ErrEventsGone is returned when the events of the requested height are no
+// longer buffered, so a stream resumed from it would silently miss events.
+var ErrEventsGone = fmt.Errorf("events are no longer available")
+
+// newEventBroker returns an event broker whose first block is the given height.
+func newEventBroker(st *state.State, height uint32) *eventBroker {
+	return &eventBroker{
+		state:  st,
+		oldest: height,
+		notify: make(chan struct{}),
+	}
+}
+
+func (b *eventBroker) addPending(event *Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.pending = append(b.pending, event)
+}
+
+// since returns the buffered events with a height equal or greater than the
+// given one, that match the election and organization filters (if not nil).
+// Block events are always returned.  The returned channel is closed once new
+// events are available.  ErrEventsGone is returned if the events of the given
+// height are no longer buffered.
+func (b *eventBroker) since(height uint32, electionID, organizationID []byte) ([]*Event, <-chan struct{}, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if height < b.oldest {
+		return nil, nil, fmt.Errorf("%w, the oldest available height is %d", ErrEventsGone, b.oldest)
+	}
+	var events []*Event
+	for _, e := range b.events {
+		if e.Height < height {
+			continue
+		}
+		if e.Type != EventTypeBlock {
+			if electionID != nil && !bytes.Equal(e.ElectionID, electionID) {
+				continue
+			}
+			if organizationID != nil && !bytes.Equal(e.OrganizationID, organizationID) {
+				continue
+			}
+		}
+		events = append(events, e)
+	}
+	return events, b.notify, nil
+}
+
+// OnVote implements the state.EventListener interface
+func (b *eventBroker) OnVote(vote *state.Vote, txIndex int32) {
+	b.addPending(&Event{
+		Type:       EventTypeVote,
+		ElectionID: vote.ProcessID,
+		VoteID:     vote.Nullifier,
+		TxIndex:    txIndex,
+	})
+}
+
+// OnProcess implements the state.EventListener interface
+func (b *eventBroker) OnProcess(pid, eid []byte, censusRoot, censusURI string, txIndex int32) {
+	b.addPending(&Event{
+		Type:           EventTypeElection,
+		ElectionID:     pid,
+		OrganizationID: eid,
+		TxIndex:        txIndex,
+	})
+}
+
+// OnProcessStatusChange implements the state.EventListener interface
+func (b *eventBroker) OnProcessStatusChange(pid []byte, status models.ProcessStatus, txIndex int32) {
+	b.addPending(&Event{
+		Type:       EventTypeElectionStatus,
+		ElectionID: pid,
+		Status:     models.ProcessStatus_name[int32(status)],
+		TxIndex:    txIndex,
+	})
+}
+
+// OnProcessResults implements the state.EventListener interface
+func (b *eventBroker) OnProcessResults(pid []byte, results *models.ProcessResult, txIndex int32) {
+	b.addPending(&Event{
+		Type:       EventTypeElectionResults,
+		ElectionID: pid,
+		TxIndex:    txIndex,
+	})
+}
+
+// Commit implements the state.EventListener interface.  The pending events
+// are published with the organization of their election.
+func (b *eventBroker) Commit(height uint32) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	organizations := make(map[string][]byte)
+	for _, e := range b.pending {
+		e.Height = height
+		if e.OrganizationID != nil || e.ElectionID == nil {
+			continue
+		}
+		eid, ok := organizations[string(e.ElectionID)]
+		if !ok {
+			process, err := b.state.Process(e.ElectionID, true)
+			if err != nil {
+				log.Warnf("cannot fetch process %x for event: %v", e.ElectionID, err)
+				continue
+			}
+			eid = process.EntityId
+			organizations[string(e.ElectionID)] = eid
+		}
+		e.OrganizationID = eid
+	}
+	b.events = append(b.events, b.pending...)
+	b.events = append(b.events, &Event{Type: EventTypeBlock, Height: height})
+	b.pending = nil
+	if len(b.events) > maxBufferedEvents {
+		cut := len(b.events) - maxBufferedEvents
+		// the events of a partially dropped block are dropped too, so the
+		// buffered blocks are always complete
+		for cut < len(b.events) && b.events[cut].Height == b.events[cut-1].Height {
+			cut++
+		}
+		b.oldest = b.events[cut-1].Height + 1
+		b.events = append([]*Event{}, b.events[cut:]...)
+	}
+	close(b.notify)
+	b.notify = make(chan struct{})
+	return nil
+}
+
+// Rollback implements the state.EventListener interface
+func (b *eventBroker) Rollback() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.pending = nil
+}
+
+// OnNewTx implements the state.EventListener interface
+func (*eventBroker) OnNewTx(tx *vochaintx.VochainTx, blockHeight uint32, txIndex int32) {}
+
+// OnCancel implements the state.EventListener interface
+func (*eventBroker) OnCancel(pid []byte, txIndex int32) {}
+
+// OnProcessKeys implements the state.EventListener interface
+func (*eventBroker) OnProcessKeys(pid []byte, encryptionPub string, txIndex int32) {}
+
+// OnRevealKeys implements the state.EventListener interface
+func (*eventBroker) OnRevealKeys(pid []byte, encryptionPriv string, txIndex int32) {}
+
+// OnProcessesStart implements the state.EventListener interface
+func (*eventBroker) OnProcessesStart(pids [][]byte) {}
+
+// OnSetAccount implements the state.EventListener interface
+func (*eventBroker) OnSetAccount(addr []byte, account *state.Account) {}
+
+// OnTransferTokens implements the state.EventListener interface
+func (*eventBroker) OnTransferTokens(tx *vochaintx.TokenTransfer) {}
+
+// OnRotateAccount implements the state.EventListener interface
+func (*eventBroker) OnRotateAccount(rotation *vochaintx.AccountRotation) {}
+
+// OnVoteDelegation implements the state.EventListener interface
+func (*eventBroker) OnVoteDelegation(delegation *vochaintx.VoteDelegation, txIndex int32) {}
+
+// OnVoteWeight implements the state.EventListener interface
+func (*eventBroker) OnVoteWeight(vote *state.Vote, previousWeight *big.Int, txIndex int32) {}
+
+func (a *API) enableEventHandlers() error {
+	a.events = newEventBroker(a.vocapp.State, a.vocapp.Height())
+	a.vocapp.State.AddEventListener(a.events)
+
+	if err := a.endpoint.RegisterMethod(
+		"/events",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		a.eventsHandler,
+	); err != nil {
+		return err
+	}
+	if err := a.endpoint.RegisterMethod(
+		"/events/height/{height}",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		a.eventsHandler,
+	); err != nil {
+		return err
+	}
+	if err := a.endpoint.RegisterMethod(
+		"/elections/{electionID}/events",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		a.eventsHandler,
+	); err != nil {
+		return err
+	}
+	if err := a.endpoint.RegisterMethod(
+		"/elections/{electionID}/events/height/{height}",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		a.eventsHandler,
+	); err != nil {
+		return err
+	}
+	if err := a.endpoint.RegisterMethod(
+		"/accounts/{organizationID}/events",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		a.eventsHandler,
+	); err != nil {
+		return err
+	}
+	if err := a.endpoint.RegisterMethod(
+		"/accounts/{organizationID}/events/height/{height}",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		a.eventsHandler,
+	); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GET /events
+// GET /elections/<electionID>/events
+// GET /accounts/<organizationID>/events
+// streams the vochain events using Server-Sent Events, optionally filtered by
+// election or organization. The /height/<height> suffix or the Last-Event-ID
+// header can be used to resume the stream from a past height.  If the events
+// of that height are no longer buffered, 410 Gone is returned with the oldest
+// available height, instead of a partial stream.
+func (a *API) eventsHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	var electionID, organizationID []byte
+	var err error
+	if ctx.URLParam("electionID") != "" {
+		electionID, err = hex.DecodeString(util.TrimHex(ctx.URLParam("electionID")))
+		if err != nil {
+			return fmt.Errorf("electionID (%q) cannot be decoded", ctx.URLParam("electionID"))
+		}
+	}
+	if ctx.URLParam("organizationID") != "" {
+		organizationID, err = hex.DecodeString(util.TrimHex(ctx.URLParam("organizationID")))
+		if err != nil {
+			return fmt.Errorf("organizationID (%q) cannot be decoded", ctx.URLParam("organizationID"))
+		}
+	}
+	// by default, only the events from the current block onwards are streamed
+	height := a.vocapp.Height()
+	if ctx.URLParam("height") != "" {
+		h, err := strconv.ParseUint(ctx.URLParam("height"), 10, 32)
+		if err != nil {
+			return fmt.Errorf("cannot parse height")
+		}
+		height = uint32(h)
+	}
+	// the Last-Event-ID header is sent by clients reconnecting to the stream
+	if lastID := ctx.Request.Header.Get("Last-Event-ID"); lastID != "" {
+		h, err := strconv.ParseUint(lastID, 10, 32)
+		if err != nil {
+			return fmt.Errorf("cannot parse Last-Event-ID")
+		}
+		height = uint32(h) + 1
+	}
+	if _, _, err := a.events.since(height, electionID, organizationID); errors.Is(err, ErrEventsGone) {
+		data, err := json.Marshal(&apirest.ErrorMsg{Error: err.Error()})
+		if err != nil {
+			return err
+		}
+		return ctx.Send(data, HTTPstatusCodeGone)
+	}
+
+	reqCtx := ctx.Request.Context()
+	events := make(chan *httprouter.ServerSentEvent)
+	go func() {
+		defer close(events)
+		timeout := time.After(eventsStreamDuration)
+		for {
+			// if the stream falls behind the buffer, it is closed so the
+			// client reconnects and learns about the missed events
+			list, notify, err := a.events.since(height, electionID, organizationID)
+			if err != nil {
+				log.Debugf("closing event stream: %v", err)
+				return
+			}
+			for _, e := range list {
+				data, err := json.Marshal(e)
+				if err != nil {
+					log.Warnf("cannot marshal event: %v", err)
+					continue
+				}
+				sse := &httprouter.ServerSentEvent{Event: e.Type, Data: data}
+				// Only the block event, which is the last one of each block,
+				// carries the event ID. So a client resuming from its last
+				// event ID never misses the events of a partially sent block.
+				if e.Type == EventTypeBlock {
+					sse.ID = strconv.FormatUint(uint64(e.Height), 10)
+				}
+				select {
+				case events <- sse:
+				case <-reqCtx.Done():
+					return
+				}
+				height = e.Height + 1
+			}
+			select {
+			case <-notify:
+			case <-timeout:
+				return
+			case <-reqCtx.Done():
+				return
+			}
+		}
+	}()
+	return ctx.SendEvents(events)
+}
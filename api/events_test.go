@@ -0,0 +1,40 @@
+package api
+
+import (
+	"errors"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestEventBrokerGone(t *testing.T) {
+	b := newEventBroker(nil, 10)
+
+	// the events before the first block of the broker were never buffered
+	_, _, err := b.since(9, nil, nil)
+	qt.Assert(t, errors.Is(err, ErrEventsGone), qt.IsTrue)
+
+	// fill the buffer with blocks of two events, so the oldest one is
+	// partially dropped once the buffer is full
+	height := uint32(10)
+	for ; height < 10+maxBufferedEvents/2; height++ {
+		b.addPending(&Event{Type: EventTypeVote, ElectionID: []byte{1}, OrganizationID: []byte{2}})
+		qt.Assert(t, b.Commit(height), qt.IsNil)
+	}
+	events, _, err := b.since(10, nil, nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, events, qt.HasLen, maxBufferedEvents)
+
+	// a block of three events drops the first block and half of the second,
+	// whose remaining event is dropped too
+	for i := 0; i < 2; i++ {
+		b.addPending(&Event{Type: EventTypeVote, ElectionID: []byte{1}, OrganizationID: []byte{2}})
+	}
+	qt.Assert(t, b.Commit(height), qt.IsNil)
+	_, _, err = b.since(11, nil, nil)
+	qt.Assert(t, errors.Is(err, ErrEventsGone), qt.IsTrue)
+	events, _, err = b.since(12, nil, nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, events, qt.HasLen, maxBufferedEvents-1)
+	qt.Assert(t, events[0].Height, qt.Equals, uint32(12))
+}
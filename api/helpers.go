@@ -6,11 +6,16 @@ import (
 	"fmt"
 	"math/big" // required for evm encoding
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/iancoleman/strcase"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/vochain/indexer/indexertypes"
+	"go.vocdoni.io/dvote/vochain/state"
 	"go.vocdoni.io/proto/build/go/models"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
@@ -23,16 +28,35 @@ func (a *API) electionSummaryList(pids ...[]byte) ([]*ElectionSummary, error) {
 		if err != nil {
 			return nil, fmt.Errorf("cannot fetch election info: %w", err)
 		}
-		processes = append(processes, &ElectionSummary{
+		status := procInfo.CurrentStatus(a.vocapp.TimestampStartBlock())
+		summary := &ElectionSummary{
 			ElectionID: procInfo.ID,
-			Status:     strings.ToLower(models.ProcessStatus_name[procInfo.Status]),
+			Status:     strings.ToLower(models.ProcessStatus_name[status]),
 			StartDate:  procInfo.CreationTime,
 			EndDate:    a.vocinfo.HeightTime(int64(procInfo.EndBlock)),
-		})
+		}
+		if _, _, ok := state.ProcessTimeBounds(procInfo.Mode); ok {
+			summary.StartDate, summary.EndDate = a.electionDates(procInfo)
+		}
+		processes = append(processes, summary)
 	}
 	return processes, nil
 }
 
+// electionDates returns the start and end dates of an election.  Time-bounded
+// elections start and end at absolute dates, the rest are estimated from their
+// start and end blocks.
+func (a *API) electionDates(proc *indexertypes.Process) (time.Time, time.Time) {
+	start, end, ok := state.ProcessTimeBounds(proc.Mode)
+	if !ok {
+		end = a.vocinfo.HeightTime(int64(proc.EndBlock))
+	}
+	if start.IsZero() {
+		start = a.vocinfo.HeightTime(int64(proc.StartBlock))
+	}
+	return start, end
+}
+
 func protoFormat(tx []byte) string {
 	ptx := models.Tx{}
 	if err := proto.Unmarshal(tx, &ptx); err != nil {
@@ -121,3 +145,38 @@ func encodeEVMResultsArgs(electionId common.Hash,
 	}
 	return fmt.Sprintf("0x%s", hex.EncodeToString(abiEncodedResultsBytes)), nil
 }
+
+// stateAt returns the state committed at the height of the optional height
+// query parameter, or the current state if the parameter is not provided.
+// Only the queries on the committed state are supported, see State.StateAt.
+func (a *API) stateAt(ctx *httprouter.HTTPContext) (*state.State, error) {
+	param := ctx.Request.URL.Query().Get("height")
+	if param == "" {
+		return a.vocapp.State, nil
+	}
+	height, err := strconv.ParseUint(param, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("height (%s) cannot be parsed", param)
+	}
+	return a.vocapp.State.StateAt(uint32(height))
+}
+
+// stateProof returns the encoded StateProof of the leaf with key of the state
+// tree with name treeName.
+func (a *API) stateProof(st *state.State, treeName string, key []byte) ([]byte, error) {
+	proof, err := st.LeafProof(treeName, key)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&StateProof{
+		Tree:         treeName,
+		Key:          key,
+		Height:       proof.Height,
+		BlockHeight:  proof.Height + 1,
+		Root:         proof.Root,
+		Value:        proof.Value,
+		Siblings:     proof.Siblings,
+		TreeRoot:     proof.TreeRoot,
+		TreeSiblings: proof.TreeSiblings,
+	})
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/apirest"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// GET /elections/search?text=<text>&lang=<lang>&organizationId=<id>&status=<status>&page=<page>
+// search the elections by the words of the title, description and questions of
+// their metadata, ranked by relevance.  All the query parameters but text are optional.
+func (a *API) electionSearchHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	params := ctx.Request.URL.Query()
+	page, err := searchPage(params.Get("page"))
+	if err != nil {
+		return err
+	}
+	var organizationID []byte
+	if param := params.Get("organizationId"); param != "" {
+		if organizationID, err = hex.DecodeString(util.TrimHex(param)); err != nil {
+			return fmt.Errorf("organizationId (%q) cannot be decoded", param)
+		}
+		// a rotated organization is searched by its current address
+		if organizationID, err = a.indexer.ResolveAccount(organizationID); err != nil {
+			return fmt.Errorf("cannot resolve organization: %w", err)
+		}
+	}
+	results, err := a.indexer.SearchElections(params.Get("text"), params.Get("lang"),
+		organizationID, strings.ToUpper(params.Get("status")), page*MaxPageSize, MaxPageSize)
+	if err != nil {
+		return fmt.Errorf("cannot search elections: %w", err)
+	}
+	resp := &SearchResults{}
+	for _, r := range results {
+		resp.Elections = append(resp.Elections, &ElectionSearchResult{
+			ElectionID:     r.ID,
+			OrganizationID: r.EntityID,
+			Status:         strings.ToLower(models.ProcessStatus_name[r.Status]),
+			Language:       r.Language,
+			Title:          r.Title,
+			Description:    r.Description,
+			Score:          r.Score,
+		})
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %w", err)
+	}
+	return ctx.Send(data, apirest.HTTPstatusCodeOK)
+}
+
+// GET /accounts/search?text=<text>&lang=<lang>&page=<page>
+// search the organizations by the words of the name and description of their
+// metadata, ranked by relevance.  All the query parameters but text are optional.
+func (a *API) organizationSearchHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	params := ctx.Request.URL.Query()
+	page, err := searchPage(params.Get("page"))
+	if err != nil {
+		return err
+	}
+	results, err := a.indexer.SearchAccounts(params.Get("text"), params.Get("lang"),
+		page*MaxPageSize, MaxPageSize)
+	if err != nil {
+		return fmt.Errorf("cannot search organizations: %w", err)
+	}
+	resp := &SearchResults{}
+	for _, r := range results {
+		resp.Organizations = append(resp.Organizations, &OrganizationSearchResult{
+			OrganizationID: r.ID,
+			Language:       r.Language,
+			Name:           r.Title,
+			Description:    r.Description,
+			Score:          r.Score,
+		})
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %w", err)
+	}
+	return ctx.Send(data, apirest.HTTPstatusCodeOK)
+}
+
+// searchPage parses the optional page query parameter.
+func searchPage(param string) (int, error) {
+	if param == "" {
+		return 0, nil
+	}
+	page, err := strconv.Atoi(param)
+	if err != nil || page < 0 {
+		return 0, fmt.Errorf("cannot parse page number")
+	}
+	return page, nil
+}
@@ -3,17 +3,21 @@ package api
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"go.vocdoni.io/dvote/httprouter"
 	"go.vocdoni.io/dvote/httprouter/apirest"
 	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain/state"
 	"go.vocdoni.io/proto/build/go/models"
 )
 
 const VoteHandler = "votes"
 
+var ErrVoteNotFound = fmt.Errorf("vote not found")
+
 func (a *API) enableVoteHandlers() error {
 	if err := a.endpoint.RegisterMethod(
 		"/votes",
@@ -39,6 +43,14 @@ func (a *API) enableVoteHandlers() error {
 	); err != nil {
 		return err
 	}
+	if err := a.endpoint.RegisterMethod(
+		"/votes/verify/{electionID}/{voteID}/proof",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		a.voteProofHandler,
+	); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -138,3 +150,50 @@ func (a *API) verifyVoteHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContex
 	}
 	return ctx.Send(nil, apirest.HTTPstatusCodeOK)
 }
+
+// /votes/verify/<electionID>/<voteID>/proof
+// get the merkle proof of a vote up to the state root, or 404 if the vote is
+// not found
+func (a *API) voteProofHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	voteID, err := hex.DecodeString(util.TrimHex(ctx.URLParam("voteID")))
+	if err != nil {
+		return fmt.Errorf("cannot decode voteID: %w", err)
+	}
+	if len(voteID) != types.VoteNullifierSize {
+		return fmt.Errorf("malformed voteId")
+	}
+	electionID, err := hex.DecodeString(util.TrimHex(ctx.URLParam("electionID")))
+	if err != nil {
+		return fmt.Errorf("cannot decode electionID: %w", err)
+	}
+	if len(electionID) != types.ProcessIDsize {
+		return fmt.Errorf("malformed electionId")
+	}
+	proof, err := a.vocapp.State.VoteProof(electionID, voteID)
+	if errors.Is(err, state.ErrVoteNotFound) || errors.Is(err, state.ErrProcessNotFound) {
+		data, err := json.Marshal(&apirest.ErrorMsg{Error: ErrVoteNotFound.Error()})
+		if err != nil {
+			return err
+		}
+		return ctx.Send(data, apirest.HTTPstatusCodeNotFound)
+	} else if err != nil {
+		return fmt.Errorf("cannot generate vote proof: %w", err)
+	}
+	data, err := json.Marshal(&VoteProof{
+		ElectionID:        electionID,
+		VoteID:            voteID,
+		Height:            proof.Height,
+		BlockHeight:       proof.Height + 1,
+		Root:              proof.Root,
+		Vote:              proof.Vote,
+		VoteSiblings:      proof.VoteSiblings,
+		Process:           proof.Process,
+		ProcessSiblings:   proof.ProcessSiblings,
+		Processes:         proof.Processes,
+		ProcessesSiblings: proof.ProcessesSiblings,
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, apirest.HTTPstatusCodeOK)
+}
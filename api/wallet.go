@@ -17,6 +17,7 @@ import (
 	"go.vocdoni.io/dvote/httprouter/apirest"
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain/state"
 	"go.vocdoni.io/proto/build/go/models"
 	"google.golang.org/protobuf/proto"
 )
@@ -301,6 +302,8 @@ func (a *API) walletElectionHandler(msg *apirest.APIdata, ctx *httprouter.HTTPCo
 
 	// Set startBlock and endBlock
 	var startBlock uint32
+	// time-bounded elections without start date start on their start block
+	timeBoundsStart := description.StartDate
 	// if start date is empty, do not attempt to parse it. Set startBlock to 0, starting the
 	// election immediately. Otherwise, ensure the startBlock is in the future
 	if !description.StartDate.IsZero() {
@@ -344,6 +347,9 @@ func (a *API) walletElectionHandler(msg *apirest.APIdata, ctx *httprouter.HTTPCo
 		Interruptible: description.ElectionType.Interruptible,
 		DynamicCensus: description.ElectionType.DynamicCensus,
 	}
+	if description.ElectionType.TimeBounded {
+		state.SetProcessTimeBounds(processMode, timeBoundsStart, description.EndDate)
+	}
 
 	// Prepare the election metadata information
 	metadata := ElectionMetadata{
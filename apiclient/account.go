@@ -7,6 +7,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"go.vocdoni.io/dvote/api"
+	"go.vocdoni.io/dvote/crypto/ethereum"
 	"go.vocdoni.io/dvote/data"
 	"go.vocdoni.io/dvote/types"
 	indexertypes "go.vocdoni.io/dvote/vochain/indexer/indexertypes"
@@ -29,6 +30,28 @@ var (
 // Account returns the information about a Vocdoni account. If address is empty, it returns the information
 // about the account associated with the client.
 func (c *HTTPclient) Account(address string) (*api.Account, error) {
+	if c.verifier == nil {
+		return c.getAccount(address)
+	}
+	if address == "" {
+		if c.account == nil {
+			return nil, ErrAccountNotConfigured
+		}
+		address = c.account.AddressString()
+	}
+	var acc *api.Account
+	if err := c.verified(func() (err error) {
+		acc, err = c.getAccount(address)
+		return err
+	}, func() error {
+		return c.verifyAccount(common.HexToAddress(address), acc)
+	}); err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+func (c *HTTPclient) getAccount(address string) (*api.Account, error) {
 	if address == "" {
 		if c.account == nil {
 			return nil, ErrAccountNotConfigured
@@ -194,6 +217,66 @@ func (c *HTTPclient) AccountSetMetadata(metadata *api.AccountMetadata) (types.He
 	return accv.TxHash, nil
 }
 
+// AccountSetMultisig sets the multisig policy of the account, which requires
+// the signatures of threshold of the signers for creating elections or
+// changing their status.  A zero threshold with no signers removes the policy.
+// If the account already has a policy, the co-signers must approve the change.
+func (c *HTTPclient) AccountSetMultisig(threshold uint32,
+	signers []common.Address) (types.HexBytes, error) {
+	acc, err := c.Account("")
+	if err != nil {
+		return nil, fmt.Errorf("account not configured: %w", err)
+	}
+	tx := &models.SetAccountTx{
+		Txtype:    models.TxType_SET_ACCOUNT_MULTISIG,
+		Nonce:     &acc.Nonce,
+		Threshold: &threshold,
+	}
+	for _, signer := range signers {
+		tx.Delegates = append(tx.Delegates, signer.Bytes())
+	}
+	stx := models.SignedTx{}
+	stx.Tx, err = proto.Marshal(&models.Tx{
+		Payload: &models.Tx_SetAccount{SetAccount: tx},
+	})
+	if err != nil {
+		return nil, err
+	}
+	txHash, _, err := c.SignAndSendTx(&stx)
+	return txHash, err
+}
+
+// AccountRotate moves the account to the address of newAccountPrivateKey, which
+// co-signs the transaction.  The balance, nonce, delegates, election index,
+// metadata and multisig policy are kept, and the elections of the account are
+// then managed by the new key.  Once the transaction is mined, the client must
+// be set to the new key with SetAccount.
+func (c *HTTPclient) AccountRotate(newAccountPrivateKey string) (types.HexBytes, error) {
+	newKey := new(ethereum.SignKeys)
+	if err := newKey.AddHexKey(newAccountPrivateKey); err != nil {
+		return nil, err
+	}
+	acc, err := c.Account("")
+	if err != nil {
+		return nil, fmt.Errorf("account not configured: %w", err)
+	}
+	stx := models.SignedTx{}
+	stx.Tx, err = proto.Marshal(&models.Tx{
+		Payload: &models.Tx_SetAccount{SetAccount: &models.SetAccountTx{
+			Txtype:  models.TxType_ROTATE_ACCOUNT,
+			Nonce:   &acc.Nonce,
+			Account: newKey.Address().Bytes(),
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	cosigned := *c
+	cosigned.cosigners = append(append([]*ethereum.SignKeys{}, c.cosigners...), newKey)
+	txHash, _, err := cosigned.SignAndSendTx(&stx)
+	return txHash, err
+}
+
 // GetTransfers returns the list of token transfers associated with an account
 func (c *HTTPclient) GetTransfers(from common.Address, page, pageSize int) ([]*indexertypes.TokenTransferMeta, error) {
 	resp, code, err := c.Request(HTTPGET, nil, "accounts", from.Hex(), "transfers", "page", strconv.Itoa(page))
@@ -209,3 +292,24 @@ func (c *HTTPclient) GetTransfers(from common.Address, page, pageSize int) ([]*i
 	}
 	return transfers, nil
 }
+
+// AccountTransactions returns a page of the transactions signed by an account,
+// or related to the elections of an organization, newest first.
+func (c *HTTPclient) AccountTransactions(address common.Address,
+	page int) ([]*indexertypes.TxReference, error) {
+	resp, code, err := c.Request(HTTPGET, nil, "accounts", address.Hex(), "transactions",
+		"page", strconv.Itoa(page))
+	if err != nil {
+		return nil, err
+	}
+	if code != 200 {
+		return nil, fmt.Errorf("%s: %d (%s)", errCodeNot200, code, resp)
+	}
+	txs := new(struct {
+		Transactions []*indexertypes.TxReference `json:"transactions"`
+	})
+	if err := json.Unmarshal(resp, txs); err != nil {
+		return nil, err
+	}
+	return txs.Transactions, nil
+}
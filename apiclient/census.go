@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/ethereum/go-ethereum/common"
 	"go.vocdoni.io/dvote/api"
 	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/proto/build/go/models"
@@ -52,6 +53,40 @@ func (c *HTTPclient) CensusAddParticipants(censusID types.HexBytes, participants
 	return nil
 }
 
+// CensusUpdate adds, removes and updates the weight of participants of an
+// existing weighted census.  Returns the new root of the census.
+func (c *HTTPclient) CensusUpdate(censusID types.HexBytes, update *api.CensusUpdate) (types.HexBytes, error) {
+	resp, code, err := c.Request("POST", update, "censuses", censusID.String(), "update")
+	if err != nil {
+		return nil, err
+	}
+	if code != 200 {
+		return nil, fmt.Errorf("%s: %d (%s)", errCodeNot200, code, resp)
+	}
+	censusData := &api.Census{}
+	if err := json.Unmarshal(resp, censusData); err != nil {
+		return nil, fmt.Errorf("could not unmarshal response: %w", err)
+	}
+	return censusData.Root, nil
+}
+
+// CensusDiff returns the participants added, updated and removed from a census
+// to a target census, such as two published roots of the same census.
+func (c *HTTPclient) CensusDiff(censusID, targetCensusID types.HexBytes) (*api.CensusDiff, error) {
+	resp, code, err := c.Request("GET", nil, "censuses", censusID.String(), "diff", targetCensusID.String())
+	if err != nil {
+		return nil, err
+	}
+	if code != 200 {
+		return nil, fmt.Errorf("%s: %d (%s)", errCodeNot200, code, resp)
+	}
+	diff := &api.CensusDiff{}
+	if err := json.Unmarshal(resp, diff); err != nil {
+		return nil, fmt.Errorf("could not unmarshal response: %w", err)
+	}
+	return diff, nil
+}
+
 // CensusSize returns the number of participants in a census.
 func (c *HTTPclient) CensusSize(censusID types.HexBytes) (uint64, error) {
 	resp, code, err := c.Request("GET", nil, "censuses", censusID.String(), "size")
@@ -87,6 +122,27 @@ func (c *HTTPclient) CensusPublish(censusID types.HexBytes) (types.HexBytes, str
 	return censusData.CensusID, censusData.URI, nil
 }
 
+// NewERC20Census builds and publishes a weighted census with the holders of an
+// ERC20 token contract at the block, from a snapshot of the given format
+// (censusdb.ERC20FormatTransfers or censusdb.ERC20FormatBalances).  Returns
+// the published census, including its root hash, storage URI and provenance.
+func (c *HTTPclient) NewERC20Census(contract common.Address, block uint64,
+	format string, snapshot []byte) (*api.Census, error) {
+	resp, code, err := c.Request("POST", json.RawMessage(snapshot), "censuses", "erc20",
+		contract.Hex(), fmt.Sprintf("%d", block), format)
+	if err != nil {
+		return nil, err
+	}
+	if code != 200 {
+		return nil, fmt.Errorf("%s: %d (%s)", errCodeNot200, code, resp)
+	}
+	censusData := &api.Census{}
+	if err := json.Unmarshal(resp, censusData); err != nil {
+		return nil, fmt.Errorf("could not unmarshal response: %w", err)
+	}
+	return censusData, nil
+}
+
 // CensusGenProof generates a proof for a voter in a census. The voterKey is the public key or address of the voter.
 func (c *HTTPclient) CensusGenProof(censusID, voterKey types.HexBytes) (*CensusProof, error) {
 	resp, code, err := c.Request("GET", nil, "censuses", censusID.String(), "proof", voterKey.String())
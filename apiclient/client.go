@@ -36,6 +36,11 @@ type HTTPclient struct {
 	addr    *url.URL
 	account *ethereum.SignKeys
 	chainID string
+	// cosigners also sign the transactions, for multisig accounts
+	cosigners []*ethereum.SignKeys
+	// verifier verifies the responses against the trusted block headers,
+	// if the verified mode is enabled
+	verifier *lightVerifier
 }
 
 // NewHTTPclient creates a new HTTP(s) API Vocdoni client.
@@ -77,10 +82,26 @@ func (c *HTTPclient) SetAccount(accountPrivateKey string) error {
 	return c.account.AddHexKey(accountPrivateKey)
 }
 
+// SetCosigners sets the keys which co-sign the transactions of the account,
+// required by accounts with a multisig policy.
+func (c *HTTPclient) SetCosigners(privateKeys ...string) error {
+	cosigners := []*ethereum.SignKeys{}
+	for _, key := range privateKeys {
+		cosigner := new(ethereum.SignKeys)
+		if err := cosigner.AddHexKey(key); err != nil {
+			return err
+		}
+		cosigners = append(cosigners, cosigner)
+	}
+	c.cosigners = cosigners
+	return nil
+}
+
 // Clone returns a copy of the HTTPclient with the accountPrivateKey set as the account key.
 // Panics if the accountPrivateKey is not valid.
 func (c *HTTPclient) Clone(accountPrivateKey string) *HTTPclient {
 	clone := *c
+	clone.cosigners = nil
 	clone.account = new(ethereum.SignKeys)
 	if err := clone.account.AddHexKey(accountPrivateKey); err != nil {
 		panic(err)
@@ -120,6 +141,13 @@ func (c *HTTPclient) SetHostAddr(addr *url.URL) error {
 // Method is either GET or POST. If POST, a JSON struct should be attached.  Returns the response,
 // the status code and an error.
 func (c *HTTPclient) Request(method string, jsonBody any, urlPath ...string) ([]byte, int, error) {
+	return c.requestWithQuery(method, jsonBody, nil, urlPath...)
+}
+
+// requestWithQuery performs a request as Request does, adding the query
+// parameters to the URL.
+func (c *HTTPclient) requestWithQuery(method string, jsonBody any, query url.Values,
+	urlPath ...string) ([]byte, int, error) {
 	body, err := json.Marshal(jsonBody)
 	if err != nil {
 		return nil, 0, err
@@ -129,6 +157,9 @@ func (c *HTTPclient) Request(method string, jsonBody any, urlPath ...string) ([]
 		return nil, 0, err
 	}
 	u.Path = path.Join(u.Path, path.Join(urlPath...))
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
 	headers := http.Header{}
 	if c.token != nil {
 		headers = http.Header{
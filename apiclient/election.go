@@ -3,6 +3,8 @@ package apiclient
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"strconv"
 	"time"
 
 	"go.vocdoni.io/dvote/api"
@@ -10,13 +12,41 @@ import (
 	"go.vocdoni.io/dvote/httprouter/apirest"
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/vochain/indexer/indexertypes"
+	"go.vocdoni.io/dvote/vochain/state"
 	"go.vocdoni.io/proto/build/go/models"
 	"google.golang.org/protobuf/proto"
 )
 
 // Election returns the election details given its ID.
 func (c *HTTPclient) Election(electionID types.HexBytes) (*api.Election, error) {
-	resp, code, err := c.Request("GET", nil, "elections", electionID.String())
+	if c.verifier == nil {
+		return c.getElection(electionID, nil)
+	}
+	var election *api.Election
+	var leaf *models.StateDBProcess
+	if err := c.verified(func() error {
+		var height uint32
+		var err error
+		if leaf, height, err = c.verifiedProcess(electionID); err != nil {
+			return err
+		}
+		// the election is requested at the height of the proof, so that its
+		// status and vote count are read from the same state version
+		election, err = c.getElection(electionID,
+			url.Values{"height": []string{strconv.FormatUint(uint64(height), 10)}})
+		return err
+	}, func() error {
+		return verifyElection(electionID, election, leaf)
+	}); err != nil {
+		return nil, err
+	}
+	return election, nil
+}
+
+func (c *HTTPclient) getElection(electionID types.HexBytes,
+	query url.Values) (*api.Election, error) {
+	resp, code, err := c.requestWithQuery("GET", nil, query, "elections", electionID.String())
 	if err != nil {
 		return nil, err
 	}
@@ -52,15 +82,7 @@ func (c *HTTPclient) NewElectionRaw(process *models.Process) (types.HexBytes, er
 	if err != nil {
 		return nil, err
 	}
-	signedTxb, err := c.account.SignVocdoniTx(txb, c.chainID)
-	if err != nil {
-		return nil, err
-	}
-	stx, err := proto.Marshal(
-		&models.SignedTx{
-			Tx:        txb,
-			Signature: signedTxb,
-		})
+	stx, err := c.signTx(txb)
 	if err != nil {
 		return nil, err
 	}
@@ -129,6 +151,9 @@ func (c *HTTPclient) NewElection(description *api.ElectionDescription) (types.He
 		Interruptible: description.ElectionType.Interruptible,
 		DynamicCensus: description.ElectionType.DynamicCensus,
 	}
+	if description.ElectionType.TimeBounded {
+		state.SetProcessTimeBounds(processMode, description.StartDate, description.EndDate)
+	}
 
 	// Prepare the election metadata information
 	metadata := api.ElectionMetadata{
@@ -225,15 +250,7 @@ func (c *HTTPclient) NewElection(description *api.ElectionDescription) (types.He
 	if err != nil {
 		return nil, err
 	}
-	signedTxb, err := c.account.SignVocdoniTx(txb, c.chainID)
-	if err != nil {
-		return nil, err
-	}
-	stx, err := proto.Marshal(
-		&models.SignedTx{
-			Tx:        txb,
-			Signature: signedTxb,
-		})
+	stx, err := c.signTx(txb)
 	if err != nil {
 		return nil, err
 	}
@@ -299,15 +316,7 @@ func (c *HTTPclient) SetElectionStatus(electionID types.HexBytes, status string)
 	if err != nil {
 		return nil, err
 	}
-	signedTxb, err := c.account.SignVocdoniTx(txb, c.chainID)
-	if err != nil {
-		return nil, err
-	}
-	stx, err := proto.Marshal(
-		&models.SignedTx{
-			Tx:        txb,
-			Signature: signedTxb,
-		})
+	stx, err := c.signTx(txb)
 	if err != nil {
 		return nil, err
 	}
@@ -348,8 +357,45 @@ func (c *HTTPclient) ElectionVoteCount(electionID types.HexBytes) (uint32, error
 	return votes.Count, nil
 }
 
+// ElectionTransactions returns a page of the transactions related to an
+// election, newest first.
+func (c *HTTPclient) ElectionTransactions(electionID types.HexBytes,
+	page int) ([]*indexertypes.TxReference, error) {
+	resp, code, err := c.Request("GET", nil, "elections", electionID.String(), "transactions",
+		"page", strconv.Itoa(page))
+	if err != nil {
+		return nil, err
+	}
+	if code != apirest.HTTPstatusCodeOK {
+		return nil, fmt.Errorf("%s: %d (%s)", errCodeNot200, code, resp)
+	}
+	txs := new(struct {
+		Transactions []*indexertypes.TxReference `json:"transactions"`
+	})
+	if err := json.Unmarshal(resp, txs); err != nil {
+		return nil, err
+	}
+	return txs.Transactions, nil
+}
+
 // ElectionResults returns the election results given its ID.
 func (c *HTTPclient) ElectionResults(electionID types.HexBytes) (*api.ElectionResults, error) {
+	if c.verifier == nil {
+		return c.getElectionResults(electionID)
+	}
+	var results *api.ElectionResults
+	if err := c.verified(func() (err error) {
+		results, err = c.getElectionResults(electionID)
+		return err
+	}, func() error {
+		return c.verifyElectionResults(electionID, results)
+	}); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (c *HTTPclient) getElectionResults(electionID types.HexBytes) (*api.ElectionResults, error) {
 	resp, code, err := c.Request("GET", nil, "elections", electionID.String(), "scrutiny")
 	if err != nil {
 		return nil, err
@@ -56,11 +56,7 @@ func (c *HTTPclient) DateToHeight(date time.Time) (uint32, error) {
 }
 
 func (c *HTTPclient) SignAndSendTx(stx *models.SignedTx) (types.HexBytes, []byte, error) {
-	var err error
-	if stx.Signature, err = c.account.SignVocdoniTx(stx.Tx, c.ChainID()); err != nil {
-		return nil, nil, err
-	}
-	txData, err := proto.Marshal(stx)
+	txData, err := c.signTx(stx.Tx)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -79,6 +75,24 @@ func (c *HTTPclient) SignAndSendTx(stx *models.SignedTx) (types.HexBytes, []byte
 	return tx.Hash, tx.Response, nil
 }
 
+// signTx signs the transaction with the account and the co-signers, and
+// returns the encoded models.SignedTx.
+func (c *HTTPclient) signTx(txb []byte) ([]byte, error) {
+	var err error
+	stx := &models.SignedTx{Tx: txb}
+	if stx.Signature, err = c.account.SignVocdoniTx(txb, c.chainID); err != nil {
+		return nil, err
+	}
+	for _, cosigner := range c.cosigners {
+		signature, err := cosigner.SignVocdoniTx(txb, c.chainID)
+		if err != nil {
+			return nil, err
+		}
+		stx.Signatures = append(stx.Signatures, signature)
+	}
+	return proto.Marshal(stx)
+}
+
 // WaitUntilHeight waits until the given height is reached and returns nil.
 //
 // If ctx.Done() is reached, returns ctx.Err() instead.
@@ -0,0 +1,369 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	tmmath "github.com/tendermint/tendermint/libs/math"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+	tmtypes "github.com/tendermint/tendermint/types"
+	"go.vocdoni.io/dvote/api"
+	"go.vocdoni.io/dvote/httprouter/apirest"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/vochain/state"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// verifyRetries is the number of times a verified query is retried when
+	// the response does not match the proof, since a new block may be
+	// committed between both requests.
+	verifyRetries = 3
+	// verifyTimeout is the maximum time to wait for the block with the
+	// AppHash of a proof.
+	verifyTimeout = 30 * time.Second
+	// maxClockDrift is the maximum time a block header can be ahead of the
+	// local clock.
+	maxClockDrift = 10 * time.Second
+
+	// DefaultTrustingPeriod is the default time the trusted validator set is
+	// trusted for, since the last header verified with it.  It must be
+	// shorter than the unbonding period of the validators.
+	DefaultTrustingPeriod = 7 * 24 * time.Hour
+)
+
+// ErrNotVerified is returned by the verified queries when the gateway
+// response does not match the state proof.
+var ErrNotVerified = fmt.Errorf("response does not match the state proof")
+
+// lightVerifier tracks the tendermint headers signed by a trusted validator
+// set, as a light client.
+type lightVerifier struct {
+	lock sync.Mutex
+	// validators is the last trusted validator set
+	validators *tmtypes.ValidatorSet
+	// trustedHeight is the height of the last header verified with
+	// validators, or zero if none was verified yet.  The trusted validator
+	// set only moves forward, so older headers are verified against it but
+	// do not replace it.
+	trustedHeight int64
+	// trustedTime is the time of the last header verified with validators,
+	// or the time they were set as trusted.
+	trustedTime time.Time
+	// trustingPeriod is the time validators are trusted for since
+	// trustedTime.
+	trustingPeriod time.Duration
+	// appHashes are the verified AppHashes by height
+	appHashes map[uint32][]byte
+}
+
+// SetTrustedValidators enables the verified mode of the client.  Then the
+// Account, Election and ElectionResults queries request a state proof of the
+// response, and reject it if the proof does not verify against the AppHash of
+// a block header signed by the trusted validators.  The validator set changes
+// are followed while at least 1/3 of the trusted voting power signs them.
+// The validators are trusted for DefaultTrustingPeriod since the last verified
+// header, see SetTrustingPeriod.
+func (c *HTTPclient) SetTrustedValidators(validators *tmtypes.ValidatorSet) error {
+	if err := validators.ValidateBasic(); err != nil {
+		return err
+	}
+	c.verifier = &lightVerifier{
+		validators:     validators,
+		trustedTime:    time.Now(),
+		trustingPeriod: DefaultTrustingPeriod,
+		appHashes:      make(map[uint32][]byte),
+	}
+	return nil
+}
+
+// SetTrustingPeriod sets the time the trusted validators are trusted for,
+// since the last header verified with them.  Once it expires, the verified
+// queries fail until SetTrustedValidators is called again.
+func (c *HTTPclient) SetTrustingPeriod(period time.Duration) error {
+	if c.verifier == nil {
+		return fmt.Errorf("verified mode not enabled")
+	}
+	if period <= 0 {
+		return fmt.Errorf("invalid trusting period %s", period)
+	}
+	c.verifier.lock.Lock()
+	defer c.verifier.lock.Unlock()
+	c.verifier.trustingPeriod = period
+	return nil
+}
+
+// VerifiedAppHash returns the AppHash of the block header at height, verified
+// against the trusted validator set.
+func (c *HTTPclient) VerifiedAppHash(height uint32) ([]byte, error) {
+	if c.verifier == nil {
+		return nil, fmt.Errorf("verified mode not enabled")
+	}
+	c.verifier.lock.Lock()
+	defer c.verifier.lock.Unlock()
+	if appHash, ok := c.verifier.appHashes[height]; ok {
+		return appHash, nil
+	}
+	resp, code, err := c.Request(HTTPGET, nil, "chain", "blocks",
+		strconv.FormatUint(uint64(height), 10), "light")
+	if err != nil {
+		return nil, err
+	}
+	if code != apirest.HTTPstatusCodeOK {
+		return nil, fmt.Errorf("%s: %d (%s)", errCodeNot200, code, resp)
+	}
+	block := &api.LightBlock{}
+	if err := json.Unmarshal(resp, block); err != nil {
+		return nil, fmt.Errorf("could not unmarshal response: %w", err)
+	}
+	lightBlockProto := &tmproto.LightBlock{}
+	if err := lightBlockProto.Unmarshal(block.LightBlock); err != nil {
+		return nil, fmt.Errorf("could not unmarshal light block: %w", err)
+	}
+	lightBlock, err := tmtypes.LightBlockFromProto(lightBlockProto)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.verifier.verify(lightBlock, c.chainID, int64(height)); err != nil {
+		return nil, fmt.Errorf("cannot verify block %d: %w", height, err)
+	}
+	c.verifier.appHashes[height] = lightBlock.AppHash
+	return lightBlock.AppHash, nil
+}
+
+// verify checks the light block is signed by the trusted validators, while
+// they are within the trusting period.  If its validator set is not the
+// trusted one, it must be signed by at least 1/3 of the trusted voting power
+// and by 2/3 of its own.  The validator set of the header becomes trusted
+// only if it is not older than the last verified one, so the trusted set is
+// never moved back by verifying an older height.
+func (v *lightVerifier) verify(lightBlock *tmtypes.LightBlock, chainID string, height int64) error {
+	now := time.Now()
+	if expires := v.trustedTime.Add(v.trustingPeriod); !now.Before(expires) {
+		return fmt.Errorf("trusted validators expired at %s", expires)
+	}
+	if err := lightBlock.ValidateBasic(chainID); err != nil {
+		return err
+	}
+	if lightBlock.Height != height {
+		return fmt.Errorf("unexpected height %d", lightBlock.Height)
+	}
+	if lightBlock.Time.After(now.Add(maxClockDrift)) {
+		return fmt.Errorf("block time %s is in the future", lightBlock.Time)
+	}
+	commit := lightBlock.Commit
+	if bytes.Equal(lightBlock.ValidatorSet.Hash(), v.validators.Hash()) {
+		if err := v.validators.VerifyCommitLight(chainID, commit.BlockID,
+			height, commit); err != nil {
+			return err
+		}
+	} else {
+		if err := v.validators.VerifyCommitLightTrusting(chainID, commit,
+			tmmath.Fraction{Numerator: 1, Denominator: 3}); err != nil {
+			return err
+		}
+		if err := lightBlock.ValidatorSet.VerifyCommitLight(chainID, commit.BlockID,
+			height, commit); err != nil {
+			return err
+		}
+	}
+	if height < v.trustedHeight {
+		return nil
+	}
+	v.validators = lightBlock.ValidatorSet
+	v.trustedHeight = height
+	if lightBlock.Time.After(v.trustedTime) {
+		v.trustedTime = lightBlock.Time
+	}
+	return nil
+}
+
+// verifiedStateProof requests the state proof of urlPath and verifies it
+// against the AppHash of the next block, waiting for it if required.
+func (c *HTTPclient) verifiedStateProof(treeName string, key []byte,
+	urlPath ...string) (*api.StateProof, error) {
+	resp, code, err := c.Request(HTTPGET, nil, urlPath...)
+	if err != nil {
+		return nil, err
+	}
+	if code != apirest.HTTPstatusCodeOK {
+		return nil, fmt.Errorf("%s: %d (%s)", errCodeNot200, code, resp)
+	}
+	proof := &api.StateProof{}
+	if err := json.Unmarshal(resp, proof); err != nil {
+		return nil, fmt.Errorf("could not unmarshal response: %w", err)
+	}
+	if proof.Tree != treeName || !bytes.Equal(proof.Key, key) {
+		return nil, fmt.Errorf("unexpected proof of %s %x", proof.Tree, proof.Key)
+	}
+	if err := (&state.LeafProof{
+		Height:       proof.Height,
+		Root:         proof.Root,
+		Value:        proof.Value,
+		Siblings:     proof.Siblings,
+		TreeRoot:     proof.TreeRoot,
+		TreeSiblings: proof.TreeSiblings,
+	}).Verify(treeName, key); err != nil {
+		return nil, err
+	}
+	appHash, err := c.VerifiedAppHash(proof.Height + 1)
+	if err != nil {
+		// the block with the AppHash may not be produced yet
+		ctx, cancel := context.WithTimeout(context.Background(), verifyTimeout)
+		defer cancel()
+		if err := c.WaitUntilHeight(ctx, proof.Height+1); err != nil {
+			return nil, err
+		}
+		if appHash, err = c.VerifiedAppHash(proof.Height + 1); err != nil {
+			return nil, err
+		}
+	}
+	if !bytes.Equal(appHash, proof.Root) {
+		return nil, fmt.Errorf("state root %x does not match block %d app hash %x",
+			proof.Root, proof.Height+1, appHash)
+	}
+	return proof, nil
+}
+
+// verifyAccount checks the account matches the state proof of address.
+func (c *HTTPclient) verifyAccount(address common.Address, acc *api.Account) error {
+	if !bytes.Equal(acc.Address, address.Bytes()) {
+		return ErrNotVerified
+	}
+	proof, err := c.verifiedStateProof(state.TreeAccounts, address.Bytes(),
+		"accounts", address.Hex(), "proof")
+	if err != nil {
+		return err
+	}
+	leaf := &models.Account{}
+	if err := proto.Unmarshal(proof.Value, leaf); err != nil {
+		return fmt.Errorf("cannot unmarshal account: %w", err)
+	}
+	if acc.Nonce != leaf.Nonce || acc.Balance != leaf.Balance ||
+		acc.ElectionIndex != leaf.ProcessIndex || acc.InfoURL != leaf.InfoURI {
+		return ErrNotVerified
+	}
+	return nil
+}
+
+// verifiedProcess returns the process leaf of the election state proof, and
+// the state height of the proof.
+func (c *HTTPclient) verifiedProcess(
+	electionID types.HexBytes) (*models.StateDBProcess, uint32, error) {
+	proof, err := c.verifiedStateProof(state.TreeProcess, electionID,
+		"elections", electionID.String(), "proof")
+	if err != nil {
+		return nil, 0, err
+	}
+	leaf := &models.StateDBProcess{}
+	if err := proto.Unmarshal(proof.Value, leaf); err != nil {
+		return nil, 0, fmt.Errorf("cannot unmarshal election: %w", err)
+	}
+	if leaf.Process == nil {
+		return nil, 0, fmt.Errorf("election proof without process")
+	}
+	return leaf, proof.Height, nil
+}
+
+// verifyElection checks the election matches the process leaf of its state
+// proof.  The vote count is not part of the leaf, so it is only checked to be
+// non-zero when the votes tree of the process is not empty.
+func verifyElection(electionID types.HexBytes, election *api.Election,
+	leaf *models.StateDBProcess) error {
+	process := leaf.Process
+	if !bytes.Equal(election.ElectionID, electionID) {
+		return ErrNotVerified
+	}
+	if election.MetadataURL != process.GetMetadata() || election.Census == nil {
+		return ErrNotVerified
+	}
+	if election.Census.CensusOrigin != process.CensusOrigin.String() ||
+		!bytes.Equal(election.Census.CensusRoot, process.CensusRoot) ||
+		election.Census.CensusURL != process.GetCensusURI() {
+		return ErrNotVerified
+	}
+	if election.Status != process.Status.String() ||
+		election.StartBlock != process.StartBlock ||
+		election.EndBlock != process.StartBlock+process.BlockCount {
+		return ErrNotVerified
+	}
+	envelopeType := election.VoteMode.EnvelopeType
+	if envelopeType == nil {
+		envelopeType = &models.EnvelopeType{}
+	}
+	processEnvelopeType := process.EnvelopeType
+	if processEnvelopeType == nil {
+		processEnvelopeType = &models.EnvelopeType{}
+	}
+	if !proto.Equal(envelopeType, processEnvelopeType) {
+		return ErrNotVerified
+	}
+	emptyVotes := bytes.Equal(leaf.VotesRoot, make([]byte, len(leaf.VotesRoot)))
+	if election.VoteCount == 0 && !emptyVotes {
+		return ErrNotVerified
+	}
+	return nil
+}
+
+// verifyElectionResults checks the election results match the state proof of
+// electionID.
+func (c *HTTPclient) verifyElectionResults(electionID types.HexBytes,
+	results *api.ElectionResults) error {
+	if !bytes.Equal(results.ElectionID, electionID) {
+		return ErrNotVerified
+	}
+	leaf, _, err := c.verifiedProcess(electionID)
+	if err != nil {
+		return err
+	}
+	process := leaf.Process
+	if !bytes.Equal(results.OrganizationID, process.EntityId) ||
+		!bytes.Equal(results.CensusRoot, process.CensusRoot) {
+		return ErrNotVerified
+	}
+	processResults := state.LastProcessResults(process)
+	if processResults == nil {
+		if len(results.Results) > 0 {
+			return ErrNotVerified
+		}
+		return nil
+	}
+	votes := processResults.Votes
+	if len(votes) != len(results.Results) {
+		return ErrNotVerified
+	}
+	for i, question := range votes {
+		if len(question.Question) != len(results.Results[i]) {
+			return ErrNotVerified
+		}
+		for j, option := range question.Question {
+			if results.Results[i][j] == nil ||
+				new(big.Int).SetBytes(option).Cmp(results.Results[i][j]) != 0 {
+				return ErrNotVerified
+			}
+		}
+	}
+	return nil
+}
+
+// verified runs the query and its verification, retrying when the response
+// does not match the proof.
+func (c *HTTPclient) verified(query func() error, verify func() error) error {
+	for i := 0; ; i++ {
+		if err := query(); err != nil {
+			return err
+		}
+		err := verify()
+		if err != ErrNotVerified || i == verifyRetries-1 {
+			return err
+		}
+	}
+}
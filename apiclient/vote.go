@@ -1,6 +1,8 @@
 package apiclient
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 
@@ -9,16 +11,26 @@ import (
 	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/dvote/util"
 	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/state"
 	"go.vocdoni.io/proto/build/go/models"
 	"google.golang.org/protobuf/proto"
 )
 
+var (
+	// ErrVoteNotFound is returned when the vote is not found in the state.
+	ErrVoteNotFound = fmt.Errorf("vote not found")
+)
+
 // VoteData contains the data needed to create a vote.
 //
 // Choices is a list of choices, where each position represents a question.
 // ElectionID is the ID of the election.
 // ProofMkTree is the proof of the vote for a off chain tree, weighted election.
 // ProofCSP is the proof of the vote fore a CSP election.
+// Delegate, if set, is the address of the census member the vote is delegated
+// to, instead of voting the Choices.  The delegate must be proven to belong to
+// the census with DelegateProofMkTree or DelegateProofCSP, and censuses keyed
+// by public key also require DelegatePublicKey.
 //
 // KeyType is the type of the key used when the census was created. It can be
 // either models.ProofArbo_ADDRESS or models.ProofArbo_PUBKEY (default).
@@ -28,11 +40,17 @@ type VoteData struct {
 
 	ProofMkTree *CensusProof
 	ProofCSP    types.HexBytes
+
+	Delegate            types.HexBytes
+	DelegateProofMkTree *CensusProof
+	DelegateProofCSP    types.HexBytes
+	DelegatePublicKey   types.HexBytes
 }
 
 // Vote sends a vote to the Vochain. The vote is a VoteData struct,
 // which contains the electionID, the choices and the proof.  The
-// return value is the voteID (nullifier).
+// return value is the voteID (nullifier).  For delegations, it is the voteID
+// the vote would have, which is not stored unless the delegator votes later.
 func (c *HTTPclient) Vote(v *VoteData) (types.HexBytes, error) {
 	votePackage := &vochain.VotePackage{
 		Votes: v.Choices,
@@ -46,27 +64,17 @@ func (c *HTTPclient) Vote(v *VoteData) (types.HexBytes, error) {
 		ProcessId:   v.ElectionID,
 		VotePackage: votePackageBytes,
 	}
-
 	// Build the proof
-	switch {
-	case v.ProofMkTree != nil:
-		vote.Proof = &models.Proof{
-			Payload: &models.Proof_Arbo{
-				Arbo: &models.ProofArbo{
-					Type:     models.ProofArbo_BLAKE2B,
-					Siblings: v.ProofMkTree.Proof,
-					Value:    v.ProofMkTree.Value,
-					KeyType:  v.ProofMkTree.KeyType,
-				},
-			},
-		}
-	case v.ProofCSP != nil:
-		p := models.ProofCA{}
-		if err := proto.Unmarshal(v.ProofCSP, &p); err != nil {
-			return nil, fmt.Errorf("could not decode CSP proof: %w", err)
-		}
-		vote.Proof = &models.Proof{
-			Payload: &models.Proof_Ca{Ca: &p},
+	if vote.Proof, err = buildProof(v.ProofMkTree, v.ProofCSP); err != nil {
+		return nil, err
+	}
+	if v.Delegate != nil {
+		vote.VotePackage = nil
+		vote.Delegate = v.Delegate
+		vote.DelegatePublicKey = v.DelegatePublicKey
+		if vote.DelegateProof, err = buildProof(v.DelegateProofMkTree,
+			v.DelegateProofCSP); err != nil {
+			return nil, fmt.Errorf("delegate: %w", err)
 		}
 	}
 
@@ -105,6 +113,33 @@ func (c *HTTPclient) Vote(v *VoteData) (types.HexBytes, error) {
 	return voteAPI.VoteID, nil
 }
 
+// buildProof returns the census proof for a off chain tree or a CSP election,
+// or nil if none is provided.
+func buildProof(proofMkTree *CensusProof, proofCSP types.HexBytes) (*models.Proof, error) {
+	switch {
+	case proofMkTree != nil:
+		return &models.Proof{
+			Payload: &models.Proof_Arbo{
+				Arbo: &models.ProofArbo{
+					Type:     models.ProofArbo_BLAKE2B,
+					Siblings: proofMkTree.Proof,
+					Value:    proofMkTree.Value,
+					KeyType:  proofMkTree.KeyType,
+				},
+			},
+		}, nil
+	case proofCSP != nil:
+		p := models.ProofCA{}
+		if err := proto.Unmarshal(proofCSP, &p); err != nil {
+			return nil, fmt.Errorf("could not decode CSP proof: %w", err)
+		}
+		return &models.Proof{
+			Payload: &models.Proof_Ca{Ca: &p},
+		}, nil
+	}
+	return nil, nil
+}
+
 // Verify verifies a vote. The voteID is the nullifier of the vote.
 func (c *HTTPclient) Verify(electionID, voteID types.HexBytes) (bool, error) {
 	resp, code, err := c.Request("GET", nil, "votes", "verify", electionID.String(), voteID.String())
@@ -119,3 +154,67 @@ func (c *HTTPclient) Verify(electionID, voteID types.HexBytes) (bool, error) {
 	}
 	return false, fmt.Errorf("%s: %d (%s)", errCodeNot200, code, resp)
 }
+
+// VoteProof returns the merkle proof of a vote in the state, and verifies it
+// locally: the proof chain from the vote up to the state root, and the state
+// root against the AppHash of the block header.  If the block is not yet
+// produced, it waits for it until the context is done.  The voteID is the
+// nullifier of the vote.  ErrVoteNotFound is returned if the vote is not in
+// the state.
+func (c *HTTPclient) VoteProof(ctx context.Context,
+	electionID, voteID types.HexBytes) (*api.VoteProof, error) {
+	resp, code, err := c.Request(HTTPGET, nil, "votes", "verify",
+		electionID.String(), voteID.String(), "proof")
+	if err != nil {
+		return nil, err
+	}
+	if code == apirest.HTTPstatusCodeNotFound {
+		return nil, ErrVoteNotFound
+	}
+	if code != apirest.HTTPstatusCodeOK {
+		return nil, fmt.Errorf("%s: %d (%s)", errCodeNot200, code, resp)
+	}
+	proof := &api.VoteProof{}
+	if err := json.Unmarshal(resp, proof); err != nil {
+		return nil, fmt.Errorf("could not unmarshal response: %w", err)
+	}
+	if err := (&state.VoteProof{
+		Height:            proof.Height,
+		Root:              proof.Root,
+		Vote:              proof.Vote,
+		VoteSiblings:      proof.VoteSiblings,
+		Process:           proof.Process,
+		ProcessSiblings:   proof.ProcessSiblings,
+		Processes:         proof.Processes,
+		ProcessesSiblings: proof.ProcessesSiblings,
+	}).Verify(electionID, voteID); err != nil {
+		return nil, err
+	}
+	if proof.BlockHeight != proof.Height+1 {
+		return nil, fmt.Errorf("unexpected block height %d for state height %d",
+			proof.BlockHeight, proof.Height)
+	}
+	if err := c.WaitUntilHeight(ctx, proof.BlockHeight); err != nil {
+		return nil, err
+	}
+	resp, code, err = c.Request(HTTPGET, nil, "chain", "blocks", fmt.Sprintf("%d", proof.BlockHeight))
+	if err != nil {
+		return nil, err
+	}
+	if code != apirest.HTTPstatusCodeOK {
+		return nil, fmt.Errorf("%s: %d (%s)", errCodeNot200, code, resp)
+	}
+	block := &struct {
+		Header struct {
+			AppHash types.HexBytes `json:"appHash"`
+		} `json:"header"`
+	}{}
+	if err := json.Unmarshal(resp, block); err != nil {
+		return nil, fmt.Errorf("could not unmarshal block: %w", err)
+	}
+	if !bytes.Equal(block.Header.AppHash, proof.Root) {
+		return nil, fmt.Errorf("state root %x does not match block %d app hash %x",
+			proof.Root, proof.BlockHeight, block.Header.AppHash)
+	}
+	return proof, nil
+}
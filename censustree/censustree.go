@@ -252,21 +252,27 @@ func (t *Tree) IterateLeaves(callback func(key, value []byte) bool) error {
 }
 
 func (t *Tree) updateCensusWeight(wTx db.WriteTx, w []byte) error {
+	return t.addCensusWeight(wTx, t.BytesToBigInt(w))
+}
+
+// addCensusWeight adds delta, which can be negative, to the census weight.
+func (t *Tree) addCensusWeight(wTx db.WriteTx, delta *big.Int) error {
 	t.updatesLock.Lock()
 	defer t.updatesLock.Unlock()
 	weightBytes, err := wTx.Get(censusWeightKey)
 	if err != nil && !errors.Is(err, db.ErrKeyNotFound) {
 		return fmt.Errorf("could not get census weight: %w", err)
 	}
-	weight := new(big.Int).Add(t.BytesToBigInt(weightBytes), t.BytesToBigInt(w))
+	weight := new(big.Int).Add(t.BytesToBigInt(weightBytes), delta)
 	if err := wTx.Set(censusWeightKey, t.BigIntToBytes(weight)); err != nil {
 		return fmt.Errorf("could not set census weight: %w", err)
 	}
 	return nil
 }
 
-// updateCensusIndex increments the census tree index by delta and return the previous value.
-func (t *Tree) updateCensusIndex(wTx db.WriteTx, delta uint32) (uint64, error) {
+// updateCensusIndex increments the census tree index by delta, which can be
+// negative, and return the previous value.
+func (t *Tree) updateCensusIndex(wTx db.WriteTx, delta int64) (uint64, error) {
 	t.updatesLock.Lock()
 	defer t.updatesLock.Unlock()
 	indexBytes, err := wTx.Get(censusIndexKey)
@@ -277,7 +283,7 @@ func (t *Tree) updateCensusIndex(wTx db.WriteTx, delta uint32) (uint64, error) {
 	if indexBytes != nil {
 		currentIndex = t.BytesToBigInt(indexBytes)
 	}
-	index := new(big.Int).Add(currentIndex, big.NewInt(int64(delta)))
+	index := new(big.Int).Add(currentIndex, big.NewInt(delta))
 	if err := wTx.Set(censusIndexKey, t.BigIntToBytes(index)); err != nil {
 		return 0, fmt.Errorf("could not set census index: %w", err)
 	}
@@ -324,7 +330,7 @@ func (t *Tree) AddBatch(keys, values [][]byte) ([]int, error) {
 	}
 
 	// update the census index
-	if _, err := t.updateCensusIndex(wTx, uint32(len(keys)-len(invalids))); err != nil {
+	if _, err := t.updateCensusIndex(wTx, int64(len(keys)-len(invalids))); err != nil {
 		return nil, err
 	}
 
@@ -353,6 +359,80 @@ func (t *Tree) AddBatch(keys, values [][]byte) ([]int, error) {
 	return invalids, wTx.Commit()
 }
 
+// Changes holds the leaves to add, update and remove from a census tree.
+type Changes struct {
+	AddKeys      [][]byte
+	AddValues    [][]byte
+	UpdateKeys   [][]byte
+	UpdateValues [][]byte
+	RemoveKeys   [][]byte
+}
+
+// ApplyChanges removes, updates and adds the given leaves in a single write
+// transaction while acquiring the lock, so either all the changes are applied
+// or none of them is.  The census weight and size are updated accordingly.
+// It is not available for indexAsKeys censuses.
+func (t *Tree) ApplyChanges(changes *Changes) error {
+	t.Lock()
+	defer t.Unlock()
+	if t.indexAsKeysCensus {
+		return fmt.Errorf("index as keys is enabled for this census tree, leaves cannot be changed")
+	}
+	if len(changes.AddKeys) != len(changes.AddValues) ||
+		len(changes.UpdateKeys) != len(changes.UpdateValues) {
+		return fmt.Errorf("keys and values length mismatch")
+	}
+	wTx := t.tree.DB().WriteTx()
+	defer wTx.Discard()
+
+	delta := big.NewInt(0)
+	for _, key := range changes.RemoveKeys {
+		oldValue, err := t.tree.Get(wTx, key)
+		if err != nil {
+			return fmt.Errorf("cannot remove (%x) from census: %w", key, err)
+		}
+		delta.Sub(delta, t.BytesToBigInt(oldValue))
+	}
+	if len(changes.RemoveKeys) > 0 {
+		if err := t.tree.DelBatch(wTx, changes.RemoveKeys); err != nil {
+			return fmt.Errorf("cannot remove leaves from census: %w", err)
+		}
+	}
+	for i, key := range changes.UpdateKeys {
+		oldValue, err := t.tree.Get(wTx, key)
+		if err != nil {
+			return fmt.Errorf("cannot update (%x) in census: %w", key, err)
+		}
+		if err := t.tree.Set(wTx, key, changes.UpdateValues[i]); err != nil {
+			return fmt.Errorf("cannot update (%x) in census: %w", key, err)
+		}
+		delta.Add(delta, t.BytesToBigInt(changes.UpdateValues[i]))
+		delta.Sub(delta, t.BytesToBigInt(oldValue))
+	}
+	if len(changes.AddKeys) > 0 {
+		invalids, err := t.tree.AddBatch(wTx, changes.AddKeys, changes.AddValues)
+		if err != nil {
+			return fmt.Errorf("addBatch failed: %w", err)
+		}
+		if len(invalids) > 0 {
+			return fmt.Errorf("cannot add (%x) to census", changes.AddKeys[invalids[0]])
+		}
+		for _, value := range changes.AddValues {
+			delta.Add(delta, t.BytesToBigInt(value))
+		}
+	}
+
+	// the census index holds the number of leaves of non indexed censuses
+	if _, err := t.updateCensusIndex(wTx,
+		int64(len(changes.AddKeys))-int64(len(changes.RemoveKeys))); err != nil {
+		return err
+	}
+	if err := t.addCensusWeight(wTx, delta); err != nil {
+		return err
+	}
+	return wTx.Commit()
+}
+
 // Add adds a new key and value to the census merkle tree.
 // The key must ideally be hashed with the tree function. If not, caller must
 // ensure the key is inside the hashing function field.
@@ -521,7 +601,7 @@ func (t *Tree) fillKeyToIndex(tx db.WriteTx) error {
 	if err != nil {
 		return err
 	}
-	if _, err := t.updateCensusIndex(tx, uint32(len(indexKeys)-int(currentIndex))); err != nil {
+	if _, err := t.updateCensusIndex(tx, int64(len(indexKeys)-int(currentIndex))); err != nil {
 		return err
 	}
 	return nil
@@ -364,3 +364,85 @@ func TestGetCensusWeight(t *testing.T) {
 	qt.Assert(t, err, qt.IsNil)
 	qt.Assert(t, w.String(), qt.Equals, "11457") // same than in the original tree
 }
+
+func TestApplyChanges(t *testing.T) {
+	newTree := func(name string) *Tree {
+		censusTree, err := New(Options{Name: name, ParentDB: metadb.NewTest(t), MaxLevels: 256,
+			CensusType: models.Census_ARBO_BLAKE2B})
+		qt.Assert(t, err, qt.IsNil)
+		return censusTree
+	}
+	censusTree := newTree("test")
+
+	rnd := testutil.NewRandom(0)
+	var keys, values [][]byte
+	for i := 1; i < 13; i++ {
+		h, err := arbo.HashFunctionBlake2b.Hash(rnd.RandomBytes(32))
+		qt.Assert(t, err, qt.IsNil)
+		keys = append(keys, h)
+		values = append(values, censusTree.BigIntToBytes(big.NewInt(int64(i))))
+	}
+	invalids, err := censusTree.AddBatch(keys[:10], values[:10])
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, invalids, qt.HasLen, 0)
+	root, err := censusTree.Root()
+	qt.Assert(t, err, qt.IsNil)
+
+	// a failing change does not modify the census
+	unknown, err := arbo.HashFunctionBlake2b.Hash(rnd.RandomBytes(32))
+	qt.Assert(t, err, qt.IsNil)
+	err = censusTree.ApplyChanges(&Changes{
+		AddKeys:    keys[10:],
+		AddValues:  values[10:],
+		RemoveKeys: [][]byte{keys[2], unknown},
+	})
+	qt.Assert(t, err, qt.ErrorMatches, "cannot remove.*")
+	root2, err := censusTree.Root()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, root2, qt.DeepEquals, root)
+	_, err = censusTree.Get(keys[2])
+	qt.Assert(t, err, qt.IsNil)
+
+	// update the weight of the first two keys (1 -> 10 and 2 -> 1), remove
+	// the third and fourth ones (3 and 4) and add two more (11 and 12)
+	newValues := [][]byte{censusTree.BigIntToBytes(big.NewInt(10)), censusTree.BigIntToBytes(big.NewInt(1))}
+	err = censusTree.ApplyChanges(&Changes{
+		AddKeys:      keys[10:],
+		AddValues:    values[10:],
+		UpdateKeys:   keys[:2],
+		UpdateValues: newValues,
+		RemoveKeys:   keys[2:4],
+	})
+	qt.Assert(t, err, qt.IsNil)
+
+	value, err := censusTree.Get(keys[0])
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, censusTree.BytesToBigInt(value).Int64(), qt.Equals, int64(10))
+	_, err = censusTree.Get(keys[2])
+	qt.Assert(t, err, qt.ErrorIs, arbo.ErrKeyNotFound)
+
+	// the weight is 55 + (10 - 1) + (1 - 2) - 3 - 4 + 11 + 12
+	weight, err := censusTree.GetCensusWeight()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, weight.Int64(), qt.Equals, int64(79))
+	size, err := censusTree.Size()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, size, qt.Equals, uint64(10))
+	index, err := censusTree.GetCensusIndex()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, index, qt.Equals, uint32(10))
+
+	// the root is the same as the one of a census built from scratch
+	expected := newTree("expected")
+	invalids, err = expected.AddBatch(keys[:2], newValues)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, invalids, qt.HasLen, 0)
+	invalids, err = expected.AddBatch(keys[4:], values[4:])
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, invalids, qt.HasLen, 0)
+	root, err = censusTree.Root()
+	qt.Assert(t, err, qt.IsNil)
+	expectedRoot, err := expected.Root()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, root, qt.DeepEquals, expectedRoot)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	flag "github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/csp"
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/db/metadb"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/apirest"
+	"go.vocdoni.io/dvote/log"
+)
+
+// keyFile is the file of the data directory holding the CSP private key, so
+// the CSP public key (the census root of the elections) survives restarts.
+const keyFile = "csp.key"
+
+func main() {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		panic(err)
+	}
+	flag.String("dataDir", filepath.Join(home, ".csp"), "storage data directory")
+	flag.String("logLevel", "info", "log level (debug, info, warn, error)")
+	flag.String("key", "",
+		"CSP private hexadecimal key, if empty it is read from or generated on the data directory")
+	flag.String("dbType", db.TypePebble, "database type (pebble, badger)")
+	flag.String("listenHost", "0.0.0.0", "API endpoint listen address")
+	flag.Int("listenPort", 5000, "API endpoint http port")
+	flag.String("urlPath", "/v1", "HTTP path for the CSP API")
+	flag.String("allowlist", "",
+		"file with the voters allowed, one 'identifier:secret' per line, enables the allowlist handler")
+	flag.Bool("mockSMS", false,
+		"enable the sms OTP handler, logging the messages instead of sending them")
+	flag.Bool("mockEmail", false,
+		"enable the email OTP handler, logging the messages instead of sending them")
+	flag.StringSlice("oauthStubTokens", []string{},
+		"access tokens of the OAuth stub handler (token:user,token:user,...), enables it")
+	flag.CommandLine.SortFlags = false
+	flag.Parse()
+
+	viper.SetEnvPrefix("CSP")
+	viper.AutomaticEnv()
+	if err := viper.BindPFlags(flag.CommandLine); err != nil {
+		panic(err)
+	}
+	log.Init(viper.GetString("logLevel"), "stdout")
+	dataDir := viper.GetString("dataDir")
+	if err := os.MkdirAll(dataDir, os.ModePerm); err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("using data directory at %s", dataDir)
+
+	signer, err := loadKey(dataDir, viper.GetString("key"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	database, err := metadb.New(viper.GetString("dbType"), filepath.Join(dataDir, "db"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer database.Close()
+
+	c := csp.New(signer, database)
+	if path := viper.GetString("allowlist"); path != "" {
+		voters, err := readAllowlist(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("allowlist handler enabled with %d voters", len(voters))
+		c.AddAuthHandler(csp.NewAllowlistHandler(voters))
+	}
+	if viper.GetBool("mockSMS") {
+		c.AddAuthHandler(csp.NewOTPHandler("sms", &csp.MockSender{}))
+	}
+	if viper.GetBool("mockEmail") {
+		c.AddAuthHandler(csp.NewOTPHandler("email", &csp.MockSender{}))
+	}
+	if tokens := viper.GetStringSlice("oauthStubTokens"); len(tokens) > 0 {
+		stub := &csp.OAuthStub{}
+		for _, t := range tokens {
+			token, user, ok := strings.Cut(t, ":")
+			if !ok {
+				log.Fatalf("invalid OAuth stub token %s, please specify token:user", t)
+			}
+			stub.AddToken(token, user)
+		}
+		c.AddAuthHandler(csp.NewOAuthHandler(stub))
+	}
+	if len(c.AuthHandlers()) == 0 {
+		log.Fatal("no auth handler enabled")
+	}
+
+	router := httprouter.HTTProuter{}
+	if err := router.Init(viper.GetString("listenHost"), viper.GetInt("listenPort")); err != nil {
+		log.Fatal(err)
+	}
+	api, err := apirest.NewAPI(&router, viper.GetString("urlPath"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := csp.AttachCSPAPI(c, api, ""); err != nil {
+		log.Fatal(err)
+	}
+	log.Infow("csp started", map[string]interface{}{
+		"publicKey": fmt.Sprintf("%x", c.PublicKey()),
+		"handlers":  c.AuthHandlers(),
+	})
+
+	// remove the auth tokens which expired without being used
+	go func() {
+		ticker := time.NewTicker(csp.AuthTokenExpiration)
+		defer ticker.Stop()
+		for range ticker.C {
+			n, err := c.PruneTokens()
+			if err != nil {
+				log.Warnf("cannot prune expired auth tokens: %v", err)
+			} else if n > 0 {
+				log.Debugf("pruned %d expired auth tokens", n)
+			}
+		}
+	}()
+
+	// wait for SIGTERM
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	<-ch
+	log.Warnf("received SIGTERM, exiting at %s", time.Now().Format(time.RFC850))
+}
+
+// loadKey returns the CSP signing key.  If no key is provided, it is read
+// from the data directory, where a new one is generated the first time.
+func loadKey(dataDir, privKey string) (*ethereum.SignKeys, error) {
+	signer := ethereum.NewSignKeys()
+	path := filepath.Join(dataDir, keyFile)
+	if privKey == "" {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			if err := signer.Generate(); err != nil {
+				return nil, err
+			}
+			_, priv := signer.HexString()
+			log.Infof("generated new CSP key at %s", path)
+			return signer, os.WriteFile(path, []byte(priv), 0o600)
+		} else if err != nil {
+			return nil, err
+		}
+		privKey = strings.TrimSpace(string(data))
+	}
+	if err := signer.AddHexKey(privKey); err != nil {
+		return nil, fmt.Errorf("cannot import CSP key: %w", err)
+	}
+	return signer, nil
+}
+
+// readAllowlist reads the allowlist file, with one 'identifier:secret' per line.
+func readAllowlist(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	voters := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		voter, secret, ok := strings.Cut(line, ":")
+		if !ok || voter == "" || secret == "" {
+			return nil, fmt.Errorf("invalid allowlist line %q", line)
+		}
+		voters[voter] = secret
+	}
+	return voters, scanner.Err()
+}
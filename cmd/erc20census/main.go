@@ -0,0 +1,135 @@
+// Command erc20census builds a weighted census with the holders of an ERC20
+// token at a given block, from a dump of the token Transfer event logs (as
+// returned by eth_getLogs) or from a JSON object mapping each holder address
+// to its balance.  The census keys are the holder addresses, so the census can
+// be used by elections with census origin OFF_CHAIN_TREE_WEIGHTED.
+//
+// If an API URL is provided, the snapshot is sent to the node, which builds,
+// publishes and records the provenance of the census.  Otherwise the census
+// is built locally and written as a census dump, which can be imported with
+// the /censuses/{censusID}/import API endpoint.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	flag "github.com/spf13/pflag"
+	"go.vocdoni.io/dvote/api/censusdb"
+	"go.vocdoni.io/dvote/apiclient"
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/db/metadb"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// result is the output of the command.
+type result struct {
+	CensusID   types.HexBytes             `json:"censusID"`
+	URI        string                     `json:"uri,omitempty"`
+	Provenance *censusdb.CensusProvenance `json:"provenance"`
+}
+
+func main() {
+	var contract, format, input, apiURL, output, dbType, logLevel string
+	var block uint64
+	flag.StringVar(&contract, "contract", "", "ERC20 token contract address")
+	flag.Uint64Var(&block, "block", 0, "block number of the snapshot")
+	flag.StringVar(&format, "format", censusdb.ERC20FormatTransfers,
+		"snapshot format [transfers,balances]")
+	flag.StringVar(&input, "input", "", "snapshot file")
+	flag.StringVar(&apiURL, "apiUrl", "",
+		"vocdoni API URL to build and publish the census, built locally if empty")
+	flag.StringVar(&output, "output", "census.json",
+		"file to write the census dump to when built locally")
+	flag.StringVar(&dbType, "dbType", db.TypePebble, "database type used to build the census")
+	flag.StringVar(&logLevel, "logLevel", "info", "log level [error,warn,info,debug]")
+	flag.Parse()
+	log.Init(logLevel, "stderr")
+
+	if !common.IsHexAddress(contract) {
+		log.Fatalf("invalid contract address %q", contract)
+	}
+	if block == 0 {
+		log.Fatal("the block number is required")
+	}
+	snapshot, err := os.ReadFile(input)
+	if err != nil {
+		log.Fatalf("cannot read snapshot: %v", err)
+	}
+
+	var res *result
+	if apiURL != "" {
+		res, err = publishCensus(apiURL, common.HexToAddress(contract), block, format, snapshot)
+	} else {
+		res, err = buildCensus(dbType, output, common.HexToAddress(contract), block, format, snapshot)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(data))
+}
+
+// publishCensus sends the snapshot to the API, which builds and publishes the census.
+func publishCensus(apiURL string, contract common.Address, block uint64, format string,
+	snapshot []byte) (*result, error) {
+	addr, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, err
+	}
+	client, err := apiclient.NewHTTPclient(addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	census, err := client.NewERC20Census(contract, block, format, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return &result{CensusID: census.CensusID, URI: census.URI, Provenance: census.Provenance}, nil
+}
+
+// buildCensus builds the census on a temporary database and writes its dump to output.
+func buildCensus(dbType, output string, contract common.Address, block uint64, format string,
+	snapshot []byte) (*result, error) {
+	dir, err := os.MkdirTemp("", "erc20census")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	database, err := metadb.New(dbType, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer database.Close()
+	ref, provenance, err := censusdb.NewCensusDB(database).NewERC20Census(util.RandomBytes(32),
+		nil, contract, block, format, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	root, err := ref.Tree().Root()
+	if err != nil {
+		return nil, err
+	}
+	dump, err := ref.Tree().Dump()
+	if err != nil {
+		return nil, err
+	}
+	data, err := censusdb.BuildExportDump(root, dump, models.Census_ARBO_BLAKE2B, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(output, data, 0o644); err != nil {
+		return nil, err
+	}
+	log.Infof("census dump written to %s", output)
+	return &result{CensusID: root, Provenance: provenance}, nil
+}
@@ -142,6 +142,10 @@ func newConfig() (*config.Config, config.Error) {
 		"vochain consensus block time target (in seconds)")
 	globalCfg.Vochain.KeyKeeperIndex = *flag.Int8("keyKeeperIndex", 0,
 		"index slot used by this node if it is a key keeper")
+	globalCfg.Vochain.KeyKeeperThreshold = *flag.Int("keyKeeperThreshold", 0,
+		"number of key keeper shares required to reconstruct a key (0 disables sharing)")
+	globalCfg.Vochain.KeyKeeperCommittee = *flag.StringSlice("keyKeeperCommittee", []string{},
+		"key keepers sharing their keys, as index:publicKey (including this node)")
 	globalCfg.Vochain.ImportPreviousCensus = *flag.Bool("importPreviousCensus", false,
 		"if enabled the census downloader will import all existing census")
 	globalCfg.Vochain.ProcessArchive = *flag.Bool("processArchive", false,
@@ -150,6 +154,26 @@ func newConfig() (*config.Config, config.Error) {
 		"IPFS base64 encoded private key for process archive IPNS")
 	globalCfg.Vochain.OffChainDataDownloader = *flag.Bool("offChainDataDownload", true,
 		"enables the off-chain data downloader component")
+	globalCfg.Vochain.SnapshotInterval = *flag.Uint32("vochainSnapshotInterval", 0,
+		"create a state snapshot every N blocks, to be served to state sync peers (0 disables)")
+	globalCfg.Vochain.SnapshotKeep = *flag.Uint32("vochainSnapshotKeep", 2,
+		"number of the most recent state snapshots kept on disk (0 keeps all)")
+	globalCfg.Vochain.StateSyncRPCServers = *flag.StringSlice("vochainStateSyncRPCServers", []string{},
+		"comma-separated list of (at least two) tendermint RPC servers to enable state sync")
+	globalCfg.Vochain.StateSyncTrustHeight = *flag.Int64("vochainStateSyncTrustHeight", 0,
+		"height of the trusted block for state sync")
+	globalCfg.Vochain.StateSyncTrustHash = *flag.String("vochainStateSyncTrustHash", "",
+		"hash of the trusted block for state sync")
+	globalCfg.Vochain.StateHistory = *flag.Uint32("vochainStateHistory", 0,
+		"number of past state versions which can be queried by height, "+
+			"without pruning the older ones from the database (0 allows all)")
+	globalCfg.Vochain.VotesPruning = *flag.Uint32("vochainVotesPruning", 0,
+		"prune the votes of an election from the state N blocks after its results (0 disables), "+
+			"which disables its vote proofs and the vote weights of the indexer rebuilds")
+	globalCfg.Vochain.Archive = *flag.Bool("vochainArchive", false,
+		"archival node, keeping all the state (disables the pruning and the history retention)")
+	globalCfg.Vochain.Indexer.Rebuild = *flag.Bool("vochainIndexerRebuild", false,
+		"wipe the indexer and index again the blocks of the local blockstore")
 	flag.StringVar(&createVochainGenesisFile, "vochainCreateGenesis", "",
 		"create a genesis file for the vochain with validators and exit"+
 			" (syntax <dir>:<numValidators>)")
@@ -223,11 +247,22 @@ func newConfig() (*config.Config, config.Error) {
 	viper.BindPFlag("vochain.MempoolSize", flag.Lookup("vochainMempoolSize"))
 	viper.BindPFlag("vochain.MinerTargetBlockTimeSeconds", flag.Lookup("vochainBlockTime"))
 	viper.BindPFlag("vochain.KeyKeeperIndex", flag.Lookup("keyKeeperIndex"))
+	viper.BindPFlag("vochain.KeyKeeperThreshold", flag.Lookup("keyKeeperThreshold"))
+	viper.BindPFlag("vochain.KeyKeeperCommittee", flag.Lookup("keyKeeperCommittee"))
 	viper.BindPFlag("vochain.ImportPreviousCensus", flag.Lookup("importPreviousCensus"))
 	viper.Set("vochain.ProcessArchiveDataDir", globalCfg.DataDir+"/archive")
 	viper.BindPFlag("vochain.ProcessArchive", flag.Lookup("processArchive"))
 	viper.BindPFlag("vochain.ProcessArchiveKey", flag.Lookup("processArchiveKey"))
 	viper.BindPFlag("vochain.OffChainDataDownload", flag.Lookup("offChainDataDownload"))
+	viper.BindPFlag("vochain.SnapshotInterval", flag.Lookup("vochainSnapshotInterval"))
+	viper.BindPFlag("vochain.SnapshotKeep", flag.Lookup("vochainSnapshotKeep"))
+	viper.BindPFlag("vochain.StateSyncRPCServers", flag.Lookup("vochainStateSyncRPCServers"))
+	viper.BindPFlag("vochain.StateSyncTrustHeight", flag.Lookup("vochainStateSyncTrustHeight"))
+	viper.BindPFlag("vochain.StateSyncTrustHash", flag.Lookup("vochainStateSyncTrustHash"))
+	viper.BindPFlag("vochain.StateHistory", flag.Lookup("vochainStateHistory"))
+	viper.BindPFlag("vochain.VotesPruning", flag.Lookup("vochainVotesPruning"))
+	viper.BindPFlag("vochain.Archive", flag.Lookup("vochainArchive"))
+	viper.BindPFlag("vochain.Indexer.Rebuild", flag.Lookup("vochainIndexerRebuild"))
 
 	// metrics
 	viper.BindPFlag("metrics.Enabled", flag.Lookup("metricsEnabled"))
@@ -506,6 +541,16 @@ func main() {
 			if err != nil {
 				log.Fatal(err)
 			}
+			if globalCfg.Vochain.KeyKeeperThreshold > 0 {
+				committee, err := keykeeper.ParseCommittee(globalCfg.Vochain.KeyKeeperCommittee)
+				if err != nil {
+					log.Fatal(err)
+				}
+				if err := vochainKeykeeper.EnableThreshold(
+					globalCfg.Vochain.KeyKeeperThreshold, committee); err != nil {
+					log.Fatal(err)
+				}
+			}
 			go vochainKeykeeper.RevealUnpublished()
 		}
 	}
@@ -543,6 +588,7 @@ func main() {
 				urlapi.WalletHandler,
 				urlapi.AccountHandler,
 				urlapi.CensusHandler,
+				urlapi.EventsHandler,
 			); err != nil {
 				log.Fatal(err)
 			}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/google/orderedcode"
+	tmstate "github.com/tendermint/tendermint/proto/tendermint/state"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+	tmtypes "github.com/tendermint/tendermint/types"
+	tmdb "github.com/tendermint/tm-db"
+)
+
+// The tendermint block and state store packages are internal, so the blocks
+// and their results are read directly from their databases using the same key
+// encoding.
+const (
+	prefixBlockMeta     = int64(0)
+	prefixBlockPart     = int64(1)
+	prefixABCIResponses = int64(7)
+)
+
+// blockStore is a read-only tendermint block store, along with the state
+// store holding the results of the block transactions.
+type blockStore struct {
+	db    tmdb.DB
+	state tmdb.DB
+}
+
+func openBlockStore(dir, backend string) (*blockStore, error) {
+	db, err := tmdb.NewDB("blockstore", tmdb.BackendType(backend), dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open block store: %w", err)
+	}
+	state, err := tmdb.NewDB("state", tmdb.BackendType(backend), dir)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot open state store: %w", err)
+	}
+	return &blockStore{db: db, state: state}, nil
+}
+
+func (bs *blockStore) close() error {
+	if err := bs.state.Close(); err != nil {
+		return err
+	}
+	return bs.db.Close()
+}
+
+func blockMetaKey(height int64) []byte {
+	key, err := orderedcode.Append(nil, prefixBlockMeta, height)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func blockPartKey(height int64, partIndex int) []byte {
+	key, err := orderedcode.Append(nil, prefixBlockPart, height, int64(partIndex))
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func abciResponsesKey(height int64) []byte {
+	key, err := orderedcode.Append(nil, prefixABCIResponses, height)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// heightRange returns the lowest and highest heights of the stored blocks.
+func (bs *blockStore) heightRange() (int64, int64, error) {
+	heightAt := func(iter tmdb.Iterator, err error) (int64, error) {
+		if err != nil {
+			return 0, err
+		}
+		defer iter.Close()
+		if !iter.Valid() {
+			return 0, fmt.Errorf("block store is empty")
+		}
+		var prefix, height int64
+		if _, err := orderedcode.Parse(string(iter.Key()), &prefix, &height); err != nil {
+			return 0, err
+		}
+		return height, nil
+	}
+	base, err := heightAt(bs.db.Iterator(blockMetaKey(1), blockMetaKey(1<<63-1)))
+	if err != nil {
+		return 0, 0, err
+	}
+	height, err := heightAt(bs.db.ReverseIterator(blockMetaKey(1), blockMetaKey(1<<63-1)))
+	if err != nil {
+		return 0, 0, err
+	}
+	return base, height, nil
+}
+
+// block loads the block at the given height, by joining its parts.
+func (bs *blockStore) block(height int64) (*tmtypes.Block, error) {
+	data, err := bs.db.Get(blockMetaKey(height))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("block %d not found", height)
+	}
+	meta := new(tmproto.BlockMeta)
+	if err := meta.Unmarshal(data); err != nil {
+		return nil, fmt.Errorf("cannot decode block %d meta: %w", height, err)
+	}
+	buf := []byte{}
+	for i := 0; i < int(meta.BlockID.PartSetHeader.Total); i++ {
+		data, err := bs.db.Get(blockPartKey(height, i))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			return nil, fmt.Errorf("block %d part %d not found", height, i)
+		}
+		part := new(tmproto.Part)
+		if err := part.Unmarshal(data); err != nil {
+			return nil, fmt.Errorf("cannot decode block %d part %d: %w", height, i, err)
+		}
+		buf = append(buf, part.Bytes...)
+	}
+	pbb := new(tmproto.Block)
+	if err := pbb.Unmarshal(buf); err != nil {
+		return nil, fmt.Errorf("cannot decode block %d: %w", height, err)
+	}
+	return tmtypes.BlockFromProto(pbb)
+}
+
+// results returns the DeliverTx result code of each transaction of the block
+// at the given height.
+func (bs *blockStore) results(height int64) ([]uint32, error) {
+	data, err := bs.state.Get(abciResponsesKey(height))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("block %d results not found", height)
+	}
+	responses := new(tmstate.ABCIResponses)
+	if err := responses.Unmarshal(data); err != nil {
+		return nil, fmt.Errorf("cannot decode block %d results: %w", height, err)
+	}
+	codes := make([]uint32, len(responses.DeliverTxs))
+	for i, r := range responses.DeliverTxs {
+		codes[i] = r.GetCode()
+	}
+	return codes, nil
+}
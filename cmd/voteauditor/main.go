@@ -0,0 +1,287 @@
+// Command voteauditor re-verifies the votes of an election and compares the
+// computed results with the ones published on-chain, producing a signed audit
+// report.  The transactions are read either from the tendermint block store of
+// a vochain node (which should be stopped), along with their results from the
+// state store, or from a dump file.
+//
+// The dump file uses the JSON Lines format, one transaction per line:
+//
+//	{"height": 1234, "time": 1672531200, "index": 0, "code": 0, "tx": "<hex encoded models.SignedTx>"}
+//
+// The time is the block header time as unix seconds, required to verify the
+// votes of elections bounded by dates.  The code is the DeliverTx result code
+// of the transaction; the transactions that failed (a non-zero code) are not
+// applied.
+//
+// The oracles authorized to publish the election keys are read from the
+// genesis file of the node, or from the one given with --genesis.
+//
+// The transactions of a dump must be sorted as they were included on the
+// blockchain, and must include the transactions creating the election and
+// publishing its keys and results.
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/common"
+	flag "github.com/spf13/pflag"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/auditor"
+	vocdoniGenesis "go.vocdoni.io/dvote/vochain/genesis"
+)
+
+// maxDumpLineSize is the maximum size of a dump file line.
+const maxDumpLineSize = 1 << 22
+
+// dumpTx is a transaction of a dump file.
+type dumpTx struct {
+	Height uint32         `json:"height"`
+	Time   int64          `json:"time,omitempty"`
+	Index  int32          `json:"index"`
+	Code   uint32         `json:"code,omitempty"`
+	Tx     types.HexBytes `json:"tx"`
+}
+
+func main() {
+	var dataDir, chain, dbBackend, dumpFile, chainID, electionID, genesisFile,
+		signingKey, output, logLevel string
+	var fromHeight, toHeight int64
+	home, err := os.UserHomeDir()
+	if err != nil {
+		log.Fatalf("cannot get user home directory with error: %v", err)
+	}
+	flag.StringVar(&chain, "chain", "stage", "chain [stage,dev,prod]")
+	flag.StringVar(&dataDir, "dataDir", "",
+		"vochain data directory (absolute path), defaults to ~/.dvote/<chain>/vochain")
+	flag.StringVar(&dbBackend, "dbBackend", "goleveldb", "tendermint block store database backend")
+	flag.StringVar(&dumpFile, "txs", "",
+		"read the transactions from a JSON Lines dump file instead of the block store")
+	flag.StringVar(&chainID, "chainId", "",
+		"vochain chain ID, required when reading a dump file")
+	flag.StringVar(&electionID, "electionId", "", "election ID to audit, as hexadecimal string")
+	flag.StringVar(&genesisFile, "genesis", "",
+		"genesis file, defaults to the one of the data directory or the chain")
+	flag.Int64Var(&fromHeight, "fromHeight", 0, "first block height to read (0 for the first stored)")
+	flag.Int64Var(&toHeight, "toHeight", 0, "last block height to read (0 for the last stored)")
+	flag.StringVar(&signingKey, "signingKey", "",
+		"hex encoded private key used to sign the report, a random one is used if empty")
+	flag.StringVar(&output, "output", "", "file to write the signed report to, stdout if empty")
+	flag.StringVar(&logLevel, "logLevel", "info", "log level [error,warn,info,debug]")
+	flag.Parse()
+	log.Init(logLevel, "stderr")
+
+	if dataDir == "" {
+		dataDir = filepath.Join(home, ".dvote", chain, "vochain")
+	}
+	eid, err := hex.DecodeString(util.TrimHex(electionID))
+	if err != nil || len(eid) != types.ProcessIDsize {
+		log.Fatalf("invalid election ID %q", electionID)
+	}
+	signer := ethereum.NewSignKeys()
+	if signingKey != "" {
+		err = signer.AddHexKey(signingKey)
+	} else {
+		log.Warn("no signing key provided, using a random one")
+		err = signer.Generate()
+	}
+	if err != nil {
+		log.Fatalf("cannot load signing key: %v", err)
+	}
+
+	oracles, err := loadOracles(genesisFile, dataDir, chain)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if oracles == nil {
+		log.Warn("no genesis found, the keykeepers cannot be verified")
+	}
+
+	var report *auditor.Report
+	if dumpFile != "" {
+		if chainID == "" {
+			log.Fatal("the chain ID is required to read a dump file")
+		}
+		report, err = auditDump(dumpFile, chainID, eid, oracles)
+	} else {
+		path := filepath.Join(dataDir, "data")
+		log.Infof("opening block store at %s", path)
+		report, err = auditBlockStore(path, dbBackend, chainID, eid, oracles, fromHeight, toHeight)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infow("audit finished", map[string]interface{}{
+		"envelopes":    report.Envelopes,
+		"validVotes":   report.ValidVotes,
+		"invalidVotes": report.InvalidVotes,
+		"resultsMatch": report.ResultsMatch,
+	})
+
+	signed, err := report.Sign(signer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	data, err := json.MarshalIndent(signed, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if output == "" {
+		fmt.Println(string(data))
+		return
+	}
+	if err := os.WriteFile(output, data, 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("signed audit report written to %s by %s", output, signer.AddressString())
+}
+
+// loadOracles returns the oracles of the genesis file, or the ones of the
+// data directory or the chain if the file is empty.  It returns nil if no
+// genesis is found.
+func loadOracles(file, dataDir, chain string) ([]common.Address, error) {
+	var data []byte
+	if file != "" {
+		var err error
+		if data, err = os.ReadFile(file); err != nil {
+			return nil, err
+		}
+	} else if d, err := os.ReadFile(filepath.Join(dataDir, "config", "genesis.json")); err == nil {
+		data = d
+	} else if g, ok := vocdoniGenesis.Genesis[chain]; ok {
+		data = []byte(g.Genesis)
+	} else {
+		return nil, nil
+	}
+	genesis := &vochain.GenesisDoc{}
+	if err := json.Unmarshal(data, genesis); err != nil {
+		return nil, fmt.Errorf("cannot decode genesis: %w", err)
+	}
+	appState := &vochain.GenesisAppState{}
+	if err := json.Unmarshal(genesis.AppState, appState); err != nil {
+		return nil, fmt.Errorf("cannot decode genesis app state: %w", err)
+	}
+	oracles := []common.Address{}
+	for _, o := range appState.Oracles {
+		oracles = append(oracles, common.BytesToAddress(o))
+	}
+	return oracles, nil
+}
+
+// auditBlockStore audits the election with the transactions of the blocks
+// found on a tendermint block store.
+func auditBlockStore(dir, backend, chainID string, electionID []byte,
+	oracles []common.Address, fromHeight, toHeight int64) (*auditor.Report, error) {
+	bs, err := openBlockStore(dir, backend)
+	if err != nil {
+		return nil, err
+	}
+	defer bs.close()
+	base, height, err := bs.heightRange()
+	if err != nil {
+		return nil, err
+	}
+	if fromHeight < base {
+		fromHeight = base
+	}
+	if toHeight == 0 || toHeight > height {
+		toHeight = height
+	}
+	log.Infof("reading blocks from %d to %d", fromHeight, toHeight)
+
+	var audit *auditor.Auditor
+	for h := fromHeight; h <= toHeight; h++ {
+		block, err := bs.block(h)
+		if err != nil {
+			return nil, err
+		}
+		codes, err := bs.results(h)
+		if err != nil {
+			return nil, err
+		}
+		if len(codes) != len(block.Txs) {
+			return nil, fmt.Errorf("block %d has %d transactions but %d results",
+				h, len(block.Txs), len(codes))
+		}
+		if audit == nil {
+			if chainID == "" {
+				chainID = block.ChainID
+			} else if chainID != block.ChainID {
+				return nil, fmt.Errorf("chain ID mismatch, the block store chain ID is %s",
+					block.ChainID)
+			}
+			if audit, err = auditor.New(chainID, electionID); err != nil {
+				return nil, err
+			}
+			if oracles != nil {
+				audit.SetOracles(oracles)
+			}
+		}
+		for i, tx := range block.Txs {
+			if err := audit.AddTx(uint32(h), block.Time.Unix(), int32(i), codes[i], tx); err != nil {
+				log.Warn(err)
+			}
+		}
+		if h%10000 == 0 {
+			log.Infof("read block %d", h)
+		}
+	}
+	if audit == nil {
+		return nil, fmt.Errorf("no blocks found")
+	}
+	return audit.Report()
+}
+
+// auditDump audits the election with the transactions of a dump file.
+func auditDump(file, chainID string, electionID []byte,
+	oracles []common.Address) (*auditor.Report, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	audit, err := auditor.New(chainID, electionID)
+	if err != nil {
+		return nil, err
+	}
+	if oracles != nil {
+		audit.SetOracles(oracles)
+	}
+	if err := readDump(f, func(tx *dumpTx) {
+		if err := audit.AddTx(tx.Height, tx.Time, tx.Index, tx.Code, tx.Tx); err != nil {
+			log.Warn(err)
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return audit.Report()
+}
+
+// readDump calls add for every transaction of a JSON Lines dump.
+func readDump(r io.Reader, add func(*dumpTx)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), maxDumpLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		tx := &dumpTx{}
+		if err := json.Unmarshal(scanner.Bytes(), tx); err != nil {
+			return fmt.Errorf("cannot decode line %d: %w", line, err)
+		}
+		add(tx)
+	}
+	return scanner.Err()
+}
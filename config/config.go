@@ -136,6 +136,12 @@ type VochainCfg struct {
 	MempoolSize int
 	// KeyKeeperIndex is the index used by the key keeper (usually and oracle)
 	KeyKeeperIndex int8
+	// KeyKeeperThreshold is the number of shares required to reconstruct the
+	// key of a key keeper which does not reveal it (0 disables the threshold mode)
+	KeyKeeperThreshold int
+	// KeyKeeperCommittee is the list of key keepers sharing their keys, with
+	// the format index:publicKey (including this one)
+	KeyKeeperCommittee []string
 	// ImportPreviousCensus if true the census downloader will try to download
 	// all census (not only the new ones)
 	ImportPreviousCensus bool
@@ -155,6 +161,31 @@ type VochainCfg struct {
 	IsSeedNode bool
 	// OffChainDataDownload specifies if the node is configured to download off-chain data
 	OffChainDataDownloader bool
+	// SnapshotInterval is the number of blocks between state snapshots, which are
+	// served to the nodes performing state sync (0 disables the snapshots)
+	SnapshotInterval uint32
+	// SnapshotKeep is the number of the most recent state snapshots kept on
+	// disk, the older ones are removed (0 keeps all of them)
+	SnapshotKeep uint32
+	// StateSyncRPCServers are the tendermint RPC servers used by the light client for
+	// verifying the state sync snapshots (at least two are required to enable state sync)
+	StateSyncRPCServers []string
+	// StateSyncTrustHeight is the height of the trusted block used for state sync
+	StateSyncTrustHeight int64
+	// StateSyncTrustHash is the hash of the trusted block used for state sync
+	StateSyncTrustHash string
+	// StateHistory is the number of past state versions which can be queried
+	// by height (0 allows all of them).  It only limits the queries, the old
+	// versions are not pruned from the database.
+	StateHistory uint32
+	// VotesPruning is the number of blocks after the results of an election
+	// when its votes are pruned from the state (0 disables the pruning).
+	// The pruned votes have no inclusion proofs, and the indexer rebuilds
+	// index them without weight.
+	VotesPruning uint32
+	// Archive specifies if the node keeps all the state, which disables the
+	// votes pruning and the state history retention
+	Archive bool
 }
 
 // IndexerCfg handles the configuration options of the indexer
@@ -163,6 +194,8 @@ type IndexerCfg struct {
 	Enabled bool
 	// Disables live results computation on indexer
 	IgnoreLiveResults bool
+	// Rebuild wipes the indexer and indexes the blocks of the local blockstore again
+	Rebuild bool
 }
 
 // OracleCfg includes all possible config params needed by the Oracle
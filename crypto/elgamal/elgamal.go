@@ -0,0 +1,345 @@
+// Package elgamal implements the exponential ElGamal encryption scheme over the
+// BabyJubJub curve.  Messages are encoded as m·G, so ciphertexts can be added
+// together (and multiplied by a scalar) to obtain the encryption of the sum of
+// the messages, without decrypting them.
+//
+// The private key can be split among several parties by adding their public
+// keys: each one computes a partial decryption with its own key, and the
+// message is recovered from the sum of all of them.  Since the decryption
+// gives m·G, the message is found with a bounded discrete logarithm search, so
+// only small messages (such as vote counts) can be decrypted.
+package elgamal
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/iden3/go-iden3-crypto/babyjub"
+	"github.com/iden3/go-iden3-crypto/constants"
+)
+
+const (
+	// PointSize is the size of a compressed curve point.
+	PointSize = 32
+	// ScalarSize is the size of an encoded scalar.
+	ScalarSize = 32
+	// CiphertextSize is the size of an encoded ciphertext.
+	CiphertextSize = 2 * PointSize
+)
+
+// PrivateKey is an ElGamal private key.
+type PrivateKey struct {
+	k *big.Int
+}
+
+// PublicKey is an ElGamal public key, k·G.
+type PublicKey struct {
+	p *babyjub.Point
+}
+
+// Ciphertext is the encryption of m with the public key P, as (r·G, m·G + r·P).
+type Ciphertext struct {
+	C1 *babyjub.Point
+	C2 *babyjub.Point
+}
+
+// GenerateKey generates a random private key.
+func GenerateKey(randReader io.Reader) (*PrivateKey, error) {
+	k, err := randomScalar(randReader)
+	if err != nil {
+		return nil, err
+	}
+	return &PrivateKey{k: k}, nil
+}
+
+// NewPrivateKey derives a private key from the given seed.
+func NewPrivateKey(seed []byte) *PrivateKey {
+	h := sha256.Sum256(seed)
+	k := new(big.Int).SetBytes(h[:])
+	k.Mod(k, babyjub.SubOrder)
+	if k.Sign() == 0 {
+		k.SetUint64(1)
+	}
+	return &PrivateKey{k: k}
+}
+
+// DecodePrivateKey decodes a private key encoded with Bytes.
+func DecodePrivateKey(data []byte) (*PrivateKey, error) {
+	k, err := decodeScalar(data)
+	if err != nil {
+		return nil, err
+	}
+	if k.Sign() == 0 {
+		return nil, fmt.Errorf("invalid private key")
+	}
+	return &PrivateKey{k: k}, nil
+}
+
+// Bytes returns the encoded private key.
+func (k *PrivateKey) Bytes() []byte {
+	return encodeScalar(k.k)
+}
+
+// Public returns the public key of the private key.
+func (k *PrivateKey) Public() *PublicKey {
+	return &PublicKey{p: mul(k.k, babyjub.B8)}
+}
+
+// DecodePublicKey decodes a public key encoded with Bytes.
+func DecodePublicKey(data []byte) (*PublicKey, error) {
+	p, err := decodePoint(data)
+	if err != nil {
+		return nil, err
+	}
+	return &PublicKey{p: p}, nil
+}
+
+// Bytes returns the encoded public key.
+func (pk *PublicKey) Bytes() []byte {
+	return encodePoint(pk.p)
+}
+
+// Point returns the curve point of the public key.
+func (pk *PublicKey) Point() *babyjub.Point {
+	return pk.p
+}
+
+// JointPublicKey returns the public key whose private key is the sum of the
+// private keys of the given public keys.  The knowledge of each private key
+// must be verified first with a KeyProof, see its documentation.
+func JointPublicKey(keys ...*PublicKey) *PublicKey {
+	p := babyjub.NewPoint()
+	for _, k := range keys {
+		p = add(p, k.p)
+	}
+	return &PublicKey{p: p}
+}
+
+// Encrypt encrypts the message m with the public key, and returns the random
+// scalar used, which is required to prove statements about the ciphertext.
+func Encrypt(randReader io.Reader, pk *PublicKey, m *big.Int) (*Ciphertext, *big.Int, error) {
+	r, err := randomScalar(randReader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return EncryptWithRandom(pk, m, r), r, nil
+}
+
+// EncryptWithRandom encrypts the message m with the public key, using the
+// given random scalar.
+func EncryptWithRandom(pk *PublicKey, m, r *big.Int) *Ciphertext {
+	return &Ciphertext{
+		C1: mul(r, babyjub.B8),
+		C2: add(mul(m, babyjub.B8), mul(r, pk.p)),
+	}
+}
+
+// NewCiphertext returns the encryption of zero with no randomness, the
+// neutral element for Add.
+func NewCiphertext() *Ciphertext {
+	return &Ciphertext{C1: babyjub.NewPoint(), C2: babyjub.NewPoint()}
+}
+
+// Add sets c to the sum of a and b, which is the encryption of the sum of
+// their messages, and returns c.
+func (c *Ciphertext) Add(a, b *Ciphertext) *Ciphertext {
+	c.C1, c.C2 = add(a.C1, b.C1), add(a.C2, b.C2)
+	return c
+}
+
+// Mul sets c to the ciphertext a multiplied by the scalar s, which is the
+// encryption of the message multiplied by s, and returns c.
+func (c *Ciphertext) Mul(s *big.Int, a *Ciphertext) *Ciphertext {
+	c.C1, c.C2 = mul(s, a.C1), mul(s, a.C2)
+	return c
+}
+
+// Bytes returns the encoded ciphertext.
+func (c *Ciphertext) Bytes() []byte {
+	return append(encodePoint(c.C1), encodePoint(c.C2)...)
+}
+
+// DecodeCiphertext decodes a ciphertext encoded with Bytes.
+func DecodeCiphertext(data []byte) (*Ciphertext, error) {
+	if len(data) != CiphertextSize {
+		return nil, fmt.Errorf("invalid ciphertext size %d", len(data))
+	}
+	c1, err := decodePoint(data[:PointSize])
+	if err != nil {
+		return nil, err
+	}
+	c2, err := decodePoint(data[PointSize:])
+	if err != nil {
+		return nil, err
+	}
+	return &Ciphertext{C1: c1, C2: c2}, nil
+}
+
+// PartialDecrypt returns the partial decryption of the ciphertext with the
+// private key, k·C1.
+func (k *PrivateKey) PartialDecrypt(c *Ciphertext) *babyjub.Point {
+	return mul(k.k, c.C1)
+}
+
+// Decrypt decrypts the ciphertext using the partial decryptions of all the
+// private keys of the joint public key, and returns the message if it is not
+// greater than max.  To decrypt several ciphertexts with the same bound, use
+// a DiscreteLogTable.
+func Decrypt(c *Ciphertext, partials []*babyjub.Point, max uint64) (uint64, error) {
+	return NewDiscreteLogTable(max).Decrypt(c, partials)
+}
+
+// DiscreteLog returns m such that m·G equals the given point, if m is not
+// greater than max.  To search several points with the same bound, use a
+// DiscreteLogTable.
+func DiscreteLog(p *babyjub.Point, max uint64) (uint64, error) {
+	return NewDiscreteLogTable(max).DiscreteLog(p)
+}
+
+// DiscreteLogTable is the precomputed table of the baby-step giant-step
+// algorithm for a given bound, so it can be reused to search the discrete
+// logarithm of several points.  It is safe for concurrent use.
+type DiscreteLogTable struct {
+	max   uint64
+	steps uint64
+	baby  map[[32]byte]uint64
+	// giant is -steps·G
+	giant *babyjub.Point
+}
+
+// NewDiscreteLogTable computes the baby steps to search discrete logarithms
+// not greater than max, which requires sqrt(max) point additions and entries.
+func NewDiscreteLogTable(max uint64) *DiscreteLogTable {
+	steps := new(big.Int).Sqrt(new(big.Int).SetUint64(max)).Uint64() + 1
+	t := &DiscreteLogTable{
+		max:   max,
+		steps: steps,
+		baby:  make(map[[32]byte]uint64, steps),
+		giant: neg(mul(new(big.Int).SetUint64(steps), babyjub.B8)),
+	}
+	q := babyjub.NewPoint()
+	for i := uint64(0); i < steps; i++ {
+		t.baby[q.Compress()] = i
+		q = add(q, babyjub.B8)
+	}
+	return t
+}
+
+// Max returns the bound of the table.
+func (t *DiscreteLogTable) Max() uint64 {
+	return t.max
+}
+
+// Decrypt decrypts the ciphertext using the partial decryptions of all the
+// private keys of the joint public key, and returns the message if it is not
+// greater than the bound of the table.
+func (t *DiscreteLogTable) Decrypt(c *Ciphertext, partials []*babyjub.Point) (uint64, error) {
+	d := babyjub.NewPoint()
+	for _, p := range partials {
+		d = add(d, p)
+	}
+	return t.DiscreteLog(add(c.C2, neg(d)))
+}
+
+// DiscreteLog returns m such that m·G equals the given point, if m is not
+// greater than the bound of the table, with the giant steps.
+func (t *DiscreteLogTable) DiscreteLog(p *babyjub.Point) (uint64, error) {
+	q := p
+	for j := uint64(0); j <= t.steps; j++ {
+		if i, ok := t.baby[q.Compress()]; ok {
+			if m := j*t.steps + i; m <= t.max {
+				return m, nil
+			}
+			break
+		}
+		q = add(q, t.giant)
+	}
+	return 0, fmt.Errorf("message not found or greater than %d", t.max)
+}
+
+// EncodePoint returns the compressed curve point.
+func EncodePoint(p *babyjub.Point) []byte {
+	return encodePoint(p)
+}
+
+// DecodePoint decodes a compressed curve point, which must be on the prime
+// order subgroup.
+func DecodePoint(data []byte) (*babyjub.Point, error) {
+	return decodePoint(data)
+}
+
+func add(a, b *babyjub.Point) *babyjub.Point {
+	return babyjub.NewPointProjective().Add(a.Projective(), b.Projective()).Affine()
+}
+
+func mul(s *big.Int, p *babyjub.Point) *babyjub.Point {
+	return babyjub.NewPoint().Mul(s, p)
+}
+
+func neg(p *babyjub.Point) *babyjub.Point {
+	x := new(big.Int).Neg(p.X)
+	x.Mod(x, constants.Q)
+	return &babyjub.Point{X: x, Y: new(big.Int).Set(p.Y)}
+}
+
+func sub(a, b *babyjub.Point) *babyjub.Point {
+	return add(a, neg(b))
+}
+
+func equal(a, b *babyjub.Point) bool {
+	return a.X.Cmp(b.X) == 0 && a.Y.Cmp(b.Y) == 0
+}
+
+func encodePoint(p *babyjub.Point) []byte {
+	c := p.Compress()
+	return c[:]
+}
+
+func decodePoint(data []byte) (*babyjub.Point, error) {
+	if len(data) != PointSize {
+		return nil, fmt.Errorf("invalid point size %d", len(data))
+	}
+	var c [32]byte
+	copy(c[:], data)
+	p, err := babyjub.NewPoint().Decompress(c)
+	if err != nil {
+		return nil, err
+	}
+	if !p.InSubGroup() {
+		return nil, fmt.Errorf("point not in the curve subgroup")
+	}
+	return p, nil
+}
+
+func encodeScalar(s *big.Int) []byte {
+	return s.FillBytes(make([]byte, ScalarSize))
+}
+
+func decodeScalar(data []byte) (*big.Int, error) {
+	if len(data) != ScalarSize {
+		return nil, fmt.Errorf("invalid scalar size %d", len(data))
+	}
+	s := new(big.Int).SetBytes(data)
+	if s.Cmp(babyjub.SubOrder) >= 0 {
+		return nil, fmt.Errorf("scalar out of range")
+	}
+	return s, nil
+}
+
+func randomScalar(randReader io.Reader) (*big.Int, error) {
+	if randReader == nil {
+		randReader = rand.Reader
+	}
+	for {
+		s, err := rand.Int(randReader, babyjub.SubOrder)
+		if err != nil {
+			return nil, err
+		}
+		if s.Sign() != 0 {
+			return s, nil
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package elgamal
+
+import (
+	"math/big"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/iden3/go-iden3-crypto/babyjub"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	// two keys jointly decrypt the ciphertexts
+	k1, err := GenerateKey(nil)
+	qt.Assert(t, err, qt.IsNil)
+	k2 := NewPrivateKey([]byte("seed"))
+	pk := JointPublicKey(k1.Public(), k2.Public())
+
+	// the encoded keys are decoded to the same value
+	k3, err := DecodePrivateKey(k2.Bytes())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, k3.Public().Bytes(), qt.DeepEquals, k2.Public().Bytes())
+	pk2, err := DecodePublicKey(pk.Bytes())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, pk2.Bytes(), qt.DeepEquals, pk.Bytes())
+
+	// 3*5 + 2*1 + 7*0 = 17
+	sum := NewCiphertext()
+	for _, mw := range [][2]int64{{5, 3}, {1, 2}, {0, 7}} {
+		c, _, err := Encrypt(nil, pk, big.NewInt(mw[0]))
+		qt.Assert(t, err, qt.IsNil)
+		c, err = DecodeCiphertext(c.Bytes())
+		qt.Assert(t, err, qt.IsNil)
+		sum.Add(sum, new(Ciphertext).Mul(big.NewInt(mw[1]), c))
+	}
+	partials := []*babyjub.Point{k1.PartialDecrypt(sum), k2.PartialDecrypt(sum)}
+	m, err := Decrypt(sum, partials, 100)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, m, qt.Equals, uint64(17))
+
+	// the message is out of the search bound
+	_, err = Decrypt(sum, partials, 16)
+	qt.Assert(t, err, qt.ErrorMatches, "message not found.*")
+	// a partial decryption is missing
+	_, err = Decrypt(sum, partials[:1], 100)
+	qt.Assert(t, err, qt.ErrorMatches, "message not found.*")
+
+	// the discrete logarithm is found on the whole range, reusing the table
+	table := NewDiscreteLogTable(12345)
+	for _, v := range []uint64{0, 1, 99, 100, 101, 12345} {
+		m, err := table.DiscreteLog(mul(new(big.Int).SetUint64(v), babyjub.B8))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, m, qt.Equals, v)
+	}
+	_, err = table.DiscreteLog(mul(big.NewInt(12346), babyjub.B8))
+	qt.Assert(t, err, qt.ErrorMatches, "message not found.*")
+}
+
+func TestProofs(t *testing.T) {
+	k, err := GenerateKey(nil)
+	qt.Assert(t, err, qt.IsNil)
+	pk := k.Public()
+	context := []byte("context")
+
+	for _, bit := range []bool{false, true} {
+		m := big.NewInt(0)
+		if bit {
+			m.SetUint64(1)
+		}
+		c, r, err := Encrypt(nil, pk, m)
+		qt.Assert(t, err, qt.IsNil)
+		proof, err := ProveBit(nil, context, pk, c, bit, r)
+		qt.Assert(t, err, qt.IsNil)
+		proof, err = DecodeBitProof(proof.Bytes())
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, proof.Verify(context, pk, c), qt.IsTrue)
+		qt.Assert(t, proof.Verify([]byte("other"), pk, c), qt.IsFalse)
+
+		eproof, err := ProveEncrypts(nil, context, pk, c, m, r)
+		qt.Assert(t, err, qt.IsNil)
+		eproof, err = DecodeDLEQProof(eproof.Bytes())
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, eproof.VerifyEncrypts(context, pk, c, m), qt.IsTrue)
+		qt.Assert(t, eproof.VerifyEncrypts(context, pk, c, big.NewInt(2)), qt.IsFalse)
+	}
+
+	// a proof for an encryption of 2 cannot be built
+	c, r, err := Encrypt(nil, pk, big.NewInt(2))
+	qt.Assert(t, err, qt.IsNil)
+	for _, bit := range []bool{false, true} {
+		proof, err := ProveBit(nil, context, pk, c, bit, r)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, proof.Verify(context, pk, c), qt.IsFalse)
+	}
+
+	// partial decryptions are verified against the public key
+	d := k.PartialDecrypt(c)
+	proof, err := ProvePartialDecryption(nil, context, k, c, d)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proof.VerifyPartialDecryption(context, pk, c, d), qt.IsTrue)
+	other, err := GenerateKey(nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proof.VerifyPartialDecryption(context, pk, c, other.PartialDecrypt(c)), qt.IsFalse)
+	qt.Assert(t, proof.VerifyPartialDecryption(context, other.Public(), c, d), qt.IsFalse)
+
+	// the knowledge of the private key is proven, so a key chosen to cancel
+	// the others on the joint key cannot be proven
+	kproof, err := ProveKey(nil, context, k)
+	qt.Assert(t, err, qt.IsNil)
+	kproof, err = DecodeKeyProof(kproof.Bytes())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, kproof.Verify(context, pk), qt.IsTrue)
+	qt.Assert(t, kproof.Verify([]byte("other"), pk), qt.IsFalse)
+	qt.Assert(t, kproof.Verify(context, other.Public()), qt.IsFalse)
+}
@@ -0,0 +1,244 @@
+package elgamal
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/iden3/go-iden3-crypto/babyjub"
+)
+
+// The proofs are non-interactive Chaum-Pedersen proofs, using the Fiat-Shamir
+// heuristic.  A context is included in the challenge, so a proof cannot be
+// reused for a different purpose (such as copying the ballot of other voter).
+
+const (
+	// DLEQProofSize is the size of an encoded DLEQProof.
+	DLEQProofSize = 2 * ScalarSize
+	// BitProofSize is the size of an encoded BitProof.
+	BitProofSize = 4 * ScalarSize
+	// KeyProofSize is the size of an encoded KeyProof.
+	KeyProofSize = 2 * ScalarSize
+)
+
+// DLEQProof proves the knowledge of x such that H1 = x·G1 and H2 = x·G2.
+type DLEQProof struct {
+	C *big.Int
+	S *big.Int
+}
+
+// BitProof proves that a ciphertext is the encryption of either 0 or 1.
+type BitProof struct {
+	C0, C1 *big.Int
+	S0, S1 *big.Int
+}
+
+// KeyProof is a Schnorr proof of knowledge of the private key k of the public
+// key P = k·G.  Public keys are only added together once their proofs are
+// verified, since otherwise a party could choose its public key as a function
+// of the others, to cancel them and know the private key of the joint key.
+type KeyProof struct {
+	C *big.Int
+	S *big.Int
+}
+
+// challenge computes the Fiat-Shamir challenge of the context and points.
+func challenge(context []byte, points ...*babyjub.Point) *big.Int {
+	h := sha256.New()
+	h.Write(context)
+	for _, p := range points {
+		h.Write(encodePoint(p))
+	}
+	c := new(big.Int).SetBytes(h.Sum(nil))
+	return c.Mod(c, babyjub.SubOrder)
+}
+
+// ProveDLEQ proves that h1 = x·g1 and h2 = x·g2.
+func ProveDLEQ(randReader io.Reader, context []byte, x *big.Int,
+	g1, h1, g2, h2 *babyjub.Point) (*DLEQProof, error) {
+	w, err := randomScalar(randReader)
+	if err != nil {
+		return nil, err
+	}
+	c := challenge(context, g1, h1, g2, h2, mul(w, g1), mul(w, g2))
+	s := new(big.Int).Mul(c, x)
+	s.Add(s, w)
+	s.Mod(s, babyjub.SubOrder)
+	return &DLEQProof{C: c, S: s}, nil
+}
+
+// Verify verifies that the proof is valid for the given points.
+func (p *DLEQProof) Verify(context []byte, g1, h1, g2, h2 *babyjub.Point) bool {
+	// a = s·g1 - c·h1, b = s·g2 - c·h2
+	a := sub(mul(p.S, g1), mul(p.C, h1))
+	b := sub(mul(p.S, g2), mul(p.C, h2))
+	return challenge(context, g1, h1, g2, h2, a, b).Cmp(p.C) == 0
+}
+
+// Bytes returns the encoded proof.
+func (p *DLEQProof) Bytes() []byte {
+	return append(encodeScalar(p.C), encodeScalar(p.S)...)
+}
+
+// DecodeDLEQProof decodes a proof encoded with Bytes.
+func DecodeDLEQProof(data []byte) (*DLEQProof, error) {
+	if len(data) != DLEQProofSize {
+		return nil, fmt.Errorf("invalid proof size %d", len(data))
+	}
+	c, err := decodeScalar(data[:ScalarSize])
+	if err != nil {
+		return nil, err
+	}
+	s, err := decodeScalar(data[ScalarSize:])
+	if err != nil {
+		return nil, err
+	}
+	return &DLEQProof{C: c, S: s}, nil
+}
+
+// ProveKey proves the knowledge of the private key k.
+func ProveKey(randReader io.Reader, context []byte, k *PrivateKey) (*KeyProof, error) {
+	w, err := randomScalar(randReader)
+	if err != nil {
+		return nil, err
+	}
+	c := challenge(context, babyjub.B8, k.Public().p, mul(w, babyjub.B8))
+	s := new(big.Int).Mul(c, k.k)
+	s.Add(s, w)
+	s.Mod(s, babyjub.SubOrder)
+	return &KeyProof{C: c, S: s}, nil
+}
+
+// Verify verifies the knowledge of the private key of the public key.
+func (p *KeyProof) Verify(context []byte, pk *PublicKey) bool {
+	// a = s·G - c·P
+	a := sub(mul(p.S, babyjub.B8), mul(p.C, pk.p))
+	return challenge(context, babyjub.B8, pk.p, a).Cmp(p.C) == 0
+}
+
+// Bytes returns the encoded proof.
+func (p *KeyProof) Bytes() []byte {
+	return append(encodeScalar(p.C), encodeScalar(p.S)...)
+}
+
+// DecodeKeyProof decodes a proof encoded with Bytes.
+func DecodeKeyProof(data []byte) (*KeyProof, error) {
+	if len(data) != KeyProofSize {
+		return nil, fmt.Errorf("invalid proof size %d", len(data))
+	}
+	c, err := decodeScalar(data[:ScalarSize])
+	if err != nil {
+		return nil, err
+	}
+	s, err := decodeScalar(data[ScalarSize:])
+	if err != nil {
+		return nil, err
+	}
+	return &KeyProof{C: c, S: s}, nil
+}
+
+// ProveEncrypts proves that the ciphertext, encrypted with the random scalar r,
+// is the encryption of m.
+func ProveEncrypts(randReader io.Reader, context []byte, pk *PublicKey, c *Ciphertext,
+	m, r *big.Int) (*DLEQProof, error) {
+	return ProveDLEQ(randReader, context, r,
+		babyjub.B8, c.C1, pk.p, sub(c.C2, mul(m, babyjub.B8)))
+}
+
+// VerifyEncrypts verifies that the ciphertext is the encryption of m.
+func (p *DLEQProof) VerifyEncrypts(context []byte, pk *PublicKey, c *Ciphertext, m *big.Int) bool {
+	return p.Verify(context, babyjub.B8, c.C1, pk.p, sub(c.C2, mul(m, babyjub.B8)))
+}
+
+// ProvePartialDecryption proves that the partial decryption d of the
+// ciphertext was computed with the private key k.
+func ProvePartialDecryption(randReader io.Reader, context []byte, k *PrivateKey,
+	c *Ciphertext, d *babyjub.Point) (*DLEQProof, error) {
+	return ProveDLEQ(randReader, context, k.k, babyjub.B8, k.Public().p, c.C1, d)
+}
+
+// VerifyPartialDecryption verifies that the partial decryption d of the
+// ciphertext was computed with the private key of the given public key.
+func (p *DLEQProof) VerifyPartialDecryption(context []byte, pk *PublicKey,
+	c *Ciphertext, d *babyjub.Point) bool {
+	return p.Verify(context, babyjub.B8, pk.p, c.C1, d)
+}
+
+// ProveBit proves that the ciphertext, encrypted with the random scalar r, is
+// the encryption of the bit (0 or 1), without revealing which one.
+func ProveBit(randReader io.Reader, context []byte, pk *PublicKey, c *Ciphertext,
+	bit bool, r *big.Int) (*BitProof, error) {
+	// the statement j is C1 = r·G and C2 - j·G = r·P
+	c2j := [2]*babyjub.Point{c.C2, sub(c.C2, babyjub.B8)}
+	known, simulated := 0, 1
+	if bit {
+		known, simulated = 1, 0
+	}
+	var a, b [2]*babyjub.Point
+	var cs, ss [2]*big.Int
+	// simulate the other statement with a random challenge and response
+	var err error
+	if cs[simulated], err = randomScalar(randReader); err != nil {
+		return nil, err
+	}
+	if ss[simulated], err = randomScalar(randReader); err != nil {
+		return nil, err
+	}
+	a[simulated] = sub(mul(ss[simulated], babyjub.B8), mul(cs[simulated], c.C1))
+	b[simulated] = sub(mul(ss[simulated], pk.p), mul(cs[simulated], c2j[simulated]))
+	// commit to the known statement
+	w, err := randomScalar(randReader)
+	if err != nil {
+		return nil, err
+	}
+	a[known], b[known] = mul(w, babyjub.B8), mul(w, pk.p)
+
+	ch := challenge(context, pk.p, c.C1, c.C2, a[0], b[0], a[1], b[1])
+	cs[known] = new(big.Int).Sub(ch, cs[simulated])
+	cs[known].Mod(cs[known], babyjub.SubOrder)
+	ss[known] = new(big.Int).Mul(cs[known], r)
+	ss[known].Add(ss[known], w)
+	ss[known].Mod(ss[known], babyjub.SubOrder)
+	return &BitProof{C0: cs[0], C1: cs[1], S0: ss[0], S1: ss[1]}, nil
+}
+
+// Verify verifies that the ciphertext is the encryption of 0 or 1.
+func (p *BitProof) Verify(context []byte, pk *PublicKey, c *Ciphertext) bool {
+	c2j := [2]*babyjub.Point{c.C2, sub(c.C2, babyjub.B8)}
+	cs := [2]*big.Int{p.C0, p.C1}
+	ss := [2]*big.Int{p.S0, p.S1}
+	var a, b [2]*babyjub.Point
+	for j := range cs {
+		a[j] = sub(mul(ss[j], babyjub.B8), mul(cs[j], c.C1))
+		b[j] = sub(mul(ss[j], pk.p), mul(cs[j], c2j[j]))
+	}
+	ch := new(big.Int).Add(p.C0, p.C1)
+	ch.Mod(ch, babyjub.SubOrder)
+	return challenge(context, pk.p, c.C1, c.C2, a[0], b[0], a[1], b[1]).Cmp(ch) == 0
+}
+
+// Bytes returns the encoded proof.
+func (p *BitProof) Bytes() []byte {
+	data := []byte{}
+	for _, s := range []*big.Int{p.C0, p.C1, p.S0, p.S1} {
+		data = append(data, encodeScalar(s)...)
+	}
+	return data
+}
+
+// DecodeBitProof decodes a proof encoded with Bytes.
+func DecodeBitProof(data []byte) (*BitProof, error) {
+	if len(data) != BitProofSize {
+		return nil, fmt.Errorf("invalid proof size %d", len(data))
+	}
+	scalars := [4]*big.Int{}
+	for i := range scalars {
+		s, err := decodeScalar(data[i*ScalarSize : (i+1)*ScalarSize])
+		if err != nil {
+			return nil, err
+		}
+		scalars[i] = s
+	}
+	return &BitProof{C0: scalars[0], C1: scalars[1], S0: scalars[2], S1: scalars[3]}, nil
+}
@@ -0,0 +1,174 @@
+// Package storagelayout computes and verifies the storage slots of EVM
+// contract values, such as token balances, described by a storage layout.
+// It is used to verify Ethereum storage proofs against contracts which do not
+// follow the standard ERC20 or MiniMe storage layouts.
+package storagelayout
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// HolderKey is the mapping key placeholder which is replaced by the holder
+// address when computing a storage slot.
+const HolderKey = "holder"
+
+// wordSize is the size in bytes of an EVM storage word.
+const wordSize = 32
+
+// Layout describes where the value (i.e. the balance) of a holder is stored
+// within the storage of an EVM contract.  The value is found by following the
+// mapping keys starting from the root slot, then adding the struct field
+// offset, and finally reading the packed field from the storage word.
+//
+// For instance, the balance of an ERC1155 token with id 7, declared as
+// `mapping(uint256 => mapping(address => uint256)) _balances` on slot 0, is
+// described as:
+//
+//	&Layout{Slot: 0, Keys: []string{"0x07", HolderKey}}
+type Layout struct {
+	// Slot is the storage slot of the root mapping (its declaration position).
+	Slot uint64
+	// Keys is the list of keys of the nested mappings, from the outer to the
+	// inner one.  The HolderKey is replaced by the holder address, any other
+	// key must be a hex encoded value of up to 32 bytes (e.g. a token ID).
+	Keys []string
+	// StructOffset is the slot offset of the field, if the mapping value is
+	// a struct.
+	StructOffset uint64
+	// Offset is the position in bytes of the packed field within the storage
+	// word, starting from the lowest-order byte as Solidity does.
+	Offset uint8
+	// Size is the size in bytes of the packed field.  Zero means the whole
+	// storage word.
+	Size uint8
+}
+
+// FromProto converts and validates a storage layout of a process.
+func FromProto(p *models.EVMStorageLayout) (*Layout, error) {
+	if p.GetOffset() > wordSize || p.GetSize() > wordSize {
+		return nil, fmt.Errorf("packed field overflows the storage word (offset %d, size %d)",
+			p.GetOffset(), p.GetSize())
+	}
+	l := &Layout{
+		Slot:         p.GetSlot(),
+		Keys:         p.GetKeys(),
+		StructOffset: p.GetStructOffset(),
+		Offset:       uint8(p.GetOffset()),
+		Size:         uint8(p.GetSize()),
+	}
+	if err := l.Validate(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Validate checks that the storage layout is well formed.
+func (l *Layout) Validate() error {
+	holder := false
+	for _, k := range l.Keys {
+		if k == HolderKey {
+			holder = true
+			continue
+		}
+		if _, err := decodeKey(k); err != nil {
+			return err
+		}
+	}
+	if !holder {
+		return fmt.Errorf("storage layout has no %q key", HolderKey)
+	}
+	if int(l.Offset)+int(l.size()) > wordSize {
+		return fmt.Errorf("packed field overflows the storage word (offset %d, size %d)",
+			l.Offset, l.size())
+	}
+	return nil
+}
+
+func (l *Layout) size() uint8 {
+	if l.Size == 0 {
+		return wordSize
+	}
+	return l.Size
+}
+
+func decodeKey(key string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(key, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode storage layout key %q: %w", key, err)
+	}
+	if len(b) > wordSize {
+		return nil, fmt.Errorf("storage layout key %q is too long", key)
+	}
+	return b, nil
+}
+
+// StorageKey returns the storage slot where the value of the holder is stored.
+func (l *Layout) StorageKey(holder common.Address) ([]byte, error) {
+	slot := common.LeftPadBytes(new(big.Int).SetUint64(l.Slot).Bytes(), wordSize)
+	for _, k := range l.Keys {
+		key := holder.Bytes()
+		if k != HolderKey {
+			var err error
+			if key, err = decodeKey(k); err != nil {
+				return nil, err
+			}
+		}
+		// the slot of a mapping value is keccak256(key . slot)
+		slot = crypto.Keccak256(common.LeftPadBytes(key, wordSize), slot)
+	}
+	if l.StructOffset > 0 {
+		s := new(big.Int).SetBytes(slot)
+		s.Add(s, new(big.Int).SetUint64(l.StructOffset))
+		// slots wrap around 2^256
+		s.Mod(s, new(big.Int).Lsh(big.NewInt(1), wordSize*8))
+		slot = common.LeftPadBytes(s.Bytes(), wordSize)
+	}
+	return slot, nil
+}
+
+// Value extracts the packed field from a storage word.
+func (l *Layout) Value(word []byte) (*big.Int, error) {
+	if len(word) > wordSize {
+		return nil, fmt.Errorf("storage value is too long (%d bytes)", len(word))
+	}
+	word = common.LeftPadBytes(word, wordSize)
+	end := wordSize - int(l.Offset)
+	return new(big.Int).SetBytes(word[end-int(l.size()) : end]), nil
+}
+
+// VerifyProof verifies an Ethereum storage proof of the holder value against
+// the contract storage root.  Returns the value of the holder.
+func (l *Layout) VerifyProof(holder common.Address, storageRoot common.Hash,
+	proof *ethstorageproof.StorageResult) (*big.Int, error) {
+	if proof == nil || len(proof.Value) == 0 {
+		return nil, fmt.Errorf("storage proof value is empty")
+	}
+	key, err := l.StorageKey(holder)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(proof.Key, key) {
+		return nil, fmt.Errorf("proof key and holder storage key do not match (%x != %x)", proof.Key, key)
+	}
+	value, err := l.Value(proof.Value)
+	if err != nil {
+		return nil, err
+	}
+	valid, err := ethstorageproof.VerifyEthStorageProof(proof, storageRoot)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, fmt.Errorf("storage proof is not valid")
+	}
+	return value, nil
+}
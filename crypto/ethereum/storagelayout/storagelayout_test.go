@@ -0,0 +1,126 @@
+package storagelayout
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// proofList collects the nodes of a trie proof.
+type proofList [][]byte
+
+func (l *proofList) Put(key []byte, value []byte) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func (l *proofList) Delete(key []byte) error {
+	panic("not supported")
+}
+
+// storageProof builds a contract storage trie with the given slot values and
+// returns its root and the proof of the given slot.
+func storageProof(t *testing.T, slots map[string][]byte, key []byte) (common.Hash, *ethstorageproof.StorageResult) {
+	tr := trie.NewEmpty(trie.NewDatabase(rawdb.NewMemoryDatabase()))
+	for k, v := range slots {
+		enc, err := rlp.EncodeToBytes(common.TrimLeftZeroes(v))
+		qt.Assert(t, err, qt.IsNil)
+		tr.Update(crypto.Keccak256([]byte(k)), enc)
+	}
+	var proof proofList
+	qt.Assert(t, tr.Prove(crypto.Keccak256(key), 0, &proof), qt.IsNil)
+	return tr.Hash(), &ethstorageproof.StorageResult{
+		Key:   key,
+		Value: common.TrimLeftZeroes(slots[string(key)]),
+		Proof: [][]byte(proof),
+	}
+}
+
+func TestStorageKey(t *testing.T) {
+	holder := common.HexToAddress("0x2a")
+
+	// a single mapping must match the ERC20 map based slots
+	key, err := (&Layout{Slot: 3, Keys: []string{HolderKey}}).StorageKey(holder)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, key, qt.DeepEquals, crypto.Keccak256(
+		common.LeftPadBytes(holder.Bytes(), 32),
+		common.LeftPadBytes([]byte{3}, 32),
+	))
+
+	// nested mappings, the holder being the inner key
+	l, err := FromProto(&models.EVMStorageLayout{Slot: 1, Keys: []string{"0x07", HolderKey}})
+	qt.Assert(t, err, qt.IsNil)
+	key, err = l.StorageKey(holder)
+	qt.Assert(t, err, qt.IsNil)
+	outer := crypto.Keccak256(common.LeftPadBytes([]byte{7}, 32), common.LeftPadBytes([]byte{1}, 32))
+	qt.Assert(t, key, qt.DeepEquals, crypto.Keccak256(common.LeftPadBytes(holder.Bytes(), 32), outer))
+
+	// struct field offset
+	l.StructOffset = 2
+	key2, err := l.StorageKey(holder)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, new(big.Int).SetBytes(key2).String(),
+		qt.Equals, new(big.Int).Add(new(big.Int).SetBytes(key), big.NewInt(2)).String())
+}
+
+func TestFromProto(t *testing.T) {
+	l, err := FromProto(&models.EVMStorageLayout{Slot: 1, Keys: []string{"0x07", HolderKey}, Size: 16})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, l, qt.DeepEquals, &Layout{Slot: 1, Keys: []string{"0x07", HolderKey}, Size: 16})
+
+	_, err = FromProto(&models.EVMStorageLayout{Slot: 1, Keys: []string{"0x07"}})
+	qt.Assert(t, err, qt.ErrorMatches, `storage layout has no "holder" key`)
+	_, err = FromProto(&models.EVMStorageLayout{Slot: 1, Keys: []string{HolderKey, "0xzz"}})
+	qt.Assert(t, err, qt.ErrorMatches, "cannot decode storage layout key.*")
+	_, err = FromProto(&models.EVMStorageLayout{Slot: 1, Keys: []string{HolderKey}, Offset: 20, Size: 20})
+	qt.Assert(t, err, qt.ErrorMatches, "packed field overflows.*")
+	_, err = FromProto(&models.EVMStorageLayout{Slot: 1, Keys: []string{HolderKey}, Offset: 16, Size: 16})
+	qt.Assert(t, err, qt.IsNil)
+	// offsets must not be truncated to fit the layout
+	_, err = FromProto(&models.EVMStorageLayout{Slot: 1, Keys: []string{HolderKey}, Offset: 257})
+	qt.Assert(t, err, qt.ErrorMatches, "packed field overflows the storage word.*")
+}
+
+func TestVerifyProof(t *testing.T) {
+	holder := common.HexToAddress("0x2a")
+	other := common.HexToAddress("0x2b")
+
+	// the holder balance is packed on the high-order half of the word:
+	// struct { uint128 lastUpdate; uint128 balance; }
+	l, err := FromProto(&models.EVMStorageLayout{Slot: 5, Keys: []string{HolderKey}, Offset: 16, Size: 16})
+	qt.Assert(t, err, qt.IsNil)
+	holderKey, err := l.StorageKey(holder)
+	qt.Assert(t, err, qt.IsNil)
+	otherKey, err := l.StorageKey(other)
+	qt.Assert(t, err, qt.IsNil)
+
+	word := make([]byte, 32)
+	word[15] = 100 // balance
+	word[31] = 1   // lastUpdate
+	root, proof := storageProof(t, map[string][]byte{
+		string(holderKey): word,
+		string(otherKey):  {0x05},
+	}, holderKey)
+
+	value, err := l.VerifyProof(holder, root, proof)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, value.Int64(), qt.Equals, int64(100))
+
+	// the proof cannot be used for another holder
+	_, err = l.VerifyProof(other, root, proof)
+	qt.Assert(t, err, qt.ErrorMatches, "proof key and holder storage key do not match.*")
+
+	// a tampered value must not verify
+	proof.Value = append([]byte{}, proof.Value...)
+	proof.Value[0]++
+	_, err = l.VerifyProof(holder, root, proof)
+	qt.Assert(t, err, qt.Not(qt.IsNil))
+}
@@ -3,8 +3,10 @@ package saltedkey
 import (
 	"crypto/ecdsa"
 	"fmt"
+	"math/big"
 
 	blind "github.com/arnaucube/go-blindsecp256k1"
+	"github.com/ethereum/go-ethereum/common/math"
 	ethcrypto "github.com/ethereum/go-ethereum/crypto"
 )
 
@@ -43,3 +45,40 @@ func SaltECDSAPubKey(pubKey *ecdsa.PublicKey, salt []byte) (*ecdsa.PublicKey, er
 	pubKey.X, pubKey.Y = pubKey.Curve.Add(pubKey.X, pubKey.Y, x, y)
 	return pubKey, nil
 }
+
+// SaltBlindPrivKey returns the salted blind private key of privKey applying the salt.
+// It is the private key of the public key returned by SaltBlindPubKey.
+func SaltBlindPrivKey(privKey *blind.PrivateKey, salt []byte) (*blind.PrivateKey, error) {
+	if privKey == nil {
+		return nil, fmt.Errorf("private key is nil")
+	}
+	d, err := saltScalar(privKey.BigInt(), salt)
+	if err != nil {
+		return nil, err
+	}
+	return (*blind.PrivateKey)(d), nil
+}
+
+// SaltECDSAPrivKey returns the salted plain private key of privKey applying the salt.
+// It is the private key of the public key returned by SaltECDSAPubKey.
+func SaltECDSAPrivKey(privKey *ecdsa.PrivateKey, salt []byte) (*ecdsa.PrivateKey, error) {
+	if privKey == nil || privKey.D == nil {
+		return nil, fmt.Errorf("private key is nil")
+	}
+	d, err := saltScalar(privKey.D, salt)
+	if err != nil {
+		return nil, err
+	}
+	return ethcrypto.ToECDSA(math.PaddedBigBytes(d, 32))
+}
+
+// saltScalar returns d+salt mod N, the private counterpart of adding salt*G
+// to the public key.
+func saltScalar(d *big.Int, salt []byte) (*big.Int, error) {
+	if len(salt) < SaltSize {
+		return nil, fmt.Errorf("provided salt is not large enough (need %d bytes)", SaltSize)
+	}
+	s := new(big.Int).SetBytes(salt[:SaltSize])
+	s.Add(s, d)
+	return s.Mod(s, ethcrypto.S256().Params().N), nil
+}
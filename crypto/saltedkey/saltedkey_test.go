@@ -0,0 +1,53 @@
+package saltedkey
+
+import (
+	"math/big"
+	"testing"
+
+	blind "github.com/arnaucube/go-blindsecp256k1"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/util"
+)
+
+func TestSaltECDSAPrivKey(t *testing.T) {
+	salt := util.RandomBytes(32)
+	privKey, err := ethcrypto.GenerateKey()
+	qt.Assert(t, err, qt.IsNil)
+
+	saltedPriv, err := SaltECDSAPrivKey(privKey, salt)
+	qt.Assert(t, err, qt.IsNil)
+	saltedPub, err := SaltECDSAPubKey(&privKey.PublicKey, salt)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, ethcrypto.FromECDSAPub(&saltedPriv.PublicKey), qt.DeepEquals,
+		ethcrypto.FromECDSAPub(saltedPub))
+
+	_, err = SaltECDSAPrivKey(privKey, salt[:SaltSize-1])
+	qt.Assert(t, err, qt.IsNotNil)
+}
+
+func TestSaltBlindPrivKey(t *testing.T) {
+	salt := util.RandomBytes(32)
+	privKey, err := blind.NewPrivateKey()
+	qt.Assert(t, err, qt.IsNil)
+
+	saltedPriv, err := SaltBlindPrivKey(privKey, salt)
+	qt.Assert(t, err, qt.IsNil)
+	saltedPub, err := SaltBlindPubKey(privKey.Public(), salt)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, saltedPriv.Public().Bytes(), qt.DeepEquals, saltedPub.Bytes())
+
+	// a blind signature of the salted private key verifies with the salted public key
+	msg := new(big.Int).SetBytes(util.RandomBytes(32))
+	k, signerR, err := blind.NewRequestParameters()
+	qt.Assert(t, err, qt.IsNil)
+	msgBlinded, userSecret, err := blind.Blind(msg, signerR)
+	qt.Assert(t, err, qt.IsNil)
+	sBlind, err := saltedPriv.BlindSign(msgBlinded, k)
+	if err != nil {
+		// the blinded message or k might be shorter than 32 bytes
+		t.Skipf("cannot blind sign: %v", err)
+	}
+	qt.Assert(t, blind.Verify(msg, blind.Unblind(sBlind, userSecret), saltedPub), qt.IsTrue)
+	qt.Assert(t, blind.Verify(msg, blind.Unblind(sBlind, userSecret), privKey.Public()), qt.IsFalse)
+}
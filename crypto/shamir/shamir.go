@@ -0,0 +1,216 @@
+// Package shamir implements Shamir's secret sharing with Feldman's verifiable
+// secret sharing, over the prime order subgroup of the BabyJubJub curve.  The
+// secret is a scalar of the subgroup, shared with a random polynomial of
+// degree threshold-1, so any threshold shares reconstruct the secret while
+// fewer shares reveal nothing about it.
+//
+// The dealer publishes a commitment to each coefficient of the polynomial, as
+// the coefficient times the base point, so each share can be verified on its
+// own against the commitments, and the shares revealed by a dishonest party
+// are rejected instead of being combined.  The commitment to the constant
+// term is the secret times the base point, so the secret must be uniformly
+// random, see NewSecret.
+//
+// A share is encoded as its x coordinate (a non-zero byte) followed by the
+// evaluation of the polynomial at x, and the commitments as the concatenation
+// of the compressed points, starting with the constant term.
+//
+// The arithmetic uses math/big, so it does not run in constant time.
+package shamir
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/iden3/go-iden3-crypto/babyjub"
+	"go.vocdoni.io/dvote/crypto/elgamal"
+)
+
+const (
+	// ScalarSize is the size of an encoded secret.
+	ScalarSize = 32
+	// ShareSize is the size of an encoded share.
+	ShareSize = 1 + ScalarSize
+	// CommitmentSize is the size of the commitment to each coefficient.
+	CommitmentSize = elgamal.PointSize
+)
+
+// NewSecret derives a secret from the given seed, reducing its hash to a
+// scalar of the subgroup.
+func NewSecret(seed []byte) []byte {
+	h := sha256.Sum256(seed)
+	s := new(big.Int).SetBytes(h[:])
+	return encodeScalar(s.Mod(s, babyjub.SubOrder))
+}
+
+// Split divides the secret into one share for each one of the given x
+// coordinates, so that any threshold of them can reconstruct the secret, and
+// returns the shares with the commitments to the polynomial.  The x
+// coordinates must be unique and non-zero.
+func Split(secret []byte, xs []byte, threshold int) ([][]byte, []byte, error) {
+	return split(rand.Reader, secret, xs, threshold)
+}
+
+func split(randReader io.Reader, secret []byte, xs []byte,
+	threshold int) ([][]byte, []byte, error) {
+	s, err := decodeScalar(secret)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid secret: %w", err)
+	}
+	if threshold < 1 || threshold > len(xs) {
+		return nil, nil, fmt.Errorf("invalid threshold %d for %d shares", threshold, len(xs))
+	}
+	seen := make(map[byte]bool)
+	for _, x := range xs {
+		if x == 0 || seen[x] {
+			return nil, nil, fmt.Errorf("invalid or duplicated share coordinate %d", x)
+		}
+		seen[x] = true
+	}
+	// coeffs[0] is the secret, the rest are random
+	coeffs := []*big.Int{s}
+	for len(coeffs) < threshold {
+		c, err := rand.Int(randReader, babyjub.SubOrder)
+		if err != nil {
+			return nil, nil, err
+		}
+		coeffs = append(coeffs, c)
+	}
+	commitments := []byte{}
+	for _, c := range coeffs {
+		commitments = append(commitments,
+			elgamal.EncodePoint(babyjub.NewPoint().Mul(c, babyjub.B8))...)
+	}
+	shares := make([][]byte, len(xs))
+	for i, x := range xs {
+		shares[i] = append([]byte{x}, encodeScalar(evaluate(coeffs, x))...)
+	}
+	return shares, commitments, nil
+}
+
+// Threshold returns the number of shares required to reconstruct a secret
+// shared with the given commitments, after checking they are valid points.
+func Threshold(commitments []byte) (int, error) {
+	points, err := decodeCommitments(commitments)
+	if err != nil {
+		return 0, err
+	}
+	return len(points), nil
+}
+
+// VerifyShare checks that the share is the evaluation of the polynomial of
+// the given commitments at its x coordinate, as y·B = sum(x^j·C_j).
+func VerifyShare(share, commitments []byte) error {
+	if len(share) != ShareSize || share[0] == 0 {
+		return fmt.Errorf("invalid share")
+	}
+	y, err := decodeScalar(share[1:])
+	if err != nil {
+		return fmt.Errorf("invalid share: %w", err)
+	}
+	points, err := decodeCommitments(commitments)
+	if err != nil {
+		return err
+	}
+	// Horner's method over the commitments
+	x := big.NewInt(int64(share[0]))
+	acc := babyjub.NewPointProjective()
+	for j := len(points) - 1; j >= 0; j-- {
+		acc = babyjub.NewPoint().Mul(x, acc.Affine()).Projective()
+		acc = babyjub.NewPointProjective().Add(acc, points[j].Projective())
+	}
+	expected := acc.Affine()
+	got := babyjub.NewPoint().Mul(y, babyjub.B8)
+	if got.X.Cmp(expected.X) != 0 || got.Y.Cmp(expected.Y) != 0 {
+		return fmt.Errorf("share %d does not match the commitments", share[0])
+	}
+	return nil
+}
+
+// Combine reconstructs the secret from the given shares, using Lagrange
+// interpolation at x=0.  If less shares than the threshold are provided, the
+// returned secret is wrong, so the shares should be verified and counted
+// against the commitments with VerifyShare and Threshold.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("no shares provided")
+	}
+	xs := make([]*big.Int, len(shares))
+	ys := make([]*big.Int, len(shares))
+	seen := make(map[byte]bool)
+	for i, s := range shares {
+		if len(s) != ShareSize {
+			return nil, fmt.Errorf("invalid share size %d", len(s))
+		}
+		if s[0] == 0 || seen[s[0]] {
+			return nil, fmt.Errorf("invalid or duplicated share coordinate %d", s[0])
+		}
+		seen[s[0]] = true
+		var err error
+		if ys[i], err = decodeScalar(s[1:]); err != nil {
+			return nil, fmt.Errorf("invalid share: %w", err)
+		}
+		xs[i] = big.NewInt(int64(s[0]))
+	}
+	q := babyjub.SubOrder
+	secret := new(big.Int)
+	for i := range shares {
+		// l_i(0) = prod(x_j / (x_j - x_i))
+		num, den := big.NewInt(1), big.NewInt(1)
+		for j := range shares {
+			if i == j {
+				continue
+			}
+			num.Mul(num, xs[j])
+			den.Mul(den, new(big.Int).Sub(xs[j], xs[i]))
+		}
+		den.Mod(den, q)
+		num.Mul(num, den.ModInverse(den, q))
+		secret.Add(secret, num.Mul(num, ys[i]))
+	}
+	return encodeScalar(secret.Mod(secret, q)), nil
+}
+
+// evaluate computes the polynomial with the given coefficients at x, using
+// Horner's method.
+func evaluate(coeffs []*big.Int, x byte) *big.Int {
+	y := new(big.Int)
+	bx := big.NewInt(int64(x))
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y.Mul(y, bx).Add(y, coeffs[i]).Mod(y, babyjub.SubOrder)
+	}
+	return y
+}
+
+func decodeCommitments(commitments []byte) ([]*babyjub.Point, error) {
+	if len(commitments) == 0 || len(commitments)%CommitmentSize != 0 {
+		return nil, fmt.Errorf("invalid commitments size %d", len(commitments))
+	}
+	points := []*babyjub.Point{}
+	for i := 0; i < len(commitments); i += CommitmentSize {
+		p, err := elgamal.DecodePoint(commitments[i : i+CommitmentSize])
+		if err != nil {
+			return nil, fmt.Errorf("invalid commitment %d: %w", i/CommitmentSize, err)
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+func encodeScalar(s *big.Int) []byte {
+	return s.FillBytes(make([]byte, ScalarSize))
+}
+
+func decodeScalar(data []byte) (*big.Int, error) {
+	if len(data) != ScalarSize {
+		return nil, fmt.Errorf("invalid scalar size %d", len(data))
+	}
+	s := new(big.Int).SetBytes(data)
+	if s.Cmp(babyjub.SubOrder) >= 0 {
+		return nil, fmt.Errorf("scalar out of range")
+	}
+	return s, nil
+}
@@ -0,0 +1,91 @@
+package shamir
+
+import (
+	"bytes"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/util"
+)
+
+func TestSplitCombine(t *testing.T) {
+	secret := NewSecret(util.RandomBytes(32))
+	xs := []byte{1, 2, 3, 4, 5}
+	shares, commitments, err := Split(secret, xs, 3)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, shares, qt.HasLen, 5)
+	qt.Assert(t, commitments, qt.HasLen, 3*CommitmentSize)
+	threshold, err := Threshold(commitments)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, threshold, qt.Equals, 3)
+	for _, s := range shares {
+		qt.Assert(t, s, qt.HasLen, ShareSize)
+		qt.Assert(t, VerifyShare(s, commitments), qt.IsNil)
+	}
+
+	// any combination of 3 or more shares reconstructs the secret
+	for _, subset := range [][]int{{0, 1, 2}, {0, 2, 4}, {4, 3, 1}, {1, 2, 3, 4}, {0, 1, 2, 3, 4}} {
+		selected := [][]byte{}
+		for _, i := range subset {
+			selected = append(selected, shares[i])
+		}
+		combined, err := Combine(selected)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, combined, qt.DeepEquals, secret, qt.Commentf("shares %v", subset))
+	}
+
+	// two shares are not enough
+	combined, err := Combine([][]byte{shares[0], shares[3]})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, bytes.Equal(combined, secret), qt.IsFalse)
+
+	// a threshold of one gives away the secret on every share
+	shares, _, err = Split(secret, []byte{7, 9}, 1)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, shares[1][1:], qt.DeepEquals, secret)
+}
+
+func TestVerifyShare(t *testing.T) {
+	secret := NewSecret(util.RandomBytes(32))
+	shares, commitments, err := Split(secret, []byte{1, 2, 3}, 2)
+	qt.Assert(t, err, qt.IsNil)
+
+	// a tampered share or a share moved to another coordinate is rejected
+	invalid := append([]byte{}, shares[1]...)
+	invalid[ShareSize-1] ^= 1
+	qt.Assert(t, VerifyShare(invalid, commitments), qt.ErrorMatches, ".*does not match.*")
+	moved := append([]byte{3}, shares[1][1:]...)
+	qt.Assert(t, VerifyShare(moved, commitments), qt.ErrorMatches, ".*does not match.*")
+
+	// the shares of another polynomial are rejected
+	other, _, err := Split(secret, []byte{1, 2, 3}, 2)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, VerifyShare(other[0], commitments), qt.ErrorMatches, ".*does not match.*")
+
+	qt.Assert(t, VerifyShare(shares[0][:10], commitments), qt.ErrorMatches, "invalid share")
+	qt.Assert(t, VerifyShare(shares[0], commitments[:10]), qt.ErrorMatches,
+		"invalid commitments size.*")
+	_, err = Threshold(make([]byte, CommitmentSize))
+	qt.Assert(t, err, qt.ErrorMatches, "invalid commitment 0.*")
+}
+
+func TestSplitErrors(t *testing.T) {
+	secret := NewSecret(util.RandomBytes(32))
+	_, _, err := Split(secret, []byte{1, 2}, 3)
+	qt.Assert(t, err, qt.ErrorMatches, "invalid threshold.*")
+	_, _, err = Split(secret, []byte{1, 0, 2}, 2)
+	qt.Assert(t, err, qt.ErrorMatches, "invalid or duplicated.*")
+	_, _, err = Split(secret, []byte{1, 2, 2}, 2)
+	qt.Assert(t, err, qt.ErrorMatches, "invalid or duplicated.*")
+	_, _, err = Split(nil, []byte{1, 2}, 2)
+	qt.Assert(t, err, qt.ErrorMatches, "invalid secret.*")
+	_, _, err = Split(bytes.Repeat([]byte{0xff}, ScalarSize), []byte{1, 2}, 2)
+	qt.Assert(t, err, qt.ErrorMatches, "invalid secret: scalar out of range")
+
+	shares, _, err := Split(secret, []byte{1, 2, 3}, 2)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = Combine([][]byte{shares[0], shares[0]})
+	qt.Assert(t, err, qt.ErrorMatches, "invalid or duplicated.*")
+	_, err = Combine([][]byte{shares[0], shares[1][:10]})
+	qt.Assert(t, err, qt.ErrorMatches, "invalid share size.*")
+}
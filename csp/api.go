@@ -0,0 +1,102 @@
+package csp
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/apirest"
+	"go.vocdoni.io/dvote/util"
+)
+
+// AttachCSPAPI attaches the CSP API to the given http apirest router.
+// The path prefix is used to define the base path in which the endpoint methods are registered:
+//   - GET {pathPrefix}/info returns the CSP public key and the available handlers.
+//   - POST {pathPrefix}/{electionID}/auth/{handler} runs an authentication step.
+//   - POST {pathPrefix}/{electionID}/sign issues the signature of an authenticated voter.
+func AttachCSPAPI(csp *CSP, api *apirest.API, pathPrefix string) error {
+	if err := api.RegisterMethod(
+		pathPrefix+"/info",
+		"GET",
+		apirest.MethodAccessTypePublic,
+		csp.infoHandler,
+	); err != nil {
+		return err
+	}
+	if err := api.RegisterMethod(
+		pathPrefix+"/{electionID}/auth/{handler}",
+		"POST",
+		apirest.MethodAccessTypePublic,
+		csp.authHandler,
+	); err != nil {
+		return err
+	}
+	return api.RegisterMethod(
+		pathPrefix+"/{electionID}/sign",
+		"POST",
+		apirest.MethodAccessTypePublic,
+		csp.signHandler,
+	)
+}
+
+func (c *CSP) infoHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	data, err := json.Marshal(&Info{
+		PublicKey:      c.PublicKey(),
+		AuthHandlers:   c.AuthHandlers(),
+		SignatureTypes: []string{SignatureTypeBlind, SignatureTypeECDSA},
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, apirest.HTTPstatusCodeOK)
+}
+
+func (c *CSP) authHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	electionID, err := urlElectionID(ctx)
+	if err != nil {
+		return err
+	}
+	req := &AuthRequest{}
+	if err := json.Unmarshal(msg.Data, req); err != nil {
+		return fmt.Errorf("cannot parse auth request: %w", err)
+	}
+	resp, err := c.Auth(ctx.URLParam("handler"), electionID, req.SignatureType, req.Data)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, apirest.HTTPstatusCodeOK)
+}
+
+func (c *CSP) signHandler(msg *apirest.APIdata, ctx *httprouter.HTTPContext) error {
+	electionID, err := urlElectionID(ctx)
+	if err != nil {
+		return err
+	}
+	req := &SignRequest{}
+	if err := json.Unmarshal(msg.Data, req); err != nil {
+		return fmt.Errorf("cannot parse sign request: %w", err)
+	}
+	signature, err := c.Sign(electionID, req.AuthToken, req.Payload)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&SignResponse{Signature: signature})
+	if err != nil {
+		return err
+	}
+	return ctx.Send(data, apirest.HTTPstatusCodeOK)
+}
+
+// urlElectionID returns the election ID of the request URL.
+func urlElectionID(ctx *httprouter.HTTPContext) ([]byte, error) {
+	electionID, err := hex.DecodeString(util.TrimHex(ctx.URLParam("electionID")))
+	if err != nil {
+		return nil, fmt.Errorf("electionID (%s) cannot be decoded", ctx.URLParam("electionID"))
+	}
+	return electionID, nil
+}
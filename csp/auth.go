@@ -0,0 +1,294 @@
+package csp
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"go.vocdoni.io/dvote/log"
+)
+
+const (
+	// DefaultOTPLength is the number of digits of the one time passwords
+	DefaultOTPLength = 6
+	// DefaultOTPExpiration is the time a one time password is valid for
+	DefaultOTPExpiration = 10 * time.Minute
+	// DefaultOTPWindow is the time window of the one time password limits
+	DefaultOTPWindow = time.Hour
+	// DefaultOTPIssues is the number of one time passwords which can be
+	// issued per contact and election within the window
+	DefaultOTPIssues = 3
+	// DefaultOTPAttempts is the number of failed attempts allowed per contact
+	// and election within the window
+	DefaultOTPAttempts = 3
+
+	// otpPruneInterval is the minimum time between the removals of the
+	// expired one time passwords
+	otpPruneInterval = time.Minute
+)
+
+// AuthHandler authenticates the voters of the CSP.  Each handler implements a
+// different authentication mechanism, which might require several steps.
+type AuthHandler interface {
+	// Name returns the name of the handler, used to select it on requests.
+	Name() string
+	// Auth authenticates a voter for the election with the handler specific
+	// data.  When the voter is authenticated, the result contains the
+	// identifier of the voter, unique within the handler.  Otherwise the
+	// result contains the messages for the voter to continue the process.
+	Auth(electionID []byte, data map[string]string) (*AuthResult, error)
+}
+
+// AuthResult is the result of an authentication step.
+type AuthResult struct {
+	Authenticated bool
+	VoterID       string
+	Response      []string
+}
+
+// AllowlistHandler authenticates the voters of a static list, by comparing
+// the secret they provide with the one of the list.
+type AllowlistHandler struct {
+	voters map[string]string
+}
+
+// NewAllowlistHandler returns an AllowlistHandler for the voters map, which
+// maps each voter identifier to its secret.
+func NewAllowlistHandler(voters map[string]string) *AllowlistHandler {
+	return &AllowlistHandler{voters: voters}
+}
+
+// Name implements AuthHandler.
+func (*AllowlistHandler) Name() string {
+	return "allowlist"
+}
+
+// Auth implements AuthHandler.  The data must contain the "voter" and
+// "secret" fields.
+func (h *AllowlistHandler) Auth(_ []byte, data map[string]string) (*AuthResult, error) {
+	secret, ok := h.voters[data["voter"]]
+	if !ok || subtle.ConstantTimeCompare([]byte(secret), []byte(data["secret"])) != 1 {
+		return nil, ErrUnauthorized
+	}
+	return &AuthResult{Authenticated: true, VoterID: data["voter"]}, nil
+}
+
+// Sender delivers the one time passwords to the voters, via SMS or email.
+type Sender interface {
+	Send(contact, message string) error
+}
+
+// MockSender is a Sender which logs the messages instead of delivering them,
+// for testing and development purposes.
+type MockSender struct {
+	lock     sync.Mutex
+	messages map[string]string
+}
+
+// Send implements Sender.
+func (s *MockSender) Send(contact, message string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.messages == nil {
+		s.messages = make(map[string]string)
+	}
+	s.messages[contact] = message
+	log.Infow("mock message sent", map[string]interface{}{"to": contact, "message": message})
+	return nil
+}
+
+// LastMessage returns the last message sent to the contact.
+func (s *MockSender) LastMessage(contact string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.messages[contact]
+}
+
+// otpChallenge is the one time password sent to a contact for an election,
+// and the issues and failed attempts of the current limits window.
+type otpChallenge struct {
+	// code is the pending password, empty if there is none
+	code     string
+	expires  time.Time
+	window   time.Time
+	issues   int
+	attempts int
+}
+
+// OTPHandler authenticates the voters by sending a one time password to their
+// contact (a phone number or an email address) through a Sender.  The
+// passwords issued and the failed attempts are limited per contact and
+// election within a time window.  The pending passwords are kept in memory, so
+// they are lost on restart.
+type OTPHandler struct {
+	name       string
+	sender     Sender
+	length     int
+	expiration time.Duration
+	window     time.Duration
+	issues     int
+	attempts   int
+
+	lock       sync.Mutex
+	challenges map[string]*otpChallenge
+	lastPrune  time.Time
+}
+
+// NewOTPHandler returns an OTPHandler with the given name (such as "sms" or
+// "email") sending the passwords through sender.
+func NewOTPHandler(name string, sender Sender) *OTPHandler {
+	return &OTPHandler{
+		name:       name,
+		sender:     sender,
+		length:     DefaultOTPLength,
+		expiration: DefaultOTPExpiration,
+		window:     DefaultOTPWindow,
+		issues:     DefaultOTPIssues,
+		attempts:   DefaultOTPAttempts,
+		challenges: make(map[string]*otpChallenge),
+	}
+}
+
+// Name implements AuthHandler.
+func (h *OTPHandler) Name() string {
+	return h.name
+}
+
+// Auth implements AuthHandler.  The first step requires the "contact" field
+// and sends the password to it.  The second step requires both the "contact"
+// and the received "code" fields.  Once the issues or the failed attempts
+// limit is reached, ErrTooManyRequests is returned until the window ends.
+func (h *OTPHandler) Auth(electionID []byte, data map[string]string) (*AuthResult, error) {
+	contact := data["contact"]
+	if contact == "" {
+		return nil, fmt.Errorf("missing contact")
+	}
+	// passwords are issued per election
+	key := fmt.Sprintf("%x/%s", electionID, contact)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	now := time.Now()
+	h.prune(now)
+	challenge, ok := h.challenges[key]
+	if !ok {
+		challenge = &otpChallenge{window: now.Add(h.window)}
+		h.challenges[key] = challenge
+	} else if !now.Before(challenge.window) {
+		// a new window starts, keeping the pending password
+		challenge.window = now.Add(h.window)
+		challenge.issues, challenge.attempts = 0, 0
+	}
+	if data["code"] == "" {
+		if challenge.issues >= h.issues {
+			return nil, ErrTooManyRequests
+		}
+		code, err := randomCode(h.length)
+		if err != nil {
+			return nil, err
+		}
+		if err := h.sender.Send(contact,
+			fmt.Sprintf("your voting authentication code is %s", code)); err != nil {
+			return nil, fmt.Errorf("cannot send code: %w", err)
+		}
+		challenge.code, challenge.expires = code, now.Add(h.expiration)
+		challenge.issues++
+		return &AuthResult{Response: []string{"code sent to " + contact}}, nil
+	}
+	if challenge.attempts >= h.attempts {
+		return nil, ErrTooManyRequests
+	}
+	if challenge.code == "" || !now.Before(challenge.expires) {
+		challenge.code = ""
+		return nil, ErrUnauthorized
+	}
+	if subtle.ConstantTimeCompare([]byte(challenge.code), []byte(data["code"])) != 1 {
+		challenge.attempts++
+		if challenge.attempts >= h.attempts {
+			challenge.code = ""
+		}
+		return nil, ErrUnauthorized
+	}
+	challenge.code = ""
+	return &AuthResult{Authenticated: true, VoterID: contact}, nil
+}
+
+// prune removes the challenges whose password and limits window are expired,
+// at most once every otpPruneInterval.  The lock must be held.
+func (h *OTPHandler) prune(now time.Time) {
+	if now.Sub(h.lastPrune) < otpPruneInterval {
+		return
+	}
+	h.lastPrune = now
+	for key, challenge := range h.challenges {
+		if !now.Before(challenge.window) && !now.Before(challenge.expires) {
+			delete(h.challenges, key)
+		}
+	}
+}
+
+// randomCode returns a random numeric code of the given length.
+func randomCode(length int) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(length)), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", length, n), nil
+}
+
+// OAuthVerifier resolves the OAuth access tokens of the voters to the
+// identifier of their user on the OAuth provider.
+type OAuthVerifier interface {
+	UserID(accessToken string) (string, error)
+}
+
+// OAuthHandler authenticates the voters with an OAuth access token, which is
+// verified against the provider by an OAuthVerifier.
+type OAuthHandler struct {
+	verifier OAuthVerifier
+}
+
+// NewOAuthHandler returns an OAuthHandler using verifier.
+func NewOAuthHandler(verifier OAuthVerifier) *OAuthHandler {
+	return &OAuthHandler{verifier: verifier}
+}
+
+// Name implements AuthHandler.
+func (*OAuthHandler) Name() string {
+	return "oauth"
+}
+
+// Auth implements AuthHandler.  The data must contain the "accessToken" field.
+func (h *OAuthHandler) Auth(_ []byte, data map[string]string) (*AuthResult, error) {
+	if data["accessToken"] == "" {
+		return nil, fmt.Errorf("missing access token")
+	}
+	userID, err := h.verifier.UserID(data["accessToken"])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+	return &AuthResult{Authenticated: true, VoterID: userID}, nil
+}
+
+// OAuthStub is an OAuthVerifier with a static set of access tokens, standing
+// for an OAuth provider for testing and development purposes.
+type OAuthStub struct {
+	tokens sync.Map
+}
+
+// AddToken makes the stub resolve the access token to the user identifier.
+func (s *OAuthStub) AddToken(accessToken, userID string) {
+	s.tokens.Store(accessToken, userID)
+}
+
+// UserID implements OAuthVerifier.
+func (s *OAuthStub) UserID(accessToken string) (string, error) {
+	userID, ok := s.tokens.Load(accessToken)
+	if !ok {
+		return "", fmt.Errorf("unknown access token")
+	}
+	return userID.(string), nil
+}
@@ -0,0 +1,356 @@
+// Package csp implements a reference Credential Service Provider (CSP).  The
+// CSP authenticates the voters of an election through pluggable handlers and
+// signs their census proof of type OFF_CHAIN_CA, with a key salted with the
+// election identifier, so the census root of all the elections is the CSP
+// public key.  Blind signatures keep the voter address hidden from the CSP.
+// Each voter gets at most one signature per election.
+package csp
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	blind "github.com/arnaucube/go-blindsecp256k1"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/crypto/saltedkey"
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// SignatureTypeBlind issues blind signatures, the voter sends the blinded
+	// hash of the CSP bundle.  The proof type is ECDSA_BLIND_PIDSALTED.
+	SignatureTypeBlind = "blind"
+	// SignatureTypeECDSA issues plain signatures, the voter sends its address
+	// and the CSP signs the bundle.  The proof type is ECDSA_PIDSALTED.
+	SignatureTypeECDSA = "ecdsa"
+
+	// AuthTokenExpiration is the time an auth token can be used for signing
+	AuthTokenExpiration = 10 * time.Minute
+)
+
+const (
+	keyPrefixToken  = "token/"
+	keyPrefixSigned = "signed/"
+)
+
+var (
+	// ErrUnauthorized is returned when the voter authentication fails
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrAlreadySigned is returned when the voter already got a signature for the election
+	ErrAlreadySigned = errors.New("voter already signed for the election")
+	// ErrInvalidToken is returned when the auth token is unknown or expired
+	ErrInvalidToken = errors.New("invalid auth token")
+	// ErrTooManyRequests is returned when the voter exceeds the authentication
+	// limits of a handler
+	ErrTooManyRequests = errors.New("too many requests, try again later")
+)
+
+// session is the state of an authenticated voter, waiting for the signature.
+type session struct {
+	ElectionID    types.HexBytes `json:"electionId"`
+	Voter         types.HexBytes `json:"voter"`
+	SignatureType string         `json:"signatureType"`
+	// K is the secret of the blind signature request
+	K       types.HexBytes `json:"k,omitempty"`
+	Expires int64          `json:"expires"`
+}
+
+// CSP is a Credential Service Provider, which persists the auth tokens and
+// the signatures issued in a database.
+type CSP struct {
+	signer   *ethereum.SignKeys
+	db       db.Database
+	handlers map[string]AuthHandler
+	// lock serializes the signatures, so a voter cannot sign twice
+	lock sync.Mutex
+}
+
+// New returns a CSP signing with signer and storing its state on database.
+func New(signer *ethereum.SignKeys, database db.Database) *CSP {
+	return &CSP{
+		signer:   signer,
+		db:       database,
+		handlers: make(map[string]AuthHandler),
+	}
+}
+
+// AddAuthHandler enables the authentication handler on the CSP.
+func (c *CSP) AddAuthHandler(handler AuthHandler) {
+	c.handlers[handler.Name()] = handler
+}
+
+// AuthHandlers returns the names of the authentication handlers enabled.
+func (c *CSP) AuthHandlers() []string {
+	names := []string{}
+	for name := range c.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PublicKey returns the compressed public key of the CSP, to be used as the
+// census root of the elections.
+func (c *CSP) PublicKey() types.HexBytes {
+	return c.signer.PublicKey()
+}
+
+// ProofType returns the CSP proof type of the signature type.
+func ProofType(signatureType string) (models.ProofCA_Type, error) {
+	switch signatureType {
+	case SignatureTypeBlind:
+		return models.ProofCA_ECDSA_BLIND_PIDSALTED, nil
+	case SignatureTypeECDSA:
+		return models.ProofCA_ECDSA_PIDSALTED, nil
+	default:
+		return 0, fmt.Errorf("invalid signature type %q", signatureType)
+	}
+}
+
+// Auth runs an authentication step of the voter for the election with the
+// handler.  Once the voter is authenticated, it returns the auth token for
+// requesting the signature of the given type, and for blind signatures the
+// signer R point needed to blind the message.
+func (c *CSP) Auth(handlerName string, electionID []byte, signatureType string,
+	data map[string]string) (*AuthResponse, error) {
+	if len(electionID) != types.ProcessIDsize {
+		return nil, fmt.Errorf("invalid election ID %x", electionID)
+	}
+	if _, err := ProofType(signatureType); err != nil {
+		return nil, err
+	}
+	handler, ok := c.handlers[handlerName]
+	if !ok {
+		return nil, fmt.Errorf("auth handler %q not found", handlerName)
+	}
+	result, err := handler.Auth(electionID, data)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Authenticated {
+		return &AuthResponse{Response: result.Response}, nil
+	}
+	voter := voterKey(handlerName, result.VoterID)
+	signed, err := c.signed(electionID, voter)
+	if err != nil {
+		return nil, err
+	}
+	if signed {
+		return nil, ErrAlreadySigned
+	}
+	s := &session{
+		ElectionID:    electionID,
+		Voter:         voter,
+		SignatureType: signatureType,
+		Expires:       time.Now().Add(AuthTokenExpiration).Unix(),
+	}
+	resp := &AuthResponse{Response: result.Response, AuthToken: util.RandomBytes(32)}
+	if signatureType == SignatureTypeBlind {
+		k, signerR, err := newBlindRequestParameters()
+		if err != nil {
+			return nil, err
+		}
+		s.K = k.Bytes()
+		resp.SignerR = signerR.Bytes()
+	}
+	sessionBytes, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	wTx := c.db.WriteTx()
+	defer wTx.Discard()
+	if err := wTx.Set(append([]byte(keyPrefixToken), resp.AuthToken...), sessionBytes); err != nil {
+		return nil, err
+	}
+	if err := wTx.Commit(); err != nil {
+		return nil, err
+	}
+	log.Debugw("csp voter authenticated", map[string]interface{}{
+		"handler":    handlerName,
+		"electionID": fmt.Sprintf("%x", electionID),
+		"type":       signatureType,
+	})
+	return resp, nil
+}
+
+// Sign issues the signature of the voter holding the auth token for the
+// election.  For blind signatures the payload is the blinded message and the
+// result is the blind signature, to be unblinded by the voter.  For ECDSA
+// signatures the payload is the voter address and the result is the signature
+// of the CSP bundle.  The auth token is consumed.
+func (c *CSP) Sign(electionID, authToken, payload []byte) (types.HexBytes, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	tokenKey := append([]byte(keyPrefixToken), authToken...)
+	rTx := c.db.ReadTx()
+	sessionBytes, err := rTx.Get(tokenKey)
+	rTx.Discard()
+	if errors.Is(err, db.ErrKeyNotFound) {
+		return nil, ErrInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+	s := &session{}
+	if err := json.Unmarshal(sessionBytes, s); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal session: %w", err)
+	}
+	if time.Now().Unix() > s.Expires || string(s.ElectionID) != string(electionID) {
+		return nil, ErrInvalidToken
+	}
+	signed, err := c.signed(electionID, s.Voter)
+	if err != nil {
+		return nil, err
+	}
+	if signed {
+		return nil, ErrAlreadySigned
+	}
+	var signature []byte
+	switch s.SignatureType {
+	case SignatureTypeBlind:
+		signature, err = c.signBlind(electionID, new(big.Int).SetBytes(s.K), payload)
+	case SignatureTypeECDSA:
+		signature, err = c.signECDSA(electionID, payload)
+	default:
+		err = fmt.Errorf("invalid signature type %q", s.SignatureType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	wTx := c.db.WriteTx()
+	defer wTx.Discard()
+	var timestamp [8]byte
+	binary.BigEndian.PutUint64(timestamp[:], uint64(time.Now().Unix()))
+	if err := wTx.Set(signedKey(electionID, s.Voter), timestamp[:]); err != nil {
+		return nil, err
+	}
+	if err := wTx.Delete(tokenKey); err != nil {
+		return nil, err
+	}
+	if err := wTx.Commit(); err != nil {
+		return nil, err
+	}
+	return signature, nil
+}
+
+// PruneTokens removes the expired auth tokens from the database, which were
+// never used for signing, and returns how many were removed.  It should be
+// called periodically, such as every AuthTokenExpiration.
+func (c *CSP) PruneTokens() (int, error) {
+	now := time.Now().Unix()
+	expired := [][]byte{}
+	if err := c.db.Iterate([]byte(keyPrefixToken), func(key, value []byte) bool {
+		s := &session{}
+		if err := json.Unmarshal(value, s); err != nil || now > s.Expires {
+			expired = append(expired, append([]byte(keyPrefixToken), key...))
+		}
+		return true
+	}); err != nil {
+		return 0, err
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	wTx := c.db.WriteTx()
+	defer wTx.Discard()
+	for _, key := range expired {
+		if err := wTx.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+	return len(expired), wTx.Commit()
+}
+
+// signBlind blind signs the blinded message with the key salted with the
+// election ID and the secret k of the request.
+func (c *CSP) signBlind(electionID []byte, k *big.Int, payload []byte) ([]byte, error) {
+	if len(payload) != 32 {
+		return nil, fmt.Errorf("invalid blinded message size %d", len(payload))
+	}
+	privKey, err := saltedkey.SaltBlindPrivKey(
+		(*blind.PrivateKey)(new(big.Int).Set(c.signer.Private.D)), electionID)
+	if err != nil {
+		return nil, err
+	}
+	sBlind, err := privKey.BlindSign(new(big.Int).SetBytes(payload), k)
+	if err != nil {
+		return nil, fmt.Errorf("cannot blind sign: %w", err)
+	}
+	return math.PaddedBigBytes(sBlind, 32), nil
+}
+
+// signECDSA signs the CSP bundle of the voter address for the election with
+// the key salted with the election ID.
+func (c *CSP) signECDSA(electionID []byte, payload []byte) ([]byte, error) {
+	if len(payload) != common.AddressLength {
+		return nil, fmt.Errorf("invalid address size %d", len(payload))
+	}
+	privKey, err := saltedkey.SaltECDSAPrivKey(&c.signer.Private, electionID)
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := proto.Marshal(&models.CAbundle{ProcessId: electionID, Address: payload})
+	if err != nil {
+		return nil, err
+	}
+	signer := ethereum.SignKeys{Private: *privKey, Public: privKey.PublicKey}
+	return signer.SignEthereum(bundle)
+}
+
+// signed returns whether the voter already got a signature for the election.
+func (c *CSP) signed(electionID, voter []byte) (bool, error) {
+	rTx := c.db.ReadTx()
+	defer rTx.Discard()
+	_, err := rTx.Get(signedKey(electionID, voter))
+	if errors.Is(err, db.ErrKeyNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Signed returns whether the voter identified by the handler already got a
+// signature for the election.
+func (c *CSP) Signed(handlerName, voterID string, electionID []byte) (bool, error) {
+	return c.signed(electionID, voterKey(handlerName, voterID))
+}
+
+// voterKey returns the identifier of the voter stored in the database, which
+// hides the voter identity of the handler.
+func voterKey(handlerName, voterID string) []byte {
+	return ethereum.HashRaw([]byte(handlerName + "/" + voterID))
+}
+
+// signedKey returns the database key of the signature of the voter for the election.
+func signedKey(electionID, voter []byte) []byte {
+	key := append([]byte(keyPrefixSigned), electionID...)
+	return append(key, voter...)
+}
+
+// newBlindRequestParameters returns the secret k and the public R point of a
+// blind signature request, making sure k is a 32 bytes scalar as required
+// by the blind signature.
+func newBlindRequestParameters() (*big.Int, *blind.Point, error) {
+	for {
+		k, signerR, err := blind.NewRequestParameters()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(k.Bytes()) == 32 {
+			return k, signerR, nil
+		}
+	}
+}
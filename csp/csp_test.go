@@ -0,0 +1,324 @@
+package csp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	blind "github.com/arnaucube/go-blindsecp256k1"
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/db/metadb"
+	"go.vocdoni.io/dvote/httprouter"
+	"go.vocdoni.io/dvote/httprouter/apirest"
+	"go.vocdoni.io/dvote/test/testcommon/testutil"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain/transaction"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+func newTestCSP(t *testing.T) *CSP {
+	signer := ethereum.NewSignKeys()
+	qt.Assert(t, signer.Generate(), qt.IsNil)
+	return New(signer, metadb.NewTest(t))
+}
+
+// verifyProof checks the CSP signature of the voter for the election is a
+// valid vote proof with the CSP public key as census root.
+func verifyProof(t *testing.T, c *CSP, electionID []byte, voter *ethereum.SignKeys,
+	proofType models.ProofCA_Type, signature []byte) error {
+	proof := &models.Proof{Payload: &models.Proof_Ca{Ca: &models.ProofCA{
+		Type:      proofType,
+		Bundle:    &models.CAbundle{ProcessId: electionID, Address: voter.Address().Bytes()},
+		Signature: signature,
+	}}}
+	valid, _, err := transaction.VerifyProofOffChainCSP(nil, proof, models.CensusOrigin_OFF_CHAIN_CA,
+		c.PublicKey(), electionID, voter.PublicKey(), voter.Address())
+	if err != nil {
+		return err
+	}
+	qt.Assert(t, valid, qt.IsTrue)
+	return nil
+}
+
+// blindSign runs the client side of a blind signature for the voter.
+func blindSign(t *testing.T, c *CSP, electionID []byte, voter *ethereum.SignKeys,
+	resp *AuthResponse) ([]byte, error) {
+	signerR, err := blind.NewPointFromBytes(resp.SignerR)
+	qt.Assert(t, err, qt.IsNil)
+	bundle, err := proto.Marshal(&models.CAbundle{
+		ProcessId: electionID,
+		Address:   voter.Address().Bytes(),
+	})
+	qt.Assert(t, err, qt.IsNil)
+	msg := new(big.Int).SetBytes(ethereum.HashRaw(bundle))
+	// the blinded message must be 32 bytes long
+	var msgBlinded *big.Int
+	var userSecret *blind.UserSecretData
+	for msgBlinded == nil || len(msgBlinded.Bytes()) != 32 {
+		msgBlinded, userSecret, err = blind.Blind(msg, signerR)
+		qt.Assert(t, err, qt.IsNil)
+	}
+	sBlind, err := c.Sign(electionID, resp.AuthToken, msgBlinded.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return blind.Unblind(new(big.Int).SetBytes(sBlind), userSecret).BytesUncompressed(), nil
+}
+
+func TestBlindSignature(t *testing.T) {
+	c := newTestCSP(t)
+	c.AddAuthHandler(NewAllowlistHandler(map[string]string{"alice": "secret1", "bob": "secret2"}))
+	electionID := util.RandomBytes(types.ProcessIDsize)
+
+	_, err := c.Auth("allowlist", electionID, SignatureTypeBlind,
+		map[string]string{"voter": "alice", "secret": "secret2"})
+	qt.Assert(t, err, qt.ErrorIs, ErrUnauthorized)
+	_, err = c.Auth("unknown", electionID, SignatureTypeBlind,
+		map[string]string{"voter": "alice", "secret": "secret1"})
+	qt.Assert(t, err, qt.IsNotNil)
+
+	resp, err := c.Auth("allowlist", electionID, SignatureTypeBlind,
+		map[string]string{"voter": "alice", "secret": "secret1"})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.AuthToken, qt.HasLen, 32)
+	voter := ethereum.NewSignKeys()
+	qt.Assert(t, voter.Generate(), qt.IsNil)
+	signature, err := blindSign(t, c, electionID, voter, resp)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, verifyProof(t, c, electionID, voter, models.ProofCA_ECDSA_BLIND_PIDSALTED,
+		signature), qt.IsNil)
+	// the signature is salted with the election ID
+	qt.Assert(t, verifyProof(t, c, electionID, voter, models.ProofCA_ECDSA_BLIND,
+		signature), qt.IsNotNil)
+
+	// the auth token is consumed and the voter cannot sign again
+	_, err = blindSign(t, c, electionID, voter, resp)
+	qt.Assert(t, err, qt.ErrorIs, ErrInvalidToken)
+	_, err = c.Auth("allowlist", electionID, SignatureTypeBlind,
+		map[string]string{"voter": "alice", "secret": "secret1"})
+	qt.Assert(t, err, qt.ErrorIs, ErrAlreadySigned)
+
+	// but other voters can, and the same voter in other elections
+	resp, err = c.Auth("allowlist", electionID, SignatureTypeBlind,
+		map[string]string{"voter": "bob", "secret": "secret2"})
+	qt.Assert(t, err, qt.IsNil)
+	_, err = blindSign(t, c, electionID, voter, resp)
+	qt.Assert(t, err, qt.IsNil)
+	electionID2 := util.RandomBytes(types.ProcessIDsize)
+	resp, err = c.Auth("allowlist", electionID2, SignatureTypeBlind,
+		map[string]string{"voter": "alice", "secret": "secret1"})
+	qt.Assert(t, err, qt.IsNil)
+	// the auth token is bound to its election
+	_, err = blindSign(t, c, electionID, voter, resp)
+	qt.Assert(t, err, qt.ErrorIs, ErrInvalidToken)
+	signature, err = blindSign(t, c, electionID2, voter, resp)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, verifyProof(t, c, electionID2, voter, models.ProofCA_ECDSA_BLIND_PIDSALTED,
+		signature), qt.IsNil)
+}
+
+func TestECDSASignature(t *testing.T) {
+	c := newTestCSP(t)
+	sender := &MockSender{}
+	c.AddAuthHandler(NewOTPHandler("sms", sender))
+	electionID := util.RandomBytes(types.ProcessIDsize)
+	voter := ethereum.NewSignKeys()
+	qt.Assert(t, voter.Generate(), qt.IsNil)
+
+	// first step sends the code
+	resp, err := c.Auth("sms", electionID, SignatureTypeECDSA,
+		map[string]string{"contact": "+34600000000"})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.AuthToken, qt.IsNil)
+	msg := sender.LastMessage("+34600000000")
+	code := msg[strings.LastIndex(msg, " ")+1:]
+	qt.Assert(t, code, qt.HasLen, DefaultOTPLength)
+
+	_, err = c.Auth("sms", electionID, SignatureTypeECDSA,
+		map[string]string{"contact": "+34600000000", "code": "wrong"})
+	qt.Assert(t, err, qt.ErrorIs, ErrUnauthorized)
+	resp, err = c.Auth("sms", electionID, SignatureTypeECDSA,
+		map[string]string{"contact": "+34600000000", "code": code})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.SignerR, qt.IsNil)
+	// the code cannot be reused
+	_, err = c.Auth("sms", electionID, SignatureTypeECDSA,
+		map[string]string{"contact": "+34600000000", "code": code})
+	qt.Assert(t, err, qt.ErrorIs, ErrUnauthorized)
+
+	signature, err := c.Sign(electionID, resp.AuthToken, voter.Address().Bytes())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, verifyProof(t, c, electionID, voter, models.ProofCA_ECDSA_PIDSALTED,
+		signature), qt.IsNil)
+	qt.Assert(t, verifyProof(t, c, electionID, voter, models.ProofCA_ECDSA,
+		signature), qt.IsNotNil)
+
+	// the issuance is persisted
+	signed, err := c.Signed("sms", "+34600000000", electionID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, signed, qt.IsTrue)
+	c2 := New(c.signer, c.db)
+	c2.AddAuthHandler(NewOTPHandler("sms", sender))
+	_, err = c2.Auth("sms", electionID, SignatureTypeECDSA,
+		map[string]string{"contact": "+34600000000"})
+	qt.Assert(t, err, qt.IsNil)
+	msg = sender.LastMessage("+34600000000")
+	_, err = c2.Auth("sms", electionID, SignatureTypeECDSA,
+		map[string]string{"contact": "+34600000000", "code": msg[strings.LastIndex(msg, " ")+1:]})
+	qt.Assert(t, err, qt.ErrorIs, ErrAlreadySigned)
+}
+
+func TestOTPLimits(t *testing.T) {
+	sender := &MockSender{}
+	h := NewOTPHandler("sms", sender)
+	electionID := util.RandomBytes(types.ProcessIDsize)
+	contact := "+34600000000"
+	auth := func(code string) error {
+		data := map[string]string{"contact": contact}
+		if code != "" {
+			data["code"] = code
+		}
+		_, err := h.Auth(electionID, data)
+		return err
+	}
+	lastCode := func() string {
+		msg := sender.LastMessage(contact)
+		return msg[strings.LastIndex(msg, " ")+1:]
+	}
+
+	// the failed attempts are not reset by issuing a new password
+	qt.Assert(t, auth(""), qt.IsNil)
+	qt.Assert(t, auth("wrong"), qt.ErrorIs, ErrUnauthorized)
+	qt.Assert(t, auth("wrong"), qt.ErrorIs, ErrUnauthorized)
+	qt.Assert(t, auth(""), qt.IsNil)
+	qt.Assert(t, auth("wrong"), qt.ErrorIs, ErrUnauthorized)
+	qt.Assert(t, auth(lastCode()), qt.ErrorIs, ErrTooManyRequests)
+	// neither are the passwords issued
+	qt.Assert(t, auth(""), qt.IsNil)
+	qt.Assert(t, auth(""), qt.ErrorIs, ErrTooManyRequests)
+	// the limits are per election
+	_, err := h.Auth(util.RandomBytes(types.ProcessIDsize), map[string]string{"contact": contact})
+	qt.Assert(t, err, qt.IsNil)
+
+	// the limits are reset when the window ends
+	for _, challenge := range h.challenges {
+		challenge.window = time.Now()
+	}
+	qt.Assert(t, auth(""), qt.IsNil)
+	qt.Assert(t, auth(lastCode()), qt.IsNil)
+
+	// the expired challenges are pruned
+	h.prune(time.Now().Add(DefaultOTPWindow + DefaultOTPExpiration))
+	qt.Assert(t, h.challenges, qt.HasLen, 0)
+}
+
+func TestPruneTokens(t *testing.T) {
+	c := newTestCSP(t)
+	c.AddAuthHandler(NewAllowlistHandler(map[string]string{"alice": "secret1", "bob": "secret2"}))
+	electionID := util.RandomBytes(types.ProcessIDsize)
+	voter := ethereum.NewSignKeys()
+	qt.Assert(t, voter.Generate(), qt.IsNil)
+
+	alice, err := c.Auth("allowlist", electionID, SignatureTypeECDSA,
+		map[string]string{"voter": "alice", "secret": "secret1"})
+	qt.Assert(t, err, qt.IsNil)
+	bob, err := c.Auth("allowlist", electionID, SignatureTypeECDSA,
+		map[string]string{"voter": "bob", "secret": "secret2"})
+	qt.Assert(t, err, qt.IsNil)
+
+	// expire the token of bob
+	tokenKey := append([]byte(keyPrefixToken), bob.AuthToken...)
+	wTx := c.db.WriteTx()
+	sessionBytes, err := wTx.Get(tokenKey)
+	qt.Assert(t, err, qt.IsNil)
+	s := &session{}
+	qt.Assert(t, json.Unmarshal(sessionBytes, s), qt.IsNil)
+	s.Expires = time.Now().Add(-time.Second).Unix()
+	sessionBytes, err = json.Marshal(s)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, wTx.Set(tokenKey, sessionBytes), qt.IsNil)
+	qt.Assert(t, wTx.Commit(), qt.IsNil)
+
+	n, err := c.PruneTokens()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, n, qt.Equals, 1)
+	_, err = c.Sign(electionID, bob.AuthToken, voter.Address().Bytes())
+	qt.Assert(t, err, qt.ErrorIs, ErrInvalidToken)
+	_, err = c.Sign(electionID, alice.AuthToken, voter.Address().Bytes())
+	qt.Assert(t, err, qt.IsNil)
+	n, err = c.PruneTokens()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, n, qt.Equals, 0)
+}
+
+func TestAPI(t *testing.T) {
+	c := newTestCSP(t)
+	oauth := &OAuthStub{}
+	oauth.AddToken("token1", "user1")
+	c.AddAuthHandler(NewOAuthHandler(oauth))
+
+	router := httprouter.HTTProuter{}
+	rng := testutil.NewRandom(0)
+	port := 24000 + rng.RandomIntn(1024)
+	qt.Assert(t, router.Init("127.0.0.1", port), qt.IsNil)
+	api, err := apirest.NewAPI(&router, "/csp")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, AttachCSPAPI(c, api, "/v1"), qt.IsNil)
+	url := fmt.Sprintf("http://127.0.0.1:%d/csp/v1", port)
+
+	info := &Info{}
+	qt.Assert(t, doRequest(t, "GET", url+"/info", nil, info), qt.Equals, http.StatusOK)
+	qt.Assert(t, info.PublicKey, qt.DeepEquals, c.PublicKey())
+	qt.Assert(t, info.AuthHandlers, qt.DeepEquals, []string{"oauth"})
+
+	electionID := types.HexBytes(util.RandomBytes(types.ProcessIDsize))
+	authURL := fmt.Sprintf("%s/%x/auth/oauth", url, electionID)
+	authResp := &AuthResponse{}
+	qt.Assert(t, doRequest(t, "POST", authURL, &AuthRequest{
+		SignatureType: SignatureTypeECDSA,
+		Data:          map[string]string{"accessToken": "token2"},
+	}, authResp), qt.Equals, apirest.HTTPstatusCodeErr)
+	qt.Assert(t, doRequest(t, "POST", authURL, &AuthRequest{
+		SignatureType: SignatureTypeECDSA,
+		Data:          map[string]string{"accessToken": "token1"},
+	}, authResp), qt.Equals, http.StatusOK)
+
+	voter := ethereum.NewSignKeys()
+	qt.Assert(t, voter.Generate(), qt.IsNil)
+	signResp := &SignResponse{}
+	qt.Assert(t, doRequest(t, "POST", fmt.Sprintf("%s/%x/sign", url, electionID), &SignRequest{
+		AuthToken: authResp.AuthToken,
+		Payload:   voter.Address().Bytes(),
+	}, signResp), qt.Equals, http.StatusOK)
+	qt.Assert(t, verifyProof(t, c, electionID, voter, models.ProofCA_ECDSA_PIDSALTED,
+		signResp.Signature), qt.IsNil)
+}
+
+func doRequest(t *testing.T, method, url string, body, resp interface{}) int {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		qt.Assert(t, err, qt.IsNil)
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, reqBody)
+	qt.Assert(t, err, qt.IsNil)
+	r, err := http.DefaultClient.Do(req)
+	qt.Assert(t, err, qt.IsNil)
+	defer r.Body.Close()
+	data, err := io.ReadAll(r.Body)
+	qt.Assert(t, err, qt.IsNil)
+	if r.StatusCode == http.StatusOK {
+		qt.Assert(t, json.Unmarshal(data, resp), qt.IsNil)
+	}
+	return r.StatusCode
+}
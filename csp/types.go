@@ -0,0 +1,42 @@
+package csp
+
+import "go.vocdoni.io/dvote/types"
+
+// Info is the response of the CSP info request.
+type Info struct {
+	// PublicKey is the CSP public key, the census root of the elections
+	PublicKey      types.HexBytes `json:"publicKey"`
+	AuthHandlers   []string       `json:"authHandlers"`
+	SignatureTypes []string       `json:"signatureTypes"`
+}
+
+// AuthRequest is the message of an authentication step.
+type AuthRequest struct {
+	// SignatureType is the type of the signature requested, blind or ecdsa
+	SignatureType string `json:"signatureType"`
+	// Data holds the handler specific authentication fields
+	Data map[string]string `json:"data"`
+}
+
+// AuthResponse is the response of an authentication step.
+type AuthResponse struct {
+	// Response holds the messages of the handler for the voter
+	Response []string `json:"response,omitempty"`
+	// AuthToken is returned once the voter is authenticated
+	AuthToken types.HexBytes `json:"authToken,omitempty"`
+	// SignerR is the point used to blind the message, for blind signatures
+	SignerR types.HexBytes `json:"signerR,omitempty"`
+}
+
+// SignRequest is the message requesting the signature.
+type SignRequest struct {
+	AuthToken types.HexBytes `json:"authToken"`
+	// Payload is the blinded message for blind signatures, or the voter
+	// address for ecdsa signatures
+	Payload types.HexBytes `json:"payload"`
+}
+
+// SignResponse is the response of the signature request.
+type SignResponse struct {
+	Signature types.HexBytes `json:"signature"`
+}
@@ -0,0 +1,8 @@
+module go.vocdoni.io/proto
+
+go 1.16
+
+require (
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013 // indirect
+	google.golang.org/protobuf v1.27.1
+)
@@ -2,8 +2,9 @@ module go.vocdoni.io/dvote
 
 go 1.19
 
-// For testing purposes while dvote-protobuf becomes stable
-// replace go.vocdoni.io/proto => ../dvote-protobuf
+// The schema changes not released yet are kept on dvote-protobuf, until they
+// are published and the dependency is bumped
+replace go.vocdoni.io/proto => ./dvote-protobuf
 
 replace github.com/timshannon/badgerhold/v3 => github.com/vocdoni/badgerhold/v3 v3.0.0-20210514115050-2d704df3456f
 
@@ -74,7 +75,10 @@ require (
 
 require github.com/iancoleman/strcase v0.2.0
 
-require github.com/rs/zerolog v1.28.0
+require (
+	github.com/google/orderedcode v0.0.1
+	github.com/rs/zerolog v1.28.0
+)
 
 require (
 	bazil.org/fuse v0.0.0-20200524192727-fb710f7dfd05 // indirect
@@ -146,7 +150,6 @@ require (
 	github.com/google/btree v1.0.1 // indirect
 	github.com/google/flatbuffers v2.0.8+incompatible // indirect
 	github.com/google/gopacket v1.1.19 // indirect
-	github.com/google/orderedcode v0.0.1 // indirect
 	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/grpc-ecosystem/go-grpc-middleware v1.3.0 // indirect
 	github.com/grpc-ecosystem/go-grpc-prometheus v1.2.0 // indirect
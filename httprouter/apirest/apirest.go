@@ -10,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"go.vocdoni.io/dvote/httprouter"
 	"go.vocdoni.io/dvote/log"
 )
@@ -39,6 +40,9 @@ type API struct {
 	adminToken     string
 	adminTokenLock sync.RWMutex
 	verboseAuthLog bool
+	// streamMethods holds the methods and patterns whose request body is
+	// not read in advance
+	streamMethods sync.Map
 }
 
 // APIdata is the data type used by the API.
@@ -110,6 +114,13 @@ func (a *API) AuthorizeRequest(data interface{},
 // ProcessData is a function for the RouterNamespace interface.
 // The body of the http requests and the bearer auth token are readed.
 func (b *API) ProcessData(req *http.Request) (interface{}, error) {
+	if rctx := chi.RouteContext(req.Context()); rctx != nil {
+		if _, ok := b.streamMethods.Load(req.Method + " " + rctx.RoutePattern()); ok {
+			return &APIdata{
+				AuthToken: strings.TrimPrefix(req.Header.Get("Authorization"), bearerPrefix),
+			}, nil
+		}
+	}
 	reqBody, err := io.ReadAll(req.Body)
 	if err != nil {
 		return nil, fmt.Errorf("HTTP connection closed: (%v)", err)
@@ -167,6 +178,16 @@ func (a *API) RegisterMethod(pattern, HTTPmethod string,
 	return nil
 }
 
+// RegisterStreamMethod adds a new method under the URL pattern, as
+// RegisterMethod does, but the request body is not read in advance.  So
+// APIdata.Data is always empty and the handler is expected to read the body
+// from the HTTPContext request, which allows streaming large request bodies.
+func (a *API) RegisterStreamMethod(pattern, HTTPmethod string,
+	accessType string, handler APIhandler) error {
+	a.streamMethods.Store(HTTPmethod+" "+path.Join(a.basePath, pattern), true)
+	return a.RegisterMethod(pattern, HTTPmethod, accessType, handler)
+}
+
 // SetAdminToken sets the bearer admin token capable to execute admin handlers
 func (a *API) SetAdminToken(bearerToken string) {
 	a.adminTokenLock.Lock()
@@ -1,7 +1,9 @@
 package httprouter
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
@@ -61,3 +63,83 @@ func (h *HTTPContext) Send(msg []byte, httpStatusCode int) error {
 	_, err := h.Writer.Write([]byte("\n"))
 	return err
 }
+
+// SendStream replies the request with the content written by the write
+// function, using the given content type.  The content is sent while it is
+// written, so it is never fully loaded in memory.  Once the write function
+// starts, the status code cannot be changed anymore, so any error returned
+// by it only aborts the response.
+func (h *HTTPContext) SendStream(contentType string, write func(w io.Writer) error) error {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Warnf("recovered http send stream panic: %v", r)
+		}
+	}()
+	defer close(h.sent)
+	defer h.Request.Body.Close()
+
+	if h.Request.Context().Err() != nil {
+		// The connection was closed, so don't try to write to it.
+		return fmt.Errorf("connection is closed")
+	}
+	h.Writer.Header().Set("Content-Type", contentType)
+	h.Writer.WriteHeader(http.StatusOK)
+	return write(h.Writer)
+}
+
+// ServerSentEvent is a message sent to the client with the text/event-stream
+// format. ID and Event are optional, and Data must not contain newlines.
+type ServerSentEvent struct {
+	ID    string
+	Event string
+	Data  []byte
+}
+
+// SendEvents replies the request with a stream of Server-Sent Events.  Every
+// event received from the events channel is written and flushed to the client,
+// until the channel is closed or the connection is closed.
+func (h *HTTPContext) SendEvents(events <-chan *ServerSentEvent) error {
+	flusher, ok := h.Writer.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("http response writer does not support streaming")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Warnf("recovered http send events panic: %v", r)
+		}
+	}()
+	defer close(h.sent)
+	defer h.Request.Body.Close()
+
+	if h.Request.Context().Err() != nil {
+		// The connection was closed, so don't try to write to it.
+		return fmt.Errorf("connection is closed")
+	}
+	h.Writer.Header().Set("Content-Type", "text/event-stream")
+	h.Writer.Header().Set("Cache-Control", "no-cache")
+	h.Writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-h.Request.Context().Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			var b bytes.Buffer
+			if event.ID != "" {
+				fmt.Fprintf(&b, "id: %s\n", event.ID)
+			}
+			if event.Event != "" {
+				fmt.Fprintf(&b, "event: %s\n", event.Event)
+			}
+			fmt.Fprintf(&b, "data: %s\n\n", event.Data)
+			if _, err := h.Writer.Write(b.Bytes()); err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -120,7 +120,11 @@ func (o *Oracle) OnComputeResults(results *indexertypes.Results, proc *indexerty
 	// check process status
 	switch vocProcessData.Status {
 	case models.ProcessStatus_READY:
-		if o.VochainApp.Height() < vocProcessData.StartBlock+vocProcessData.BlockCount {
+		finished := o.VochainApp.Height() >= vocProcessData.StartBlock+vocProcessData.BlockCount
+		if _, _, ok := state.ProcessTimeBounds(vocProcessData.Mode); ok {
+			finished = state.ProcessEndedAt(vocProcessData, o.VochainApp.TimestampStartBlock())
+		}
+		if !finished {
 			log.Warnf("process %x is in READY state and not yet finished, cannot publish results",
 				results.ProcessID)
 			return
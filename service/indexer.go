@@ -2,6 +2,7 @@ package service
 
 import (
 	"path/filepath"
+	"time"
 
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/vochain/indexer"
@@ -19,7 +20,32 @@ func (vs *VocdoniService) VochainIndexer() error {
 	if err != nil {
 		return err
 	}
-	// launch the indexer after sync routine (executed when the blockchain is ready)
-	go vs.Indexer.AfterSyncBootstrap()
+	// index the metadata downloaded by the offchain data handler
+	if vs.OffChainData != nil {
+		vs.OffChainData.SetIndexer(vs.Indexer)
+	}
+	rebuild := vs.Config.Indexer.Rebuild
+	if !rebuild {
+		// resume an interrupted rebuild
+		if rebuild, err = vs.Indexer.RebuildPending(); err != nil {
+			return err
+		}
+	}
+	go func() {
+		// the blocks are read from the blockstore of the node, once started
+		for vs.App.Node == nil {
+			time.Sleep(time.Second)
+		}
+		if rebuild {
+			if err := vs.Indexer.Rebuild(); err != nil {
+				log.Errorf("cannot rebuild the indexer: %v", err)
+			}
+		}
+		// launch the indexer after sync routine (executed when the blockchain is ready)
+		go vs.Indexer.AfterSyncBootstrap()
+		if err := vs.Indexer.BackfillTxReferences(); err != nil {
+			log.Errorf("cannot backfill the indexed transactions: %v", err)
+		}
+	}()
 	return nil
 }
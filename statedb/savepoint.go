@@ -0,0 +1,144 @@
+package statedb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.vocdoni.io/dvote/db"
+)
+
+// ErrSavepointActive is returned when a savepoint is created while another
+// one is still active.  Savepoints cannot be nested.
+var ErrSavepointActive = errors.New("savepoint already active")
+
+// ErrNoSavepoint is returned when a savepoint is released or rolled back
+// without being created.
+var ErrNoSavepoint = errors.New("no active savepoint")
+
+// undoEntry is the value of a key before it was written in a savepoint.
+type undoEntry struct {
+	key    []byte
+	value  []byte
+	exists bool
+}
+
+// savepointTx is a db.WriteTx that records the previous value of the keys
+// written while a savepoint is active, so that they can be restored.  It is
+// used as the root transaction of the TreeTx, so it sees the writes of the
+// mainTree, all the subTrees and their NoState databases.
+type savepointTx struct {
+	db.WriteTx
+	active bool
+	// dirtyTree is the TreeTx.dirtyTree value when the savepoint was
+	// created.
+	dirtyTree bool
+	undo      []undoEntry
+	written   map[string]bool
+}
+
+// Unwrap returns the wrapped WriteTx.
+func (t *savepointTx) Unwrap() db.WriteTx {
+	return t.WriteTx
+}
+
+// record stores the current value of key, if it is the first time the key is
+// written in the active savepoint.
+func (t *savepointTx) record(key []byte) error {
+	if !t.active || t.written[string(key)] {
+		return nil
+	}
+	value, err := t.WriteTx.Get(key)
+	exists := true
+	if errors.Is(err, db.ErrKeyNotFound) {
+		exists = false
+	} else if err != nil {
+		return err
+	}
+	t.written[string(key)] = true
+	t.undo = append(t.undo, undoEntry{
+		key:    append([]byte{}, key...),
+		value:  append([]byte{}, value...),
+		exists: exists,
+	})
+	return nil
+}
+
+// Set implements db.WriteTx.Set, recording the previous value of the key.
+func (t *savepointTx) Set(key, value []byte) error {
+	if err := t.record(key); err != nil {
+		return err
+	}
+	return t.WriteTx.Set(key, value)
+}
+
+// Delete implements db.WriteTx.Delete, recording the previous value of the
+// key.
+func (t *savepointTx) Delete(key []byte) error {
+	if err := t.record(key); err != nil {
+		return err
+	}
+	return t.WriteTx.Delete(key)
+}
+
+// Savepoint marks the current state of the TreeTx, so that the changes made
+// from then on can be discarded with RollbackSavepoint, or kept with
+// ReleaseSavepoint.  Savepoints cannot be nested.
+func (t *TreeTx) Savepoint() error {
+	if t.sp.active {
+		return ErrSavepointActive
+	}
+	// Propagate the roots of the opened subTrees, so that all of them can
+	// be closed.  The subTrees opened after the savepoint are discarded on
+	// rollback, since they may not exist on the restored state.
+	root, err := propagateRoot(&t.TreeUpdate)
+	if err != nil {
+		return err
+	}
+	t.openSubs = sync.Map{}
+	if root != nil {
+		t.dirtyTree = true
+	}
+	t.sp.active = true
+	t.sp.dirtyTree = t.dirtyTree
+	t.sp.undo = nil
+	t.sp.written = make(map[string]bool)
+	return nil
+}
+
+// ReleaseSavepoint keeps the changes made since the savepoint was created.
+func (t *TreeTx) ReleaseSavepoint() error {
+	if !t.sp.active {
+		return ErrNoSavepoint
+	}
+	t.sp.active = false
+	t.sp.undo = nil
+	t.sp.written = nil
+	return nil
+}
+
+// RollbackSavepoint discards the changes made since the savepoint was
+// created.
+func (t *TreeTx) RollbackSavepoint() error {
+	if !t.sp.active {
+		return ErrNoSavepoint
+	}
+	t.sp.active = false
+	for i := len(t.sp.undo) - 1; i >= 0; i-- {
+		entry := t.sp.undo[i]
+		var err error
+		if entry.exists {
+			err = t.sp.WriteTx.Set(entry.key, entry.value)
+		} else {
+			err = t.sp.WriteTx.Delete(entry.key)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot restore key %x: %w", entry.key, err)
+		}
+	}
+	t.openSubs = sync.Map{}
+	t.dirtyTree = t.sp.dirtyTree
+	t.sp.undo = nil
+	t.sp.written = nil
+	return nil
+}
@@ -289,6 +289,28 @@ func (s *StateDB) Hash() ([]byte, error) {
 // either call treeTx.Commit or treeTx.Discard if BeginTx doesn't return an
 // error.  Calling treeTx.Discard after treeTx.Commit is ok.
 func (s *StateDB) BeginTx() (treeTx *TreeTx, err error) {
+	return s.beginTx(s.getRoot)
+}
+
+// BeginTxAt creates a new transaction for the StateDB like BeginTx, but with
+// the mainTree opened at the root of the given version instead of the last
+// one.  It allows to apply again the updates made after a past version.  The
+// returned TreeTx must be discarded: committing it would store the version
+// over the ones that follow it.
+func (s *StateDB) BeginTxAt(version uint32) (*TreeTx, error) {
+	treeTx, err := s.beginTx(func(tx db.ReadTx) ([]byte, error) {
+		return s.getVersionRoot(tx, version)
+	})
+	if err != nil {
+		return nil, err
+	}
+	treeTx.discardOnly = true
+	return treeTx, nil
+}
+
+// beginTx creates a new TreeTx with the mainTree opened at the root returned
+// by getRoot.
+func (s *StateDB) beginTx(getRoot func(db.ReadTx) ([]byte, error)) (treeTx *TreeTx, err error) {
 	cfg := mainTreeCfg
 	// NOTE(Edu): The introduction of Batched Txs here came from the fact
 	// that Badger takes a lot of memory and as a preconfigured maximum
@@ -302,13 +324,14 @@ func (s *StateDB) BeginTx() (treeTx *TreeTx, err error) {
 	// `TestBlockMemoryUsage` in `vochain/state_test.go` this should be
 	// reasonable.
 	// tx := db.NewBatch(s.db)
-	tx := s.db.WriteTx()
+	sp := &savepointTx{WriteTx: s.db.WriteTx()}
+	tx := db.WriteTx(sp)
 	defer func() {
 		if err != nil {
 			tx.Discard()
 		}
 	}()
-	root, err := s.getRoot(tx)
+	root, err := getRoot(tx)
 	if err != nil {
 		return nil, err
 	}
@@ -329,6 +352,7 @@ func (s *StateDB) BeginTx() (treeTx *TreeTx, err error) {
 	}
 	return &TreeTx{
 		sdb: s,
+		sp:  sp,
 		TreeUpdate: TreeUpdate{
 			tx: tx,
 			tree: treeWithTx{
@@ -353,6 +377,10 @@ type readOnlyWriteTx struct {
 // that we set up for read only.
 var ErrReadOnly = errors.New("read only")
 
+// ErrDiscardOnly is returned when committing a TreeTx opened at a past
+// version with BeginTxAt.
+var ErrDiscardOnly = errors.New("tree tx opened at a past version can only be discarded")
+
 // ErrEmptyTree is returned when a tree is opened for read-only but hasn't been
 // created yet.
 var ErrEmptyTree = errors.New("empty tree")
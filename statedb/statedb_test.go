@@ -310,6 +310,48 @@ func TestSubTree(t *testing.T) {
 	}
 }
 
+func TestPruneSubTree(t *testing.T) {
+	sdb := NewStateDB(metadb.NewTest(t))
+	id := []byte("01234567")
+
+	mainTree, err := sdb.BeginTx()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, mainTree.Add(id, make([]byte, 32*2)), qt.IsNil)
+	multiA, err := mainTree.SubTree(multiACfg.WithKey(id))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, multiA.Add([]byte("key0"), []byte("value0")), qt.IsNil)
+	multiB, err := mainTree.SubTree(multiBCfg.WithKey(id))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, multiB.Add([]byte("key1"), []byte("value1")), qt.IsNil)
+	qt.Assert(t, mainTree.Commit(1), qt.IsNil)
+	hash, err := sdb.Hash()
+	qt.Assert(t, err, qt.IsNil)
+
+	// Prune multiA one node per commit, keeping its root in the parent leaf
+	version := uint32(2)
+	for done := false; !done; version++ {
+		mainTree, err = sdb.BeginTx()
+		qt.Assert(t, err, qt.IsNil)
+		done, err = mainTree.PruneSubTree(1, multiACfg.WithKey(id))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, mainTree.Commit(version), qt.IsNil)
+	}
+	qt.Assert(t, version > 3, qt.IsTrue)
+	prunedHash, err := sdb.Hash()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, prunedHash, qt.DeepEquals, hash)
+
+	mainTreeView, err := sdb.TreeView(nil)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = mainTreeView.SubTree(multiACfg.WithKey(id))
+	qt.Assert(t, err, qt.IsNotNil)
+	multiBView, err := mainTreeView.SubTree(multiBCfg.WithKey(id))
+	qt.Assert(t, err, qt.IsNil)
+	v1, err := multiBView.Get([]byte("key1"))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, v1, qt.DeepEquals, []byte("value1"))
+}
+
 func TestNoState(t *testing.T) {
 	sdb := NewStateDB(metadb.NewTest(t))
 
@@ -533,3 +575,68 @@ func TestBigUpdateDiscard(t *testing.T) {
 	qt.Assert(t, singleRoot, qt.DeepEquals, emptyHash)
 	mainTree.Discard()
 }
+
+func TestSavepoint(t *testing.T) {
+	// update applies the changes of the savepoint to the tree
+	update := func(tx *TreeTx) {
+		qt.Assert(t, tx.Set(singleCfg.Key(), emptyHash), qt.IsNil)
+		single, err := tx.SubTree(singleCfg)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, single.Set([]byte("key0"), []byte("value1")), qt.IsNil)
+		id := []byte("01234567")
+		qt.Assert(t, tx.Add(id, make([]byte, 32*2)), qt.IsNil)
+		multiA, err := tx.SubTree(multiACfg.WithKey(id))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, multiA.Add([]byte("key2"), []byte("value2")), qt.IsNil)
+		qt.Assert(t, multiA.NoState().Set([]byte("key3"), []byte("value3")), qt.IsNil)
+	}
+	// newTx returns a tx with the changes made before the savepoint
+	newTx := func() (*StateDB, *TreeTx) {
+		sdb := NewStateDB(metadb.NewTest(t))
+		tx, err := sdb.BeginTx()
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, tx.Add(singleCfg.Key(), emptyHash), qt.IsNil)
+		single, err := tx.SubTree(singleCfg)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, single.Add([]byte("key0"), []byte("value0")), qt.IsNil)
+		return sdb, tx
+	}
+	hash := func(sdb *StateDB, tx *TreeTx) []byte {
+		qt.Assert(t, tx.Commit(1), qt.IsNil)
+		root, err := sdb.Hash()
+		qt.Assert(t, err, qt.IsNil)
+		return root
+	}
+
+	sdb, tx := newTx()
+	rootBefore := hash(sdb, tx)
+	sdb, tx = newTx()
+	update(tx)
+	rootAfter := hash(sdb, tx)
+	qt.Assert(t, rootAfter, qt.Not(qt.DeepEquals), rootBefore)
+
+	// the rolled back changes are not committed
+	sdb, tx = newTx()
+	qt.Assert(t, tx.Savepoint(), qt.IsNil)
+	qt.Assert(t, tx.Savepoint(), qt.Equals, ErrSavepointActive)
+	update(tx)
+	qt.Assert(t, tx.RollbackSavepoint(), qt.IsNil)
+	qt.Assert(t, tx.RollbackSavepoint(), qt.Equals, ErrNoSavepoint)
+	_, err := tx.DeepGet([]byte("key2"), multiACfg.WithKey([]byte("01234567")))
+	qt.Assert(t, err, qt.IsNotNil)
+	qt.Assert(t, hash(sdb, tx), qt.DeepEquals, rootBefore)
+
+	// the released changes are committed
+	sdb, tx = newTx()
+	qt.Assert(t, tx.Savepoint(), qt.IsNil)
+	update(tx)
+	qt.Assert(t, tx.ReleaseSavepoint(), qt.IsNil)
+	qt.Assert(t, hash(sdb, tx), qt.DeepEquals, rootAfter)
+	view, err := sdb.TreeView(nil)
+	qt.Assert(t, err, qt.IsNil)
+	multiA, err := view.SubTree(multiACfg.WithKey([]byte("01234567")))
+	qt.Assert(t, err, qt.IsNil)
+	value, err := multiA.NoState().Get([]byte("key3"))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, value, qt.DeepEquals, []byte("value3"))
+}
@@ -2,6 +2,7 @@ package statedb
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"path"
 	"sync"
@@ -98,14 +99,55 @@ func (u *TreeUpdate) Dump(w io.Writer) error {
 	return u.tree.DumpWriter(w)
 }
 
-// Import writes the content exported with Dump.
-// TODO: use io.Reader once implemented in Arbo.
+// Import writes the content exported with Dump, replacing all the existing
+// leafs of the tree.
 func (u *TreeUpdate) Import(r io.Reader) error {
-	var buf bytes.Buffer
-	if _, err := buf.ReadFrom(r); err != nil {
+	u.dirtyTree = true
+	if err := u.tree.SetRoot(u.tree.tx, make([]byte, u.cfg.hashFunc.Len())); err != nil {
+		return err
+	}
+	return u.tree.ImportDumpReaderWithTx(u.tree.tx, r)
+}
+
+// ImportSubTree writes the content exported with Dump into the subTree
+// described by cfg, replacing all its existing leafs.  Unlike SubTree, the
+// root found in the parent leaf doesn't need to exist in the database yet: the
+// subTree is built from the imported leafs and its resulting root must match
+// the one found in the parent leaf.  This allows rebuilding a StateDB from a
+// dump, parent trees first.
+func (u *TreeUpdate) ImportSubTree(cfg TreeConfig, r io.Reader) error {
+	parentLeaf, err := u.tree.Get(u.tree.tx, cfg.parentLeafKey)
+	if err != nil {
+		return err
+	}
+	root, err := cfg.parentLeafGetRoot(parentLeaf)
+	if err != nil {
+		return err
+	}
+	tx := subWriteTx(u.tx, path.Join(subKeySubTree, cfg.prefix))
+	txTree := subWriteTx(tx, subKeyTree)
+	tree, err := tree.New(txTree,
+		tree.Options{DB: nil, MaxLevels: cfg.maxLevels, HashFunc: cfg.hashFunc})
+	if err != nil {
+		return err
+	}
+	if err := tree.SetRoot(txTree, make([]byte, cfg.hashFunc.Len())); err != nil {
 		return err
 	}
-	return u.tree.ImportDump(buf.Bytes())
+	if err := tree.ImportDumpReaderWithTx(txTree, r); err != nil {
+		return err
+	}
+	importedRoot, err := tree.Root(txTree)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(root, importedRoot) {
+		return fmt.Errorf("imported subTree root %x does not match the parent leaf root %x",
+			importedRoot, root)
+	}
+	// drop any previously opened instance, since its tree is now outdated
+	u.openSubs.Delete(cfg.prefix)
+	return nil
 }
 
 // NoState returns a key-value database associated with this tree that doesn't
@@ -222,8 +264,13 @@ func (u *TreeUpdate) DeepSet(key, value []byte, cfgs ...TreeConfig) error {
 // concurent use.
 type TreeTx struct {
 	sdb *StateDB
+	// sp is the root transaction, used to create savepoints.
+	sp *savepointTx
 	// TreeUpdate contains the mainTree opened for updates.
 	TreeUpdate
+	// discardOnly is set when the mainTree was opened at a past version,
+	// so the TreeTx can't be committed.
+	discardOnly bool
 }
 
 // update is a helper struct used to collect subTree updates that need to
@@ -315,6 +362,9 @@ func propagateRoot(treeUpdate *TreeUpdate) ([]byte, error) {
 // version numbers, but overwritting an existing version can be useful in some
 // cases (for example, overwritting version 0 to setup a genesis state).
 func (t *TreeTx) Commit(version uint32) error {
+	if t.discardOnly {
+		return ErrDiscardOnly
+	}
 	root, err := propagateRoot(&t.TreeUpdate)
 	if err != nil {
 		return err
@@ -337,6 +387,57 @@ func (t *TreeTx) Commit(version uint32) error {
 	return t.tx.Commit()
 }
 
+// PruneSubTree deletes from the database the nodes of all the versions of the
+// nested subTree described by the list of tree configurations, as in
+// DeepSubTree.  The parent leaf holding the subTree root is kept, so the
+// StateDB.Hash doesn't change, but the pruned subTree can't be opened anymore.
+// Only the committed nodes are deleted, so the subTree must not be updated in
+// the same TreeTx.  At most maxNodes nodes are deleted, so a large subTree is
+// pruned in bounded chunks: it returns whether all of them were deleted, and
+// otherwise PruneSubTree must be called again after the TreeTx is committed.
+func (t *TreeTx) PruneSubTree(maxNodes int, cfgs ...TreeConfig) (bool, error) {
+	if len(cfgs) == 0 {
+		return false, fmt.Errorf("cannot prune the mainTree")
+	}
+	if maxNodes <= 0 {
+		return false, fmt.Errorf("invalid maximum number of nodes %d", maxNodes)
+	}
+	prefix := []byte{}
+	parent := &t.TreeUpdate
+	for i, cfg := range cfgs {
+		prefix = append(prefix, path.Join(subKeySubTree, cfg.prefix)+"/"...)
+		if parent == nil {
+			continue
+		}
+		// drop the opened instance, since its nodes are deleted
+		if i == len(cfgs)-1 {
+			parent.openSubs.Delete(cfg.prefix)
+		} else if sub, ok := parent.openSubs.Load(cfg.prefix); ok {
+			parent = sub.(*TreeUpdate)
+		} else {
+			parent = nil
+		}
+	}
+	keys := [][]byte{}
+	done := true
+	if err := t.sdb.db.Iterate(prefix, func(key, _ []byte) bool {
+		if len(keys) == maxNodes {
+			done = false
+			return false
+		}
+		keys = append(keys, append(append([]byte{}, prefix...), key...))
+		return true
+	}); err != nil {
+		return false, err
+	}
+	for _, key := range keys {
+		if err := t.tx.Delete(key); err != nil {
+			return false, err
+		}
+	}
+	return done, nil
+}
+
 // Discard all the changes that have been made from the TreeTx.  After calling
 // Discard, the TreeTx shouldn't no longer be used.
 func (t *TreeTx) Discard() {
@@ -1,15 +1,25 @@
 package test
 
 import (
+	"bufio"
+	"context"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"math/big"
+	"net/http"
+	"strings"
 	"testing"
 	"time"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/google/uuid"
+	"github.com/tendermint/tendermint/crypto/tmhash"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+	tmtypes "github.com/tendermint/tendermint/types"
+	"github.com/tendermint/tendermint/version"
 	"go.vocdoni.io/dvote/api"
+	"go.vocdoni.io/dvote/apiclient"
 	"go.vocdoni.io/dvote/crypto/ethereum"
 	"go.vocdoni.io/dvote/data"
 	"go.vocdoni.io/dvote/test/testcommon"
@@ -17,6 +27,7 @@ import (
 	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/dvote/util"
 	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/state"
 	"go.vocdoni.io/proto/build/go/models"
 	"google.golang.org/protobuf/proto"
 )
@@ -169,6 +180,47 @@ func TestAPIcensusAndVote(t *testing.T) {
 	_, code = c.Request("GET", nil, "votes", "verify", election.ElectionID.String(), v.VoteID.String())
 	qt.Assert(t, code, qt.Equals, 200)
 
+	// Get the merkle proof of the vote and verify it up to the state root
+	resp, code = c.Request("GET", nil, "votes", "verify", election.ElectionID.String(), v.VoteID.String(), "proof")
+	qt.Assert(t, code, qt.Equals, 200)
+	proof := &api.VoteProof{}
+	qt.Assert(t, json.Unmarshal(resp, proof), qt.IsNil)
+	qt.Assert(t, proof.BlockHeight, qt.Equals, proof.Height+1)
+	stateRoot, err := server.VochainAPP.State.Store.VersionRoot(proof.Height)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, []byte(proof.Root), qt.DeepEquals, stateRoot)
+	qt.Assert(t, (&state.VoteProof{
+		Height:            proof.Height,
+		Root:              proof.Root,
+		Vote:              proof.Vote,
+		VoteSiblings:      proof.VoteSiblings,
+		Process:           proof.Process,
+		ProcessSiblings:   proof.ProcessSiblings,
+		Processes:         proof.Processes,
+		ProcessesSiblings: proof.ProcessesSiblings,
+	}).Verify(election.ElectionID, v.VoteID), qt.IsNil)
+	_, code = c.Request("GET", nil, "votes", "verify", election.ElectionID.String(), util.RandomHex(32), "proof")
+	qt.Assert(t, code, qt.Equals, 404)
+
+	// The client verifies the proof against the AppHash of the block header
+	server.VochainAPP.SetFnGetBlockByHeight(func(height int64) *tmtypes.Block {
+		root, err := server.VochainAPP.State.Store.VersionRoot(uint32(height - 1))
+		if err != nil {
+			return nil
+		}
+		return &tmtypes.Block{Header: tmtypes.Header{Height: height, AppHash: root}}
+	})
+	client, err := apiclient.NewHTTPclient(server.ListenAddr, &token1)
+	qt.Assert(t, err, qt.IsNil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server.VochainAPP.AdvanceTestBlock()
+	clientProof, err := client.VoteProof(ctx, election.ElectionID, v.VoteID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, clientProof.Root, qt.DeepEquals, proof.Root)
+	_, err = client.VoteProof(ctx, election.ElectionID, util.RandomBytes(32))
+	qt.Assert(t, err, qt.Equals, apiclient.ErrVoteNotFound)
+
 	// Get the vote and check the data
 	resp, code = c.Request("GET", nil, "votes", v.VoteID.String())
 	qt.Assert(t, code, qt.Equals, 200)
@@ -179,6 +231,27 @@ func TestAPIcensusAndVote(t *testing.T) {
 	qt.Assert(t, v2.BlockHeight, qt.Equals, uint32(2))
 	qt.Assert(t, *v2.TransactionIndex, qt.Equals, int32(0))
 
+	// The transactions of the election are listed for the election, the
+	// organization and the voter
+	server.Indexer.WaitIdle()
+	txs, err := client.ElectionTransactions(election.ElectionID, 0)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, txs, qt.HasLen, 2)
+	qt.Assert(t, txs[0].TxType, qt.Equals, "vote")
+	qt.Assert(t, txs[0].Signer, qt.DeepEquals, types.HexBytes(voterKey.Address().Bytes()))
+	qt.Assert(t, txs[1].TxType, qt.Equals, "newProcess")
+	qt.Assert(t, txs[1].EntityID, qt.DeepEquals, types.HexBytes(server.Account.Address().Bytes()))
+	txs, err = client.ElectionTransactions(election.ElectionID, 1)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, txs, qt.HasLen, 0)
+	txs, err = client.AccountTransactions(server.Account.Address(), 0)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, txs, qt.HasLen, 2)
+	txs, err = client.AccountTransactions(voterKey.Address(), 0)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, txs, qt.HasLen, 1)
+	qt.Assert(t, txs[0].ProcessID, qt.DeepEquals, election.ElectionID)
+
 	// TODO (painan): check why the voterID is not present on the reply
 	//qt.Assert(t, v2.VoterID.String(), qt.Equals, voterKey.AddressString())
 }
@@ -246,6 +319,93 @@ func TestAPIaccount(t *testing.T) {
 	// check the account exist
 	resp, code = c.Request("GET", nil, "accounts", signer.Address().String())
 	qt.Assert(t, code, qt.Equals, 200, qt.Commentf("response: %s", resp))
+
+	// check the account creation is listed on its transactions
+	server.Indexer.WaitIdle()
+	client, err := apiclient.NewHTTPclient(server.ListenAddr, &token1)
+	qt.Assert(t, err, qt.IsNil)
+	txs, err := client.AccountTransactions(signer.Address(), 0)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, txs, qt.HasLen, 1)
+	qt.Assert(t, txs[0].TxType, qt.Equals, "setAccount")
+	qt.Assert(t, txs[0].Signer, qt.DeepEquals, types.HexBytes(signer.Address().Bytes()))
+	txs, err = client.AccountTransactions(signer.Address(), 1)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, txs, qt.HasLen, 0)
+}
+
+func TestAPIevents(t *testing.T) {
+	server := testcommon.APIserver{}
+	server.Start(t, api.EventsHandler)
+
+	// add two elections from different organizations
+	pid1, pid2 := util.RandomBytes(32), util.RandomBytes(32)
+	org1, org2 := util.RandomBytes(20), util.RandomBytes(20)
+	for i, pid := range [][]byte{pid1, pid2} {
+		err := server.VochainAPP.State.AddProcess(&models.Process{
+			ProcessId:    pid,
+			EntityId:     [][]byte{org1, org2}[i],
+			EnvelopeType: &models.EnvelopeType{},
+			Status:       models.ProcessStatus_READY,
+			Mode:         &models.ProcessMode{AutoStart: true},
+			BlockCount:   10,
+			VoteOptions:  &models.ProcessVoteOptions{MaxCount: 1, MaxValue: 1},
+		})
+		qt.Assert(t, err, qt.IsNil)
+	}
+	height := server.VochainAPP.Height()
+	server.VochainAPP.AdvanceTestBlock()
+
+	// resume the organization events from a past height
+	events := readEvents(t, server.ListenAddr.String()+"accounts/"+hex.EncodeToString(org1)+"/events/height/0", 2)
+	qt.Assert(t, events[0].Type, qt.Equals, api.EventTypeElection)
+	qt.Assert(t, events[0].Height, qt.Equals, height)
+	qt.Assert(t, events[0].ElectionID, qt.DeepEquals, types.HexBytes(pid1))
+	qt.Assert(t, events[1].Type, qt.Equals, api.EventTypeBlock)
+	qt.Assert(t, events[1].Height, qt.Equals, height)
+
+	// the votes of the next block are streamed once committed
+	go func() {
+		time.Sleep(time.Second)
+		for _, pid := range [][]byte{pid2, pid1} {
+			err := server.VochainAPP.State.AddVote(&state.Vote{
+				ProcessID:   pid,
+				Nullifier:   util.RandomBytes(32),
+				VotePackage: []byte("{}"),
+			})
+			qt.Check(t, err, qt.IsNil)
+		}
+		server.VochainAPP.AdvanceTestBlock()
+	}()
+	events = readEvents(t, server.ListenAddr.String()+"elections/"+hex.EncodeToString(pid1)+"/events", 2)
+	qt.Assert(t, events[0].Type, qt.Equals, api.EventTypeVote)
+	qt.Assert(t, events[0].Height, qt.Equals, height+1)
+	qt.Assert(t, events[0].ElectionID, qt.DeepEquals, types.HexBytes(pid1))
+	qt.Assert(t, events[0].OrganizationID, qt.DeepEquals, types.HexBytes(org1))
+	qt.Assert(t, events[1].Type, qt.Equals, api.EventTypeBlock)
+}
+
+// readEvents opens a Server-Sent Events stream and reads n events from it.
+func readEvents(t testing.TB, url string, n int) []*api.Event {
+	resp, err := http.Get(url)
+	qt.Assert(t, err, qt.IsNil)
+	defer resp.Body.Close()
+	qt.Assert(t, resp.StatusCode, qt.Equals, 200)
+	qt.Assert(t, resp.Header.Get("Content-Type"), qt.Equals, "text/event-stream")
+
+	var events []*api.Event
+	scanner := bufio.NewScanner(resp.Body)
+	for len(events) < n && scanner.Scan() {
+		if !strings.HasPrefix(scanner.Text(), "data: ") {
+			continue
+		}
+		event := &api.Event{}
+		data := strings.TrimPrefix(scanner.Text(), "data: ")
+		qt.Assert(t, json.Unmarshal([]byte(data), event), qt.IsNil)
+		events = append(events, event)
+	}
+	qt.Assert(t, events, qt.HasLen, n)
+	return events
 }
 
 func waitUntilHeight(t testing.TB, c *testutil.TestHTTPclient, h uint32) {
@@ -261,3 +421,145 @@ func waitUntilHeight(t testing.TB, c *testutil.TestHTTPclient, h uint32) {
 		time.Sleep(time.Second * 1)
 	}
 }
+
+func TestAPIverifiedQueries(t *testing.T) {
+	server := testcommon.APIserver{}
+	server.Start(t,
+		api.ChainHandler,
+		api.AccountHandler,
+		api.ElectionHandler,
+	)
+	// the light blocks are signed by a single validator, over the state root
+	// of the previous height
+	pv := tmtypes.NewMockPV()
+	valSet := testValidatorSet(t, pv)
+	server.VochainAPP.SetFnGetLightBlock(func(height int64) (*tmtypes.LightBlock, error) {
+		root, err := server.VochainAPP.State.Store.VersionRoot(uint32(height - 1))
+		if err != nil {
+			return nil, err
+		}
+		return testLightBlock(t, pv, valSet, server.VochainAPP.ChainID(), height, root), nil
+	})
+
+	pid := util.RandomBytes(32)
+	censusURI := "ipfs://foobar"
+	qt.Assert(t, server.VochainAPP.State.AddProcess(&models.Process{
+		ProcessId:    pid,
+		EntityId:     server.Account.Address().Bytes(),
+		EnvelopeType: &models.EnvelopeType{},
+		Status:       models.ProcessStatus_READY,
+		Mode:         &models.ProcessMode{AutoStart: true},
+		BlockCount:   10,
+		CensusRoot:   util.RandomBytes(32),
+		CensusURI:    &censusURI,
+		CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 1, MaxValue: 1},
+	}), qt.IsNil)
+	server.VochainAPP.AdvanceTestBlock()
+	server.VochainAPP.AdvanceTestBlock()
+
+	token := uuid.New()
+	c, err := apiclient.NewHTTPclient(server.ListenAddr, &token)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, c.SetTrustedValidators(valSet), qt.IsNil)
+
+	acc, err := c.Account(server.Account.Address().Hex())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, acc.Balance, qt.Equals, uint64(100000))
+	election, err := c.Election(pid)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, election.Census.CensusURL, qt.Equals, censusURI)
+	qt.Assert(t, election.Status, qt.Equals, models.ProcessStatus_READY.String())
+	qt.Assert(t, election.EndBlock, qt.Equals, election.StartBlock+10)
+
+	// the results are verified against the last results set by an oracle
+	oracle := ethereum.NewSignKeys()
+	qt.Assert(t, oracle.Generate(), qt.IsNil)
+	qt.Assert(t, server.VochainAPP.State.AddOracle(oracle.Address()), qt.IsNil)
+	for i := 0; i < 12; i++ {
+		server.VochainAPP.AdvanceTestBlock()
+	}
+	qt.Assert(t, server.VochainAPP.State.SetProcessResults(pid, &models.ProcessResult{
+		ProcessId:     pid,
+		EntityId:      server.Account.Address().Bytes(),
+		OracleAddress: oracle.Address().Bytes(),
+		Votes: []*models.QuestionResult{{
+			Question: [][]byte{big.NewInt(3).Bytes(), big.NewInt(1).Bytes()},
+		}},
+	}, true), qt.IsNil)
+	server.VochainAPP.AdvanceTestBlock()
+	server.VochainAPP.AdvanceTestBlock()
+	results, err := c.ElectionResults(pid)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, fmt.Sprint(results.Results), qt.Equals, "[[3 1]]")
+	election, err = c.Election(pid)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, election.Status, qt.Equals, models.ProcessStatus_RESULTS.String())
+	qt.Assert(t, election.FinalResults, qt.IsTrue)
+	qt.Assert(t, fmt.Sprint(election.Results), qt.Equals, "[[3 1]]")
+
+	// the trusted validators expire after the trusting period
+	qt.Assert(t, c.SetTrustingPeriod(time.Nanosecond), qt.IsNil)
+	server.VochainAPP.AdvanceTestBlock()
+	_, err = c.Account(server.Account.Address().Hex())
+	qt.Assert(t, err, qt.ErrorMatches, "cannot verify block .*: trusted validators expired .*")
+
+	// the headers signed by an untrusted validator are rejected
+	untrusted, err := apiclient.NewHTTPclient(server.ListenAddr, &token)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, untrusted.SetTrustedValidators(testValidatorSet(t, tmtypes.NewMockPV())), qt.IsNil)
+	_, err = untrusted.Account(server.Account.Address().Hex())
+	qt.Assert(t, err, qt.ErrorMatches, "cannot verify block .*")
+}
+
+// testValidatorSet returns a validator set with the single validator pv.
+func testValidatorSet(t *testing.T, pv tmtypes.PrivValidator) *tmtypes.ValidatorSet {
+	pubKey, err := pv.GetPubKey(context.Background())
+	qt.Assert(t, err, qt.IsNil)
+	return tmtypes.NewValidatorSet([]*tmtypes.Validator{tmtypes.NewValidator(pubKey, 10)})
+}
+
+// testLightBlock returns a light block with the given AppHash, with a commit
+// signed by pv, the single validator of valSet.
+func testLightBlock(t *testing.T, pv tmtypes.PrivValidator, valSet *tmtypes.ValidatorSet,
+	chainID string, height int64, appHash []byte) *tmtypes.LightBlock {
+	val := valSet.Validators[0]
+	header := &tmtypes.Header{
+		Version:            version.Consensus{Block: version.BlockProtocol},
+		ChainID:            chainID,
+		Height:             height,
+		Time:               time.Now(),
+		AppHash:            appHash,
+		ValidatorsHash:     valSet.Hash(),
+		NextValidatorsHash: valSet.Hash(),
+		ProposerAddress:    val.Address,
+	}
+	blockID := tmtypes.BlockID{
+		Hash:          header.Hash(),
+		PartSetHeader: tmtypes.PartSetHeader{Total: 1, Hash: tmhash.Sum(nil)},
+	}
+	vote := (&tmtypes.Vote{
+		Type:             tmproto.PrecommitType,
+		Height:           height,
+		BlockID:          blockID,
+		Timestamp:        header.Time,
+		ValidatorAddress: val.Address,
+	}).ToProto()
+	qt.Assert(t, pv.SignVote(context.Background(), chainID, vote), qt.IsNil)
+	return &tmtypes.LightBlock{
+		SignedHeader: &tmtypes.SignedHeader{
+			Header: header,
+			Commit: &tmtypes.Commit{
+				Height:  height,
+				BlockID: blockID,
+				Signatures: []tmtypes.CommitSig{{
+					BlockIDFlag:      tmtypes.BlockIDFlagCommit,
+					ValidatorAddress: val.Address,
+					Timestamp:        header.Time,
+					Signature:        vote.Signature,
+				}},
+			},
+		},
+		ValidatorSet: valSet,
+	}
+}
@@ -1,6 +1,8 @@
 package tree
 
 import (
+	"bufio"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -10,6 +12,12 @@ import (
 	"go.vocdoni.io/dvote/db"
 )
 
+// dbKeyNLeafsOffset is the database key where the number of leafs counted by
+// arbo which are no longer in the tree is stored.  arbo can only add leafs, so
+// Del rebuilds the tree from the remaining ones and arbo keeps counting the
+// leafs it held before.
+var dbKeyNLeafsOffset = []byte("treeNLeafsOffset")
+
 // Tree defines the struct that implements the MerkleTree functionalities
 type Tree struct {
 	tree *arbo.Tree
@@ -150,6 +158,68 @@ func (t *Tree) AddBatch(wTx db.WriteTx, keys, values [][]byte) ([]int, error) {
 	return invalidIndexes, nil
 }
 
+// Del removes a leaf from the tree.  If the key does not exist, it returns
+// arbo.ErrKeyNotFound.  See DelBatch.
+func (t *Tree) Del(wTx db.WriteTx, key []byte) error {
+	return t.DelBatch(wTx, [][]byte{key})
+}
+
+// DelBatch removes a batch of leafs from the tree.  If any of the keys does not
+// exist, it returns arbo.ErrKeyNotFound.  arbo does not support deleting leafs,
+// so the tree is rebuilt by adding all the other leafs to an empty root, which
+// gives the same root as if the removed leafs had never been added.  The nodes
+// of the previous roots are kept, so snapshots from them are still valid.
+func (t *Tree) DelBatch(wTx db.WriteTx, keys [][]byte) error {
+	givenTx := wTx != nil
+	if !givenTx {
+		wTx = t.DB().WriteTx()
+		defer wTx.Discard()
+	}
+	removed := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if _, _, err := t.tree.GetWithTx(wTx, key); err != nil {
+			return err
+		}
+		removed[string(key)] = true
+	}
+	var leafKeys, leafValues [][]byte
+	if err := t.IterateLeaves(wTx, func(key, value []byte) bool {
+		if !removed[string(key)] {
+			leafKeys = append(leafKeys, append([]byte{}, key...))
+			leafValues = append(leafValues, append([]byte{}, value...))
+		}
+		return false
+	}); err != nil {
+		return err
+	}
+	// arbo counts the leafs added since the tree was created, including the
+	// ones added again below
+	nLeafs, err := t.tree.GetNLeafsWithTx(wTx)
+	if err != nil {
+		return err
+	}
+	if err := t.tree.SetRootWithTx(wTx, make([]byte, t.tree.HashFunction().Len())); err != nil {
+		return err
+	}
+	invalid, err := t.tree.AddBatchWithTx(wTx, leafKeys, leafValues)
+	if err != nil {
+		return err
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("cannot add back key %x: %w",
+			leafKeys[invalid[0].Index], invalid[0].Error)
+	}
+	offset := make([]byte, 8)
+	binary.LittleEndian.PutUint64(offset, uint64(nLeafs))
+	if err := wTx.Set(dbKeyNLeafsOffset, offset); err != nil {
+		return err
+	}
+	if !givenTx {
+		return wTx.Commit()
+	}
+	return nil
+}
+
 // Iterate over all the database-encoded nodes of the tree.  When callback
 // returns true, the iteration is stopped and this function returns.
 func (t *Tree) Iterate(rTx db.ReadTx, callback func(key, value []byte) bool) error {
@@ -190,8 +260,21 @@ func (t *Tree) Root(rTx db.ReadTx) ([]byte, error) {
 
 // Size returns the number of leafs under the current root
 func (t *Tree) Size(rTx db.ReadTx) (uint64, error) {
-	n, err := t.tree.GetNLeafs()
-	return uint64(n), err
+	if rTx == nil {
+		rTx = t.DB().ReadTx()
+		defer rTx.Discard()
+	}
+	n, err := t.tree.GetNLeafsWithTx(rTx)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := rTx.Get(dbKeyNLeafsOffset)
+	if errors.Is(err, db.ErrKeyNotFound) {
+		return uint64(n), nil
+	} else if err != nil {
+		return 0, err
+	}
+	return uint64(n) - binary.LittleEndian.Uint64(offset), nil
 }
 
 // GenProof returns a byte array with the necessary data to verify that the
@@ -207,7 +290,7 @@ func (t *Tree) GenProof(rTx db.ReadTx, key []byte) ([]byte, []byte, error) {
 	}
 	if !existence {
 		// proof of non-existence currently not needed in vocdoni-node
-		return nil, nil, fmt.Errorf("key does not exist %s", hex.EncodeToString(key))
+		return nil, nil, fmt.Errorf("%w: %s", arbo.ErrKeyNotFound, hex.EncodeToString(key))
 	}
 	return leafV, s, nil
 }
@@ -270,6 +353,34 @@ func (t *Tree) ImportDump(b []byte) error {
 	return t.tree.ImportDump(b)
 }
 
+// ImportDumpReaderWithTx imports the leafs (that have been exported with the
+// Dump method) in the Tree, reading them from r and using the given
+// db.WriteTx.  Leafs whose key already exists in the Tree are updated with the
+// imported value.
+func (t *Tree) ImportDumpReaderWithTx(wTx db.WriteTx, r io.Reader) error {
+	br := bufio.NewReader(r)
+	for {
+		// each leaf is encoded as [len(k) 1 byte | len(v) 2 bytes | k | v]
+		l := make([]byte, 3)
+		if _, err := io.ReadFull(br, l); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		k := make([]byte, int(l[0]))
+		if _, err := io.ReadFull(br, k); err != nil {
+			return err
+		}
+		v := make([]byte, binary.LittleEndian.Uint16(l[1:3]))
+		if _, err := io.ReadFull(br, v); err != nil {
+			return err
+		}
+		if err := t.Set(wTx, k, v); err != nil {
+			return fmt.Errorf("cannot import key %x: %w", k, err)
+		}
+	}
+}
+
 func (t *Tree) PrintGraphviz() error {
 	return t.tree.PrintGraphviz(nil)
 }
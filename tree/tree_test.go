@@ -138,3 +138,72 @@ func TestFromRoot(t *testing.T) {
 	err = wTx.Commit()
 	qt.Assert(t, err, qt.IsNil)
 }
+
+func TestDel(t *testing.T) {
+	newTree := func() *Tree {
+		tree, err := New(nil, Options{DB: metadb.NewTest(t), MaxLevels: 100, HashFunc: arbo.HashFunctionBlake2b})
+		qt.Assert(t, err, qt.IsNil)
+		return tree
+	}
+	tree := newTree()
+	expected := newTree()
+
+	wTx := tree.DB().WriteTx()
+	defer wTx.Discard()
+	for i := 0; i < 50; i++ {
+		k := []byte("key" + strconv.Itoa(i))
+		qt.Assert(t, tree.Add(wTx, k, []byte("value"+strconv.Itoa(i))), qt.IsNil)
+	}
+	// remove every third leaf, the root must match the one of a tree built
+	// from the remaining leafs only
+	for i := 0; i < 50; i++ {
+		k := []byte("key" + strconv.Itoa(i))
+		if i%3 == 0 {
+			qt.Assert(t, tree.Del(wTx, k), qt.IsNil)
+			continue
+		}
+		qt.Assert(t, expected.Add(nil, k, []byte("value"+strconv.Itoa(i))), qt.IsNil)
+	}
+	root, err := tree.Root(wTx)
+	qt.Assert(t, err, qt.IsNil)
+	expectedRoot, err := expected.Root(nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, root, qt.DeepEquals, expectedRoot)
+	qt.Assert(t, wTx.Commit(), qt.IsNil)
+
+	size, err := tree.Size(nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, size, qt.Equals, uint64(33))
+
+	_, err = tree.Get(nil, []byte("key3"))
+	qt.Assert(t, err, qt.Equals, arbo.ErrKeyNotFound)
+	qt.Assert(t, tree.Del(nil, []byte("key3")), qt.Equals, arbo.ErrKeyNotFound)
+
+	// the remaining leafs can still be proven
+	v, proof, err := tree.GenProof(nil, []byte("key4"))
+	qt.Assert(t, err, qt.IsNil)
+	verif, err := tree.VerifyProof([]byte("key4"), v, proof, root)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, verif, qt.IsTrue)
+
+	// removing all the leafs leaves an empty tree
+	for i := 0; i < 50; i++ {
+		if i%3 != 0 {
+			qt.Assert(t, tree.Del(nil, []byte("key"+strconv.Itoa(i))), qt.IsNil)
+		}
+	}
+	root, err = tree.Root(nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, root, qt.DeepEquals, make([]byte, arbo.HashFunctionBlake2b.Len()))
+
+	// the leafs can be added again, and removed in a batch
+	for i := 0; i < 10; i++ {
+		qt.Assert(t, tree.Add(nil, []byte("key"+strconv.Itoa(i)), []byte("value")), qt.IsNil)
+	}
+	qt.Assert(t, tree.DelBatch(nil, [][]byte{[]byte("key1"), []byte("key50")}),
+		qt.Equals, arbo.ErrKeyNotFound)
+	qt.Assert(t, tree.DelBatch(nil, [][]byte{[]byte("key1"), []byte("key2")}), qt.IsNil)
+	size, err = tree.Size(nil)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, size, qt.Equals, uint64(8))
+}
@@ -10,6 +10,7 @@ import (
 	abcitypes "github.com/tendermint/tendermint/abci/types"
 
 	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/dvote/util"
 	"go.vocdoni.io/dvote/vochain/state"
 	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
@@ -853,6 +854,227 @@ func testSetAccountDelegateTx(t *testing.T,
 	return nil
 }
 
+func TestSetAccountMultisigTx(t *testing.T) {
+	// keys: [oracle, entity, delegate, treasurer, random]
+	app, keys := createTestBaseApplicationAndAccounts(t, 10)
+	entity, delegate, random := keys[1], keys[2], keys[4]
+	signers := []common.Address{entity.Address(), delegate.Address(), random.Address()}
+
+	// an invalid policy is rejected
+	qt.Assert(t, testSetAccountMultisigTx(t, app, entity, nil, 4, signers),
+		qt.ErrorMatches, ".*threshold must be between 1 and 3.*")
+
+	// set a 2 of 3 policy, which is charged with its own cost
+	qt.Assert(t, app.State.SetTxCost(models.TxType_SET_ACCOUNT_MULTISIG, 25), qt.IsNil)
+	acc, err := app.State.GetAccount(entity.Address(), false)
+	qt.Assert(t, err, qt.IsNil)
+	balance := acc.Balance
+	qt.Assert(t, testSetAccountMultisigTx(t, app, entity, nil, 2, signers), qt.IsNil)
+	policy, err := app.State.MultisigPolicy(entity.Address(), true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, policy, qt.DeepEquals, &state.MultisigPolicy{Threshold: 2, Signers: signers})
+	acc, err = app.State.GetAccount(entity.Address(), false)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, acc.Balance, qt.Equals, balance-25)
+
+	censusURI := ipfsUrl
+	process := &models.Process{
+		StartBlock:   0,
+		EnvelopeType: &models.EnvelopeType{},
+		Mode:         &models.ProcessMode{Interruptible: true},
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 16, MaxValue: 16},
+		Status:       models.ProcessStatus_READY,
+		EntityId:     entity.Address().Bytes(),
+		CensusRoot:   util.RandomBytes(32),
+		CensusURI:    &censusURI,
+		CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+		BlockCount:   1024,
+	}
+
+	// a single signature is not enough to create an election, neither for
+	// the organization nor for its delegate
+	qt.Assert(t, testNewProcess(t, nil, entity, app, process),
+		qt.ErrorMatches, ".*requires 2 multisig approvals, got 1.*")
+	qt.Assert(t, testNewProcess(t, nil, delegate, app, process), qt.IsNotNil)
+	// a co-signer out of the policy does not count
+	qt.Assert(t, testNewProcessMultisig(t, app, delegate, []*ethereum.SignKeys{keys[3]}, process),
+		qt.ErrorMatches, ".*requires 2 multisig approvals, got 1.*")
+	// the same signer does not count twice
+	qt.Assert(t, testNewProcessMultisig(t, app, entity, []*ethereum.SignKeys{entity}, process),
+		qt.ErrorMatches, ".*requires 2 multisig approvals, got 1.*")
+	qt.Assert(t, testNewProcessMultisig(t, app, delegate, []*ethereum.SignKeys{random}, process),
+		qt.IsNil)
+	// the oracle is not subject to the policy
+	qt.Assert(t, testNewProcess(t, nil, keys[0], app, process), qt.IsNil)
+
+	// changing the policy requires its approval
+	qt.Assert(t, testSetAccountMultisigTx(t, app, entity, nil, 1, signers[:1]),
+		qt.ErrorMatches, ".*requires 2 multisig approvals, got 1.*")
+	qt.Assert(t, testSetAccountMultisigTx(t, app, entity,
+		[]*ethereum.SignKeys{random}, 0, nil), qt.IsNil)
+	policy, err = app.State.MultisigPolicy(entity.Address(), true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, policy, qt.IsNil)
+
+	// once removed, a single signature is enough again
+	qt.Assert(t, testNewProcess(t, nil, entity, app, process), qt.IsNil)
+}
+
+func testSetAccountMultisigTx(t *testing.T,
+	app *BaseApplication,
+	signer *ethereum.SignKeys,
+	cosigners []*ethereum.SignKeys,
+	threshold uint32,
+	signers []common.Address) error {
+	acc, err := app.State.GetAccount(signer.Address(), false)
+	qt.Assert(t, err, qt.IsNil)
+	tx := &models.SetAccountTx{
+		Txtype:    models.TxType_SET_ACCOUNT_MULTISIG,
+		Nonce:     &acc.Nonce,
+		Threshold: &threshold,
+	}
+	for _, s := range signers {
+		tx.Delegates = append(tx.Delegates, s.Bytes())
+	}
+	stx := &models.SignedTx{}
+	if stx.Tx, err = proto.Marshal(&models.Tx{Payload: &models.Tx_SetAccount{SetAccount: tx}}); err != nil {
+		t.Fatal(err)
+	}
+	return testMultisigTx(t, app, signer, cosigners, stx)
+}
+
+func testNewProcessMultisig(t *testing.T,
+	app *BaseApplication,
+	signer *ethereum.SignKeys,
+	cosigners []*ethereum.SignKeys,
+	process *models.Process) error {
+	acc, err := app.State.GetAccount(signer.Address(), false)
+	qt.Assert(t, err, qt.IsNil)
+	tx := &models.NewProcessTx{
+		Txtype:  models.TxType_NEW_PROCESS,
+		Nonce:   acc.Nonce,
+		Process: process,
+	}
+	stx := &models.SignedTx{}
+	if stx.Tx, err = proto.Marshal(&models.Tx{Payload: &models.Tx_NewProcess{NewProcess: tx}}); err != nil {
+		t.Fatal(err)
+	}
+	return testMultisigTx(t, app, signer, cosigners, stx)
+}
+
+// testMultisigTx signs the transaction with the signer and all the cosigners,
+// and delivers it.
+func testMultisigTx(t *testing.T,
+	app *BaseApplication,
+	signer *ethereum.SignKeys,
+	cosigners []*ethereum.SignKeys,
+	stx *models.SignedTx) error {
+	var err error
+	if stx.Signature, err = signer.SignVocdoniTx(stx.Tx, app.chainID); err != nil {
+		t.Fatal(err)
+	}
+	for _, cosigner := range cosigners {
+		signature, err := cosigner.SignVocdoniTx(stx.Tx, app.chainID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stx.Signatures = append(stx.Signatures, signature)
+	}
+	return testCheckTxDeliverTxCommit(t, app, stx)
+}
+
+func TestRotateAccountTx(t *testing.T) {
+	// keys: [oracle, entity, delegate, treasurer, random]
+	app, keys := createTestBaseApplicationAndAccounts(t, 10)
+	entity, delegate := keys[1], keys[2]
+	newKey := &ethereum.SignKeys{}
+	qt.Assert(t, newKey.Generate(), qt.IsNil)
+
+	censusURI := ipfsUrl
+	process := &models.Process{
+		StartBlock:   0,
+		EnvelopeType: &models.EnvelopeType{},
+		Mode:         &models.ProcessMode{Interruptible: true},
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 16, MaxValue: 16},
+		Status:       models.ProcessStatus_READY,
+		EntityId:     entity.Address().Bytes(),
+		CensusRoot:   util.RandomBytes(32),
+		CensusURI:    &censusURI,
+		CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+		BlockCount:   1024,
+	}
+	qt.Assert(t, testNewProcess(t, nil, entity, app, process), qt.IsNil)
+	pid := util.RandomBytes(types.ProcessIDsize)
+	election := proto.Clone(process).(*models.Process)
+	election.ProcessId = pid
+	qt.Assert(t, app.State.AddProcess(election), qt.IsNil)
+	app.Commit()
+
+	// the new key must co-sign the rotation, and cannot have an account
+	qt.Assert(t, testRotateAccountTx(t, app, entity, newKey.Address(), nil),
+		qt.ErrorMatches, ".*must co-sign the transaction.*")
+	qt.Assert(t, testRotateAccountTx(t, app, entity, keys[4].Address(),
+		[]*ethereum.SignKeys{keys[4]}), qt.ErrorMatches, ".*account already exists.*")
+
+	// the rotation is charged with its own cost
+	qt.Assert(t, app.State.SetTxCost(models.TxType_ROTATE_ACCOUNT, 25), qt.IsNil)
+	old, err := app.State.GetAccount(entity.Address(), true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, testRotateAccountTx(t, app, entity, newKey.Address(),
+		[]*ethereum.SignKeys{newKey}), qt.IsNil)
+
+	// the account is moved to the new address
+	acc, err := app.State.GetAccount(newKey.Address(), true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, acc.Balance, qt.Equals, old.Balance-25)
+	qt.Assert(t, acc.Nonce, qt.Equals, old.Nonce+1)
+	qt.Assert(t, acc.ProcessIndex, qt.Equals, old.ProcessIndex)
+	qt.Assert(t, acc.DelegateAddrs, qt.DeepEquals, old.DelegateAddrs)
+	to, err := app.State.AccountRotation(entity.Address(), true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, *to, qt.Equals, newKey.Address())
+	acc, err = app.State.GetAccount(entity.Address(), true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, acc.Balance, qt.Equals, uint64(0))
+
+	// the old key cannot be used anymore, nor receive tokens
+	qt.Assert(t, testNewProcess(t, nil, entity, app, process),
+		qt.ErrorMatches, ".*account rotated.*")
+	qt.Assert(t, testSendTokensTx(t, keys[4], app, entity.Address(), 100, 0),
+		qt.ErrorMatches, ".*account rotated.*")
+
+	// the elections of the organization are managed by the new key and its
+	// delegates, and new elections use the new address
+	status := models.ProcessStatus_PAUSED
+	qt.Assert(t, testSetProcessStatus(t, pid, entity, app, &status), qt.IsNotNil)
+	qt.Assert(t, testSetProcessStatus(t, pid, newKey, app, &status), qt.IsNil)
+	status = models.ProcessStatus_READY
+	qt.Assert(t, testSetProcessStatus(t, pid, delegate, app, &status), qt.IsNil)
+	qt.Assert(t, testNewProcess(t, nil, newKey, app, process),
+		qt.ErrorMatches, ".*account rotated.*")
+	process.EntityId = newKey.Address().Bytes()
+	qt.Assert(t, testNewProcess(t, nil, newKey, app, process), qt.IsNil)
+}
+
+func testRotateAccountTx(t *testing.T,
+	app *BaseApplication,
+	signer *ethereum.SignKeys,
+	newAddress common.Address,
+	cosigners []*ethereum.SignKeys) error {
+	acc, err := app.State.GetAccount(signer.Address(), false)
+	qt.Assert(t, err, qt.IsNil)
+	tx := &models.SetAccountTx{
+		Txtype:  models.TxType_ROTATE_ACCOUNT,
+		Nonce:   &acc.Nonce,
+		Account: newAddress.Bytes(),
+	}
+	stx := &models.SignedTx{}
+	if stx.Tx, err = proto.Marshal(&models.Tx{Payload: &models.Tx_SetAccount{SetAccount: tx}}); err != nil {
+		t.Fatal(err)
+	}
+	return testMultisigTx(t, app, signer, cosigners, stx)
+}
+
 func TestCollectFaucetTx(t *testing.T) {
 	app := TestBaseApplication(t)
 
@@ -49,6 +49,8 @@ type BaseApplication struct {
 	fnSendTx           func(tx []byte) (*ctypes.ResultBroadcastTx, error)
 	fnGetTx            func(height uint32, txIndex int32) (*models.SignedTx, error)
 	fnGetTxHash        func(height uint32, txIndex int32) (*models.SignedTx, []byte, error)
+	fnGetTxResults     func(height uint32) ([]*abcitypes.ResponseDeliverTx, error)
+	fnGetLightBlock    func(height int64) (*tmtypes.LightBlock, error)
 	fnMempoolSize      func() int
 	fnBeginBlock       func(req abcitypes.RequestBeginBlock) abcitypes.ResponseBeginBlock
 	fnEndBlock         func(req abcitypes.RequestEndBlock) abcitypes.ResponseEndBlock
@@ -63,6 +65,19 @@ type BaseApplication struct {
 	startBlockTimestamp int64
 	chainID             string
 	dataDir             string
+	// snapshotInterval is the number of blocks between state snapshots,
+	// if 0 no snapshots are performed
+	snapshotInterval uint32
+	// snapshotKeep is the number of the most recent state snapshots kept on
+	// disk, if 0 all of them are kept
+	snapshotKeep uint32
+	// snapshots caches the ABCI snapshots served to state sync peers,
+	// by height, and restore holds the snapshot being restored (if any)
+	snapshots     map[uint32]*abcitypes.Snapshot
+	restore       *snapshotRestore
+	snapshotsLock sync.Mutex
+	// snapshotting is true while a state snapshot is taken, see snapshot
+	snapshotting atomic.Bool
 }
 
 // Ensure that BaseApplication implements abcitypes.Application.
@@ -92,6 +107,7 @@ func NewBaseApplication(dbType, dbpath string) (*BaseApplication, error) {
 		State:              state,
 		TransactionHandler: transactionHandler,
 		blockCache:         lru.NewAtomic(32),
+		snapshots:          make(map[uint32]*abcitypes.Snapshot),
 		dataDir:            dbpath,
 		chainID:            "test",
 	}, nil
@@ -128,6 +144,12 @@ func TestBaseApplication(tb testing.TB) *BaseApplication {
 
 func (app *BaseApplication) SetNode(vochaincfg *config.VochainCfg, genesis []byte) error {
 	var err error
+	app.snapshotInterval = vochaincfg.SnapshotInterval
+	app.snapshotKeep = vochaincfg.SnapshotKeep
+	if !vochaincfg.Archive {
+		app.State.SetVersionRetention(vochaincfg.StateHistory)
+		app.State.SetVotesPruning(vochaincfg.VotesPruning)
+	}
 	if app.Service, err = newTendermint(app, vochaincfg, genesis); err != nil {
 		return fmt.Errorf("could not set tendermint node service: %s", err)
 	}
@@ -164,6 +186,8 @@ func (app *BaseApplication) SetDefaultMethods() {
 	app.IsSynchronizing = app.isSynchronizingTendermint
 	app.SetFnGetTx(app.getTxTendermint)
 	app.SetFnGetTxHash(app.getTxHashTendermint)
+	app.SetFnGetTxResults(app.getTxResultsTendermint)
+	app.SetFnGetLightBlock(app.getLightBlockTendermint)
 	app.SetFnMempoolSize(func() int {
 		// TODO: find the way to return correctly the mempool size
 		return 0
@@ -204,6 +228,18 @@ func (app *BaseApplication) SetTestingMethods() {
 		tx := blk.Txs[txIndex]
 		return &stx, tx.Hash(), proto.Unmarshal(blk.Txs[txIndex], &stx)
 	})
+	// the mock blocks only include the transactions delivered successfully
+	app.SetFnGetTxResults(func(height uint32) ([]*abcitypes.ResponseDeliverTx, error) {
+		blk := mockBlockStore.Get(int64(height))
+		if blk == nil {
+			return nil, fmt.Errorf("block not found")
+		}
+		results := make([]*abcitypes.ResponseDeliverTx, len(blk.Txs))
+		for i := range results {
+			results[i] = &abcitypes.ResponseDeliverTx{Code: 0}
+		}
+		return results, nil
+	})
 	app.SetFnSendTx(func(tx []byte) (*ctypes.ResultBroadcastTx, error) {
 		resp := app.DeliverTx(abcitypes.RequestDeliverTx{Tx: tx})
 		if resp.Code == 0 {
@@ -339,6 +375,177 @@ func (app *BaseApplication) getTxHashTendermint(height uint32, txIndex int32) (*
 	return tx, block.Txs[txIndex].Hash(), proto.Unmarshal(block.Txs[txIndex], tx)
 }
 
+// GetTxResults retrieves the results of delivering the transactions of a
+// block, in the same order as the block transactions.  Only the transactions
+// with a zero result code were applied to the state.
+func (app *BaseApplication) GetTxResults(height uint32) ([]*abcitypes.ResponseDeliverTx, error) {
+	if app.fnGetTxResults == nil {
+		return nil, fmt.Errorf("application getTxResults method not assigned")
+	}
+	return app.fnGetTxResults(height)
+}
+
+func (app *BaseApplication) getTxResultsTendermint(
+	height uint32) ([]*abcitypes.ResponseDeliverTx, error) {
+	h := int64(height)
+	res, err := app.Node.BlockResults(context.Background(), &h)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get results of block %d: %w", height, err)
+	}
+	return res.TxsResults, nil
+}
+
+// ReplayApp returns a read-only application at the end of the block at
+// height, with the state committed at that height and the blockstore of app.
+// It allows replaying the events of past blocks, such as when rebuilding the
+// indexer.  The returned application is synchronizing, and it cannot deliver
+// transactions nor commit blocks.
+func (app *BaseApplication) ReplayApp(height uint32) (*BaseApplication, error) {
+	state, err := app.State.ReplayStateAt(height)
+	if err != nil {
+		return nil, err
+	}
+	blk := app.GetBlockByHeight(int64(height))
+	if blk == nil {
+		return nil, fmt.Errorf("block %d not found on the blockstore", height)
+	}
+	replay := &BaseApplication{
+		State:              state,
+		Node:               app.Node,
+		IsSynchronizing:    func() bool { return true },
+		fnGetBlockByHeight: app.fnGetBlockByHeight,
+		fnGetBlockByHash:   app.fnGetBlockByHash,
+		fnGetTx:            app.fnGetTx,
+		fnGetTxHash:        app.fnGetTxHash,
+		fnGetTxResults:     app.fnGetTxResults,
+		fnGetLightBlock:    app.fnGetLightBlock,
+		blockCache:         app.blockCache,
+		height:             height,
+		chainID:            app.chainID,
+		dataDir:            app.dataDir,
+		// the replayed block starts and ends at the block header time
+		startBlockTimestamp: blk.Time.Unix(),
+		endBlockTimestamp:   blk.Time.Unix(),
+	}
+	return replay, nil
+}
+
+// ReplayBlock delivers again the transactions of the block at height on a
+// fork of the state committed at the previous height, with listeners as the
+// event listeners of the fork, so that they receive the events emitted when
+// the block was delivered, such as when rebuilding the indexer.  Only the
+// transactions delivered successfully are applied, and OnNewTx is called for
+// each of them.  The events of the block commit are not emitted.  It returns
+// the transactions of the block by their position, as modified by their
+// handlers, or nil for the failed ones.  The fork is discarded, so the state
+// is not modified.
+//
+// The votes of a process whose votes were pruned (see State.SetVotesPruning)
+// can't be checked again, so OnVote is called with the vote built from the
+// envelope, without weight, and the vote delegations emit no events.
+func (app *BaseApplication) ReplayBlock(height uint32,
+	listeners ...vstate.EventListener) ([]*vochaintx.VochainTx, error) {
+	if height == 0 {
+		return nil, fmt.Errorf("cannot replay the genesis block")
+	}
+	blk := app.GetBlockByHeight(int64(height))
+	if blk == nil {
+		return nil, fmt.Errorf("block %d not found on the blockstore", height)
+	}
+	results, err := app.GetTxResults(height)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != len(blk.Txs) {
+		return nil, fmt.Errorf("found %d transaction results for %d transactions",
+			len(results), len(blk.Txs))
+	}
+	fork, err := app.State.ForkAt(height - 1)
+	if err != nil {
+		return nil, err
+	}
+	defer fork.Tx.Discard()
+	for _, l := range listeners {
+		fork.AddEventListener(l)
+	}
+	fork.SetHeight(height)
+	fork.SetTimestamp(blk.Time.Unix())
+	handler := app.TransactionHandler.WithState(fork)
+	txs := make([]*vochaintx.VochainTx, len(blk.Txs))
+	for i, tx := range blk.Txs {
+		// only the successful transactions were applied to the state, but
+		// all of them count for the transaction index
+		if results[i].Code == 0 {
+			vtx := new(vochaintx.VochainTx)
+			if err := vtx.Unmarshal(tx, app.ChainID()); err != nil {
+				return nil, fmt.Errorf("cannot unmarshal transaction %d: %w", i, err)
+			}
+			if err := replayTx(fork, handler, vtx); err != nil {
+				return nil, fmt.Errorf("cannot replay transaction %d: %w", i, err)
+			}
+			for _, l := range listeners {
+				l.OnNewTx(vtx, height, fork.TxCounter())
+			}
+			txs[i] = vtx
+		}
+		fork.TxCounterAdd()
+	}
+	return txs, nil
+}
+
+// replayTx delivers again a transaction on the fork of the state, see
+// ReplayBlock.
+func replayTx(fork *vstate.State, handler *transaction.TransactionHandler,
+	vtx *vochaintx.VochainTx) error {
+	_, err := handler.CheckTx(vtx, true)
+	if !errors.Is(err, vstate.ErrVotesPruned) {
+		return err
+	}
+	vote, err := handler.PrunedVote(vtx)
+	if err != nil || vote == nil {
+		return err
+	}
+	log.Debugf("replaying pruned vote %x of process %x without weight",
+		vote.Nullifier, vote.ProcessID)
+	for _, l := range fork.EventListeners() {
+		l.OnVote(vote, fork.TxCounter())
+	}
+	return nil
+}
+
+// GetLightBlock retrieves the signed header and the validator set of a block,
+// which allow light clients to verify the block AppHash.
+func (app *BaseApplication) GetLightBlock(height int64) (*tmtypes.LightBlock, error) {
+	if app.fnGetLightBlock == nil {
+		return nil, fmt.Errorf("application getLightBlock method not assigned")
+	}
+	return app.fnGetLightBlock(height)
+}
+
+func (app *BaseApplication) getLightBlockTendermint(height int64) (*tmtypes.LightBlock, error) {
+	commit, err := app.Node.Commit(context.Background(), &height)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get commit of block %d: %w", height, err)
+	}
+	var validators []*tmtypes.Validator
+	perPage := 100
+	for page := 1; ; page++ {
+		res, err := app.Node.Validators(context.Background(), &height, &page, &perPage)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get validators of block %d: %w", height, err)
+		}
+		validators = append(validators, res.Validators...)
+		if len(res.Validators) == 0 || len(validators) >= res.Total {
+			break
+		}
+	}
+	valSet, err := tmtypes.ValidatorSetFromExistingValidators(validators)
+	if err != nil {
+		return nil, err
+	}
+	return &tmtypes.LightBlock{SignedHeader: &commit.SignedHeader, ValidatorSet: valSet}, nil
+}
+
 // SendTx sends a transaction to the mempool (sync)
 func (app *BaseApplication) SendTx(tx []byte) (*ctypes.ResultBroadcastTx, error) {
 	if app.fnSendTx == nil {
@@ -473,6 +680,7 @@ func (app *BaseApplication) fnBeginBlockDefault(
 	atomic.StoreInt64(&app.startBlockTimestamp, req.Header.GetTime().Unix())
 	height := uint32(req.Header.GetHeight())
 	app.State.SetHeight(height)
+	app.State.SetTimestamp(req.Header.GetTime().Unix())
 	go app.State.CachePurge(height)
 
 	return abcitypes.ResponseBeginBlock{}
@@ -560,14 +768,12 @@ func (app *BaseApplication) Commit() abcitypes.ResponseCommit {
 	if err != nil {
 		log.Fatalf("cannot save state: %v", err)
 	}
-	if false && app.Height()%50000 == 0 && !app.IsSynchronizing() { // DISABLED
-		startTime := time.Now()
-		log.Infof("performing a state snapshot on block %d", app.Height())
-		if _, err := app.State.Snapshot(); err != nil {
-			log.Fatalf("cannot make state snapshot: %v", err)
+	if app.snapshotInterval > 0 && app.Height()%app.snapshotInterval == 0 && !app.IsSynchronizing() {
+		height, err := app.State.LastHeight()
+		if err != nil {
+			log.Fatalf("cannot get state height: %v", err)
 		}
-		log.Infof("snapshot created successfully, took %s", time.Since(startTime))
-		log.Debugf("%+v", app.State.ListSnapshots())
+		app.snapshot(height, app.State.CurrentTimestamp())
 	}
 	return abcitypes.ResponseCommit{
 		Data: data,
@@ -591,26 +797,6 @@ func (app *BaseApplication) endBlock(height int64, timestamp time.Time) abcitype
 	return abcitypes.ResponseEndBlock{}
 }
 
-func (app *BaseApplication) ApplySnapshotChunk(
-	req abcitypes.RequestApplySnapshotChunk) abcitypes.ResponseApplySnapshotChunk {
-	return abcitypes.ResponseApplySnapshotChunk{}
-}
-
-func (app *BaseApplication) ListSnapshots(
-	req abcitypes.RequestListSnapshots) abcitypes.ResponseListSnapshots {
-	return abcitypes.ResponseListSnapshots{}
-}
-
-func (app *BaseApplication) LoadSnapshotChunk(
-	req abcitypes.RequestLoadSnapshotChunk) abcitypes.ResponseLoadSnapshotChunk {
-	return abcitypes.ResponseLoadSnapshotChunk{}
-}
-
-func (app *BaseApplication) OfferSnapshot(
-	req abcitypes.RequestOfferSnapshot) abcitypes.ResponseOfferSnapshot {
-	return abcitypes.ResponseOfferSnapshot{}
-}
-
 // SetFnGetBlockByHash sets the getter for blocks by hash
 func (app *BaseApplication) SetFnGetBlockByHash(fn func(hash []byte) *tmtypes.Block) {
 	app.fnGetBlockByHash = fn
@@ -621,6 +807,11 @@ func (app *BaseApplication) SetFnGetBlockByHeight(fn func(height int64) *tmtypes
 	app.fnGetBlockByHeight = fn
 }
 
+// SetFnGetLightBlock sets the getter for light blocks by height
+func (app *BaseApplication) SetFnGetLightBlock(fn func(height int64) (*tmtypes.LightBlock, error)) {
+	app.fnGetLightBlock = fn
+}
+
 // SetFnSendTx sets the sendTx method
 func (app *BaseApplication) SetFnSendTx(fn func(tx []byte) (*ctypes.ResultBroadcastTx, error)) {
 	app.fnSendTx = fn
@@ -636,6 +827,12 @@ func (app *BaseApplication) SetFnGetTxHash(fn func(height uint32, txIndex int32)
 	app.fnGetTxHash = fn
 }
 
+// SetFnGetTxResults sets the getTxResults method
+func (app *BaseApplication) SetFnGetTxResults(
+	fn func(height uint32) ([]*abcitypes.ResponseDeliverTx, error)) {
+	app.fnGetTxResults = fn
+}
+
 // SetFnMempoolSize sets the mempool size method method
 func (app *BaseApplication) SetFnMempoolSize(fn func() int) {
 	app.fnMempoolSize = fn
@@ -0,0 +1,883 @@
+// Package auditor re-verifies the votes of an election from the raw vochain
+// transactions, so its results can be checked without trusting a gateway or
+// an indexer.  The transactions, as found on the blocks, are replayed in
+// order: the election definition, status and census changes, the encryption
+// keys and the vote envelopes.  Every vote envelope is verified again (census
+// proof, nullifier, overwrites, decryption) and the election is re-tallied to
+// compare it against the results published on-chain.
+//
+// Transactions that failed on-chain, as reported by their DeliverTx result
+// code, are not applied.  The transactions which succeeded are still verified
+// again, including the authorization of their signers: the organization, its
+// account delegates and the oracles, whose list is provided with SetOracles.
+//
+// Only signature based elections can be audited, since the zk-SNARK proofs of
+// anonymous elections require the circuit verification keys.
+package auditor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/crypto/nacl"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/vochain/homomorphic"
+	"go.vocdoni.io/dvote/vochain/indexer"
+	"go.vocdoni.io/dvote/vochain/indexer/indexertypes"
+	"go.vocdoni.io/dvote/vochain/processid"
+	"go.vocdoni.io/dvote/vochain/state"
+	"go.vocdoni.io/dvote/vochain/transaction"
+	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxReportedVotes is the maximum number of rejected votes listed on a report.
+const maxReportedVotes = 1000
+
+// vote is a verified vote envelope, identified by its nullifier.
+type vote struct {
+	height      uint32
+	txIndex     int32
+	voter       common.Address
+	votePackage []byte
+	keyIndexes  []uint32
+	weight      *big.Int
+	overwrites  uint32
+}
+
+// delegation is a verified vote delegation, identified by its delegator.
+type delegation struct {
+	delegate common.Address
+	weight   *big.Int
+}
+
+// Auditor verifies the votes of a single election.  The transactions must be
+// added in the same order they were included on the blockchain, using AddTx.
+// Once all of them are added, Report returns the audit result.
+type Auditor struct {
+	chainID    string
+	electionID []byte
+
+	process    *models.Process
+	candidates int
+	pubKeys    [types.KeyKeeperMaxKeyIndex]string
+	privKeys   [types.KeyKeeperMaxKeyIndex]string
+	keyShares  [types.KeyKeeperMaxKeyIndex][][]byte
+	// keyCommitments are the commitments to the shares of each key, if shared
+	keyCommitments [types.KeyKeeperMaxKeyIndex][]byte
+	keyIndex       uint32
+	votes          map[string]*vote
+	// keyKeepers are the signers of the encryption keys of each index
+	keyKeepers [types.KeyKeeperMaxKeyIndex]common.Address
+
+	// owner is the current address of the organization, which changes if it
+	// rotates its account, and delegates are its account delegates.
+	owner     common.Address
+	delegates map[common.Address]bool
+	// oracles is the oracle list, or nil if it is unknown.
+	oracles       map[common.Address]bool
+	oraclesWarned bool
+
+	delegations map[common.Address]*delegation
+	delegators  map[common.Address][]common.Address
+	// depths are the lengths of the longest delegation chain ending in each
+	// census member, as the vochain tracks them.
+	depths map[common.Address]int
+
+	// blockTime is the header time of the block of the transaction being
+	// added, as unix seconds, or zero if unknown.
+	blockTime       int64
+	blockTimeWarned bool
+
+	report *Report
+}
+
+// New creates an auditor for the given election, on the given chain.
+func New(chainID string, electionID []byte) (*Auditor, error) {
+	if len(electionID) != types.ProcessIDsize {
+		return nil, fmt.Errorf("invalid election ID length %d", len(electionID))
+	}
+	// the organization address is part of the election ID
+	pid := new(processid.ProcessID)
+	if err := pid.Unmarshal(electionID); err != nil {
+		return nil, fmt.Errorf("invalid election ID: %w", err)
+	}
+	return &Auditor{
+		chainID:    chainID,
+		electionID: append([]byte{}, electionID...),
+		votes:      make(map[string]*vote),
+		owner:      pid.Addr(),
+		delegates:  make(map[common.Address]bool),
+
+		delegations: make(map[common.Address]*delegation),
+		delegators:  make(map[common.Address][]common.Address),
+		depths:      make(map[common.Address]int),
+		report: &Report{
+			ChainID:    chainID,
+			ElectionID: append([]byte{}, electionID...),
+		},
+	}, nil
+}
+
+// SetOracles sets the oracle list at the first audited height, such as the
+// one of the genesis.  Later changes are followed from the oracle admin
+// transactions.  If it is not set, the keykeepers cannot be verified.
+func (a *Auditor) SetOracles(oracles []common.Address) {
+	a.oracles = make(map[common.Address]bool)
+	for _, o := range oracles {
+		a.oracles[o] = true
+	}
+}
+
+// AddTx decodes and audits a signed transaction (a protobuf encoded
+// models.SignedTx), included on the given block height and position, with
+// the given DeliverTx result code.  The block header time, as unix seconds,
+// is required to audit the votes of time-bounded elections; zero means it is
+// unknown.  Transactions not related to the audited election, or that failed
+// on-chain (a non-zero code), are ignored.  An error is only returned if the
+// transaction cannot be decoded.
+func (a *Auditor) AddTx(height uint32, blockTime int64, txIndex int32, code uint32,
+	signedTx []byte) error {
+	a.blockTime = blockTime
+	if a.report.FirstHeight == 0 || height < a.report.FirstHeight {
+		a.report.FirstHeight = height
+	}
+	if height > a.report.LastHeight {
+		a.report.LastHeight = height
+	}
+	a.report.Transactions++
+	if code != 0 {
+		a.report.FailedTransactions++
+		return nil
+	}
+	vtx := new(vochaintx.VochainTx)
+	if err := vtx.Unmarshal(signedTx, a.chainID); err != nil {
+		return fmt.Errorf("cannot decode transaction %d at height %d: %w", txIndex, height, err)
+	}
+	// the sub-transactions of a batch succeeded all together
+	if subTxs, err := vochaintx.BatchTxs(vtx.Tx); err == nil {
+		for _, subTx := range subTxs {
+			a.addTx(vtx.BatchSubTx(subTx), height, txIndex)
+		}
+		return nil
+	}
+	a.addTx(vtx, height, txIndex)
+	return nil
+}
+
+// addTx audits a decoded transaction which succeeded on-chain.
+func (a *Auditor) addTx(vtx *vochaintx.VochainTx, height uint32, txIndex int32) {
+	switch vtx.Tx.Payload.(type) {
+	case *models.Tx_NewProcess:
+		a.newProcess(vtx, height)
+	case *models.Tx_SetProcess:
+		a.setProcess(vtx, height, txIndex)
+	case *models.Tx_Admin:
+		switch vtx.Tx.GetAdmin().Txtype {
+		case models.TxType_ADD_ORACLE, models.TxType_REMOVE_ORACLE:
+			a.setOracle(vtx)
+		default:
+			a.processKeys(vtx, height)
+		}
+	case *models.Tx_SetAccount:
+		a.setAccount(vtx)
+	case *models.Tx_Vote:
+		if len(vtx.Tx.GetVote().GetDelegate()) > 0 {
+			a.addDelegation(vtx, height, txIndex)
+		} else {
+			a.addVote(vtx, height, txIndex)
+		}
+	}
+}
+
+// setOracle applies the changes of the oracle list, if known.  The treasurer
+// signing them is not verified, since its key is not known by the auditor.
+func (a *Auditor) setOracle(vtx *vochaintx.VochainTx) {
+	tx := vtx.Tx.GetAdmin()
+	if a.oracles == nil || len(tx.Address) != common.AddressLength {
+		return
+	}
+	if tx.Txtype == models.TxType_ADD_ORACLE {
+		a.oracles[common.BytesToAddress(tx.Address)] = true
+	} else {
+		delete(a.oracles, common.BytesToAddress(tx.Address))
+	}
+}
+
+// setAccount follows the account delegates and the rotations of the
+// organization account, which authorize the changes of the election.
+func (a *Auditor) setAccount(vtx *vochaintx.VochainTx) {
+	tx := vtx.Tx.GetSetAccount()
+	switch tx.Txtype {
+	case models.TxType_ADD_DELEGATE_FOR_ACCOUNT, models.TxType_DEL_DELEGATE_FOR_ACCOUNT,
+		models.TxType_ROTATE_ACCOUNT:
+	default:
+		return
+	}
+	addr, err := a.signer(vtx)
+	if err != nil || addr != a.owner {
+		return
+	}
+	switch tx.Txtype {
+	case models.TxType_ADD_DELEGATE_FOR_ACCOUNT:
+		for _, d := range tx.Delegates {
+			a.delegates[common.BytesToAddress(d)] = true
+		}
+	case models.TxType_DEL_DELEGATE_FOR_ACCOUNT:
+		for _, d := range tx.Delegates {
+			delete(a.delegates, common.BytesToAddress(d))
+		}
+	case models.TxType_ROTATE_ACCOUNT:
+		// the delegates are kept by the new address, see state.RotateAccount
+		a.owner = common.BytesToAddress(tx.Account)
+		delete(a.delegates, a.owner)
+	}
+}
+
+// isOracle returns whether the address is an oracle.  If the oracle list is
+// unknown, it returns true and a warning is reported once.
+func (a *Auditor) isOracle(addr common.Address, height uint32) bool {
+	if a.oracles == nil {
+		if !a.oraclesWarned {
+			a.warnf(height, "oracles unknown, the keykeepers and oracles are not verified")
+			a.oraclesWarned = true
+		}
+		return true
+	}
+	return a.oracles[addr]
+}
+
+// warnf adds a warning to the report.
+func (a *Auditor) warnf(height uint32, format string, args ...interface{}) {
+	a.report.Warnings = append(a.report.Warnings,
+		fmt.Sprintf("height %d: %s", height, fmt.Sprintf(format, args...)))
+}
+
+// signer returns the address of the transaction signer.
+func (*Auditor) signer(vtx *vochaintx.VochainTx) (common.Address, error) {
+	if vtx.Signature == nil {
+		return common.Address{}, fmt.Errorf("missing signature")
+	}
+	// PubKeyFromSignature modifies the recovery byte of the signature
+	signature := append([]byte{}, vtx.Signature...)
+	return ethereum.AddrFromSignature(vtx.SignedBody, signature)
+}
+
+// newProcess looks for the transaction that created the audited election.
+// Since the election ID is built from the organization account nonce, which
+// cannot be known without the state, the candidates are the transactions whose
+// election fields match the ones encoded on the election ID.  If several
+// candidates are found, the last one is used and a warning is reported.
+func (a *Auditor) newProcess(vtx *vochaintx.VochainTx, height uint32) {
+	p := vtx.Tx.GetNewProcess().GetProcess()
+	if p == nil || p.VoteOptions == nil || p.EnvelopeType == nil || p.Mode == nil {
+		return
+	}
+	p = proto.Clone(p).(*models.Process)
+	if p.EntityId == nil {
+		addr, err := a.signer(vtx)
+		if err != nil {
+			return
+		}
+		p.EntityId = addr.Bytes()
+	}
+	if !bytes.Equal(p.ProcessId, a.electionID) {
+		pid := new(processid.ProcessID)
+		pid.SetChainID(a.chainID)
+		pid.SetAddr(common.BytesToAddress(p.EntityId))
+		pid.SetNonce(binary.BigEndian.Uint32(a.electionID[types.ProcessIDsize-4:]))
+		if pid.SetEnvelopeType(p.EnvelopeType) != nil || pid.SetCensusOrigin(p.CensusOrigin) != nil {
+			return
+		}
+		if !bytes.Equal(pid.Marshal(), a.electionID) {
+			return
+		}
+	}
+	if len(a.votes) > 0 || len(a.delegations) > 0 {
+		a.warnf(height, "ignoring election candidate created after the first vote")
+		return
+	}
+	a.candidates++
+	if a.candidates > 1 {
+		a.warnf(height, "found %d transactions matching the election ID, using the last one",
+			a.candidates)
+	}
+	p.ProcessId = a.electionID
+	// oracles may create elections for any organization
+	if owner := common.BytesToAddress(p.EntityId); owner != a.owner {
+		a.owner = owner
+		a.delegates = make(map[common.Address]bool)
+	}
+	// the election starts on the next block if no start block is set
+	if p.StartBlock == 0 || p.StartBlock == 1 {
+		p.StartBlock = height + 1
+	}
+	a.process = p
+	a.keyIndex = 0
+	a.report.OrganizationID = p.EntityId
+	a.report.CreationHeight = height
+	if p.EnvelopeType.Anonymous {
+		a.warnf(height, "anonymous elections cannot be audited offline")
+	}
+}
+
+// setProcess applies the status and census changes of the election, and
+// collects the published results.  Status and census changes are only
+// applied if they are signed by the organization, one of its account
+// delegates or an oracle, as the vochain does.
+func (a *Auditor) setProcess(vtx *vochaintx.VochainTx, height uint32, txIndex int32) {
+	tx := vtx.Tx.GetSetProcess()
+	if a.process == nil || !bytes.Equal(tx.GetProcessId(), a.electionID) {
+		return
+	}
+	addr, err := a.signer(vtx)
+	if err != nil {
+		a.warnf(height, "ignoring %s transaction: %v", tx.Txtype, err)
+		return
+	}
+	switch tx.Txtype {
+	case models.TxType_SET_PROCESS_RESULTS:
+		if tx.GetResults() == nil {
+			return
+		}
+		a.report.OnChainResults = append(a.report.OnChainResults, &OnChainResults{
+			Height:  height,
+			TxIndex: txIndex,
+			Signer:  addr.Bytes(),
+			Oracle:  tx.GetResults().GetOracleAddress(),
+			Votes:   questionResults(tx.GetResults().GetVotes()),
+		})
+		return
+	case models.TxType_SET_PROCESS_STATUS, models.TxType_SET_PROCESS_CENSUS:
+	default:
+		return
+	}
+	if addr != a.owner && !a.delegates[addr] && !(a.oracles != nil && a.oracles[addr]) {
+		a.warnf(height, "ignoring %s transaction signed by %s, which is not the organization",
+			tx.Txtype, addr.Hex())
+		return
+	}
+	if tx.Txtype == models.TxType_SET_PROCESS_STATUS {
+		if tx.GetStatus() != models.ProcessStatus_PROCESS_UNKNOWN {
+			a.process.Status = tx.GetStatus()
+		}
+		return
+	}
+	if !a.process.GetMode().GetDynamicCensus() {
+		a.warnf(height, "ignoring census change of an election without dynamic census")
+		return
+	}
+	if tx.GetCensusRoot() != nil {
+		a.process.CensusRoot = tx.GetCensusRoot()
+		a.process.CensusURI = tx.CensusURI
+	}
+}
+
+// processKeys collects the election encryption keys, which must be signed by
+// an oracle acting as keykeeper.  Each keykeeper adds a single key.  A
+// revealed private key is only accepted if it matches the public key published
+// on the same index.  Revealed key shares are accumulated until the key can be
+// reconstructed, and each one must be signed by the keykeeper of its
+// coordinate and match the commitments published with the key.
+func (a *Auditor) processKeys(vtx *vochaintx.VochainTx, height uint32) {
+	tx := vtx.Tx.GetAdmin()
+	if a.process == nil || !bytes.Equal(tx.GetProcessId(), a.electionID) || tx.KeyIndex == nil {
+		return
+	}
+	index := tx.GetKeyIndex()
+	if index >= types.KeyKeeperMaxKeyIndex {
+		a.warnf(height, "ignoring encryption key with index %d", index)
+		return
+	}
+	addr, err := a.signer(vtx)
+	if err != nil {
+		a.warnf(height, "ignoring %s transaction: %v", tx.Txtype, err)
+		return
+	}
+	if !a.isOracle(addr, height) {
+		a.warnf(height, "ignoring encryption key %d signed by %s, which is not an oracle",
+			index, addr.Hex())
+		return
+	}
+	switch tx.Txtype {
+	case models.TxType_ADD_PROCESS_KEYS:
+		if tx.EncryptionPublicKey == nil || a.pubKeys[index] != "" {
+			return
+		}
+		for i, k := range a.keyKeepers {
+			if k == addr {
+				a.warnf(height, "ignoring encryption key %d, keykeeper %s already added the key %d",
+					index, addr.Hex(), i)
+				return
+			}
+		}
+		// the keys of homomorphic elections are added to the joint key, so
+		// they are only accepted with the proof of their private key
+		if a.process.EnvelopeType.GetHomomorphic() {
+			if err := homomorphic.VerifyKey(a.electionID, int(index),
+				tx.EncryptionPublicKey, tx.EncryptionKeyProof); err != nil {
+				a.warnf(height, "ignoring encryption key %d: %v", index, err)
+				return
+			}
+		}
+		a.pubKeys[index] = fmt.Sprintf("%x", tx.EncryptionPublicKey)
+		a.keyCommitments[index] = tx.KeyShareCommitments
+		a.keyKeepers[index] = addr
+		a.keyIndex++
+	case models.TxType_REVEAL_PROCESS_KEYS:
+		if (tx.EncryptionPrivateKey == nil && tx.EncryptionKeyShare == nil) ||
+			a.privKeys[index] != "" {
+			return
+		}
+		// homomorphic elections reveal the partial decryption of the results,
+		// which is verified when tallying
+		if a.process.EnvelopeType.GetHomomorphic() {
+			a.privKeys[index] = fmt.Sprintf("%x", tx.EncryptionPrivateKey)
+			return
+		}
+		privKey := tx.EncryptionPrivateKey
+		if share := tx.EncryptionKeyShare; share != nil {
+			if privKey != nil || len(share) != state.ProcessKeyShareSize {
+				a.warnf(height, "ignoring invalid share of encryption key %d", index)
+				return
+			}
+			x := uint32(share[0])
+			if x == index || x >= types.KeyKeeperMaxKeyIndex || a.keyKeepers[x] != addr {
+				a.warnf(height, "ignoring share %d of encryption key %d, not signed by its keykeeper",
+					x, index)
+				return
+			}
+			if privKey = a.addKeyShare(height, index, share); privKey == nil {
+				return
+			}
+		}
+		priv := fmt.Sprintf("%x", privKey)
+		key, err := nacl.DecodePrivate(priv)
+		if err != nil {
+			a.warnf(height, "ignoring invalid encryption key %d: %v", index, err)
+			return
+		}
+		if pub := fmt.Sprintf("%x", key.Public().Bytes()); pub != a.pubKeys[index] {
+			a.warnf(height, "ignoring encryption key %d, it does not match the public key", index)
+			return
+		}
+		a.privKeys[index] = priv
+	}
+}
+
+// addKeyShare stores a revealed share of an encryption key, and returns the
+// private key if it can be reconstructed with the known shares.  As on the
+// state, a share must match the commitments of the key and it cannot be
+// replaced.
+func (a *Auditor) addKeyShare(height, index uint32, share []byte) []byte {
+	shares, err := state.AddProcessKeyShare(a.keyShares[index], share, a.keyCommitments[index])
+	if err != nil {
+		a.warnf(height, "ignoring share %d of encryption key %d: %v", share[0], index, err)
+		return nil
+	}
+	a.keyShares[index] = shares
+	privKey, err := state.CombineProcessKeyShares(shares, a.keyCommitments[index], a.pubKeys[index])
+	if err != nil {
+		return nil
+	}
+	return privKey
+}
+
+// addVote verifies a vote envelope the same way the vochain does when the
+// vote transaction is delivered.
+func (a *Auditor) addVote(vtx *vochaintx.VochainTx, height uint32, txIndex int32) {
+	envelope := vtx.Tx.GetVote()
+	if !bytes.Equal(envelope.GetProcessId(), a.electionID) {
+		return
+	}
+	a.report.Envelopes++
+	nullifier, v, err := a.verifyVote(vtx, envelope, height)
+	if err != nil {
+		a.report.InvalidVotes++
+		a.report.reject(&RejectedVote{
+			Height:    height,
+			TxIndex:   txIndex,
+			Nullifier: nullifier,
+			Reason:    err.Error(),
+		})
+		return
+	}
+	v.txIndex = txIndex
+	if prev, ok := a.votes[string(nullifier)]; ok {
+		v.overwrites = prev.overwrites + 1
+		a.report.Overwrites++
+	}
+	a.votes[string(nullifier)] = v
+}
+
+// verifyVoter verifies the signer of a vote envelope belongs to the census of
+// the election, and the election accepts votes at the height.  It returns the
+// address of the voter, once known, and its weight on the census.
+func (a *Auditor) verifyVoter(vtx *vochaintx.VochainTx, envelope *models.VoteEnvelope,
+	height uint32) (common.Address, *big.Int, error) {
+	p := a.process
+	if _, _, ok := state.ProcessTimeBounds(p.Mode); ok && a.blockTime == 0 {
+		if !a.blockTimeWarned {
+			a.warnf(height, "block times unknown, the voting period of the election is not verified")
+			a.blockTimeWarned = true
+		}
+	} else if err := transaction.CheckVotingPeriod(p, height, a.blockTime); err != nil {
+		return common.Address{}, nil, err
+	}
+	if p.Status != models.ProcessStatus_READY {
+		return common.Address{}, nil, fmt.Errorf("election not in READY state (%s)", p.Status)
+	}
+	if envelope.Proof == nil || envelope.Proof.Payload == nil {
+		return common.Address{}, nil, fmt.Errorf("proof not found")
+	}
+	if vtx.Signature == nil {
+		return common.Address{}, nil, fmt.Errorf("signature missing")
+	}
+	// PubKeyFromSignature modifies the recovery byte of the signature
+	signature := append([]byte{}, vtx.Signature...)
+	pubKey, err := ethereum.PubKeyFromSignature(vtx.SignedBody, signature)
+	if err != nil {
+		return common.Address{}, nil,
+			fmt.Errorf("cannot extract public key from signature: %w", err)
+	}
+	addr, err := ethereum.AddrFromPublicKey(pubKey)
+	if err != nil {
+		return common.Address{}, nil,
+			fmt.Errorf("cannot extract address from public key: %w", err)
+	}
+	valid, weight, err := transaction.VerifyProof(p, envelope.Proof,
+		p.CensusOrigin, p.CensusRoot, p.ProcessId, pubKey, addr)
+	if err != nil {
+		return addr, nil, err
+	}
+	if !valid {
+		return addr, nil, fmt.Errorf("proof not valid")
+	}
+	return addr, weight, nil
+}
+
+func (a *Auditor) verifyVote(vtx *vochaintx.VochainTx, envelope *models.VoteEnvelope,
+	height uint32) ([]byte, *vote, error) {
+	p := a.process
+	if p == nil {
+		return nil, nil, fmt.Errorf("election not found")
+	}
+	if p.EnvelopeType.Anonymous {
+		return envelope.Nullifier, nil, fmt.Errorf("anonymous votes cannot be verified")
+	}
+	if p.EnvelopeType.EncryptedVotes {
+		if a.keyIndex < 1 {
+			return nil, nil, fmt.Errorf("no encryption keys available")
+		}
+		if len(envelope.EncryptionKeyIndexes) == 0 {
+			return nil, nil, fmt.Errorf("no key indexes provided on vote package")
+		}
+	}
+	addr, weight, err := a.verifyVoter(vtx, envelope, height)
+	if err != nil {
+		// the vote is identified by its nullifier once the voter is known
+		var nullifier []byte
+		if addr != (common.Address{}) {
+			nullifier = state.GenerateNullifier(addr, p.ProcessId)
+		}
+		return nullifier, nil, err
+	}
+	nullifier := state.GenerateNullifier(addr, p.ProcessId)
+	if prev, ok := a.votes[string(nullifier)]; ok {
+		if prev.overwrites >= p.VoteOptions.MaxVoteOverwrites {
+			return nullifier, nil, fmt.Errorf("vote overwrite count reached")
+		}
+	}
+	if p.EnvelopeType.GetHomomorphic() {
+		if err := homomorphic.CheckVoteWeight(weight); err != nil {
+			return nullifier, nil, err
+		}
+		err := homomorphic.VerifyVotePackage(a.keysProcess(), envelope.VotePackage, nullifier)
+		if err != nil {
+			return nullifier, nil, fmt.Errorf("invalid homomorphic ballot: %w", err)
+		}
+	}
+	return nullifier, &vote{
+		height:      height,
+		voter:       addr,
+		votePackage: envelope.VotePackage,
+		keyIndexes:  envelope.EncryptionKeyIndexes,
+		weight:      weight,
+	}, nil
+}
+
+// addDelegation verifies a vote delegation the same way the vochain does when
+// the delegation transaction is delivered, see state.AddVoteDelegation.
+func (a *Auditor) addDelegation(vtx *vochaintx.VochainTx, height uint32, txIndex int32) {
+	envelope := vtx.Tx.GetVote()
+	if !bytes.Equal(envelope.GetProcessId(), a.electionID) {
+		return
+	}
+	a.report.Envelopes++
+	delegator, d, err := a.verifyDelegation(vtx, envelope, height)
+	if err != nil {
+		a.report.InvalidVotes++
+		rejected := &RejectedVote{
+			Height:  height,
+			TxIndex: txIndex,
+			Reason:  fmt.Sprintf("vote delegation not valid: %v", err),
+		}
+		if delegator != (common.Address{}) {
+			rejected.Nullifier = state.GenerateNullifier(delegator, a.electionID)
+		}
+		a.report.reject(rejected)
+		return
+	}
+	a.report.Delegations++
+	a.delegations[delegator] = d
+	a.delegators[d.delegate] = append(a.delegators[d.delegate], delegator)
+	for addr, depth := d.delegate, a.depths[delegator]+1; depth > a.depths[addr]; depth++ {
+		a.depths[addr] = depth
+		if a.delegations[addr] == nil {
+			break
+		}
+		addr = a.delegations[addr].delegate
+	}
+}
+
+func (a *Auditor) verifyDelegation(vtx *vochaintx.VochainTx, envelope *models.VoteEnvelope,
+	height uint32) (common.Address, *delegation, error) {
+	p := a.process
+	if p == nil {
+		return common.Address{}, nil, fmt.Errorf("election not found")
+	}
+	if p.EnvelopeType.Anonymous || p.EnvelopeType.GetHomomorphic() {
+		return common.Address{}, nil,
+			fmt.Errorf("not supported by anonymous nor homomorphic elections")
+	}
+	delegate, err := vochaintx.VoteDelegate(envelope)
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	if len(envelope.VotePackage) > 0 {
+		return common.Address{}, nil, fmt.Errorf("vote delegation cannot include a vote package")
+	}
+	delegator, weight, err := a.verifyVoter(vtx, envelope, height)
+	if err != nil {
+		return delegator, nil, err
+	}
+	if delegator == *delegate {
+		return delegator, nil, state.ErrVoteDelegationCycle
+	}
+	if d, ok := a.delegations[delegator]; ok {
+		return delegator, nil, fmt.Errorf("%w to %s", state.ErrVoteAlreadyDelegated,
+			d.delegate.Hex())
+	}
+	if _, ok := a.votes[string(state.GenerateNullifier(delegator, p.ProcessId))]; ok {
+		return delegator, nil, fmt.Errorf("%s already voted", delegator.Hex())
+	}
+	for addr, depth := *delegate, a.depths[delegator]+1; ; depth++ {
+		if depth > state.MaxVoteDelegationDepth {
+			return delegator, nil, fmt.Errorf("vote delegation chain longer than %d",
+				state.MaxVoteDelegationDepth)
+		}
+		if a.delegations[addr] == nil {
+			break
+		}
+		if a.delegations[addr].delegate == delegator {
+			return delegator, nil, state.ErrVoteDelegationCycle
+		}
+		addr = a.delegations[addr].delegate
+	}
+	if len(a.delegators[*delegate]) >= state.MaxVoteDelegators {
+		return delegator, nil, fmt.Errorf("delegate %s reached the maximum number of delegators",
+			delegate.Hex())
+	}
+	if err := transaction.VerifyVoteDelegate(p, envelope, *delegate); err != nil {
+		return delegator, nil, err
+	}
+	return delegator, &delegation{delegate: *delegate, weight: weight}, nil
+}
+
+// delegatedWeight returns the weight delegated to the voter by the delegators
+// who did not vote, following the delegation chains, as the vochain adds it to
+// the vote.  The chains are not longer than state.MaxVoteDelegationDepth.
+func (a *Auditor) delegatedWeight(voter common.Address) *big.Int {
+	weight := new(big.Int)
+	for _, delegator := range a.delegators[voter] {
+		if _, ok := a.votes[string(state.GenerateNullifier(delegator, a.electionID))]; ok {
+			continue
+		}
+		weight.Add(weight, a.delegations[delegator].weight)
+		weight.Add(weight, a.delegatedWeight(delegator))
+	}
+	return weight
+}
+
+// Report tallies the verified votes and returns the audit report.  The
+// results are computed the same way the indexer does, so they can be compared
+// with the ones published on-chain.
+func (a *Auditor) Report() (*Report, error) {
+	p := a.process
+	if p == nil {
+		return nil, fmt.Errorf("election %x not found", a.electionID)
+	}
+	// the report is copied, so it can be built again if more transactions are added
+	r := new(Report)
+	*r = *a.report
+	r.RejectedVotes = append([]*RejectedVote{}, a.report.RejectedVotes...)
+	r.OnChainResults = []*OnChainResults{}
+	for _, oc := range a.report.OnChainResults {
+		c := *oc
+		r.OnChainResults = append(r.OnChainResults, &c)
+	}
+	r.CensusOrigin = p.CensusOrigin.String()
+	r.CensusRoot = p.CensusRoot
+	r.StartBlock = p.StartBlock
+	r.EndBlock = p.StartBlock + p.BlockCount
+	r.Status = p.Status.String()
+	r.Encrypted = p.EnvelopeType.EncryptedVotes
+	if p.VoteOptions.MaxCount == 0 || p.VoteOptions.MaxValue == 0 ||
+		p.VoteOptions.MaxCount > indexer.MaxQuestions || p.VoteOptions.MaxValue > indexer.MaxOptions {
+		return nil, fmt.Errorf("invalid election vote options")
+	}
+	results := &indexertypes.Results{
+		Votes: indexertypes.NewEmptyVotes(int(p.VoteOptions.MaxCount),
+			int(p.VoteOptions.MaxValue)+1),
+		ProcessID:    p.ProcessId,
+		Weight:       new(types.BigInt).SetUint64(0),
+		VoteOpts:     p.VoteOptions,
+		EnvelopeType: p.EnvelopeType,
+	}
+	if p.EnvelopeType.GetHomomorphic() {
+		a.tallyHomomorphic(r, results)
+	} else {
+		a.tallyVotes(r, results)
+	}
+	r.Weight = results.Weight
+	r.Results = results.Votes
+
+	// compare with the results published on-chain by the oracles
+	r.ResultsMatch = len(r.OnChainResults) > 0
+	for _, oc := range r.OnChainResults {
+		oc.Match = equalResults(results.Votes, oc.Votes)
+		r.ResultsMatch = r.ResultsMatch && oc.Match
+	}
+	return r, nil
+}
+
+// tallyVotes decrypts and adds each verified vote to the results.
+func (a *Auditor) tallyVotes(r *Report, results *indexertypes.Results) {
+	p := a.process
+	lock := sync.Mutex{}
+	for _, nullifier := range a.sortedNullifiers() {
+		v := a.votes[nullifier]
+		keys := []string{}
+		if p.EnvelopeType.EncryptedVotes {
+			for _, k := range v.keyIndexes {
+				if k >= types.KeyKeeperMaxKeyIndex || a.privKeys[k] == "" {
+					keys = nil
+					break
+				}
+				keys = append(keys, a.privKeys[k])
+			}
+		}
+		if keys == nil {
+			r.UncountedVotes++
+			r.reject(v.uncounted(nullifier, "encryption key not revealed"))
+			continue
+		}
+		vp, err := indexer.UnmarshalVote(v.votePackage, keys)
+		if err != nil {
+			r.UncountedVotes++
+			r.reject(v.uncounted(nullifier, err.Error()))
+			continue
+		}
+		weight := new(big.Int).Add(v.weight, a.delegatedWeight(v.voter))
+		if err := results.AddVote(vp.Votes, weight, &lock); err != nil {
+			r.UncountedVotes++
+			r.reject(v.uncounted(nullifier, err.Error()))
+			continue
+		}
+		r.ValidVotes++
+	}
+}
+
+// keysProcess returns a copy of the election with the collected encryption
+// keys.
+func (a *Auditor) keysProcess() *models.Process {
+	p := proto.Clone(a.process).(*models.Process)
+	p.EncryptionPublicKeys = append([]string{}, a.pubKeys[:]...)
+	p.EncryptionPrivateKeys = append([]string{}, a.privKeys[:]...)
+	return p
+}
+
+// tallyHomomorphic sums the encrypted ballots of a homomorphic election and
+// decrypts the sum with the revealed partial decryptions.
+func (a *Auditor) tallyHomomorphic(r *Report, results *indexertypes.Results) {
+	tally := homomorphic.NewTally(a.process.VoteOptions)
+	for _, nullifier := range a.sortedNullifiers() {
+		v := a.votes[nullifier]
+		if err := tally.AddVote(&models.StateDBVote{
+			VotePackage: v.votePackage,
+			Weight:      v.weight.Bytes(),
+		}); err != nil {
+			r.UncountedVotes++
+			r.reject(v.uncounted(nullifier, err.Error()))
+		}
+	}
+	votes, err := tally.Results(a.keysProcess())
+	if err != nil {
+		r.UncountedVotes += uint64(tally.Votes())
+		r.Warnings = append(r.Warnings, fmt.Sprintf("cannot decrypt the results: %v", err))
+		return
+	}
+	for i := range votes {
+		for j := range votes[i] {
+			results.Votes[i][j] = (*types.BigInt)(votes[i][j])
+		}
+	}
+	results.Weight = (*types.BigInt)(tally.Weight())
+	r.ValidVotes += uint64(tally.Votes())
+}
+
+// uncounted returns the rejection of a verified vote that cannot be counted.
+func (v *vote) uncounted(nullifier, reason string) *RejectedVote {
+	return &RejectedVote{
+		Height:    v.height,
+		TxIndex:   v.txIndex,
+		Nullifier: []byte(nullifier),
+		Reason:    fmt.Sprintf("vote not counted: %s", reason),
+	}
+}
+
+// questionResults converts the protobuf results to big integers.
+func questionResults(qr []*models.QuestionResult) [][]*types.BigInt {
+	votes := [][]*types.BigInt{}
+	for _, q := range qr {
+		question := []*types.BigInt{}
+		for _, v := range q.GetQuestion() {
+			question = append(question, new(types.BigInt).SetBytes(v))
+		}
+		votes = append(votes, question)
+	}
+	return votes
+}
+
+func equalResults(a, b [][]*types.BigInt) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i]) != len(b[i]) {
+			return false
+		}
+		for j := range a[i] {
+			if !a[i][j].Equal(b[i][j]) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,405 @@
+package auditor
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/censustree"
+	"go.vocdoni.io/dvote/crypto"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/crypto/nacl"
+	"go.vocdoni.io/dvote/db/metadb"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/processid"
+	"go.vocdoni.io/dvote/vochain/state"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+const testChainID = "test"
+
+// testCensus creates a census with the given voters and returns its root and
+// the census proof of each voter.
+func testCensus(t *testing.T, voters []*ethereum.SignKeys) ([]byte, [][]byte) {
+	tr, err := censustree.New(censustree.Options{Name: "census", ParentDB: metadb.NewTest(t),
+		MaxLevels: 256, CensusType: models.Census_ARBO_BLAKE2B})
+	qt.Assert(t, err, qt.IsNil)
+	keys := [][]byte{}
+	for _, v := range voters {
+		key, err := tr.Hash(v.PublicKey())
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, tr.Add(key, nil), qt.IsNil)
+		keys = append(keys, key)
+	}
+	tr.Publish()
+	proofs := [][]byte{}
+	for _, key := range keys {
+		_, proof, err := tr.GenProof(key)
+		qt.Assert(t, err, qt.IsNil)
+		proofs = append(proofs, proof)
+	}
+	root, err := tr.Root()
+	qt.Assert(t, err, qt.IsNil)
+	return root, proofs
+}
+
+func testSignTx(t *testing.T, signer *ethereum.SignKeys, tx *models.Tx) []byte {
+	var err error
+	stx := &models.SignedTx{}
+	stx.Tx, err = proto.Marshal(tx)
+	qt.Assert(t, err, qt.IsNil)
+	stx.Signature, err = signer.SignVocdoniTx(stx.Tx, testChainID)
+	qt.Assert(t, err, qt.IsNil)
+	data, err := proto.Marshal(stx)
+	qt.Assert(t, err, qt.IsNil)
+	return data
+}
+
+func testProof(siblings []byte) *models.Proof {
+	return &models.Proof{Payload: &models.Proof_Arbo{Arbo: &models.ProofArbo{
+		Type:     models.ProofArbo_BLAKE2B,
+		Siblings: siblings,
+		KeyType:  models.ProofArbo_PUBKEY,
+	}}}
+}
+
+// testVoteTx builds a vote, encrypted with the key index 1 if encryptionKey
+// is not nil.
+func testVoteTx(t *testing.T, voter *ethereum.SignKeys, electionID, proof []byte,
+	encryptionKey crypto.PublicKey, votes []int) []byte {
+	vp, err := json.Marshal(&vochain.VotePackage{Votes: votes})
+	qt.Assert(t, err, qt.IsNil)
+	envelope := &models.VoteEnvelope{
+		Nonce:       util.RandomBytes(32),
+		ProcessId:   electionID,
+		Proof:       testProof(proof),
+		VotePackage: vp,
+	}
+	if encryptionKey != nil {
+		envelope.VotePackage, err = nacl.Anonymous.Encrypt(vp, encryptionKey)
+		qt.Assert(t, err, qt.IsNil)
+		envelope.EncryptionKeyIndexes = []uint32{1}
+	}
+	return testSignTx(t, voter, &models.Tx{Payload: &models.Tx_Vote{Vote: envelope}})
+}
+
+func testDelegationTx(t *testing.T, voter *ethereum.SignKeys, electionID, proof []byte,
+	delegate *ethereum.SignKeys, delegateProof []byte) []byte {
+	return testSignTx(t, voter, &models.Tx{Payload: &models.Tx_Vote{Vote: &models.VoteEnvelope{
+		Nonce:             util.RandomBytes(32),
+		ProcessId:         electionID,
+		Proof:             testProof(proof),
+		Delegate:          delegate.Address().Bytes(),
+		DelegateProof:     testProof(delegateProof),
+		DelegatePublicKey: delegate.PublicKey(),
+	}}})
+}
+
+func testSetProcessTx(t *testing.T, signer *ethereum.SignKeys, tx *models.SetProcessTx) []byte {
+	return testSignTx(t, signer, &models.Tx{Payload: &models.Tx_SetProcess{SetProcess: tx}})
+}
+
+func TestAuditor(t *testing.T) {
+	organization := ethereum.NewSignKeys()
+	qt.Assert(t, organization.Generate(), qt.IsNil)
+	oracle := ethereum.NewSignKeys()
+	qt.Assert(t, oracle.Generate(), qt.IsNil)
+	accountDelegate := ethereum.NewSignKeys()
+	qt.Assert(t, accountDelegate.Generate(), qt.IsNil)
+	stranger := ethereum.NewSignKeys()
+	qt.Assert(t, stranger.Generate(), qt.IsNil)
+	voters := util.CreateEthRandomKeysBatch(4)
+	// the last voter is not part of the census
+	root, proofs := testCensus(t, voters[:3])
+	encryptionKey, err := nacl.Generate(nil)
+	qt.Assert(t, err, qt.IsNil)
+	strangerKey, err := nacl.Generate(nil)
+	qt.Assert(t, err, qt.IsNil)
+	keyIndex := uint32(1)
+
+	process := &models.Process{
+		EntityId:     organization.Address().Bytes(),
+		EnvelopeType: &models.EnvelopeType{EncryptedVotes: true},
+		Mode:         &models.ProcessMode{AutoStart: true},
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 2, MaxValue: 2, MaxVoteOverwrites: 1},
+		Status:       models.ProcessStatus_READY,
+		CensusRoot:   root,
+		CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+		BlockCount:   10,
+	}
+	pid := new(processid.ProcessID)
+	pid.SetChainID(testChainID)
+	pid.SetAddr(organization.Address())
+	qt.Assert(t, pid.SetEnvelopeType(process.EnvelopeType), qt.IsNil)
+	qt.Assert(t, pid.SetCensusOrigin(process.CensusOrigin), qt.IsNil)
+	electionID := pid.Marshal()
+
+	results := func(votes [][]int) *models.ProcessResult {
+		qr := []*models.QuestionResult{}
+		for _, q := range votes {
+			question := &models.QuestionResult{}
+			for _, v := range q {
+				question.Question = append(question.Question, new(types.BigInt).SetUint64(uint64(v)).Bytes())
+			}
+			qr = append(qr, question)
+		}
+		return &models.ProcessResult{ProcessId: electionID, Votes: qr}
+	}
+
+	txs := []struct {
+		height uint32
+		tx     []byte
+		code   uint32
+	}{
+		{1, testSignTx(t, organization, &models.Tx{Payload: &models.Tx_NewProcess{
+			NewProcess: &models.NewProcessTx{Txtype: models.TxType_NEW_PROCESS, Process: process},
+		}}), 0},
+		// encryption keys must be added by an oracle
+		{1, testSignTx(t, stranger, &models.Tx{Payload: &models.Tx_Admin{Admin: &models.AdminTx{
+			Txtype:              models.TxType_ADD_PROCESS_KEYS,
+			ProcessId:           electionID,
+			KeyIndex:            &keyIndex,
+			EncryptionPublicKey: strangerKey.Public().Bytes(),
+		}}}), 0},
+		{1, testSignTx(t, oracle, &models.Tx{Payload: &models.Tx_Admin{Admin: &models.AdminTx{
+			Txtype:              models.TxType_ADD_PROCESS_KEYS,
+			ProcessId:           electionID,
+			KeyIndex:            &keyIndex,
+			EncryptionPublicKey: encryptionKey.Public().Bytes(),
+		}}}), 0},
+		// the election starts at height 2, so this vote is not valid
+		{1, testVoteTx(t, voters[1], electionID, proofs[1], encryptionKey.Public(), []int{1, 1}), 0},
+		{2, testVoteTx(t, voters[0], electionID, proofs[0], encryptionKey.Public(), []int{1, 2}), 0},
+		{2, testVoteTx(t, voters[1], electionID, proofs[1], encryptionKey.Public(), []int{0, 1}), 0},
+		{2, testVoteTx(t, voters[2], electionID, proofs[2], encryptionKey.Public(), []int{2, 2}), 0},
+		// the first vote is overwritten, the second overwrite is not allowed
+		{3, testVoteTx(t, voters[0], electionID, proofs[0], encryptionKey.Public(), []int{0, 0}), 0},
+		{4, testVoteTx(t, voters[0], electionID, proofs[0], encryptionKey.Public(), []int{2, 0}), 0},
+		// the proof of another voter is not valid
+		{4, testVoteTx(t, voters[3], electionID, proofs[0], encryptionKey.Public(), []int{2, 2}), 0},
+		// a vote which failed on-chain is not applied
+		{4, testVoteTx(t, voters[1], electionID, proofs[1], encryptionKey.Public(), []int{2, 2}), 1},
+		// status changes must be signed by the organization or its delegates
+		{5, testSetProcessTx(t, stranger, &models.SetProcessTx{
+			Txtype:    models.TxType_SET_PROCESS_STATUS,
+			ProcessId: electionID,
+			Status:    models.ProcessStatus_ENDED.Enum(),
+		}), 0},
+		{5, testSignTx(t, organization, &models.Tx{Payload: &models.Tx_SetAccount{
+			SetAccount: &models.SetAccountTx{
+				Txtype:    models.TxType_ADD_DELEGATE_FOR_ACCOUNT,
+				Delegates: [][]byte{accountDelegate.Address().Bytes()},
+			},
+		}}), 0},
+		{5, testSetProcessTx(t, accountDelegate, &models.SetProcessTx{
+			Txtype:    models.TxType_SET_PROCESS_STATUS,
+			ProcessId: electionID,
+			Status:    models.ProcessStatus_ENDED.Enum(),
+		}), 0},
+		{6, testVoteTx(t, voters[2], electionID, proofs[2], encryptionKey.Public(), []int{0, 0}), 0},
+		{7, testSignTx(t, oracle, &models.Tx{Payload: &models.Tx_Admin{Admin: &models.AdminTx{
+			Txtype:               models.TxType_REVEAL_PROCESS_KEYS,
+			ProcessId:            electionID,
+			KeyIndex:             &keyIndex,
+			EncryptionPrivateKey: encryptionKey.Bytes(),
+		}}}), 0},
+		{8, testSetProcessTx(t, oracle, &models.SetProcessTx{
+			Txtype:    models.TxType_SET_PROCESS_RESULTS,
+			ProcessId: electionID,
+			Results:   results([][]int{{2, 0, 1}, {1, 1, 1}}),
+		}), 0},
+	}
+
+	auditor, err := New(testChainID, electionID)
+	qt.Assert(t, err, qt.IsNil)
+	auditor.SetOracles([]common.Address{oracle.Address()})
+	for i, tx := range txs {
+		qt.Assert(t, auditor.AddTx(tx.height, 0, int32(i), tx.code, tx.tx), qt.IsNil)
+	}
+	report, err := auditor.Report()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, report.FailedTransactions, qt.Equals, uint64(1))
+	qt.Assert(t, report.StartBlock, qt.Equals, uint32(2))
+	qt.Assert(t, report.Status, qt.Equals, models.ProcessStatus_ENDED.String())
+	qt.Assert(t, report.Envelopes, qt.Equals, uint64(8))
+	qt.Assert(t, report.InvalidVotes, qt.Equals, uint64(4))
+	qt.Assert(t, report.Overwrites, qt.Equals, uint64(1))
+	qt.Assert(t, report.ValidVotes, qt.Equals, uint64(3))
+	qt.Assert(t, report.UncountedVotes, qt.Equals, uint64(0))
+	qt.Assert(t, report.RejectedVotes, qt.HasLen, 4)
+	qt.Assert(t, report.Warnings, qt.HasLen, 2)
+	qt.Assert(t, report.Weight.String(), qt.Equals, "3")
+	qt.Assert(t, questionResults(results([][]int{{2, 0, 1}, {1, 1, 1}}).Votes),
+		qt.DeepEquals, report.Results)
+	qt.Assert(t, report.OnChainResults, qt.HasLen, 1)
+	qt.Assert(t, report.ResultsMatch, qt.IsTrue)
+
+	// a second published result which does not match
+	qt.Assert(t, auditor.AddTx(9, 0, 0, 0, testSetProcessTx(t, oracle, &models.SetProcessTx{
+		Txtype:    models.TxType_SET_PROCESS_RESULTS,
+		ProcessId: electionID,
+		Results:   results([][]int{{3, 0, 0}, {1, 1, 1}}),
+	})), qt.IsNil)
+	report, err = auditor.Report()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, report.OnChainResults, qt.HasLen, 2)
+	qt.Assert(t, report.OnChainResults[0].Match, qt.IsTrue)
+	qt.Assert(t, report.OnChainResults[1].Match, qt.IsFalse)
+	qt.Assert(t, report.ResultsMatch, qt.IsFalse)
+	qt.Assert(t, report.RejectedVotes, qt.HasLen, 4)
+
+	// the signed report can be verified
+	signer := ethereum.NewSignKeys()
+	qt.Assert(t, signer.Generate(), qt.IsNil)
+	signed, err := report.Sign(signer)
+	qt.Assert(t, err, qt.IsNil)
+	verified, err := signed.Verify()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, verified.ElectionID, qt.DeepEquals, report.ElectionID)
+	qt.Assert(t, verified.ResultsMatch, qt.IsFalse)
+
+	signed.Report = append([]byte{}, signed.Report...)
+	signed.Report[len(signed.Report)-2] = ' '
+	_, err = signed.Verify()
+	qt.Assert(t, err, qt.ErrorMatches, "report signer mismatch.*")
+}
+
+func TestAuditorDelegations(t *testing.T) {
+	organization := ethereum.NewSignKeys()
+	qt.Assert(t, organization.Generate(), qt.IsNil)
+	voters := util.CreateEthRandomKeysBatch(5)
+	// the last voter is not part of the census
+	root, proofs := testCensus(t, voters[:4])
+
+	process := &models.Process{
+		EntityId:     organization.Address().Bytes(),
+		EnvelopeType: &models.EnvelopeType{},
+		Mode:         &models.ProcessMode{AutoStart: true},
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 1, MaxValue: 2},
+		Status:       models.ProcessStatus_READY,
+		CensusRoot:   root,
+		CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+		BlockCount:   10,
+	}
+	pid := new(processid.ProcessID)
+	pid.SetChainID(testChainID)
+	pid.SetAddr(organization.Address())
+	qt.Assert(t, pid.SetEnvelopeType(process.EnvelopeType), qt.IsNil)
+	qt.Assert(t, pid.SetCensusOrigin(process.CensusOrigin), qt.IsNil)
+	electionID := pid.Marshal()
+
+	delegate := func(from, to int) []byte {
+		return testDelegationTx(t, voters[from], electionID, proofs[from], voters[to], proofs[to%4])
+	}
+	txs := []struct {
+		height uint32
+		tx     []byte
+	}{
+		{1, testSignTx(t, organization, &models.Tx{Payload: &models.Tx_NewProcess{
+			NewProcess: &models.NewProcessTx{Txtype: models.TxType_NEW_PROCESS, Process: process},
+		}})},
+		// the delegation chain 2 -> 1 -> 0 cannot be closed
+		{2, delegate(1, 0)},
+		{2, delegate(2, 1)},
+		{2, delegate(0, 2)},
+		// the delegate votes with the weight of the chain
+		{3, testVoteTx(t, voters[0], electionID, proofs[0], nil, []int{1})},
+		// voters cannot delegate, and delegates must belong to the census
+		{3, delegate(0, 3)},
+		{3, delegate(3, 4)},
+		// a delegator overrides the delegation by voting, keeping the weight
+		// delegated to it
+		{4, testVoteTx(t, voters[1], electionID, proofs[1], nil, []int{2})},
+	}
+
+	auditor, err := New(testChainID, electionID)
+	qt.Assert(t, err, qt.IsNil)
+	for i, tx := range txs {
+		qt.Assert(t, auditor.AddTx(tx.height, 0, int32(i), 0, tx.tx), qt.IsNil)
+	}
+	report, err := auditor.Report()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, report.Envelopes, qt.Equals, uint64(7))
+	qt.Assert(t, report.Delegations, qt.Equals, uint64(2))
+	qt.Assert(t, report.InvalidVotes, qt.Equals, uint64(3))
+	qt.Assert(t, report.ValidVotes, qt.Equals, uint64(2))
+	qt.Assert(t, report.RejectedVotes, qt.HasLen, 3)
+	qt.Assert(t, report.RejectedVotes[0].Reason, qt.Matches, ".*vote delegation cycle.*")
+	qt.Assert(t, report.RejectedVotes[1].Reason, qt.Matches, ".*already voted.*")
+	qt.Assert(t, report.RejectedVotes[2].Reason, qt.Matches, ".*not a census member.*")
+	qt.Assert(t, report.Weight.String(), qt.Equals, "3")
+	qt.Assert(t, report.Results, qt.DeepEquals, questionResults([]*models.QuestionResult{{
+		Question: [][]byte{{}, big.NewInt(1).Bytes(), big.NewInt(2).Bytes()},
+	}}))
+}
+
+func TestAuditorTimeBounds(t *testing.T) {
+	organization := ethereum.NewSignKeys()
+	qt.Assert(t, organization.Generate(), qt.IsNil)
+	voters := util.CreateEthRandomKeysBatch(3)
+	root, proofs := testCensus(t, voters)
+
+	process := &models.Process{
+		EntityId:     organization.Address().Bytes(),
+		EnvelopeType: &models.EnvelopeType{},
+		Mode:         &models.ProcessMode{AutoStart: true},
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 1, MaxValue: 2},
+		Status:       models.ProcessStatus_READY,
+		CensusRoot:   root,
+		CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+		BlockCount:   2,
+	}
+	state.SetProcessTimeBounds(process.Mode, time.Unix(1000, 0), time.Unix(2000, 0))
+	pid := new(processid.ProcessID)
+	pid.SetChainID(testChainID)
+	pid.SetAddr(organization.Address())
+	qt.Assert(t, pid.SetEnvelopeType(process.EnvelopeType), qt.IsNil)
+	qt.Assert(t, pid.SetCensusOrigin(process.CensusOrigin), qt.IsNil)
+	electionID := pid.Marshal()
+
+	txs := []struct {
+		height uint32
+		time   int64
+		tx     []byte
+	}{
+		{1, 900, testSignTx(t, organization, &models.Tx{Payload: &models.Tx_NewProcess{
+			NewProcess: &models.NewProcessTx{Txtype: models.TxType_NEW_PROCESS, Process: process},
+		}})},
+		// within the block range, but before the start time
+		{2, 999, testVoteTx(t, voters[0], electionID, proofs[0], nil, []int{1})},
+		// after the estimated end block, but within the time bounds
+		{8, 1500, testVoteTx(t, voters[1], electionID, proofs[1], nil, []int{2})},
+		{9, 2000, testVoteTx(t, voters[2], electionID, proofs[2], nil, []int{1})},
+	}
+
+	auditor, err := New(testChainID, electionID)
+	qt.Assert(t, err, qt.IsNil)
+	for i, tx := range txs {
+		qt.Assert(t, auditor.AddTx(tx.height, tx.time, int32(i), 0, tx.tx), qt.IsNil)
+	}
+	report, err := auditor.Report()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, report.ValidVotes, qt.Equals, uint64(1))
+	qt.Assert(t, report.InvalidVotes, qt.Equals, uint64(2))
+	qt.Assert(t, report.RejectedVotes, qt.HasLen, 2)
+	qt.Assert(t, report.RejectedVotes[0].Reason, qt.Matches, ".*starts at.*")
+	qt.Assert(t, report.RejectedVotes[1].Reason, qt.Matches, ".*finished at.*")
+	qt.Assert(t, report.Warnings, qt.HasLen, 0)
+
+	// without block times the voting period cannot be verified
+	auditor, err = New(testChainID, electionID)
+	qt.Assert(t, err, qt.IsNil)
+	for i, tx := range txs {
+		qt.Assert(t, auditor.AddTx(tx.height, 0, int32(i), 0, tx.tx), qt.IsNil)
+	}
+	report, err = auditor.Report()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, report.ValidVotes, qt.Equals, uint64(3))
+	qt.Assert(t, report.Warnings, qt.HasLen, 1)
+}
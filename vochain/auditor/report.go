@@ -0,0 +1,137 @@
+package auditor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/types"
+)
+
+// Report is the result of an election audit.
+type Report struct {
+	ChainID        string         `json:"chainId"`
+	ElectionID     types.HexBytes `json:"electionId"`
+	OrganizationID types.HexBytes `json:"organizationId"`
+	CreationHeight uint32         `json:"creationHeight"`
+	StartBlock     uint32         `json:"startBlock"`
+	EndBlock       uint32         `json:"endBlock"`
+	Status         string         `json:"status"`
+	CensusOrigin   string         `json:"censusOrigin"`
+	CensusRoot     types.HexBytes `json:"censusRoot"`
+	Encrypted      bool           `json:"encrypted"`
+
+	// FirstHeight and LastHeight are the block range of the audited
+	// transactions, and Transactions their number.  FailedTransactions is
+	// the number of them that failed on-chain, which are not applied.
+	FirstHeight        uint32 `json:"firstHeight"`
+	LastHeight         uint32 `json:"lastHeight"`
+	Transactions       uint64 `json:"transactions"`
+	FailedTransactions uint64 `json:"failedTransactions"`
+
+	// Envelopes is the number of vote envelopes found for the election.
+	Envelopes uint64 `json:"envelopes"`
+	// InvalidVotes is the number of vote envelopes that failed verification.
+	InvalidVotes uint64 `json:"invalidVotes"`
+	// Overwrites is the number of valid vote envelopes that overwrote a
+	// previous vote of the same voter.
+	Overwrites uint64 `json:"overwrites"`
+	// Delegations is the number of valid vote envelopes that delegated the
+	// vote of a census member to another one.  The delegated weight is added
+	// to the vote of the delegate, unless the delegator votes too.
+	Delegations uint64 `json:"delegations"`
+	// ValidVotes is the number of counted votes, one per voter.
+	ValidVotes uint64 `json:"validVotes"`
+	// UncountedVotes is the number of valid votes which cannot be counted,
+	// because they cannot be decrypted or do not follow the ballot protocol.
+	UncountedVotes uint64          `json:"uncountedVotes"`
+	RejectedVotes  []*RejectedVote `json:"rejectedVotes,omitempty"`
+
+	Weight  *types.BigInt     `json:"weight"`
+	Results [][]*types.BigInt `json:"results"`
+	// OnChainResults are the results published on-chain for the election.
+	OnChainResults []*OnChainResults `json:"onChainResults"`
+	// ResultsMatch is true if results have been published on-chain and all
+	// of them match the computed results.
+	ResultsMatch bool     `json:"resultsMatch"`
+	Warnings     []string `json:"warnings,omitempty"`
+}
+
+// RejectedVote is a vote envelope that is not counted on the audited results.
+type RejectedVote struct {
+	Height    uint32         `json:"height"`
+	TxIndex   int32          `json:"txIndex"`
+	Nullifier types.HexBytes `json:"nullifier,omitempty"`
+	Reason    string         `json:"reason"`
+}
+
+// OnChainResults are election results published on-chain.
+type OnChainResults struct {
+	Height  uint32            `json:"height"`
+	TxIndex int32             `json:"txIndex"`
+	Signer  types.HexBytes    `json:"signer"`
+	Oracle  types.HexBytes    `json:"oracle,omitempty"`
+	Votes   [][]*types.BigInt `json:"votes"`
+	Match   bool              `json:"match"`
+}
+
+// reject adds a rejected vote to the report, up to maxReportedVotes.
+func (r *Report) reject(v *RejectedVote) {
+	if len(r.RejectedVotes) < maxReportedVotes {
+		r.RejectedVotes = append(r.RejectedVotes, v)
+	}
+}
+
+// SignedReport is an audit report signed by the auditor.  The signature is
+// computed over the JSON encoded report, as found on the Report field.
+type SignedReport struct {
+	Report    json.RawMessage `json:"report"`
+	Signature types.HexBytes  `json:"signature"`
+	Signer    types.HexBytes  `json:"signer"`
+}
+
+// Sign encodes and signs the report with the given key.
+func (r *Report) Sign(signer *ethereum.SignKeys) (*SignedReport, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := signer.SignEthereum(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot sign report: %w", err)
+	}
+	return &SignedReport{
+		Report:    data,
+		Signature: signature,
+		Signer:    signer.Address().Bytes(),
+	}, nil
+}
+
+// Verify checks the signature of the report and returns the decoded report.
+func (s *SignedReport) Verify() (*Report, error) {
+	addr, err := ethereum.AddrFromSignature(s.Report, append([]byte{}, s.Signature...))
+	if err != nil {
+		return nil, fmt.Errorf("cannot recover report signer: %w", err)
+	}
+	if !bytes.Equal(addr.Bytes(), s.Signer) {
+		return nil, fmt.Errorf("report signer mismatch, signed by %s", addr.Hex())
+	}
+	r := &Report{}
+	if err := json.Unmarshal(s.Report, r); err != nil {
+		return nil, fmt.Errorf("cannot decode report: %w", err)
+	}
+	return r, nil
+}
+
+// sortedNullifiers returns the nullifiers of the verified votes, sorted so the
+// rejected votes are always reported in the same order.
+func (a *Auditor) sortedNullifiers() []string {
+	nullifiers := make([]string, 0, len(a.votes))
+	for n := range a.votes {
+		nullifiers = append(nullifiers, n)
+	}
+	sort.Strings(nullifiers)
+	return nullifiers
+}
@@ -0,0 +1,149 @@
+package vochain
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestBatchTx(t *testing.T) {
+	// keys: [oracle, entity, delegate, treasurer, random]
+	app, keys := createTestBaseApplicationAndAccounts(t, 10)
+	org := &ethereum.SignKeys{}
+	qt.Assert(t, org.Generate(), qt.IsNil)
+
+	faucetPkg, err := GenerateFaucetPackage(keys[4], org.Address(), 1000)
+	qt.Assert(t, err, qt.IsNil)
+	uri := infoURI
+	censusURI := ipfsUrl
+	process := &models.Process{
+		StartBlock:   0,
+		EnvelopeType: &models.EnvelopeType{},
+		Mode:         &models.ProcessMode{Interruptible: true},
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 16, MaxValue: 16},
+		Status:       models.ProcessStatus_READY,
+		EntityId:     org.Address().Bytes(),
+		CensusRoot:   util.RandomBytes(32),
+		CensusURI:    &censusURI,
+		CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+		BlockCount:   1024,
+	}
+
+	// the nonces of the batch must be consecutive
+	qt.Assert(t, testBatchTx(t, app, org,
+		&models.Tx{Payload: &models.Tx_SetAccount{SetAccount: &models.SetAccountTx{
+			Txtype:        models.TxType_CREATE_ACCOUNT,
+			FaucetPackage: faucetPkg,
+		}}},
+		testBatchSetAccountInfoTx(0, uri),
+		testBatchNewProcessTx(2, process),
+	), qt.ErrorMatches, "checkTx failed: .*batch tx 2: .*invalid account nonce.*")
+
+	// onboard the organization: create the account, set its info and
+	// create an election
+	qt.Assert(t, testBatchTx(t, app, org,
+		&models.Tx{Payload: &models.Tx_SetAccount{SetAccount: &models.SetAccountTx{
+			Txtype:        models.TxType_CREATE_ACCOUNT,
+			FaucetPackage: faucetPkg,
+		}}},
+		testBatchSetAccountInfoTx(0, uri),
+		testBatchNewProcessTx(1, process),
+	), qt.IsNil)
+	acc, err := app.State.GetAccount(org.Address(), true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, acc, qt.IsNotNil)
+	qt.Assert(t, acc.InfoURI, qt.Equals, infoURI)
+	qt.Assert(t, acc.Nonce, qt.Equals, uint32(2))
+	qt.Assert(t, acc.ProcessIndex, qt.Equals, uint32(1))
+	qt.Assert(t, acc.Balance, qt.Equals, uint64(980))
+
+	// a failing sub-transaction discards the whole batch: the election
+	// belongs to another organization.  Every sub-transaction is checked
+	// before the batch enters the mempool, and the changes of the check are
+	// discarded.
+	wrongProcess := proto.Clone(process).(*models.Process)
+	wrongProcess.EntityId = keys[1].Address().Bytes()
+	qt.Assert(t, testBatchTx(t, app, org,
+		testBatchSetAccountInfoTx(2, infoURI2),
+		testBatchNewProcessTx(3, wrongProcess),
+	), qt.ErrorMatches, "checkTx failed: .*batch tx 1.*")
+	for _, committed := range []bool{false, true} {
+		acc, err = app.State.GetAccount(org.Address(), committed)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, acc.InfoURI, qt.Equals, infoURI)
+		qt.Assert(t, acc.Nonce, qt.Equals, uint32(2))
+		qt.Assert(t, acc.Balance, qt.Equals, uint64(980))
+	}
+
+	// if the state changes between the mempool check and the delivery, the
+	// batch still fails as a whole
+	batch, err := vochaintx.NewBatchTx(
+		testBatchSetAccountInfoTx(2, infoURI2),
+		testBatchNewProcessTx(3, process),
+	)
+	qt.Assert(t, err, qt.IsNil)
+	stx := &models.SignedTx{}
+	stx.Tx, err = proto.Marshal(batch)
+	qt.Assert(t, err, qt.IsNil)
+	stx.Signature, err = org.SignVocdoniTx(stx.Tx, app.chainID)
+	qt.Assert(t, err, qt.IsNil)
+	req, err := proto.Marshal(stx)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, app.CheckTx(abcitypes.RequestCheckTx{Tx: req}).Code, qt.Equals, uint32(0))
+	qt.Assert(t, app.State.SetTxCost(models.TxType_NEW_PROCESS, 10000), qt.IsNil)
+	qt.Assert(t, app.DeliverTx(abcitypes.RequestDeliverTx{Tx: req}).Code, qt.Not(qt.Equals), uint32(0))
+	app.Commit()
+	acc, err = app.State.GetAccount(org.Address(), true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, acc.InfoURI, qt.Equals, infoURI)
+	qt.Assert(t, acc.Nonce, qt.Equals, uint32(2))
+
+	// votes are not allowed in a batch
+	qt.Assert(t, testBatchTx(t, app, org,
+		&models.Tx{Payload: &models.Tx_Vote{Vote: &models.VoteEnvelope{}}},
+	), qt.ErrorMatches, ".*not allowed in a batch.*")
+
+	// batches can't be nested
+	inner, err := vochaintx.NewBatchTx(testBatchSetAccountInfoTx(2, infoURI))
+	qt.Assert(t, err, qt.IsNil)
+	_, err = vochaintx.NewBatchTx(inner)
+	qt.Assert(t, err, qt.ErrorMatches, "batch transaction 0 is a batch")
+}
+
+func testBatchSetAccountInfoTx(nonce uint32, uri string) *models.Tx {
+	return &models.Tx{Payload: &models.Tx_SetAccount{SetAccount: &models.SetAccountTx{
+		Txtype:  models.TxType_SET_ACCOUNT_INFO_URI,
+		Nonce:   &nonce,
+		InfoURI: &uri,
+	}}}
+}
+
+func testBatchNewProcessTx(nonce uint32, process *models.Process) *models.Tx {
+	return &models.Tx{Payload: &models.Tx_NewProcess{NewProcess: &models.NewProcessTx{
+		Txtype:  models.TxType_NEW_PROCESS,
+		Nonce:   nonce,
+		Process: process,
+	}}}
+}
+
+// testBatchTx signs a batch with the given transactions and delivers it.
+func testBatchTx(t *testing.T, app *BaseApplication, signer *ethereum.SignKeys,
+	txs ...*models.Tx) error {
+	batch, err := vochaintx.NewBatchTx(txs...)
+	qt.Assert(t, err, qt.IsNil)
+	stx := &models.SignedTx{}
+	if stx.Tx, err = proto.Marshal(batch); err != nil {
+		t.Fatal(err)
+	}
+	if stx.Signature, err = signer.SignVocdoniTx(stx.Tx, app.chainID); err != nil {
+		t.Fatal(err)
+	}
+	return testCheckTxDeliverTxCommit(t, app, stx)
+}
@@ -340,7 +340,9 @@ var Genesis = map[string]VochainGenesis{
          "Tx_SetAccountInfoURI": 10,
          "Tx_AddDelegateForAccount": 10,
          "Tx_DelDelegateForAccount": 10,
-         "Tx_CollectFaucet": 10
+         "Tx_CollectFaucet": 10,
+         "Tx_SetAccountMultisig": 10,
+         "Tx_RotateAccount": 10
        }
    }
 }
@@ -511,7 +513,9 @@ var Genesis = map[string]VochainGenesis{
          "Tx_SetAccountInfoURI": 1,
          "Tx_AddDelegateForAccount": 1,
          "Tx_DelDelegateForAccount": 1,
-         "Tx_CollectFaucet": 1
+         "Tx_CollectFaucet": 1,
+         "Tx_SetAccountMultisig": 1,
+         "Tx_RotateAccount": 1
        }
    }
 }
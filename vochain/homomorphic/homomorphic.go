@@ -0,0 +1,305 @@
+// Package homomorphic implements the homomorphic tally of encrypted elections.
+//
+// On a homomorphic election the ballots are encrypted with the joint ElGamal
+// public key of the keykeepers.  Each question of a ballot contains one
+// ciphertext for every possible value, encrypting 1 for the chosen value and
+// 0 for the rest, so the sum of the ballots gives the encrypted results.
+// Ballots include zero-knowledge proofs of being well formed, which are
+// verified by the vochain when the vote is received.
+//
+// The results are decrypted with a discrete logarithm search bounded by the
+// total weight of the votes, so only unweighted censuses are supported and the
+// total weight is the number of votes.
+//
+// Once the election is finished, instead of revealing their private keys, the
+// keykeepers publish the partial decryption of the sum (with a proof of being
+// correct) as their revealed key, so individual ballots are never decrypted.
+//
+// The keykeepers prove the knowledge of their private keys when publishing
+// their public keys, so none of them can pick its key to cancel the others on
+// the joint key.  There is no threshold: the results can only be decrypted
+// with the partial decryptions of every keykeeper which published a key, so a
+// single missing keykeeper blocks the results of the election.
+package homomorphic
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"go.vocdoni.io/dvote/crypto/elgamal"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+const (
+	// MaxCells is the maximum number of ciphertexts of a ballot, which is
+	// MaxCount * (MaxValue + 1).
+	MaxCells = 64
+	// MaxTallyWeight is the maximum weight of all the votes of an election,
+	// since the decryption requires a discrete logarithm search.
+	MaxTallyWeight = 1 << 40
+)
+
+// bigOne is the weight of every vote of a homomorphic election.
+var bigOne = big.NewInt(1)
+
+// CheckProcess checks that the election options are supported by the
+// homomorphic tally.
+func CheckProcess(process *models.Process) error {
+	et, opts := process.GetEnvelopeType(), process.GetVoteOptions()
+	if !et.GetEncryptedVotes() {
+		return fmt.Errorf("homomorphic elections require encrypted votes")
+	}
+	if et.GetUniqueValues() || et.GetCostFromWeight() || opts.GetMaxTotalCost() > 0 {
+		return fmt.Errorf("homomorphic elections do not support unique values or vote costs")
+	}
+	if opts.GetMaxCount() == 0 || opts.GetMaxValue() == 0 {
+		return fmt.Errorf("homomorphic elections require maxCount and maxValue")
+	}
+	if Cells(opts) > MaxCells {
+		return fmt.Errorf("homomorphic elections support up to %d options", MaxCells)
+	}
+	switch process.GetCensusOrigin() {
+	case models.CensusOrigin_OFF_CHAIN_TREE, models.CensusOrigin_OFF_CHAIN_CA:
+	default:
+		return fmt.Errorf("homomorphic elections do not support the weighted census origin %s",
+			process.GetCensusOrigin())
+	}
+	return nil
+}
+
+// CheckVoteWeight checks the weight of a vote, given by its census proof, is
+// supported by the homomorphic tally.  Since the census origin of the election
+// is not weighted, every vote must have a weight of one, which keeps the total
+// weight bounded by the number of votes.
+func CheckVoteWeight(weight *big.Int) error {
+	if weight == nil || weight.Cmp(bigOne) != 0 {
+		return fmt.Errorf("homomorphic elections only support votes with weight 1, got %s", weight)
+	}
+	return nil
+}
+
+// PublicKey returns the joint public key of the keykeepers of the election,
+// whose proofs were verified by VerifyKey when they were added.
+func PublicKey(process *models.Process) (*elgamal.PublicKey, error) {
+	keys := []*elgamal.PublicKey{}
+	for i, key := range process.GetEncryptionPublicKeys() {
+		if key == "" {
+			continue
+		}
+		pk, err := decodePublicKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %d: %w", i, err)
+		}
+		keys = append(keys, pk)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no encryption keys available")
+	}
+	return elgamal.JointPublicKey(keys...), nil
+}
+
+// keyContext binds the proofs of the encryption keys to the election and the
+// key index.
+func keyContext(processID []byte, keyIndex int) []byte {
+	return append(append([]byte("key"), processID...), byte(keyIndex))
+}
+
+// ProveKey returns the proof of knowledge of the private key with the given
+// key index, which is published by the keykeeper along with its public key.
+func ProveKey(processID []byte, keyIndex int, k *elgamal.PrivateKey) ([]byte, error) {
+	proof, err := elgamal.ProveKey(nil, keyContext(processID, keyIndex), k)
+	if err != nil {
+		return nil, err
+	}
+	return proof.Bytes(), nil
+}
+
+// VerifyKey verifies the public key with the given key index and the proof of
+// knowledge of its private key, before it is added to the joint key.
+func VerifyKey(processID []byte, keyIndex int, pubKey, proof []byte) error {
+	pk, err := elgamal.DecodePublicKey(pubKey)
+	if err != nil {
+		return err
+	}
+	p, err := elgamal.DecodeKeyProof(proof)
+	if err != nil {
+		return err
+	}
+	if !p.Verify(keyContext(processID, keyIndex), pk) {
+		return fmt.Errorf("invalid proof of the private key")
+	}
+	return nil
+}
+
+// Cell is the encryption of 0 or 1 for a value of a question.
+type Cell struct {
+	Ciphertext types.HexBytes `json:"ciphertext"`
+	Proof      types.HexBytes `json:"proof"`
+}
+
+// Ballot is a homomorphic vote package.
+type Ballot struct {
+	// Questions contains the cells of each question, one for each value.
+	Questions [][]*Cell `json:"questions"`
+	// SumProofs prove that the sum of the cells of each question is the
+	// encryption of 1.
+	SumProofs []types.HexBytes `json:"sumProofs"`
+}
+
+// ballotContext binds the ballot proofs to the election and the voter.
+func ballotContext(processID, nullifier []byte) []byte {
+	return append(append([]byte("ballot"), processID...), nullifier...)
+}
+
+// NewBallot builds the ballot with the given votes, the chosen value for each
+// question, encrypted with the joint public key of the election.
+func NewBallot(process *models.Process, nullifier []byte, votes []int) (*Ballot, error) {
+	opts := process.GetVoteOptions()
+	if len(votes) != int(opts.GetMaxCount()) {
+		return nil, fmt.Errorf("expected %d votes, got %d", opts.GetMaxCount(), len(votes))
+	}
+	pk, err := PublicKey(process)
+	if err != nil {
+		return nil, err
+	}
+	context := ballotContext(process.ProcessId, nullifier)
+	ballot := &Ballot{}
+	for _, vote := range votes {
+		if vote < 0 || vote > int(opts.GetMaxValue()) {
+			return nil, fmt.Errorf("vote value %d out of range", vote)
+		}
+		question := []*Cell{}
+		sum := elgamal.NewCiphertext()
+		rsum := new(big.Int)
+		for value := 0; value <= int(opts.GetMaxValue()); value++ {
+			bit := value == vote
+			m := big.NewInt(0)
+			if bit {
+				m.SetUint64(1)
+			}
+			c, r, err := elgamal.Encrypt(nil, pk, m)
+			if err != nil {
+				return nil, err
+			}
+			proof, err := elgamal.ProveBit(nil, context, pk, c, bit, r)
+			if err != nil {
+				return nil, err
+			}
+			question = append(question, &Cell{Ciphertext: c.Bytes(), Proof: proof.Bytes()})
+			sum.Add(sum, c)
+			rsum.Add(rsum, r)
+		}
+		proof, err := elgamal.ProveEncrypts(nil, context, pk, sum, big.NewInt(1), rsum)
+		if err != nil {
+			return nil, err
+		}
+		ballot.Questions = append(ballot.Questions, question)
+		ballot.SumProofs = append(ballot.SumProofs, proof.Bytes())
+	}
+	return ballot, nil
+}
+
+// Marshal encodes the ballot as a vote package.
+func (b *Ballot) Marshal() ([]byte, error) {
+	return json.Marshal(b)
+}
+
+// UnmarshalBallot decodes a ballot from a vote package, checking its size
+// matches with the election options.
+func UnmarshalBallot(votePackage []byte, opts *models.ProcessVoteOptions) (*Ballot, error) {
+	ballot := &Ballot{}
+	if err := json.Unmarshal(votePackage, ballot); err != nil {
+		return nil, fmt.Errorf("cannot decode ballot: %w", err)
+	}
+	if len(ballot.Questions) != int(opts.GetMaxCount()) ||
+		len(ballot.SumProofs) != len(ballot.Questions) {
+		return nil, fmt.Errorf("invalid number of questions")
+	}
+	for _, q := range ballot.Questions {
+		if len(q) != int(opts.GetMaxValue())+1 {
+			return nil, fmt.Errorf("invalid number of values")
+		}
+		for _, cell := range q {
+			if cell == nil {
+				return nil, fmt.Errorf("missing ballot cell")
+			}
+		}
+	}
+	return ballot, nil
+}
+
+// ciphertexts decodes the ciphertexts of the ballot.
+func (b *Ballot) ciphertexts() ([][]*elgamal.Ciphertext, error) {
+	cts := [][]*elgamal.Ciphertext{}
+	for _, q := range b.Questions {
+		question := []*elgamal.Ciphertext{}
+		for _, cell := range q {
+			c, err := elgamal.DecodeCiphertext(cell.Ciphertext)
+			if err != nil {
+				return nil, err
+			}
+			question = append(question, c)
+		}
+		cts = append(cts, question)
+	}
+	return cts, nil
+}
+
+// Verify verifies the proofs of the ballot, which must be encrypted with the
+// joint public key of the election and cast by the voter with the nullifier.
+func (b *Ballot) Verify(process *models.Process, nullifier []byte) error {
+	pk, err := PublicKey(process)
+	if err != nil {
+		return err
+	}
+	cts, err := b.ciphertexts()
+	if err != nil {
+		return err
+	}
+	context := ballotContext(process.ProcessId, nullifier)
+	for i, q := range b.Questions {
+		sum := elgamal.NewCiphertext()
+		for j, cell := range q {
+			proof, err := elgamal.DecodeBitProof(cell.Proof)
+			if err != nil {
+				return err
+			}
+			if !proof.Verify(context, pk, cts[i][j]) {
+				return fmt.Errorf("invalid proof for question %d value %d", i, j)
+			}
+			sum.Add(sum, cts[i][j])
+		}
+		proof, err := elgamal.DecodeDLEQProof(b.SumProofs[i])
+		if err != nil {
+			return err
+		}
+		if !proof.VerifyEncrypts(context, pk, sum, big.NewInt(1)) {
+			return fmt.Errorf("invalid sum proof for question %d", i)
+		}
+	}
+	return nil
+}
+
+// VerifyVotePackage decodes and verifies the ballot of a vote package.
+func VerifyVotePackage(process *models.Process, votePackage, nullifier []byte) error {
+	ballot, err := UnmarshalBallot(votePackage, process.GetVoteOptions())
+	if err != nil {
+		return err
+	}
+	return ballot.Verify(process, nullifier)
+}
+
+// Cells returns the number of ciphertexts of a ballot for the election options.
+func Cells(opts *models.ProcessVoteOptions) int {
+	return int(opts.GetMaxCount()) * (int(opts.GetMaxValue()) + 1)
+}
+
+func decodePublicKey(key string) (*elgamal.PublicKey, error) {
+	data, err := decodeHex(key)
+	if err != nil {
+		return nil, err
+	}
+	return elgamal.DecodePublicKey(data)
+}
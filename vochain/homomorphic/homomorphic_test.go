@@ -0,0 +1,121 @@
+package homomorphic
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"go.vocdoni.io/dvote/crypto/elgamal"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestEnvelopeType(t *testing.T) {
+	et := &models.EnvelopeType{Anonymous: true, EncryptedVotes: true, Homomorphic: true}
+
+	// the flag is kept when the process is encoded and decoded
+	data, err := proto.Marshal(&models.Process{EnvelopeType: et})
+	qt.Assert(t, err, qt.IsNil)
+	p := &models.Process{}
+	qt.Assert(t, proto.Unmarshal(data, p), qt.IsNil)
+	qt.Assert(t, p.EnvelopeType.GetHomomorphic(), qt.IsTrue)
+	qt.Assert(t, p.EnvelopeType.Anonymous, qt.IsTrue)
+
+	qt.Assert(t, CheckProcess(&models.Process{
+		EnvelopeType: &models.EnvelopeType{Homomorphic: true},
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 2, MaxValue: 3},
+	}), qt.IsNotNil)
+	qt.Assert(t, CheckProcess(&models.Process{
+		EnvelopeType: et,
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 2, MaxValue: 3},
+		CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+	}), qt.IsNil)
+	// weighted census origins are not supported
+	qt.Assert(t, CheckProcess(&models.Process{
+		EnvelopeType: et,
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 2, MaxValue: 3},
+		CensusOrigin: models.CensusOrigin_ERC20,
+	}), qt.ErrorMatches, ".*weighted census origin ERC20")
+	qt.Assert(t, CheckVoteWeight(big.NewInt(1)), qt.IsNil)
+	qt.Assert(t, CheckVoteWeight(big.NewInt(2)), qt.IsNotNil)
+	qt.Assert(t, CheckVoteWeight(nil), qt.IsNotNil)
+	qt.Assert(t, CheckProcess(&models.Process{
+		EnvelopeType: et,
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 2, MaxValue: 3, MaxTotalCost: 4},
+	}), qt.IsNotNil)
+	qt.Assert(t, CheckProcess(&models.Process{
+		EnvelopeType: et,
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 10, MaxValue: 10},
+	}), qt.IsNotNil)
+}
+
+func TestTally(t *testing.T) {
+	keys := []*elgamal.PrivateKey{}
+	process := &models.Process{
+		ProcessId:             []byte("process"),
+		EnvelopeType:          &models.EnvelopeType{EncryptedVotes: true, Homomorphic: true},
+		VoteOptions:           &models.ProcessVoteOptions{MaxCount: 2, MaxValue: 2},
+		EncryptionPublicKeys:  make([]string, 4),
+		EncryptionPrivateKeys: make([]string, 4),
+	}
+	for _, i := range []int{0, 2} {
+		k, err := elgamal.GenerateKey(nil)
+		qt.Assert(t, err, qt.IsNil)
+		keys = append(keys, k)
+		process.EncryptionPublicKeys[i] = fmt.Sprintf("%x", k.Public().Bytes())
+	}
+
+	tally := NewTally(process.VoteOptions)
+	for i, vote := range []struct {
+		values []int
+		weight int64
+	}{{[]int{0, 1}, 1}, {[]int{2, 1}, 3}, {[]int{2, 0}, 2}} {
+		nullifier := []byte{byte(i)}
+		ballot, err := NewBallot(process, nullifier, vote.values)
+		qt.Assert(t, err, qt.IsNil)
+		vp, err := ballot.Marshal()
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, VerifyVotePackage(process, vp, nullifier), qt.IsNil)
+		// the ballot cannot be reused by other voter
+		qt.Assert(t, VerifyVotePackage(process, vp, []byte("other")), qt.IsNotNil)
+		qt.Assert(t, tally.AddVote(&models.StateDBVote{
+			VotePackage: vp,
+			Weight:      big.NewInt(vote.weight).Bytes(),
+		}), qt.IsNil)
+	}
+	qt.Assert(t, tally.Votes(), qt.Equals, uint64(3))
+	qt.Assert(t, tally.Weight().Int64(), qt.Equals, int64(6))
+
+	// a ballot with two chosen values is rejected
+	ballot, err := NewBallot(process, []byte("a"), []int{0, 0})
+	qt.Assert(t, err, qt.IsNil)
+	ballot2, err := NewBallot(process, []byte("a"), []int{1, 1})
+	qt.Assert(t, err, qt.IsNil)
+	ballot.Questions[0][1] = ballot2.Questions[0][1]
+	qt.Assert(t, ballot.Verify(process, []byte("a")), qt.ErrorMatches, "invalid sum proof.*")
+
+	// the results require all the partial decryptions
+	_, err = tally.Results(process)
+	qt.Assert(t, err, qt.ErrorMatches, "partial decryption 0 not revealed")
+	for i, index := range []int{0, 2} {
+		data, err := tally.PartialDecryption(process.ProcessId, index, keys[i])
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, tally.VerifyPartialDecryption(process, index, data), qt.IsNil)
+		process.EncryptionPrivateKeys[index] = fmt.Sprintf("%x", data)
+	}
+	results, err := tally.Results(process)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, fmt.Sprint(results), qt.Equals, "[[1 0 5] [2 4 0]]")
+
+	// a partial decryption from the wrong key is rejected
+	data, err := tally.PartialDecryption(process.ProcessId, 0, keys[1])
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, tally.VerifyPartialDecryption(process, 0, data),
+		qt.ErrorMatches, "invalid partial decryption 0 proof")
+	qt.Assert(t, tally.VerifyPartialDecryption(process, 1, data),
+		qt.ErrorMatches, "encryption key 1 does not exist")
+	process.EncryptionPrivateKeys[0] = fmt.Sprintf("%x", data)
+	_, err = tally.Results(process)
+	qt.Assert(t, err, qt.ErrorMatches, "invalid partial decryption 0 proof")
+}
@@ -0,0 +1,222 @@
+package homomorphic
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/iden3/go-iden3-crypto/babyjub"
+	"go.vocdoni.io/dvote/crypto/elgamal"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// PartialDecryptionSize is the size of the partial decryption of each cell of
+// the tally: the decryption share followed by its proof.
+const PartialDecryptionSize = elgamal.PointSize + elgamal.DLEQProofSize
+
+// Tally is the encrypted sum of the weighted ballots of an election.
+// It is safe for concurrent use.
+type Tally struct {
+	opts   *models.ProcessVoteOptions
+	cells  [][]*elgamal.Ciphertext
+	weight *big.Int
+	votes  uint64
+	lock   sync.Mutex
+	// table is the discrete logarithm table of the last decrypted weight,
+	// shared by every cell of the results.
+	table *elgamal.DiscreteLogTable
+}
+
+// NewTally returns an empty tally for the election options.
+func NewTally(opts *models.ProcessVoteOptions) *Tally {
+	t := &Tally{opts: opts, weight: new(big.Int)}
+	for i := 0; i < int(opts.GetMaxCount()); i++ {
+		question := []*elgamal.Ciphertext{}
+		for j := 0; j <= int(opts.GetMaxValue()); j++ {
+			question = append(question, elgamal.NewCiphertext())
+		}
+		t.cells = append(t.cells, question)
+	}
+	return t
+}
+
+// AddVote adds the ballot of a vote stored on the state to the tally,
+// multiplied by the vote weight.  The ballot proofs are not verified, since
+// the vote was already verified by the vochain.
+func (t *Tally) AddVote(vote *models.StateDBVote) error {
+	ballot, err := UnmarshalBallot(vote.GetVotePackage(), t.opts)
+	if err != nil {
+		return err
+	}
+	cts, err := ballot.ciphertexts()
+	if err != nil {
+		return err
+	}
+	weight := new(big.Int).SetBytes(vote.GetWeight())
+	if weight.Cmp(big.NewInt(1)) != 0 {
+		for _, q := range cts {
+			for _, c := range q {
+				c.Mul(weight, c)
+			}
+		}
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for i, q := range cts {
+		for j, c := range q {
+			t.cells[i][j].Add(t.cells[i][j], c)
+		}
+	}
+	t.weight.Add(t.weight, weight)
+	t.votes++
+	return nil
+}
+
+// Votes returns the number of votes added to the tally.
+func (t *Tally) Votes() uint64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.votes
+}
+
+// Weight returns the sum of the weights of the votes added to the tally.
+func (t *Tally) Weight() *big.Int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return new(big.Int).Set(t.weight)
+}
+
+// decryptionContext binds the partial decryption proofs to the election and
+// the key index.
+func decryptionContext(processID []byte, keyIndex int) []byte {
+	return append(append([]byte("decryption"), processID...), byte(keyIndex))
+}
+
+// PartialDecryption returns the partial decryption of the tally with the
+// private key of the given key index, which is revealed by the keykeeper
+// instead of the private key.
+func (t *Tally) PartialDecryption(processID []byte, keyIndex int,
+	k *elgamal.PrivateKey) ([]byte, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	context := decryptionContext(processID, keyIndex)
+	data := []byte{}
+	for _, q := range t.cells {
+		for _, c := range q {
+			d := k.PartialDecrypt(c)
+			proof, err := elgamal.ProvePartialDecryption(nil, context, k, c, d)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, elgamal.EncodePoint(d)...)
+			data = append(data, proof.Bytes()...)
+		}
+	}
+	return data, nil
+}
+
+// VerifyPartialDecryption verifies the partial decryption of the tally
+// revealed by the keykeeper with the given key index, against its public key.
+func (t *Tally) VerifyPartialDecryption(process *models.Process, keyIndex int,
+	data []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	_, err := t.partialDecryption(process, keyIndex, data)
+	return err
+}
+
+// partialDecryption decodes and verifies a partial decryption of the tally,
+// returning the decryption share of each cell.
+func (t *Tally) partialDecryption(process *models.Process, keyIndex int,
+	data []byte) ([][]*babyjub.Point, error) {
+	if keyIndex < 0 || keyIndex >= len(process.GetEncryptionPublicKeys()) ||
+		process.EncryptionPublicKeys[keyIndex] == "" {
+		return nil, fmt.Errorf("encryption key %d does not exist", keyIndex)
+	}
+	pk, err := decodePublicKey(process.EncryptionPublicKeys[keyIndex])
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key %d: %w", keyIndex, err)
+	}
+	if len(data) != Cells(t.opts)*PartialDecryptionSize {
+		return nil, fmt.Errorf("invalid partial decryption %d size", keyIndex)
+	}
+	context := decryptionContext(process.ProcessId, keyIndex)
+	shares := make([][]*babyjub.Point, len(t.cells))
+	for i, q := range t.cells {
+		for _, c := range q {
+			d, err := elgamal.DecodePoint(data[:elgamal.PointSize])
+			if err != nil {
+				return nil, fmt.Errorf("invalid partial decryption %d: %w", keyIndex, err)
+			}
+			proof, err := elgamal.DecodeDLEQProof(data[elgamal.PointSize:PartialDecryptionSize])
+			if err != nil {
+				return nil, fmt.Errorf("invalid partial decryption %d: %w", keyIndex, err)
+			}
+			if !proof.VerifyPartialDecryption(context, pk, c, d) {
+				return nil, fmt.Errorf("invalid partial decryption %d proof", keyIndex)
+			}
+			shares[i] = append(shares[i], d)
+			data = data[PartialDecryptionSize:]
+		}
+	}
+	return shares, nil
+}
+
+// Results decrypts the tally using the partial decryptions revealed by the
+// keykeepers of the election, which are verified against their public keys.
+func (t *Tally) Results(process *models.Process) ([][]*big.Int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.weight.Cmp(big.NewInt(MaxTallyWeight)) > 0 {
+		return nil, fmt.Errorf("total weight %s too big for the homomorphic tally", t.weight)
+	}
+	// shares[i][j] contains the partial decryptions of the cell j of question i
+	shares := make([][][]*babyjub.Point, len(t.cells))
+	for i := range t.cells {
+		shares[i] = make([][]*babyjub.Point, len(t.cells[i]))
+	}
+	for index, key := range process.GetEncryptionPublicKeys() {
+		if key == "" {
+			continue
+		}
+		if index >= len(process.GetEncryptionPrivateKeys()) ||
+			process.EncryptionPrivateKeys[index] == "" {
+			return nil, fmt.Errorf("partial decryption %d not revealed", index)
+		}
+		data, err := decodeHex(process.EncryptionPrivateKeys[index])
+		if err != nil {
+			return nil, err
+		}
+		partial, err := t.partialDecryption(process, index, data)
+		if err != nil {
+			return nil, err
+		}
+		for i, q := range partial {
+			for j, d := range q {
+				shares[i][j] = append(shares[i][j], d)
+			}
+		}
+	}
+	if t.table == nil || t.table.Max() != t.weight.Uint64() {
+		t.table = elgamal.NewDiscreteLogTable(t.weight.Uint64())
+	}
+	results := [][]*big.Int{}
+	for i, q := range t.cells {
+		question := []*big.Int{}
+		for j, c := range q {
+			m, err := t.table.Decrypt(c, shares[i][j])
+			if err != nil {
+				return nil, fmt.Errorf("cannot decrypt question %d value %d: %w", i, j, err)
+			}
+			question = append(question, new(big.Int).SetUint64(m))
+		}
+		results = append(results, question)
+	}
+	return results, nil
+}
+
+func decodeHex(s string) ([]byte, error) {
+	return hex.DecodeString(util.TrimHex(s))
+}
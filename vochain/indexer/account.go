@@ -0,0 +1,78 @@
+package indexer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+	indexerdb "go.vocdoni.io/dvote/vochain/indexer/db"
+	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
+)
+
+// newAccountRotation stores the account rotation and moves the indexed
+// processes of the organization to its new address, so both addresses
+// resolve to the same organization.
+func (idx *Indexer) newAccountRotation(r *vochaintx.AccountRotation, height uint32) error {
+	queries, ctx, cancel := idx.timeoutQueries()
+	defer cancel()
+	if _, err := queries.CreateAccountRotation(ctx, indexerdb.CreateAccountRotationParams{
+		FromAccount: r.FromAddress.Bytes(),
+		ToAccount:   r.ToAddress.Bytes(),
+		Height:      int64(height),
+		TxHash:      r.TxHash,
+	}); err != nil {
+		return err
+	}
+	if _, err := queries.UpdateProcessesEntityID(ctx, indexerdb.UpdateProcessesEntityIDParams{
+		EntityID:   r.ToAddress.Bytes(),
+		EntityID_2: r.FromAddress.Bytes(),
+	}); err != nil {
+		return fmt.Errorf("sql update processes entity: %w", err)
+	}
+	log.Debugw("new account rotation", map[string]interface{}{
+		"from": r.FromAddress.Hex(),
+		"to":   r.ToAddress.Hex(),
+	})
+	return nil
+}
+
+// ResolveAccount returns the current address of an account, following the
+// indexed account rotations.  If the account was never rotated, the same
+// address is returned.
+func (idx *Indexer) ResolveAccount(address []byte) (types.HexBytes, error) {
+	queries, ctx, cancel := idx.timeoutQueries()
+	defer cancel()
+	for {
+		rotation, err := queries.GetAccountRotation(ctx, address)
+		if errors.Is(err, sql.ErrNoRows) {
+			return address, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		address = rotation.ToAccount
+	}
+}
+
+// PreviousAccounts returns the previous addresses of an account, which was
+// rotated from them, from the most recent to the oldest.
+func (idx *Indexer) PreviousAccounts(address []byte) ([]types.HexBytes, error) {
+	queries, ctx, cancel := idx.timeoutQueries()
+	defer cancel()
+	accounts := []types.HexBytes{}
+	pending := [][]byte{address}
+	for len(pending) > 0 {
+		rotations, err := queries.GetAccountRotationsByToAccount(ctx, pending[0])
+		if err != nil {
+			return nil, err
+		}
+		pending = pending[1:]
+		for _, r := range rotations {
+			accounts = append(accounts, types.HexBytes(r.FromAccount))
+			pending = append(pending, r.FromAccount)
+		}
+	}
+	return accounts, nil
+}
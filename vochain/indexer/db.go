@@ -1,57 +1,263 @@
 package indexer
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/timshannon/badgerhold/v3"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+	indexerdb "go.vocdoni.io/dvote/vochain/indexer/db"
+	"go.vocdoni.io/dvote/vochain/indexer/indexertypes"
 )
 
 //go:generate go run github.com/kyleconroy/sqlc/cmd/sqlc@v1.16.0 generate
 
-// The string to search on the KV database error to identify a transaction conflict.
-// If the KV (currently badger) returns this error, it is considered non fatal and the
-// transaction will be retried until it works.
-// This check is made comparing string in order to avoid importing a specific KV
-// implementation.
-const kvErrorStringForRetry = "Transaction Conflict"
+// badgerholdMigratedSuffix is appended to the directory of the old badgerhold
+// database once its data is imported, so that it is only imported once.
+const badgerholdMigratedSuffix = "-badgerhold-migrated"
 
-// InitDB initializes a badgerhold db at the location given by dataDir
-func InitDB(dataDir string) (*badgerhold.Store, error) {
+// migrateBadgerhold imports the processes, results, envelopes and transactions
+// of the old badgerhold indexer database at dataDir, if any.  The records
+// already indexed on the SQL database are kept, so only those indexed before
+// the SQL database existed are imported.  The entities and the counts are not
+// imported, as they are computed from the SQL tables.
+//
+// The imported transactions keep their hash, block height and block index,
+// but not their badgerhold index: they get new sequential IDs after the ones
+// already indexed, since those cannot be renumbered.  So the transaction
+// indexes served by the API (see /chain/transactions/reference/index) do not
+// follow the block order, and the ones used with the old indexer are not
+// valid anymore; the transactions should be looked up by hash instead.
+//
+// Once imported, the badgerhold directory is renamed with the suffix
+// badgerholdMigratedSuffix, and it can be safely removed.
+func (idx *Indexer) migrateBadgerhold(dataDir string) error {
+	// badger always writes a MANIFEST file on its directory
+	if _, err := os.Stat(filepath.Join(dataDir, "MANIFEST")); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	startTime := time.Now()
+	log.Infof("migrating the badgerhold indexer database at %s", dataDir)
 	opts := badgerhold.DefaultOptions
-
-	// Note that these "With" options return a modified copy.
-	opts.Options = opts.WithCompression(0)
-	opts.Options = opts.WithBlockCacheSize(0)
-	opts.Options = opts.WithVerifyValueChecksum(false)
-	opts.Options = opts.WithDetectConflicts(true)
-
-	opts.SequenceBandwith = 10000
 	opts.Dir = dataDir
 	opts.ValueDir = dataDir
-
-	// TODO: support configurable logging
 	opts.Options = opts.WithLogger(nil)
 	opts.Logger = nil
-
-	return badgerhold.Open(opts)
+	store, err := badgerhold.Open(opts)
+	if err != nil {
+		return err
+	}
+	counts, err := idx.importBadgerhold(store)
+	if err2 := store.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(dataDir, dataDir+badgerholdMigratedSuffix); err != nil {
+		return err
+	}
+	log.Infow("migrated the badgerhold indexer database", map[string]interface{}{
+		"took":         time.Since(startTime),
+		"processes":    counts[0],
+		"envelopes":    counts[1],
+		"transactions": counts[2],
+		"oldDataDir":   dataDir + badgerholdMigratedSuffix,
+	})
+	return nil
 }
 
-func (s *Indexer) queryWithRetries(query func() error) error {
-	maxTries := 1000
-	for {
-		if err := query(); err != nil {
-			if strings.Contains(err.Error(), kvErrorStringForRetry) {
-				maxTries--
-				if maxTries == 0 {
-					return fmt.Errorf("cannot update record: max retires reached")
-				}
-				time.Sleep(time.Millisecond * 5)
-				continue
-			}
-			return fmt.Errorf("cannot update record: %w, ", err)
+// importBadgerhold imports the records of the badgerhold store missing on the
+// SQL database, on a single database transaction.  It returns the number of
+// processes, envelopes and transactions imported, in that order.
+func (idx *Indexer) importBadgerhold(store *badgerhold.Store) ([3]int, error) {
+	var counts [3]int
+	// The import may take longer than the timeout of the regular queries.
+	ctx := context.Background()
+	tx, err := idx.sqlDB.Begin()
+	if err != nil {
+		return counts, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Warnf("cannot rollback badgerhold migration transaction: %v", err)
 		}
+	}()
+	queries := indexerdb.New(tx)
+
+	results := make(map[string]*indexertypes.Results)
+	if err := store.ForEach(nil, func(r *indexertypes.Results) error {
+		results[string(r.ProcessID)] = r
 		return nil
+	}); err != nil {
+		return counts, fmt.Errorf("cannot read results: %w", err)
+	}
+	if err := store.ForEach(nil, func(p *indexertypes.Process) error {
+		if _, err := queries.GetProcessStatus(ctx, p.ID); err == nil {
+			return nil
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		counts[0]++
+		return importBadgerholdProcess(ctx, queries, p, results[string(p.ID)])
+	}); err != nil {
+		return counts, fmt.Errorf("cannot import processes: %w", err)
+	}
+
+	if err := store.ForEach(nil, func(v *indexertypes.VoteReference) error {
+		if _, err := queries.GetVoteReference(ctx, v.Nullifier); err == nil {
+			return nil
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		weight := "1"
+		if v.Weight != nil {
+			weight = v.Weight.String()
+		}
+		counts[1]++
+		// the vote packages were not kept by badgerhold
+		_, err := queries.CreateVoteReference(ctx, indexerdb.CreateVoteReferenceParams{
+			Nullifier:      v.Nullifier,
+			ProcessID:      v.ProcessID,
+			Height:         int64(v.Height),
+			Weight:         weight,
+			TxIndex:        int64(v.TxIndex),
+			VoterID:        nonNullBytes(v.VoterID),
+			OverwriteCount: int64(v.OverwriteCount),
+			CreationTime:   v.CreationTime,
+			VotePackage:    nonNullBytes(nil),
+		})
+		return err
+	}); err != nil {
+		return counts, fmt.Errorf("cannot import envelopes: %w", err)
+	}
+
+	// The transactions are keyed by their sequential index, starting at 1.
+	// Those missing get a new index after the ones already indexed, and their
+	// participants are backfilled, see BackfillTxReferences.
+	var firstID, lastID int64
+	for i := uint64(1); ; i++ {
+		txRef := &indexertypes.TxReference{}
+		if err := store.Get(i, txRef); errors.Is(err, badgerhold.ErrNotFound) {
+			break
+		} else if err != nil {
+			return counts, fmt.Errorf("cannot import transaction %d: %w", i, err)
+		}
+		if _, err := queries.GetTxReferenceByHash(ctx, txRef.Hash); err == nil {
+			continue
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return counts, err
+		}
+		counts[2]++
+		res, err := queries.CreateTxReference(ctx, indexerdb.CreateTxReferenceParams{
+			Hash:         txRef.Hash,
+			BlockHeight:  int64(txRef.BlockHeight),
+			TxBlockIndex: int64(txRef.TxBlockIndex),
+			TxType:       txRef.TxType,
+			Signer:       nonNullBytes(nil),
+			EntityID:     nonNullBytes(nil),
+			ProcessID:    nonNullBytes(nil),
+		})
+		if err != nil {
+			return counts, fmt.Errorf("cannot import transaction %d: %w", i, err)
+		}
+		if lastID, err = res.LastInsertId(); err != nil {
+			return counts, err
+		}
+		if firstID == 0 {
+			firstID = lastID
+		}
+	}
+	if firstID > 0 {
+		status, err := queries.GetTxBackfillStatus(ctx)
+		if err == nil && status.NextID < firstID {
+			firstID = status.NextID
+		} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return counts, err
+		}
+		if _, err := queries.SetTxBackfillStatus(ctx, indexerdb.SetTxBackfillStatusParams{
+			NextID: firstID,
+			LastID: lastID,
+		}); err != nil {
+			return counts, err
+		}
+	}
+	return counts, tx.Commit()
+}
+
+// importBadgerholdProcess creates the process with its results, if any.
+func importBadgerholdProcess(ctx context.Context, queries *indexerdb.Queries,
+	p *indexertypes.Process, results *indexertypes.Results) error {
+	if _, err := queries.CreateProcess(ctx, indexerdb.CreateProcessParams{
+		ID:                p.ID,
+		EntityID:          nonNullBytes(p.EntityID),
+		EntityIndex:       int64(p.EntityIndex),
+		StartBlock:        int64(p.StartBlock),
+		EndBlock:          int64(p.EndBlock),
+		ResultsHeight:     int64(p.Rheight),
+		HaveResults:       p.HaveResults,
+		CensusRoot:        nonNullBytes(p.CensusRoot),
+		RollingCensusRoot: nonNullBytes(p.RollingCensusRoot),
+		RollingCensusSize: int64(p.RollingCensusSize),
+		MaxCensusSize:     int64(p.MaxCensusSize),
+		CensusUri:         p.CensusURI,
+		CensusOrigin:      int64(p.CensusOrigin),
+		Status:            int64(p.Status),
+		Namespace:         int64(p.Namespace),
+		EnvelopePb:        encodedPb(p.Envelope),
+		ModePb:            encodedPb(p.Mode),
+		VoteOptsPb:        encodedPb(p.VoteOpts),
+		PrivateKeys:       strings.Join(p.PrivateKeys, ","),
+		PublicKeys:        strings.Join(p.PublicKeys, ","),
+		QuestionIndex:     int64(p.QuestionIndex),
+		CreationTime:      p.CreationTime,
+		SourceBlockHeight: int64(p.SourceBlockHeight),
+		SourceNetworkID:   p.SourceNetworkId,
+		Metadata:          p.Metadata,
+	}); err != nil {
+		return err
+	}
+	if results != nil {
+		weight := results.Weight
+		if weight == nil {
+			weight = new(types.BigInt)
+		}
+		if _, err := queries.UpdateProcessResults(ctx, indexerdb.UpdateProcessResultsParams{
+			ID:             p.ID,
+			Votes:          encodeVotes(results.Votes),
+			Weight:         weight.String(),
+			EnvelopeHeight: int64(results.EnvelopeHeight),
+			BlockHeight:    int64(results.BlockHeight),
+		}); err != nil {
+			return err
+		}
+		if p.FinalResults && p.HaveResults {
+			_, err := queries.SetProcessResultsReady(ctx, indexerdb.SetProcessResultsReadyParams{
+				ID:             p.ID,
+				Votes:          encodeVotes(results.Votes),
+				Weight:         weight.String(),
+				EnvelopeHeight: int64(results.EnvelopeHeight),
+				Signatures:     joinHexBytes(results.Signatures),
+				BlockHeight:    int64(results.BlockHeight),
+				RankedRounds:   encodeRankedRounds(results.RankedRounds),
+			})
+			return err
+		}
+	}
+	if p.FinalResults && !p.HaveResults {
+		// canceled processes
+		_, err := queries.SetProcessResultsCancelled(ctx, p.ID)
+		return err
 	}
+	return nil
 }
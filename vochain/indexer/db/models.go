@@ -11,6 +11,22 @@ import (
 	"go.vocdoni.io/dvote/vochain/state"
 )
 
+type AccountRotation struct {
+	FromAccount types.AccountID
+	ToAccount   types.AccountID
+	Height      int64
+	TxHash      types.Hash
+}
+
+type MetadataText struct {
+	Kind        string
+	ID          types.HexBytes
+	Language    string
+	Title       string
+	Description string
+	Questions   string
+}
+
 type Process struct {
 	ID                    types.ProcessID
 	EntityID              types.EntityID
@@ -43,6 +59,13 @@ type Process struct {
 	CreationTime          time.Time
 	SourceBlockHeight     int64
 	SourceNetworkID       string
+	ResultsRankedRounds   string
+}
+
+type RebuildStatus struct {
+	ID           int64
+	Height       int64
+	TargetHeight int64
 }
 
 type TokenTransferMeta struct {
@@ -54,21 +77,41 @@ type TokenTransferMeta struct {
 	TransferTime time.Time
 }
 
+type TxBatchReference struct {
+	TxID      int64
+	EntityID  types.EntityID
+	ProcessID types.ProcessID
+}
+
 type TxReference struct {
 	ID           int64
 	Hash         types.Hash
 	BlockHeight  int64
 	TxBlockIndex int64
 	TxType       string
+	Signer       types.AccountID
+	EntityID     types.EntityID
+	ProcessID    types.ProcessID
+}
+
+type TxReferencesBackfill struct {
+	ID     int64
+	NextID int64
+	LastID int64
 }
 
 type VoteReference struct {
-	Nullifier      types.Nullifier
-	ProcessID      types.ProcessID
-	Height         int64
-	Weight         string
-	TxIndex        int64
-	CreationTime   time.Time
-	VoterID        state.VoterID
-	OverwriteCount int64
+	Nullifier            types.Nullifier
+	ProcessID            types.ProcessID
+	Height               int64
+	Weight               string
+	TxIndex              int64
+	CreationTime         time.Time
+	VoterID              state.VoterID
+	OverwriteCount       int64
+	VotePackage          []byte
+	EncryptionKeyIndexes string
+	FirstHeight          int64
+	FirstCreationTime    time.Time
+	Answers              int64
 }
@@ -129,7 +129,7 @@ func (q *Queries) GetEntityProcessCount(ctx context.Context, entityID types.Enti
 }
 
 const getProcess = `-- name: GetProcess :one
-SELECT id, entity_id, entity_index, start_block, end_block, results_height, have_results, final_results, results_votes, results_weight, results_envelope_height, results_signatures, results_block_height, census_root, rolling_census_root, rolling_census_size, max_census_size, census_uri, metadata, census_origin, status, namespace, envelope_pb, mode_pb, vote_opts_pb, private_keys, public_keys, question_index, creation_time, source_block_height, source_network_id FROM processes
+SELECT id, entity_id, entity_index, start_block, end_block, results_height, have_results, final_results, results_votes, results_weight, results_envelope_height, results_signatures, results_block_height, census_root, rolling_census_root, rolling_census_size, max_census_size, census_uri, metadata, census_origin, status, namespace, envelope_pb, mode_pb, vote_opts_pb, private_keys, public_keys, question_index, creation_time, source_block_height, source_network_id, results_ranked_rounds FROM processes
 WHERE id = ?
 LIMIT 1
 `
@@ -169,6 +169,7 @@ func (q *Queries) GetProcess(ctx context.Context, id types.ProcessID) (Process,
 		&i.CreationTime,
 		&i.SourceBlockHeight,
 		&i.SourceNetworkID,
+		&i.ResultsRankedRounds,
 	)
 	return i, err
 }
@@ -184,6 +185,19 @@ func (q *Queries) GetProcessCount(ctx context.Context) (int64, error) {
 	return count, err
 }
 
+const getProcessEntityID = `-- name: GetProcessEntityID :one
+SELECT entity_id FROM processes
+WHERE id = ?
+LIMIT 1
+`
+
+func (q *Queries) GetProcessEntityID(ctx context.Context, id types.ProcessID) (types.EntityID, error) {
+	row := q.db.QueryRowContext(ctx, getProcessEntityID, id)
+	var entity_id types.EntityID
+	err := row.Scan(&entity_id)
+	return entity_id, err
+}
+
 const getProcessEnvelopeHeight = `-- name: GetProcessEnvelopeHeight :one
 SELECT results_envelope_height FROM processes
 WHERE id = ?
@@ -197,6 +211,62 @@ func (q *Queries) GetProcessEnvelopeHeight(ctx context.Context, id types.Process
 	return results_envelope_height, err
 }
 
+const getProcessIDsByFinalResults = `-- name: GetProcessIDsByFinalResults :many
+SELECT id FROM processes
+WHERE final_results = ?
+`
+
+func (q *Queries) GetProcessIDsByFinalResults(ctx context.Context, finalResults bool) ([]types.ProcessID, error) {
+	rows, err := q.db.QueryContext(ctx, getProcessIDsByFinalResults, finalResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []types.ProcessID
+	for rows.Next() {
+		var id types.ProcessID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getProcessIDsByResultsHeight = `-- name: GetProcessIDsByResultsHeight :many
+SELECT id FROM processes
+WHERE results_height = ?
+`
+
+func (q *Queries) GetProcessIDsByResultsHeight(ctx context.Context, resultsHeight int64) ([]types.ProcessID, error) {
+	rows, err := q.db.QueryContext(ctx, getProcessIDsByResultsHeight, resultsHeight)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []types.ProcessID
+	for rows.Next() {
+		var id types.ProcessID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getProcessStatus = `-- name: GetProcessStatus :one
 SELECT status FROM processes
 WHERE id = ?
@@ -210,21 +280,24 @@ func (q *Queries) GetProcessStatus(ctx context.Context, id types.ProcessID) (int
 	return status, err
 }
 
-const getTotalProcessEnvelopeHeight = `-- name: GetTotalProcessEnvelopeHeight :one
-SELECT SUM(results_envelope_height) FROM processes
+const getResultsWeight = `-- name: GetResultsWeight :one
+SELECT results_weight FROM processes
+WHERE id = ?
+LIMIT 1
 `
 
-func (q *Queries) GetTotalProcessEnvelopeHeight(ctx context.Context) (interface{}, error) {
-	row := q.db.QueryRowContext(ctx, getTotalProcessEnvelopeHeight)
-	var sum interface{}
-	err := row.Scan(&sum)
-	return sum, err
+func (q *Queries) GetResultsWeight(ctx context.Context, id types.ProcessID) (string, error) {
+	row := q.db.QueryRowContext(ctx, getResultsWeight, id)
+	var results_weight string
+	err := row.Scan(&results_weight)
+	return results_weight, err
 }
 
 const searchEntities = `-- name: SearchEntities :many
 SELECT entity_id FROM processes
 WHERE (? = '' OR (INSTR(LOWER(HEX(entity_id)), ?) > 0))
-ORDER BY creation_time ASC, ID ASC
+GROUP BY entity_id
+ORDER BY MIN(creation_time) ASC, entity_id ASC
 LIMIT ?
 OFFSET ?
 `
@@ -235,6 +308,7 @@ type SearchEntitiesParams struct {
 	Offset         int32
 }
 
+// The entities are sorted by the creation time of their first process.
 func (q *Queries) SearchEntities(ctx context.Context, arg SearchEntitiesParams) ([]types.EntityID, error) {
 	rows, err := q.db.QueryContext(ctx, searchEntities,
 		arg.EntityIDSubstr,
@@ -365,7 +439,8 @@ SET have_results = TRUE, final_results = TRUE,
 	results_weight = ?,
 	results_envelope_height = ?,
 	results_signatures = ?,
-	results_block_height = ?
+	results_block_height = ?,
+	results_ranked_rounds = ?
 WHERE id = ?
 `
 
@@ -375,6 +450,7 @@ type SetProcessResultsReadyParams struct {
 	EnvelopeHeight int64
 	Signatures     string
 	BlockHeight    int64
+	RankedRounds   string
 	ID             types.ProcessID
 }
 
@@ -385,6 +461,7 @@ func (q *Queries) SetProcessResultsReady(ctx context.Context, arg SetProcessResu
 		arg.EnvelopeHeight,
 		arg.Signatures,
 		arg.BlockHeight,
+		arg.RankedRounds,
 		arg.ID,
 	)
 }
@@ -23,11 +23,31 @@ func (q *Queries) CountTxReferences(ctx context.Context) (int64, error) {
 	return count, err
 }
 
+const createTxBatchReference = `-- name: CreateTxBatchReference :execresult
+INSERT INTO tx_batch_references (
+	tx_id, entity_id, process_id
+) VALUES (
+	?, ?, ?
+)
+`
+
+type CreateTxBatchReferenceParams struct {
+	TxID      int64
+	EntityID  types.EntityID
+	ProcessID types.ProcessID
+}
+
+func (q *Queries) CreateTxBatchReference(ctx context.Context, arg CreateTxBatchReferenceParams) (sql.Result, error) {
+	return q.db.ExecContext(ctx, createTxBatchReference, arg.TxID, arg.EntityID, arg.ProcessID)
+}
+
 const createTxReference = `-- name: CreateTxReference :execresult
 INSERT INTO tx_references (
-	hash, block_height, tx_block_index, tx_type
+	hash, block_height, tx_block_index, tx_type,
+	signer, entity_id, process_id
 ) VALUES (
-	?, ?, ?, ?
+	?, ?, ?, ?,
+	?, ?, ?
 )
 `
 
@@ -36,6 +56,9 @@ type CreateTxReferenceParams struct {
 	BlockHeight  int64
 	TxBlockIndex int64
 	TxType       string
+	Signer       types.AccountID
+	EntityID     types.EntityID
+	ProcessID    types.ProcessID
 }
 
 func (q *Queries) CreateTxReference(ctx context.Context, arg CreateTxReferenceParams) (sql.Result, error) {
@@ -44,11 +67,31 @@ func (q *Queries) CreateTxReference(ctx context.Context, arg CreateTxReferencePa
 		arg.BlockHeight,
 		arg.TxBlockIndex,
 		arg.TxType,
+		arg.Signer,
+		arg.EntityID,
+		arg.ProcessID,
 	)
 }
 
+const deleteTxBackfillStatus = `-- name: DeleteTxBackfillStatus :execresult
+DELETE FROM tx_references_backfill
+`
+
+func (q *Queries) DeleteTxBackfillStatus(ctx context.Context) (sql.Result, error) {
+	return q.db.ExecContext(ctx, deleteTxBackfillStatus)
+}
+
+const deleteTxBatchReferences = `-- name: DeleteTxBatchReferences :execresult
+DELETE FROM tx_batch_references
+WHERE tx_id = ?
+`
+
+func (q *Queries) DeleteTxBatchReferences(ctx context.Context, txID int64) (sql.Result, error) {
+	return q.db.ExecContext(ctx, deleteTxBatchReferences, txID)
+}
+
 const getLastTxReferences = `-- name: GetLastTxReferences :many
-SELECT id, hash, block_height, tx_block_index, tx_type FROM tx_references
+SELECT id, hash, block_height, tx_block_index, tx_type, signer, entity_id, process_id FROM tx_references
 ORDER BY id DESC
 LIMIT ?
 OFFSET ?
@@ -74,6 +117,9 @@ func (q *Queries) GetLastTxReferences(ctx context.Context, arg GetLastTxReferenc
 			&i.BlockHeight,
 			&i.TxBlockIndex,
 			&i.TxType,
+			&i.Signer,
+			&i.EntityID,
+			&i.ProcessID,
 		); err != nil {
 			return nil, err
 		}
@@ -88,8 +134,20 @@ func (q *Queries) GetLastTxReferences(ctx context.Context, arg GetLastTxReferenc
 	return items, nil
 }
 
+const getTxBackfillStatus = `-- name: GetTxBackfillStatus :one
+SELECT id, next_id, last_id FROM tx_references_backfill
+LIMIT 1
+`
+
+func (q *Queries) GetTxBackfillStatus(ctx context.Context) (TxReferencesBackfill, error) {
+	row := q.db.QueryRowContext(ctx, getTxBackfillStatus)
+	var i TxReferencesBackfill
+	err := row.Scan(&i.ID, &i.NextID, &i.LastID)
+	return i, err
+}
+
 const getTxReference = `-- name: GetTxReference :one
-SELECT id, hash, block_height, tx_block_index, tx_type FROM tx_references
+SELECT id, hash, block_height, tx_block_index, tx_type, signer, entity_id, process_id FROM tx_references
 WHERE id = ?
 LIMIT 1
 `
@@ -103,12 +161,15 @@ func (q *Queries) GetTxReference(ctx context.Context, id int64) (TxReference, er
 		&i.BlockHeight,
 		&i.TxBlockIndex,
 		&i.TxType,
+		&i.Signer,
+		&i.EntityID,
+		&i.ProcessID,
 	)
 	return i, err
 }
 
 const getTxReferenceByHash = `-- name: GetTxReferenceByHash :one
-SELECT id, hash, block_height, tx_block_index, tx_type FROM tx_references
+SELECT id, hash, block_height, tx_block_index, tx_type, signer, entity_id, process_id FROM tx_references
 WHERE hash = ?
 LIMIT 1
 `
@@ -122,6 +183,215 @@ func (q *Queries) GetTxReferenceByHash(ctx context.Context, hash types.Hash) (Tx
 		&i.BlockHeight,
 		&i.TxBlockIndex,
 		&i.TxType,
+		&i.Signer,
+		&i.EntityID,
+		&i.ProcessID,
 	)
 	return i, err
 }
+
+const getTxReferencesByAccount = `-- name: GetTxReferencesByAccount :many
+SELECT id, hash, block_height, tx_block_index, tx_type, signer, entity_id, process_id FROM tx_references
+WHERE tx_references.signer = ?
+	OR tx_references.entity_id = ?
+	OR tx_references.id IN (
+		SELECT tx_batch_references.tx_id FROM tx_batch_references
+		WHERE tx_batch_references.entity_id = ?
+	)
+ORDER BY id DESC
+LIMIT ?
+OFFSET ?
+`
+
+type GetTxReferencesByAccountParams struct {
+	Signer     types.AccountID
+	EntityID   types.EntityID
+	EntityID_2 types.EntityID
+	Limit      int32
+	Offset     int32
+}
+
+func (q *Queries) GetTxReferencesByAccount(ctx context.Context, arg GetTxReferencesByAccountParams) ([]TxReference, error) {
+	rows, err := q.db.QueryContext(ctx, getTxReferencesByAccount,
+		arg.Signer,
+		arg.EntityID,
+		arg.EntityID_2,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TxReference
+	for rows.Next() {
+		var i TxReference
+		if err := rows.Scan(
+			&i.ID,
+			&i.Hash,
+			&i.BlockHeight,
+			&i.TxBlockIndex,
+			&i.TxType,
+			&i.Signer,
+			&i.EntityID,
+			&i.ProcessID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTxReferencesByIDRange = `-- name: GetTxReferencesByIDRange :many
+SELECT id, hash, block_height, tx_block_index, tx_type, signer, entity_id, process_id FROM tx_references
+WHERE id >= ? AND id <= ?
+ORDER BY id ASC
+LIMIT ?
+`
+
+type GetTxReferencesByIDRangeParams struct {
+	FromID int64
+	ToID   int64
+	Limit  int32
+}
+
+func (q *Queries) GetTxReferencesByIDRange(ctx context.Context, arg GetTxReferencesByIDRangeParams) ([]TxReference, error) {
+	rows, err := q.db.QueryContext(ctx, getTxReferencesByIDRange, arg.FromID, arg.ToID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TxReference
+	for rows.Next() {
+		var i TxReference
+		if err := rows.Scan(
+			&i.ID,
+			&i.Hash,
+			&i.BlockHeight,
+			&i.TxBlockIndex,
+			&i.TxType,
+			&i.Signer,
+			&i.EntityID,
+			&i.ProcessID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTxReferencesByProcessID = `-- name: GetTxReferencesByProcessID :many
+SELECT id, hash, block_height, tx_block_index, tx_type, signer, entity_id, process_id FROM tx_references
+WHERE tx_references.process_id = ?
+	OR tx_references.id IN (
+		SELECT tx_batch_references.tx_id FROM tx_batch_references
+		WHERE tx_batch_references.process_id = ?
+	)
+ORDER BY id DESC
+LIMIT ?
+OFFSET ?
+`
+
+type GetTxReferencesByProcessIDParams struct {
+	ProcessID   types.ProcessID
+	ProcessID_2 types.ProcessID
+	Limit       int32
+	Offset      int32
+}
+
+func (q *Queries) GetTxReferencesByProcessID(ctx context.Context, arg GetTxReferencesByProcessIDParams) ([]TxReference, error) {
+	rows, err := q.db.QueryContext(ctx, getTxReferencesByProcessID,
+		arg.ProcessID,
+		arg.ProcessID_2,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TxReference
+	for rows.Next() {
+		var i TxReference
+		if err := rows.Scan(
+			&i.ID,
+			&i.Hash,
+			&i.BlockHeight,
+			&i.TxBlockIndex,
+			&i.TxType,
+			&i.Signer,
+			&i.EntityID,
+			&i.ProcessID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setTxBackfillNextID = `-- name: SetTxBackfillNextID :execresult
+UPDATE tx_references_backfill
+SET next_id = ?
+WHERE id = 1
+`
+
+func (q *Queries) SetTxBackfillNextID(ctx context.Context, nextID int64) (sql.Result, error) {
+	return q.db.ExecContext(ctx, setTxBackfillNextID, nextID)
+}
+
+const setTxBackfillStatus = `-- name: SetTxBackfillStatus :execresult
+REPLACE INTO tx_references_backfill (id, next_id, last_id)
+VALUES (1, ?, ?)
+`
+
+type SetTxBackfillStatusParams struct {
+	NextID int64
+	LastID int64
+}
+
+func (q *Queries) SetTxBackfillStatus(ctx context.Context, arg SetTxBackfillStatusParams) (sql.Result, error) {
+	return q.db.ExecContext(ctx, setTxBackfillStatus, arg.NextID, arg.LastID)
+}
+
+const updateTxReferenceParticipants = `-- name: UpdateTxReferenceParticipants :execresult
+UPDATE tx_references
+SET signer = ?, entity_id = ?, process_id = ?
+WHERE id = ?
+`
+
+type UpdateTxReferenceParticipantsParams struct {
+	Signer    types.AccountID
+	EntityID  types.EntityID
+	ProcessID types.ProcessID
+	ID        int64
+}
+
+func (q *Queries) UpdateTxReferenceParticipants(ctx context.Context, arg UpdateTxReferenceParticipantsParams) (sql.Result, error) {
+	return q.db.ExecContext(ctx, updateTxReferenceParticipants,
+		arg.Signer,
+		arg.EntityID,
+		arg.ProcessID,
+		arg.ID,
+	)
+}
@@ -14,25 +14,45 @@ import (
 	"go.vocdoni.io/dvote/vochain/state"
 )
 
+const countVoteReferences = `-- name: CountVoteReferences :one
+SELECT COUNT(*) FROM vote_references
+`
+
+func (q *Queries) CountVoteReferences(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countVoteReferences)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createVoteReference = `-- name: CreateVoteReference :execresult
 REPLACE INTO vote_references (
 	nullifier, process_id, height, weight,
-	tx_index, voter_id, overwrite_count, creation_time
+	tx_index, voter_id, overwrite_count, creation_time,
+	vote_package, encryption_key_indexes, first_height, first_creation_time,
+	answers
 ) VALUES (
 	?, ?, ?, ?,
-	?, ?, ?, ?
+	?, ?, ?, ?,
+	?, ?, ?, ?,
+	?
 )
 `
 
 type CreateVoteReferenceParams struct {
-	Nullifier      types.Nullifier
-	ProcessID      types.ProcessID
-	Height         int64
-	Weight         string
-	TxIndex        int64
-	VoterID        state.VoterID
-	OverwriteCount int64
-	CreationTime   time.Time
+	Nullifier            types.Nullifier
+	ProcessID            types.ProcessID
+	Height               int64
+	Weight               string
+	TxIndex              int64
+	VoterID              state.VoterID
+	OverwriteCount       int64
+	CreationTime         time.Time
+	VotePackage          []byte
+	EncryptionKeyIndexes string
+	FirstHeight          int64
+	FirstCreationTime    time.Time
+	Answers              int64
 }
 
 func (q *Queries) CreateVoteReference(ctx context.Context, arg CreateVoteReferenceParams) (sql.Result, error) {
@@ -45,11 +65,94 @@ func (q *Queries) CreateVoteReference(ctx context.Context, arg CreateVoteReferen
 		arg.VoterID,
 		arg.OverwriteCount,
 		arg.CreationTime,
+		arg.VotePackage,
+		arg.EncryptionKeyIndexes,
+		arg.FirstHeight,
+		arg.FirstCreationTime,
+		arg.Answers,
 	)
 }
 
+const getUndecodedVoteReferences = `-- name: GetUndecodedVoteReferences :many
+SELECT nullifier, process_id, height, weight, tx_index, creation_time, voter_id, overwrite_count, vote_package, encryption_key_indexes, first_height, first_creation_time, answers FROM vote_references
+WHERE process_id = ? AND answers = -1
+`
+
+func (q *Queries) GetUndecodedVoteReferences(ctx context.Context, processID types.ProcessID) ([]VoteReference, error) {
+	rows, err := q.db.QueryContext(ctx, getUndecodedVoteReferences, processID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []VoteReference
+	for rows.Next() {
+		var i VoteReference
+		if err := rows.Scan(
+			&i.Nullifier,
+			&i.ProcessID,
+			&i.Height,
+			&i.Weight,
+			&i.TxIndex,
+			&i.CreationTime,
+			&i.VoterID,
+			&i.OverwriteCount,
+			&i.VotePackage,
+			&i.EncryptionKeyIndexes,
+			&i.FirstHeight,
+			&i.FirstCreationTime,
+			&i.Answers,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getVoteAnswersCount = `-- name: GetVoteAnswersCount :many
+SELECT answers, COUNT(*) AS votes
+FROM vote_references
+WHERE process_id = ? AND answers >= 0
+GROUP BY answers
+ORDER BY answers ASC
+`
+
+type GetVoteAnswersCountRow struct {
+	Answers int64
+	Votes   int64
+}
+
+func (q *Queries) GetVoteAnswersCount(ctx context.Context, processID types.ProcessID) ([]GetVoteAnswersCountRow, error) {
+	rows, err := q.db.QueryContext(ctx, getVoteAnswersCount, processID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetVoteAnswersCountRow
+	for rows.Next() {
+		var i GetVoteAnswersCountRow
+		if err := rows.Scan(&i.Answers, &i.Votes); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getVoteReference = `-- name: GetVoteReference :one
-SELECT nullifier, process_id, height, weight, tx_index, creation_time, voter_id, overwrite_count FROM vote_references
+SELECT nullifier, process_id, height, weight, tx_index, creation_time, voter_id, overwrite_count, vote_package, encryption_key_indexes, first_height, first_creation_time, answers FROM vote_references
 WHERE nullifier = ?
 LIMIT 1
 `
@@ -66,12 +169,17 @@ func (q *Queries) GetVoteReference(ctx context.Context, nullifier types.Nullifie
 		&i.CreationTime,
 		&i.VoterID,
 		&i.OverwriteCount,
+		&i.VotePackage,
+		&i.EncryptionKeyIndexes,
+		&i.FirstHeight,
+		&i.FirstCreationTime,
+		&i.Answers,
 	)
 	return i, err
 }
 
 const getVoteReferencesByProcessID = `-- name: GetVoteReferencesByProcessID :many
-SELECT nullifier, process_id, height, weight, tx_index, creation_time, voter_id, overwrite_count FROM vote_references
+SELECT nullifier, process_id, height, weight, tx_index, creation_time, voter_id, overwrite_count, vote_package, encryption_key_indexes, first_height, first_creation_time, answers FROM vote_references
 WHERE process_id = ?
 `
 
@@ -93,6 +201,197 @@ func (q *Queries) GetVoteReferencesByProcessID(ctx context.Context, processID ty
 			&i.CreationTime,
 			&i.VoterID,
 			&i.OverwriteCount,
+			&i.VotePackage,
+			&i.EncryptionKeyIndexes,
+			&i.FirstHeight,
+			&i.FirstCreationTime,
+			&i.Answers,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getVoteStatsByHeight = `-- name: GetVoteStatsByHeight :many
+SELECT CAST(first_height / ? * ? AS INTEGER) AS start,
+	COUNT(*) AS votes,
+	CAST(SUM(overwrite_count) AS INTEGER) AS overwrites,
+	CAST(SUM(CASE WHEN LENGTH(weight) <= 9 THEN CAST(weight AS INTEGER) ELSE 0 END) AS INTEGER) AS weight,
+	CAST(COALESCE(GROUP_CONCAT(CASE WHEN LENGTH(weight) > 9 THEN weight END), '') AS TEXT) AS large_weights
+FROM vote_references
+WHERE process_id = ?
+GROUP BY start
+ORDER BY start ASC
+LIMIT ?
+`
+
+type GetVoteStatsByHeightParams struct {
+	Bucket    interface{}
+	ProcessID types.ProcessID
+	Limit     int32
+}
+
+type GetVoteStatsByHeightRow struct {
+	Start        int64
+	Votes        int64
+	Overwrites   int64
+	Weight       int64
+	LargeWeights string
+}
+
+// The votes are grouped in buckets by the height of their first cast.  The
+// weights of up to 9 digits are summed here, and the larger ones are returned
+// to be summed by the indexer, as they overflow the integers of SQLite.
+func (q *Queries) GetVoteStatsByHeight(ctx context.Context, arg GetVoteStatsByHeightParams) ([]GetVoteStatsByHeightRow, error) {
+	rows, err := q.db.QueryContext(ctx, getVoteStatsByHeight,
+		arg.Bucket,
+		arg.Bucket,
+		arg.ProcessID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetVoteStatsByHeightRow
+	for rows.Next() {
+		var i GetVoteStatsByHeightRow
+		if err := rows.Scan(
+			&i.Start,
+			&i.Votes,
+			&i.Overwrites,
+			&i.Weight,
+			&i.LargeWeights,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getVoteStatsByTime = `-- name: GetVoteStatsByTime :many
+SELECT CAST(CAST(strftime('%s', first_creation_time) AS INTEGER) / ? * ? AS INTEGER) AS start,
+	COUNT(*) AS votes,
+	CAST(SUM(overwrite_count) AS INTEGER) AS overwrites,
+	CAST(SUM(CASE WHEN LENGTH(weight) <= 9 THEN CAST(weight AS INTEGER) ELSE 0 END) AS INTEGER) AS weight,
+	CAST(COALESCE(GROUP_CONCAT(CASE WHEN LENGTH(weight) > 9 THEN weight END), '') AS TEXT) AS large_weights
+FROM vote_references
+WHERE process_id = ?
+GROUP BY start
+ORDER BY start ASC
+LIMIT ?
+`
+
+type GetVoteStatsByTimeParams struct {
+	Bucket    interface{}
+	ProcessID types.ProcessID
+	Limit     int32
+}
+
+type GetVoteStatsByTimeRow struct {
+	Start        int64
+	Votes        int64
+	Overwrites   int64
+	Weight       int64
+	LargeWeights string
+}
+
+// The votes are grouped in buckets by the unix time of their first cast, in
+// seconds, summing their weights as in GetVoteStatsByHeight.
+func (q *Queries) GetVoteStatsByTime(ctx context.Context, arg GetVoteStatsByTimeParams) ([]GetVoteStatsByTimeRow, error) {
+	rows, err := q.db.QueryContext(ctx, getVoteStatsByTime,
+		arg.Bucket,
+		arg.Bucket,
+		arg.ProcessID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetVoteStatsByTimeRow
+	for rows.Next() {
+		var i GetVoteStatsByTimeRow
+		if err := rows.Scan(
+			&i.Start,
+			&i.Votes,
+			&i.Overwrites,
+			&i.Weight,
+			&i.LargeWeights,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchProcessVoteReferences = `-- name: SearchProcessVoteReferences :many
+SELECT nullifier, process_id, height, weight, tx_index, creation_time, voter_id, overwrite_count, vote_package, encryption_key_indexes, first_height, first_creation_time, answers FROM vote_references
+WHERE process_id = ?
+	AND (? = '' OR (INSTR(LOWER(HEX(nullifier)), ?) > 0))
+ORDER BY height ASC, nullifier ASC
+LIMIT ?
+OFFSET ?
+`
+
+type SearchProcessVoteReferencesParams struct {
+	ProcessID       types.ProcessID
+	NullifierSubstr string
+	Limit           int32
+	Offset          int32
+}
+
+func (q *Queries) SearchProcessVoteReferences(ctx context.Context, arg SearchProcessVoteReferencesParams) ([]VoteReference, error) {
+	rows, err := q.db.QueryContext(ctx, searchProcessVoteReferences,
+		arg.ProcessID,
+		arg.NullifierSubstr,
+		arg.NullifierSubstr,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []VoteReference
+	for rows.Next() {
+		var i VoteReference
+		if err := rows.Scan(
+			&i.Nullifier,
+			&i.ProcessID,
+			&i.Height,
+			&i.Weight,
+			&i.TxIndex,
+			&i.CreationTime,
+			&i.VoterID,
+			&i.OverwriteCount,
+			&i.VotePackage,
+			&i.EncryptionKeyIndexes,
+			&i.FirstHeight,
+			&i.FirstCreationTime,
+			&i.Answers,
 		); err != nil {
 			return nil, err
 		}
@@ -106,3 +405,69 @@ func (q *Queries) GetVoteReferencesByProcessID(ctx context.Context, processID ty
 	}
 	return items, nil
 }
+
+const searchVoteReferences = `-- name: SearchVoteReferences :many
+SELECT nullifier, process_id, height, weight, tx_index, creation_time, voter_id, overwrite_count, vote_package, encryption_key_indexes, first_height, first_creation_time, answers FROM vote_references
+WHERE INSTR(LOWER(HEX(nullifier)), ?) > 0
+ORDER BY height ASC, nullifier ASC
+LIMIT ?
+OFFSET ?
+`
+
+type SearchVoteReferencesParams struct {
+	NullifierSubstr string
+	Limit           int32
+	Offset          int32
+}
+
+func (q *Queries) SearchVoteReferences(ctx context.Context, arg SearchVoteReferencesParams) ([]VoteReference, error) {
+	rows, err := q.db.QueryContext(ctx, searchVoteReferences, arg.NullifierSubstr, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []VoteReference
+	for rows.Next() {
+		var i VoteReference
+		if err := rows.Scan(
+			&i.Nullifier,
+			&i.ProcessID,
+			&i.Height,
+			&i.Weight,
+			&i.TxIndex,
+			&i.CreationTime,
+			&i.VoterID,
+			&i.OverwriteCount,
+			&i.VotePackage,
+			&i.EncryptionKeyIndexes,
+			&i.FirstHeight,
+			&i.FirstCreationTime,
+			&i.Answers,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setVoteReferenceAnswers = `-- name: SetVoteReferenceAnswers :execresult
+UPDATE vote_references
+SET answers = ?
+WHERE nullifier = ?
+`
+
+type SetVoteReferenceAnswersParams struct {
+	Answers   int64
+	Nullifier types.Nullifier
+}
+
+func (q *Queries) SetVoteReferenceAnswers(ctx context.Context, arg SetVoteReferenceAnswersParams) (sql.Result, error) {
+	return q.db.ExecContext(ctx, setVoteReferenceAnswers, arg.Answers, arg.Nullifier)
+}
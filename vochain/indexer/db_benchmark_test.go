@@ -1,7 +1,6 @@
 package indexer
 
 import (
-	"fmt"
 	"math/big"
 	"testing"
 	"time"
@@ -37,6 +36,27 @@ func BenchmarkNewProcess(b *testing.B) {
 	b.Run("newProcess", benchmarkNewProcess)
 }
 
+// LOG_LEVEL=info go test -v -benchmem -run=- -bench=EnvelopeList -benchtime=20s
+func BenchmarkEnvelopeList(b *testing.B) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.Run("envelopeList", benchmarkEnvelopeList)
+}
+
+// LOG_LEVEL=info go test -v -benchmem -run=- -bench=GetResults -benchtime=20s
+func BenchmarkGetResults(b *testing.B) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.Run("getResults", benchmarkGetResults)
+}
+
+// LOG_LEVEL=info go test -v -benchmem -run=- -bench=Counts -benchtime=20s
+func BenchmarkCounts(b *testing.B) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.Run("counts", benchmarkCounts)
+}
+
 func benchmarkIndexTx(b *testing.B) {
 	app := vochain.TestBaseApplication(b)
 
@@ -85,8 +105,10 @@ func benchmarkFetchTx(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		idx.Rollback()
+		hashes := make([][32]byte, numTxs)
 		for j := 0; j < numTxs; j++ {
-			idx.OnNewTx(&vochaintx.VochainTx{TxID: util.Random32()}, uint32(i), int32(j))
+			hashes[j] = util.Random32()
+			idx.OnNewTx(&vochaintx.VochainTx{TxID: hashes[j]}, uint32(i), int32(j))
 		}
 		err := idx.Commit(uint32(i))
 		qt.Assert(b, err, qt.IsNil)
@@ -102,7 +124,7 @@ func benchmarkFetchTx(b *testing.B) {
 			numTxs, (i+1)*numTxs, time.Since(startTime))
 		startTime = time.Now()
 		for j := 0; j < numTxs; j++ {
-			_, err = idx.GetTxHashReference([]byte(fmt.Sprintf("hash%d%d", i, j)))
+			_, err = idx.GetTxHashReference(hashes[j][:])
 			qt.Assert(b, err, qt.IsNil)
 		}
 		log.Infof("fetched %d transactions (out of %d total) by hash, took %s",
@@ -139,3 +161,96 @@ func benchmarkNewProcess(b *testing.B) {
 	log.Infof("indexed %d new processes, took %s",
 		numProcesses, time.Since(startTime))
 }
+
+// benchmarkTestProcess adds a new process with live results to the state and
+// the indexer, and returns its ID.
+func benchmarkTestProcess(b *testing.B, app *vochain.BaseApplication, idx *Indexer) []byte {
+	pid := util.RandomBytes(32)
+	if err := app.State.AddProcess(&models.Process{
+		ProcessId:    pid,
+		EntityId:     util.RandomBytes(20),
+		EnvelopeType: &models.EnvelopeType{EncryptedVotes: false},
+		Status:       models.ProcessStatus_READY,
+		BlockCount:   100000000,
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 3, MaxValue: 1},
+		Mode:         &models.ProcessMode{AutoStart: true},
+	}); err != nil {
+		b.Fatal(err)
+	}
+	idx.Rollback()
+	idx.OnProcess(pid, nil, "", "", 0)
+	qt.Assert(b, idx.Commit(0), qt.IsNil)
+	return pid
+}
+
+// benchmarkTestVotes indexes the votes of the process on blocks of 2000 votes.
+func benchmarkTestVotes(b *testing.B, idx *Indexer, pid []byte, numVotes int) {
+	for i := 0; i < numVotes; i += 2000 {
+		idx.Rollback()
+		for j := i; j < numVotes && j < i+2000; j++ {
+			idx.OnVote(&state.Vote{
+				Height:      uint32(j / 2000),
+				ProcessID:   pid,
+				Nullifier:   util.RandomBytes(32),
+				VotePackage: []byte(`{"votes":[1,0,1]}`),
+				Weight:      new(big.Int).SetUint64(uint64(util.RandomInt(1, 10000))),
+			}, int32(j%2000))
+		}
+		qt.Assert(b, idx.Commit(0), qt.IsNil)
+	}
+}
+
+func benchmarkEnvelopeList(b *testing.B) {
+	numVotes := 10000
+	app := vochain.TestBaseApplication(b)
+	// the envelope list includes the tx hashes from the blockstore, which
+	// does not exist on the tests
+	app.SetFnGetTxHash(func(height uint32, txIndex int32) (*models.SignedTx, []byte, error) {
+		return &models.SignedTx{}, make([]byte, 32), nil
+	})
+
+	idx := newTestIndexer(b, app, true)
+	pid := benchmarkTestProcess(b, app, idx)
+	benchmarkTestVotes(b, idx, pid, numVotes)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		envelopes, err := idx.GetEnvelopes(pid, 64, (i*64)%(numVotes-64), "")
+		qt.Assert(b, err, qt.IsNil)
+		qt.Assert(b, envelopes, qt.HasLen, 64)
+	}
+}
+
+func benchmarkGetResults(b *testing.B) {
+	app := vochain.TestBaseApplication(b)
+
+	idx := newTestIndexer(b, app, true)
+	pid := benchmarkTestProcess(b, app, idx)
+	benchmarkTestVotes(b, idx, pid, 2000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		results, err := idx.GetResults(pid)
+		qt.Assert(b, err, qt.IsNil)
+		qt.Assert(b, results.EnvelopeHeight, qt.Equals, uint64(2000))
+	}
+}
+
+func benchmarkCounts(b *testing.B) {
+	numProcesses := 100
+	app := vochain.TestBaseApplication(b)
+
+	idx := newTestIndexer(b, app, true)
+	for i := 0; i < numProcesses; i++ {
+		pid := benchmarkTestProcess(b, app, idx)
+		benchmarkTestVotes(b, idx, pid, 100)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		qt.Assert(b, idx.ProcessCount(nil), qt.Equals, uint64(numProcesses))
+		qt.Assert(b, idx.EntityCount(), qt.Equals, uint64(numProcesses))
+		_, err := idx.GetEnvelopeHeight(nil)
+		qt.Assert(b, err, qt.IsNil)
+	}
+}
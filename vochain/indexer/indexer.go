@@ -8,14 +8,10 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
-	"os"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/dgraph-io/badger/v3"
-	"github.com/timshannon/badgerhold/v3"
-	"go.vocdoni.io/dvote/db/lru"
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/dvote/vochain"
@@ -32,10 +28,6 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// enableBadgerhold is true by default;
-// badgerhold can be disabled via DISABLE_BADGERHOLD=true.
-var enableBadgerhold = os.Getenv("DISABLE_BADGERHOLD") != "true"
-
 //go:embed migrations/*.sql
 var embedMigrations embed.FS
 
@@ -47,9 +39,6 @@ const (
 	// MaxEnvelopeListSize is the maximum number of envelopes a process can store.
 	// 8.3M seems enough for now
 	MaxEnvelopeListSize = 32 << 18
-
-	countEnvelopeCacheSize = 1024
-	resultsCacheSize       = 512
 )
 
 // EventListener is an interface used for executing custom functions during the
@@ -83,28 +72,21 @@ type Indexer struct {
 	newTxPool []*indexertypes.TxReference
 	// tokenTransferPool is the list of token transfers to be indexed
 	tokenTransferPool []*indexertypes.TokenTransferMeta
+	// accountRotationPool is the list of account rotations to be indexed
+	accountRotationPool []*vochaintx.AccountRotation
+	// voteWeightPool is the list of weight changes of votes already counted,
+	// due to vote delegations, that should be live counted, grouped by processId
+	voteWeightPool map[string][]*voteWeight
 	// lockPool is the lock for all *Pool operations
 	lockPool sync.RWMutex
 	// list of live processes (those on which the votes will be computed on arrival)
 	liveResultsProcs sync.Map
 	// eventOnResults is the list of external callbacks that will be executed by the indexer
 	eventOnResults []EventListener
-	db             *badgerhold.Store
 	sqlDB          *sql.DB
-	// envelopeHeightCache and countTotalEnvelopes are in memory counters that helps reducing the
-	// access time when GenEnvelopeHeight() is called.
-	envelopeHeightCache *lru.Cache
-	// resultsCache is a memory cache for final results results, stores processId:<results>
-	resultsCache *lru.Cache
-	// addVoteLock is used to avoid Transaction Conflicts on the KV database.
-	// It is not critical and the code should be able to recover from a Conflict, but we
-	// try to minimize this situations in order to improve performance on the KV.
-	// TODO (pau): remove this mutex and relay on the KV layer
-	addVoteLock sync.RWMutex
-	// voteTxLock is used to avoid Transaction Conflicts on the vote index KV database.
-	voteTxLock sync.RWMutex
-	// txIndexLock is used to avoid Transaction Conflicts on the transaction index KV database.
-	txIndexLock sync.Mutex
+	// searchEnabled is true if SQLite is built with FTS5, required by the
+	// metadata search, see setupMetadataSearch
+	searchEnabled bool
 	// recoveryBootLock prevents Commit() to add new votes while the recovery bootstratp is
 	// being executed.
 	recoveryBootLock sync.RWMutex
@@ -114,9 +96,22 @@ type Indexer struct {
 	liveGoroutines int64 // atomic
 
 	// In the tests, the extra 5s sleeps can make CI really slow at times, to
-	// the point that it times out. Skip that in the tests.
+	// the point that it times out. Skip that in the tests, and when replaying
+	// past blocks, see Rebuild.
 	skipTargetHeightSleeps bool
 
+	// rebuildLock protects rebuilding and liveFromHeight
+	rebuildLock sync.Mutex
+	// rebuilding is true while Rebuild replays the blocks, so Commit does not
+	// index the blocks committed meanwhile
+	rebuilding bool
+	// liveFromHeight is the first block indexed by Commit after a Rebuild,
+	// since the previous ones were replayed
+	liveFromHeight uint32
+	// replaying is true on the indexers which replay a block for Rebuild, so
+	// Commit indexes it synchronously
+	replaying bool
+
 	// Note that cancelling currently only stops asynchronous goroutines started
 	// by Commit. In the future we could make it stop all other work as well,
 	// like entire calls to Commit.
@@ -141,29 +136,9 @@ func NewIndexer(dbPath string, app *vochain.BaseApplication, countLiveResults bo
 		cancelCtx:  cancelCtx,
 		cancelFunc: cancelFunc,
 	}
-	var err error
-	s.db, err = InitDB(dbPath)
-	if err != nil {
-		return nil, err
-	}
-
 	startTime := time.Now()
 
-	countMap, err := s.retrieveCounts()
-	if err != nil {
-		return nil, fmt.Errorf("could not create indexer: %v", err)
-	}
-
-	log.Infow("indexer initialization", map[string]interface{}{
-		"took":         time.Since(startTime),
-		"dataDir":      dbPath,
-		"liveResults":  countLiveResults,
-		"transactions": countMap[indexertypes.CountStoreTransactions],
-		"envelopes":    countMap[indexertypes.CountStoreEnvelopes],
-		"processes":    countMap[indexertypes.CountStoreProcesses],
-		"entities":     countMap[indexertypes.CountStoreEntities],
-	})
-
+	var err error
 	sqlPath := dbPath + "-sqlite"
 	// s.sqlDB, err = sql.Open("sqlite", sqlPath) // modernc
 	s.sqlDB, err = sql.Open("sqlite3", sqlPath) // mattn
@@ -188,27 +163,53 @@ func NewIndexer(dbPath string, app *vochain.BaseApplication, countLiveResults bo
 	if err := goose.Up(s.sqlDB, "migrations"); err != nil {
 		return nil, fmt.Errorf("goose up: %w", err)
 	}
+	// The metadata search uses FTS5, which mattn only builds with the sqlite_fts5 tag.
+	var fts5 bool
+	if err := s.sqlDB.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").
+		Scan(&fts5); err != nil {
+		return nil, err
+	}
+	if !fts5 {
+		log.Warnf("sqlite is built without FTS5, the metadata search is disabled " +
+			"(build with -tags sqlite_fts5 to enable it)")
+	}
+	if err := s.setupMetadataSearch(fts5); err != nil {
+		return nil, fmt.Errorf("cannot set up metadata search index: %w", err)
+	}
+
+	// The indexer used to keep most of its data on a badgerhold database at
+	// dbPath, import it if it was not migrated yet.
+	if err := s.migrateBadgerhold(dbPath); err != nil {
+		return nil, fmt.Errorf("could not migrate the badgerhold indexer: %w", err)
+	}
+
+	txCount, err := s.TransactionCount()
+	if err != nil {
+		return nil, fmt.Errorf("could not create indexer: %v", err)
+	}
+	envelopeCount, err := s.GetEnvelopeHeight(nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create indexer: %v", err)
+	}
+	log.Infow("indexer initialization", map[string]interface{}{
+		"took":         time.Since(startTime),
+		"dataDir":      sqlPath,
+		"liveResults":  countLiveResults,
+		"transactions": txCount,
+		"envelopes":    envelopeCount,
+		"processes":    s.ProcessCount(nil),
+		"entities":     s.EntityCount(),
+	})
 
 	// Subscrive to events
 	s.App.State.AddEventListener(s)
-	s.envelopeHeightCache = lru.New(countEnvelopeCacheSize)
-	s.resultsCache = lru.New(resultsCacheSize)
 	return s, nil
 }
 
 func (idx *Indexer) Close() error {
 	idx.cancelFunc()
 	idx.WaitIdle()
-	// Try closing both before reporting errors.
-	err1 := idx.db.Close()
-	err2 := idx.sqlDB.Close()
-	if err1 != nil {
-		return err1
-	}
-	if err2 != nil {
-		return err2
-	}
-	return nil
+	return idx.sqlDB.Close()
 }
 
 // WaitIdle waits until there are no live asynchronous goroutines, such as those
@@ -232,6 +233,15 @@ func (idx *Indexer) WaitIdle() {
 	}
 }
 
+// eventTime returns the time of the events being indexed, which is the block
+// time when replaying a block.
+func (idx *Indexer) eventTime() time.Time {
+	if idx.replaying {
+		return time.Unix(idx.App.TimestampStartBlock(), 0)
+	}
+	return time.Now()
+}
+
 func (idx *Indexer) timeoutQueries() (*indexerdb.Queries, context.Context, context.CancelFunc) {
 	ctx := context.TODO()
 	ctx, cancel := context.WithTimeout(ctx, time.Minute)
@@ -239,76 +249,6 @@ func (idx *Indexer) timeoutQueries() (*indexerdb.Queries, context.Context, conte
 	return queries, ctx, cancel
 }
 
-// retrieveCounts returns a count for txs, envelopes, processes, and entities, in that order.
-// If no CountStore model is stored for the type, it counts all db entries of that type.
-func (idx *Indexer) retrieveCounts() (map[uint8]uint64, error) {
-	var err error
-	txCountStore := new(indexertypes.CountStore)
-	envelopeCountStore := new(indexertypes.CountStore)
-	processCountStore := new(indexertypes.CountStore)
-	entityCountStore := new(indexertypes.CountStore)
-	if enableBadgerhold {
-		if err = idx.db.Get(indexertypes.CountStoreTransactions, txCountStore); err != nil {
-			count, err := idx.db.Count(&indexertypes.TxReference{}, &badgerhold.Query{})
-			if err != nil {
-				if err != badger.ErrKeyNotFound {
-					return nil, fmt.Errorf("could not count total transactions: %v", err)
-				}
-				// If keyNotFound error, ensure count is 0
-				count = 0
-			}
-			// Store new countStore value
-			txCountStore.Count = uint64(count)
-			txCountStore.Type = indexertypes.CountStoreTransactions
-			if err := idx.db.Upsert(txCountStore.Type, txCountStore); err != nil {
-				return nil, fmt.Errorf("could not store transaction count: %v", err)
-			}
-		}
-		if err = idx.db.Get(indexertypes.CountStoreEnvelopes, envelopeCountStore); err != nil {
-			count, err := idx.db.Count(&indexertypes.VoteReference{}, &badgerhold.Query{})
-			if err != nil && err != badger.ErrKeyNotFound {
-				return nil, fmt.Errorf("could not count total envelopes: %v", err)
-			}
-			// Store new countStore value
-			envelopeCountStore.Count = uint64(count)
-			envelopeCountStore.Type = indexertypes.CountStoreEnvelopes
-			if err := idx.db.Upsert(envelopeCountStore.Type, envelopeCountStore); err != nil {
-				return nil, fmt.Errorf("could not store envelope count: %v", err)
-			}
-		}
-		if err = idx.db.Get(indexertypes.CountStoreProcesses, processCountStore); err != nil {
-			count, err := idx.db.Count(&indexertypes.Process{}, &badgerhold.Query{})
-			if err != nil && err != badger.ErrKeyNotFound {
-				return nil, fmt.Errorf("could not count total processes: %v", err)
-			}
-			// Store new countStore value
-			processCountStore.Count = uint64(count)
-			processCountStore.Type = indexertypes.CountStoreProcesses
-			if err := idx.db.Upsert(processCountStore.Type, processCountStore); err != nil {
-				return nil, fmt.Errorf("could not store process count: %v", err)
-			}
-		}
-		if err = idx.db.Get(indexertypes.CountStoreEntities, entityCountStore); err != nil {
-			count, err := idx.db.Count(&indexertypes.Entity{}, &badgerhold.Query{})
-			if err != nil && err != badger.ErrKeyNotFound {
-				return nil, fmt.Errorf("could not count total entities: %v", err)
-			}
-			// Store new countStore value
-			entityCountStore.Count = uint64(count)
-			entityCountStore.Type = indexertypes.CountStoreEntities
-			if err := idx.db.Upsert(entityCountStore.Type, entityCountStore); err != nil {
-				return nil, fmt.Errorf("could not store entity count: %v", err)
-			}
-		}
-	}
-	return map[uint8]uint64{
-		indexertypes.CountStoreTransactions: txCountStore.Count,
-		indexertypes.CountStoreEnvelopes:    envelopeCountStore.Count,
-		indexertypes.CountStoreProcesses:    processCountStore.Count,
-		indexertypes.CountStoreEntities:     entityCountStore.Count,
-	}, nil
-}
-
 // AfterSyncBootstrap is a blocking function that waits until the Vochain is synchronized
 // and then execute a set of recovery actions. It mainly checks for those processes which are
 // still open (live) and updates all temporary data (current voting weight and live results
@@ -319,10 +259,6 @@ func (idx *Indexer) AfterSyncBootstrap() {
 	if idx.ignoreLiveResults {
 		return
 	}
-	if !enableBadgerhold {
-		// TODO(sqlite): needs to be reimplemented
-		return
-	}
 	// During the first seconds/milliseconds of the Vochain startup, Tendermint might report that
 	// the chain is not synchronizing since it still does not have any peer and do not know the
 	// actual size of the blockchain. If afterSyncBootStrap is executed on this specific moment,
@@ -347,15 +283,9 @@ func (idx *Indexer) AfterSyncBootstrap() {
 	// Find those processes which do not have yet final results,
 	// they are considered live so we need to compute the temporary
 	// results (or only its weight in case of Encrypted)
-	//prcs, err := idx.ProcessList(nil, 0, 0, "", 0, "", "READY", true)
-	prcs := [][]byte{}
-	err := idx.db.ForEach(
-		badgerhold.Where("FinalResults").Eq(false),
-		func(p *indexertypes.Process) error {
-			prcs = append(prcs, p.ID)
-			return nil
-		})
-
+	queries, ctx, cancel := idx.timeoutQueries()
+	prcs, err := queries.GetProcessIDsByFinalResults(ctx, false)
+	cancel()
 	if err != nil {
 		log.Error(err)
 	}
@@ -368,43 +298,9 @@ func (idx *Indexer) AfterSyncBootstrap() {
 		// Since we cannot be sure if there are votes missing, we need to
 		// perform the full computation.
 		log.Debugf("recovering live process %x", p)
-		process, err := idx.App.State.Process(p, false)
+		results, err := idx.recountLiveResultsUnsafe(p, idx.App.Height())
 		if err != nil {
-			log.Errorf("cannot fetch process: %v", err)
-			continue
-		}
-		options := process.GetVoteOptions()
-		if err := idx.queryWithRetries(func() error {
-			return idx.db.Upsert(p, &indexertypes.Results{
-				ProcessID: p,
-				// MaxValue requires +1 since 0 is also an option
-				Votes:        indexertypes.NewEmptyVotes(int(options.MaxCount), int(options.MaxValue)+1),
-				Weight:       new(types.BigInt).SetUint64(0),
-				VoteOpts:     options,
-				EnvelopeType: process.GetEnvelopeType(),
-				Signatures:   []types.HexBytes{},
-			})
-		}); err != nil {
-			log.Errorw(err, "cannot upsert results to db")
-			continue
-		}
-
-		// Count the votes, add them to results (in memory, without any db transaction)
-		results := &indexertypes.Results{
-			Weight:       new(types.BigInt).SetUint64(0),
-			VoteOpts:     options,
-			EnvelopeType: process.EnvelopeType,
-		}
-		// Get the votes from the state
-		idx.App.State.IterateVotes(p, true, func(vote *models.StateDBVote) bool {
-			if err := idx.addLiveVote(p, vote.VotePackage, new(big.Int).SetBytes(vote.Weight), results); err != nil {
-				log.Errorw(err, "could not add live vote")
-			}
-			return false
-		})
-		// Store the results on the persisten database
-		if err := idx.commitVotesUnsafe(p, results, idx.App.Height()); err != nil {
-			log.Errorw(err, "could not commit live votes")
+			log.Errorw(err, "could not recover live results")
 			continue
 		}
 		log.Infow("partial results recovered", map[string]interface{}{
@@ -421,6 +317,12 @@ func (idx *Indexer) AfterSyncBootstrap() {
 
 // Commit is called by the APP when a block is confirmed and included into the chain
 func (idx *Indexer) Commit(height uint32) error {
+	idx.rebuildLock.Lock()
+	replayed := idx.rebuilding || height < idx.liveFromHeight
+	idx.rebuildLock.Unlock()
+	if replayed {
+		return nil
+	}
 	idx.lockPool.RLock()
 	defer idx.lockPool.RUnlock()
 	// Add Entity and register new active process
@@ -441,9 +343,20 @@ func (idx *Indexer) Commit(height uint32) error {
 		}
 	}
 
+	// Move the processes of the rotated accounts, including the new ones
+	for _, r := range idx.accountRotationPool {
+		if err := idx.newAccountRotation(r, height); err != nil {
+			log.Errorw(err, "commit: cannot index account rotation")
+		}
+	}
+
 	// Index new transactions
 	atomic.AddInt64(&idx.liveGoroutines, 1)
-	go idx.indexNewTxs(idx.newTxPool)
+	if idx.replaying {
+		idx.indexNewTxs(idx.newTxPool)
+	} else {
+		go idx.indexNewTxs(idx.newTxPool)
+	}
 
 	// Schedule results computation
 	for _, p := range idx.resultsPool {
@@ -455,42 +368,13 @@ func (idx *Indexer) Commit(height uint32) error {
 		log.Infof("scheduled results computation on next block for %x", p.ProcessID)
 	}
 
-	startTime := time.Now()
-	txn := idx.db.Badger().NewTransaction(true)
-	for _, v := range idx.voteIndexPool {
-		if err := idx.addVoteIndex(v.vote, v.txIndex, txn); err != nil {
-			log.Errorw(err, "could not index vote")
-		}
-	}
 	if len(idx.voteIndexPool) > 0 {
-		idx.voteTxLock.Lock()
-		wg := sync.WaitGroup{}
-		wg.Add(1)
-		txn.CommitWith(func(err error) {
-			if err != nil {
-				log.Error(err)
-			}
-			wg.Done()
-		})
-		wg.Wait()
-		idx.voteTxLock.Unlock()
-		log.Infof("indexed %d new envelopes, took %s",
-			len(idx.voteIndexPool), time.Since(startTime))
-
-		if enableBadgerhold {
-			if err := idx.db.UpdateMatching(&indexertypes.CountStore{},
-				badgerhold.Where(badgerhold.Key).Eq(indexertypes.CountStoreEnvelopes),
-				func(record interface{}) error {
-					update, ok := record.(*indexertypes.CountStore)
-					if !ok {
-						return fmt.Errorf("record isn't the correct type! Wanted CountStore, got %T", record)
-					}
-					update.Count += uint64(len(idx.voteIndexPool))
-					return nil
-				},
-			); err != nil {
-				log.Errorf("could not get envelope count: %v", err)
-			}
+		startTime := time.Now()
+		if err := idx.addVoteIndexes(idx.voteIndexPool); err != nil {
+			log.Errorw(err, "could not index votes")
+		} else {
+			log.Infof("indexed %d new envelopes, took %s",
+				len(idx.voteIndexPool), time.Since(startTime))
 		}
 	}
 	// index token transfers
@@ -499,12 +383,17 @@ func (idx *Indexer) Commit(height uint32) error {
 			log.Errorw(err, "commit: cannot create new token transfer")
 		}
 	}
-	txn.Discard()
 
-	// Add votes collected by onVote (live results)
+	// Add votes collected by onVote, and the weight changes collected by
+	// OnVoteWeight (live results)
 	nvotes := 0
-	startTime = time.Now()
+	startTime := time.Now()
 
+	for pid := range idx.voteWeightPool {
+		if _, ok := idx.votePool[pid]; !ok {
+			idx.votePool[pid] = nil
+		}
+	}
 	for pid, votes := range idx.votePool {
 		// Get the process information
 		proc, err := idx.ProcessInfo([]byte(pid))
@@ -530,6 +419,11 @@ func (idx *Indexer) Commit(height uint32) error {
 				nvotes++
 			}
 		}
+		for _, w := range idx.voteWeightPool[pid] {
+			if err := idx.addLiveVoteWeight(w, results); err != nil {
+				log.Warnf("vote weight cannot be updated: %v", err)
+			}
+		}
 		// Commit votes (store to disk)
 		if err := idx.commitVotes([]byte(pid), results, idx.App.Height()); err != nil {
 			log.Errorf("cannot commit live votes from block %d: (%v)", err, height)
@@ -547,7 +441,11 @@ func (idx *Indexer) Commit(height uint32) error {
 	// for such an initial height.
 	if height > 0 {
 		atomic.AddInt64(&idx.liveGoroutines, 1)
-		go idx.computePendingProcesses(height)
+		if idx.replaying {
+			idx.computePendingProcesses(height)
+		} else {
+			go idx.computePendingProcesses(height)
+		}
 	}
 	return nil
 }
@@ -562,10 +460,20 @@ func (idx *Indexer) Rollback() {
 	idx.resultsPool = []*indexertypes.IndexerOnProcessData{}
 	idx.updateProcessPool = [][]byte{}
 	idx.newTxPool = []*indexertypes.TxReference{}
+	idx.tokenTransferPool = []*indexertypes.TokenTransferMeta{}
+	idx.accountRotationPool = []*vochaintx.AccountRotation{}
+	idx.voteWeightPool = make(map[string][]*voteWeight)
 }
 
 // OnProcess indexer stores the processID and entityID
 func (idx *Indexer) OnProcess(pid, eid []byte, censusRoot, censusURI string, txIndex int32) {
+	// the processes have no height, so those indexed before resuming an
+	// interrupted rebuild are kept, see startRebuild
+	if idx.replaying {
+		if _, err := idx.ProcessInfo(pid); err == nil {
+			return
+		}
+	}
 	idx.lockPool.Lock()
 	defer idx.lockPool.Unlock()
 	data := &indexertypes.IndexerOnProcessData{EntityID: eid, ProcessID: pid}
@@ -735,10 +643,32 @@ func (idx *Indexer) OnTransferTokens(tx *vochaintx.TokenTransfer) {
 		Amount:    tx.Amount,
 		Height:    uint64(idx.App.Height()),
 		TxHash:    tx.TxHash,
-		Timestamp: time.Now(),
+		Timestamp: idx.eventTime(),
 	})
 }
 
+// OnRotateAccount adds the account rotation to the accountRotationPool
+func (idx *Indexer) OnRotateAccount(rotation *vochaintx.AccountRotation) {
+	idx.lockPool.Lock()
+	defer idx.lockPool.Unlock()
+	idx.accountRotationPool = append(idx.accountRotationPool, rotation)
+}
+
+// OnVoteDelegation does nothing, the weight changes of the votes are received
+// by OnVoteWeight
+func (idx *Indexer) OnVoteDelegation(delegation *vochaintx.VoteDelegation, txIndex int32) {}
+
+// OnVoteWeight stores the weight change of the vote if the processId is live
+// results (on going) and the blockchain is not synchronizing.
+func (idx *Indexer) OnVoteWeight(v *state.Vote, previousWeight *big.Int, txIndex int32) {
+	idx.lockPool.Lock()
+	defer idx.lockPool.Unlock()
+	if !idx.ignoreLiveResults && idx.isProcessLiveResults(v.ProcessID) {
+		idx.voteWeightPool[string(v.ProcessID)] = append(idx.voteWeightPool[string(v.ProcessID)],
+			&voteWeight{vote: v, previousWeight: previousWeight})
+	}
+}
+
 // newTokenTransfer creates a new token transfer and stores it in the database
 func (idx *Indexer) newTokenTransfer(tt *indexertypes.TokenTransferMeta) error {
 	queries, ctx, cancel := idx.timeoutQueries()
@@ -774,7 +704,7 @@ func (idx *Indexer) GetTokenTransfersByFromAccount(from []byte, offset, maxItems
 	if err != nil {
 		return nil, err
 	}
-	tt := make([]*indexertypes.TokenTransferMeta, len(ttFromDB))
+	tt := make([]*indexertypes.TokenTransferMeta, 0, len(ttFromDB))
 	for _, t := range ttFromDB {
 		tt = append(tt, &indexertypes.TokenTransferMeta{
 			Amount:    uint64(t.Amount),
@@ -7,11 +7,17 @@ import (
 	"io"
 	stdlog "log"
 	"math/big"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	qt "github.com/frankban/quicktest"
 	"github.com/pressly/goose/v3"
+	tmtypes "github.com/tendermint/tendermint/types"
+	"github.com/timshannon/badgerhold/v3"
 	"go.vocdoni.io/dvote/crypto/ethereum"
 	"go.vocdoni.io/dvote/crypto/nacl"
 	"go.vocdoni.io/dvote/log"
@@ -19,6 +25,7 @@ import (
 	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/dvote/util"
 	"go.vocdoni.io/dvote/vochain"
+	indexerdb "go.vocdoni.io/dvote/vochain/indexer/db"
 	"go.vocdoni.io/dvote/vochain/indexer/indexertypes"
 	"go.vocdoni.io/dvote/vochain/state"
 	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
@@ -267,9 +274,7 @@ func testProcessList(t *testing.T, procsCount int) {
 	qt.Assert(t, idx.ProcessCount(eidOneProcess), qt.Equals, uint64(1))
 	qt.Assert(t, idx.ProcessCount(eidProcsCount), qt.Equals, uint64(procsCount))
 	qt.Assert(t, idx.ProcessCount(nil), qt.Equals, uint64(10+procsCount))
-	// TODO(mvdan): our badgerhold code errors out unnecessarily in this case.
-	// Reenable this test once we've dropped badgerhold.
-	// qt.Assert(t, idx.ProcessCount([]byte("not an entity id that exists")), qt.Equals, uint64(0))
+	qt.Assert(t, idx.ProcessCount([]byte("not an entity id that exists")), qt.Equals, uint64(0))
 }
 
 func TestProcessSearch(t *testing.T) {
@@ -951,6 +956,145 @@ func TestBallotProtocolMultiChoice(t *testing.T) {
 	qt.Assert(t, votes[4], qt.DeepEquals, []string{"3", "0"})
 }
 
+func TestBallotProtocolRankedChoice(t *testing.T) {
+	// Rank 4 candidates, tallied with instant-runoff voting
+	app := vochain.TestBaseApplication(t)
+
+	idx := newTestIndexer(t, app, true)
+
+	// the same ballots on an election without ranked choice get no rounds
+	pid := util.RandomBytes(32)
+	unranked := util.RandomBytes(32)
+	for _, p := range []*models.Process{
+		{ProcessId: pid, EnvelopeType: &models.EnvelopeType{UniqueValues: true, RankedChoice: true}},
+		{ProcessId: unranked, EnvelopeType: &models.EnvelopeType{UniqueValues: true}},
+	} {
+		p.Status = models.ProcessStatus_READY
+		p.Mode = &models.ProcessMode{AutoStart: true}
+		p.BlockCount = 10
+		p.VoteOptions = &models.ProcessVoteOptions{MaxCount: 4, MaxValue: 3}
+		qt.Assert(t, app.State.AddProcess(p), qt.IsNil)
+	}
+	app.AdvanceTestBlock()
+
+	// Ranked choice (field i holds the candidate ranked in position i):
+	// - Vote Envelopes: 4x`[0,1,2]` 3x`[1,0]` 2x`[2,1]` 1x`[3]`
+	// - Round 1: [4,3,2,1] candidate 3 eliminated
+	// - Round 2: [4,3,2,0] candidate 2 eliminated, 1 exhausted
+	// - Round 3: [4,5,0,0] candidate 1 wins with 5 out of 9
+	ballots := []rankedVotes{{4, []int{0, 1, 2}}, {3, []int{1, 0}}, {2, []int{2, 1}}, {1, []int{3}}}
+	addRankedVotes(t, app, pid, ballots)
+	addRankedVotes(t, app, unranked, ballots)
+	app.AdvanceTestBlock()
+
+	for _, p := range [][]byte{pid, unranked} {
+		qt.Assert(t, idx.setResultsHeight(p, app.Height()), qt.IsNil)
+		qt.Assert(t, idx.ComputeResult(p), qt.IsNil)
+	}
+
+	result, err := idx.GetResults(unranked)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, result.RankedRounds, qt.IsNil)
+
+	result, err = idx.GetResults(pid)
+	qt.Assert(t, err, qt.IsNil)
+	votes := GetFriendlyResults(result.Votes)
+	qt.Assert(t, votes[0], qt.DeepEquals, []string{"4", "3", "2", "1"})
+
+	rounds := result.RankedRounds
+	qt.Assert(t, rounds, qt.HasLen, 3)
+	qt.Assert(t, friendlyRankedTally(rounds[0].Tally), qt.DeepEquals, []string{"4", "3", "2", "1"})
+	qt.Assert(t, *rounds[0].Eliminated, qt.Equals, 3)
+	qt.Assert(t, rounds[0].Winner, qt.IsNil)
+	qt.Assert(t, friendlyRankedTally(rounds[1].Tally), qt.DeepEquals, []string{"4", "3", "2", "0"})
+	qt.Assert(t, rounds[1].Exhausted.String(), qt.Equals, "1")
+	qt.Assert(t, *rounds[1].Eliminated, qt.Equals, 2)
+	qt.Assert(t, friendlyRankedTally(rounds[2].Tally), qt.DeepEquals, []string{"4", "5", "0", "0"})
+	qt.Assert(t, rounds[2].Eliminated, qt.IsNil)
+	qt.Assert(t, *rounds[2].Winner, qt.Equals, 1)
+}
+
+func TestBallotProtocolSingleTransferableVote(t *testing.T) {
+	// Rank 4 candidates for 2 seats, tallied with single transferable vote
+	app := vochain.TestBaseApplication(t)
+
+	idx := newTestIndexer(t, app, true)
+
+	pid := util.RandomBytes(32)
+	err := app.State.AddProcess(&models.Process{
+		ProcessId:    pid,
+		EnvelopeType: &models.EnvelopeType{UniqueValues: true, RankedChoice: true},
+		Status:       models.ProcessStatus_READY,
+		Mode:         &models.ProcessMode{AutoStart: true},
+		BlockCount:   10,
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 4, MaxValue: 3, Seats: 2},
+	})
+	qt.Assert(t, err, qt.IsNil)
+	app.AdvanceTestBlock()
+
+	// - Vote Envelopes: 3x`[0,1]` 2x`[0,2]` 2x`[1]` 2x`[2,3]` 1x`[3]`
+	// - Quota: 10/3+1 = 4
+	// - Round 1: [5,2,2,1] candidate 0 elected, surplus 1 transferred at 1/5
+	// - Round 2: [4,2.6,2.4,1] candidate 3 eliminated
+	// - Round 3: [4,2.6,2.4,0] candidate 2 eliminated, 1 exhausted
+	// - Round 4: [4,2.6,0,0] candidate 1 elected, 3.4 exhausted
+	addRankedVotes(t, app, pid, []rankedVotes{
+		{3, []int{0, 1}}, {2, []int{0, 2}}, {2, []int{1}}, {2, []int{2, 3}}, {1, []int{3}},
+	})
+	app.AdvanceTestBlock()
+
+	err = idx.setResultsHeight(pid, app.Height())
+	qt.Assert(t, err, qt.IsNil)
+	err = idx.ComputeResult(pid)
+	qt.Assert(t, err, qt.IsNil)
+
+	result, err := idx.GetResults(pid)
+	qt.Assert(t, err, qt.IsNil)
+	rounds := result.RankedRounds
+	qt.Assert(t, rounds, qt.HasLen, 4)
+	qt.Assert(t, rounds[0].Quota.String(), qt.Equals, "4")
+	qt.Assert(t, friendlyRankedTally(rounds[0].Tally), qt.DeepEquals, []string{"5", "2", "2", "1"})
+	qt.Assert(t, rounds[0].Elected, qt.DeepEquals, []int{0})
+	qt.Assert(t, friendlyRankedTally(rounds[1].Tally), qt.DeepEquals,
+		[]string{"4", "2.600000", "2.400000", "1"})
+	qt.Assert(t, *rounds[1].Eliminated, qt.Equals, 3)
+	qt.Assert(t, friendlyRankedTally(rounds[2].Tally), qt.DeepEquals,
+		[]string{"4", "2.600000", "2.400000", "0"})
+	qt.Assert(t, rounds[2].Exhausted.String(), qt.Equals, "1")
+	qt.Assert(t, *rounds[2].Eliminated, qt.Equals, 2)
+	qt.Assert(t, friendlyRankedTally(rounds[3].Tally), qt.DeepEquals, []string{"4", "2.600000", "0", "0"})
+	qt.Assert(t, rounds[3].Exhausted.String(), qt.Equals, "3.400000")
+	qt.Assert(t, rounds[3].Elected, qt.DeepEquals, []int{1})
+}
+
+// rankedVotes is a number of votes casting the same ranked ballot.
+type rankedVotes struct {
+	n      int
+	ballot []int
+}
+
+func addRankedVotes(t *testing.T, app *vochain.BaseApplication, pid []byte, votes []rankedVotes) {
+	for _, v := range votes {
+		vp, err := json.Marshal(vochain.VotePackage{
+			Nonce: fmt.Sprintf("%x", util.RandomHex(32)),
+			Votes: v.ballot,
+		})
+		qt.Assert(t, err, qt.IsNil)
+		for i := 0; i < v.n; i++ {
+			vote := &state.Vote{ProcessID: pid, VotePackage: vp, Nullifier: util.RandomBytes(32)}
+			qt.Assert(t, app.State.AddVote(vote), qt.IsNil)
+		}
+	}
+}
+
+func friendlyRankedTally(tally []*indexertypes.RankedTally) []string {
+	s := []string{}
+	for _, t := range tally {
+		s = append(s, t.String())
+	}
+	return s
+}
+
 func TestAfterSyncBootStrap(t *testing.T) {
 	app := vochain.TestBaseApplication(t)
 	dataDir := t.TempDir()
@@ -1169,6 +1313,487 @@ func TestOverwriteVotes(t *testing.T) {
 	qt.Assert(t, envelope.VotePackage, qt.DeepEquals, vp)
 }
 
+func TestPrunedVotes(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	app.State.SetVotesPruning(2)
+	idx := newTestIndexer(t, app, true)
+	pid := util.RandomBytes(32)
+	eid := util.RandomBytes(20)
+	oracle := common.HexToAddress("0x309Bd6959bf4289CDf9c7198cF9f4494e0244b7d")
+	keys, root, proofs := testvoteproof.CreateKeysAndBuildCensus(t, 1)
+
+	qt.Assert(t, app.State.AddOracle(oracle), qt.IsNil)
+	err := app.State.AddProcess(&models.Process{
+		CensusRoot:   root,
+		CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+		ProcessId:    pid,
+		EntityId:     eid,
+		EnvelopeType: &models.EnvelopeType{EncryptedVotes: false},
+		Status:       models.ProcessStatus_READY,
+		Mode:         &models.ProcessMode{AutoStart: true},
+		StartBlock:   app.Height(),
+		BlockCount:   2,
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 3, MaxValue: 2},
+	})
+	qt.Assert(t, err, qt.IsNil)
+	app.AdvanceTestBlock()
+
+	vp, err := json.Marshal(vochain.VotePackage{Votes: []int{1, 1, 1}})
+	qt.Assert(t, err, qt.IsNil)
+	vote := &models.VoteEnvelope{
+		Nonce: util.RandomBytes(32),
+		Proof: &models.Proof{Payload: &models.Proof_Arbo{
+			Arbo: &models.ProofArbo{
+				Type:     models.ProofArbo_BLAKE2B,
+				Siblings: proofs[0],
+				KeyType:  models.ProofArbo_PUBKEY,
+			}}},
+		ProcessId:   pid,
+		VotePackage: vp,
+	}
+	voteTx, err := proto.Marshal(&models.Tx{Payload: &models.Tx_Vote{Vote: vote}})
+	qt.Assert(t, err, qt.IsNil)
+	signature, err := keys[0].SignVocdoniTx(voteTx, app.ChainID())
+	qt.Assert(t, err, qt.IsNil)
+	signedTx, err := proto.Marshal(&models.SignedTx{Tx: voteTx, Signature: signature})
+	qt.Assert(t, err, qt.IsNil)
+	response, err := app.SendTx(signedTx)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, response.Code, qt.Equals, uint32(0))
+	nullifier := response.Data.Bytes()
+	app.AdvanceTestBlock()
+	app.AdvanceTestBlock()
+
+	// set the results once the process ended, so the votes are pruned two
+	// blocks later
+	qt.Assert(t, app.State.SetProcessResults(pid, &models.ProcessResult{
+		ProcessId:     pid,
+		EntityId:      eid,
+		OracleAddress: oracle.Bytes(),
+	}, true), qt.IsNil)
+	for i := 0; i < 3; i++ {
+		app.AdvanceTestBlock()
+	}
+	pruned, count, err := app.State.VotesPruned(pid, true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, pruned, qt.IsTrue)
+	qt.Assert(t, count, qt.Equals, uint32(1))
+
+	// the indexer keeps serving the votes
+	envelope, err := idx.GetEnvelope(nullifier)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, envelope.VotePackage, qt.DeepEquals, vp)
+	walked := 0
+	qt.Assert(t, idx.WalkEnvelopes(pid, false, func(v *models.StateDBVote) {
+		qt.Assert(t, v.VotePackage, qt.DeepEquals, vp)
+		walked++
+	}), qt.IsNil)
+	qt.Assert(t, walked, qt.Equals, 1)
+}
+
+func TestVoteDelegationLiveResults(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	idx := newTestIndexer(t, app, true)
+	pid := util.RandomBytes(32)
+	keys, root, proofs := testvoteproof.CreateKeysAndBuildCensus(t, 3)
+
+	err := app.State.AddProcess(&models.Process{
+		CensusRoot:   root,
+		CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+		ProcessId:    pid,
+		EnvelopeType: &models.EnvelopeType{EncryptedVotes: false},
+		Status:       models.ProcessStatus_READY,
+		Mode:         &models.ProcessMode{AutoStart: true},
+		BlockCount:   10,
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 3, MaxValue: 2},
+	})
+	qt.Assert(t, err, qt.IsNil)
+	app.AdvanceTestBlock()
+
+	// sendVote sends the vote of the key, or its delegation if delegate is set
+	sendVote := func(key int, votes []int, delegate *int) {
+		stx := testvoteproof.BuildSignedVoteForOffChainTree(t, pid, keys[key], proofs[key],
+			nil, app.ChainID())
+		tx := &models.Tx{}
+		qt.Assert(t, proto.Unmarshal(stx.Tx, tx), qt.IsNil)
+		if delegate != nil {
+			delegateTx := &models.Tx{}
+			qt.Assert(t, proto.Unmarshal(testvoteproof.BuildSignedVoteForOffChainTree(t, pid,
+				keys[*delegate], proofs[*delegate], nil, app.ChainID()).Tx, delegateTx), qt.IsNil)
+			tx.GetVote().VotePackage = nil
+			tx.GetVote().Delegate = keys[*delegate].Address().Bytes()
+			tx.GetVote().DelegateProof = delegateTx.GetVote().Proof
+			tx.GetVote().DelegatePublicKey = keys[*delegate].PublicKey()
+		} else {
+			tx.GetVote().VotePackage, err = json.Marshal(vochain.VotePackage{Votes: votes})
+			qt.Assert(t, err, qt.IsNil)
+		}
+		stx.Tx, err = proto.Marshal(tx)
+		qt.Assert(t, err, qt.IsNil)
+		stx.Signature, err = keys[key].SignVocdoniTx(stx.Tx, app.ChainID())
+		qt.Assert(t, err, qt.IsNil)
+		signedTx, err := proto.Marshal(stx)
+		qt.Assert(t, err, qt.IsNil)
+		response, err := app.SendTx(signedTx)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, response.Code, qt.Equals, uint32(0), qt.Commentf("%s", response.Data))
+		app.AdvanceTestBlock()
+	}
+	checkResults := func(weight int64, option1, option2 int64) {
+		results, err := idx.GetResults(pid)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, results.Weight.ToInt().Int64(), qt.Equals, weight)
+		qt.Assert(t, results.Votes[0][1].ToInt().Int64(), qt.Equals, option1)
+		qt.Assert(t, results.Votes[0][2].ToInt().Int64(), qt.Equals, option2)
+	}
+
+	// the second voter delegates to the first one, who votes with both weights
+	first := 0
+	sendVote(1, nil, &first)
+	sendVote(0, []int{1, 1, 1}, nil)
+	checkResults(2, 2, 0)
+
+	// a delegation adds its weight to the vote already counted
+	sendVote(2, nil, &first)
+	checkResults(3, 3, 0)
+
+	// the delegator overrides the delegation, so its weight is moved
+	sendVote(1, []int{2, 2, 2}, nil)
+	checkResults(3, 2, 1)
+}
+
+func TestAccountRotation(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	idx := newTestIndexer(t, app, true)
+
+	from := ethereum.SignKeys{}
+	qt.Assert(t, from.Generate(), qt.IsNil)
+	to := ethereum.SignKeys{}
+	qt.Assert(t, to.Generate(), qt.IsNil)
+	qt.Assert(t, app.State.SetAccount(from.Address(), &state.Account{}), qt.IsNil)
+	for i := 0; i < 3; i++ {
+		qt.Assert(t, app.State.AddProcess(&models.Process{
+			ProcessId:    util.RandomBytes(32),
+			EntityId:     from.Address().Bytes(),
+			BlockCount:   10,
+			VoteOptions:  &models.ProcessVoteOptions{MaxCount: 8, MaxValue: 3},
+			EnvelopeType: &models.EnvelopeType{},
+		}), qt.IsNil)
+	}
+	app.AdvanceTestBlock()
+	pids, err := idx.ProcessList(from.Address().Bytes(), 0, 10, "", 0, "", "", false)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, pids, qt.HasLen, 3)
+
+	qt.Assert(t, app.State.RotateAccount(from.Address(), to.Address(), util.RandomBytes(32)),
+		qt.IsNil)
+	app.AdvanceTestBlock()
+
+	// the elections of the organization are moved to the new address
+	resolved, err := idx.ResolveAccount(from.Address().Bytes())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resolved, qt.DeepEquals, types.HexBytes(to.Address().Bytes()))
+	resolved, err = idx.ResolveAccount(to.Address().Bytes())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resolved, qt.DeepEquals, types.HexBytes(to.Address().Bytes()))
+	previous, err := idx.PreviousAccounts(to.Address().Bytes())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, previous, qt.DeepEquals, []types.HexBytes{from.Address().Bytes()})
+
+	pids2, err := idx.ProcessList(to.Address().Bytes(), 0, 10, "", 0, "", "", false)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, pids2, qt.DeepEquals, pids)
+	count, err := idx.EntityProcessCount(to.Address().Bytes())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, count, qt.Equals, uint32(3))
+	qt.Assert(t, idx.EntityCount(), qt.Equals, uint64(1))
+	proc, err := idx.ProcessInfo(pids[0])
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proc.EntityID, qt.DeepEquals, types.HexBytes(to.Address().Bytes()))
+}
+
+func TestElectionStats(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	idx := newTestIndexer(t, app, true)
+	pid := util.RandomBytes(32)
+	keys, root, proofs := testvoteproof.CreateKeysAndBuildCensus(t, 4)
+
+	qt.Assert(t, app.State.AddProcess(&models.Process{
+		CensusRoot:   root,
+		CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+		ProcessId:    pid,
+		EnvelopeType: &models.EnvelopeType{},
+		Status:       models.ProcessStatus_READY,
+		Mode:         &models.ProcessMode{AutoStart: true},
+		BlockCount:   100,
+		VoteOptions: &models.ProcessVoteOptions{
+			MaxCount:          3,
+			MaxValue:          2,
+			MaxVoteOverwrites: 1,
+		},
+	}), qt.IsNil)
+	app.AdvanceTestBlock()
+
+	vote := func(i int, votes []int) {
+		vp, err := json.Marshal(vochain.VotePackage{Votes: votes})
+		qt.Assert(t, err, qt.IsNil)
+		voteTx, err := proto.Marshal(&models.Tx{Payload: &models.Tx_Vote{Vote: &models.VoteEnvelope{
+			Nonce: util.RandomBytes(32),
+			Proof: &models.Proof{Payload: &models.Proof_Arbo{
+				Arbo: &models.ProofArbo{
+					Type:     models.ProofArbo_BLAKE2B,
+					Siblings: proofs[i],
+					KeyType:  models.ProofArbo_PUBKEY,
+				}}},
+			ProcessId:   pid,
+			VotePackage: vp,
+		}}})
+		qt.Assert(t, err, qt.IsNil)
+		signature, err := keys[i].SignVocdoniTx(voteTx, app.ChainID())
+		qt.Assert(t, err, qt.IsNil)
+		signedTx, err := proto.Marshal(&models.SignedTx{Tx: voteTx, Signature: signature})
+		qt.Assert(t, err, qt.IsNil)
+		response, err := app.SendTx(signedTx)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, response.Code, qt.Equals, uint32(0), qt.Commentf("%s", response.Data))
+	}
+	// the second voter leaves the last question unanswered, and the
+	// third voter overwrites its vote leaving the last two unanswered
+	vote(0, []int{1, 1, 1})
+	vote(1, []int{1, 1})
+	app.AdvanceTestBlock()
+	vote(2, []int{2, 2, 2})
+	app.AdvanceTestBlock()
+	vote(2, []int{2})
+	app.AdvanceTestBlock()
+	app.AdvanceTestBlock()
+
+	_, err := idx.ElectionStats(pid, 0, time.Hour)
+	qt.Assert(t, err, qt.IsNotNil)
+	stats, err := idx.ElectionStats(pid, 1, time.Hour)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, stats.Votes, qt.Equals, uint64(3))
+	qt.Assert(t, stats.Overwrites, qt.Equals, uint64(1))
+	qt.Assert(t, stats.Weight.String(), qt.Equals, "3")
+	qt.Assert(t, stats.Abstentions, qt.DeepEquals, []uint64{0, 1, 2})
+
+	// the votes indexed before their answers were stored are not decoded by
+	// the stats, but once the results are computed; the votes without a vote
+	// package are left unknown instead of invalid
+	_, err = idx.sqlDB.Exec("UPDATE vote_references SET answers = -1")
+	qt.Assert(t, err, qt.IsNil)
+	_, err = idx.sqlDB.Exec("UPDATE vote_references SET vote_package = X'' WHERE nullifier = ?",
+		state.GenerateNullifier(keys[1].Address(), pid))
+	qt.Assert(t, err, qt.IsNil)
+	stats, err = idx.ElectionStats(pid, 1, time.Hour)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, stats.Abstentions, qt.DeepEquals, []uint64{0, 0, 0})
+	queries, ctx, cancel := idx.timeoutQueries()
+	defer cancel()
+	undecoded, err := queries.GetUndecodedVoteReferences(ctx, pid)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, undecoded, qt.HasLen, 3)
+	qt.Assert(t, idx.ComputeResult(pid), qt.IsNil)
+	stats, err = idx.ElectionStats(pid, 1, time.Hour)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, stats.Abstentions, qt.DeepEquals, []uint64{0, 1, 1})
+	undecoded, err = queries.GetUndecodedVoteReferences(ctx, pid)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, undecoded, qt.HasLen, 1)
+
+	// the overwritten vote is counted at its first cast
+	qt.Assert(t, stats.ByHeight, qt.HasLen, 2)
+	first, last := stats.ByHeight[0], stats.ByHeight[1]
+	qt.Assert(t, last.Start, qt.Equals, first.Start+1)
+	qt.Assert(t, first.Votes, qt.Equals, uint64(2))
+	qt.Assert(t, first.Overwrites, qt.Equals, uint64(0))
+	qt.Assert(t, last.Votes, qt.Equals, uint64(1))
+	qt.Assert(t, last.Overwrites, qt.Equals, uint64(1))
+	qt.Assert(t, last.Weight.String(), qt.Equals, "1")
+	qt.Assert(t, last.CumulativeVotes, qt.Equals, uint64(3))
+	qt.Assert(t, last.CumulativeWeight.String(), qt.Equals, "3")
+
+	// all the votes were cast within the same hour, unless the test runs
+	// just when the hour changes
+	stats, err = idx.ElectionStats(pid, 1000, time.Hour)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, stats.ByHeight, qt.HasLen, 1)
+	qt.Assert(t, stats.ByHeight[0].Start, qt.Equals, int64(0))
+	qt.Assert(t, stats.ByHeight[0].CumulativeVotes, qt.Equals, uint64(3))
+	qt.Assert(t, len(stats.ByTime) > 0, qt.IsTrue)
+	lastTime := stats.ByTime[len(stats.ByTime)-1]
+	qt.Assert(t, lastTime.Start%3600, qt.Equals, int64(0))
+	qt.Assert(t, lastTime.CumulativeVotes, qt.Equals, uint64(3))
+	qt.Assert(t, lastTime.CumulativeWeight.String(), qt.Equals, "3")
+
+	// the weights too large for SQLite are summed by the indexer
+	_, err = idx.sqlDB.Exec("UPDATE vote_references SET weight = '100000000000000000000' WHERE nullifier = ?",
+		state.GenerateNullifier(keys[0].Address(), pid))
+	qt.Assert(t, err, qt.IsNil)
+	stats, err = idx.ElectionStats(pid, 1000, time.Hour)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, stats.Weight.String(), qt.Equals, "100000000000000000002")
+
+	// the number of buckets is limited
+	_, err = idx.sqlDB.Exec(`WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i+1 FROM n WHERE i < ?)
+		INSERT INTO vote_references (nullifier, process_id, height, weight, tx_index,
+			creation_time, first_height, first_creation_time)
+		SELECT randomblob(32), ?, i, '1', 0, datetime('now'), 1000 + i, datetime('now') FROM n`,
+		MaxStatsBuckets, pid)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = idx.ElectionStats(pid, 1, time.Hour)
+	qt.Assert(t, err, qt.ErrorMatches, "electionStats: more than .* buckets.*")
+	stats, err = idx.ElectionStats(pid, 2, time.Hour)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, stats.Votes, qt.Equals, uint64(MaxStatsBuckets+3))
+}
+
+func TestMetadataSearch(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	idx := newTestIndexer(t, app, true)
+	if !idx.searchEnabled {
+		t.Skip("sqlite is built without FTS5")
+	}
+
+	org1 := util.RandomBytes(20)
+	org2 := util.RandomBytes(20)
+	pids := [][]byte{}
+	for _, org := range [][]byte{org1, org1, org2} {
+		pid := util.RandomBytes(32)
+		qt.Assert(t, app.State.AddProcess(&models.Process{
+			ProcessId:    pid,
+			EntityId:     org,
+			BlockCount:   10,
+			Status:       models.ProcessStatus_READY,
+			VoteOptions:  &models.ProcessVoteOptions{MaxCount: 8, MaxValue: 3},
+			EnvelopeType: &models.EnvelopeType{},
+		}), qt.IsNil)
+		pids = append(pids, pid)
+	}
+	app.AdvanceTestBlock()
+
+	qt.Assert(t, idx.SetElectionMetadata(pids[0], []*indexertypes.MetadataText{
+		{Language: "en", Title: "Participatory budget 2023", Description: "Vote the projects"},
+		{Language: "es", Title: "Presupuestos participativos 2023", Description: "Vota los proyectos"},
+	}), qt.IsNil)
+	qt.Assert(t, idx.SetElectionMetadata(pids[1], []*indexertypes.MetadataText{
+		{Language: "en", Title: "Board election", Description: "Approve the annual budget",
+			Questions: "Which candidate? Alice Bob"},
+	}), qt.IsNil)
+	qt.Assert(t, idx.SetElectionMetadata(pids[2], []*indexertypes.MetadataText{
+		{Language: "en", Title: "Budgets of the city", Description: "Choose the budgets"},
+	}), qt.IsNil)
+	qt.Assert(t, idx.SetAccountMetadata(org1, []*indexertypes.MetadataText{
+		{Language: "ca", Title: "Ajuntament de Vocdoni", Description: "Pressupostos participatius"},
+	}), qt.IsNil)
+
+	ids := func(results []*indexertypes.MetadataSearchResult) [][]byte {
+		ids := [][]byte{}
+		for _, r := range results {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+	// the title matches rank first, and the words match as prefixes
+	results, err := idx.SearchElections("budget", "", nil, "", 0, 10)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, results, qt.HasLen, 3)
+	qt.Assert(t, ids(results)[2], qt.DeepEquals, pids[1])
+	qt.Assert(t, results[0].Score >= results[1].Score, qt.IsTrue)
+	qt.Assert(t, results[1].Score > results[2].Score, qt.IsTrue)
+
+	// each election is returned once, with its best matching language
+	results, err = idx.SearchElections("presupuestos 2023", "", nil, "", 0, 10)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, ids(results), qt.DeepEquals, [][]byte{pids[0]})
+	qt.Assert(t, results[0].Language, qt.Equals, "es")
+	qt.Assert(t, results[0].EntityID, qt.DeepEquals, types.HexBytes(org1))
+	qt.Assert(t, results[0].Status, qt.Equals, int32(models.ProcessStatus_READY))
+	results, err = idx.SearchElections("2023", "en", nil, "", 0, 10)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, results, qt.HasLen, 1)
+	qt.Assert(t, results[0].Language, qt.Equals, "en")
+
+	// the questions are searchable too
+	results, err = idx.SearchElections("alice", "", nil, "", 0, 10)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, ids(results), qt.DeepEquals, [][]byte{pids[1]})
+
+	// filters and pagination
+	results, err = idx.SearchElections("budget", "", org2, "", 0, 10)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, ids(results), qt.DeepEquals, [][]byte{pids[2]})
+	results, err = idx.SearchElections("budget", "", nil, "ENDED", 0, 10)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, results, qt.HasLen, 0)
+	_, err = idx.SearchElections("budget", "", nil, "UNKNOWN", 0, 10)
+	qt.Assert(t, err, qt.IsNotNil)
+	page1, err := idx.SearchElections("budget", "", nil, "", 0, 2)
+	qt.Assert(t, err, qt.IsNil)
+	page2, err := idx.SearchElections("budget", "", nil, "", 2, 2)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, append(ids(page1), ids(page2)...), qt.HasLen, 3)
+	qt.Assert(t, ids(page2), qt.DeepEquals, [][]byte{pids[1]})
+
+	// the query operators are not interpreted
+	results, err = idx.SearchElections(`budget OR "board`, "", nil, "", 0, 10)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, results, qt.HasLen, 0)
+	_, err = idx.SearchElections(" ,; ", "", nil, "", 0, 10)
+	qt.Assert(t, err, qt.IsNotNil)
+
+	// the metadata is replaced
+	qt.Assert(t, idx.SetElectionMetadata(pids[2], []*indexertypes.MetadataText{
+		{Language: "en", Title: "City council", Description: "Choose the council"},
+	}), qt.IsNil)
+	results, err = idx.SearchElections("budget", "", nil, "", 0, 10)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, results, qt.HasLen, 2)
+
+	// accounts are searched apart, ignoring the diacritics
+	results, err = idx.SearchAccounts("pressupostos", "", 0, 10)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, ids(results), qt.DeepEquals, [][]byte{org1})
+	results, err = idx.SearchAccounts("pressupostos", "en", 0, 10)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, results, qt.HasLen, 0)
+	results, err = idx.SearchAccounts("ajuntamént", "", 0, 10)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, results, qt.HasLen, 1)
+}
+
+func TestMetadataSearchDisabled(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	idx := newTestIndexer(t, app, true)
+	if !idx.searchEnabled {
+		t.Skip("sqlite is built without FTS5")
+	}
+	org := util.RandomBytes(20)
+	qt.Assert(t, idx.SetAccountMetadata(org, []*indexertypes.MetadataText{
+		{Language: "en", Title: "Vocdoni city council"},
+	}), qt.IsNil)
+
+	// without FTS5, the texts are still stored but cannot be searched
+	qt.Assert(t, idx.setupMetadataSearch(false), qt.IsNil)
+	_, err := idx.SearchAccounts("council", "", 0, 10)
+	qt.Assert(t, err, qt.ErrorIs, ErrSearchDisabled)
+	_, err = idx.SearchElections("council", "", nil, "", 0, 10)
+	qt.Assert(t, err, qt.ErrorIs, ErrSearchDisabled)
+	qt.Assert(t, idx.SetAccountMetadata(org, []*indexertypes.MetadataText{
+		{Language: "en", Title: "Vocdoni town hall"},
+	}), qt.IsNil)
+
+	// once enabled again, the texts stored meanwhile are indexed
+	qt.Assert(t, idx.setupMetadataSearch(true), qt.IsNil)
+	results, err := idx.SearchAccounts("council", "", 0, 10)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, results, qt.HasLen, 0)
+	results, err = idx.SearchAccounts("town hall", "", 0, 10)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, results, qt.HasLen, 1)
+	qt.Assert(t, results[0].ID, qt.DeepEquals, types.HexBytes(org))
+}
+
 func TestTxIndexer(t *testing.T) {
 	app := vochain.TestBaseApplication(t)
 	idx := newTestIndexer(t, app, true)
@@ -1229,6 +1854,98 @@ func TestTxIndexer(t *testing.T) {
 	qt.Assert(t, txs[0].Index, qt.Equals, uint64(95))
 }
 
+func TestBadgerholdMigration(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	dataDir := filepath.Join(t.TempDir(), "indexer")
+
+	// Populate an old badgerhold indexer database.
+	opts := badgerhold.DefaultOptions
+	opts.Dir = dataDir
+	opts.ValueDir = dataDir
+	opts.Options = opts.WithLogger(nil)
+	opts.Logger = nil
+	store, err := badgerhold.Open(opts)
+	qt.Assert(t, err, qt.IsNil)
+
+	pid := util.RandomBytes(32)
+	eid := util.RandomBytes(20)
+	nullifier := util.RandomBytes(32)
+	txHash := util.RandomBytes(32)
+	qt.Assert(t, store.Insert(pid, &indexertypes.Process{
+		ID:           pid,
+		EntityID:     eid,
+		StartBlock:   10,
+		EndBlock:     20,
+		Status:       int32(models.ProcessStatus_RESULTS),
+		HaveResults:  true,
+		FinalResults: true,
+		Envelope:     &models.EnvelopeType{},
+		Mode:         &models.ProcessMode{},
+		VoteOpts:     &models.ProcessVoteOptions{MaxCount: 2, MaxValue: 1},
+		CreationTime: time.Unix(1000, 0),
+	}), qt.IsNil)
+	qt.Assert(t, store.Insert(pid, &indexertypes.Results{
+		ProcessID:      pid,
+		Votes:          [][]*types.BigInt{{new(types.BigInt).SetUint64(1), new(types.BigInt).SetUint64(2)}},
+		Weight:         new(types.BigInt).SetUint64(3),
+		EnvelopeHeight: 1,
+		BlockHeight:    20,
+	}), qt.IsNil)
+	qt.Assert(t, store.Insert(nullifier, &indexertypes.VoteReference{
+		Nullifier:    nullifier,
+		ProcessID:    pid,
+		Height:       15,
+		Weight:       new(types.BigInt).SetUint64(3),
+		CreationTime: time.Unix(1500, 0),
+	}), qt.IsNil)
+	qt.Assert(t, store.Insert(uint64(1), &indexertypes.TxReference{
+		Index:       1,
+		Hash:        txHash,
+		BlockHeight: 15,
+		TxType:      "vote",
+	}), qt.IsNil)
+	qt.Assert(t, store.Close(), qt.IsNil)
+
+	// The data is imported when the indexer is created.
+	idx, err := newTestIndexerNoCleanup(dataDir, app, true)
+	qt.Assert(t, err, qt.IsNil)
+	proc, err := idx.ProcessInfo(pid)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proc.EntityID, qt.DeepEquals, types.HexBytes(eid))
+	qt.Assert(t, proc.EndBlock, qt.Equals, uint32(20))
+	qt.Assert(t, proc.FinalResults, qt.IsTrue)
+	results, err := idx.GetResults(pid)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, results.Votes[0][1].String(), qt.Equals, "2")
+	qt.Assert(t, results.Weight.String(), qt.Equals, "3")
+	vote, err := idx.GetEnvelopeReference(nullifier)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, vote.Height, qt.Equals, uint32(15))
+	qt.Assert(t, vote.Weight.String(), qt.Equals, "3")
+	txRef, err := idx.GetTxReference(1)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, txRef.Hash, qt.DeepEquals, types.HexBytes(txHash))
+	qt.Assert(t, txRef.TxType, qt.Equals, "vote")
+	// the participants of the imported transactions are backfilled
+	queries, ctx, cancel := idx.timeoutQueries()
+	status, err := queries.GetTxBackfillStatus(ctx)
+	cancel()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, status.NextID, qt.Equals, int64(1))
+	qt.Assert(t, status.LastID, qt.Equals, int64(1))
+	qt.Assert(t, idx.Close(), qt.IsNil)
+
+	// The old database is moved away, so it is only imported once.
+	_, err = os.Stat(dataDir + badgerholdMigratedSuffix)
+	qt.Assert(t, err, qt.IsNil)
+	idx, err = newTestIndexerNoCleanup(dataDir, app, true)
+	qt.Assert(t, err, qt.IsNil)
+	count, err := idx.TransactionCount()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, count, qt.Equals, uint64(1))
+	qt.Assert(t, idx.Close(), qt.IsNil)
+}
+
 // Test that we can do concurrent reads and writes to sqlite without running
 // into "database is locked" errors.
 func TestIndexerConcurrentDB(t *testing.T) {
@@ -1270,3 +1987,397 @@ func TestIndexerConcurrentDB(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestRebuild(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	idx := newTestIndexer(t, app, true)
+
+	org := ethereum.SignKeys{}
+	qt.Assert(t, org.Generate(), qt.IsNil)
+	to := ethereum.SignKeys{}
+	qt.Assert(t, to.Generate(), qt.IsNil)
+	qt.Assert(t, app.State.SetAccount(state.BurnAddress, &state.Account{}), qt.IsNil)
+	qt.Assert(t, app.State.SetTxCost(models.TxType_NEW_PROCESS, 10), qt.IsNil)
+	qt.Assert(t, app.State.SetTxCost(models.TxType_SEND_TOKENS, 10), qt.IsNil)
+	qt.Assert(t, app.State.CreateAccount(org.Address(), "", nil, 0), qt.IsNil)
+	qt.Assert(t, app.State.CreateAccount(to.Address(), "", nil, 0), qt.IsNil)
+	qt.Assert(t, app.State.MintBalance(&vochaintx.TokenTransfer{
+		ToAddress: org.Address(),
+		Amount:    1000,
+	}), qt.IsNil)
+	app.AdvanceTestBlock()
+
+	sendTx := func(signer *ethereum.SignKeys, tx *models.Tx) []byte {
+		txBytes, err := proto.Marshal(tx)
+		qt.Assert(t, err, qt.IsNil)
+		signature, err := signer.SignVocdoniTx(txBytes, app.ChainID())
+		qt.Assert(t, err, qt.IsNil)
+		signedTx, err := proto.Marshal(&models.SignedTx{Tx: txBytes, Signature: signature})
+		qt.Assert(t, err, qt.IsNil)
+		response, err := app.SendTx(signedTx)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, response.Code, qt.Equals, uint32(0), qt.Commentf("%s", response.Data))
+		app.AdvanceTestBlock()
+		return response.Data.Bytes()
+	}
+
+	keys, root, proofs := testvoteproof.CreateKeysAndBuildCensus(t, 2)
+	newProcess := func(nonce uint32) *models.Tx {
+		return &models.Tx{Payload: &models.Tx_NewProcess{NewProcess: &models.NewProcessTx{
+			Txtype: models.TxType_NEW_PROCESS,
+			Nonce:  nonce,
+			Process: &models.Process{
+				CensusRoot:   root,
+				CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+				EnvelopeType: &models.EnvelopeType{},
+				Status:       models.ProcessStatus_READY,
+				Mode:         &models.ProcessMode{AutoStart: true},
+				BlockCount:   100,
+				VoteOptions:  &models.ProcessVoteOptions{MaxCount: 3, MaxValue: 2},
+			},
+		}}}
+	}
+	pid := sendTx(&org, newProcess(0))
+	vp, err := json.Marshal(vochain.VotePackage{Votes: []int{1, 2, 0}})
+	qt.Assert(t, err, qt.IsNil)
+	nullifier := sendTx(keys[0], &models.Tx{Payload: &models.Tx_Vote{Vote: &models.VoteEnvelope{
+		Nonce: util.RandomBytes(32),
+		Proof: &models.Proof{Payload: &models.Proof_Arbo{
+			Arbo: &models.ProofArbo{
+				Type:     models.ProofArbo_BLAKE2B,
+				Siblings: proofs[0],
+				KeyType:  models.ProofArbo_PUBKEY,
+			}}},
+		ProcessId:   pid,
+		VotePackage: vp,
+	}}})
+	sendTokens := func(nonce uint32) {
+		sendTx(&org, &models.Tx{Payload: &models.Tx_SendTokens{SendTokens: &models.SendTokensTx{
+			Txtype: models.TxType_SEND_TOKENS,
+			From:   org.Address().Bytes(),
+			To:     to.Address().Bytes(),
+			Value:  100,
+			Nonce:  nonce,
+		}}})
+	}
+	sendTokens(1)
+	idx.WaitIdle()
+
+	process, err := idx.ProcessInfo(pid)
+	qt.Assert(t, err, qt.IsNil)
+	vote, err := idx.GetEnvelope(nullifier)
+	qt.Assert(t, err, qt.IsNil)
+	transfers, err := idx.GetTokenTransfersByFromAccount(org.Address().Bytes(), 0, 10)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, transfers, qt.HasLen, 1)
+	txs, err := idx.GetLastTxReferences(10, 0)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, txs, qt.HasLen, 3)
+
+	qt.Assert(t, idx.Rebuild(), qt.IsNil)
+	pending, err := idx.RebuildPending()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, pending, qt.IsFalse)
+
+	// the data indexed from the blocks is indexed again
+	process2, err := idx.ProcessInfo(pid)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, process2.EntityID, qt.DeepEquals, process.EntityID)
+	qt.Assert(t, process2.EntityIndex, qt.Equals, process.EntityIndex)
+	qt.Assert(t, process2.StartBlock, qt.Equals, process.StartBlock)
+	qt.Assert(t, process2.CensusRoot, qt.DeepEquals, process.CensusRoot)
+	qt.Assert(t, process2.Status, qt.Equals, process.Status)
+	vote2, err := idx.GetEnvelope(nullifier)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, vote2.Meta, qt.DeepEquals, vote.Meta)
+	qt.Assert(t, vote2.VotePackage, qt.DeepEquals, vote.VotePackage)
+	qt.Assert(t, vote2.Weight, qt.Equals, vote.Weight)
+	transfers2, err := idx.GetTokenTransfersByFromAccount(org.Address().Bytes(), 0, 10)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, transfers2, qt.HasLen, 1)
+	qt.Assert(t, transfers2[0].TxHash, qt.DeepEquals, transfers[0].TxHash)
+	qt.Assert(t, transfers2[0].Amount, qt.Equals, transfers[0].Amount)
+	// the blocks were indexed concurrently, so their transactions may not
+	// have been indexed in order, while the rebuild replays them in order
+	txTypes := make(map[string]string)
+	for _, tx := range txs {
+		txTypes[tx.Hash.String()] = tx.TxType
+	}
+	assertTxs := func(count int) {
+		t.Helper()
+		txs2, err := idx.GetLastTxReferences(10, 0)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, txs2, qt.HasLen, count)
+		for i, tx := range txs2 {
+			if i > 0 {
+				qt.Assert(t, tx.BlockHeight < txs2[i-1].BlockHeight, qt.IsTrue)
+			}
+			if txType, ok := txTypes[tx.Hash.String()]; ok {
+				qt.Assert(t, tx.TxType, qt.Equals, txType)
+				delete(txTypes, tx.Hash.String())
+			}
+		}
+		qt.Assert(t, txTypes, qt.HasLen, 0)
+	}
+	assertTxs(3)
+	envelopes, err := idx.GetEnvelopes(pid, 10, 0, "")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, envelopes, qt.HasLen, 1)
+
+	// the blocks committed after the rebuild are indexed by Commit
+	sendTokens(2)
+	idx.WaitIdle()
+	transfers2, err = idx.GetTokenTransfersByFromAccount(org.Address().Bytes(), 0, 10)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, transfers2, qt.HasLen, 2)
+
+	// an interrupted rebuild resumes after its last replayed block, which is
+	// the block of the vote, without indexing twice the blocks before it
+	queries, ctx, cancel := idx.timeoutQueries()
+	defer cancel()
+	_, err = queries.CreateRebuildStatus(ctx, indexerdb.CreateRebuildStatusParams{
+		Height:       int64(vote2.Meta.Height),
+		TargetHeight: int64(app.Height() - 1),
+	})
+	qt.Assert(t, err, qt.IsNil)
+	pending, err = idx.RebuildPending()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, pending, qt.IsTrue)
+	qt.Assert(t, idx.Rebuild(), qt.IsNil)
+	pending, err = idx.RebuildPending()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, pending, qt.IsFalse)
+
+	process2, err = idx.ProcessInfo(pid)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, process2.EntityIndex, qt.Equals, process.EntityIndex)
+	envelopes, err = idx.GetEnvelopes(pid, 10, 0, "")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, envelopes, qt.HasLen, 1)
+	transfers2, err = idx.GetTokenTransfersByFromAccount(org.Address().Bytes(), 0, 10)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, transfers2, qt.HasLen, 2)
+	for _, tx := range txs {
+		txTypes[tx.Hash.String()] = tx.TxType
+	}
+	assertTxs(4)
+}
+
+func TestRebuildMissingBlocks(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	idx := newTestIndexer(t, app, true)
+
+	from, to := ethereum.SignKeys{}, ethereum.SignKeys{}
+	qt.Assert(t, from.Generate(), qt.IsNil)
+	qt.Assert(t, to.Generate(), qt.IsNil)
+	qt.Assert(t, app.State.CreateAccount(from.Address(), "", nil, 100), qt.IsNil)
+	qt.Assert(t, app.State.CreateAccount(to.Address(), "", nil, 0), qt.IsNil)
+	app.AdvanceTestBlock()
+	qt.Assert(t, app.State.TransferBalance(&vochaintx.TokenTransfer{
+		FromAddress: from.Address(),
+		ToAddress:   to.Address(),
+		Amount:      10,
+		TxHash:      util.RandomBytes(32),
+	}, false), qt.IsNil)
+	app.AdvanceTestBlock()
+	idx.WaitIdle()
+
+	// as on a node synced from a state snapshot, the first blocks are missing
+	app.SetFnGetBlockByHeight(func(height int64) *tmtypes.Block { return nil })
+	err := idx.Rebuild()
+	qt.Assert(t, err, qt.ErrorMatches, ".*block 1 not found.*")
+
+	// nothing was wiped
+	pending, err := idx.RebuildPending()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, pending, qt.IsFalse)
+	transfers, err := idx.GetTokenTransfersByFromAccount(from.Address().Bytes(), 0, 10)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, transfers, qt.HasLen, 1)
+}
+
+func TestTxReferenceParticipants(t *testing.T) {
+	app := vochain.TestBaseApplication(t)
+	idx := newTestIndexer(t, app, true)
+
+	org := ethereum.SignKeys{}
+	qt.Assert(t, org.Generate(), qt.IsNil)
+	qt.Assert(t, app.State.SetAccount(state.BurnAddress, &state.Account{}), qt.IsNil)
+	qt.Assert(t, app.State.SetTxCost(models.TxType_NEW_PROCESS, 10), qt.IsNil)
+	qt.Assert(t, app.State.CreateAccount(org.Address(), "", nil, 0), qt.IsNil)
+	qt.Assert(t, app.State.MintBalance(&vochaintx.TokenTransfer{
+		ToAddress: org.Address(),
+		Amount:    1000,
+	}), qt.IsNil)
+	app.AdvanceTestBlock()
+
+	sendTx := func(signer *ethereum.SignKeys, tx *models.Tx) []byte {
+		txBytes, err := proto.Marshal(tx)
+		qt.Assert(t, err, qt.IsNil)
+		signature, err := signer.SignVocdoniTx(txBytes, app.ChainID())
+		qt.Assert(t, err, qt.IsNil)
+		signedTx, err := proto.Marshal(&models.SignedTx{Tx: txBytes, Signature: signature})
+		qt.Assert(t, err, qt.IsNil)
+		response, err := app.SendTx(signedTx)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, response.Code, qt.Equals, uint32(0), qt.Commentf("%s", response.Data))
+		app.AdvanceTestBlock()
+		// the txs of each block are indexed concurrently, so wait for them
+		// to keep their IDs in order
+		idx.WaitIdle()
+		return response.Data.Bytes()
+	}
+
+	keys, root, proofs := testvoteproof.CreateKeysAndBuildCensus(t, 2)
+	newProcess := func(nonce uint32) *models.Tx {
+		return &models.Tx{Payload: &models.Tx_NewProcess{NewProcess: &models.NewProcessTx{
+			Txtype: models.TxType_NEW_PROCESS,
+			Nonce:  nonce,
+			Process: &models.Process{
+				CensusRoot:   root,
+				CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+				EnvelopeType: &models.EnvelopeType{},
+				Status:       models.ProcessStatus_READY,
+				Mode:         &models.ProcessMode{AutoStart: true, Interruptible: true},
+				BlockCount:   100,
+				VoteOptions:  &models.ProcessVoteOptions{MaxCount: 3, MaxValue: 2},
+			},
+		}}}
+	}
+	pid := sendTx(&org, newProcess(0))
+	vp, err := json.Marshal(vochain.VotePackage{Votes: []int{1, 2, 0}})
+	qt.Assert(t, err, qt.IsNil)
+	for i, key := range keys {
+		sendTx(key, &models.Tx{Payload: &models.Tx_Vote{Vote: &models.VoteEnvelope{
+			Nonce: util.RandomBytes(32),
+			Proof: &models.Proof{Payload: &models.Proof_Arbo{
+				Arbo: &models.ProofArbo{
+					Type:     models.ProofArbo_BLAKE2B,
+					Siblings: proofs[i],
+					KeyType:  models.ProofArbo_PUBKEY,
+				}}},
+			ProcessId:   pid,
+			VotePackage: vp,
+		}}})
+	}
+	// a batch pausing the election and creating another one
+	batch, err := vochaintx.NewBatchTx(
+		&models.Tx{Payload: &models.Tx_SetProcess{SetProcess: &models.SetProcessTx{
+			Txtype:    models.TxType_SET_PROCESS_STATUS,
+			Nonce:     1,
+			ProcessId: pid,
+			Status:    models.ProcessStatus_PAUSED.Enum(),
+		}}},
+		newProcess(2),
+	)
+	qt.Assert(t, err, qt.IsNil)
+	pid2 := sendTx(&org, batch)
+	idx.WaitIdle()
+
+	checkTxs := func() {
+		// the votes are listed for the voter and for the organization
+		txs, err := idx.GetTxReferencesByAccount(keys[1].Address().Bytes(), 10, 0)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, txs, qt.HasLen, 1)
+		qt.Assert(t, txs[0].TxType, qt.Equals, "vote")
+		qt.Assert(t, txs[0].Signer, qt.DeepEquals, types.HexBytes(keys[1].Address().Bytes()))
+		qt.Assert(t, txs[0].EntityID, qt.DeepEquals, types.HexBytes(org.Address().Bytes()))
+		qt.Assert(t, txs[0].ProcessID, qt.DeepEquals, types.HexBytes(pid))
+
+		txs, err = idx.GetTxReferencesByAccount(org.Address().Bytes(), 10, 0)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, txs, qt.HasLen, 4)
+		qt.Assert(t, txs[0].TxType, qt.Equals, vochaintx.TxModelTypeBatch)
+		qt.Assert(t, txs[0].Signer, qt.DeepEquals, types.HexBytes(org.Address().Bytes()))
+		qt.Assert(t, txs[1].Signer, qt.DeepEquals, types.HexBytes(keys[1].Address().Bytes()))
+		qt.Assert(t, txs[2].Signer, qt.DeepEquals, types.HexBytes(keys[0].Address().Bytes()))
+		qt.Assert(t, txs[3].TxType, qt.Equals, "newProcess")
+		qt.Assert(t, txs[3].Signer, qt.DeepEquals, types.HexBytes(org.Address().Bytes()))
+		txs, err = idx.GetTxReferencesByAccount(org.Address().Bytes(), 10, 3)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, txs, qt.HasLen, 1)
+
+		// the batch is listed for both of the elections of its sub-transactions
+		txs, err = idx.GetTxReferencesByProcessID(pid, 10, 0)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, txs, qt.HasLen, 4)
+		qt.Assert(t, txs[0].TxType, qt.Equals, vochaintx.TxModelTypeBatch)
+		for _, tx := range txs[1:] {
+			qt.Assert(t, tx.ProcessID, qt.DeepEquals, types.HexBytes(pid))
+		}
+		txs, err = idx.GetTxReferencesByProcessID(pid2, 10, 0)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, txs, qt.HasLen, 1)
+		qt.Assert(t, txs[0].TxType, qt.Equals, vochaintx.TxModelTypeBatch)
+	}
+	checkTxs()
+
+	// the transactions indexed before the participants are backfilled
+	resetParticipants := func() {
+		_, err := idx.sqlDB.Exec(`UPDATE tx_references SET signer = X'', entity_id = X'',
+		process_id = X''`)
+		qt.Assert(t, err, qt.IsNil)
+		_, err = idx.sqlDB.Exec(`DELETE FROM tx_batch_references`)
+		qt.Assert(t, err, qt.IsNil)
+	}
+	resetParticipants()
+	// the heights of the mock blockstore differ from the indexed ones
+	for height := uint32(0); height <= app.Height(); height++ {
+		block := app.GetBlockByHeight(int64(height))
+		if block == nil {
+			continue
+		}
+		for _, tx := range block.Txs {
+			hash := vochaintx.TxKey(tx)
+			_, err = idx.sqlDB.Exec(`UPDATE tx_references SET block_height = ? WHERE hash = ?`,
+				height, hash[:])
+			qt.Assert(t, err, qt.IsNil)
+		}
+	}
+	queries, ctx, cancel := idx.timeoutQueries()
+	defer cancel()
+	_, err = queries.SetTxBackfillStatus(ctx, indexerdb.SetTxBackfillStatusParams{
+		NextID: 1,
+		LastID: 4,
+	})
+	qt.Assert(t, err, qt.IsNil)
+	txs, err := idx.GetTxReferencesByProcessID(pid, 10, 0)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, txs, qt.HasLen, 0)
+
+	qt.Assert(t, idx.BackfillTxReferences(), qt.IsNil)
+	checkTxs()
+	// once done, it does nothing
+	qt.Assert(t, idx.BackfillTxReferences(), qt.IsNil)
+
+	// the transactions of a block missing from the blockstore, and the
+	// following ones, are left pending until it can be decoded
+	resetParticipants()
+	_, err = queries.SetTxBackfillStatus(ctx, indexerdb.SetTxBackfillStatusParams{
+		NextID: 1,
+		LastID: 4,
+	})
+	qt.Assert(t, err, qt.IsNil)
+	txRef, err := idx.GetTxReference(3)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = idx.sqlDB.Exec(`UPDATE tx_references SET block_height = ? WHERE id = 3`,
+		app.Height()+100)
+	qt.Assert(t, err, qt.IsNil)
+	err = idx.BackfillTxReferences()
+	qt.Assert(t, err, qt.ErrorMatches, ".*block .* not found.*")
+	status, err := queries.GetTxBackfillStatus(ctx)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, status.NextID, qt.Equals, int64(3))
+	txRef2, err := idx.GetTxReference(2)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, txRef2.Signer, qt.DeepEquals, types.HexBytes(keys[0].Address().Bytes()))
+	txs, err = idx.GetTxReferencesByProcessID(pid2, 10, 0)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, txs, qt.HasLen, 0)
+
+	_, err = idx.sqlDB.Exec(`UPDATE tx_references SET block_height = ? WHERE id = 3`,
+		txRef.BlockHeight)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, idx.BackfillTxReferences(), qt.IsNil)
+	checkTxs()
+}
@@ -0,0 +1,311 @@
+package indexertypes
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// rankedTallyDecimals is the number of decimals used to encode fractional
+// ranked tallies.
+const rankedTallyDecimals = 6
+
+// RankedBallot holds the preference list of a ranked vote, the first element
+// being the most preferred candidate, and the weight of the vote.
+type RankedBallot struct {
+	Preferences []int
+	Weight      *big.Int
+}
+
+// RankedTally is the weight held by a candidate on a ranked round. Single
+// transferable vote tallies transfer fractions of the ballots, so the weight
+// is a rational number. It marshals to an integer string if the weight is
+// whole, and to a decimal string otherwise.
+type RankedTally big.Rat
+
+func newRankedTally(x *big.Rat) *RankedTally {
+	return (*RankedTally)(new(big.Rat).Set(x))
+}
+
+// Rat returns the weight as a big.Rat.
+func (t *RankedTally) Rat() *big.Rat {
+	return (*big.Rat)(t)
+}
+
+// String returns the string representation of the weight.
+func (t *RankedTally) String() string {
+	if t.Rat().IsInt() {
+		return t.Rat().Num().String()
+	}
+	return t.Rat().FloatString(rankedTallyDecimals)
+}
+
+func (t *RankedTally) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *RankedTally) UnmarshalText(data []byte) error {
+	if _, ok := t.Rat().SetString(string(data)); !ok {
+		return fmt.Errorf("wrong format for ranked tally")
+	}
+	return nil
+}
+
+func (t *RankedTally) GobEncode() ([]byte, error) {
+	return t.Rat().GobEncode()
+}
+
+func (t *RankedTally) GobDecode(buf []byte) error {
+	return t.Rat().GobDecode(buf)
+}
+
+// RankedRound holds the tally of one instant-runoff or single transferable
+// vote round.
+type RankedRound struct {
+	// Tally is the weight assigned to each candidate on this round.
+	// Eliminated candidates always have a zero tally, and candidates elected
+	// on a previous round keep the quota.
+	Tally []*RankedTally `json:"tally"`
+	// Exhausted is the weight of the ballots with no continuing candidates left.
+	Exhausted *RankedTally `json:"exhausted"`
+	// Quota is the weight required to be elected, only on single transferable
+	// vote tallies.
+	Quota *RankedTally `json:"quota,omitempty"`
+	// Eliminated is the candidate eliminated at the end of the round, if any.
+	Eliminated *int `json:"eliminated,omitempty"`
+	// Winner is the candidate that won on this round, if any. Only set on
+	// instant-runoff tallies.
+	Winner *int `json:"winner,omitempty"`
+	// Elected are the candidates elected on this round, if any. Only set on
+	// single transferable vote tallies.
+	Elected []int `json:"elected,omitempty"`
+}
+
+// ComputeRankedRounds tallies the ranked ballots of an election with the given
+// number of candidates. A single seat is tallied with instant-runoff voting,
+// and several seats with single transferable vote.
+func ComputeRankedRounds(candidates, seats int, ballots []*RankedBallot) []*RankedRound {
+	if candidates == 0 {
+		return nil
+	}
+	if seats > 1 {
+		return computeSTVRounds(candidates, seats, ballots)
+	}
+	return computeIRVRounds(candidates, ballots)
+}
+
+// ballotWeight returns the weight of the ballot, one if it is not set.
+func ballotWeight(ballot *RankedBallot) *big.Int {
+	if ballot.Weight == nil {
+		return big.NewInt(1)
+	}
+	return ballot.Weight
+}
+
+// computeIRVRounds runs an instant-runoff tally over the given ballots.
+// On each round the weight of every ballot is assigned to its most preferred
+// continuing candidate.  If a candidate gets more than half of the weight of
+// the non-exhausted ballots, or it is the only continuing candidate left, it
+// wins. Otherwise the candidate with the lowest tally is eliminated and a new
+// round starts.  Ties are broken by candidate index: the lowest index wins and
+// the highest index is eliminated.
+func computeIRVRounds(candidates int, ballots []*RankedBallot) []*RankedRound {
+	continuing := make([]bool, candidates)
+	for i := range continuing {
+		continuing[i] = true
+	}
+	remaining := candidates
+	rounds := []*RankedRound{}
+	for {
+		tally := make([]*big.Int, candidates)
+		for c := range tally {
+			tally[c] = new(big.Int)
+		}
+		exhausted, active := new(big.Int), new(big.Int)
+		for _, ballot := range ballots {
+			weight := ballotWeight(ballot)
+			assigned := false
+			for _, c := range ballot.Preferences {
+				if c >= 0 && c < candidates && continuing[c] {
+					tally[c].Add(tally[c], weight)
+					active.Add(active, weight)
+					assigned = true
+					break
+				}
+			}
+			if !assigned {
+				exhausted.Add(exhausted, weight)
+			}
+		}
+		round := &RankedRound{
+			Tally:     make([]*RankedTally, candidates),
+			Exhausted: newRankedTally(new(big.Rat).SetInt(exhausted)),
+		}
+		for c := range tally {
+			round.Tally[c] = newRankedTally(new(big.Rat).SetInt(tally[c]))
+		}
+		rounds = append(rounds, round)
+		if active.Sign() == 0 {
+			return rounds
+		}
+
+		leader, loser := -1, -1
+		for c := 0; c < candidates; c++ {
+			if !continuing[c] {
+				continue
+			}
+			if leader < 0 || tally[c].Cmp(tally[leader]) > 0 {
+				leader = c
+			}
+			if loser < 0 || tally[c].Cmp(tally[loser]) <= 0 {
+				loser = c
+			}
+		}
+		double := new(big.Int).Mul(tally[leader], big.NewInt(2))
+		if remaining == 1 || double.Cmp(active) > 0 {
+			round.Winner = &leader
+			return rounds
+		}
+		round.Eliminated = &loser
+		continuing[loser] = false
+		remaining--
+	}
+}
+
+// stvBallot is a ranked ballot being transferred on a single transferable
+// vote tally.
+type stvBallot struct {
+	preferences []int
+	// next is the position of the next preference to look at.
+	next int
+	// value is the weight of the ballot still to be assigned.
+	value *big.Rat
+}
+
+// computeSTVRounds runs a single transferable vote tally over the given
+// ballots, using the Droop quota and the weighted inclusive Gregory method.
+// On each round, every continuing candidate reaching the quota is elected and
+// all of their ballots are transferred to the next continuing preference,
+// with their value reduced by the surplus fraction. If no candidate reaches
+// the quota, the candidate with the lowest tally is eliminated and their
+// ballots are transferred at their current value. When the continuing
+// candidates do not outnumber the seats left, all of them are elected.
+// Ties are broken by candidate index: the lowest index is elected first and
+// the highest index is eliminated.
+func computeSTVRounds(candidates, seats int, ballots []*RankedBallot) []*RankedRound {
+	const (
+		continuing = iota
+		elected
+		eliminated
+	)
+	status := make([]int, candidates)
+	piles := make([][]*stvBallot, candidates)
+	tally := make([]*big.Rat, candidates)
+	for c := range tally {
+		tally[c] = new(big.Rat)
+	}
+	exhausted := new(big.Rat)
+
+	// assign gives the ballot to its next continuing preference, or marks
+	// its value as exhausted if there is none.
+	assign := func(b *stvBallot) {
+		for ; b.next < len(b.preferences); b.next++ {
+			c := b.preferences[b.next]
+			if c >= 0 && c < candidates && status[c] == continuing {
+				piles[c] = append(piles[c], b)
+				tally[c].Add(tally[c], b.value)
+				b.next++
+				return
+			}
+		}
+		exhausted.Add(exhausted, b.value)
+	}
+	// transfer moves all the ballots of the candidate to their next
+	// preference, multiplying their value by the given fraction.
+	transfer := func(c int, fraction *big.Rat) {
+		pile := piles[c]
+		piles[c] = nil
+		for _, b := range pile {
+			b.value.Mul(b.value, fraction)
+			if b.value.Sign() > 0 {
+				assign(b)
+			}
+		}
+	}
+
+	total := new(big.Rat)
+	for _, ballot := range ballots {
+		b := &stvBallot{
+			preferences: ballot.Preferences,
+			value:       new(big.Rat).SetInt(ballotWeight(ballot)),
+		}
+		assign(b)
+	}
+	for c := range tally {
+		total.Add(total, tally[c])
+	}
+	// Droop quota: floor(total / (seats + 1)) + 1
+	quotaInt := new(big.Int).Quo(total.Num(), total.Denom())
+	quotaInt.Quo(quotaInt, big.NewInt(int64(seats+1)))
+	quotaInt.Add(quotaInt, big.NewInt(1))
+	quota := new(big.Rat).SetInt(quotaInt)
+
+	remainingSeats := seats
+	rounds := []*RankedRound{}
+	for {
+		round := &RankedRound{
+			Tally:     make([]*RankedTally, candidates),
+			Exhausted: newRankedTally(exhausted),
+			Quota:     newRankedTally(quota),
+		}
+		pending := []int{}
+		for c := range tally {
+			round.Tally[c] = newRankedTally(tally[c])
+			if status[c] == continuing {
+				pending = append(pending, c)
+			}
+		}
+		rounds = append(rounds, round)
+		if len(pending) == 0 || total.Sign() == 0 {
+			return rounds
+		}
+		sort.SliceStable(pending, func(i, j int) bool {
+			return tally[pending[i]].Cmp(tally[pending[j]]) > 0
+		})
+
+		if len(pending) <= remainingSeats {
+			round.Elected = pending
+			return rounds
+		}
+		for _, c := range pending {
+			if tally[c].Cmp(quota) >= 0 && len(round.Elected) < remainingSeats {
+				round.Elected = append(round.Elected, c)
+				status[c] = elected
+			}
+		}
+		if len(round.Elected) > 0 {
+			remainingSeats -= len(round.Elected)
+			if remainingSeats == 0 {
+				return rounds
+			}
+			for _, c := range round.Elected {
+				surplus := new(big.Rat).Sub(tally[c], quota)
+				fraction := surplus.Quo(surplus, tally[c])
+				tally[c].Set(quota)
+				transfer(c, fraction)
+			}
+			continue
+		}
+
+		loser := pending[len(pending)-1]
+		for _, c := range pending {
+			if tally[c].Cmp(tally[loser]) == 0 && c > loser {
+				loser = c
+			}
+		}
+		round.Eliminated = &loser
+		status[loser] = eliminated
+		tally[loser].SetInt64(0)
+		transfer(loser, big.NewRat(1, 1))
+	}
+}
@@ -19,7 +19,7 @@ const (
 
 // Results holds the final results and relevant process info for a vochain process
 type Results struct {
-	ProcessID      types.HexBytes             `badgerholdKey:"ProcessID" json:"processId"`
+	ProcessID      types.HexBytes             `json:"processId"`
 	Votes          [][]*types.BigInt          `json:"votes"`
 	Weight         *types.BigInt              `json:"weight"`
 	EnvelopeHeight uint64                     `json:"envelopeHeight"`
@@ -28,6 +28,7 @@ type Results struct {
 	Signatures     []types.HexBytes           `json:"signatures"`
 	Final          bool                       `json:"final"`
 	BlockHeight    uint32                     `json:"blockHeight"`
+	RankedRounds   []*RankedRound             `json:"rankedRounds,omitempty"`
 }
 
 // String formats the results in a human-readable string
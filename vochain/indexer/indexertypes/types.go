@@ -16,40 +16,23 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
-const (
-	// CountStoreEntities is the key for the entity count db reference
-	CountStoreEntities = uint8(iota)
-	// CountStoreProcesses is the key for the process count db reference
-	CountStoreProcesses
-	// CountStoreEnvelopes is the key for the envelope count db reference
-	CountStoreEnvelopes
-	// CountStoreTransactions is the key for the transaction count db reference
-	CountStoreTransactions
-)
-
-// CountStore holds the count of envelopes, processes, entities, or transactions
-type CountStore struct {
-	Type  uint8 `badgerholdKey:"Type"`
-	Count uint64
-}
-
 // Process represents an election process handled by the Vochain.
 // The indexer Process data type is different from the vochain state data type
 // since it is optimized for querying purposes and not for keeping a shared consensus state.
 type Process struct {
-	ID                types.HexBytes             `badgerholdKey:"ID" json:"processId"`
-	EntityID          types.HexBytes             `badgerholdIndex:"EntityID" json:"entityId"`
+	ID                types.HexBytes             `json:"processId"`
+	EntityID          types.HexBytes             `json:"entityId"`
 	EntityIndex       uint32                     `json:"entityIndex"`
 	StartBlock        uint32                     `json:"startBlock"`
-	EndBlock          uint32                     `badgerholdIndex:"EndBlock" json:"endBlock"`
-	Rheight           uint32                     `badgerholdIndex:"Rheight" json:"-"`
+	EndBlock          uint32                     `json:"endBlock"`
+	Rheight           uint32                     `json:"-"`
 	CensusRoot        types.HexBytes             `json:"censusRoot"`
 	RollingCensusRoot types.HexBytes             `json:"rollingCensusRoot"`
 	CensusURI         string                     `json:"censusURI"`
 	Metadata          string                     `json:"metadata"`
 	CensusOrigin      int32                      `json:"censusOrigin"`
-	Status            int32                      `badgerholdIndex:"Status" json:"status"`
-	Namespace         uint32                     `badgerholdIndex:"Namespace" json:"namespace"`
+	Status            int32                      `json:"status"`
+	Namespace         uint32                     `json:"namespace"`
 	Envelope          *models.EnvelopeType       `json:"envelopeType"`
 	Mode              *models.ProcessMode        `json:"processMode"`
 	VoteOpts          *models.ProcessVoteOptions `json:"voteOptions"`
@@ -60,7 +43,7 @@ type Process struct {
 	HaveResults       bool                       `json:"haveResults"`
 	FinalResults      bool                       `json:"finalResults"`
 	SourceBlockHeight uint64                     `json:"sourceBlockHeight"`
-	SourceNetworkId   string                     `badgerholdIndex:"SourceNetworkId" json:"sourceNetworkId"`
+	SourceNetworkId   string                     `json:"sourceNetworkId"`
 	MaxCensusSize     uint64                     `json:"maxCensusSize"`
 	RollingCensusSize uint64                     `json:"rollingCensusSize"`
 }
@@ -90,8 +73,8 @@ func ProcessFromDB(dbproc *indexerdb.Process) *Process {
 		SourceNetworkId:   dbproc.SourceNetworkID,
 		Metadata:          dbproc.Metadata,
 	}
-	// Note that the old DB does not seem to keep a nil Envelope.
-	// TODO(mvdan): when we drop badgerhold, consider removing this alloc.
+	// The envelope type is never nil, even if the process was created
+	// without one, since its fields are read directly.
 	proc.Envelope = new(models.EnvelopeType)
 	if err := proto.Unmarshal(dbproc.EnvelopePb, proc.Envelope); err != nil {
 		log.Error(err)
@@ -111,6 +94,18 @@ func ProcessFromDB(dbproc *indexerdb.Process) *Process {
 	return proc
 }
 
+// CurrentStatus returns the status of the process at the given block time.
+// Time-bounded processes in READY status are reported as ENDED once their end
+// date is reached, since from then on the vochain rejects their votes.
+func (p *Process) CurrentStatus(timestamp int64) int32 {
+	if p.Status == int32(models.ProcessStatus_READY) {
+		if _, end, ok := state.ProcessTimeBounds(p.Mode); ok && end.Unix() <= timestamp {
+			return int32(models.ProcessStatus_ENDED)
+		}
+	}
+	return p.Status
+}
+
 func decodeVotes(input string) [][]*types.BigInt {
 	// "a,b,c x,y,z ..."
 	var votes [][]*types.BigInt
@@ -138,9 +133,10 @@ func ResultsFromDB(dbproc *indexerdb.Process) *Results {
 		Signatures:     hexSplit(dbproc.ResultsSignatures),
 		Final:          dbproc.FinalResults,
 		BlockHeight:    uint32(dbproc.ResultsBlockHeight),
+		RankedRounds:   decodeRankedRounds(dbproc.ResultsRankedRounds),
 	}
-	// Note that the old DB does not seem to keep a nil Envelope.
-	// TODO(mvdan): when we drop badgerhold, consider removing this alloc.
+	// The envelope type is never nil, even if the process was created
+	// without one, since the tally reads its fields directly.
 	results.EnvelopeType = new(models.EnvelopeType)
 	if err := proto.Unmarshal(dbproc.EnvelopePb, results.EnvelopeType); err != nil {
 		log.Error(err)
@@ -163,6 +159,17 @@ func decodeBigint(s string) *types.BigInt {
 	return n
 }
 
+func decodeRankedRounds(s string) []*RankedRound {
+	if s == "" {
+		return nil // avoid a non-nil empty list for s==""
+	}
+	var rounds []*RankedRound
+	if err := json.Unmarshal([]byte(s), &rounds); err != nil {
+		log.Error(err)
+	}
+	return rounds
+}
+
 func nonEmptyBytes(p []byte) []byte {
 	if len(p) == 0 {
 		return nil
@@ -180,7 +187,7 @@ func nonEmptySplit(s, sep string) []string {
 
 func hexSplit(joined string) []types.HexBytes {
 	if joined == "" {
-		return nil // match badgerhold
+		return nil // avoid []types.HexBytes{} for joined==""
 	}
 	strs := strings.Split(joined, ",")
 	list := make([]types.HexBytes, len(strs))
@@ -199,13 +206,6 @@ func (p Process) String() string {
 	return string(b)
 }
 
-// Entity holds the db reference for an entity
-type Entity struct {
-	ID           types.HexBytes `badgerholdKey:"ID"`
-	ProcessCount uint32
-	CreationTime time.Time
-}
-
 // VotePackage represents the payload of a vote (usually base64 encoded)
 type VotePackage struct {
 	Nonce string `json:"nonce,omitempty"`
@@ -214,8 +214,8 @@ type VotePackage struct {
 
 // VoteReference holds the db reference for a single vote
 type VoteReference struct {
-	Nullifier      types.HexBytes `badgerholdKey:"Nullifier"`
-	ProcessID      types.HexBytes `badgerholdIndex:"ProcessID"`
+	Nullifier      types.HexBytes
+	ProcessID      types.HexBytes
 	VoterID        state.VoterID
 	Height         uint32
 	Weight         *types.BigInt
@@ -282,11 +282,20 @@ type TxMetadata struct {
 
 // TxReference holds the db reference for a single transaction
 type TxReference struct {
-	Index        uint64         `badgerholdKey:"Index" json:"transactionNumber"`
-	Hash         types.HexBytes `badgerholdIndex:"Hash" json:"transactionHash"`
+	Index        uint64         `json:"transactionNumber"`
+	Hash         types.HexBytes `json:"transactionHash"`
 	BlockHeight  uint32         `json:"blockHeight"`
 	TxBlockIndex int32          `json:"transactionIndex"`
 	TxType       string         `json:"transactionType"`
+	// Signer is the address of the signer, empty for the unsigned transactions
+	Signer types.HexBytes `json:"signer,omitempty"`
+	// ProcessID is the election the transaction relates to, if any, and
+	// EntityID its organization
+	EntityID  types.HexBytes `json:"entityId,omitempty"`
+	ProcessID types.HexBytes `json:"processId,omitempty"`
+	// BatchTxs are the sub-transactions of a batch that relate to an
+	// election, only set when indexing it
+	BatchTxs []*TxReference `json:"-"`
 }
 
 func TxReferenceFromDB(dbtx *indexerdb.TxReference) *TxReference {
@@ -296,6 +305,9 @@ func TxReferenceFromDB(dbtx *indexerdb.TxReference) *TxReference {
 		BlockHeight:  uint32(dbtx.BlockHeight),
 		TxBlockIndex: int32(dbtx.TxBlockIndex),
 		TxType:       dbtx.TxType,
+		Signer:       nonEmptyBytes(dbtx.Signer),
+		EntityID:     nonEmptyBytes(dbtx.EntityID),
+		ProcessID:    nonEmptyBytes(dbtx.ProcessID),
 	}
 }
 
@@ -350,6 +362,56 @@ type TokenTransferMeta struct {
 	To        types.AccountID `json:"to"`
 }
 
+// MetadataText holds the searchable texts of an election or account metadata
+// in one language.  Questions joins the titles, descriptions and choices of
+// the election questions.
+type MetadataText struct {
+	Language    string
+	Title       string
+	Description string
+	Questions   string
+}
+
+// MetadataSearchResult is an election or account whose metadata matches a
+// full-text search, with the texts of its best ranked language.  A higher
+// score means a better match.
+type MetadataSearchResult struct {
+	ID          types.HexBytes `json:"id"`
+	EntityID    types.HexBytes `json:"entityId,omitempty"`
+	Status      int32          `json:"status,omitempty"`
+	Language    string         `json:"language"`
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Score       float64        `json:"score"`
+}
+
+// ElectionStats holds the participation statistics of an election.  The
+// votes are grouped in buckets of blocks and of time, by the height and time
+// of their first cast, so the overwrites do not move them.
+type ElectionStats struct {
+	Votes      uint64             `json:"votes"`
+	Overwrites uint64             `json:"overwrites"`
+	Weight     *types.BigInt      `json:"weight"`
+	ByHeight   []*VoteStatsBucket `json:"byHeight"`
+	ByTime     []*VoteStatsBucket `json:"byTime"`
+	// Abstentions is the number of votes leaving each question unanswered,
+	// nil if the votes cannot be decoded, such as encrypted votes whose keys
+	// are not revealed yet.
+	Abstentions []uint64 `json:"abstentions,omitempty"`
+}
+
+// VoteStatsBucket holds the votes of a bucket of blocks or of time.  Start is
+// the first height of the bucket, or its first second as a unix timestamp.
+// The cumulative fields include the votes of the previous buckets.
+type VoteStatsBucket struct {
+	Start            int64         `json:"start"`
+	Votes            uint64        `json:"votes"`
+	Overwrites       uint64        `json:"overwrites"`
+	Weight           *types.BigInt `json:"weight"`
+	CumulativeVotes  uint64        `json:"cumulativeVotes"`
+	CumulativeWeight *types.BigInt `json:"cumulativeWeight"`
+}
+
 // ________________________ CALLBACKS DATA STRUCTS ________________________
 
 // IndexerOnProcessData holds the required data for callbacks when
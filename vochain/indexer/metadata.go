@@ -0,0 +1,285 @@
+package indexer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"go.vocdoni.io/dvote/log"
+	indexerdb "go.vocdoni.io/dvote/vochain/indexer/db"
+	"go.vocdoni.io/dvote/vochain/indexer/indexertypes"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+const (
+	metadataKindElection = "election"
+	metadataKindAccount  = "account"
+
+	// maxSearchTerms is the maximum number of words of a full-text search
+	maxSearchTerms = 16
+)
+
+// ErrSearchDisabled is returned by the metadata searches if SQLite is built
+// without FTS5.
+var ErrSearchDisabled = fmt.Errorf("metadata search is disabled, sqlite is built without FTS5")
+
+// metadataSearchSchema creates the full-text search index of metadata_texts,
+// with the rows sharing their rowid, and indexes the texts stored before.  It
+// is not a migration as sqlc cannot parse virtual tables, and it is optional
+// as FTS5 requires building go-sqlite3 with the sqlite_fts5 tag.
+var metadataSearchSchema = []string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS metadata_search USING fts5(
+		title, description, questions,
+		tokenize="unicode61 remove_diacritics 1"
+	)`,
+	// The metadata texts are replaced, never updated,
+	// so the search index is kept in sync on insert and delete.
+	`CREATE TRIGGER IF NOT EXISTS metadata_texts_insert AFTER INSERT ON metadata_texts BEGIN
+		INSERT INTO metadata_search (rowid, title, description, questions)
+		VALUES (new.rowid, new.title, new.description, new.questions);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS metadata_texts_delete AFTER DELETE ON metadata_texts BEGIN
+		DELETE FROM metadata_search WHERE rowid = old.rowid;
+	END`,
+	// The texts may have changed while the search was disabled.
+	`DELETE FROM metadata_search`,
+	`INSERT INTO metadata_search (rowid, title, description, questions)
+		SELECT rowid, title, description, questions FROM metadata_texts`,
+}
+
+// metadataSearchDisable drops the triggers keeping the search index in sync,
+// which fail without FTS5, so the metadata texts are still stored.
+var metadataSearchDisable = []string{
+	`DROP TRIGGER IF EXISTS metadata_texts_insert`,
+	`DROP TRIGGER IF EXISTS metadata_texts_delete`,
+}
+
+// searchElectionMetadata returns each election once, with the texts of its
+// best ranked language.  The ranks are materialized first, as bm25 cannot be
+// used in aggregates.  FTS5 ranks the best matches lower, so the score is
+// negated.
+const searchElectionMetadata = `WITH s AS MATERIALIZED (
+	SELECT m.id, m.language, m.title, m.description,
+		-bm25(metadata_search, 4.0, 2.0, 1.0) AS score
+	FROM metadata_search
+	JOIN metadata_texts AS m ON m.rowid = metadata_search.rowid
+	WHERE metadata_search MATCH ?1
+		AND m.kind = 'election'
+		AND (?2 = '' OR m.language = ?2)
+)
+SELECT s.id, p.entity_id, p.status, s.language, s.title, s.description,
+	MAX(s.score) AS score
+FROM s
+JOIN processes AS p ON p.id = s.id
+WHERE (?3 = 0 OR p.entity_id = ?4)
+	AND (?5 = 0 OR p.status = ?5)
+GROUP BY s.id
+ORDER BY score DESC, p.creation_time ASC, s.id ASC
+LIMIT ?6
+OFFSET ?7`
+
+// searchAccountMetadata is the searchElectionMetadata query for accounts.
+const searchAccountMetadata = `WITH s AS MATERIALIZED (
+	SELECT m.id, m.language, m.title, m.description,
+		-bm25(metadata_search, 4.0, 2.0, 1.0) AS score
+	FROM metadata_search
+	JOIN metadata_texts AS m ON m.rowid = metadata_search.rowid
+	WHERE metadata_search MATCH ?1
+		AND m.kind = 'account'
+		AND (?2 = '' OR m.language = ?2)
+)
+SELECT s.id, s.language, s.title, s.description, MAX(s.score) AS score
+FROM s
+GROUP BY s.id
+ORDER BY score DESC, s.id ASC
+LIMIT ?3
+OFFSET ?4`
+
+// setupMetadataSearch enables or disables the metadata search.  Once enabled,
+// the search index is created if it is not kept in sync yet, as on a new
+// database or after running without FTS5.
+func (idx *Indexer) setupMetadataSearch(enabled bool) error {
+	idx.searchEnabled = enabled
+	stmts := metadataSearchDisable
+	if enabled {
+		var synced bool
+		if err := idx.sqlDB.QueryRow(`SELECT COUNT(*) > 0 FROM sqlite_master
+			WHERE type = 'trigger' AND name = 'metadata_texts_insert'`).Scan(&synced); err != nil {
+			return err
+		}
+		if synced {
+			return nil
+		}
+		stmts = metadataSearchSchema
+	}
+	tx, err := idx.sqlDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Warnf("cannot rollback metadata search transaction: %v", err)
+		}
+	}()
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SetElectionMetadata indexes the metadata texts of the election for the
+// full-text search, replacing the texts indexed before.
+func (idx *Indexer) SetElectionMetadata(electionID []byte,
+	texts []*indexertypes.MetadataText) error {
+	return idx.setMetadata(metadataKindElection, electionID, texts)
+}
+
+// SetAccountMetadata indexes the metadata texts of the account for the
+// full-text search, replacing the texts indexed before.
+func (idx *Indexer) SetAccountMetadata(address []byte, texts []*indexertypes.MetadataText) error {
+	return idx.setMetadata(metadataKindAccount, address, texts)
+}
+
+func (idx *Indexer) setMetadata(kind string, id []byte, texts []*indexertypes.MetadataText) error {
+	queries, ctx, cancel := idx.timeoutQueries()
+	defer cancel()
+	// The old texts are replaced, so use a transaction to apply all the queries together.
+	tx, err := idx.sqlDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Warnf("cannot rollback metadata transaction: %v", err)
+		}
+	}()
+	queries = queries.WithTx(tx)
+	if _, err := queries.DeleteMetadataTexts(ctx, indexerdb.DeleteMetadataTextsParams{
+		Kind: kind,
+		ID:   id,
+	}); err != nil {
+		return fmt.Errorf("cannot delete %s metadata %x: %w", kind, id, err)
+	}
+	for _, text := range texts {
+		if _, err := queries.CreateMetadataText(ctx, indexerdb.CreateMetadataTextParams{
+			Kind:        kind,
+			ID:          id,
+			Language:    text.Language,
+			Title:       text.Title,
+			Description: text.Description,
+			Questions:   text.Questions,
+		}); err != nil {
+			return fmt.Errorf("cannot index %s metadata %x: %w", kind, id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Debugw("indexed metadata", map[string]interface{}{
+		"kind":      kind,
+		"id":        fmt.Sprintf("%x", id),
+		"languages": len(texts),
+	})
+	return nil
+}
+
+// SearchElections returns the elections whose metadata matches all the words
+// of the text, from the best to the worst ranked.  The words match the words
+// of the titles, descriptions and questions starting with them, and the title
+// matches weigh more.  Language, entityID and status are optional filters,
+// ignored if declared as zero-values.  Status is one of READY, CANCELED,
+// ENDED, PAUSED, RESULTS.  ErrSearchDisabled is returned if SQLite is built
+// without FTS5.
+func (idx *Indexer) SearchElections(text, language string, entityID []byte, status string,
+	from, max int) ([]*indexertypes.MetadataSearchResult, error) {
+	if !idx.searchEnabled {
+		return nil, ErrSearchDisabled
+	}
+	query, err := searchQuery(text)
+	if err != nil {
+		return nil, err
+	}
+	if from < 0 {
+		return nil, fmt.Errorf("searchElections: invalid value: from is invalid value %d", from)
+	}
+	statusnum := int32(0)
+	if status != "" {
+		var ok bool
+		if statusnum, ok = models.ProcessStatus_value[status]; !ok {
+			return nil, fmt.Errorf("searchElections: status %s is unknown", status)
+		}
+	}
+	_, ctx, cancel := idx.timeoutQueries()
+	defer cancel()
+	rows, err := idx.sqlDB.QueryContext(ctx, searchElectionMetadata,
+		query, language, len(entityID), entityID, statusnum, max, from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []*indexertypes.MetadataSearchResult{}
+	for rows.Next() {
+		r := &indexertypes.MetadataSearchResult{}
+		if err := rows.Scan(&r.ID, &r.EntityID, &r.Status, &r.Language,
+			&r.Title, &r.Description, &r.Score); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// SearchAccounts returns the accounts whose metadata matches all the words of
+// the text, from the best to the worst ranked, as done by SearchElections.
+// Language is an optional filter, ignored if empty.
+func (idx *Indexer) SearchAccounts(text, language string,
+	from, max int) ([]*indexertypes.MetadataSearchResult, error) {
+	if !idx.searchEnabled {
+		return nil, ErrSearchDisabled
+	}
+	query, err := searchQuery(text)
+	if err != nil {
+		return nil, err
+	}
+	if from < 0 {
+		return nil, fmt.Errorf("searchAccounts: invalid value: from is invalid value %d", from)
+	}
+	_, ctx, cancel := idx.timeoutQueries()
+	defer cancel()
+	rows, err := idx.sqlDB.QueryContext(ctx, searchAccountMetadata, query, language, max, from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []*indexertypes.MetadataSearchResult{}
+	for rows.Next() {
+		r := &indexertypes.MetadataSearchResult{}
+		if err := rows.Scan(&r.ID, &r.Language, &r.Title, &r.Description, &r.Score); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// searchQuery returns the full-text query matching all the words of text as
+// prefixes.  The words are quoted, so the text cannot inject query operators.
+func searchQuery(text string) (string, error) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return "", fmt.Errorf("empty search text")
+	}
+	if len(words) > maxSearchTerms {
+		return "", fmt.Errorf("too many search words (max %d)", maxSearchTerms)
+	}
+	for i, w := range words {
+		words[i] = `"` + w + `"*`
+	}
+	return strings.Join(words, " "), nil
+}
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE processes
+ADD COLUMN results_ranked_rounds TEXT NOT NULL DEFAULT ''; -- JSON-encoded list of instant-runoff rounds
+
+-- +goose Down
+ALTER TABLE processes
+DROP COLUMN results_ranked_rounds
@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE account_rotations (
+  from_account BLOB NOT NULL PRIMARY KEY,
+  to_account BLOB NOT NULL,
+  height INTEGER NOT NULL,
+  tx_hash BLOB NOT NULL
+);
+
+CREATE INDEX index_to_account_account_rotations
+ON account_rotations(to_account);
+
+-- +goose Down
+DROP TABLE account_rotations
+
+DROP INDEX index_to_account_account_rotations
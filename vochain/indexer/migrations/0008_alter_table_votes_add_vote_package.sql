@@ -0,0 +1,13 @@
+-- +goose Up
+ALTER TABLE vote_references
+ADD COLUMN vote_package BLOB NOT NULL DEFAULT X''; -- kept for when the state votes are pruned
+
+ALTER TABLE vote_references
+ADD COLUMN encryption_key_indexes TEXT NOT NULL DEFAULT ''; -- JSON-encoded list of key indexes
+
+-- +goose Down
+ALTER TABLE vote_references
+DROP COLUMN encryption_key_indexes;
+
+ALTER TABLE vote_references
+DROP COLUMN vote_package;
@@ -0,0 +1,15 @@
+-- +goose Up
+-- The full-text search index over these rows is created by the indexer,
+-- as sqlc cannot parse virtual tables; see metadata.go.
+CREATE TABLE metadata_texts (
+  kind TEXT NOT NULL, -- 'election' or 'account'
+  id BLOB NOT NULL, -- election ID or account address
+  language TEXT NOT NULL,
+  title TEXT NOT NULL,
+  description TEXT NOT NULL,
+  questions TEXT NOT NULL, -- question titles, descriptions and choices
+  PRIMARY KEY (kind, id, language)
+);
+
+-- +goose Down
+DROP TABLE metadata_texts;
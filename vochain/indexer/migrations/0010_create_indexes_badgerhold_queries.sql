@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE INDEX index_vote_references_process_id_height
+ON vote_references(process_id, height);
+
+CREATE INDEX index_processes_results_height
+ON processes(results_height);
+
+-- +goose Down
+DROP INDEX index_processes_results_height
+
+DROP INDEX index_vote_references_process_id_height
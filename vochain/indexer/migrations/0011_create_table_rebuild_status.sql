@@ -0,0 +1,10 @@
+-- +goose Up
+-- The progress of the indexer rebuild, a single row kept while it is running.
+CREATE TABLE rebuild_status (
+  id            INTEGER NOT NULL PRIMARY KEY CHECK (id = 1),
+  height        INTEGER NOT NULL, -- last block replayed
+  target_height INTEGER NOT NULL -- last block when the rebuild started
+);
+
+-- +goose Down
+DROP TABLE rebuild_status;
@@ -0,0 +1,23 @@
+-- +goose Up
+ALTER TABLE vote_references
+ADD COLUMN first_height INTEGER NOT NULL DEFAULT 0; -- the height of the first cast, kept on overwrites
+
+ALTER TABLE vote_references
+ADD COLUMN first_creation_time DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00';
+
+ALTER TABLE vote_references
+ADD COLUMN answers INTEGER NOT NULL DEFAULT -1; -- number of questions answered, see Indexer.abstentions
+
+-- The votes overwritten before are counted from their last cast.
+UPDATE vote_references
+SET first_height = height, first_creation_time = creation_time;
+
+-- +goose Down
+ALTER TABLE vote_references
+DROP COLUMN answers;
+
+ALTER TABLE vote_references
+DROP COLUMN first_creation_time;
+
+ALTER TABLE vote_references
+DROP COLUMN first_height;
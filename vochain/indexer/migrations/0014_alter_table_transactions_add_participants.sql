@@ -0,0 +1,49 @@
+-- +goose Up
+ALTER TABLE tx_references
+ADD COLUMN signer BLOB NOT NULL DEFAULT X''; -- empty for the unsigned transactions
+
+ALTER TABLE tx_references
+ADD COLUMN entity_id BLOB NOT NULL DEFAULT X''; -- the organization of the election, if any
+
+ALTER TABLE tx_references
+ADD COLUMN process_id BLOB NOT NULL DEFAULT X''; -- the election, if any
+
+CREATE INDEX index_tx_references_signer
+ON tx_references(signer);
+
+CREATE INDEX index_tx_references_entity_id
+ON tx_references(entity_id);
+
+CREATE INDEX index_tx_references_process_id
+ON tx_references(process_id);
+
+-- The new columns of the transactions indexed before are filled from the
+-- blockstore, see Indexer.BackfillTxReferences.
+CREATE TABLE tx_references_backfill (
+  id      INTEGER NOT NULL PRIMARY KEY CHECK (id = 1),
+  next_id INTEGER NOT NULL,
+  last_id INTEGER NOT NULL
+);
+
+INSERT INTO tx_references_backfill (id, next_id, last_id)
+SELECT 1, next_id, last_id FROM (
+  SELECT MIN(id) AS next_id, MAX(id) AS last_id FROM tx_references
+) WHERE last_id IS NOT NULL;
+
+-- +goose Down
+DROP TABLE tx_references_backfill;
+
+DROP INDEX index_tx_references_process_id;
+
+DROP INDEX index_tx_references_entity_id;
+
+DROP INDEX index_tx_references_signer;
+
+ALTER TABLE tx_references
+DROP COLUMN process_id;
+
+ALTER TABLE tx_references
+DROP COLUMN entity_id;
+
+ALTER TABLE tx_references
+DROP COLUMN signer;
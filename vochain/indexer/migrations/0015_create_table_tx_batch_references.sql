@@ -0,0 +1,38 @@
+-- +goose Up
+-- The elections the sub-transactions of a batch relate to, since a batch may
+-- relate to several of them.
+CREATE TABLE tx_batch_references (
+  tx_id      INTEGER NOT NULL,
+  entity_id  BLOB NOT NULL DEFAULT X'',
+  process_id BLOB NOT NULL,
+  FOREIGN KEY(tx_id) REFERENCES tx_references(id)
+);
+
+CREATE INDEX index_tx_batch_references_tx_id
+ON tx_batch_references(tx_id);
+
+CREATE INDEX index_tx_batch_references_entity_id
+ON tx_batch_references(entity_id);
+
+CREATE INDEX index_tx_batch_references_process_id
+ON tx_batch_references(process_id);
+
+-- The batches indexed before are backfilled again, along with any
+-- transaction still pending, see Indexer.BackfillTxReferences.
+REPLACE INTO tx_references_backfill (id, next_id, last_id)
+SELECT 1, next_id, last_id FROM (
+  SELECT MIN(id) AS next_id, MAX(id) AS last_id FROM (
+    SELECT id FROM tx_references WHERE tx_type = 'batch'
+    UNION ALL SELECT next_id FROM tx_references_backfill
+    UNION ALL SELECT last_id FROM tx_references_backfill
+  )
+) WHERE last_id IS NOT NULL;
+
+-- +goose Down
+DROP INDEX index_tx_batch_references_process_id;
+
+DROP INDEX index_tx_batch_references_entity_id;
+
+DROP INDEX index_tx_batch_references_tx_id;
+
+DROP TABLE tx_batch_references;
@@ -1,16 +1,14 @@
 package indexer
 
 import (
-	"encoding/hex"
-	"flag"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"sync/atomic"
 	"time"
 
-	"github.com/google/go-cmp/cmp"
-	"github.com/google/go-cmp/cmp/cmpopts"
-	"github.com/timshannon/badgerhold/v3"
 	"go.vocdoni.io/proto/build/go/models"
 	"google.golang.org/protobuf/proto"
 
@@ -61,34 +59,19 @@ func encodeVotes(votes [][]*types.BigInt) string {
 	return b.String()
 }
 
-func sqliteWarnf(format string, args ...interface{}) {
-	// We only complain when running the tests.
-	// In production, the two databases are modified in parallel.
-	// For example, when a process is finished, one database is updated
-	// immediately after the other.
-	// If a client query comes in between those two write operations,
-	// the response to the query might see inconsistent results from the DBs.
-	// That is fine for production.
-	//
-	// We still want to catch the inconsistencies while running the tests,
-	// because they do help catch SQL schema and query bugs.
-	// Tests tend to be sequential with their DBs, so there aren't such races.
-	if flag.Lookup("test.run") != nil {
-		log.Fatalf(format, args...)
+func encodeRankedRounds(rounds []*indexertypes.RankedRound) string {
+	if len(rounds) == 0 {
+		return ""
 	}
+	enc, err := json.Marshal(rounds)
+	if err != nil {
+		panic(err) // TODO(mvdan): propagate errors via a database/sql interface?
+	}
+	return string(enc)
 }
 
 // ProcessInfo returns the available information regarding an election process id
 func (s *Indexer) ProcessInfo(pid []byte) (*indexertypes.Process, error) {
-	proc := &indexertypes.Process{}
-	if enableBadgerhold {
-		bhStartTime := time.Now()
-		if err := s.db.FindOne(proc, badgerhold.Where(badgerhold.Key).Eq(pid)); err != nil {
-			return nil, err
-		}
-		log.Debugf("ProcessInfo badgerhold took %s", time.Since(bhStartTime))
-	}
-
 	sqlStartTime := time.Now()
 
 	queries, ctx, cancel := s.timeoutQueries()
@@ -98,18 +81,7 @@ func (s *Indexer) ProcessInfo(pid []byte) (*indexertypes.Process, error) {
 		return nil, err
 	}
 	log.Debugf("ProcessInfo sqlite took %s", time.Since(sqlStartTime))
-	sqlProc := indexertypes.ProcessFromDB(&sqlProcInner)
-
-	if enableBadgerhold {
-		if diff := cmp.Diff(proc, sqlProc, cmpopts.IgnoreUnexported(
-			models.EnvelopeType{},
-			models.ProcessMode{},
-			models.ProcessVoteOptions{},
-		)); diff != "" {
-			sqliteWarnf("ping mvdan to fix the bug with the information below:\nparams: %x\ndiff (-badger +sql):\n%s", pid, diff)
-		}
-	}
-	return sqlProc, nil
+	return indexertypes.ProcessFromDB(&sqlProcInner), nil
 }
 
 // ProcessList returns a list of process identifiers (PIDs) registered in the Vochain.
@@ -127,8 +99,7 @@ func (s *Indexer) ProcessList(entityID []byte,
 	if from < 0 {
 		return nil, fmt.Errorf("processList: invalid value: from is invalid value %d", from)
 	}
-	// For filtering on Status we use a badgerhold match function.
-	// If status is not defined, then the match function will return always true.
+	// If status is not defined, the processes are not filtered by status.
 	statusnum := int32(0)
 	statusfound := false
 	if status != "" {
@@ -136,114 +107,14 @@ func (s *Indexer) ProcessList(entityID []byte,
 			return nil, fmt.Errorf("processList: status %s is unknown", status)
 		}
 	}
-	statusMatchFunc := func(r *badgerhold.RecordAccess) (bool, error) {
-		if statusnum == 0 {
-			return true, nil
-		}
-		if r.Field().(int32) == statusnum {
-			return true, nil
-		}
-		return false, nil
-	}
-	// Filter match function for source network Id
 	if srcNetworkIdstr != "" {
 		if _, ok := models.SourceNetworkId_value[srcNetworkIdstr]; !ok {
 			return nil, fmt.Errorf("sourceNetworkId is unknown %s", srcNetworkIdstr)
 		}
 	}
-	netIdMatchFunc := func(r *badgerhold.RecordAccess) (bool, error) {
-		if srcNetworkIdstr == "" {
-			return true, nil
-		}
-		if r.Field().(string) == srcNetworkIdstr {
-			return true, nil
-		}
-		return false, nil
-	}
-	// For filtering on withResults we use also a match function
-	wResultsMatchFunc := func(r *badgerhold.RecordAccess) (bool, error) {
-		if !withResults {
-			return true, nil
-		}
-		return r.Field().(bool), nil
-	}
 	queries, ctx, cancel := s.timeoutQueries()
 	defer cancel()
 
-	var procs [][]byte
-	if enableBadgerhold {
-		bhStartTime := time.Now()
-
-		// For EntityID and Namespace we use different queries, since they are indexes and the
-		// performance improvement is quite relevant.
-		var err error
-		switch {
-		case namespace == 0 && len(entityID) > 0:
-			err = s.db.ForEach(
-				badgerhold.Where("EntityID").Eq(entityID).
-					Index("EntityID").
-					And("Status").MatchFunc(statusMatchFunc).
-					And("SourceNetworkId").MatchFunc(netIdMatchFunc).
-					And("HaveResults").MatchFunc(wResultsMatchFunc).
-					And("ID").MatchFunc(searchMatchFunc(searchTerm)).
-					SortBy("CreationTime", "ID").
-					Skip(from).
-					Limit(max),
-				func(p *indexertypes.Process) error {
-					procs = append(procs, p.ID)
-					return nil
-				})
-		case namespace > 0 && len(entityID) == 0:
-			err = s.db.ForEach(
-				badgerhold.Where("Namespace").Eq(namespace).
-					Index("Namespace").
-					And("Status").MatchFunc(statusMatchFunc).
-					And("SourceNetworkId").MatchFunc(netIdMatchFunc).
-					And("HaveResults").MatchFunc(wResultsMatchFunc).
-					And("ID").MatchFunc(searchMatchFunc(searchTerm)).
-					SortBy("CreationTime", "ID").
-					Skip(from).
-					Limit(max),
-				func(p *indexertypes.Process) error {
-					procs = append(procs, p.ID)
-					return nil
-				})
-		case namespace == 0 && len(entityID) == 0:
-			err = s.db.ForEach(
-				badgerhold.Where("Status").MatchFunc(statusMatchFunc).
-					Index("Status").
-					And("SourceNetworkId").MatchFunc(netIdMatchFunc).
-					And("HaveResults").MatchFunc(wResultsMatchFunc).
-					And("ID").MatchFunc(searchMatchFunc(searchTerm)).
-					SortBy("CreationTime", "ID").
-					Skip(from).
-					Limit(max),
-				func(p *indexertypes.Process) error {
-					procs = append(procs, p.ID)
-					return nil
-				})
-		default:
-			err = s.db.ForEach(
-				badgerhold.Where("EntityID").Eq(entityID).
-					Index("EntityID").
-					And("Namespace").Eq(namespace).
-					And("SourceNetworkId").MatchFunc(netIdMatchFunc).
-					And("Status").MatchFunc(statusMatchFunc).
-					And("HaveResults").MatchFunc(wResultsMatchFunc).
-					And("ID").MatchFunc(searchMatchFunc(searchTerm)).
-					SortBy("CreationTime", "ID").
-					Skip(from).
-					Limit(max),
-				func(p *indexertypes.Process) error {
-					procs = append(procs, p.ID)
-					return nil
-				})
-		}
-		log.Debugf("ProcessList badgerhold took %s", time.Since(bhStartTime))
-		if err != nil {
-			return nil, err
-		}
-	}
 	sqlStartTime := time.Now()
 	sqlProcs, err := queries.SearchProcesses(ctx, indexerdb.SearchProcessesParams{
 		EntityID:        entityID,
@@ -260,138 +131,72 @@ func (s *Indexer) ProcessList(entityID []byte,
 	if err != nil {
 		return nil, err
 	}
-	if enableBadgerhold {
-		// []string in hex form is easier to debug when we get mismatches
-		if diff := cmp.Diff(procs, sqlProcs); diff != "" {
-			params := []interface{}{
-				entityID, from, max,
-				searchTerm, namespace,
-				srcNetworkIdstr, status, withResults,
-			}
-			sqliteWarnf("ping mvdan to fix the bug with the information below:\nparams: %#v\ndiff (-badger +sql):\n%s", params, diff)
-		}
-	}
-
 	return sqlProcs, nil
 }
 
 // ProcessCount returns the number of processes indexed
 func (s *Indexer) ProcessCount(entityID []byte) uint64 {
-	if !enableBadgerhold {
+	if len(entityID) == 0 {
 		queries, ctx, cancel := s.timeoutQueries()
 		defer cancel()
-
-		if len(entityID) == 0 {
-			count, err := queries.GetProcessCount(ctx)
-			if err != nil {
-				log.Errorf("could not get the process count: %v", err)
-				return 0
-			}
-			return uint64(count)
-		}
-		count, err := s.EntityProcessCount(entityID)
+		count, err := queries.GetProcessCount(ctx)
 		if err != nil {
-			log.Errorf("processCount: cannot fetch entity process count: %v", err)
-			return 0
-		}
-		return uint64(count)
-	}
-	startTime := time.Now()
-	defer func() { log.Debugf("ProcessCount took %s", time.Since(startTime)) }()
-	var c uint32
-	var err error
-	if len(entityID) == 0 {
-		// If no entity ID, return the stored count of all processes
-		processCountStore := &indexertypes.CountStore{}
-		if err = s.db.Get(indexertypes.CountStoreProcesses, processCountStore); err != nil {
 			log.Errorf("could not get the process count: %v", err)
 			return 0
 		}
-		return processCountStore.Count
+		return uint64(count)
 	}
-	if c, err = s.EntityProcessCount(entityID); err != nil {
+	count, err := s.EntityProcessCount(entityID)
+	if err != nil {
 		log.Errorf("processCount: cannot fetch entity process count: %v", err)
 		return 0
 	}
-	return uint64(c)
+	return uint64(count)
 }
 
 // EntityList returns the list of entities indexed by the indexer
 // searchTerm is optional, if declared as zero-value
 // will be ignored. Searches against the ID field.
 func (s *Indexer) EntityList(max, from int, searchTerm string) []types.HexBytes {
-	if !enableBadgerhold {
-		queries, ctx, cancel := s.timeoutQueries()
-		defer cancel()
-
-		entityIDs, err := queries.SearchEntities(ctx, indexerdb.SearchEntitiesParams{
-			EntityIDSubstr: searchTerm,
-			Offset:         int32(from),
-			Limit:          int32(max),
-		})
-		if err != nil {
-			log.Errorf("error listing entities: %v", err)
-			return nil
-		}
-		hexIDs := make([]types.HexBytes, len(entityIDs))
-		for i, id := range entityIDs {
-			hexIDs[i] = types.HexBytes(id)
-		}
-		return hexIDs
-	}
-	entities := []types.HexBytes{}
-	if err := s.db.ForEach(
-		badgerhold.Where("ID").MatchFunc(searchMatchFunc(searchTerm)).
-			SortBy("CreationTime", "ID").
-			Skip(from).
-			Limit(max),
-		func(e *indexertypes.Entity) error {
-			entities = append(entities, e.ID)
-			return nil
-		}); err != nil {
+	queries, ctx, cancel := s.timeoutQueries()
+	defer cancel()
+	entityIDs, err := queries.SearchEntities(ctx, indexerdb.SearchEntitiesParams{
+		EntityIDSubstr: searchTerm,
+		Offset:         int32(from),
+		Limit:          int32(max),
+	})
+	if err != nil {
 		log.Errorf("error listing entities: %v", err)
+		return nil
+	}
+	hexIDs := make([]types.HexBytes, len(entityIDs))
+	for i, id := range entityIDs {
+		hexIDs[i] = types.HexBytes(id)
 	}
-	return entities
+	return hexIDs
 }
 
 // EntityProcessCount returns the number of processes that an entity holds
 func (s *Indexer) EntityProcessCount(entityId []byte) (uint32, error) {
-	if !enableBadgerhold {
-		queries, ctx, cancel := s.timeoutQueries()
-		defer cancel()
-
-		count, err := queries.GetEntityProcessCount(ctx, entityId)
-		if err != nil {
-			return 0, err
-		}
-		return uint32(count), nil
-	}
-	entity := &indexertypes.Entity{}
-	if err := s.db.FindOne(entity, badgerhold.Where(badgerhold.Key).Eq(entityId)); err != nil {
+	queries, ctx, cancel := s.timeoutQueries()
+	defer cancel()
+	count, err := queries.GetEntityProcessCount(ctx, entityId)
+	if err != nil {
 		return 0, err
 	}
-	return entity.ProcessCount, nil
+	return uint32(count), nil
 }
 
 // EntityCount return the number of entities indexed by the indexer
 func (s *Indexer) EntityCount() uint64 {
-	if !enableBadgerhold {
-		queries, ctx, cancel := s.timeoutQueries()
-		defer cancel()
-
-		count, err := queries.GetEntityCount(ctx)
-		if err != nil {
-			log.Errorf("could not get the entity count: %v", err)
-			return 0
-		}
-		return uint64(count)
-	}
-	entityCountStore := &indexertypes.CountStore{}
-	if err := s.db.Get(indexertypes.CountStoreEntities, entityCountStore); err != nil {
+	queries, ctx, cancel := s.timeoutQueries()
+	defer cancel()
+	count, err := queries.GetEntityCount(ctx)
+	if err != nil {
 		log.Errorf("could not get the entity count: %v", err)
 		return 0
 	}
-	return entityCountStore.Count
+	return uint64(count)
 }
 
 // Return whether a process must have live results or not
@@ -421,21 +226,21 @@ func (s *Indexer) computePendingProcesses(height uint32) {
 		}
 		time.Sleep(5 * time.Second)
 	}
-	if enableBadgerhold {
-		if err := s.db.ForEach(badgerhold.Where("Rheight").Eq(height).Index("Rheight"),
-			func(p *indexertypes.Process) error {
-				initT := time.Now()
-				if err := s.ComputeResult(p.ID); err != nil {
-					log.Warnf("cannot compute results for %x: (%v)", p.ID, err)
-					return nil
-				}
-				log.Infof("results computation on %x took %s", p.ID, time.Since(initT).String())
-				return nil
-			}); err != nil {
-			log.Warn(err)
+	queries, ctx, cancel := s.timeoutQueries()
+	pids, err := queries.GetProcessIDsByResultsHeight(ctx, int64(height))
+	cancel()
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	for _, pid := range pids {
+		initT := time.Now()
+		if err := s.ComputeResult(pid); err != nil {
+			log.Warnf("cannot compute results for %x: (%v)", pid, err)
+			continue
 		}
+		log.Infof("results computation on %x took %s", pid, time.Since(initT).String())
 	}
-	// TODO(sqlite): rewrite
 }
 
 // newEmptyProcess creates a new empty process and stores it into the database.
@@ -462,66 +267,6 @@ func (s *Indexer) newEmptyProcess(pid []byte) error {
 	// Get the block time from the Header
 	currentBlockTime := time.Unix(s.App.TimestampStartBlock(), 0)
 
-	if enableBadgerhold {
-		// Create results in the indexer database
-		s.addVoteLock.Lock()
-		if err := s.queryWithRetries(func() error {
-			return s.db.Insert(pid, &indexertypes.Results{
-				ProcessID: pid,
-				// MaxValue requires +1 since 0 is also an option
-				Votes:        indexertypes.NewEmptyVotes(int(options.MaxCount), int(options.MaxValue)+1),
-				Weight:       new(types.BigInt).SetUint64(0),
-				Signatures:   []types.HexBytes{},
-				VoteOpts:     p.GetVoteOptions(),
-				EnvelopeType: p.GetEnvelopeType(),
-			})
-		}); err != nil {
-			s.addVoteLock.Unlock()
-			return err
-		}
-		s.addVoteLock.Unlock()
-
-		// Increment the total process count storage
-		s.db.UpdateMatching(&indexertypes.CountStore{}, badgerhold.Where(badgerhold.Key).
-			Eq(indexertypes.CountStoreProcesses), func(record interface{}) error {
-			update, ok := record.(*indexertypes.CountStore)
-			if !ok {
-				return fmt.Errorf("record isn't the correct type! Wanted CountStore, got %T", record)
-			}
-			update.Count++
-			return nil
-		},
-		)
-
-		// Add the entity to the indexer database
-		entity := &indexertypes.Entity{}
-		// If entity is not registered in db, add to entity count cache and insert to db
-		if err := s.db.FindOne(entity, badgerhold.Where(badgerhold.Key).Eq(eid)); err != nil {
-			if err != badgerhold.ErrNotFound {
-				return err
-			}
-			entity.ID = eid
-			entity.CreationTime = currentBlockTime
-			entity.ProcessCount = 0
-			// Increment the total entity count storage
-			s.db.UpdateMatching(&indexertypes.CountStore{}, badgerhold.Where(badgerhold.Key).Eq(indexertypes.CountStoreEntities), func(record interface{}) error {
-				update, ok := record.(*indexertypes.CountStore)
-				if !ok {
-					return fmt.Errorf("record isn't the correct type! Wanted CountStore, got %T", record)
-				}
-				update.Count++
-				return nil
-			})
-		}
-		// Increase the entity process count (and create new entity if does not exist)
-		entity.ProcessCount++
-		if err := s.queryWithRetries(func() error {
-			return s.db.Upsert(eid, entity)
-		}); err != nil {
-			return err
-		}
-	}
-
 	compResultsHeight := uint32(0)
 	if live, err := s.isOpenProcess(pid); err != nil {
 		return fmt.Errorf("cannot check if process is live: %w", err)
@@ -532,40 +277,6 @@ func (s *Indexer) newEmptyProcess(pid []byte) error {
 	}
 
 	// Create and store process in the indexer database
-	proc := &indexertypes.Process{
-		ID:                pid,
-		EntityID:          eid,
-		StartBlock:        p.GetStartBlock(),
-		EndBlock:          p.GetBlockCount() + p.GetStartBlock(),
-		Rheight:           compResultsHeight,
-		HaveResults:       compResultsHeight > 0,
-		CensusRoot:        p.GetCensusRoot(),
-		RollingCensusRoot: p.GetRollingCensusRoot(),
-		CensusURI:         p.GetCensusURI(),
-		CensusOrigin:      int32(p.GetCensusOrigin()),
-		Status:            int32(p.GetStatus()),
-		Namespace:         p.GetNamespace(),
-		PrivateKeys:       p.EncryptionPrivateKeys,
-		PublicKeys:        p.EncryptionPublicKeys,
-		Envelope:          p.GetEnvelopeType(),
-		Mode:              p.GetMode(),
-		VoteOpts:          p.GetVoteOptions(),
-		CreationTime:      currentBlockTime,
-		SourceBlockHeight: p.GetSourceBlockHeight(),
-		SourceNetworkId:   p.SourceNetworkId.String(),
-		Metadata:          p.GetMetadata(),
-		// EntityIndex: entity.ProcessCount, // TODO(sqlite): replace with a COUNT
-		MaxCensusSize:     p.GetMaxCensusSize(),
-		RollingCensusSize: p.GetRollingCensusSize(),
-	}
-	log.Debugf("new indexer process %s", proc.String())
-
-	if enableBadgerhold {
-		if err := s.queryWithRetries(func() error { return s.db.Insert(pid, proc) }); err != nil {
-			return err
-		}
-	}
-
 	queries, ctx, cancel := s.timeoutQueries()
 	defer cancel()
 	if _, err := queries.CreateProcess(ctx, indexerdb.CreateProcessParams{
@@ -598,7 +309,11 @@ func (s *Indexer) newEmptyProcess(pid []byte) error {
 	}); err != nil {
 		return fmt.Errorf("sql create process: %w", err)
 	}
-
+	log.Debugw("new indexer process", map[string]interface{}{
+		"electionID":    fmt.Sprintf("%x", pid),
+		"entityID":      fmt.Sprintf("%x", eid),
+		"resultsHeight": compResultsHeight,
+	})
 	return nil
 }
 
@@ -609,61 +324,20 @@ func (s *Indexer) updateProcess(pid []byte) error {
 	if err != nil {
 		return fmt.Errorf("updateProcess: cannot fetch process %x: %w", pid, err)
 	}
-	if enableBadgerhold {
-		updateFunc := func(record interface{}) error {
-			update, ok := record.(*indexertypes.Process)
-			if !ok {
-				return fmt.Errorf("record isn't the correct type! Wanted Process, got %T", record)
-			}
-			update.EndBlock = p.GetBlockCount() + p.GetStartBlock()
-			update.CensusRoot = p.GetCensusRoot()
-			update.RollingCensusRoot = p.GetRollingCensusRoot()
-			update.CensusURI = p.GetCensusURI()
-			update.PrivateKeys = p.EncryptionPrivateKeys
-			update.PublicKeys = p.EncryptionPublicKeys
-			update.Metadata = p.GetMetadata()
-			update.RollingCensusSize = p.GetRollingCensusSize()
-			// If the process is transacting to CANCELED, ensure results are not computed and remove
-			// them from the KV database.
-			if update.Status != int32(models.ProcessStatus_CANCELED) &&
-				p.GetStatus() == models.ProcessStatus_CANCELED {
-				update.HaveResults = false
-				update.FinalResults = true
-				update.Rheight = 0
-				if err := s.db.UpdateMatching(&indexertypes.Results{},
-					badgerhold.Where(badgerhold.Key).Eq(pid), func(record interface{}) error {
-						results, ok := record.(*indexertypes.Results)
-						if !ok {
-							return fmt.Errorf("record isn't the correct type! Wanted Result, got %T", record)
-						}
-						// On cancelled process, remove all results except for envelope height, weight, pid
-						results.Votes = [][]*types.BigInt{}
-						results.EnvelopeType = &models.EnvelopeType{}
-						results.VoteOpts = &models.ProcessVoteOptions{}
-						results.Signatures = []types.HexBytes{}
-						results.Final = false
-						results.BlockHeight = 0
-						return nil
-					}); err != nil {
-					if err != badgerhold.ErrNotFound {
-						log.Warnf("cannot remove CANCELED results: %v", err)
-					}
-				}
-			}
-			update.Status = int32(p.GetStatus())
-			return nil
-		}
-		if err := s.queryWithRetries(func() error {
-			return s.db.UpdateMatching(&indexertypes.Process{},
-				badgerhold.Where(badgerhold.Key).Eq(pid), updateFunc)
-		}); err != nil {
-			return err
-		}
-	}
 	// TODO: remove from results table
-	// TODO: hold a sql db transaction for multiple queries
 	queries, ctx, cancel := s.timeoutQueries()
 	defer cancel()
+	// We use multiple SQL queries, so use a transaction to apply them together.
+	tx, err := s.sqlDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Warnf("cannot rollback process transaction: %v", err)
+		}
+	}()
+	queries = queries.WithTx(tx)
 	previousStatus, err := queries.GetProcessStatus(ctx, pid)
 	if err != nil {
 		return err
@@ -684,14 +358,6 @@ func (s *Indexer) updateProcess(pid []byte) error {
 	}
 	if models.ProcessStatus(previousStatus) != models.ProcessStatus_CANCELED &&
 		p.GetStatus() == models.ProcessStatus_CANCELED {
-
-		// We use two SQL queries, so use a transaction to apply them together.
-		tx, err := s.sqlDB.Begin()
-		if err != nil {
-			return err
-		}
-		queries = queries.WithTx(tx)
-
 		if _, err := queries.SetProcessResultsHeight(ctx, indexerdb.SetProcessResultsHeightParams{
 			ID:            pid,
 			ResultsHeight: 0,
@@ -701,11 +367,8 @@ func (s *Indexer) updateProcess(pid []byte) error {
 		if _, err := queries.SetProcessResultsCancelled(ctx, pid); err != nil {
 			return err
 		}
-		if err := tx.Commit(); err != nil {
-			return err
-		}
 	}
-	return nil
+	return tx.Commit()
 }
 
 // setResultsHeight updates the Rheight of any process whose ID is pid.
@@ -713,21 +376,6 @@ func (s *Indexer) setResultsHeight(pid []byte, height uint32) error {
 	if height == 0 {
 		panic("setting results height to 0?")
 	}
-	if enableBadgerhold {
-		if err := s.queryWithRetries(func() error {
-			return s.db.UpdateMatching(&indexertypes.Process{}, badgerhold.Where(badgerhold.Key).Eq(pid),
-				func(record interface{}) error {
-					update, ok := record.(*indexertypes.Process)
-					if !ok {
-						return fmt.Errorf("record isn't the correct type! Wanted Result, got %T", record)
-					}
-					update.Rheight = height
-					return nil
-				})
-		}); err != nil {
-			return err
-		}
-	}
 	queries, ctx, cancel := s.timeoutQueries()
 	defer cancel()
 	if _, err := queries.SetProcessResultsHeight(ctx, indexerdb.SetProcessResultsHeightParams{
@@ -738,18 +386,3 @@ func (s *Indexer) setResultsHeight(pid []byte, height uint32) error {
 	}
 	return nil
 }
-
-// searchMatchFunc generates a function which compares a badgerhold record against searchTerm.
-// The record should be types.HexBytes, and is encoded as a string to compare to searchTerm.
-func searchMatchFunc(searchTerm string) func(r *badgerhold.RecordAccess) (bool, error) {
-	return func(r *badgerhold.RecordAccess) (bool, error) {
-		if searchTerm == "" {
-			return true, nil
-		}
-		// TODO search without having to convert each field to strings
-		if strings.Contains(hex.EncodeToString(r.Field().(types.HexBytes)), searchTerm) {
-			return true, nil
-		}
-		return false, nil
-	}
-}
@@ -0,0 +1,22 @@
+-- name: CreateAccountRotation :execresult
+INSERT INTO account_rotations (
+	from_account, to_account, height, tx_hash
+) VALUES (
+	?, ?, ?, ?
+);
+
+-- name: GetAccountRotation :one
+SELECT * FROM account_rotations
+WHERE from_account = ?
+LIMIT 1;
+
+-- name: GetAccountRotationsByToAccount :many
+SELECT * FROM account_rotations
+WHERE to_account = ?
+ORDER BY height ASC;
+
+-- name: UpdateProcessesEntityID :execresult
+-- Moves the processes of the second organization to the first one.
+UPDATE processes
+SET entity_id = ?
+WHERE entity_id = ?;
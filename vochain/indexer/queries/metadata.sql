@@ -0,0 +1,10 @@
+-- name: CreateMetadataText :execresult
+INSERT INTO metadata_texts (
+	kind, id, language, title, description, questions
+) VALUES (
+	?, ?, ?, ?, ?, ?
+);
+
+-- name: DeleteMetadataTexts :execresult
+DELETE FROM metadata_texts
+WHERE kind = sqlc.arg(kind) AND id = sqlc.arg(id);
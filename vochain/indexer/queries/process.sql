@@ -66,6 +66,11 @@ SET end_block           = sqlc.arg(end_block),
 	status              = sqlc.arg(status)
 WHERE id = sqlc.arg(id);
 
+-- name: GetProcessEntityID :one
+SELECT entity_id FROM processes
+WHERE id = ?
+LIMIT 1;
+
 -- name: GetProcessStatus :one
 SELECT status FROM processes
 WHERE id = ?
@@ -76,8 +81,18 @@ SELECT results_envelope_height FROM processes
 WHERE id = ?
 LIMIT 1;
 
--- name: GetTotalProcessEnvelopeHeight :one
-SELECT SUM(results_envelope_height) FROM processes;
+-- name: GetResultsWeight :one
+SELECT results_weight FROM processes
+WHERE id = ?
+LIMIT 1;
+
+-- name: GetProcessIDsByResultsHeight :many
+SELECT id FROM processes
+WHERE results_height = sqlc.arg(results_height);
+
+-- name: GetProcessIDsByFinalResults :many
+SELECT id FROM processes
+WHERE final_results = sqlc.arg(final_results);
 
 -- name: SetProcessResultsHeight :execresult
 UPDATE processes
@@ -99,7 +114,8 @@ SET have_results = TRUE, final_results = TRUE,
 	results_weight = sqlc.arg(weight),
 	results_envelope_height = sqlc.arg(envelope_height),
 	results_signatures = sqlc.arg(signatures),
-	results_block_height = sqlc.arg(block_height)
+	results_block_height = sqlc.arg(block_height),
+	results_ranked_rounds = sqlc.arg(ranked_rounds)
 WHERE id = sqlc.arg(id);
 
 -- name: SetProcessResultsCancelled :execresult
@@ -118,9 +134,11 @@ WHERE entity_id = sqlc.arg(entity_id);
 SELECT COUNT(DISTINCT entity_id) FROM processes;
 
 -- name: SearchEntities :many
+-- The entities are sorted by the creation time of their first process.
 SELECT entity_id FROM processes
 WHERE (sqlc.arg(entity_id_substr) = '' OR (INSTR(LOWER(HEX(entity_id)), sqlc.arg(entity_id_substr)) > 0))
-ORDER BY creation_time ASC, ID ASC
+GROUP BY entity_id
+ORDER BY MIN(creation_time) ASC, entity_id ASC
 LIMIT ?
 OFFSET ?
 ;
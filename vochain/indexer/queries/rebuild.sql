@@ -0,0 +1,45 @@
+-- name: CreateRebuildStatus :execresult
+INSERT INTO rebuild_status (
+	id, height, target_height
+) VALUES (
+	1, ?, ?
+);
+
+-- name: DeleteAccountRotationsFromHeight :execresult
+DELETE FROM account_rotations
+WHERE height >= ?;
+
+-- name: DeleteProcesses :execresult
+DELETE FROM processes;
+
+-- name: DeleteRebuildStatus :execresult
+DELETE FROM rebuild_status;
+
+-- name: DeleteTokenTransfersFromHeight :execresult
+DELETE FROM token_transfers
+WHERE height >= ?;
+
+-- name: DeleteTxBatchReferencesFromHeight :execresult
+DELETE FROM tx_batch_references
+WHERE tx_id IN (
+	SELECT id FROM tx_references
+	WHERE block_height >= ?
+);
+
+-- name: DeleteTxReferencesFromHeight :execresult
+DELETE FROM tx_references
+WHERE block_height >= ?;
+
+-- name: DeleteVoteReferencesFromHeight :execresult
+DELETE FROM vote_references
+WHERE height >= ?;
+
+-- name: GetRebuildStatus :one
+SELECT * FROM rebuild_status
+WHERE id = 1
+LIMIT 1;
+
+-- name: SetRebuildHeight :execresult
+UPDATE rebuild_status
+SET height = ?
+WHERE id = 1;
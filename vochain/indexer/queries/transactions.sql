@@ -1,10 +1,23 @@
 -- name: CreateTxReference :execresult
 INSERT INTO tx_references (
-	hash, block_height, tx_block_index, tx_type
+	hash, block_height, tx_block_index, tx_type,
+	signer, entity_id, process_id
 ) VALUES (
-	?, ?, ?, ?
+	?, ?, ?, ?,
+	?, ?, ?
 );
 
+-- name: CreateTxBatchReference :execresult
+INSERT INTO tx_batch_references (
+	tx_id, entity_id, process_id
+) VALUES (
+	?, ?, ?
+);
+
+-- name: DeleteTxBatchReferences :execresult
+DELETE FROM tx_batch_references
+WHERE tx_id = ?;
+
 -- name: GetTxReference :one
 SELECT * FROM tx_references
 WHERE id = ?
@@ -24,3 +37,56 @@ OFFSET ?
 
 -- name: CountTxReferences :one
 SELECT COUNT(*) FROM tx_references;
+
+-- name: GetTxReferencesByAccount :many
+SELECT * FROM tx_references
+WHERE tx_references.signer = sqlc.arg(signer)
+	OR tx_references.entity_id = sqlc.arg(entity_id)
+	OR tx_references.id IN (
+		SELECT tx_batch_references.tx_id FROM tx_batch_references
+		WHERE tx_batch_references.entity_id = ?
+	)
+ORDER BY id DESC
+LIMIT ?
+OFFSET ?
+;
+
+-- name: GetTxReferencesByProcessID :many
+SELECT * FROM tx_references
+WHERE tx_references.process_id = ?
+	OR tx_references.id IN (
+		SELECT tx_batch_references.tx_id FROM tx_batch_references
+		WHERE tx_batch_references.process_id = ?
+	)
+ORDER BY id DESC
+LIMIT ?
+OFFSET ?
+;
+
+-- name: GetTxReferencesByIDRange :many
+SELECT * FROM tx_references
+WHERE id >= sqlc.arg(from_id) AND id <= sqlc.arg(to_id)
+ORDER BY id ASC
+LIMIT ?
+;
+
+-- name: UpdateTxReferenceParticipants :execresult
+UPDATE tx_references
+SET signer = ?, entity_id = ?, process_id = ?
+WHERE id = ?;
+
+-- name: GetTxBackfillStatus :one
+SELECT * FROM tx_references_backfill
+LIMIT 1;
+
+-- name: SetTxBackfillStatus :execresult
+REPLACE INTO tx_references_backfill (id, next_id, last_id)
+VALUES (1, ?, ?);
+
+-- name: SetTxBackfillNextID :execresult
+UPDATE tx_references_backfill
+SET next_id = ?
+WHERE id = 1;
+
+-- name: DeleteTxBackfillStatus :execresult
+DELETE FROM tx_references_backfill;
@@ -1,10 +1,14 @@
 -- name: CreateVoteReference :execresult
 REPLACE INTO vote_references (
 	nullifier, process_id, height, weight,
-	tx_index, voter_id, overwrite_count, creation_time
+	tx_index, voter_id, overwrite_count, creation_time,
+	vote_package, encryption_key_indexes, first_height, first_creation_time,
+	answers
 ) VALUES (
 	?, ?, ?, ?,
-	?, ?, ?, ?
+	?, ?, ?, ?,
+	?, ?, ?, ?,
+	?
 );
 
 -- name: GetVoteReference :one
@@ -15,3 +19,72 @@ LIMIT 1;
 -- name: GetVoteReferencesByProcessID :many
 SELECT * FROM vote_references
 WHERE process_id = ?;
+
+-- name: SearchProcessVoteReferences :many
+SELECT * FROM vote_references
+WHERE process_id = sqlc.arg(process_id)
+	AND (sqlc.arg(nullifier_substr) = '' OR (INSTR(LOWER(HEX(nullifier)), sqlc.arg(nullifier_substr)) > 0))
+ORDER BY height ASC, nullifier ASC
+LIMIT ?
+OFFSET ?
+;
+
+-- name: SearchVoteReferences :many
+SELECT * FROM vote_references
+WHERE INSTR(LOWER(HEX(nullifier)), sqlc.arg(nullifier_substr)) > 0
+ORDER BY height ASC, nullifier ASC
+LIMIT ?
+OFFSET ?
+;
+
+-- name: CountVoteReferences :one
+SELECT COUNT(*) FROM vote_references;
+
+-- name: GetVoteStatsByHeight :many
+-- The votes are grouped in buckets by the height of their first cast.  The
+-- weights of up to 9 digits are summed here, and the larger ones are returned
+-- to be summed by the indexer, as they overflow the integers of SQLite.
+SELECT CAST(first_height / sqlc.arg(bucket) * sqlc.arg(bucket) AS INTEGER) AS start,
+	COUNT(*) AS votes,
+	CAST(SUM(overwrite_count) AS INTEGER) AS overwrites,
+	CAST(SUM(CASE WHEN LENGTH(weight) <= 9 THEN CAST(weight AS INTEGER) ELSE 0 END) AS INTEGER) AS weight,
+	CAST(COALESCE(GROUP_CONCAT(CASE WHEN LENGTH(weight) > 9 THEN weight END), '') AS TEXT) AS large_weights
+FROM vote_references
+WHERE process_id = sqlc.arg(process_id)
+GROUP BY start
+ORDER BY start ASC
+LIMIT ?
+;
+
+-- name: GetVoteStatsByTime :many
+-- The votes are grouped in buckets by the unix time of their first cast, in
+-- seconds, summing their weights as in GetVoteStatsByHeight.
+SELECT CAST(CAST(strftime('%s', first_creation_time) AS INTEGER) / sqlc.arg(bucket) * sqlc.arg(bucket) AS INTEGER) AS start,
+	COUNT(*) AS votes,
+	CAST(SUM(overwrite_count) AS INTEGER) AS overwrites,
+	CAST(SUM(CASE WHEN LENGTH(weight) <= 9 THEN CAST(weight AS INTEGER) ELSE 0 END) AS INTEGER) AS weight,
+	CAST(COALESCE(GROUP_CONCAT(CASE WHEN LENGTH(weight) > 9 THEN weight END), '') AS TEXT) AS large_weights
+FROM vote_references
+WHERE process_id = sqlc.arg(process_id)
+GROUP BY start
+ORDER BY start ASC
+LIMIT ?
+;
+
+-- name: GetUndecodedVoteReferences :many
+SELECT * FROM vote_references
+WHERE process_id = ? AND answers = -1
+;
+
+-- name: SetVoteReferenceAnswers :execresult
+UPDATE vote_references
+SET answers = ?
+WHERE nullifier = ?;
+
+-- name: GetVoteAnswersCount :many
+SELECT answers, COUNT(*) AS votes
+FROM vote_references
+WHERE process_id = ? AND answers >= 0
+GROUP BY answers
+ORDER BY answers ASC
+;
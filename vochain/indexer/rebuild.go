@@ -0,0 +1,381 @@
+package indexer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.vocdoni.io/dvote/log"
+	indexerdb "go.vocdoni.io/dvote/vochain/indexer/db"
+	"go.vocdoni.io/dvote/vochain/indexer/indexertypes"
+	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
+)
+
+// rebuildProgressInterval is the interval between the progress logs of Rebuild.
+const rebuildProgressInterval = 10 * time.Second
+
+// RebuildPending returns whether a rebuild of the indexer was started and did
+// not finish, so it must be resumed by calling Rebuild.
+func (idx *Indexer) RebuildPending() (bool, error) {
+	queries, ctx, cancel := idx.timeoutQueries()
+	defer cancel()
+	if _, err := queries.GetRebuildStatus(ctx); errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Rebuild wipes the data indexed from the blocks and indexes it again, by
+// replaying the transactions of every block of the local blockstore through
+// the state event callbacks, on a read-only view of the state at each height.
+// The blocks committed meanwhile are not indexed by Commit, but replayed,
+// until the rebuild catches up with the chain.  Neither the state nor the
+// blockstore are modified.  Nothing is wiped if the blockstore or the state
+// history do not start at the first block, as on the nodes synced from a
+// state snapshot.
+//
+// The progress is stored after each block, so if the rebuild is interrupted,
+// calling Rebuild again resumes it, see RebuildPending.  The metadata texts
+// are kept, since they are not part of the blocks.  The votes pruned from the
+// state are indexed from their transactions, without their weight.
+func (idx *Indexer) Rebuild() error {
+	idx.rebuildLock.Lock()
+	if idx.rebuilding {
+		idx.rebuildLock.Unlock()
+		return fmt.Errorf("the indexer is already being rebuilt")
+	}
+	idx.rebuilding = true
+	idx.rebuildLock.Unlock()
+	defer func() {
+		idx.rebuildLock.Lock()
+		idx.rebuilding = false
+		idx.rebuildLock.Unlock()
+	}()
+
+	status, err := idx.startRebuild()
+	if err != nil {
+		return fmt.Errorf("cannot start the indexer rebuild: %w", err)
+	}
+	log.Infow("rebuilding the indexer", map[string]interface{}{
+		"fromHeight":   status.Height + 1,
+		"targetHeight": status.TargetHeight,
+	})
+	startTime, lastLog, lastLogHeight := time.Now(), time.Now(), uint32(status.Height)
+	height := uint32(status.Height) + 1
+	for ; ; height++ {
+		if err := idx.cancelCtx.Err(); err != nil {
+			return err
+		}
+		caughtUp, err := idx.rebuildCaughtUp(height)
+		if err != nil {
+			return err
+		}
+		if caughtUp {
+			break
+		}
+		if err := idx.replayBlock(height); err != nil {
+			return fmt.Errorf("cannot replay block %d: %w", height, err)
+		}
+		queries, ctx, cancel := idx.timeoutQueries()
+		_, err = queries.SetRebuildHeight(ctx, int64(height))
+		cancel()
+		if err != nil {
+			return err
+		}
+		if elapsed := time.Since(lastLog); elapsed > rebuildProgressInterval {
+			blocksPerSecond := float64(height-lastLogHeight) / elapsed.Seconds()
+			eta := time.Duration(0)
+			if target := uint32(status.TargetHeight); target > height && blocksPerSecond > 0 {
+				eta = time.Duration(float64(target-height)/blocksPerSecond) * time.Second
+			}
+			log.Infow("rebuilding the indexer", map[string]interface{}{
+				"height":          height,
+				"targetHeight":    status.TargetHeight,
+				"blocksPerSecond": fmt.Sprintf("%.1f", blocksPerSecond),
+				"eta":             eta.Round(time.Second).String(),
+			})
+			lastLog, lastLogHeight = time.Now(), height
+		}
+	}
+	queries, ctx, cancel := idx.timeoutQueries()
+	defer cancel()
+	if _, err := queries.DeleteRebuildStatus(ctx); err != nil {
+		return err
+	}
+	log.Infow("rebuilt the indexer", map[string]interface{}{
+		"took":       time.Since(startTime).Round(time.Second).String(),
+		"lastHeight": height - 1,
+		"processes":  idx.ProcessCount(nil),
+	})
+	return nil
+}
+
+// startRebuild returns the status of the rebuild being resumed, if any, and
+// deletes the data indexed after its last replayed block.  Otherwise, it
+// wipes the data indexed from the blocks and returns the status of a new
+// rebuild, up to the last committed block.
+func (idx *Indexer) startRebuild() (*indexerdb.RebuildStatus, error) {
+	// Deleting the whole index may take longer than the timeout of the
+	// regular queries.
+	ctx := context.Background()
+	tx, err := idx.sqlDB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Warnf("cannot rollback rebuild transaction: %v", err)
+		}
+	}()
+	queries := indexerdb.New(tx)
+	status, err := queries.GetRebuildStatus(ctx)
+	resume := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	fromHeight := status.Height + 1
+	if !resume {
+		// nothing is wiped unless the whole chain can be replayed, which is
+		// not the case on the nodes synced from a state snapshot
+		if err := idx.checkReplayFrom(1); err != nil {
+			return nil, err
+		}
+		fromHeight = 0
+	}
+	for _, deleteFromHeight := range []func(context.Context, int64) (sql.Result, error){
+		queries.DeleteVoteReferencesFromHeight,
+		queries.DeleteTxBatchReferencesFromHeight,
+		queries.DeleteTxReferencesFromHeight,
+		queries.DeleteTokenTransfersFromHeight,
+		queries.DeleteAccountRotationsFromHeight,
+	} {
+		if _, err := deleteFromHeight(ctx, fromHeight); err != nil {
+			return nil, err
+		}
+	}
+	if resume {
+		// the processes have no height, so those indexed after the last
+		// replayed block are kept, see OnProcess
+		return &status, tx.Commit()
+	}
+	if _, err := queries.DeleteProcesses(ctx); err != nil {
+		return nil, err
+	}
+	// the replayed transactions are indexed with their signers
+	if _, err := queries.DeleteTxBackfillStatus(ctx); err != nil {
+		return nil, err
+	}
+	lastHeight, err := idx.App.State.LastHeight()
+	if err != nil {
+		return nil, err
+	}
+	status = indexerdb.RebuildStatus{ID: 1, TargetHeight: int64(lastHeight)}
+	if _, err := queries.CreateRebuildStatus(ctx, indexerdb.CreateRebuildStatusParams{
+		Height:       status.Height,
+		TargetHeight: status.TargetHeight,
+	}); err != nil {
+		return nil, err
+	}
+	return &status, tx.Commit()
+}
+
+// checkReplayFrom returns an error if the blocks from height onwards cannot be
+// replayed, since the block or the state at the previous block are missing.
+func (idx *Indexer) checkReplayFrom(height uint32) error {
+	if idx.App.GetBlockByHeight(int64(height)) == nil {
+		return fmt.Errorf("block %d not found on the local blockstore", height)
+	}
+	if _, err := idx.App.State.ReplayStateAt(height - 1); err != nil {
+		return fmt.Errorf("cannot get the state at height %d: %w", height-1, err)
+	}
+	return nil
+}
+
+// rebuildCaughtUp returns whether the block at height is not committed yet,
+// so the rebuild caught up with the chain.  In that case, Commit indexes the
+// blocks from height onwards.  The lock is held while checking it, so a block
+// committed meanwhile is either replayed or indexed by Commit.
+func (idx *Indexer) rebuildCaughtUp(height uint32) (bool, error) {
+	idx.rebuildLock.Lock()
+	defer idx.rebuildLock.Unlock()
+	lastHeight, err := idx.App.State.LastHeight()
+	if err != nil || height <= lastHeight {
+		return false, err
+	}
+	idx.rebuilding = false
+	idx.liveFromHeight = height
+	return true, nil
+}
+
+// replayBlock indexes the block at height, delivering its transactions again
+// with a new indexer on the state at that height as event listener, see
+// BaseApplication.ReplayBlock.  The replay indexer shares the database, and
+// indexes the block synchronously.
+func (idx *Indexer) replayBlock(height uint32) error {
+	app, err := idx.App.ReplayApp(height)
+	if err != nil {
+		return err
+	}
+	replay := &Indexer{
+		App:                    app,
+		sqlDB:                  idx.sqlDB,
+		ignoreLiveResults:      true,
+		skipTargetHeightSleeps: true,
+		replaying:              true,
+		cancelCtx:              idx.cancelCtx,
+	}
+	replay.Rollback()
+	if _, err := idx.App.ReplayBlock(height, replay); err != nil {
+		return err
+	}
+	pids, err := app.State.ProcessIDsByStartBlock(height + 1)
+	if err != nil {
+		return err
+	}
+	if len(pids) > 0 {
+		replay.OnProcessesStart(pids)
+	}
+	return replay.Commit(height)
+}
+
+// txBackfillBatchSize is the number of transactions updated on each database
+// transaction by BackfillTxReferences.
+const txBackfillBatchSize = 1000
+
+// BackfillTxReferences fills the signer, the election and the organization of
+// the transactions indexed before they were, by decoding them again from the
+// blockstore.  The progress is stored after each batch, so calling it again
+// resumes an interrupted backfill, or retries the blocks which could not be
+// decoded.  It does nothing once the backfill is done.
+func (idx *Indexer) BackfillTxReferences() error {
+	queries, ctx, cancel := idx.timeoutQueries()
+	status, err := queries.GetTxBackfillStatus(ctx)
+	cancel()
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	log.Infow("backfilling the indexed transactions", map[string]interface{}{
+		"fromTx": status.NextID,
+		"toTx":   status.LastID,
+	})
+	startTime := time.Now()
+	for nextID := status.NextID; nextID <= status.LastID; {
+		if err := idx.cancelCtx.Err(); err != nil {
+			return err
+		}
+		queries, ctx, cancel := idx.timeoutQueries()
+		refs, err := queries.GetTxReferencesByIDRange(ctx, indexerdb.GetTxReferencesByIDRangeParams{
+			FromID: nextID,
+			ToID:   status.LastID,
+			Limit:  txBackfillBatchSize,
+		})
+		cancel()
+		if err != nil {
+			return err
+		}
+		if len(refs) == 0 {
+			break
+		}
+		nextID = refs[len(refs)-1].ID + 1
+		if err := idx.backfillTxReferences(refs, nextID); err != nil {
+			return fmt.Errorf("cannot backfill tx %d: %w", refs[0].ID, err)
+		}
+	}
+	queries, ctx, cancel = idx.timeoutQueries()
+	defer cancel()
+	if _, err := queries.DeleteTxBackfillStatus(ctx); err != nil {
+		return err
+	}
+	log.Infow("backfilled the indexed transactions", map[string]interface{}{
+		"took": time.Since(startTime).Round(time.Second).String(),
+	})
+	return nil
+}
+
+// backfillTxReferences updates a batch of transactions, and stores nextID as
+// the first one of the next batch.  If a block cannot be decoded, its
+// transactions and the following ones are left pending, so that they are
+// backfilled once the block is available, and an error is returned.
+func (idx *Indexer) backfillTxReferences(refs []indexerdb.TxReference, nextID int64) error {
+	txRefs := make([]*indexertypes.TxReference, 0, len(refs))
+	var blockTxs map[string]*vochaintx.VochainTx
+	var decodeErr error
+	for i := range refs {
+		txRef := indexertypes.TxReferenceFromDB(&refs[i])
+		if i == 0 || txRef.BlockHeight != txRefs[i-1].BlockHeight {
+			if blockTxs, decodeErr = idx.decodeBlockTxs(txRef.BlockHeight); decodeErr != nil {
+				decodeErr = fmt.Errorf("cannot decode the txs of block %d: %w",
+					txRef.BlockHeight, decodeErr)
+				nextID = refs[i].ID
+				break
+			}
+		}
+		if vtx := blockTxs[string(txRef.Hash)]; vtx != nil {
+			setTxParticipants(txRef, vtx)
+		}
+		txRefs = append(txRefs, txRef)
+	}
+
+	queries, ctx, cancel := idx.timeoutQueries()
+	defer cancel()
+	tx, err := idx.sqlDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Warnf("cannot rollback backfill transaction: %v", err)
+		}
+	}()
+	queries = queries.WithTx(tx)
+	for _, txRef := range txRefs {
+		if err := txRefEntityID(ctx, queries, txRef); err != nil {
+			return err
+		}
+		if _, err := queries.UpdateTxReferenceParticipants(ctx,
+			indexerdb.UpdateTxReferenceParticipantsParams{
+				Signer:    nonNullBytes(txRef.Signer),
+				EntityID:  nonNullBytes(txRef.EntityID),
+				ProcessID: nonNullBytes(txRef.ProcessID),
+				ID:        int64(txRef.Index),
+			}); err != nil {
+			return err
+		}
+		if _, err := queries.DeleteTxBatchReferences(ctx, int64(txRef.Index)); err != nil {
+			return err
+		}
+		if err := createTxBatchReferences(ctx, queries, int64(txRef.Index), txRef); err != nil {
+			return err
+		}
+	}
+	if _, err := queries.SetTxBackfillNextID(ctx, nextID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return decodeErr
+}
+
+// decodeBlockTxs returns the successful transactions of the block at height by
+// their hash, with the IDs of the processes they create set by their handlers,
+// since they are delivered again, see BaseApplication.ReplayBlock.
+func (idx *Indexer) decodeBlockTxs(height uint32) (map[string]*vochaintx.VochainTx, error) {
+	blockTxs, err := idx.App.ReplayBlock(height)
+	if err != nil {
+		return nil, err
+	}
+	txs := make(map[string]*vochaintx.VochainTx, len(blockTxs))
+	for _, vtx := range blockTxs {
+		if vtx != nil {
+			txs[string(vtx.TxID[:])] = vtx
+		}
+	}
+	return txs, nil
+}
@@ -0,0 +1,220 @@
+package indexer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/vochain"
+	indexerdb "go.vocdoni.io/dvote/vochain/indexer/db"
+	"go.vocdoni.io/dvote/vochain/indexer/indexertypes"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// MaxStatsBuckets is the maximum number of buckets of each kind returned by
+// ElectionStats, so the buckets must be wider for the longer elections.
+const MaxStatsBuckets = 10000
+
+// ElectionStats returns the participation statistics of the election, with
+// its votes grouped in buckets of heightBucket blocks and of timeBucket,
+// which is rounded down to seconds.  The votes are grouped by the database,
+// and it fails if there are more than MaxStatsBuckets buckets of any kind.
+func (idx *Indexer) ElectionStats(processID []byte, heightBucket uint32,
+	timeBucket time.Duration) (*indexertypes.ElectionStats, error) {
+	if heightBucket == 0 {
+		return nil, fmt.Errorf("electionStats: the height bucket is zero")
+	}
+	if timeBucket < time.Second {
+		return nil, fmt.Errorf("electionStats: the time bucket is shorter than a second")
+	}
+	p, err := idx.ProcessInfo(processID)
+	if err != nil {
+		return nil, err
+	}
+	queries, ctx, cancel := idx.timeoutQueries()
+	defer cancel()
+	byHeight, err := queries.GetVoteStatsByHeight(ctx, indexerdb.GetVoteStatsByHeightParams{
+		Bucket:    int64(heightBucket),
+		ProcessID: processID,
+		Limit:     MaxStatsBuckets + 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	byTime, err := queries.GetVoteStatsByTime(ctx, indexerdb.GetVoteStatsByTimeParams{
+		Bucket:    int64(timeBucket / time.Second),
+		ProcessID: processID,
+		Limit:     MaxStatsBuckets + 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(byHeight) > MaxStatsBuckets || len(byTime) > MaxStatsBuckets {
+		return nil, fmt.Errorf("electionStats: more than %d buckets, use wider ones",
+			MaxStatsBuckets)
+	}
+	stats := &indexertypes.ElectionStats{
+		Weight: new(types.BigInt),
+	}
+	heightRows := make([]indexerdb.GetVoteStatsByTimeRow, len(byHeight))
+	for i, row := range byHeight {
+		heightRows[i] = indexerdb.GetVoteStatsByTimeRow(row)
+	}
+	if stats.ByHeight, err = voteStatsBuckets(heightRows); err != nil {
+		return nil, err
+	}
+	if stats.ByTime, err = voteStatsBuckets(byTime); err != nil {
+		return nil, err
+	}
+	if n := len(stats.ByHeight); n > 0 {
+		stats.Votes = stats.ByHeight[n-1].CumulativeVotes
+		stats.Weight = stats.ByHeight[n-1].CumulativeWeight
+	}
+	for _, bucket := range stats.ByHeight {
+		stats.Overwrites += bucket.Overwrites
+	}
+	if stats.Abstentions, err = idx.abstentions(p); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// voteStatsBuckets builds the buckets grouped by the database, sorted by their
+// start, adding the large weights which the database cannot sum.
+func voteStatsBuckets(rows []indexerdb.GetVoteStatsByTimeRow) ([]*indexertypes.VoteStatsBucket, error) {
+	buckets := make([]*indexertypes.VoteStatsBucket, 0, len(rows))
+	for i, row := range rows {
+		bucket := &indexertypes.VoteStatsBucket{
+			Start:      row.Start,
+			Votes:      uint64(row.Votes),
+			Overwrites: uint64(row.Overwrites),
+			Weight:     new(types.BigInt).SetUint64(uint64(row.Weight)),
+		}
+		if row.LargeWeights != "" {
+			for _, w := range strings.Split(row.LargeWeights, ",") {
+				voteWeight := new(types.BigInt)
+				if err := voteWeight.UnmarshalText([]byte(w)); err != nil {
+					return nil, fmt.Errorf("cannot decode vote weight %q: %w", w, err)
+				}
+				bucket.Weight.Add(bucket.Weight, voteWeight)
+			}
+		}
+		bucket.CumulativeVotes = bucket.Votes
+		bucket.CumulativeWeight = new(types.BigInt).Add(new(types.BigInt), bucket.Weight)
+		if i > 0 {
+			bucket.CumulativeVotes += buckets[i-1].CumulativeVotes
+			bucket.CumulativeWeight.Add(bucket.CumulativeWeight, buckets[i-1].CumulativeWeight)
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+const (
+	// voteAnswersUnknown is the number of answers of the votes not decoded
+	// yet, such as the encrypted votes until the keys are revealed.
+	voteAnswersUnknown = -1
+	// voteAnswersInvalid is the number of answers of the votes which cannot
+	// be decoded, which are not counted as in the results.
+	voteAnswersInvalid = -2
+)
+
+// voteAnswers returns the number of questions answered by the decoded vote,
+// or voteAnswersInvalid if it cannot be decoded.
+func voteAnswers(vp *vochain.VotePackage, err error) int64 {
+	if err != nil {
+		return voteAnswersInvalid
+	}
+	return int64(len(vp.Votes))
+}
+
+// votesDecodable returns whether the votes of the process can be decoded one
+// by one: the homomorphic ballots never are, and the encrypted votes only once
+// the keys are revealed.
+func votesDecodable(p *indexertypes.Process) bool {
+	if p.Envelope.GetHomomorphic() || p.VoteOpts == nil {
+		return false
+	}
+	if !p.Envelope.GetEncryptedVotes() {
+		return true
+	}
+	for _, key := range p.PrivateKeys {
+		if key != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeVoteAnswers stores the number of answers of the votes of the process
+// not decoded yet, such as the encrypted votes once the keys are revealed.
+// It is called when the results are computed.  The votes are decoded from the
+// vote packages kept on their references, so the votes pruned from the state
+// are decoded too, and the votes without one stay unknown.
+func (idx *Indexer) decodeVoteAnswers(p *indexertypes.Process) error {
+	if !votesDecodable(p) {
+		return nil
+	}
+	queries, ctx, cancel := idx.timeoutQueries()
+	defer cancel()
+	undecoded, err := queries.GetUndecodedVoteReferences(ctx, p.ID)
+	if err != nil || len(undecoded) == 0 {
+		return err
+	}
+	tx, err := idx.sqlDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Warnf("cannot rollback vote answers transaction: %v", err)
+		}
+	}()
+	queries = queries.WithTx(tx)
+	for _, ref := range undecoded {
+		if len(ref.VotePackage) == 0 {
+			continue
+		}
+		answers := int64(voteAnswersInvalid)
+		if keyIndexes, err := decodeKeyIndexes(ref.EncryptionKeyIndexes); err == nil {
+			answers = voteAnswers(decodeEnvelopeVote(p, &models.StateDBVote{
+				VotePackage:          ref.VotePackage,
+				EncryptionKeyIndexes: keyIndexes,
+			}))
+		}
+		if _, err := queries.SetVoteReferenceAnswers(ctx, indexerdb.SetVoteReferenceAnswersParams{
+			Answers:   answers,
+			Nullifier: ref.Nullifier,
+		}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// abstentions returns the number of votes leaving each question of the
+// process unanswered, or nil if its votes cannot be decoded, see
+// votesDecodable.  The number of answers of each vote is stored when it is
+// indexed, or by decodeVoteAnswers.
+func (idx *Indexer) abstentions(p *indexertypes.Process) ([]uint64, error) {
+	if !votesDecodable(p) {
+		return nil, nil
+	}
+	queries, ctx, cancel := idx.timeoutQueries()
+	defer cancel()
+	rows, err := queries.GetVoteAnswersCount(ctx, p.ID)
+	if err != nil {
+		return nil, err
+	}
+	abstentions := make([]uint64, p.VoteOpts.MaxCount)
+	for _, row := range rows {
+		for i := int(row.Answers); i < len(abstentions); i++ {
+			abstentions[i] += uint64(row.Votes)
+		}
+	}
+	return abstentions, nil
+}
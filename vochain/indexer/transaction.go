@@ -1,68 +1,49 @@
 package indexer
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"sync/atomic"
 
-	"github.com/timshannon/badgerhold/v3"
+	"go.vocdoni.io/dvote/crypto/ethereum"
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/types"
 	indexerdb "go.vocdoni.io/dvote/vochain/indexer/db"
 	"go.vocdoni.io/dvote/vochain/indexer/indexertypes"
 	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
+	"go.vocdoni.io/proto/build/go/models"
 )
 
 // TransactionCount returns the number of transactions indexed
 func (s *Indexer) TransactionCount() (uint64, error) {
-	if !enableBadgerhold {
-		queries, ctx, cancel := s.timeoutQueries()
-		defer cancel()
-		count, err := queries.CountTxReferences(ctx)
-		return uint64(count), err
-	}
-	txCountStore := &indexertypes.CountStore{}
-	if err := s.db.Get(indexertypes.CountStoreTransactions, txCountStore); err != nil {
-		return txCountStore.Count, err
-	}
-	return txCountStore.Count, nil
+	queries, ctx, cancel := s.timeoutQueries()
+	defer cancel()
+	count, err := queries.CountTxReferences(ctx)
+	return uint64(count), err
 }
 
 // GetTxReference fetches the txReference for the given tx height
 func (s *Indexer) GetTxReference(height uint64) (*indexertypes.TxReference, error) {
-	if !enableBadgerhold {
-		queries, ctx, cancel := s.timeoutQueries()
-		defer cancel()
-		sqlTxRef, err := queries.GetTxReference(ctx, int64(height))
-		if err != nil {
-			return nil, fmt.Errorf("tx height %d not found: %v", height, err)
-		}
-		return indexertypes.TxReferenceFromDB(&sqlTxRef), nil
-	}
-	txReference := &indexertypes.TxReference{}
-	err := s.db.FindOne(txReference, badgerhold.Where(badgerhold.Key).Eq(height))
+	queries, ctx, cancel := s.timeoutQueries()
+	defer cancel()
+	sqlTxRef, err := queries.GetTxReference(ctx, int64(height))
 	if err != nil {
 		return nil, fmt.Errorf("tx height %d not found: %v", height, err)
 	}
-	return txReference, nil
+	return indexertypes.TxReferenceFromDB(&sqlTxRef), nil
 }
 
 // GetTxReference fetches the txReference for the given tx hash
 func (s *Indexer) GetTxHashReference(hash types.HexBytes) (*indexertypes.TxReference, error) {
-	if !enableBadgerhold {
-		queries, ctx, cancel := s.timeoutQueries()
-		defer cancel()
-		sqlTxRef, err := queries.GetTxReferenceByHash(ctx, hash)
-		if err != nil {
-			return nil, fmt.Errorf("tx hash %x not found: %v", hash, err)
-		}
-		return indexertypes.TxReferenceFromDB(&sqlTxRef), nil
-	}
-	txReference := &indexertypes.TxReference{}
-	err := s.db.FindOne(txReference, badgerhold.Where("Hash").Eq(hash).Index("Hash"))
+	queries, ctx, cancel := s.timeoutQueries()
+	defer cancel()
+	sqlTxRef, err := queries.GetTxReferenceByHash(ctx, hash)
 	if err != nil {
 		return nil, fmt.Errorf("tx hash %x not found: %v", hash, err)
 	}
-	return txReference, nil
+	return indexertypes.TxReferenceFromDB(&sqlTxRef), nil
 }
 
 // GetLastTxReferences fetches a number of the latest indexed transactions.
@@ -88,16 +69,67 @@ func (s *Indexer) GetLastTxReferences(limit, offset int32) ([]*indexertypes.TxRe
 func (s *Indexer) OnNewTx(tx *vochaintx.VochainTx, blockHeight uint32, txIndex int32) {
 	s.lockPool.Lock()
 	defer s.lockPool.Unlock()
-	s.newTxPool = append(s.newTxPool, &indexertypes.TxReference{
+	txRef := &indexertypes.TxReference{
 		Hash:         types.HexBytes(tx.TxID[:]),
 		BlockHeight:  blockHeight,
 		TxBlockIndex: txIndex,
 		TxType:       tx.TxModelType,
-	})
+	}
+	setTxParticipants(txRef, tx)
+	s.newTxPool = append(s.newTxPool, txRef)
+}
+
+// setTxParticipants sets the signer of the transaction and the election it
+// relates to, if any.  The organization of the election is only known here for
+// the new processes, so indexNewTxs looks up the rest.
+func setTxParticipants(txRef *indexertypes.TxReference, tx *vochaintx.VochainTx) {
+	if len(tx.Signature) > 0 {
+		// AddrFromSignature modifies the recovery byte of the signature
+		signer, err := ethereum.AddrFromSignature(tx.SignedBody,
+			append([]byte{}, tx.Signature...))
+		if err != nil {
+			log.Debugf("cannot recover the signer of tx %x: %v", tx.TxID, err)
+		} else {
+			txRef.Signer = signer.Bytes()
+		}
+	}
+	setTxElection(txRef, tx.Tx)
+}
+
+// setTxElection sets the election the transaction relates to, if any.  The
+// sub-transactions of a batch may relate to several elections, so they are
+// kept apart in BatchTxs, signed by the signer of the batch.
+func setTxElection(txRef *indexertypes.TxReference, tx *models.Tx) {
+	switch payload := tx.GetPayload().(type) {
+	case *models.Tx_Batch:
+		for _, subTx := range payload.Batch.GetTxs() {
+			subRef := &indexertypes.TxReference{Signer: txRef.Signer}
+			setTxElection(subRef, subTx)
+			if len(subRef.ProcessID) > 0 {
+				txRef.BatchTxs = append(txRef.BatchTxs, subRef)
+			}
+		}
+	case *models.Tx_Vote:
+		txRef.ProcessID = payload.Vote.GetProcessId()
+	case *models.Tx_NewProcess:
+		// NewProcessTxCheck sets the process ID, and the organization
+		// defaults to the signer
+		txRef.ProcessID = payload.NewProcess.GetProcess().GetProcessId()
+		txRef.EntityID = payload.NewProcess.GetProcess().GetEntityId()
+		if txRef.EntityID == nil {
+			txRef.EntityID = txRef.Signer
+		}
+	case *models.Tx_SetProcess:
+		txRef.ProcessID = payload.SetProcess.GetProcessId()
+	case *models.Tx_Admin:
+		txRef.ProcessID = payload.Admin.GetProcessId()
+	case *models.Tx_RegisterKey:
+		txRef.ProcessID = payload.RegisterKey.GetProcessId()
+	}
 }
 
-// indexNewTxs indexes the txs pending in the newTxPool and updates the transaction count
-// this function should only be called within Commit(), on a new block.
+// indexNewTxs indexes the txs pending in the newTxPool, on a single database
+// transaction.  This function should only be called within Commit(), on a new block.
 func (s *Indexer) indexNewTxs(txList []*indexertypes.TxReference) {
 	defer atomic.AddInt64(&s.liveGoroutines, -1)
 	if len(txList) == 0 {
@@ -107,48 +139,139 @@ func (s *Indexer) indexNewTxs(txList []*indexertypes.TxReference) {
 		return // closing
 	}
 
-	// TODO(mvdan): use a single transaction
 	queries, ctx, cancel := s.timeoutQueries()
 	defer cancel()
-	for _, tx := range txList {
+	tx, err := s.sqlDB.Begin()
+	if err != nil {
+		log.Errorf("cannot store txs: %v", err)
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Warnf("cannot rollback txs transaction: %v", err)
+		}
+	}()
+	queries = queries.WithTx(tx)
+	for _, txRef := range txList {
 		if s.cancelCtx.Err() != nil {
 			return // closing
 		}
-		if _, err := queries.CreateTxReference(ctx, indexerdb.CreateTxReferenceParams{
-			Hash:         tx.Hash,
-			BlockHeight:  int64(tx.BlockHeight),
-			TxBlockIndex: int64(tx.TxBlockIndex),
-			TxType:       tx.TxType,
-		}); err != nil {
-			log.Errorf("cannot store tx at height %d: %v", tx.Index, err)
+		if err := txRefEntityID(ctx, queries, txRef); err != nil {
+			log.Errorf("cannot get the organization of tx at height %d: %v",
+				txRef.BlockHeight, err)
+			return
+		}
+		res, err := queries.CreateTxReference(ctx, indexerdb.CreateTxReferenceParams{
+			Hash:         txRef.Hash,
+			BlockHeight:  int64(txRef.BlockHeight),
+			TxBlockIndex: int64(txRef.TxBlockIndex),
+			TxType:       txRef.TxType,
+			Signer:       nonNullBytes(txRef.Signer),
+			EntityID:     nonNullBytes(txRef.EntityID),
+			ProcessID:    nonNullBytes(txRef.ProcessID),
+		})
+		if err != nil {
+			log.Errorf("cannot store tx at height %d: %v", txRef.BlockHeight, err)
 			return
 		}
+		if len(txRef.BatchTxs) > 0 {
+			txID, err := res.LastInsertId()
+			if err == nil {
+				err = createTxBatchReferences(ctx, queries, txID, txRef)
+			}
+			if err != nil {
+				log.Errorf("cannot store batch tx at height %d: %v", txRef.BlockHeight, err)
+				return
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Errorf("cannot store txs: %v", err)
 	}
+}
 
-	if !enableBadgerhold {
-		return
+// txRefEntityID sets the organization of the election the transaction relates
+// to, if it is not set yet.  The processes created on the same block are
+// already indexed, since Commit creates them before indexing the txs.
+func txRefEntityID(ctx context.Context, queries *indexerdb.Queries,
+	txRef *indexertypes.TxReference) error {
+	if len(txRef.ProcessID) == 0 || len(txRef.EntityID) > 0 {
+		return nil
 	}
-	s.txIndexLock.Lock()
-	defer s.txIndexLock.Unlock()
-	txCount := &indexertypes.CountStore{}
-	if err := s.db.Get(indexertypes.CountStoreTransactions, txCount); err != nil {
-		log.Errorf("could not get tx count: %v", err)
-		return
+	entityID, err := queries.GetProcessEntityID(ctx, txRef.ProcessID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
 	}
-	for i, tx := range txList {
-		if s.cancelCtx.Err() != nil {
-			return // closing
+	txRef.EntityID = types.HexBytes(entityID)
+	return nil
+}
+
+// createTxBatchReferences stores the elections the sub-transactions of the
+// batch txRef relate to, for the transaction stored with txID.
+func createTxBatchReferences(ctx context.Context, queries *indexerdb.Queries,
+	txID int64, txRef *indexertypes.TxReference) error {
+	for _, subRef := range txRef.BatchTxs {
+		if err := txRefEntityID(ctx, queries, subRef); err != nil {
+			return err
 		}
-		// Add confirmed txs to transaction count
-		tx.Index = txCount.Count + uint64(i) + 1 // Start indexing at 1
-		if err := s.db.Insert(tx.Index, tx); err != nil {
-			log.Errorf("cannot store tx at height %d: %v", tx.Index, err)
-			return
+		if _, err := queries.CreateTxBatchReference(ctx, indexerdb.CreateTxBatchReferenceParams{
+			TxID:      txID,
+			EntityID:  nonNullBytes(subRef.EntityID),
+			ProcessID: subRef.ProcessID,
+		}); err != nil {
+			return err
 		}
 	}
-	// Store new transaction count
-	txCount.Count += uint64(len(txList))
-	if err := s.db.Upsert(indexertypes.CountStoreTransactions, txCount); err != nil {
-		log.Errorf("could not update tx count: %v", err)
+	return nil
+}
+
+// GetTxReferencesByAccount fetches the transactions signed by the given
+// account, or related to an election of the organization with that address,
+// including the batches with such a sub-transaction.
+// The first one returned is the newest, so they are in descending order.
+func (s *Indexer) GetTxReferencesByAccount(address []byte,
+	limit, offset int32) ([]*indexertypes.TxReference, error) {
+	queries, ctx, cancel := s.timeoutQueries()
+	defer cancel()
+	sqlTxRefs, err := queries.GetTxReferencesByAccount(ctx, indexerdb.GetTxReferencesByAccountParams{
+		Signer:     address,
+		EntityID:   address,
+		EntityID_2: address,
+		Limit:      limit,
+		Offset:     offset,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not get tx refs of account %x: %v", address, err)
+	}
+	txRefs := make([]*indexertypes.TxReference, len(sqlTxRefs))
+	for i, sqlTxRef := range sqlTxRefs {
+		txRefs[i] = indexertypes.TxReferenceFromDB(&sqlTxRef)
 	}
+	return txRefs, nil
+}
+
+// GetTxReferencesByProcessID fetches the transactions related to the given
+// election, including the batches with such a sub-transaction.  The first one
+// returned is the newest, so they are in descending order.
+func (s *Indexer) GetTxReferencesByProcessID(pid []byte,
+	limit, offset int32) ([]*indexertypes.TxReference, error) {
+	queries, ctx, cancel := s.timeoutQueries()
+	defer cancel()
+	sqlTxRefs, err := queries.GetTxReferencesByProcessID(ctx,
+		indexerdb.GetTxReferencesByProcessIDParams{
+			ProcessID:   pid,
+			ProcessID_2: pid,
+			Limit:       limit,
+			Offset:      offset,
+		})
+	if err != nil {
+		return nil, fmt.Errorf("could not get tx refs of process %x: %v", pid, err)
+	}
+	txRefs := make([]*indexertypes.TxReference, len(sqlTxRefs))
+	for i, sqlTxRef := range sqlTxRefs {
+		txRefs[i] = indexertypes.TxReferenceFromDB(&sqlTxRef)
+	}
+	return txRefs, nil
 }
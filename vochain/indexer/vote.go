@@ -1,6 +1,8 @@
 package indexer
 
 import (
+	"context"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -11,16 +13,13 @@ import (
 	"sync/atomic"
 	"time"
 
-	badger "github.com/dgraph-io/badger/v3"
-	"github.com/google/go-cmp/cmp"
-	"github.com/google/go-cmp/cmp/cmpopts"
-	"github.com/timshannon/badgerhold/v3"
 	"go.vocdoni.io/proto/build/go/models"
 
 	"go.vocdoni.io/dvote/crypto/nacl"
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/homomorphic"
 	indexerdb "go.vocdoni.io/dvote/vochain/indexer/db"
 	"go.vocdoni.io/dvote/vochain/indexer/indexertypes"
 	"go.vocdoni.io/dvote/vochain/state"
@@ -31,20 +30,11 @@ import (
 var ErrNoResultsYet = fmt.Errorf("no results yet")
 
 // ErrNotFoundIndatabase is raised if a database query returns no results
-var ErrNotFoundInDatabase = badgerhold.ErrNotFound
+var ErrNotFoundInDatabase = sql.ErrNoRows
 
 // Getindexertypes.VoteReference gets the reference for an AddVote transaction.
 // This reference can then be used to fetch the vote transaction directly from the BlockStore.
 func (s *Indexer) GetEnvelopeReference(nullifier []byte) (*indexertypes.VoteReference, error) {
-	bhStartTime := time.Now()
-	txRef := &indexertypes.VoteReference{}
-	if enableBadgerhold {
-		if err := s.db.FindOne(txRef, badgerhold.Where(badgerhold.Key).Eq(nullifier)); err != nil {
-			return nil, err
-		}
-		log.Debugf("GetEnvelopeReference badgerhold took %s", time.Since(bhStartTime))
-	}
-
 	sqlStartTime := time.Now()
 
 	queries, ctx, cancel := s.timeoutQueries()
@@ -77,7 +67,7 @@ func (s *Indexer) GetEnvelope(nullifier []byte) (*indexertypes.EnvelopePackage,
 	if err != nil {
 		return nil, err
 	}
-	vote, err := s.App.State.Vote(voteRef.ProcessID, nullifier, true)
+	vote, err := s.envelopeVote(voteRef.ProcessID, nullifier)
 	if err != nil {
 		return nil, err
 	}
@@ -110,6 +100,55 @@ func (s *Indexer) GetEnvelope(nullifier []byte) (*indexertypes.EnvelopePackage,
 	return envelopePackage, nil
 }
 
+// envelopeVote returns the vote from the committed state, or the copy kept by
+// the indexer if the votes of the process were pruned from the state.
+func (s *Indexer) envelopeVote(processID, nullifier []byte) (*models.StateDBVote, error) {
+	vote, err := s.App.State.Vote(processID, nullifier, true)
+	if !errors.Is(err, state.ErrVotesPruned) {
+		return vote, err
+	}
+	queries, ctx, cancel := s.timeoutQueries()
+	defer cancel()
+	voteRef, err := queries.GetVoteReference(ctx, nullifier)
+	if err != nil {
+		return nil, err
+	}
+	weight := new(types.BigInt)
+	if err := weight.UnmarshalText([]byte(voteRef.Weight)); err != nil {
+		return nil, err
+	}
+	keyIndexes, err := decodeKeyIndexes(voteRef.EncryptionKeyIndexes)
+	if err != nil {
+		return nil, err
+	}
+	overwrites := uint32(voteRef.OverwriteCount)
+	stateVote := &state.Vote{
+		ProcessID:   processID,
+		Nullifier:   nullifier,
+		VotePackage: voteRef.VotePackage,
+		Weight:      weight.ToInt(),
+	}
+	return &models.StateDBVote{
+		VoteHash:             stateVote.Hash(),
+		Nullifier:            nullifier,
+		VotePackage:          voteRef.VotePackage,
+		Weight:               stateVote.WeightBytes(),
+		EncryptionKeyIndexes: keyIndexes,
+		OverwriteCount:       &overwrites,
+	}, nil
+}
+
+// decodeKeyIndexes decodes the encryption key indexes of a vote reference.
+func decodeKeyIndexes(keyIndexes string) ([]uint32, error) {
+	indexes := []uint32{}
+	if keyIndexes != "" {
+		if err := json.Unmarshal([]byte(keyIndexes), &indexes); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal encryption key indexes: %w", err)
+		}
+	}
+	return indexes, nil
+}
+
 // WalkEnvelopes executes callback for each envelopes of the ProcessId.
 // The callback function is executed async (in a goroutine) if async=true.
 // The method will return once all goroutines have finished the work.
@@ -125,35 +164,6 @@ func (s *Indexer) WalkEnvelopes(processId []byte, async bool,
 	const limitConcurrentProcessing = 20
 	semaphore := make(chan bool, limitConcurrentProcessing)
 
-	if enableBadgerhold {
-		err := s.db.ForEach(
-			badgerhold.Where("ProcessID").Eq(processId).Index("ProcessID"),
-			func(txRef *indexertypes.VoteReference) error {
-				wg.Add(1)
-				processVote := func() {
-					defer wg.Done()
-					v, err := s.App.State.Vote(processId, txRef.Nullifier, true)
-					if err != nil {
-						log.Errorw(err, "cannot get vote from state")
-						return
-					}
-					callback(v)
-				}
-				if async {
-					go func() {
-						semaphore <- true
-						processVote()
-						<-semaphore
-					}()
-				} else {
-					processVote()
-				}
-				return nil
-			})
-		wg.Wait()
-		return err
-	}
-
 	queries, ctx, cancel := s.timeoutQueries()
 	defer cancel()
 	// TODO(sqlite): getting all votes as a single slice is not scalable.
@@ -162,10 +172,11 @@ func (s *Indexer) WalkEnvelopes(processId []byte, async bool,
 		return err
 	}
 	for _, txRef := range txRefs {
+		nullifier := txRef.Nullifier // used by the goroutines below
 		wg.Add(1)
 		processVote := func() {
 			defer wg.Done()
-			v, err := s.App.State.Vote(processId, txRef.Nullifier, true)
+			v, err := s.envelopeVote(processId, nullifier)
 			if err != nil {
 				log.Errorw(err, "cannot get vote from state")
 				return
@@ -193,112 +204,71 @@ func (s *Indexer) GetEnvelopes(processId []byte, max, from int,
 	if from < 0 {
 		return nil, fmt.Errorf("envelopeList: invalid value: from is invalid value %d", from)
 	}
-	envelopes := []*indexertypes.EnvelopeMetadata{}
+	// A zero max means no limit
+	limit := int32(max)
+	if max <= 0 {
+		limit = -1
+	}
+	queries, ctx, cancel := s.timeoutQueries()
+	defer cancel()
+	var txRefs []indexerdb.VoteReference
 	var err error
-	// TODO(sqlite): reimplement
-	// TODO: check pid
-	// TODO: get the TxHash from the Database (not from the blockstore)
 	if len(processId) > 0 {
-		err = s.db.ForEach(
-			badgerhold.Where("ProcessID").Eq(processId).Index("ProcessID").
-				And("Nullifier").MatchFunc(searchMatchFunc(searchTerm)).
-				SortBy("Height").
-				Skip(from).
-				Limit(max),
-			func(txRef *indexertypes.VoteReference) error {
-				_, txHash, err := s.App.GetTxHash(txRef.Height, txRef.TxIndex)
-				if err != nil {
-					return err
-				}
-				envelopeMetadata := &indexertypes.EnvelopeMetadata{
-					ProcessId: txRef.ProcessID,
-					Nullifier: txRef.Nullifier,
-					TxIndex:   txRef.TxIndex,
-					Height:    txRef.Height,
-					TxHash:    txHash,
-				}
-				if len(txRef.VoterID) > 0 {
-					envelopeMetadata.VoterID, err = txRef.VoterID.Address()
-					if err != nil {
-						return fmt.Errorf("cannot get voterID from pubkey: %w", err)
-					}
-				}
-				envelopes = append(envelopes, envelopeMetadata)
-				return nil
+		txRefs, err = queries.SearchProcessVoteReferences(ctx,
+			indexerdb.SearchProcessVoteReferencesParams{
+				ProcessID:       processId,
+				NullifierSubstr: searchTerm,
+				Limit:           limit,
+				Offset:          int32(from),
 			})
 	} else if len(searchTerm) > 0 { // Search nullifiers without process id
-		err = s.db.ForEach(
-			badgerhold.
-				Where("Nullifier").MatchFunc(searchMatchFunc(searchTerm)).
-				SortBy("Height").
-				Skip(from).
-				Limit(max),
-			func(txRef *indexertypes.VoteReference) error {
-				_, txHash, err := s.App.GetTxHash(txRef.Height, txRef.TxIndex)
-				if err != nil {
-					return err
-				}
-				envelopeMetadata := &indexertypes.EnvelopeMetadata{
-					ProcessId: txRef.ProcessID,
-					Nullifier: txRef.Nullifier,
-					TxIndex:   txRef.TxIndex,
-					Height:    txRef.Height,
-					TxHash:    txHash,
-				}
-				if len(txRef.VoterID) > 0 {
-					envelopeMetadata.VoterID, err = txRef.VoterID.Address()
-					if err != nil {
-						return fmt.Errorf("cannot get voterID from pubkey: %w", err)
-					}
-				}
-				envelopes = append(envelopes, envelopeMetadata)
-				return nil
-			})
+		txRefs, err = queries.SearchVoteReferences(ctx, indexerdb.SearchVoteReferencesParams{
+			NullifierSubstr: searchTerm,
+			Limit:           limit,
+			Offset:          int32(from),
+		})
 	} else {
 		return nil, fmt.Errorf("cannot get envelope status: (malformed processId)")
 	}
-	return envelopes, err
+	if err != nil {
+		return nil, err
+	}
+	// TODO: get the TxHash from the Database (not from the blockstore)
+	envelopes := []*indexertypes.EnvelopeMetadata{}
+	for _, txRef := range txRefs {
+		_, txHash, err := s.App.GetTxHash(uint32(txRef.Height), int32(txRef.TxIndex))
+		if err != nil {
+			return nil, err
+		}
+		envelopeMetadata := &indexertypes.EnvelopeMetadata{
+			ProcessId: txRef.ProcessID,
+			Nullifier: txRef.Nullifier,
+			TxIndex:   int32(txRef.TxIndex),
+			Height:    uint32(txRef.Height),
+			TxHash:    txHash,
+		}
+		if len(txRef.VoterID) > 0 {
+			envelopeMetadata.VoterID, err = txRef.VoterID.Address()
+			if err != nil {
+				return nil, fmt.Errorf("cannot get voterID from pubkey: %w", err)
+			}
+		}
+		envelopes = append(envelopes, envelopeMetadata)
+	}
+	return envelopes, nil
 }
 
 // GetEnvelopeHeight returns the number of envelopes for a processId.
 // If processId is empty, returns the total number of envelopes.
 func (s *Indexer) GetEnvelopeHeight(processID []byte) (uint64, error) {
-	if !enableBadgerhold {
-		queries, ctx, cancel := s.timeoutQueries()
-		defer cancel()
-		if len(processID) == 0 {
-			height, err := queries.GetTotalProcessEnvelopeHeight(ctx)
-			return uint64(height.(int64)), err
-		}
-		height, err := queries.GetProcessEnvelopeHeight(ctx, processID)
-		return uint64(height), err
-	}
-
-	startTime := time.Now()
-	defer func() { log.Debugf("GetEnvelopeHeight took %s", time.Since(startTime)) }()
+	queries, ctx, cancel := s.timeoutQueries()
+	defer cancel()
 	if len(processID) == 0 {
-		// If no processID is provided, count all envelopes
-		envelopeCountStore := &indexertypes.CountStore{}
-		if err := s.db.Get(indexertypes.CountStoreEnvelopes, envelopeCountStore); err != nil {
-			return 0, err
-		}
-		return envelopeCountStore.Count, nil
+		count, err := queries.CountVoteReferences(ctx)
+		return uint64(count), err
 	}
-	// Check if the envelope height is cached
-	val := s.envelopeHeightCache.Get(string(processID))
-	if val != nil {
-		return val.(uint64), nil
-	}
-	// If not cached, make the expensive query
-	results := &indexertypes.Results{}
-	if err := s.db.FindOne(results, badgerhold.Where(badgerhold.Key).Eq(processID)); err != nil {
-		return 0, err
-	}
-	// If final, store them in cache (won't change anymore)
-	if results.Final {
-		s.envelopeHeightCache.Add(string(processID), results.EnvelopeHeight)
-	}
-	return results.EnvelopeHeight, nil
+	height, err := queries.GetProcessEnvelopeHeight(ctx, processID)
+	return uint64(height), err
 }
 
 // ComputeResult process a finished voting, compute the results and saves it in the Storage.
@@ -307,8 +277,6 @@ func (s *Indexer) ComputeResult(processID []byte) error {
 	height := s.App.Height()
 	log.Debugf("computing results on height %d for %x", height, processID)
 
-	// TODO(sqlite): use a single tx for everything here
-
 	// Get process from database
 	p, err := s.ProcessInfo(processID)
 	if err != nil {
@@ -320,28 +288,8 @@ func (s *Indexer) ComputeResult(processID []byte) error {
 		return err
 	}
 
-	if enableBadgerhold {
-		// We need to use UpdateMatching here because of a race condition between ComputeResult and
-		// updateProcess. If we fetch the process, compute the results, then update the process,
-		// ComputeResult can override the process status set by updateProcess. UpdateMatching
-		// gets rid of the time between fetching and updating the process, eliminating this race.
-		if err := s.queryWithRetries(func() error {
-			return s.db.UpdateMatching(&indexertypes.Process{},
-				badgerhold.Where(badgerhold.Key).Eq(processID),
-				func(record interface{}) error {
-					update, ok := record.(*indexertypes.Process)
-					if !ok {
-						return fmt.Errorf("record isn't the correct type! Wanted Result, got %T", record)
-					}
-					update.HaveResults = true
-					update.FinalResults = true
-					return nil
-				},
-			)
-		}); err != nil {
-			return fmt.Errorf("computeResult: cannot update processID %x: %v", processID, err)
-		}
-	}
+	// The results are set with a single update, which does not modify the
+	// process status, so it cannot override the status set by updateProcess.
 	queries, ctx, cancel := s.timeoutQueries()
 	defer cancel()
 	if _, err := queries.SetProcessResultsReady(ctx, indexerdb.SetProcessResultsReadyParams{
@@ -351,15 +299,12 @@ func (s *Indexer) ComputeResult(processID []byte) error {
 		EnvelopeHeight: int64(results.EnvelopeHeight),
 		Signatures:     joinHexBytes(results.Signatures),
 		BlockHeight:    int64(results.BlockHeight),
+		RankedRounds:   encodeRankedRounds(results.RankedRounds),
 	}); err != nil {
 		return err
 	}
-	if enableBadgerhold {
-		s.addVoteLock.Lock()
-		defer s.addVoteLock.Unlock()
-		if err := s.queryWithRetries(func() error { return s.db.Upsert(processID, results) }); err != nil {
-			return err
-		}
+	if err := s.decodeVoteAnswers(p); err != nil {
+		log.Warnf("cannot decode the vote answers of %x: %v", processID, err)
 	}
 
 	// Execute callbacks
@@ -379,33 +324,6 @@ func joinHexBytes(list []types.HexBytes) string {
 
 // GetResults returns the current result for a processId
 func (s *Indexer) GetResults(processID []byte) (*indexertypes.Results, error) {
-	results := &indexertypes.Results{}
-	if enableBadgerhold {
-		startTime := time.Now()
-		defer func() { log.Debugf("GetResults took %s", time.Since(startTime)) }()
-		// Check if results are in cache and return them.
-		// Note that we don't use an atomic cache,
-		// since that would mean always inserting into the cache.
-		// TODO: remove this cache once we replace badgerhold
-		val := s.resultsCache.Get(string(processID))
-		if val != nil {
-			return val.(*indexertypes.Results), nil
-		}
-		// If not in cache, execute the expensive query
-		s.addVoteLock.RLock()
-		defer s.addVoteLock.RUnlock()
-		if err := s.db.FindOne(results, badgerhold.Where(badgerhold.Key).
-			Eq(processID)); err != nil {
-			if errors.Is(err, badgerhold.ErrNotFound) {
-				return nil, ErrNoResultsYet
-			}
-			return nil, err
-		}
-		// If results are final, store in cache for next queries
-		if results.Final {
-			s.resultsCache.Add(string(processID), results)
-		}
-	}
 	startTime := time.Now()
 	defer func() { log.Debugf("GetResults sqlite took %s", time.Since(startTime)) }()
 
@@ -414,42 +332,42 @@ func (s *Indexer) GetResults(processID []byte) (*indexertypes.Results, error) {
 	queries, ctx, cancel := s.timeoutQueries()
 	defer cancel()
 	sqlProcInner, err := queries.GetProcess(ctx, processID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoResultsYet
+	}
 	if err != nil {
 		return nil, err
 	}
-	sqlResults := indexertypes.ResultsFromDB(&sqlProcInner)
-
-	if enableBadgerhold {
-		if diff := cmp.Diff(results, sqlResults, cmpopts.IgnoreUnexported(
-			models.EnvelopeType{},
-			models.ProcessVoteOptions{},
-		)); diff != "" {
-			sqliteWarnf("ping mvdan to fix the bug with the information below:\nparams: %x\ndiff (-badger +sql):\n%s", processID, diff)
-		}
-		sqlResults = results // do not rely on sqlite yet
+	results := indexertypes.ResultsFromDB(&sqlProcInner)
+	if results == nil {
+		return nil, fmt.Errorf("cannot decode the results of %x", processID)
 	}
-	return sqlResults, nil
+	return results, nil
 }
 
 // GetResultsWeight returns the current weight of cast votes for a processId.
 func (s *Indexer) GetResultsWeight(processID []byte) (*big.Int, error) {
 	startTime := time.Now()
 	defer func() { log.Debugf("GetResultsWeight took %s", time.Since(startTime)) }()
-	s.addVoteLock.RLock()
-	defer s.addVoteLock.RUnlock()
-	results := &indexertypes.Results{}
-	if err := s.db.FindOne(results, badgerhold.Where(badgerhold.Key).Eq(processID)); err != nil {
+	queries, ctx, cancel := s.timeoutQueries()
+	defer cancel()
+	weightText, err := queries.GetResultsWeight(ctx, processID)
+	if err != nil {
+		return nil, err
+	}
+	weight := new(types.BigInt)
+	if err := weight.UnmarshalText([]byte(weightText)); err != nil {
 		return nil, err
 	}
-	return results.Weight.ToInt(), nil
+	return weight.ToInt(), nil
 }
 
-// unmarshalVote decodes the base64 payload to a VotePackage struct type.
+// UnmarshalVote decodes the base64 payload to a VotePackage struct type.
 // If the vochain.VotePackage is encrypted the list of keys to decrypt it should be provided.
 // The order of the Keys must be as it was encrypted.
 // The function will reverse the order and use the decryption keys starting from the
 // last one provided.
-func unmarshalVote(VotePackage []byte, keys []string) (*vochain.VotePackage, error) {
+func UnmarshalVote(VotePackage []byte, keys []string) (*vochain.VotePackage, error) {
 	var vote vochain.VotePackage
 	rawVote := make([]byte, len(VotePackage))
 	copy(rawVote, VotePackage)
@@ -479,7 +397,7 @@ func (s *Indexer) addLiveVote(pid []byte, VotePackage []byte, weight *big.Int,
 	// If live process, add vote to temporary results
 	var vote *vochain.VotePackage
 	if open, err := s.isOpenProcess(pid); open && err == nil {
-		vote, err = unmarshalVote(VotePackage, []string{})
+		vote, err = UnmarshalVote(VotePackage, []string{})
 		if err != nil {
 			log.Warnf("cannot unmarshal vote: %v", err)
 			vote = nil
@@ -501,40 +419,78 @@ func (s *Indexer) addLiveVote(pid []byte, VotePackage []byte, weight *big.Int,
 	return nil
 }
 
-// addVoteIndex adds the nullifier reference to the kv for fetching vote Txs from BlockStore.
-// This method is triggered by Commit callback for each vote added to the blockchain.
-// If txn is provided the vote will be added on the transaction (without performing a commit).
-func (s *Indexer) addVoteIndex(vote *state.Vote, txIndex int32, txn *badger.Txn) error {
-	creationTime := time.Now()
-	if enableBadgerhold {
-		bhVoteRef := &indexertypes.VoteReference{
-			Nullifier:      vote.Nullifier,
-			ProcessID:      vote.ProcessID,
-			VoterID:        nonNullBytes(vote.VoterID),
-			OverwriteCount: vote.Overwrites,
-			Height:         vote.Height,
-			Weight: func() *types.BigInt {
-				if vote.Weight == nil {
-					return new(types.BigInt).SetUint64(1)
-				}
-				return (*types.BigInt)(vote.Weight)
-			}(),
-			TxIndex:      txIndex,
-			CreationTime: creationTime,
+// voteWeight holds the weight change of a vote already counted, due to vote
+// delegations. Model for the voteWeightPool.
+type voteWeight struct {
+	vote           *state.Vote
+	previousWeight *big.Int
+}
+
+// addLiveVoteWeight adds the weight change of a vote already counted to the
+// results, as the difference between the vote counted with the new weight and
+// with the previous one.  It does not commit to the database.
+func (s *Indexer) addLiveVoteWeight(w *voteWeight, results *indexertypes.Results) error {
+	count := func(weight *big.Int) (*indexertypes.Results, error) {
+		r := &indexertypes.Results{
+			Votes: indexertypes.NewEmptyVotes(int(results.VoteOpts.MaxCount),
+				int(results.VoteOpts.MaxValue)+1),
+			Weight:       new(types.BigInt).SetUint64(0),
+			VoteOpts:     results.VoteOpts,
+			EnvelopeType: results.EnvelopeType,
 		}
-		if txn != nil {
-			if err := s.db.TxUpsert(txn, vote.Nullifier, bhVoteRef); err != nil {
-				return err
-			}
-		} else {
-			if err := s.queryWithRetries(func() error {
-				return s.db.Upsert(vote.Nullifier, bhVoteRef)
-			}); err != nil {
-				return err
-			}
+		return r, s.addLiveVote(w.vote.ProcessID, w.vote.VotePackage, weight, r)
+	}
+	previous, err := count(w.previousWeight)
+	if err != nil {
+		return err
+	}
+	current, err := count(w.vote.Weight)
+	if err != nil {
+		return err
+	}
+	results.Weight.Add(results.Weight, current.Weight.Sub(current.Weight, previous.Weight))
+	if len(results.Votes) == 0 {
+		results.Votes = indexertypes.NewEmptyVotes(int(results.VoteOpts.MaxCount),
+			int(results.VoteOpts.MaxValue)+1)
+	}
+	for q := range current.Votes {
+		for opt := range current.Votes[q] {
+			results.Votes[q][opt].Add(results.Votes[q][opt],
+				current.Votes[q][opt].Sub(current.Votes[q][opt], previous.Votes[q][opt]))
 		}
 	}
+	return nil
+}
 
+// addVoteIndexes adds the nullifier references of the votes to the database,
+// for fetching vote Txs from BlockStore.  All the votes are added on a single
+// database transaction.  This method is triggered by Commit for the votes
+// added to the blockchain.
+func (s *Indexer) addVoteIndexes(votes []*VoteWithIndex) error {
+	queries, ctx, cancel := s.timeoutQueries()
+	defer cancel()
+	tx, err := s.sqlDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Warnf("cannot rollback votes transaction: %v", err)
+		}
+	}()
+	queries = queries.WithTx(tx)
+	for _, v := range votes {
+		if err := s.addVoteIndex(ctx, queries, v.vote, v.txIndex); err != nil {
+			log.Errorw(err, "could not index vote")
+		}
+	}
+	return tx.Commit()
+}
+
+// addVoteIndex adds the nullifier reference of a vote using the given queries.
+func (s *Indexer) addVoteIndex(ctx context.Context, queries *indexerdb.Queries,
+	vote *state.Vote, txIndex int32) error {
+	creationTime := s.eventTime()
 	weightStr := []byte("1")
 	if vote.Weight != nil {
 		var err error
@@ -543,10 +499,28 @@ func (s *Indexer) addVoteIndex(vote *state.Vote, txIndex int32, txn *badger.Txn)
 			panic(err) // should never happen
 		}
 	}
+	// the vote package is kept for when the votes are pruned from the state
+	keyIndexes := ""
+	if len(vote.EncryptionKeyIndexes) > 0 {
+		keyIndexesJSON, err := json.Marshal(vote.EncryptionKeyIndexes)
+		if err != nil {
+			return err
+		}
+		keyIndexes = string(keyIndexesJSON)
+	}
+	// the stats count the overwritten votes from their first cast
+	firstHeight, firstCreationTime := int64(vote.Height), creationTime
+	if prev, err := queries.GetVoteReference(ctx, vote.Nullifier); err == nil {
+		firstHeight, firstCreationTime = prev.FirstHeight, prev.FirstCreationTime
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	// the encrypted votes are decoded once the keys are revealed, see decodeVoteAnswers
+	answers := int64(voteAnswersUnknown)
+	if len(vote.EncryptionKeyIndexes) == 0 {
+		answers = voteAnswers(UnmarshalVote(vote.VotePackage, []string{}))
+	}
 	sqlStartTime := time.Now()
-
-	queries, ctx, cancel := s.timeoutQueries()
-	defer cancel()
 	if _, err := queries.CreateVoteReference(ctx, indexerdb.CreateVoteReferenceParams{
 		Nullifier:      vote.Nullifier,
 		ProcessID:      vote.ProcessID,
@@ -556,8 +530,13 @@ func (s *Indexer) addVoteIndex(vote *state.Vote, txIndex int32, txn *badger.Txn)
 		OverwriteCount: int64(vote.Overwrites),
 		// VoterID has a NOT NULL constraint, so we need to provide
 		// a zero value for it since nil is not allowed
-		VoterID:      nonNullBytes(vote.VoterID),
-		CreationTime: creationTime,
+		VoterID:              nonNullBytes(vote.VoterID),
+		CreationTime:         creationTime,
+		VotePackage:          nonNullBytes(vote.VotePackage),
+		EncryptionKeyIndexes: keyIndexes,
+		FirstHeight:          firstHeight,
+		FirstCreationTime:    firstCreationTime,
+		Answers:              answers,
 	}); err != nil {
 		return err
 	}
@@ -598,49 +577,42 @@ func (s *Indexer) commitVotes(pid []byte, partialResults *indexertypes.Results,
 	// If the recovery bootstrap is running, wait
 	s.recoveryBootLock.RLock()
 	defer s.recoveryBootLock.RUnlock()
-	// The next lock avoid Transaction Conflicts
-	s.addVoteLock.Lock()
-	defer s.addVoteLock.Unlock()
 	return s.commitVotesUnsafe(pid, partialResults, height)
 }
 
 // commitVotesUnsafe does the same as commitVotes but it does not use locks.
 func (s *Indexer) commitVotesUnsafe(pid []byte, partialResults *indexertypes.Results, height uint32) error {
-
-	if enableBadgerhold {
-		update := func(record interface{}) error {
-			stored, ok := record.(*indexertypes.Results)
-			if !ok {
-				return fmt.Errorf("record isn't the correct type! Wanted Result, got %T", record)
-			}
-			// If already final, don't update.
-			if stored.Final {
-				return nil
-			}
-			return stored.Add(partialResults)
-		}
-
-		if err := s.queryWithRetries(func() error {
-			return s.db.UpdateMatching(&indexertypes.Results{},
-				badgerhold.Where(badgerhold.Key).Eq(pid), update)
-		}); err != nil {
-			log.Debugf("saved %d votes with total weight of %s on process %x", len(partialResults.Votes),
-				partialResults.Weight, pid)
-			return err
-		}
-	}
-
-	// TODO(sqlite): use a tx
 	// TODO(sqlite): getting the whole process is perhaps wasteful, but probably
 	// does not matter much in the end
 	queries, ctx, cancel := s.timeoutQueries()
 	defer cancel()
+	// The stored results are read and updated, so use a transaction to apply
+	// the queries together.
+	tx, err := s.sqlDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Warnf("cannot rollback results transaction: %v", err)
+		}
+	}()
+	queries = queries.WithTx(tx)
 	sqlProcInner, err := queries.GetProcess(ctx, pid)
 	if err != nil {
 		return err
 	}
 	results := indexertypes.ResultsFromDB(&sqlProcInner)
-	results.Add(partialResults)
+	if results == nil {
+		return fmt.Errorf("cannot decode the results of %x", pid)
+	}
+	// If already final, don't update.
+	if results.Final {
+		return nil
+	}
+	if err := results.Add(partialResults); err != nil {
+		return err
+	}
 
 	if _, err := queries.UpdateProcessResults(ctx, indexerdb.UpdateProcessResultsParams{
 		ID:             pid,
@@ -651,7 +623,53 @@ func (s *Indexer) commitVotesUnsafe(pid []byte, partialResults *indexertypes.Res
 	}); err != nil {
 		return err
 	}
-	return nil
+	return tx.Commit()
+}
+
+// recountLiveResultsUnsafe computes again the live results of a process from
+// the votes of the committed state, and replaces the stored ones.  It does not
+// use locks, and returns the recounted results.
+func (s *Indexer) recountLiveResultsUnsafe(pid []byte,
+	height uint32) (*indexertypes.Results, error) {
+	proc, err := s.ProcessInfo(pid)
+	if err != nil {
+		return nil, err
+	}
+	results := &indexertypes.Results{
+		Votes: indexertypes.NewEmptyVotes(int(proc.VoteOpts.MaxCount),
+			int(proc.VoteOpts.MaxValue)+1),
+		Weight:       new(types.BigInt).SetUint64(0),
+		VoteOpts:     proc.VoteOpts,
+		EnvelopeType: proc.Envelope,
+		BlockHeight:  height,
+	}
+	if err := s.App.State.IterateVotes(pid, true, func(vote *models.StateDBVote) bool {
+		if err := s.addLiveVote(pid, vote.VotePackage, new(big.Int).SetBytes(vote.Weight),
+			results); err != nil {
+			log.Warnf("vote cannot be added: %v", err)
+		}
+		return false
+	}); err != nil {
+		return nil, err
+	}
+
+	queries, ctx, cancel := s.timeoutQueries()
+	defer cancel()
+	if _, err := queries.UpdateProcessResults(ctx, indexerdb.UpdateProcessResultsParams{
+		ID:             pid,
+		Votes:          encodeVotes(results.Votes),
+		Weight:         results.Weight.String(),
+		EnvelopeHeight: int64(results.EnvelopeHeight),
+		BlockHeight:    int64(results.BlockHeight),
+	}); err != nil {
+		return nil, err
+	}
+	log.Debugw("recounted live results", map[string]interface{}{
+		"electionID": fmt.Sprintf("%x", pid),
+		"weight":     results.Weight.String(),
+		"votes":      results.EnvelopeHeight,
+	})
+	return results, nil
 }
 
 // computeFinalResults walks through the envelopes of a process and computes the results.
@@ -674,35 +692,21 @@ func (s *Indexer) computeFinalResults(p *indexertypes.Process) (*indexertypes.Re
 		EnvelopeType: p.Envelope,
 		BlockHeight:  s.App.Height(),
 	}
+	if p.Envelope.GetHomomorphic() {
+		return results, s.computeHomomorphicResults(p, results)
+	}
 
 	var nvotes uint64
 	var err error
 	lock := sync.Mutex{}
+	// For ranked elections keep the full preference lists, since the
+	// ranked rounds cannot be computed from the aggregated results.
+	ranked := p.Envelope.GetRankedChoice()
+	ballots := []*indexertypes.RankedBallot{}
+	ballotsLock := sync.Mutex{}
 
 	if err = s.WalkEnvelopes(p.ID, true, func(vote *models.StateDBVote) {
-		var vp *vochain.VotePackage
-		var err error
-		if p.Envelope.GetEncryptedVotes() {
-			if len(p.PrivateKeys) < len(vote.GetEncryptionKeyIndexes()) {
-				log.Error("encryptionKeyIndexes has too many fields")
-				return
-			}
-			keys := []string{}
-			for _, k := range vote.GetEncryptionKeyIndexes() {
-				if k >= types.KeyKeeperMaxKeyIndex {
-					log.Warn("key index overflow")
-					return
-				}
-				keys = append(keys, p.PrivateKeys[k])
-			}
-			if len(keys) == 0 {
-				log.Warn("no keys provided or wrong index")
-				return
-			}
-			vp, err = unmarshalVote(vote.GetVotePackage(), keys)
-		} else {
-			vp, err = unmarshalVote(vote.GetVotePackage(), []string{})
-		}
+		vp, err := decodeEnvelopeVote(p, vote)
 		if err != nil {
 			log.Debugf("vote invalid: %v", err)
 			return
@@ -712,6 +716,14 @@ func (s *Indexer) computeFinalResults(p *indexertypes.Process) (*indexertypes.Re
 			log.Warnf("addVote failed: %v", err)
 			return
 		}
+		if ranked {
+			ballotsLock.Lock()
+			ballots = append(ballots, &indexertypes.RankedBallot{
+				Preferences: vp.Votes,
+				Weight:      new(big.Int).SetBytes(vote.GetWeight()),
+			})
+			ballotsLock.Unlock()
+		}
 		atomic.AddUint64(&nvotes, 1)
 	}); err == nil {
 		log.Infow("computed results", map[string]interface{}{
@@ -721,9 +733,74 @@ func (s *Indexer) computeFinalResults(p *indexertypes.Process) (*indexertypes.Re
 		})
 	}
 	results.EnvelopeHeight = nvotes
+	if ranked {
+		results.RankedRounds = indexertypes.ComputeRankedRounds(int(p.VoteOpts.MaxValue)+1,
+			int(p.VoteOpts.GetSeats()), ballots)
+	}
 	return results, err
 }
 
+// decodeEnvelopeVote decodes the vote package of the envelope, decrypted with
+// the private keys of the process if its votes are encrypted.
+func decodeEnvelopeVote(p *indexertypes.Process,
+	vote *models.StateDBVote) (*vochain.VotePackage, error) {
+	if !p.Envelope.GetEncryptedVotes() {
+		return UnmarshalVote(vote.GetVotePackage(), []string{})
+	}
+	if len(p.PrivateKeys) < len(vote.GetEncryptionKeyIndexes()) {
+		return nil, fmt.Errorf("encryptionKeyIndexes has too many fields")
+	}
+	keys := []string{}
+	for _, k := range vote.GetEncryptionKeyIndexes() {
+		if k >= types.KeyKeeperMaxKeyIndex {
+			return nil, fmt.Errorf("key index overflow")
+		}
+		keys = append(keys, p.PrivateKeys[k])
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys provided or wrong index")
+	}
+	return UnmarshalVote(vote.GetVotePackage(), keys)
+}
+
+// computeHomomorphicResults sums the encrypted ballots of a homomorphic
+// process and decrypts the sum with the partial decryptions revealed by the
+// keykeepers.
+func (s *Indexer) computeHomomorphicResults(p *indexertypes.Process,
+	results *indexertypes.Results) error {
+	// the partial decryptions are taken from the state, which is the source
+	// of truth for the revealed keys
+	process, err := s.App.State.Process(p.ID, true)
+	if err != nil {
+		return err
+	}
+	tally := homomorphic.NewTally(process.VoteOptions)
+	if err := s.WalkEnvelopes(p.ID, true, func(vote *models.StateDBVote) {
+		if err := tally.AddVote(vote); err != nil {
+			log.Warnf("cannot add vote to the homomorphic tally: %v", err)
+		}
+	}); err != nil {
+		return err
+	}
+	votes, err := tally.Results(process)
+	if err != nil {
+		return fmt.Errorf("cannot decrypt homomorphic results: %w", err)
+	}
+	for i := range votes {
+		for j := range votes[i] {
+			results.Votes[i][j] = (*types.BigInt)(votes[i][j])
+		}
+	}
+	results.Weight = (*types.BigInt)(tally.Weight())
+	results.EnvelopeHeight = tally.Votes()
+	log.Infow("computed homomorphic results", map[string]interface{}{
+		"process": p.ID.String(),
+		"votes":   results.EnvelopeHeight,
+		"results": results.String(),
+	})
+	return nil
+}
+
 // BuildProcessResult takes the indexer Results type and builds the protobuf type ProcessResult.
 // EntityId should be provided as addition field to include in ProcessResult.
 func BuildProcessResult(results *indexertypes.Results, entityID []byte) *models.ProcessResult {
@@ -0,0 +1,99 @@
+package keykeeper
+
+import (
+	"fmt"
+
+	"go.vocdoni.io/dvote/crypto/elgamal"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain/homomorphic"
+	models "go.vocdoni.io/proto/build/go/models"
+)
+
+/*
+ Homomorphic elections
+
+ The keykeepers of homomorphic elections publish an ElGamal public key, derived
+ from the same seed as the regular encryption keys.  Once the election ends,
+ each keykeeper sums the encrypted ballots stored on the state and reveals the
+ partial decryption of the sum instead of its private key, so the individual
+ ballots are never decrypted.  The public keys are published with a proof of
+ their private keys, see homomorphic.VerifyKey.  The threshold mode is not used
+ for these elections, since the private keys are never revealed, so the
+ results cannot be decrypted if any keykeeper does not reveal its partial
+ decryption.
+*/
+
+// generateHomomorphicKeys generates the ElGamal keys of a homomorphic process.
+func (k *KeyKeeper) generateHomomorphicKeys(pid []byte) *processKeys {
+	pb := append(append([]byte{}, pid...), byte(k.myIndex))
+	priv := elgamal.NewPrivateKey(ethereum.HashRaw(append(k.signer.Private.D.Bytes(), pb...)))
+	return &processKeys{
+		privKey:     priv.Bytes(),
+		pubKey:      priv.Public().Bytes(),
+		index:       k.myIndex,
+		homomorphic: true,
+	}
+}
+
+// homomorphicKeyProof returns the proof of the private key of the homomorphic
+// process keys, which is published along with the public key.
+func homomorphicKeyProof(pid []byte, pk *processKeys) ([]byte, error) {
+	priv, err := elgamal.DecodePrivateKey(pk.privKey)
+	if err != nil {
+		return nil, err
+	}
+	return homomorphic.ProveKey(pid, int(pk.index), priv)
+}
+
+// revealPartialDecryption computes the encrypted results of a homomorphic
+// process and reveals their partial decryption.
+func (k *KeyKeeper) revealPartialDecryption(process *models.Process) error {
+	pk := k.generateHomomorphicKeys(process.ProcessId)
+	priv, err := elgamal.DecodePrivateKey(pk.privKey)
+	if err != nil {
+		return err
+	}
+	tally := homomorphic.NewTally(process.VoteOptions)
+	if err := k.vochain.State.IterateVotes(process.ProcessId, true,
+		func(vote *models.StateDBVote) bool {
+			if err := tally.AddVote(vote); err != nil {
+				log.Warnf("cannot add vote %x to the tally: (%s)", vote.Nullifier, err)
+			}
+			return false
+		}); err != nil {
+		return err
+	}
+	partial, err := tally.PartialDecryption(process.ProcessId, int(pk.index), priv)
+	if err != nil {
+		return err
+	}
+	kindex := new(uint32)
+	*kindex = uint32(pk.index)
+	tx := &models.AdminTx{
+		Txtype:               models.TxType_REVEAL_PROCESS_KEYS,
+		KeyIndex:             kindex,
+		Nonce:                uint32(util.RandomInt(0, 1000000000)),
+		ProcessId:            process.ProcessId,
+		EncryptionPrivateKey: partial,
+	}
+	if err := k.signAndSendTx(tx); err != nil {
+		return err
+	}
+	log.Infof("revealing partial decryption of %d votes for process %x",
+		tally.Votes(), process.ProcessId)
+	return k.deleteProcessKeys(string(process.ProcessId))
+}
+
+// homomorphicProcess returns the process if it is homomorphic, or nil.
+func (k *KeyKeeper) homomorphicProcess(pid []byte) (*models.Process, error) {
+	process, err := k.vochain.State.Process(pid, true)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get process from state: (%s)", err)
+	}
+	if !process.EnvelopeType.GetHomomorphic() {
+		return nil, nil
+	}
+	return process, nil
+}
@@ -1,13 +1,16 @@
 package keykeeper
 
 import (
+	"crypto/ecdsa"
 	"errors"
 	"fmt"
+	"math/big"
 	"strconv"
 	"sync"
 
 	"go.vocdoni.io/dvote/crypto/ethereum"
 	"go.vocdoni.io/dvote/crypto/nacl"
+	"go.vocdoni.io/dvote/crypto/shamir"
 	"go.vocdoni.io/dvote/db"
 	"go.vocdoni.io/dvote/db/badgerdb"
 	"go.vocdoni.io/dvote/log"
@@ -42,12 +45,19 @@ type KeyKeeper struct {
 	signer    *ethereum.SignKeys
 	lock      sync.Mutex
 	myIndex   int8
+	// threshold mode, see threshold.go
+	threshold int
+	committee map[int8]*ecdsa.PublicKey
+	sharePool map[string][]byte
+	shareLock sync.Mutex
 }
 
 type processKeys struct {
 	pubKey  []byte
 	privKey []byte
 	index   int8
+	// homomorphic is not encoded, it is only used for the pending keys
+	homomorphic bool
 }
 
 // Encode encodes processKeys to bytes
@@ -153,6 +163,9 @@ func (k *KeyKeeper) RevealUnpublished() {
 	if err := wTx.Commit(); err != nil {
 		log.Error(err)
 	}
+	if k.threshold > 0 {
+		k.revealUnpublishedShares(height)
+	}
 
 	var pid []byte
 	var process *models.Process
@@ -186,6 +199,9 @@ func (k *KeyKeeper) Rollback() {
 	defer k.lock.Unlock()
 	k.keyPool = make(map[string]*processKeys)
 	k.blockPool = make(map[string]int64)
+	k.shareLock.Lock()
+	k.sharePool = make(map[string][]byte)
+	k.shareLock.Unlock()
 }
 
 // OnProcess creates the keys and add them to the pool queue, if the process requires it
@@ -210,7 +226,9 @@ func (k *KeyKeeper) OnProcess(pid, eid []byte, censusRoot, censusURI string, txi
 	}
 
 	// Generate keys
-	if k.keyPool[string(pid)], err = k.generateKeys(pid); err != nil {
+	if p.EnvelopeType.GetHomomorphic() {
+		k.keyPool[string(pid)] = k.generateHomomorphicKeys(pid)
+	} else if k.keyPool[string(pid)], err = k.generateKeys(pid); err != nil {
 		log.Errorf("cannot generate process keys: (%s)", err)
 		return
 	}
@@ -239,6 +257,10 @@ func (k *KeyKeeper) OnCancel(pid []byte, txindex int32) { // LEGACY
 // Commit saves the pending operation
 func (k *KeyKeeper) Commit(height uint32) error {
 	k.scheduleRevealKeys()
+	if k.threshold > 0 {
+		k.storeShares()
+		go k.checkRevealShares(height)
+	}
 	go k.checkRevealProcess(height)
 	go k.publishPendingKeys()
 	return nil
@@ -249,9 +271,12 @@ func (k *KeyKeeper) OnVote(v *state.Vote, txindex int32) {
 	// do nothing
 }
 
-// OnNewTx is not used by the KeyKeeper
+// OnNewTx collects the shares of the process keys published by the other
+// keykeepers, if the threshold mode is enabled
 func (k *KeyKeeper) OnNewTx(tx *vochaintx.VochainTx, blockHeight uint32, txIndex int32) {
-	// do nothing
+	if k.threshold > 0 {
+		k.receiveShares(tx)
+	}
 }
 
 // OnProcessStatusChange will publish the private
@@ -274,14 +299,19 @@ func (k *KeyKeeper) OnProcessStatusChange(pid []byte, status models.ProcessStatu
 }
 
 // Generate Keys generates a set of encryption/commitment keys for a process.
-// Encryption private key = hash(signer.privKey + processId + keyIndex).
+// Encryption private key = hash(signer.privKey + processId + keyIndex), which
+// is reduced to a scalar of the sharing field in threshold mode.
 func (k *KeyKeeper) generateKeys(pid []byte) (*processKeys, error) {
 	// Generate keys
 	// Add the index in order to win some extra entropy
 	pb := append(pid, byte(k.myIndex))
+	seed := append(k.signer.Private.D.Bytes(), pb...)
+	privKey := ethereum.HashRaw(seed)
+	if k.threshold > 0 {
+		privKey = shamir.NewSecret(seed)
+	}
 	// Private ed25519 key
-	priv, err := nacl.DecodePrivate(fmt.Sprintf("%x",
-		ethereum.HashRaw(append(k.signer.Private.D.Bytes(), pb...))))
+	priv, err := nacl.DecodePrivate(fmt.Sprintf("%x", privKey))
 	if err != nil {
 		return nil, fmt.Errorf("cannot generate encryption key: (%s)", err)
 	}
@@ -302,31 +332,9 @@ func (k *KeyKeeper) scheduleRevealKeys() {
 	defer wTx.Discard()
 
 	for pid, height := range k.blockPool {
-		pids := models.StoredKeys{}
-		pkey := []byte(dbPrefixBlock + fmt.Sprintf("%d", height))
-		var data []byte
-		var err error
-
-		data, err = wTx.Get(pkey)
-		if err == nil {
-			// case where key does exist
-			if err := proto.Unmarshal(data, &pids); err != nil {
-				log.Errorf("cannot unmarshal process pids for block %d: (%s)", height, err)
-			}
-		} else if err != db.ErrKeyNotFound {
-			// case where Get returns an error, and is not that ErrKeyNotFound
-			log.Errorf("cannot get existing list of scheduled reveal processes for block %d", height)
-			continue
-		}
-
-		pids.Pids = append(pids.Pids, []byte(pid))
-		data, err = proto.Marshal(&pids)
-		if err != nil {
-			log.Errorf("cannot marshal new pid list for scheduling on block %d: (%s)", height, err)
-			continue
-		}
-		if err := wTx.Set(pkey, data); err != nil {
-			log.Errorf("cannot save scheduled list of pids for block %d: (%s)", height, err)
+		if err := k.scheduleReveal(wTx, []byte(pid), height); err != nil {
+			log.Errorf("cannot schedule reveal keys of process %x for block %d: (%s)",
+				pid, height, err)
 			continue
 		}
 		log.Infof("scheduled reveal keys of process %x for block %d", pid, height)
@@ -336,6 +344,26 @@ func (k *KeyKeeper) scheduleRevealKeys() {
 	}
 }
 
+// scheduleReveal adds the process to the list of processes whose keys are
+// revealed on the given height.
+func (k *KeyKeeper) scheduleReveal(wTx db.WriteTx, pid []byte, height int64) error {
+	pkey := []byte(dbPrefixBlock + fmt.Sprintf("%d", height))
+	pids := models.StoredKeys{}
+	data, err := wTx.Get(pkey)
+	if err == nil {
+		if err := proto.Unmarshal(data, &pids); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, db.ErrKeyNotFound) {
+		return err
+	}
+	pids.Pids = append(pids.Pids, pid)
+	if data, err = proto.Marshal(&pids); err != nil {
+		return err
+	}
+	return wTx.Set(pkey, data)
+}
+
 // checkRevealProcess check if keys should be revealed for height
 // and deletes the entry from the storage
 func (k *KeyKeeper) checkRevealProcess(height uint32) {
@@ -369,6 +397,22 @@ func (k *KeyKeeper) checkRevealProcess(height uint32) {
 		if !(process.EnvelopeType.Anonymous || process.EnvelopeType.EncryptedVotes) {
 			return
 		}
+		// the end block of time-bounded processes is an estimation, if the
+		// process is still open check it again on the next block
+		if _, _, ok := state.ProcessTimeBounds(process.Mode); ok &&
+			!state.ProcessEndedAt(process, k.vochain.State.CurrentTimestamp()) &&
+			process.Status != models.ProcessStatus_ENDED &&
+			process.Status != models.ProcessStatus_CANCELED {
+			if err := k.scheduleReveal(wTx, p, int64(height)+1); err != nil {
+				log.Errorf("cannot postpone reveal keys of process %x: (%s)", p, err)
+			}
+			continue
+		}
+		if k.threshold > 0 {
+			if err := k.scheduleRevealShares(wTx, p, height+shareRevealDelay); err != nil {
+				log.Errorf("cannot schedule reveal shares for %x: (%s)", p, err)
+			}
+		}
 		if process.EncryptionPublicKeys[k.myIndex] != "" {
 			log.Infof("revealing keys for process %x on block %d", p, height)
 			if err := k.revealKeys(string(p)); err != nil {
@@ -407,6 +451,19 @@ func (k *KeyKeeper) publishKeys(pk *processKeys, pid string) error {
 		ProcessId:           []byte(pid),
 		EncryptionPublicKey: pk.pubKey,
 	}
+	if k.threshold > 0 && !pk.homomorphic {
+		var err error
+		if tx.EncryptedKeyShares, tx.KeyShareCommitments, err = k.encryptShares(
+			pk.privKey); err != nil {
+			return err
+		}
+	}
+	if pk.homomorphic {
+		var err error
+		if tx.EncryptionKeyProof, err = homomorphicKeyProof([]byte(pid), pk); err != nil {
+			return err
+		}
+	}
 	if err := k.signAndSendTx(tx); err != nil {
 		return err
 	}
@@ -431,6 +488,13 @@ func (k *KeyKeeper) publishKeys(pk *processKeys, pid string) error {
 // Insecure
 // revealKeys reveals the keys for a given process
 func (k *KeyKeeper) revealKeys(pid string) error {
+	process, err := k.homomorphicProcess([]byte(pid))
+	if err != nil {
+		return err
+	}
+	if process != nil {
+		return k.revealPartialDecryption(process)
+	}
 	pk, err := k.generateKeys([]byte(pid))
 	if err != nil {
 		return err
@@ -450,10 +514,14 @@ func (k *KeyKeeper) revealKeys(pid string) error {
 	if len(pk.privKey) > 0 {
 		log.Infof("revealing encryption key for process %x", pid)
 	}
+	return k.deleteProcessKeys(pid)
+}
 
+// deleteProcessKeys deletes the stored keys of a revealed process.
+func (k *KeyKeeper) deleteProcessKeys(pid string) error {
 	wTx := k.storage.WriteTx()
 	defer wTx.Discard()
-	if err = wTx.Delete([]byte(dbPrefixProcess + pid)); err != nil {
+	if err := wTx.Delete([]byte(dbPrefixProcess + pid)); err != nil {
 		log.Warnf("cannot delete pid %x, for some reason it does not exist", pid)
 	}
 	return wTx.Commit()
@@ -501,3 +569,12 @@ func (k *KeyKeeper) OnSetAccount(addr []byte, account *state.Account) {}
 
 // OnTransferTokens does nothing
 func (k *KeyKeeper) OnTransferTokens(tx *vochaintx.TokenTransfer) {}
+
+// OnRotateAccount does nothing
+func (k *KeyKeeper) OnRotateAccount(rotation *vochaintx.AccountRotation) {}
+
+// OnVoteDelegation does nothing
+func (k *KeyKeeper) OnVoteDelegation(delegation *vochaintx.VoteDelegation, txIndex int32) {}
+
+// OnVoteWeight does nothing
+func (k *KeyKeeper) OnVoteWeight(vote *state.Vote, previousWeight *big.Int, txIndex int32) {}
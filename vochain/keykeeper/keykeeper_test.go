@@ -2,12 +2,26 @@ package keykeeper
 
 import (
 	"crypto/rand"
+	"fmt"
+	"math/big"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/google/go-cmp/cmp/cmpopts"
-
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	ctypes "github.com/tendermint/tendermint/rpc/coretypes"
+	"go.vocdoni.io/dvote/crypto/elgamal"
 	"go.vocdoni.io/dvote/crypto/nacl"
+	"go.vocdoni.io/dvote/crypto/shamir"
+	"go.vocdoni.io/dvote/db/metadb"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/homomorphic"
+	"go.vocdoni.io/dvote/vochain/state"
+	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestEncodeDecode(t *testing.T) {
@@ -27,3 +41,354 @@ func TestEncodeDecode(t *testing.T) {
 
 	qt.Assert(t, pk, qt.CmpEquals(cmpopts.IgnoreUnexported(processKeys{})), pk2)
 }
+
+// thresholdTest drives a set of keykeepers sharing a single vochain
+// application, delivering the transactions they send block by block.
+type thresholdTest struct {
+	t          *testing.T
+	app        *vochain.BaseApplication
+	keykeepers []*KeyKeeper
+	sent       [][]byte
+}
+
+func newThresholdTest(t *testing.T, n, threshold int) *thresholdTest {
+	tt := &thresholdTest{t: t, app: vochain.TestBaseApplication(t)}
+	tt.app.SetFnSendTx(func(tx []byte) (*ctypes.ResultBroadcastTx, error) {
+		tt.sent = append(tt.sent, tx)
+		return &ctypes.ResultBroadcastTx{}, nil
+	})
+	committee := make(map[int8][]byte)
+	for i, signer := range util.CreateEthRandomKeysBatch(n) {
+		qt.Assert(t, tt.app.State.AddOracle(signer.Address()), qt.IsNil)
+		qt.Assert(t, tt.app.State.CreateAccount(signer.Address(), "", nil, 0), qt.IsNil)
+		k := &KeyKeeper{
+			vochain: tt.app,
+			storage: metadb.NewTest(t),
+			signer:  signer,
+			myIndex: int8(i + 1),
+		}
+		k.Rollback()
+		committee[k.myIndex] = signer.PublicKey()
+		tt.keykeepers = append(tt.keykeepers, k)
+	}
+	for _, k := range tt.keykeepers {
+		if threshold > 0 {
+			qt.Assert(t, k.EnableThreshold(threshold, committee), qt.IsNil)
+		}
+	}
+	tt.app.AdvanceTestBlock()
+	return tt
+}
+
+// deliver delivers the sent transactions, notifying the keykeepers as the
+// event listeners of the application would do.
+func (tt *thresholdTest) deliver() {
+	for _, tx := range tt.sent {
+		resp := tt.app.DeliverTx(abcitypes.RequestDeliverTx{Tx: tx})
+		qt.Assert(tt.t, resp.Code, qt.Equals, uint32(0), qt.Commentf("%s", resp.Data))
+		vtx := new(vochaintx.VochainTx)
+		qt.Assert(tt.t, vtx.Unmarshal(tx, tt.app.ChainID()), qt.IsNil)
+		for _, k := range tt.keykeepers {
+			k.OnNewTx(vtx, tt.app.Height()+1, 0)
+		}
+	}
+	tt.sent = nil
+}
+
+// advance commits the current block, as the keykeepers would do on Commit,
+// and starts a new one.
+func (tt *thresholdTest) advance() {
+	for _, k := range tt.keykeepers {
+		k.scheduleRevealKeys()
+		k.storeShares()
+		k.Rollback()
+	}
+	tt.app.AdvanceTestBlock()
+}
+
+func (tt *thresholdTest) process(pid []byte) *models.Process {
+	process, err := tt.app.State.Process(pid, false)
+	qt.Assert(tt.t, err, qt.IsNil)
+	return process
+}
+
+func TestThresholdMissingKeyKeepers(t *testing.T) {
+	tt := newThresholdTest(t, 4, 2)
+	pid := util.RandomBytes(32)
+	startBlock := tt.app.Height() + 2
+	qt.Assert(t, tt.app.State.AddProcess(&models.Process{
+		ProcessId:             pid,
+		EntityId:              util.RandomBytes(20),
+		StartBlock:            startBlock,
+		BlockCount:            2,
+		EnvelopeType:          &models.EnvelopeType{EncryptedVotes: true},
+		Mode:                  &models.ProcessMode{},
+		VoteOptions:           &models.ProcessVoteOptions{MaxCount: 1, MaxValue: 1},
+		Status:                models.ProcessStatus_READY,
+		EncryptionPrivateKeys: make([]string, types.KeyKeeperMaxKeyIndex),
+		EncryptionPublicKeys:  make([]string, types.KeyKeeperMaxKeyIndex),
+		CensusRoot:            util.RandomBytes(32),
+		CensusOrigin:          models.CensusOrigin_OFF_CHAIN_TREE,
+	}), qt.IsNil)
+
+	// all the keykeepers publish their keys and the shares for the others
+	for _, k := range tt.keykeepers {
+		k.OnProcess(pid, nil, "", "", 0)
+		k.publishPendingKeys()
+	}
+	qt.Assert(t, tt.sent, qt.HasLen, 4)
+	tt.deliver()
+	tt.advance()
+	for i, k := range tt.keykeepers {
+		qt.Assert(t, tt.process(pid).EncryptionPublicKeys[i+1], qt.Not(qt.Equals), "")
+		rTx := k.storage.ReadTx()
+		shares, err := rTx.Get([]byte(dbPrefixShares + string(pid)))
+		rTx.Discard()
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, shares, qt.HasLen, 3*shareEntrySize)
+	}
+
+	// a keykeeper cannot add another key, which would give it another share
+	keyIndex := uint32(5)
+	qt.Assert(t, tt.keykeepers[0].signAndSendTx(&models.AdminTx{
+		Txtype:              models.TxType_ADD_PROCESS_KEYS,
+		KeyIndex:            &keyIndex,
+		ProcessId:           pid,
+		EncryptionPublicKey: util.RandomBytes(32),
+	}), qt.IsNil)
+	resp := tt.app.DeliverTx(abcitypes.RequestDeliverTx{Tx: tt.sent[0]})
+	qt.Assert(t, string(resp.Data), qt.Matches, ".*already added the keys 1")
+	tt.sent = nil
+
+	// once the process is finished, the keykeepers 3 and 4 are offline and
+	// do not reveal their keys
+	endBlock := startBlock + 2
+	for tt.app.Height() < endBlock {
+		tt.advance()
+	}
+	online := tt.keykeepers[:2]
+	for _, k := range online {
+		k.checkRevealProcess(endBlock)
+	}
+	qt.Assert(t, tt.sent, qt.HasLen, 2)
+	tt.deliver()
+	for tt.app.Height() < endBlock+shareRevealDelay {
+		tt.advance()
+	}
+	process := tt.process(pid)
+	qt.Assert(t, process.EncryptionPrivateKeys[1], qt.Not(qt.Equals), "")
+	qt.Assert(t, process.EncryptionPrivateKeys[2], qt.Not(qt.Equals), "")
+	qt.Assert(t, process.EncryptionPrivateKeys[3], qt.Equals, "")
+	qt.Assert(t, process.EncryptionPrivateKeys[4], qt.Equals, "")
+	qt.Assert(t, *process.KeyIndex, qt.Equals, uint32(2))
+
+	// a single share is not enough to reconstruct the missing keys
+	online[0].checkRevealShares(endBlock + shareRevealDelay)
+	qt.Assert(t, tt.sent, qt.HasLen, 2)
+	tt.deliver()
+	tt.advance()
+	process = tt.process(pid)
+	qt.Assert(t, process.EncryptionPrivateKeys[3], qt.Equals, "")
+	qt.Assert(t, process.EncryptionPrivateKeys[4], qt.Equals, "")
+	shares, err := tt.app.State.ProcessKeyShares(pid, 4, false)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, shares, qt.HasLen, 1)
+
+	// the shares are only revealed by the keykeeper of their coordinate, they
+	// cannot be replaced, and they must match the commitments of the key
+	for _, c := range []struct {
+		k     *KeyKeeper
+		share []byte
+		err   string
+	}{
+		{online[0], append([]byte{1}, util.RandomBytes(32)...), "key share 1 already revealed"},
+		{online[1], append([]byte{1}, util.RandomBytes(32)...), "key share 1 can only be .*"},
+		{online[1], append([]byte{17}, util.RandomBytes(32)...), "invalid key share coordinate"},
+		{online[1], append([]byte{2}, shamir.NewSecret(util.RandomBytes(32))...),
+			"share 2 does not match the commitments"},
+	} {
+		keyIndex := uint32(4)
+		qt.Assert(t, c.k.signAndSendTx(&models.AdminTx{
+			Txtype:             models.TxType_REVEAL_PROCESS_KEYS,
+			KeyIndex:           &keyIndex,
+			ProcessId:          pid,
+			EncryptionKeyShare: c.share,
+		}), qt.IsNil)
+		resp := tt.app.DeliverTx(abcitypes.RequestDeliverTx{Tx: tt.sent[0]})
+		qt.Assert(t, resp.Code, qt.Not(qt.Equals), uint32(0))
+		qt.Assert(t, string(resp.Data), qt.Matches, ".*"+c.err)
+		tt.sent = nil
+	}
+
+	// with the second share, both keys are reconstructed
+	online[1].checkRevealShares(endBlock + shareRevealDelay)
+	qt.Assert(t, tt.sent, qt.HasLen, 2)
+	tt.deliver()
+	tt.advance()
+	process = tt.process(pid)
+	for _, k := range tt.keykeepers[2:] {
+		pk, err := k.generateKeys(pid)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, process.EncryptionPrivateKeys[k.myIndex], qt.Equals,
+			fmt.Sprintf("%x", pk.privKey))
+	}
+	qt.Assert(t, *process.KeyIndex, qt.Equals, uint32(0))
+
+	// the stored shares have been removed, nothing else is revealed
+	for _, k := range online {
+		k.revealUnpublishedShares(tt.app.Height())
+	}
+	qt.Assert(t, tt.sent, qt.HasLen, 0)
+}
+
+func TestThresholdNotCommitteeMember(t *testing.T) {
+	tt := newThresholdTest(t, 3, 1)
+	k := tt.keykeepers[0]
+	committee := map[int8][]byte{
+		1: util.CreateEthRandomKeysBatch(1)[0].PublicKey(),
+		2: tt.keykeepers[1].signer.PublicKey(),
+	}
+	qt.Assert(t, k.EnableThreshold(1, committee), qt.ErrorMatches, "keykeeper 1 is not part.*")
+	committee[1] = k.signer.PublicKey()
+	qt.Assert(t, k.EnableThreshold(2, committee), qt.ErrorMatches, "threshold must be.*")
+
+	// shares published by a signer which is not the committee member are ignored
+	keyIndex := uint32(2)
+	shares, commitments, err := k.encryptShares(shamir.NewSecret(util.RandomBytes(32)))
+	qt.Assert(t, err, qt.IsNil)
+	stx := &models.SignedTx{}
+	stx.Tx, err = proto.Marshal(&models.Tx{Payload: &models.Tx_Admin{Admin: &models.AdminTx{
+		Txtype:              models.TxType_ADD_PROCESS_KEYS,
+		KeyIndex:            &keyIndex,
+		ProcessId:           util.RandomBytes(32),
+		EncryptedKeyShares:  shares,
+		KeyShareCommitments: commitments,
+	}}})
+	qt.Assert(t, err, qt.IsNil)
+	stx.Signature, err = k.signer.SignVocdoniTx(stx.Tx, tt.app.ChainID())
+	qt.Assert(t, err, qt.IsNil)
+	data, err := proto.Marshal(stx)
+	qt.Assert(t, err, qt.IsNil)
+	vtx := new(vochaintx.VochainTx)
+	qt.Assert(t, vtx.Unmarshal(data, tt.app.ChainID()), qt.IsNil)
+	tt.keykeepers[2].OnNewTx(vtx, 1, 0)
+	qt.Assert(t, tt.keykeepers[2].sharePool, qt.HasLen, 0)
+}
+
+func TestHomomorphicTally(t *testing.T) {
+	tt := newThresholdTest(t, 2, 0)
+	pid := util.RandomBytes(32)
+	startBlock := tt.app.Height() + 2
+	envelopeType := &models.EnvelopeType{EncryptedVotes: true, Homomorphic: true}
+	qt.Assert(t, tt.app.State.AddProcess(&models.Process{
+		ProcessId:             pid,
+		EntityId:              util.RandomBytes(20),
+		StartBlock:            startBlock,
+		BlockCount:            2,
+		EnvelopeType:          envelopeType,
+		Mode:                  &models.ProcessMode{},
+		VoteOptions:           &models.ProcessVoteOptions{MaxCount: 1, MaxValue: 2},
+		Status:                models.ProcessStatus_READY,
+		EncryptionPrivateKeys: make([]string, types.KeyKeeperMaxKeyIndex),
+		EncryptionPublicKeys:  make([]string, types.KeyKeeperMaxKeyIndex),
+		CensusRoot:            util.RandomBytes(32),
+		CensusOrigin:          models.CensusOrigin_OFF_CHAIN_TREE,
+	}), qt.IsNil)
+
+	// the ElGamal keys require the proof of their private key for their
+	// index, so a key chosen to cancel the others on the joint key is rejected
+	k := tt.keykeepers[0]
+	pk := k.generateHomomorphicKeys(pid)
+	wrongProof, err := homomorphicKeyProof(pid, &processKeys{privKey: pk.privKey, index: 2})
+	qt.Assert(t, err, qt.IsNil)
+	for _, proof := range [][]byte{nil, wrongProof} {
+		kindex := uint32(k.myIndex)
+		qt.Assert(t, k.signAndSendTx(&models.AdminTx{
+			Txtype:              models.TxType_ADD_PROCESS_KEYS,
+			KeyIndex:            &kindex,
+			ProcessId:           pid,
+			EncryptionPublicKey: pk.pubKey,
+			EncryptionKeyProof:  proof,
+		}), qt.IsNil)
+		resp := tt.app.DeliverTx(abcitypes.RequestDeliverTx{Tx: tt.sent[0]})
+		qt.Assert(t, string(resp.Data), qt.Contains, "invalid homomorphic encryption key")
+		tt.sent = nil
+	}
+
+	// the keykeepers publish their ElGamal keys
+	for _, k := range tt.keykeepers {
+		k.OnProcess(pid, nil, "", "", 0)
+		k.publishPendingKeys()
+	}
+	qt.Assert(t, tt.sent, qt.HasLen, 2)
+	tt.deliver()
+	tt.advance()
+
+	// the votes are encrypted with the joint key of the keykeepers
+	for i, vote := range []struct {
+		value  int
+		weight int64
+	}{{0, 1}, {2, 3}, {2, 1}} {
+		nullifier := util.RandomBytes(32)
+		ballot, err := homomorphic.NewBallot(tt.process(pid), nullifier, []int{vote.value})
+		qt.Assert(t, err, qt.IsNil)
+		vp, err := ballot.Marshal()
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, tt.app.State.AddVote(&state.Vote{
+			ProcessID:            pid,
+			Nullifier:            nullifier,
+			VotePackage:          vp,
+			Weight:               big.NewInt(vote.weight),
+			EncryptionKeyIndexes: []uint32{uint32(i%2 + 1)},
+		}), qt.IsNil)
+	}
+	endBlock := startBlock + 2
+	for tt.app.Height() < endBlock {
+		tt.advance()
+	}
+
+	tally := homomorphic.NewTally(tt.process(pid).VoteOptions)
+	qt.Assert(t, tt.app.State.IterateVotes(pid, true, func(vote *models.StateDBVote) bool {
+		qt.Assert(t, tally.AddVote(vote), qt.IsNil)
+		return false
+	}), qt.IsNil)
+
+	// a partial decryption not matching the key of the keykeeper is rejected
+	wrongKey, err := elgamal.GenerateKey(nil)
+	qt.Assert(t, err, qt.IsNil)
+	k = tt.keykeepers[0]
+	partial, err := tally.PartialDecryption(pid, int(k.myIndex), wrongKey)
+	qt.Assert(t, err, qt.IsNil)
+	kindex := uint32(k.myIndex)
+	qt.Assert(t, k.signAndSendTx(&models.AdminTx{
+		Txtype:               models.TxType_REVEAL_PROCESS_KEYS,
+		KeyIndex:             &kindex,
+		ProcessId:            pid,
+		EncryptionPrivateKey: partial,
+	}), qt.IsNil)
+	resp := tt.app.DeliverTx(abcitypes.RequestDeliverTx{Tx: tt.sent[0]})
+	qt.Assert(t, resp.Code, qt.Not(qt.Equals), uint32(0))
+	qt.Assert(t, string(resp.Data), qt.Contains, "invalid partial decryption")
+	tt.sent = nil
+
+	// the keykeepers reveal the partial decryption of the results instead of
+	// their private keys
+	for _, k := range tt.keykeepers {
+		k.checkRevealProcess(endBlock)
+	}
+	qt.Assert(t, tt.sent, qt.HasLen, 2)
+	tt.deliver()
+	tt.advance()
+	process := tt.process(pid)
+	qt.Assert(t, *process.KeyIndex, qt.Equals, uint32(0))
+	for _, k := range tt.keykeepers {
+		pk, err := k.generateKeys(pid)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, process.EncryptionPrivateKeys[k.myIndex], qt.Not(qt.Equals),
+			fmt.Sprintf("%x", pk.privKey))
+	}
+
+	results, err := tally.Results(process)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, fmt.Sprint(results), qt.Equals, "[[1 0 4]]")
+}
@@ -0,0 +1,392 @@
+package keykeeper
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/crypto/shamir"
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain/state"
+	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
+	models "go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+ Threshold mode
+
+ Each keykeeper splits its process encryption private key with Shamir's secret
+ sharing among the other keykeepers of the committee, using their keykeeper
+ index as the share coordinate.  The private key is derived as a scalar of the
+ sharing field, see shamir.NewSecret.  The shares are encrypted (ECIES) with
+ the public key of each member and included on the EncryptedKeyShares field of
+ the ADD_PROCESS_KEYS transaction, along with the Feldman commitments to the
+ shares on the KeyShareCommitments field.
+
+ Once the process is finished, if the owner of a key does not reveal it, the
+ other keykeepers reveal their shares on the EncryptionKeyShare field of
+ REVEAL_PROCESS_KEYS transactions.  The state verifies each share against the
+ commitments, and reconstructs the key once threshold shares are revealed.
+
+ KV database scheme:
+   s_{processId} = {[]keyIndex|share} // shares received for the process keys
+   r_{#block} = {[]processId} // index by block in order to reveal the shares
+*/
+
+const (
+	dbPrefixShares       = "s_"
+	dbPrefixRevealShares = "r_"
+	// shareRevealDelay is the number of blocks to wait for the owner of a key
+	// to reveal it, before revealing the shares of the key.
+	shareRevealDelay = 3
+)
+
+// shareEntrySize is the size of a stored share, the key index of the owner
+// followed by the share.
+var shareEntrySize = 1 + state.ProcessKeyShareSize
+
+// ParseCommittee parses a list of keykeeper committee members, each one with
+// the format "index:publicKey" where publicKey is the hex encoded secp256k1
+// public key of the keykeeper signer.
+func ParseCommittee(members []string) (map[int8][]byte, error) {
+	committee := make(map[int8][]byte)
+	for _, m := range members {
+		parts := strings.SplitN(m, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid committee member %q, expected index:publicKey", m)
+		}
+		index, err := strconv.ParseInt(parts[0], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid committee member index %q: %w", parts[0], err)
+		}
+		pubKey, err := hex.DecodeString(util.TrimHex(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid committee member public key %q: %w", parts[1], err)
+		}
+		committee[int8(index)] = pubKey
+	}
+	return committee, nil
+}
+
+// EnableThreshold enables the threshold mode.  The committee contains the
+// public key of each keykeeper by its index, including this one, and
+// threshold is the number of shares required to reconstruct a key which has
+// not been revealed by its owner.  Since the keys are derived differently in
+// threshold mode, it must not be enabled nor disabled while there are keys
+// pending to reveal.
+func (k *KeyKeeper) EnableThreshold(threshold int, committee map[int8][]byte) error {
+	if threshold < 1 || threshold >= len(committee) {
+		return fmt.Errorf("threshold must be between 1 and %d", len(committee)-1)
+	}
+	k.committee = make(map[int8]*ecdsa.PublicKey)
+	for index, pubKey := range committee {
+		if index < 1 || int(index) > types.KeyKeeperMaxKeyIndex {
+			return fmt.Errorf("invalid committee member index %d", index)
+		}
+		var pub *ecdsa.PublicKey
+		var err error
+		if len(pubKey) == ethereum.PubKeyLengthBytes {
+			pub, err = ethcrypto.DecompressPubkey(pubKey)
+		} else {
+			pub, err = ethcrypto.UnmarshalPubkey(pubKey)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid public key for committee member %d: %w", index, err)
+		}
+		k.committee[index] = pub
+	}
+	me, ok := k.committee[k.myIndex]
+	if !ok || ethcrypto.PubkeyToAddress(*me) != k.signer.Address() {
+		return fmt.Errorf("keykeeper %d is not part of the committee", k.myIndex)
+	}
+	k.threshold = threshold
+	log.Infof("keykeeper threshold mode enabled, %d of %d shares required",
+		threshold, len(committee)-1)
+	return nil
+}
+
+// encryptShares splits the private key among the other members of the
+// committee and encrypts each share with the public key of its owner.
+// The shares are encoded as a list of {index|size|encryptedShare}, and they
+// are returned with the commitments to verify them.
+func (k *KeyKeeper) encryptShares(privKey []byte) ([]byte, []byte, error) {
+	indexes := []int{}
+	for index := range k.committee {
+		if index != k.myIndex {
+			indexes = append(indexes, int(index))
+		}
+	}
+	sort.Ints(indexes)
+	xs := []byte{}
+	for _, index := range indexes {
+		xs = append(xs, byte(index))
+	}
+	shares, commitments, err := shamir.Split(privKey, xs, k.threshold)
+	if err != nil {
+		return nil, nil, err
+	}
+	data := []byte{}
+	for i, share := range shares {
+		encrypted, err := ecies.Encrypt(rand.Reader,
+			ecies.ImportECDSAPublic(k.committee[int8(xs[i])]), share, nil, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot encrypt share for keykeeper %d: %w", xs[i], err)
+		}
+		data = append(data, xs[i])
+		data = binary.BigEndian.AppendUint16(data, uint16(len(encrypted)))
+		data = append(data, encrypted...)
+	}
+	return data, commitments, nil
+}
+
+// decodeEncryptedShares decodes the encrypted shares encoded by encryptShares.
+func decodeEncryptedShares(data []byte) (map[int8][]byte, error) {
+	shares := make(map[int8][]byte)
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return nil, fmt.Errorf("encrypted shares too short")
+		}
+		size := int(binary.BigEndian.Uint16(data[1:3]))
+		if len(data) < 3+size {
+			return nil, fmt.Errorf("encrypted shares too short")
+		}
+		shares[int8(data[0])] = data[3 : 3+size]
+		data = data[3+size:]
+	}
+	return shares, nil
+}
+
+// receiveShares decrypts and adds to the pool the share of this keykeeper
+// included on the process keys published by another committee member, if it
+// matches the published commitments.
+func (k *KeyKeeper) receiveShares(tx *vochaintx.VochainTx) {
+	admin := tx.Tx.GetAdmin()
+	if admin == nil || admin.Txtype != models.TxType_ADD_PROCESS_KEYS ||
+		len(admin.EncryptedKeyShares) == 0 {
+		return
+	}
+	owner := int8(admin.GetKeyIndex())
+	pub, ok := k.committee[owner]
+	if !ok || owner == k.myIndex {
+		return
+	}
+	addr, err := ethereum.AddrFromSignature(tx.SignedBody, append([]byte{}, tx.Signature...))
+	if err != nil || addr != ethcrypto.PubkeyToAddress(*pub) {
+		log.Warnf("process keys %d for %x not published by the committee member",
+			owner, admin.ProcessId)
+		return
+	}
+	shares, err := decodeEncryptedShares(admin.EncryptedKeyShares)
+	if err != nil {
+		log.Warnf("cannot decode shares of process keys %d for %x: %v", owner, admin.ProcessId, err)
+		return
+	}
+	encrypted, ok := shares[k.myIndex]
+	if !ok {
+		log.Warnf("no share found on process keys %d for %x", owner, admin.ProcessId)
+		return
+	}
+	share, err := ecies.ImportECDSA(&k.signer.Private).Decrypt(encrypted, nil, nil)
+	if err != nil || len(share) != state.ProcessKeyShareSize || share[0] != byte(k.myIndex) {
+		log.Warnf("invalid share on process keys %d for %x", owner, admin.ProcessId)
+		return
+	}
+	if err := shamir.VerifyShare(share, admin.KeyShareCommitments); err != nil {
+		log.Warnf("invalid share on process keys %d for %x: %v", owner, admin.ProcessId, err)
+		return
+	}
+	k.shareLock.Lock()
+	defer k.shareLock.Unlock()
+	pid := string(admin.ProcessId)
+	k.sharePool[pid] = append(k.sharePool[pid], byte(owner))
+	k.sharePool[pid] = append(k.sharePool[pid], share...)
+	log.Debugf("received share of process keys %d for %x", owner, admin.ProcessId)
+}
+
+// storeShares saves the shares of the pool, replacing any previous share of
+// the same key.
+func (k *KeyKeeper) storeShares() {
+	k.shareLock.Lock()
+	defer k.shareLock.Unlock()
+	if len(k.sharePool) == 0 {
+		return
+	}
+	wTx := k.storage.WriteTx()
+	defer wTx.Discard()
+	for pid, received := range k.sharePool {
+		dbKey := []byte(dbPrefixShares + pid)
+		stored, err := wTx.Get(dbKey)
+		if err != nil && !errors.Is(err, db.ErrKeyNotFound) {
+			log.Errorf("cannot get stored shares for process %x: (%s)", pid, err)
+			continue
+		}
+		entries := make(map[byte][]byte)
+		for _, data := range [][]byte{stored, received} {
+			for i := 0; i+shareEntrySize <= len(data); i += shareEntrySize {
+				entries[data[i]] = data[i : i+shareEntrySize]
+			}
+		}
+		data := []byte{}
+		for _, entry := range entries {
+			data = append(data, entry...)
+		}
+		if err := wTx.Set(dbKey, data); err != nil {
+			log.Errorf("cannot store shares for process %x: (%s)", pid, err)
+		}
+	}
+	if err := wTx.Commit(); err != nil {
+		log.Error(err)
+	}
+	k.sharePool = make(map[string][]byte)
+}
+
+// scheduleRevealShares schedules the reveal of the stored shares of a process
+// for the given height.
+func (k *KeyKeeper) scheduleRevealShares(wTx db.WriteTx, pid []byte, height uint32) error {
+	if _, err := wTx.Get([]byte(dbPrefixShares + string(pid))); err != nil {
+		if errors.Is(err, db.ErrKeyNotFound) {
+			return nil
+		}
+		return err
+	}
+	pkey := []byte(dbPrefixRevealShares + fmt.Sprintf("%d", height))
+	pids := models.StoredKeys{}
+	data, err := wTx.Get(pkey)
+	if err == nil {
+		if err := proto.Unmarshal(data, &pids); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, db.ErrKeyNotFound) {
+		return err
+	}
+	pids.Pids = append(pids.Pids, pid)
+	if data, err = proto.Marshal(&pids); err != nil {
+		return err
+	}
+	log.Infof("scheduled reveal shares of process %x for block %d", pid, height)
+	return wTx.Set(pkey, data)
+}
+
+// checkRevealShares reveals the shares of the processes scheduled for height
+// and deletes the entry from the storage.
+func (k *KeyKeeper) checkRevealShares(height uint32) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	pkey := []byte(dbPrefixRevealShares + fmt.Sprintf("%d", height))
+
+	wTx := k.storage.WriteTx()
+	defer wTx.Discard()
+
+	data, err := wTx.Get(pkey)
+	if errors.Is(err, db.ErrKeyNotFound) {
+		return
+	}
+	if err != nil {
+		log.Errorf("cannot get reveal shares for block %d", height)
+		return
+	}
+	var pids models.StoredKeys
+	if err := proto.Unmarshal(data, &pids); err != nil {
+		log.Errorf("cannot unmarshal process pids for block %d: (%s)", height, err)
+		return
+	}
+	for _, p := range pids.GetPids() {
+		if err := k.revealShares(string(p)); err != nil {
+			log.Errorf("cannot reveal shares for process %x: (%s)", p, err)
+		}
+	}
+	if err := wTx.Delete(pkey); err != nil {
+		log.Errorf("cannot delete revealed shares for block %d: (%s)", height, err)
+	}
+	if err := wTx.Commit(); err != nil {
+		log.Error(err)
+	}
+}
+
+// revealShares reveals the stored shares of the process keys that have not
+// been revealed by their owners, and deletes them from the storage.
+func (k *KeyKeeper) revealShares(pid string) error {
+	dbKey := []byte(dbPrefixShares + pid)
+
+	wTx := k.storage.WriteTx()
+	defer wTx.Discard()
+
+	data, err := wTx.Get(dbKey)
+	if errors.Is(err, db.ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	process, err := k.vochain.State.Process([]byte(pid), false)
+	if err != nil {
+		return err
+	}
+	for i := 0; i+shareEntrySize <= len(data); i += shareEntrySize {
+		index := uint32(data[i])
+		if int(index) >= len(process.EncryptionPrivateKeys) ||
+			process.EncryptionPublicKeys[index] == "" ||
+			process.EncryptionPrivateKeys[index] != "" {
+			continue
+		}
+		log.Infof("revealing share of encryption key %d for process %x", index, pid)
+		tx := &models.AdminTx{
+			Txtype:             models.TxType_REVEAL_PROCESS_KEYS,
+			KeyIndex:           &index,
+			Nonce:              uint32(util.RandomInt(0, 1000000000)),
+			ProcessId:          []byte(pid),
+			EncryptionKeyShare: data[i+1 : i+shareEntrySize],
+		}
+		if err := k.signAndSendTx(tx); err != nil {
+			log.Errorf("cannot reveal share of encryption key %d for process %x: (%s)",
+				index, pid, err)
+		}
+	}
+	if err := wTx.Delete(dbKey); err != nil {
+		return err
+	}
+	return wTx.Commit()
+}
+
+// revealUnpublishedShares reveals the stored shares of the finished processes.
+func (k *KeyKeeper) revealUnpublishedShares(height uint32) {
+	pids := []string{}
+	if err := k.storage.Iterate([]byte(dbPrefixShares), func(key, value []byte) bool {
+		pid := key[len(dbPrefixShares):]
+		process, err := k.vochain.State.Process(pid, true)
+		if err != nil {
+			log.Error(err)
+			return true
+		}
+		finished := height > process.StartBlock+process.BlockCount+shareRevealDelay
+		if _, _, ok := state.ProcessTimeBounds(process.Mode); ok {
+			finished = state.ProcessEndedAt(process, k.vochain.State.CurrentTimestamp())
+		}
+		if process.Status == models.ProcessStatus_CANCELED ||
+			process.Status == models.ProcessStatus_ENDED || finished {
+			pids = append(pids, string(pid))
+		}
+		return true
+	}); err != nil {
+		log.Error(err)
+	}
+	for _, pid := range pids {
+		log.Warnf("found pending shares for reveal on process %x", pid)
+		if err := k.revealShares(pid); err != nil {
+			log.Error(err)
+		}
+	}
+}
@@ -1,19 +1,109 @@
 package offchaindatahandler
 
 import (
+	"encoding/json"
+	"sort"
 	"strings"
 
+	"go.vocdoni.io/dvote/api"
 	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/vochain/indexer/indexertypes"
 )
 
-// enqueueMetadata enqueue a election metadata for download.
+// enqueueMetadata enqueue a election or account metadata for download.
+// If an indexer is set, the downloaded metadata is indexed for searching.
 // (safe for concurrent use, simply pushes an item to a channel)
-func (d *OffChainDataHandler) enqueueMetadata(uri string) {
+func (d *OffChainDataHandler) enqueueMetadata(itemType int, id []byte, uri string) {
 	if !strings.HasPrefix(uri, d.storage.RemoteStorage.URIprefix()) {
 		log.Warnf("metadata URI not valid: %s", uri)
 		return
 	}
 	d.storage.AddToQueue(uri, func(s string, b []byte) {
 		log.Infof("metadata downloaded successfully from %s (%d bytes)", s, len(b))
+		if d.indexer == nil {
+			return
+		}
+		if err := d.indexMetadata(itemType, id, b); err != nil {
+			log.Warnf("cannot index metadata %s of %x: %v", s, id, err)
+		}
 	}, true)
 }
+
+// indexMetadata parses the election or account metadata and stores its texts
+// on the indexer.
+func (d *OffChainDataHandler) indexMetadata(itemType int, id, data []byte) error {
+	switch itemType {
+	case itemTypeElectionMetadata:
+		metadata := &api.ElectionMetadata{}
+		if err := json.Unmarshal(data, metadata); err != nil {
+			return err
+		}
+		return d.indexer.SetElectionMetadata(id, electionMetadataTexts(metadata))
+	case itemTypeAccountMetadata:
+		metadata := &api.AccountMetadata{}
+		if err := json.Unmarshal(data, metadata); err != nil {
+			return err
+		}
+		return d.indexer.SetAccountMetadata(id, accountMetadataTexts(metadata))
+	}
+	return nil
+}
+
+// electionMetadataTexts returns the searchable texts of the election metadata
+// for each of its languages.
+func electionMetadataTexts(metadata *api.ElectionMetadata) []*indexertypes.MetadataText {
+	strs := []api.LanguageString{metadata.Title, metadata.Description}
+	for _, q := range metadata.Questions {
+		strs = append(strs, q.Title, q.Description)
+		for _, c := range q.Choices {
+			strs = append(strs, c.Title)
+		}
+	}
+	texts := []*indexertypes.MetadataText{}
+	for _, lang := range languages(strs...) {
+		questions := []string{}
+		for _, q := range metadata.Questions {
+			questions = append(questions, q.Title[lang], q.Description[lang])
+			for _, c := range q.Choices {
+				questions = append(questions, c.Title[lang])
+			}
+		}
+		texts = append(texts, &indexertypes.MetadataText{
+			Language:    lang,
+			Title:       metadata.Title[lang],
+			Description: metadata.Description[lang],
+			Questions:   strings.Join(questions, "\n"),
+		})
+	}
+	return texts
+}
+
+// accountMetadataTexts returns the searchable texts of the account metadata
+// for each of its languages.  The title is the account name.
+func accountMetadataTexts(metadata *api.AccountMetadata) []*indexertypes.MetadataText {
+	texts := []*indexertypes.MetadataText{}
+	for _, lang := range languages(metadata.Name, metadata.Description) {
+		texts = append(texts, &indexertypes.MetadataText{
+			Language:    lang,
+			Title:       metadata.Name[lang],
+			Description: metadata.Description[lang],
+		})
+	}
+	return texts
+}
+
+// languages returns the sorted languages of the strings.
+func languages(strs ...api.LanguageString) []string {
+	found := make(map[string]bool)
+	for _, s := range strs {
+		for lang := range s {
+			found[lang] = true
+		}
+	}
+	langs := []string{}
+	for lang := range found {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
@@ -1,6 +1,7 @@
 package offchaindatahandler
 
 import (
+	"math/big"
 	"sync"
 
 	"go.vocdoni.io/dvote/api/censusdb"
@@ -8,6 +9,7 @@ import (
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/util"
 	"go.vocdoni.io/dvote/vochain"
+	"go.vocdoni.io/dvote/vochain/indexer"
 	"go.vocdoni.io/dvote/vochain/state"
 	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
 	"go.vocdoni.io/proto/build/go/models"
@@ -26,6 +28,8 @@ type importItem struct {
 	uri        string
 	censusRoot string
 	pid        []byte
+	// id is the election ID or account address of the metadata
+	id []byte
 }
 
 // TBD: A startup process for importing on-going process census
@@ -37,6 +41,7 @@ type OffChainDataHandler struct {
 	vochain       *vochain.BaseApplication
 	census        *censusdb.CensusDB
 	storage       *downloader.Downloader
+	indexer       *indexer.Indexer
 	queue         []importItem
 	queueLock     sync.RWMutex
 	importOnlyNew bool
@@ -58,6 +63,14 @@ func NewOffChainDataHandler(v *vochain.BaseApplication, d *downloader.Downloader
 	return &od
 }
 
+// SetIndexer sets the indexer where the downloaded election and account
+// metadata is indexed for searching.
+func (c *OffChainDataHandler) SetIndexer(idx *indexer.Indexer) {
+	c.queueLock.Lock()
+	defer c.queueLock.Unlock()
+	c.indexer = idx
+}
+
 func (c *OffChainDataHandler) Rollback() {
 	c.queueLock.Lock()
 	c.queue = make([]importItem, 0)
@@ -78,7 +91,7 @@ func (c *OffChainDataHandler) Commit(height uint32) error {
 			go c.enqueueOffchainCensus(item.censusRoot, item.uri)
 		case itemTypeElectionMetadata, itemTypeAccountMetadata:
 			log.Infof("importing metadata from %s", item.uri)
-			go c.enqueueMetadata(item.uri)
+			go c.enqueueMetadata(item.itemType, item.id, item.uri)
 		case itemTypeRollingCensus:
 			log.Infof("importing rolling census for process %x", item.pid)
 			c.importRollingCensus(item.pid)
@@ -107,6 +120,7 @@ func (c *OffChainDataHandler) OnProcess(pid, eid []byte, censusRoot, censusURI s
 			c.queue = append(c.queue, importItem{
 				uri:      m,
 				itemType: itemTypeElectionMetadata,
+				id:       pid,
 			})
 		}
 		// enqueue for download external census if needs to be imported
@@ -153,6 +167,7 @@ func (c *OffChainDataHandler) OnSetAccount(addr []byte, account *state.Account)
 			c.queue = append(c.queue, importItem{
 				uri:      m,
 				itemType: itemTypeAccountMetadata,
+				id:       addr,
 			})
 		}
 	}
@@ -166,6 +181,13 @@ func (c *OffChainDataHandler) OnProcessKeys(pid []byte, pub string, txindex int3
 func (c *OffChainDataHandler) OnRevealKeys(pid []byte, priv string, txindex int32)                {}
 func (c *OffChainDataHandler) OnProcessStatusChange(pid []byte, status models.ProcessStatus, txindex int32) {
 }
-func (c *OffChainDataHandler) OnTransferTokens(tx *vochaintx.TokenTransfer) {}
+func (c *OffChainDataHandler) OnTransferTokens(tx *vochaintx.TokenTransfer)        {}
+func (c *OffChainDataHandler) OnRotateAccount(rotation *vochaintx.AccountRotation) {}
+func (c *OffChainDataHandler) OnVoteDelegation(delegation *vochaintx.VoteDelegation,
+	txindex int32) {
+}
+func (c *OffChainDataHandler) OnVoteWeight(vote *state.Vote, previousWeight *big.Int,
+	txindex int32) {
+}
 func (c *OffChainDataHandler) OnProcessResults(pid []byte, results *models.ProcessResult, txindex int32) {
 }
@@ -129,6 +129,50 @@ func testNewProcess(t *testing.T, pid []byte, txSender *ethereum.SignKeys,
 	return testCheckTxDeliverTxCommit(t, app, &stx)
 }
 
+func TestNewProcessRankedChoice(t *testing.T) {
+	app, accounts := createTestBaseApplicationAndAccounts(t, 10)
+
+	censusURI := ipfsUrl
+	newProcess := func(envelope *models.EnvelopeType, opts *models.ProcessVoteOptions) *models.Process {
+		return &models.Process{
+			ProcessId:    util.RandomBytes(types.ProcessIDsize),
+			EnvelopeType: envelope,
+			Mode:         &models.ProcessMode{Interruptible: true},
+			VoteOptions:  opts,
+			Status:       models.ProcessStatus_READY,
+			EntityId:     accounts[1].Address().Bytes(),
+			CensusRoot:   util.RandomBytes(32),
+			CensusURI:    &censusURI,
+			CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+			BlockCount:   1024,
+		}
+	}
+	ranked := &models.EnvelopeType{UniqueValues: true, RankedChoice: true}
+
+	// rank 4 candidates for 2 seats (should work)
+	process := newProcess(ranked, &models.ProcessVoteOptions{MaxCount: 4, MaxValue: 3, Seats: 2})
+	qt.Assert(t, testNewProcess(t, process.ProcessId, accounts[1], app, process), qt.IsNil)
+
+	// seats without ranked choice (should not work)
+	process = newProcess(&models.EnvelopeType{UniqueValues: true},
+		&models.ProcessVoteOptions{MaxCount: 4, MaxValue: 3, Seats: 2})
+	qt.Assert(t, testNewProcess(t, process.ProcessId, accounts[1], app, process),
+		qt.ErrorMatches, ".*seats are only supported on ranked choice.*")
+	// ranked choice without unique values (should not work)
+	process = newProcess(&models.EnvelopeType{RankedChoice: true},
+		&models.ProcessVoteOptions{MaxCount: 4, MaxValue: 3})
+	qt.Assert(t, testNewProcess(t, process.ProcessId, accounts[1], app, process),
+		qt.ErrorMatches, ".*require unique values.*")
+	// more positions than candidates (should not work)
+	process = newProcess(ranked, &models.ProcessVoteOptions{MaxCount: 5, MaxValue: 3})
+	qt.Assert(t, testNewProcess(t, process.ProcessId, accounts[1], app, process),
+		qt.ErrorMatches, ".*maxCount.*bigger than the number of candidates.*")
+	// more seats than candidates (should not work)
+	process = newProcess(ranked, &models.ProcessVoteOptions{MaxCount: 4, MaxValue: 3, Seats: 5})
+	qt.Assert(t, testNewProcess(t, process.ProcessId, accounts[1], app, process),
+		qt.ErrorMatches, ".*seats.*bigger than the number of candidates.*")
+}
+
 func TestProcessSetStatusCheckTxDeliverTxCommitTransitions(t *testing.T) {
 	app, keys := createTestBaseApplicationAndAccounts(t, 10)
 	// add a process with status=READY and interruptible=true
@@ -3,14 +3,19 @@ package vochain
 import (
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"testing"
 
+	"github.com/ethereum/go-ethereum/common"
+	qt "github.com/frankban/quicktest"
 	abcitypes "github.com/tendermint/tendermint/abci/types"
 	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
 	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/crypto/ethereum/storagelayout"
 	"go.vocdoni.io/dvote/test/testcommon/testutil"
 	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain/transaction"
 	models "go.vocdoni.io/proto/build/go/models"
 	"google.golang.org/protobuf/proto"
 )
@@ -57,6 +62,56 @@ func TestEthProof(t *testing.T) {
 	testEthSendVotes(t, sp.StorageProofs[2], pid, vp, app, false)
 }
 
+func TestEVMStorageProof(t *testing.T) {
+	sp := testStorageProofs{}
+	qt.Assert(t, json.Unmarshal([]byte(ethVotingProofs), &sp), qt.IsNil)
+
+	// the ERC20 balances are a single mapping keyed by the holder, so the
+	// same proofs are valid for the equivalent storage layout
+	process := &models.Process{
+		ProcessId:    util.RandomBytes(types.ProcessIDsize),
+		CensusRoot:   testEthStorageRoot,
+		CensusOrigin: models.CensusOrigin_EVM_STORAGE,
+		StorageLayout: &models.EVMStorageLayout{
+			Slot: uint64(testEthIndexSlot),
+			Keys: []string{storagelayout.HolderKey},
+		},
+	}
+	proof := func(s testStorageProof) *models.Proof {
+		return &models.Proof{Payload: &models.Proof_EthereumStorage{
+			EthereumStorage: &models.ProofEthereumStorage{
+				Key:      s.StorageProof.Key,
+				Value:    s.StorageProof.Value,
+				Siblings: s.StorageProof.Proof,
+			},
+		}}
+	}
+	for _, s := range sp.StorageProofs {
+		valid, weight, err := transaction.VerifyProof(process, proof(s), process.CensusOrigin,
+			process.CensusRoot, process.ProcessId, nil, common.HexToAddress(s.Address))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, valid, qt.IsTrue)
+		qt.Assert(t, weight.Cmp(new(big.Int).SetBytes(s.StorageProof.Value)), qt.Equals, 0)
+	}
+
+	// the proof of a holder cannot be used by another one
+	s := sp.StorageProofs[0]
+	_, _, err := transaction.VerifyProof(process, proof(s), process.CensusOrigin,
+		process.CensusRoot, process.ProcessId, nil, common.HexToAddress(sp.StorageProofs[1].Address))
+	qt.Assert(t, err, qt.ErrorMatches, ".*proof key and holder storage key do not match.*")
+
+	// the proof must match the storage layout of the process
+	process.StorageLayout.Slot++
+	_, _, err = transaction.VerifyProof(process, proof(s), process.CensusOrigin,
+		process.CensusRoot, process.ProcessId, nil, common.HexToAddress(s.Address))
+	qt.Assert(t, err, qt.ErrorMatches, ".*proof key and holder storage key do not match.*")
+
+	process.StorageLayout = nil
+	_, _, err = transaction.VerifyProof(process, proof(s), process.CensusOrigin,
+		process.CensusRoot, process.ProcessId, nil, common.HexToAddress(s.Address))
+	qt.Assert(t, err, qt.ErrorMatches, ".*storage layout not found.*")
+}
+
 func testEthSendVotes(t *testing.T, s testStorageProof,
 	pid []byte, vp []byte, app *BaseApplication, expectedResult bool) {
 	var cktx abcitypes.RequestCheckTx
@@ -0,0 +1,367 @@
+package vochain
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/crypto/nacl"
+	"go.vocdoni.io/dvote/crypto/shamir"
+	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain/state"
+	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// testEvent is an event received by a testEventRecorder, with the name of the
+// listener method and its arguments.
+type testEvent struct {
+	Method string
+	Args   []interface{}
+}
+
+// testEventRecorder records by block the events replayed by
+// BaseApplication.ReplayBlock.  The start of the processes is notified on the
+// block commit, which is not replayed, so it is ignored.
+type testEventRecorder struct {
+	events []testEvent
+	blocks map[uint32][]testEvent
+}
+
+func (r *testEventRecorder) add(method string, args ...interface{}) {
+	r.events = append(r.events, testEvent{Method: method, Args: args})
+}
+
+func (r *testEventRecorder) OnVote(vote *state.Vote, txIndex int32) {
+	v := *vote
+	r.add("OnVote", &v, txIndex)
+}
+
+// OnNewTx leaves out the height, since the test blocks are delivered with the
+// app height of the next one, see SetTestingMethods.
+func (r *testEventRecorder) OnNewTx(tx *vochaintx.VochainTx, _ uint32, txIndex int32) {
+	r.add("OnNewTx", tx.TxID, proto.Clone(tx.Tx), txIndex)
+}
+
+func (r *testEventRecorder) OnProcess(pid, eid []byte, censusRoot, censusURI string, txIndex int32) {
+	r.add("OnProcess", pid, eid, censusRoot, censusURI, txIndex)
+}
+
+func (r *testEventRecorder) OnProcessStatusChange(pid []byte, status models.ProcessStatus,
+	txIndex int32) {
+	r.add("OnProcessStatusChange", pid, status, txIndex)
+}
+
+func (r *testEventRecorder) OnCancel(pid []byte, txIndex int32) {
+	r.add("OnCancel", pid, txIndex)
+}
+
+func (r *testEventRecorder) OnProcessKeys(pid []byte, encryptionPub string, txIndex int32) {
+	r.add("OnProcessKeys", pid, encryptionPub, txIndex)
+}
+
+func (r *testEventRecorder) OnRevealKeys(pid []byte, encryptionPriv string, txIndex int32) {
+	r.add("OnRevealKeys", pid, encryptionPriv, txIndex)
+}
+
+func (r *testEventRecorder) OnProcessResults(pid []byte, results *models.ProcessResult,
+	txIndex int32) {
+	r.add("OnProcessResults", pid, proto.Clone(results), txIndex)
+}
+
+func (*testEventRecorder) OnProcessesStart([][]byte) {}
+
+func (r *testEventRecorder) OnSetAccount(addr []byte, account *state.Account) {
+	r.add("OnSetAccount", addr, proto.Clone(&account.Account))
+}
+
+func (r *testEventRecorder) OnTransferTokens(tx *vochaintx.TokenTransfer) {
+	transfer := *tx
+	r.add("OnTransferTokens", &transfer)
+}
+
+func (r *testEventRecorder) OnRotateAccount(rotation *vochaintx.AccountRotation) {
+	rot := *rotation
+	r.add("OnRotateAccount", &rot)
+}
+
+func (r *testEventRecorder) OnVoteDelegation(delegation *vochaintx.VoteDelegation, txIndex int32) {
+	d := *delegation
+	r.add("OnVoteDelegation", &d, txIndex)
+}
+
+func (r *testEventRecorder) OnVoteWeight(vote *state.Vote, weight *big.Int, txIndex int32) {
+	v := *vote
+	r.add("OnVoteWeight", &v, new(big.Int).Set(weight), txIndex)
+}
+
+func (r *testEventRecorder) Commit(height uint32) error {
+	if len(r.events) > 0 {
+		r.blocks[height] = r.events
+	}
+	r.events = nil
+	return nil
+}
+
+func (r *testEventRecorder) Rollback() {
+	r.events = nil
+}
+
+// TestEventReplay delivers transactions of every type, including vote
+// delegations and batches, and checks that the events replayed for each block
+// by BaseApplication.ReplayBlock are the events received when the block was
+// delivered.
+func TestEventReplay(t *testing.T) {
+	// keys: [oracle, entity, delegate, treasurer, random]
+	app, keys := createTestBaseApplicationAndAccounts(t, 10)
+	oracle, entity, delegate, treasurer, random := keys[0], keys[1], keys[2], keys[3], keys[4]
+	keykeepers := util.CreateEthRandomKeysBatch(3)
+	for _, k := range keykeepers {
+		qt.Assert(t, app.State.AddOracle(k.Address()), qt.IsNil)
+		qt.Assert(t, app.State.CreateAccount(k.Address(), "", nil, 0), qt.IsNil)
+	}
+	recorder := &testEventRecorder{blocks: make(map[uint32][]testEvent)}
+	app.State.AddEventListener(recorder)
+	app.AdvanceTestBlock()
+
+	send := func(signer *ethereum.SignKeys, tx *models.Tx, cosigners ...*ethereum.SignKeys) []byte {
+		stx := &models.SignedTx{}
+		var err error
+		stx.Tx, err = proto.Marshal(tx)
+		qt.Assert(t, err, qt.IsNil)
+		stx.Signature, err = signer.SignVocdoniTx(stx.Tx, app.ChainID())
+		qt.Assert(t, err, qt.IsNil)
+		for _, cosigner := range cosigners {
+			signature, err := cosigner.SignVocdoniTx(stx.Tx, app.ChainID())
+			qt.Assert(t, err, qt.IsNil)
+			stx.Signatures = append(stx.Signatures, signature)
+		}
+		txBytes, err := proto.Marshal(stx)
+		qt.Assert(t, err, qt.IsNil)
+		resp, err := app.SendTx(txBytes)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, resp.Code, qt.Equals, uint32(0), qt.Commentf("%s", resp.Data))
+		return resp.Data
+	}
+	nonce := func(signer *ethereum.SignKeys) uint32 {
+		acc, err := app.State.GetAccount(signer.Address(), false)
+		qt.Assert(t, err, qt.IsNil)
+		return acc.Nonce
+	}
+	newProcess := func(signer *ethereum.SignKeys, process *models.Process) []byte {
+		return send(signer, &models.Tx{Payload: &models.Tx_NewProcess{NewProcess: &models.NewProcessTx{
+			Txtype:  models.TxType_NEW_PROCESS,
+			Nonce:   nonce(signer),
+			Process: proto.Clone(process).(*models.Process),
+		}}})
+	}
+	setProcess := func(signer *ethereum.SignKeys, tx *models.SetProcessTx) {
+		tx.Nonce = nonce(signer)
+		send(signer, &models.Tx{Payload: &models.Tx_SetProcess{SetProcess: tx}})
+	}
+	adminTx := func(signer *ethereum.SignKeys, tx *models.AdminTx) {
+		send(signer, &models.Tx{Payload: &models.Tx_Admin{Admin: tx}})
+	}
+
+	// the organizations create an election, one of them on a batch, and the
+	// treasurer and the accounts transfer tokens
+	voters, root, proofs := testCreateKeysAndBuildCensus(t, 4)
+	censusURI := ipfsUrl
+	election := &models.Process{
+		StartBlock:   0,
+		EnvelopeType: &models.EnvelopeType{},
+		Mode:         &models.ProcessMode{AutoStart: true, Interruptible: true},
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 3, MaxValue: 3, MaxVoteOverwrites: 1},
+		Status:       models.ProcessStatus_READY,
+		EntityId:     entity.Address().Bytes(),
+		CensusRoot:   root,
+		CensusURI:    &censusURI,
+		CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+		BlockCount:   100,
+	}
+	pid := newProcess(entity, election)
+	encrypted := proto.Clone(election).(*models.Process)
+	encrypted.EnvelopeType = &models.EnvelopeType{EncryptedVotes: true}
+	encrypted.StartBlock = app.Height() + 3
+	encrypted.BlockCount = 2
+	encryptedPid := newProcess(entity, encrypted)
+	org := &ethereum.SignKeys{}
+	qt.Assert(t, org.Generate(), qt.IsNil)
+	faucetPkg, err := GenerateFaucetPackage(random, org.Address(), 1000)
+	qt.Assert(t, err, qt.IsNil)
+	orgElection := proto.Clone(election).(*models.Process)
+	orgElection.EntityId = org.Address().Bytes()
+	batch, err := vochaintx.NewBatchTx(
+		&models.Tx{Payload: &models.Tx_SetAccount{SetAccount: &models.SetAccountTx{
+			Txtype:        models.TxType_CREATE_ACCOUNT,
+			FaucetPackage: faucetPkg,
+		}}},
+		testBatchNewProcessTx(0, orgElection),
+	)
+	qt.Assert(t, err, qt.IsNil)
+	send(org, batch)
+	treasurerAcc, err := app.State.Treasurer(false)
+	qt.Assert(t, err, qt.IsNil)
+	send(treasurer, &models.Tx{Payload: &models.Tx_MintTokens{MintTokens: &models.MintTokensTx{
+		Txtype: models.TxType_MINT_TOKENS,
+		To:     random.Address().Bytes(),
+		Value:  500,
+		Nonce:  treasurerAcc.Nonce,
+	}}})
+	send(random, &models.Tx{Payload: &models.Tx_SendTokens{SendTokens: &models.SendTokensTx{
+		Txtype: models.TxType_SEND_TOKENS,
+		From:   random.Address().Bytes(),
+		To:     delegate.Address().Bytes(),
+		Value:  100,
+		Nonce:  nonce(random),
+	}}})
+	app.AdvanceTestBlock()
+
+	// the voters vote or delegate, before and after their delegate votes, the
+	// keykeepers add their keys to the encrypted election, and the accounts
+	// are created, collect faucets and rotate
+	sendSigned := func(stx *models.SignedTx) {
+		txBytes, err := proto.Marshal(stx)
+		qt.Assert(t, err, qt.IsNil)
+		resp, err := app.SendTx(txBytes)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, resp.Code, qt.Equals, uint32(0), qt.Commentf("%s", resp.Data))
+	}
+	sendSigned(testBuildSignedDelegation(t, pid, voters[2], proofs[2],
+		voters[0], proofs[0], app.ChainID()))
+	for i, voter := range voters[:2] {
+		sendSigned(testBuildSignedVote(t, pid, voter, proofs[i], []int{1, 2, 3}, app.ChainID()))
+	}
+	sendSigned(testBuildSignedDelegation(t, pid, voters[3], proofs[3],
+		voters[1], proofs[1], app.ChainID()))
+	// the key of the second keykeeper is shared with the others
+	encryptionKeys := make([]string, len(keykeepers))
+	var shares [][]byte
+	for i, k := range keykeepers {
+		priv, err := nacl.DecodePrivate(fmt.Sprintf("%x", shamir.NewSecret(util.RandomBytes(32))))
+		qt.Assert(t, err, qt.IsNil)
+		encryptionKeys[i] = fmt.Sprintf("%x", priv.Bytes())
+		keyIndex := uint32(i + 1)
+		tx := &models.AdminTx{
+			Txtype:              models.TxType_ADD_PROCESS_KEYS,
+			ProcessId:           encryptedPid,
+			KeyIndex:            &keyIndex,
+			EncryptionPublicKey: priv.Public().Bytes(),
+		}
+		if i == 1 {
+			shares, tx.KeyShareCommitments, err = shamir.Split(priv.Bytes(), []byte{1, 3}, 2)
+			qt.Assert(t, err, qt.IsNil)
+		}
+		adminTx(k, tx)
+	}
+	account := &ethereum.SignKeys{}
+	qt.Assert(t, account.Generate(), qt.IsNil)
+	faucetPkg, err = GenerateFaucetPackage(random, account.Address(), 100)
+	qt.Assert(t, err, qt.IsNil)
+	send(account, &models.Tx{Payload: &models.Tx_SetAccount{SetAccount: &models.SetAccountTx{
+		Txtype:        models.TxType_CREATE_ACCOUNT,
+		FaucetPackage: faucetPkg,
+	}}})
+	faucetPkg, err = GenerateFaucetPackage(random, delegate.Address(), 100)
+	qt.Assert(t, err, qt.IsNil)
+	send(delegate, &models.Tx{Payload: &models.Tx_CollectFaucet{CollectFaucet: &models.CollectFaucetTx{
+		TxType:        models.TxType_COLLECT_FAUCET,
+		FaucetPackage: faucetPkg,
+		Nonce:         nonce(delegate),
+	}}})
+	rotated := &ethereum.SignKeys{}
+	qt.Assert(t, rotated.Generate(), qt.IsNil)
+	accountNonce := nonce(account)
+	send(account, &models.Tx{Payload: &models.Tx_SetAccount{SetAccount: &models.SetAccountTx{
+		Txtype:  models.TxType_ROTATE_ACCOUNT,
+		Nonce:   &accountNonce,
+		Account: rotated.Address().Bytes(),
+	}}}, rotated)
+	app.AdvanceTestBlock()
+
+	// a vote is overwritten, and the election is ended and its results set
+	sendSigned(testBuildSignedVote(t, pid, voters[0], proofs[0], []int{3, 2, 1}, app.ChainID()))
+	status := models.ProcessStatus_ENDED
+	setProcess(entity, &models.SetProcessTx{
+		Txtype:    models.TxType_SET_PROCESS_STATUS,
+		ProcessId: pid,
+		Status:    &status,
+	})
+	app.AdvanceTestBlock()
+	setProcess(oracle, &models.SetProcessTx{
+		Txtype:    models.TxType_SET_PROCESS_RESULTS,
+		ProcessId: pid,
+		Results: &models.ProcessResult{
+			ProcessId:     pid,
+			EntityId:      entity.Address().Bytes(),
+			Votes:         []*models.QuestionResult{{Question: [][]byte{{1}}}},
+			OracleAddress: oracle.Address().Bytes(),
+		},
+	})
+	for app.Height() < encrypted.StartBlock+encrypted.BlockCount {
+		app.AdvanceTestBlock()
+	}
+
+	// the first keykeeper reveals its key, and the key of the second one is
+	// reconstructed from the shares of the others on the same block
+	keyIndex := uint32(1)
+	key, err := nacl.DecodePrivate(encryptionKeys[0])
+	qt.Assert(t, err, qt.IsNil)
+	adminTx(keykeepers[0], &models.AdminTx{
+		Txtype:               models.TxType_REVEAL_PROCESS_KEYS,
+		ProcessId:            encryptedPid,
+		KeyIndex:             &keyIndex,
+		EncryptionPrivateKey: key.Bytes(),
+	})
+	revealShare := func(signer *ethereum.SignKeys, share []byte) {
+		keyIndex := uint32(2)
+		adminTx(signer, &models.AdminTx{
+			Txtype:             models.TxType_REVEAL_PROCESS_KEYS,
+			ProcessId:          encryptedPid,
+			KeyIndex:           &keyIndex,
+			EncryptionKeyShare: share,
+		})
+	}
+	revealShare(keykeepers[0], shares[0])
+	revealShare(keykeepers[2], shares[1])
+	app.AdvanceTestBlock()
+	process, err := app.State.Process(encryptedPid, true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, process.EncryptionPrivateKeys[2], qt.Equals, encryptionKeys[1])
+
+	// the events are replayed as the indexer rebuild does
+	methods := make(map[string]bool)
+	for height, delivered := range recorder.blocks {
+		for _, event := range delivered {
+			methods[event.Method] = true
+		}
+		replayed := &testEventRecorder{blocks: make(map[uint32][]testEvent)}
+		_, err := app.ReplayBlock(height, replayed)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, replayed.Commit(height), qt.IsNil)
+		qt.Assert(t, replayed.blocks[height], qt.CmpEquals(
+			protocmp.Transform(),
+			cmp.Comparer(func(a, b *big.Int) bool { return a.Cmp(b) == 0 }),
+		), delivered, qt.Commentf("block %d", height))
+	}
+	qt.Assert(t, methods, qt.DeepEquals, map[string]bool{
+		"OnNewTx":               true,
+		"OnVote":                true,
+		"OnVoteDelegation":      true,
+		"OnVoteWeight":          true,
+		"OnProcess":             true,
+		"OnProcessStatusChange": true,
+		"OnProcessKeys":         true,
+		"OnRevealKeys":          true,
+		"OnProcessResults":      true,
+		"OnSetAccount":          true,
+		"OnTransferTokens":      true,
+		"OnRotateAccount":       true,
+	})
+}
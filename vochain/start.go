@@ -172,9 +172,16 @@ func newTendermint(app *BaseApplication,
 	tconfig.Consensus.TimeoutPrecommit = time.Second * 1
 	tconfig.Consensus.TimeoutCommit = time.Second * time.Duration(blockTime)
 
-	// Enable only FastSync (until StateSync is implemented)
+	// Enable FastSync, and StateSync if the light client servers are configured
 	tconfig.BlockSync.Enable = true
 	tconfig.StateSync.Enable = false
+	if len(localConfig.StateSyncRPCServers) > 1 {
+		tconfig.StateSync.Enable = true
+		tconfig.StateSync.RPCServers = localConfig.StateSyncRPCServers
+		tconfig.StateSync.TrustHeight = localConfig.StateSyncTrustHeight
+		tconfig.StateSync.TrustHash = localConfig.StateSyncTrustHash
+		log.Infof("state sync enabled using %s", strings.Join(tconfig.StateSync.RPCServers, ","))
+	}
 
 	// if gateway or oracle
 	tconfig.Mode = tmcfg.ModeFull
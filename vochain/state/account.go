@@ -119,6 +119,9 @@ func (v *State) AccountFromSignature(message, signature []byte) (*common.Address
 	if acc == nil {
 		return &common.Address{}, nil, fmt.Errorf("%w %s", ErrAccountNotExist, address.Hex())
 	}
+	if err := v.CheckNotRotated(address); err != nil {
+		return &common.Address{}, nil, err
+	}
 	return &address, acc, nil
 }
 
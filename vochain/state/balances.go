@@ -34,6 +34,8 @@ var (
 		models.TxType_ADD_DELEGATE_FOR_ACCOUNT:   "c_addDelegateForAccount",
 		models.TxType_DEL_DELEGATE_FOR_ACCOUNT:   "c_delDelegateForAccount",
 		models.TxType_COLLECT_FAUCET:             "c_collectFaucet",
+		models.TxType_SET_ACCOUNT_MULTISIG:       "c_setAccountMultisig",
+		models.TxType_ROTATE_ACCOUNT:             "c_rotateAccount",
 	}
 	ErrTxCostNotFound = fmt.Errorf("transaction cost is not set")
 )
@@ -237,6 +239,10 @@ func (v *State) TransferBalance(tx *vochaintx.TokenTransfer, burnTxCost bool) er
 	if accTo == nil {
 		return ErrAccountNotExist
 	}
+	// the tokens of a rotated account could not be spent
+	if err := v.CheckNotRotated(tx.ToAddress); err != nil {
+		return err
+	}
 	if err := accFrom.Transfer(accTo, tx.Amount); err != nil {
 		return err
 	}
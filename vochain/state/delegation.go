@@ -0,0 +1,373 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/vocdoni/arbo"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+// MaxVoteDelegators is the maximum number of census members that can delegate
+// their vote to the same delegate in an election.
+const MaxVoteDelegators = 1024
+
+// MaxVoteDelegationDepth is the maximum number of delegations of a delegation
+// chain in an election, so the chains are followed in bounded time.
+const MaxVoteDelegationDepth = 16
+
+const (
+	delegationPrefix = "delegation/"
+	delegatorsPrefix = "delegators/"
+	delegatePrefix   = "delegate/"
+)
+
+// delegationKey returns the Extra tree key where the delegation of the vote of
+// delegator in the process is stored.
+func delegationKey(processID []byte, delegator common.Address) []byte {
+	key := append([]byte(delegationPrefix), processID...)
+	return ethereum.HashRaw(append(key, delegator.Bytes()...))
+}
+
+// delegatorsKey returns the Extra tree key where the list of census members
+// which delegated their vote to delegate in the process is stored.
+func delegatorsKey(processID []byte, delegate common.Address) []byte {
+	key := append([]byte(delegatorsPrefix), processID...)
+	return ethereum.HashRaw(append(key, delegate.Bytes()...))
+}
+
+// delegateKey returns the Extra tree key where the delegated weight and the
+// depth of the delegation tree of delegate in the process are stored.
+func delegateKey(processID []byte, delegate common.Address) []byte {
+	key := append([]byte(delegatePrefix), processID...)
+	return ethereum.HashRaw(append(key, delegate.Bytes()...))
+}
+
+// delegateInfo is the delegation tree of a census member in an election.
+type delegateInfo struct {
+	// weight is the weight delegated to the census member by the delegators
+	// who did not vote, following the delegation chains.
+	weight *big.Int
+	// depth is the length of the longest delegation chain ending in the
+	// census member.  It is not decreased when the delegators vote.
+	depth uint8
+}
+
+// delegateInfo returns the delegation tree of delegate in the process, which
+// is empty if nobody delegated to it.
+func (v *State) delegateInfo(processID []byte, delegate common.Address) (*delegateInfo, error) {
+	data, err := v.extraValue(delegateKey(processID, delegate), false)
+	if err != nil {
+		return nil, err
+	}
+	info := &delegateInfo{weight: new(big.Int)}
+	if len(data) > 0 {
+		info.depth = data[0]
+		info.weight.SetBytes(data[1:])
+	}
+	return info, nil
+}
+
+// setDelegateInfo stores the delegation tree of delegate in the process.
+func (v *State) setDelegateInfo(processID []byte, delegate common.Address,
+	info *delegateInfo) error {
+	if info.weight.Sign() < 0 {
+		return fmt.Errorf("negative weight delegated to %s", delegate.Hex())
+	}
+	v.Tx.Lock()
+	defer v.Tx.Unlock()
+	return v.Tx.DeepSet(delegateKey(processID, delegate),
+		append([]byte{info.depth}, info.weight.Bytes()...), StateTreeCfg(TreeExtra))
+}
+
+// extraValue returns the value of key in the Extra tree, or nil if not found.
+func (v *State) extraValue(key []byte, committed bool) ([]byte, error) {
+	if !committed {
+		v.Tx.RLock()
+		defer v.Tx.RUnlock()
+	}
+	extraTree, err := v.mainTreeViewer(committed).SubTree(StateTreeCfg(TreeExtra))
+	if err != nil {
+		return nil, err
+	}
+	data, err := extraTree.Get(key)
+	if errors.Is(err, arbo.ErrKeyNotFound) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// VoteDelegation returns the delegation of the vote of delegator in the
+// process, or nil if the vote was not delegated.
+// When committed is false, the operation is executed also on not yet commited
+// data from the currently open StateDB transaction.
+// When committed is true, the operation is executed on the last commited version.
+func (v *State) VoteDelegation(processID []byte, delegator common.Address,
+	committed bool) (*vochaintx.VoteDelegation, error) {
+	data, err := v.extraValue(delegationKey(processID, delegator), committed)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	if len(data) < common.AddressLength {
+		return nil, fmt.Errorf("invalid vote delegation size %d", len(data))
+	}
+	return &vochaintx.VoteDelegation{
+		ProcessID: processID,
+		Delegator: delegator,
+		Delegate:  common.BytesToAddress(data[:common.AddressLength]),
+		Weight:    new(big.Int).SetBytes(data[common.AddressLength:]),
+	}, nil
+}
+
+// VoteDelegators returns the census members which delegated their vote to
+// delegate in the process.
+// When committed is false, the operation is executed also on not yet commited
+// data from the currently open StateDB transaction.
+// When committed is true, the operation is executed on the last commited version.
+func (v *State) VoteDelegators(processID []byte, delegate common.Address,
+	committed bool) ([]common.Address, error) {
+	data, err := v.extraValue(delegatorsKey(processID, delegate), committed)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%common.AddressLength != 0 {
+		return nil, fmt.Errorf("invalid vote delegators size %d", len(data))
+	}
+	delegators := []common.Address{}
+	for i := 0; i < len(data); i += common.AddressLength {
+		delegators = append(delegators, common.BytesToAddress(data[i:i+common.AddressLength]))
+	}
+	return delegators, nil
+}
+
+// CheckVoteDelegation checks the vote of delegator can be delegated to
+// delegate in the process: the delegator did neither vote nor delegate yet,
+// and the delegation does neither close a cycle nor make a chain longer than
+// MaxVoteDelegationDepth.  The census membership of both
+// must be checked by the caller, since the state cannot verify census proofs.
+func (v *State) CheckVoteDelegation(processID []byte, delegator, delegate common.Address) error {
+	if delegator == delegate {
+		return fmt.Errorf("%w: cannot delegate the vote to itself", ErrVoteDelegationCycle)
+	}
+	delegation, err := v.VoteDelegation(processID, delegator, false)
+	if err != nil {
+		return err
+	}
+	if delegation != nil {
+		return fmt.Errorf("%w to %s", ErrVoteAlreadyDelegated, delegation.Delegate.Hex())
+	}
+	voted, err := v.VoteExists(processID, GenerateNullifier(delegator, processID), false)
+	if err != nil {
+		return err
+	}
+	if voted {
+		return fmt.Errorf("%s already voted", delegator.Hex())
+	}
+	// follow the delegation chain of the delegate, which is acyclic, adding
+	// its length to the longest chain ending in the delegator
+	info, err := v.delegateInfo(processID, delegator)
+	if err != nil {
+		return err
+	}
+	for addr, depth := delegate, int(info.depth)+1; ; depth++ {
+		if depth > MaxVoteDelegationDepth {
+			return fmt.Errorf("vote delegation chain longer than %d", MaxVoteDelegationDepth)
+		}
+		delegation, err := v.VoteDelegation(processID, addr, false)
+		if err != nil {
+			return err
+		}
+		if delegation == nil {
+			break
+		}
+		if delegation.Delegate == delegator {
+			return fmt.Errorf("%w: %s delegates to %s", ErrVoteDelegationCycle,
+				delegate.Hex(), delegator.Hex())
+		}
+		addr = delegation.Delegate
+	}
+	delegators, err := v.VoteDelegators(processID, delegate, false)
+	if err != nil {
+		return err
+	}
+	if len(delegators) >= MaxVoteDelegators {
+		return fmt.Errorf("delegate %s reached the maximum number of delegators", delegate.Hex())
+	}
+	return nil
+}
+
+// AddVoteDelegation delegates the vote of a census member to another one in
+// an election.  The weight of the delegator, plus the weight delegated to it,
+// is added to the vote of the first delegate of the chain who already voted,
+// and to the votes cast later by them until the delegator votes.
+func (v *State) AddVoteDelegation(delegation *vochaintx.VoteDelegation) error {
+	if err := v.CheckVoteDelegation(delegation.ProcessID, delegation.Delegator,
+		delegation.Delegate); err != nil {
+		return err
+	}
+	delegators, err := v.VoteDelegators(delegation.ProcessID, delegation.Delegate, false)
+	if err != nil {
+		return err
+	}
+	delegatorsData := []byte{}
+	for _, d := range append(delegators, delegation.Delegator) {
+		delegatorsData = append(delegatorsData, d.Bytes()...)
+	}
+	log.Debugf("delegating vote of %s to %s on process %x", delegation.Delegator.Hex(),
+		delegation.Delegate.Hex(), delegation.ProcessID)
+	v.Tx.Lock()
+	err = func() error {
+		if err := v.Tx.DeepSet(delegationKey(delegation.ProcessID, delegation.Delegator),
+			append(delegation.Delegate.Bytes(), delegation.Weight.Bytes()...),
+			StateTreeCfg(TreeExtra)); err != nil {
+			return err
+		}
+		return v.Tx.DeepSet(delegatorsKey(delegation.ProcessID, delegation.Delegate),
+			delegatorsData, StateTreeCfg(TreeExtra))
+	}()
+	v.Tx.Unlock()
+	if err != nil {
+		return err
+	}
+	info, err := v.delegateInfo(delegation.ProcessID, delegation.Delegator)
+	if err != nil {
+		return err
+	}
+	if err := v.moveDelegatedWeight(delegation.ProcessID, delegation.Delegate,
+		info.weight.Add(info.weight, delegation.Weight), info.depth+1); err != nil {
+		return err
+	}
+	for _, l := range v.eventListeners {
+		l.OnVoteDelegation(delegation, v.TxCounter())
+	}
+	return nil
+}
+
+// moveDelegatedWeight adds the weight to the weight delegated to delegate and
+// to the next delegates of its chain, up to the first one who already voted,
+// whose vote weight is also updated.  A negative weight removes it, when a
+// delegator votes.  If depth is not zero, the depth of their delegation trees
+// is raised to depth, the length of the chain ending in delegate, plus their
+// distance to it.
+func (v *State) moveDelegatedWeight(processID []byte, delegate common.Address,
+	weight *big.Int, depth uint8) error {
+	moving, raising := true, depth > 0
+	for addr := delegate; moving || raising; depth++ {
+		info, err := v.delegateInfo(processID, addr)
+		if err != nil {
+			return err
+		}
+		raising = raising && depth > info.depth
+		if !moving && !raising {
+			return nil
+		}
+		if raising {
+			if depth > MaxVoteDelegationDepth {
+				return fmt.Errorf("vote delegation chain longer than %d", MaxVoteDelegationDepth)
+			}
+			info.depth = depth
+		}
+		if moving {
+			info.weight.Add(info.weight, weight)
+		}
+		if err := v.setDelegateInfo(processID, addr, info); err != nil {
+			return err
+		}
+		if moving {
+			sdbVote, err := v.Vote(processID, GenerateNullifier(addr, processID), false)
+			if err == nil {
+				if err := v.setVoteWeight(sdbVote, processID,
+					new(big.Int).Add(new(big.Int).SetBytes(sdbVote.Weight), weight)); err != nil {
+					return err
+				}
+				moving = false
+			} else if !errors.Is(err, ErrVoteNotFound) {
+				return err
+			}
+		}
+		delegation, err := v.VoteDelegation(processID, addr, false)
+		if err != nil || delegation == nil {
+			return err
+		}
+		addr = delegation.Delegate
+	}
+	return nil
+}
+
+// setVoteWeight updates the weight of a vote already cast, and calls the
+// event listeners to OnVoteWeight.
+func (v *State) setVoteWeight(sdbVote *models.StateDBVote, processID []byte,
+	weight *big.Int) error {
+	if weight.Sign() < 0 {
+		return fmt.Errorf("negative weight of vote %x", sdbVote.Nullifier)
+	}
+	vote := &Vote{
+		ProcessID:            processID,
+		Nullifier:            sdbVote.Nullifier,
+		VotePackage:          sdbVote.VotePackage,
+		EncryptionKeyIndexes: sdbVote.EncryptionKeyIndexes,
+		Weight:               weight,
+	}
+	previousWeight := new(big.Int).SetBytes(sdbVote.Weight)
+	sdbVote.Weight = vote.WeightBytes()
+	sdbVote.VoteHash = vote.Hash()
+	vid, err := voteID(processID, sdbVote.Nullifier)
+	if err != nil {
+		return err
+	}
+	sdbVoteBytes, err := proto.Marshal(sdbVote)
+	if err != nil {
+		return fmt.Errorf("cannot marshal sdbVote: %w", err)
+	}
+	v.Tx.Lock()
+	treeCfg := StateChildTreeCfg(ChildTreeVotes)
+	err = v.Tx.DeepSet(vid, sdbVoteBytes,
+		StateTreeCfg(TreeProcess), treeCfg.WithKey(processID))
+	v.Tx.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, l := range v.eventListeners {
+		l.OnVoteWeight(vote, previousWeight, v.TxCounter())
+	}
+	return nil
+}
+
+// delegateVote adds to the vote the weight delegated to the voter.  If the
+// voter delegated its vote, the delegation is overridden and the weight moved
+// by AddVoteDelegation, which is the delegator weight plus the weight
+// delegated to it, is removed from the vote of the delegate holding it.
+func (v *State) delegateVote(vote *Vote, overwrite bool) error {
+	if vote.VoterID.IsNil() || vote.VoterID.Type() != VoterIDTypeECDSA {
+		return nil
+	}
+	addrBytes, err := vote.VoterID.Address()
+	if err != nil {
+		return err
+	}
+	voter := common.BytesToAddress(addrBytes)
+	info, err := v.delegateInfo(vote.ProcessID, voter)
+	if err != nil {
+		return err
+	}
+	weight := info.weight
+	if vote.Weight == nil {
+		vote.Weight = big.NewInt(1)
+	}
+	vote.Weight = new(big.Int).Add(weight, vote.Weight)
+	if overwrite {
+		return nil
+	}
+	delegation, err := v.VoteDelegation(vote.ProcessID, voter, false)
+	if err != nil || delegation == nil {
+		return err
+	}
+	return v.moveDelegatedWeight(vote.ProcessID, delegation.Delegate,
+		weight.Neg(weight.Add(weight, delegation.Weight)), 0)
+}
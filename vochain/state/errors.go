@@ -12,4 +12,9 @@ var (
 	ErrAccountBalanceZero   = fmt.Errorf("zero balance account not valid")
 	ErrAccountAlreadyExists = fmt.Errorf("account already exists")
 	ErrInvalidURILength     = fmt.Errorf("invalid URI length")
+	ErrAccountRotated       = fmt.Errorf("account rotated")
+	ErrVoteAlreadyDelegated = fmt.Errorf("vote already delegated")
+	ErrVoteDelegationCycle  = fmt.Errorf("vote delegation cycle")
+	ErrVersionNotAvailable  = fmt.Errorf("state version not available")
+	ErrVotesPruned          = fmt.Errorf("process votes pruned")
 )
@@ -1,6 +1,8 @@
 package state
 
 import (
+	"math/big"
+
 	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
 	"go.vocdoni.io/proto/build/go/models"
 )
@@ -17,6 +19,9 @@ import (
 // If OncProcessResults() returns an error, the results transaction won't be included
 // in the blockchain. This event relays on the event handlers to decide if results are
 // valid or not since the Vochain State do not validate results.
+//
+// OnVoteWeight is called when the weight of a vote already cast changes, due
+// to vote delegations, see AddVoteDelegation.
 type EventListener interface {
 	OnVote(vote *Vote, txIndex int32)
 	OnNewTx(tx *vochaintx.VochainTx, blockHeight uint32, txIndex int32)
@@ -29,6 +34,9 @@ type EventListener interface {
 	OnProcessesStart(pids [][]byte)
 	OnSetAccount(addr []byte, account *Account)
 	OnTransferTokens(tx *vochaintx.TokenTransfer)
+	OnRotateAccount(rotation *vochaintx.AccountRotation)
+	OnVoteDelegation(delegation *vochaintx.VoteDelegation, txIndex int32)
+	OnVoteWeight(vote *Vote, previousWeight *big.Int, txIndex int32)
 	Commit(height uint32) (err error)
 	Rollback()
 }
@@ -0,0 +1,112 @@
+package state
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// SetVersionRetention sets the number of past state versions which can be
+// opened with StateAt, besides the last committed one.  Zero keeps all of them
+// available.  The retention only limits the queries, since the tree nodes of
+// the old versions are shared with the newer ones and are kept in the
+// database.
+func (v *State) SetVersionRetention(versions uint32) {
+	atomic.StoreUint32(&v.versionRetention, versions)
+}
+
+// StateAt returns a read-only view of the state committed at height.  Both
+// the committed and the not committed queries of the view read that version,
+// so the view can be used with any State method taking a committed argument.
+// The methods which modify the state must not be called on the view.
+func (v *State) StateAt(height uint32) (*State, error) {
+	return v.stateAt(height, atomic.LoadUint32(&v.versionRetention))
+}
+
+// ReplayStateAt returns a read-only view of the state committed at height, as
+// StateAt does but ignoring the version retention, so that the whole chain can
+// be replayed from the state history.  The votes pruned from the state are
+// missing on any version, see SetVotesPruning.
+func (v *State) ReplayStateAt(height uint32) (*State, error) {
+	return v.stateAt(height, 0)
+}
+
+// stateAt returns the read-only view of the state committed at height, if it
+// is one of the last retention versions.  Zero retention allows any version.
+func (v *State) stateAt(height, retention uint32) (*State, error) {
+	last, err := v.Store.Version()
+	if err != nil {
+		return nil, err
+	}
+	if height > last {
+		return nil, fmt.Errorf("%w: height %d is not committed, last height is %d",
+			ErrVersionNotAvailable, height, last)
+	}
+	if retention > 0 && last-height > retention {
+		return nil, fmt.Errorf("%w: only the last %d versions are kept",
+			ErrVersionNotAvailable, retention)
+	}
+	root, err := v.Store.VersionRoot(height)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVersionNotAvailable, err)
+	}
+	mainTreeView, err := v.Store.TreeView(root)
+	if err != nil {
+		return nil, err
+	}
+	view := &State{
+		dataDir:       v.dataDir,
+		db:            v.db,
+		Store:         v.Store,
+		currentHeight: height,
+		chainID:       v.chainID,
+		readOnly:      true,
+	}
+	view.DisableVoteCache.Store(true)
+	view.setMainTreeView(mainTreeView)
+	return view, nil
+}
+
+// ReadOnly returns whether the state is a read-only view, see StateAt.
+func (v *State) ReadOnly() bool {
+	return v.readOnly
+}
+
+// ForkAt returns a state whose open transaction starts from the version
+// committed at height, so that the blocks after it can be delivered again,
+// such as when replaying their events.  The updates of the fork are never
+// saved: it must not be committed, and its transaction must be released with
+// fork.Tx.Discard.  The committed queries of the fork read the version at
+// height, and it has no event listeners nor vote cache.
+func (v *State) ForkAt(height uint32) (*State, error) {
+	last, err := v.Store.Version()
+	if err != nil {
+		return nil, err
+	}
+	if height > last {
+		return nil, fmt.Errorf("%w: height %d is not committed, last height is %d",
+			ErrVersionNotAvailable, height, last)
+	}
+	root, err := v.Store.VersionRoot(height)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVersionNotAvailable, err)
+	}
+	mainTreeView, err := v.Store.TreeView(root)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := v.Store.BeginTxAt(height)
+	if err != nil {
+		return nil, err
+	}
+	fork := &State{
+		dataDir:       v.dataDir,
+		db:            v.db,
+		Store:         v.Store,
+		Tx:            treeTxWithMutex{TreeTx: tx},
+		currentHeight: height,
+		chainID:       v.chainID,
+	}
+	fork.DisableVoteCache.Store(true)
+	fork.setMainTreeView(mainTreeView)
+	return fork, nil
+}
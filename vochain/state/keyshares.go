@@ -0,0 +1,261 @@
+package state
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/vocdoni/arbo"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/crypto/nacl"
+	"go.vocdoni.io/dvote/crypto/shamir"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/types"
+)
+
+// ProcessKeyShareSize is the size of a share of a process encryption private
+// key.  Keykeepers working in threshold mode split their private keys among
+// the other keykeepers, publishing the commitments to the shares with the
+// ADD_PROCESS_KEYS transaction.  So if one of them is not available to reveal
+// its key, the others can reveal their shares on the EncryptionKeyShare field
+// of a REVEAL_PROCESS_KEYS transaction.  Each share is verified against the
+// commitments, and the key is reconstructed on the state once the threshold of
+// shares is known.
+const ProcessKeyShareSize = shamir.ShareSize
+
+const (
+	processKeySharesPrefix      = "keyShares/"
+	processKeyCommitmentsPrefix = "keyCommitments/"
+	processKeyKeeperPrefix      = "keyKeeper/"
+)
+
+// processKeySharesKey returns the Extra tree key where the revealed shares of
+// a process encryption key are stored.
+func processKeySharesKey(pid []byte, keyIndex uint32) []byte {
+	return processKeyIndexKey(processKeySharesPrefix, pid, keyIndex)
+}
+
+// processKeyCommitmentsKey returns the Extra tree key where the commitments to
+// the shares of a process encryption key are stored.
+func processKeyCommitmentsKey(pid []byte, keyIndex uint32) []byte {
+	return processKeyIndexKey(processKeyCommitmentsPrefix, pid, keyIndex)
+}
+
+// processKeyKeeperKey returns the Extra tree key where the keykeeper which
+// added a process encryption key is stored.
+func processKeyKeeperKey(pid []byte, keyIndex uint32) []byte {
+	return processKeyIndexKey(processKeyKeeperPrefix, pid, keyIndex)
+}
+
+func processKeyIndexKey(prefix string, pid []byte, keyIndex uint32) []byte {
+	key := append([]byte(prefix), pid...)
+	key = binary.LittleEndian.AppendUint32(key, keyIndex)
+	return ethereum.HashRaw(key)
+}
+
+// SetProcessKeyKeeper stores the keykeeper which added the process encryption
+// key with the given index.  Its key index is the coordinate of the shares it
+// reveals of the other keys of the process.
+func (v *State) SetProcessKeyKeeper(pid []byte, keyIndex uint32, addr common.Address) error {
+	v.Tx.Lock()
+	defer v.Tx.Unlock()
+	return v.Tx.DeepSet(processKeyKeeperKey(pid, keyIndex), addr.Bytes(),
+		StateTreeCfg(TreeExtra))
+}
+
+// ProcessKeyKeeper returns the keykeeper which added the process encryption
+// key with the given index, or nil if it is unknown.
+// When committed is false, the operation is executed also on not yet commited
+// data from the currently open StateDB transaction.
+// When committed is true, the operation is executed on the last commited version.
+func (v *State) ProcessKeyKeeper(pid []byte, keyIndex uint32,
+	committed bool) (*common.Address, error) {
+	if !committed {
+		v.Tx.RLock()
+		defer v.Tx.RUnlock()
+	}
+	extraTree, err := v.mainTreeViewer(committed).SubTree(StateTreeCfg(TreeExtra))
+	if err != nil {
+		return nil, err
+	}
+	data, err := extraTree.Get(processKeyKeeperKey(pid, keyIndex))
+	if errors.Is(err, arbo.ErrKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	addr := common.BytesToAddress(data)
+	return &addr, nil
+}
+
+// ProcessKeyKeeperIndex returns the index of the process encryption key added
+// by the keykeeper addr, or zero if it did not add any.
+// When committed is false, the operation is executed also on not yet commited
+// data from the currently open StateDB transaction.
+// When committed is true, the operation is executed on the last commited version.
+func (v *State) ProcessKeyKeeperIndex(pid []byte, addr common.Address,
+	committed bool) (uint32, error) {
+	for i := uint32(1); i <= types.KeyKeeperMaxKeyIndex; i++ {
+		keykeeper, err := v.ProcessKeyKeeper(pid, i, committed)
+		if err != nil {
+			return 0, err
+		}
+		if keykeeper != nil && *keykeeper == addr {
+			return i, nil
+		}
+	}
+	return 0, nil
+}
+
+// SetProcessKeyShareCommitments stores the commitments to the shares of the
+// process encryption key with the given index, see shamir.Split.
+func (v *State) SetProcessKeyShareCommitments(pid []byte, keyIndex uint32,
+	commitments []byte) error {
+	v.Tx.Lock()
+	defer v.Tx.Unlock()
+	return v.Tx.DeepSet(processKeyCommitmentsKey(pid, keyIndex), commitments,
+		StateTreeCfg(TreeExtra))
+}
+
+// ProcessKeyShareCommitments returns the commitments to the shares of the
+// process encryption key with the given index, or nil if the key is not
+// shared.
+// When committed is false, the operation is executed also on not yet commited
+// data from the currently open StateDB transaction.
+// When committed is true, the operation is executed on the last commited version.
+func (v *State) ProcessKeyShareCommitments(pid []byte, keyIndex uint32,
+	committed bool) ([]byte, error) {
+	if !committed {
+		v.Tx.RLock()
+		defer v.Tx.RUnlock()
+	}
+	extraTree, err := v.mainTreeViewer(committed).SubTree(StateTreeCfg(TreeExtra))
+	if err != nil {
+		return nil, err
+	}
+	data, err := extraTree.Get(processKeyCommitmentsKey(pid, keyIndex))
+	if errors.Is(err, arbo.ErrKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ProcessKeyShares returns the revealed shares of a process encryption key
+// which has not been reconstructed yet.
+// When committed is false, the operation is executed also on not yet commited
+// data from the currently open StateDB transaction.
+// When committed is true, the operation is executed on the last commited version.
+func (v *State) ProcessKeyShares(pid []byte, keyIndex uint32, committed bool) ([][]byte, error) {
+	if !committed {
+		v.Tx.RLock()
+		defer v.Tx.RUnlock()
+	}
+	extraTree, err := v.mainTreeViewer(committed).SubTree(StateTreeCfg(TreeExtra))
+	if err != nil {
+		return nil, err
+	}
+	data, err := extraTree.Get(processKeySharesKey(pid, keyIndex))
+	if errors.Is(err, arbo.ErrKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return splitKeyShares(data), nil
+}
+
+// addProcessKeyShare stores a new share of the process encryption key with
+// the given index and reconstructs the private key once the threshold of
+// shares is known, see AddProcessKeyShare and CombineProcessKeyShares.
+func (v *State) addProcessKeyShare(pid []byte, keyIndex uint32, share []byte,
+	pubKey string) ([]byte, error) {
+	commitments, err := v.ProcessKeyShareCommitments(pid, keyIndex, false)
+	if err != nil {
+		return nil, err
+	}
+	shares, err := v.ProcessKeyShares(pid, keyIndex, false)
+	if err != nil {
+		return nil, err
+	}
+	if shares, err = AddProcessKeyShare(shares, share, commitments); err != nil {
+		return nil, err
+	}
+	data := []byte{}
+	for _, s := range shares {
+		data = append(data, s...)
+	}
+	v.Tx.Lock()
+	err = v.Tx.DeepSet(processKeySharesKey(pid, keyIndex), data, StateTreeCfg(TreeExtra))
+	v.Tx.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("added share %d of encryption key %d for process %x, %d shares known",
+		share[0], keyIndex, pid, len(shares))
+	return CombineProcessKeyShares(shares, commitments, pubKey)
+}
+
+// AddProcessKeyShare returns the known shares of a process encryption key
+// with a new share appended, after verifying it against the commitments of
+// the key.  A share cannot replace a known share with the same coordinate,
+// and no more shares are accepted once the threshold is reached.
+func AddProcessKeyShare(shares [][]byte, share, commitments []byte) ([][]byte, error) {
+	if commitments == nil {
+		return nil, fmt.Errorf("the key is not shared")
+	}
+	if len(share) != ProcessKeyShareSize || share[0] == 0 ||
+		share[0] > types.KeyKeeperMaxKeyIndex {
+		return nil, fmt.Errorf("invalid key share")
+	}
+	threshold, err := shamir.Threshold(commitments)
+	if err != nil {
+		return nil, err
+	}
+	if len(shares) >= threshold {
+		return nil, fmt.Errorf("the %d key shares required were already revealed", threshold)
+	}
+	for _, s := range shares {
+		if s[0] == share[0] {
+			return nil, fmt.Errorf("key share %d already revealed", share[0])
+		}
+	}
+	if err := shamir.VerifyShare(share, commitments); err != nil {
+		return nil, err
+	}
+	return append(shares, share), nil
+}
+
+// CombineProcessKeyShares reconstructs a process encryption private key from
+// its verified shares once the threshold of the commitments is reached.  The
+// key is nil while there are less shares, or if it does not match the public
+// key of the process, which only happens if its keykeeper shared another
+// secret.
+func CombineProcessKeyShares(shares [][]byte, commitments []byte, pubKey string) ([]byte, error) {
+	threshold, err := shamir.Threshold(commitments)
+	if err != nil || len(shares) < threshold {
+		return nil, err
+	}
+	privKey, err := shamir.Combine(shares)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := nacl.DecodePrivate(fmt.Sprintf("%x", privKey))
+	if err != nil {
+		return nil, err
+	}
+	if fmt.Sprintf("%x", priv.Public().Bytes()) != pubKey {
+		log.Warnf("the key shares do not reconstruct the public key %s", pubKey)
+		return nil, nil
+	}
+	return privKey, nil
+}
+
+func splitKeyShares(data []byte) [][]byte {
+	shares := [][]byte{}
+	for i := 0; i+ProcessKeyShareSize <= len(data); i += ProcessKeyShareSize {
+		shares = append(shares, data[i:i+ProcessKeyShareSize])
+	}
+	return shares
+}
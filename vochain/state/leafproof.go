@@ -0,0 +1,98 @@
+package state
+
+import (
+	"fmt"
+
+	"go.vocdoni.io/dvote/statedb"
+)
+
+// LeafProof is the proof of a leaf of a mainTree subTree, such as an account
+// of the Accounts tree or a process of the Processes tree, being part of the
+// state at a given height.
+type LeafProof struct {
+	// Height is the state version of the proof.  The Root is the AppHash of
+	// the header of the next block.
+	Height uint32
+	// Root is the mainTree root at Height.
+	Root []byte
+	// Value is the leaf value in the subTree.
+	Value    []byte
+	Siblings []byte
+	// TreeRoot is the subTree root, the leaf of the mainTree.
+	TreeRoot     []byte
+	TreeSiblings []byte
+}
+
+// LeafProof returns the proof of the leaf with key of the mainTree subTree
+// with name treeName, in the last committed state.
+func (v *State) LeafProof(treeName string, key []byte) (*LeafProof, error) {
+	if _, ok := MainTrees[treeName]; !ok {
+		return nil, fmt.Errorf("state tree %s does not exist", treeName)
+	}
+	height, root, mainTree, err := v.committedMainTree()
+	if err != nil {
+		return nil, err
+	}
+	proof := &LeafProof{Height: height, Root: root}
+	treeCfg := StateTreeCfg(treeName)
+	if proof.TreeRoot, proof.TreeSiblings, err = mainTree.GenProof(treeCfg.Key()); err != nil {
+		return nil, fmt.Errorf("cannot generate %s proof: %w", treeName, err)
+	}
+	tree, err := mainTree.SubTree(treeCfg)
+	if err != nil {
+		return nil, err
+	}
+	if proof.Value, proof.Siblings, err = tree.GenProof(key); err != nil {
+		return nil, fmt.Errorf("cannot generate %s leaf proof: %w", treeName, err)
+	}
+	return proof, nil
+}
+
+// Verify checks the proof chain of the leaf with key of the mainTree subTree
+// with name treeName, up to the proof Root.  The caller must still check the
+// Root against the AppHash of the block Height+1.
+func (p *LeafProof) Verify(treeName string, key []byte) error {
+	if _, ok := MainTrees[treeName]; !ok {
+		return fmt.Errorf("state tree %s does not exist", treeName)
+	}
+	treeCfg := StateTreeCfg(treeName)
+	treeRoot, err := rootLeafGetRoot(p.TreeRoot)
+	if err != nil {
+		return err
+	}
+	if err := checkProof(treeCfg.HashFunc(), key, p.Value, treeRoot, p.Siblings); err != nil {
+		return fmt.Errorf("invalid %s leaf proof: %w", treeName, err)
+	}
+	// the mainTree uses the same hash function as its singleton subTrees
+	if err := checkProof(treeCfg.HashFunc(), treeCfg.Key(), p.TreeRoot, p.Root,
+		p.TreeSiblings); err != nil {
+		return fmt.Errorf("invalid %s proof: %w", treeName, err)
+	}
+	return nil
+}
+
+// committedMainTree opens the mainTree at the last committed version, and
+// returns the version and its root.  A known version is used since a new
+// block may be committed meanwhile.
+func (v *State) committedMainTree() (uint32, []byte, *statedb.TreeView, error) {
+	if v.readOnly {
+		root, err := v.MainTreeView().Root()
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		return v.CurrentHeight(), root, v.MainTreeView(), nil
+	}
+	height, err := v.Store.Version()
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	root, err := v.Store.VersionRoot(height)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	mainTree, err := v.Store.TreeView(root)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return height, root, mainTree, nil
+}
@@ -0,0 +1,133 @@
+package state
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/vocdoni/arbo"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+)
+
+// MaxMultisigSigners is the maximum number of signers of a multisig policy.
+const MaxMultisigSigners = 32
+
+const multisigPrefix = "multisig/"
+
+// MultisigPolicy requires the approval of Threshold of the Signers for the
+// organization transactions of an account, such as creating an election or
+// changing its status.
+type MultisigPolicy struct {
+	Threshold uint32           `json:"threshold"`
+	Signers   []common.Address `json:"signers"`
+}
+
+// Marshal encodes the policy as the threshold followed by the signers.
+func (p *MultisigPolicy) Marshal() []byte {
+	data := binary.LittleEndian.AppendUint32(nil, p.Threshold)
+	for _, s := range p.Signers {
+		data = append(data, s.Bytes()...)
+	}
+	return data
+}
+
+// Unmarshal decodes a policy encoded with Marshal.
+func (p *MultisigPolicy) Unmarshal(data []byte) error {
+	if len(data) < 4 || (len(data)-4)%common.AddressLength != 0 {
+		return fmt.Errorf("invalid multisig policy size %d", len(data))
+	}
+	p.Threshold = binary.LittleEndian.Uint32(data)
+	p.Signers = nil
+	for i := 4; i < len(data); i += common.AddressLength {
+		p.Signers = append(p.Signers, common.BytesToAddress(data[i:i+common.AddressLength]))
+	}
+	return nil
+}
+
+// Check checks the policy is valid: the signers are unique and the threshold
+// is reachable.
+func (p *MultisigPolicy) Check() error {
+	if len(p.Signers) == 0 || len(p.Signers) > MaxMultisigSigners {
+		return fmt.Errorf("multisig policy requires between 1 and %d signers", MaxMultisigSigners)
+	}
+	if p.Threshold < 1 || int(p.Threshold) > len(p.Signers) {
+		return fmt.Errorf("multisig threshold must be between 1 and %d", len(p.Signers))
+	}
+	seen := make(map[common.Address]bool)
+	for _, s := range p.Signers {
+		if seen[s] {
+			return fmt.Errorf("duplicated multisig signer %s", s.Hex())
+		}
+		seen[s] = true
+	}
+	return nil
+}
+
+// Approved returns the number of policy signers found on the given list of
+// addresses, and whether they reach the threshold.
+func (p *MultisigPolicy) Approved(addrs []common.Address) (uint32, bool) {
+	signers := make(map[common.Address]bool)
+	for _, s := range p.Signers {
+		signers[s] = true
+	}
+	approvals := uint32(0)
+	for _, addr := range addrs {
+		if signers[addr] {
+			approvals++
+			delete(signers, addr)
+		}
+	}
+	return approvals, approvals >= p.Threshold
+}
+
+// multisigKey returns the Extra tree key where the multisig policy of an
+// account is stored.
+func multisigKey(address common.Address) []byte {
+	return ethereum.HashRaw(append([]byte(multisigPrefix), address.Bytes()...))
+}
+
+// SetMultisigPolicy sets the multisig policy of an account, or removes it if
+// the policy is nil.  A removed policy is stored with a zero threshold.
+func (v *State) SetMultisigPolicy(address common.Address, policy *MultisigPolicy) error {
+	data := (&MultisigPolicy{}).Marshal()
+	if policy != nil {
+		if err := policy.Check(); err != nil {
+			return err
+		}
+		data = policy.Marshal()
+	}
+	v.Tx.Lock()
+	defer v.Tx.Unlock()
+	return v.Tx.DeepSet(multisigKey(address), data, StateTreeCfg(TreeExtra))
+}
+
+// MultisigPolicy returns the multisig policy of an account, or nil if the
+// account does not have one.
+// When committed is false, the operation is executed also on not yet commited
+// data from the currently open StateDB transaction.
+// When committed is true, the operation is executed on the last commited version.
+func (v *State) MultisigPolicy(address common.Address, committed bool) (*MultisigPolicy, error) {
+	if !committed {
+		v.Tx.RLock()
+		defer v.Tx.RUnlock()
+	}
+	extraTree, err := v.mainTreeViewer(committed).SubTree(StateTreeCfg(TreeExtra))
+	if err != nil {
+		return nil, err
+	}
+	data, err := extraTree.Get(multisigKey(address))
+	if errors.Is(err, arbo.ErrKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	policy := &MultisigPolicy{}
+	if err := policy.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	if policy.Threshold == 0 {
+		return nil, nil
+	}
+	return policy, nil
+}
@@ -241,7 +241,7 @@ func (v *State) SetProcessStatus(pid []byte, newstatus models.ProcessStatus, com
 			return fmt.Errorf("process %x can only be ended from ready status", pid)
 		}
 		if !process.Mode.Interruptible {
-			if v.CurrentHeight() <= process.BlockCount+process.StartBlock {
+			if !v.processFinished(process, v.CurrentHeight() > process.BlockCount+process.StartBlock) {
 				return fmt.Errorf("process %x is not interruptible, cannot change state to %s",
 					pid, newstatus.String())
 			}
@@ -275,7 +275,7 @@ func (v *State) SetProcessStatus(pid []byte, newstatus models.ProcessStatus, com
 			return fmt.Errorf("cannot set state to results from %s", currentStatus.String())
 		}
 		if currentStatus == models.ProcessStatus_READY &&
-			process.StartBlock+process.BlockCount <= v.CurrentHeight() {
+			!v.processFinished(process, process.StartBlock+process.BlockCount > v.CurrentHeight()) {
 			return fmt.Errorf("cannot set state to results from %s, process is still alive",
 				currentStatus.String())
 		}
@@ -309,7 +309,7 @@ func (v *State) SetProcessResults(pid []byte, result *models.ProcessResult, comm
 		return fmt.Errorf("cannot set results, invalid status: %s", process.Status)
 	}
 	if process.Status == models.ProcessStatus_READY &&
-		process.StartBlock+process.BlockCount > v.CurrentHeight() {
+		!v.processFinished(process, process.StartBlock+process.BlockCount <= v.CurrentHeight()) {
 		return fmt.Errorf("cannot set state to results, process is still alive")
 	}
 	if !bytes.Equal(result.ProcessId, process.ProcessId) {
@@ -346,11 +346,19 @@ func (v *State) SetProcessResults(pid []byte, result *models.ProcessResult, comm
 				return fmt.Errorf("results already set for this oracle address")
 			}
 		}
+		firstResults := process.Status != models.ProcessStatus_RESULTS
 		process.Results = append(process.Results, result)
 		process.Status = models.ProcessStatus_RESULTS
 		if err := v.UpdateProcess(process, process.ProcessId); err != nil {
 			return fmt.Errorf("cannot set results: %w", err)
 		}
+		// schedule the pruning of the votes, see SetVotesPruning
+		if firstResults {
+			if err := v.setProcessIDByResultsHeight(process.ProcessId,
+				v.CurrentHeight()); err != nil {
+				return fmt.Errorf("cannot set results: %w", err)
+			}
+		}
 		// Call event listeners
 		for _, l := range v.eventListeners {
 			l.OnProcessResults(process.ProcessId, result, v.TxCounter())
@@ -359,6 +367,18 @@ func (v *State) SetProcessResults(pid []byte, result *models.ProcessResult, comm
 	return nil
 }
 
+// LastProcessResults returns the last results set by an oracle on the
+// process, or nil if there are none.  The results slice of a process is
+// allocated with empty entries before the ones set by the oracles.
+func LastProcessResults(process *models.Process) *models.ProcessResult {
+	for i := len(process.GetResults()) - 1; i >= 0; i-- {
+		if result := process.Results[i]; len(result.GetVotes()) > 0 {
+			return result
+		}
+	}
+	return nil
+}
+
 // GetProcessResults returns a friendly representation of the results stored in the State (if any).
 func (v *State) GetProcessResults(pid []byte) ([][]string, error) {
 	// TO-DO (pau): use a LRU cache for results
@@ -366,9 +386,9 @@ func (v *State) GetProcessResults(pid []byte) ([][]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	if len(process.Results) > 0 {
+	if result := LastProcessResults(process); result != nil {
 		// TO-DO (pau): return the whole list of oracle results
-		return GetFriendlyResults(process.Results[0].GetVotes()), nil
+		return GetFriendlyResults(result.GetVotes()), nil
 	}
 	return nil, fmt.Errorf("no results for process %x", pid)
 }
@@ -0,0 +1,62 @@
+package state
+
+import (
+	"time"
+
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// Time-bounded processes start and end at absolute timestamps, the StartTime
+// and EndTime of their mode, checked against the block header time, instead
+// of at StartBlock and StartBlock+BlockCount.  Their StartBlock and BlockCount
+// are still required as an estimation, used to schedule the process start and
+// the key reveal.
+
+// SetProcessTimeBounds makes the process mode time-bounded, starting at start
+// and ending at end.  A zero start means the process starts at its StartBlock.
+func SetProcessTimeBounds(mode *models.ProcessMode, start, end time.Time) {
+	mode.StartTime = 0
+	if !start.IsZero() {
+		mode.StartTime = uint64(start.Unix())
+	}
+	mode.EndTime = uint64(end.Unix())
+}
+
+// ProcessTimeBounds returns the start and end timestamps of a time-bounded
+// process mode, and false if the process is bounded by blocks.
+func ProcessTimeBounds(mode *models.ProcessMode) (start, end time.Time, ok bool) {
+	if mode.GetEndTime() == 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	if mode.GetStartTime() > 0 {
+		start = time.Unix(int64(mode.GetStartTime()), 0)
+	}
+	return start, time.Unix(int64(mode.GetEndTime()), 0), true
+}
+
+// ProcessStartedAt returns whether a time-bounded process has started at the
+// given timestamp.  Processes bounded by blocks, and time-bounded processes
+// starting at their StartBlock, always return true: their start is checked
+// against the block height by the caller.
+func ProcessStartedAt(p *models.Process, timestamp int64) bool {
+	start, _, ok := ProcessTimeBounds(p.GetMode())
+	return !ok || start.IsZero() || start.Unix() <= timestamp
+}
+
+// ProcessEndedAt returns whether a time-bounded process has ended at the
+// given timestamp.  Processes bounded by blocks always return false.
+func ProcessEndedAt(p *models.Process, timestamp int64) bool {
+	_, end, ok := ProcessTimeBounds(p.GetMode())
+	return ok && end.Unix() <= timestamp
+}
+
+// processFinished returns whether the voting period of the process is over at
+// the current block.  Time-bounded processes are checked against the current
+// block header time, the rest return blockFinished, the result of the caller
+// check on the process block range.
+func (v *State) processFinished(p *models.Process, blockFinished bool) bool {
+	if _, _, ok := ProcessTimeBounds(p.GetMode()); ok {
+		return ProcessEndedAt(p, v.CurrentTimestamp())
+	}
+	return blockFinished
+}
@@ -0,0 +1,255 @@
+package state
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path"
+	"sync/atomic"
+
+	"go.vocdoni.io/dvote/db"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/statedb"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+// The votes of a process are pruned a number of blocks after its results are
+// set, see SetVotesPruning.  Pruning deletes the nodes of the Votes tree of
+// the process from the database, while its root and results are kept in the
+// process leaf, so the state hash does not change and the nodes which do not
+// prune (such as archival ones) stay in consensus.  The votes must be served
+// by the indexer (or the process archive) afterwards: the pruned votes have no
+// inclusion proofs (see VoteProof), and the indexer rebuilds index them without
+// weight.  So pruning is opt-in.  The nodes of a Votes tree are deleted in
+// chunks of votesPruningMaxNodes per block, so pruning a large process does not
+// stall the commit of a block.
+
+// pathProcessIDsByResultsHeight is the db path used to store ProcessIDs
+// indexed by the height their results were set at.
+const pathProcessIDsByResultsHeight = "pidByResultsHeight"
+
+// pathPrunedVotes is the db path used to store the vote count of the processes
+// whose votes were pruned.
+const pathPrunedVotes = "prunedVotes/"
+
+// votesPruningMaxNodes is the maximum number of nodes of the Votes tree of a
+// process deleted on each block.
+const votesPruningMaxNodes = 50000
+
+// keyVotesPruningPending is the db key where the list of processes whose
+// Votes tree is not completely deleted yet is stored.
+var keyVotesPruningPending = []byte("votesPruningPending")
+
+// keyVotesPrunedHeight is the db key where the last results height whose
+// processes were pruned is stored.
+var keyVotesPrunedHeight = []byte("votesPrunedHeight")
+
+// keyProcessIDsByResultsHeight returns the db key where ProcessesIDs with
+// results set at height are stored.
+func keyProcessIDsByResultsHeight(height uint32) []byte {
+	key := make([]byte, 4)
+	binary.LittleEndian.PutUint32(key, height)
+	return []byte(path.Join(pathProcessIDsByResultsHeight, string(key)))
+}
+
+// keyPrunedVotes returns the db key where the vote count of the process is
+// stored once its votes are pruned.
+func keyPrunedVotes(processID []byte) []byte {
+	return append([]byte(pathPrunedVotes), processID...)
+}
+
+// SetVotesPruning sets the number of blocks after the results of a process
+// are set when its votes are pruned from the state.  Zero disables the
+// pruning, keeping all the votes (as archival nodes do).
+func (v *State) SetVotesPruning(blocks uint32) {
+	atomic.StoreUint32(&v.votesPruning, blocks)
+}
+
+// VotesPruned returns whether the votes of the process were pruned from the
+// state, and the number of votes it had.
+// When committed is false, the operation is executed also on not yet commited
+// data from the currently open StateDB transaction.
+// When committed is true, the operation is executed on the last commited version.
+func (v *State) VotesPruned(processID []byte, committed bool) (bool, uint32, error) {
+	if !committed {
+		v.Tx.RLock()
+		defer v.Tx.RUnlock()
+	}
+	return votesPruned(v.mainTreeViewer(committed).NoState(), processID)
+}
+
+// votesPruned returns whether the votes of the process were pruned, and the
+// number of votes it had, reading from the mainTree noState.
+func votesPruned(noState statedb.Viewer, processID []byte) (bool, uint32, error) {
+	countLE, err := noState.Get(keyPrunedVotes(processID))
+	if errors.Is(err, db.ErrKeyNotFound) {
+		return false, 0, nil
+	} else if err != nil {
+		return false, 0, err
+	}
+	return true, binary.LittleEndian.Uint32(countLE), nil
+}
+
+// setProcessIDByResultsHeight indexes the processID by the height its results
+// are set at, to schedule the pruning of its votes.
+func (v *State) setProcessIDByResultsHeight(processID []byte, height uint32) error {
+	v.Tx.Lock()
+	defer v.Tx.Unlock()
+	pids, err := processIDsByResultsHeight(v.Tx.NoState(), height)
+	if err != nil {
+		return err
+	}
+	pidsBytes, err := proto.Marshal(&models.ProcessIdList{
+		ProcessIds: append(pids, processID),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot proto.Marshal pids: %w", err)
+	}
+	return v.Tx.NoState().Set(keyProcessIDsByResultsHeight(height), pidsBytes)
+}
+
+// processIDsByResultsHeight returns the ProcessIDs of processes with results
+// set at height.
+func processIDsByResultsHeight(noState statedb.Viewer, height uint32) ([][]byte, error) {
+	pidsBytes, err := noState.Get(keyProcessIDsByResultsHeight(height))
+	if errors.Is(err, db.ErrKeyNotFound) {
+		return [][]byte{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var pids models.ProcessIdList
+	if err := proto.Unmarshal(pidsBytes, &pids); err != nil {
+		return nil, fmt.Errorf("cannot proto.Unmarshal pids: %w", err)
+	}
+	return pids.ProcessIds, nil
+}
+
+// pruneVotes prunes the votes of the processes whose results were set the
+// configured number of blocks before height, and of the previous ones not
+// pruned yet, tracked by the last results height pruned.  It also continues
+// deleting the Votes trees of the processes pruned on previous blocks.  The Tx
+// lock must be held by the caller.
+func (v *State) pruneVotes(height uint32) error {
+	blocks := atomic.LoadUint32(&v.votesPruning)
+	if blocks == 0 || height <= blocks {
+		return nil
+	}
+	noState := v.Tx.NoState()
+	pending, err := votesPruningPending(noState)
+	if err != nil {
+		return err
+	}
+	stillPending := [][]byte{}
+	for _, pid := range pending {
+		done, err := v.pruneVotesTree(pid)
+		if err != nil {
+			return fmt.Errorf("cannot prune votes of process %x: %w", pid, err)
+		}
+		if !done {
+			stillPending = append(stillPending, pid)
+		}
+	}
+	from := uint32(1)
+	prunedLE, err := noState.Get(keyVotesPrunedHeight)
+	if err == nil {
+		from = binary.LittleEndian.Uint32(prunedLE) + 1
+	} else if !errors.Is(err, db.ErrKeyNotFound) {
+		return err
+	}
+	to := height - blocks
+	for resultsHeight := from; resultsHeight <= to; resultsHeight++ {
+		pids, err := processIDsByResultsHeight(noState, resultsHeight)
+		if err != nil {
+			return err
+		}
+		for _, pid := range pids {
+			done, err := v.pruneProcessVotes(pid)
+			if err != nil {
+				return fmt.Errorf("cannot prune votes of process %x: %w", pid, err)
+			}
+			if !done {
+				stillPending = append(stillPending, pid)
+			}
+		}
+	}
+	if len(pending) > 0 || len(stillPending) > 0 {
+		if err := setVotesPruningPending(noState, stillPending); err != nil {
+			return err
+		}
+	}
+	if from > to {
+		return nil
+	}
+	prunedLE = make([]byte, 4)
+	binary.LittleEndian.PutUint32(prunedLE, to)
+	return noState.Set(keyVotesPrunedHeight, prunedLE)
+}
+
+// votesPruningPending returns the processes whose Votes tree is not
+// completely deleted yet.
+func votesPruningPending(noState statedb.Viewer) ([][]byte, error) {
+	pidsBytes, err := noState.Get(keyVotesPruningPending)
+	if errors.Is(err, db.ErrKeyNotFound) {
+		return [][]byte{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var pids models.ProcessIdList
+	if err := proto.Unmarshal(pidsBytes, &pids); err != nil {
+		return nil, fmt.Errorf("cannot proto.Unmarshal pids: %w", err)
+	}
+	return pids.ProcessIds, nil
+}
+
+// setVotesPruningPending stores the processes whose Votes tree is not
+// completely deleted yet.
+func setVotesPruningPending(noState statedb.Updater, pids [][]byte) error {
+	pidsBytes, err := proto.Marshal(&models.ProcessIdList{ProcessIds: pids})
+	if err != nil {
+		return fmt.Errorf("cannot proto.Marshal pids: %w", err)
+	}
+	return noState.Set(keyVotesPruningPending, pidsBytes)
+}
+
+// pruneProcessVotes marks the votes of the process as pruned, keeping its vote
+// count, and starts deleting its Votes tree from the database.  It returns
+// whether the tree was completely deleted.  The Tx lock must be held by the
+// caller.
+func (v *State) pruneProcessVotes(processID []byte) (bool, error) {
+	noState := v.Tx.NoState()
+	if pruned, _, err := votesPruned(noState, processID); err != nil || pruned {
+		return true, err
+	}
+	treeCfg := StateChildTreeCfg(ChildTreeVotes)
+	votesTree, err := v.Tx.DeepSubTree(StateTreeCfg(TreeProcess), treeCfg.WithKey(processID))
+	if err != nil {
+		return false, err
+	}
+	count := uint32(0)
+	if err := votesTree.Iterate(func(_, _ []byte) bool {
+		count++
+		return false
+	}); err != nil {
+		return false, err
+	}
+	countLE := make([]byte, 4)
+	binary.LittleEndian.PutUint32(countLE, count)
+	if err := noState.Set(keyPrunedVotes(processID), countLE); err != nil {
+		return false, err
+	}
+	log.Infow("pruning election votes", map[string]interface{}{
+		"electionId": fmt.Sprintf("%x", processID),
+		"votes":      count,
+	})
+	return v.pruneVotesTree(processID)
+}
+
+// pruneVotesTree deletes up to votesPruningMaxNodes nodes of the Votes tree of
+// the process from the database, and returns whether it was completely
+// deleted.  The Tx lock must be held by the caller.
+func (v *State) pruneVotesTree(processID []byte) (bool, error) {
+	treeCfg := StateChildTreeCfg(ChildTreeVotes)
+	return v.Tx.PruneSubTree(votesPruningMaxNodes, StateTreeCfg(TreeProcess),
+		treeCfg.WithKey(processID))
+}
@@ -0,0 +1,142 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/vocdoni/arbo"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+const rotationPrefix = "rotation/"
+
+// rotationKey returns the Extra tree key where the address an account was
+// rotated to is stored.
+func rotationKey(address common.Address) []byte {
+	return ethereum.HashRaw(append([]byte(rotationPrefix), address.Bytes()...))
+}
+
+// RotateAccount moves the account of from to the address to, which must not
+// have an account.  The balance, nonce, delegates, process index, info URI and
+// multisig policy are kept.  The account of from is left empty and marked as
+// rotated, so it cannot be used or created again, and the elections of the
+// organization are then managed by the new address, see ResolveAccount.
+func (v *State) RotateAccount(from, to common.Address, txHash []byte) error {
+	acc, err := v.GetAccount(from, false)
+	if err != nil {
+		return err
+	}
+	if acc == nil {
+		return ErrAccountNotExist
+	}
+	toAcc, err := v.GetAccount(to, false)
+	if err != nil {
+		return err
+	}
+	if toAcc != nil {
+		return ErrAccountAlreadyExists
+	}
+	policy, err := v.MultisigPolicy(from, false)
+	if err != nil {
+		return err
+	}
+	newAcc := &Account{models.Account{
+		Nonce:        acc.Nonce,
+		InfoURI:      acc.InfoURI,
+		Balance:      acc.Balance,
+		ProcessIndex: acc.ProcessIndex,
+	}}
+	// the new address cannot be a delegate of itself
+	for _, delegate := range acc.DelegateAddrs {
+		if common.BytesToAddress(delegate) != to {
+			newAcc.DelegateAddrs = append(newAcc.DelegateAddrs, delegate)
+		}
+	}
+	log.Debugf("rotating account %s to %s", from.Hex(), to.Hex())
+	if err := v.SetAccount(to, newAcc); err != nil {
+		return err
+	}
+	// the nonce is kept, so the transactions of the old key cannot be replayed
+	if err := v.SetAccount(from, &Account{models.Account{Nonce: acc.Nonce}}); err != nil {
+		return err
+	}
+	if policy != nil {
+		if err := v.SetMultisigPolicy(to, policy); err != nil {
+			return err
+		}
+		if err := v.SetMultisigPolicy(from, nil); err != nil {
+			return err
+		}
+	}
+	v.Tx.Lock()
+	err = v.Tx.DeepSet(rotationKey(from), to.Bytes(), StateTreeCfg(TreeExtra))
+	v.Tx.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, l := range v.eventListeners {
+		l.OnRotateAccount(&vochaintx.AccountRotation{
+			FromAddress: from,
+			ToAddress:   to,
+			TxHash:      txHash,
+		})
+	}
+	return nil
+}
+
+// AccountRotation returns the address the account was rotated to, or nil if
+// the account was not rotated.
+// When committed is false, the operation is executed also on not yet commited
+// data from the currently open StateDB transaction.
+// When committed is true, the operation is executed on the last commited version.
+func (v *State) AccountRotation(address common.Address, committed bool) (*common.Address, error) {
+	if !committed {
+		v.Tx.RLock()
+		defer v.Tx.RUnlock()
+	}
+	extraTree, err := v.mainTreeViewer(committed).SubTree(StateTreeCfg(TreeExtra))
+	if err != nil {
+		return nil, err
+	}
+	data, err := extraTree.Get(rotationKey(address))
+	if errors.Is(err, arbo.ErrKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	to := common.BytesToAddress(data)
+	return &to, nil
+}
+
+// ResolveAccount returns the current address of the account, following its
+// rotations.  If the account was never rotated, the same address is returned.
+// Rotations cannot form cycles, since an account can only be rotated to an
+// address without account.
+func (v *State) ResolveAccount(address common.Address, committed bool) (common.Address, error) {
+	for {
+		to, err := v.AccountRotation(address, committed)
+		if err != nil {
+			return common.Address{}, fmt.Errorf("cannot get account rotation: %w", err)
+		}
+		if to == nil {
+			return address, nil
+		}
+		address = *to
+	}
+}
+
+// CheckNotRotated returns ErrAccountRotated if the account was rotated.
+func (v *State) CheckNotRotated(address common.Address) error {
+	to, err := v.AccountRotation(address, false)
+	if err != nil {
+		return fmt.Errorf("cannot get account rotation: %w", err)
+	}
+	if to != nil {
+		return fmt.Errorf("%w to %s", ErrAccountRotated, to.Hex())
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package state
+
+import (
+	"math/big"
+
+	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// Savepoint marks the current state, so that the changes made from then on
+// can be discarded with RollbackSavepoint, or kept with ReleaseSavepoint.
+// The events produced while the savepoint is active are queued, and only
+// sent to the event listeners if the savepoint is released.
+// Savepoints cannot be nested.
+func (v *State) Savepoint() error {
+	v.Tx.Lock()
+	defer v.Tx.Unlock()
+	if err := v.Tx.Savepoint(); err != nil {
+		return err
+	}
+	v.savepointListeners = v.eventListeners
+	v.eventListeners = []EventListener{&eventQueue{}}
+	return nil
+}
+
+// ReleaseSavepoint keeps the changes made since the savepoint was created, and
+// sends the queued events to the event listeners.
+func (v *State) ReleaseSavepoint() error {
+	v.Tx.Lock()
+	if err := v.Tx.ReleaseSavepoint(); err != nil {
+		v.Tx.Unlock()
+		return err
+	}
+	queue := v.restoreListeners()
+	v.Tx.Unlock()
+	for _, l := range v.eventListeners {
+		for _, event := range queue.events {
+			event(l)
+		}
+	}
+	return nil
+}
+
+// RollbackSavepoint discards the changes made since the savepoint was created,
+// and the queued events.
+func (v *State) RollbackSavepoint() error {
+	v.Tx.Lock()
+	defer v.Tx.Unlock()
+	if err := v.Tx.RollbackSavepoint(); err != nil {
+		return err
+	}
+	v.restoreListeners()
+	return nil
+}
+
+// restoreListeners restores the event listeners replaced by Savepoint, and
+// returns the queue of events.
+func (v *State) restoreListeners() *eventQueue {
+	queue := v.eventListeners[0].(*eventQueue)
+	v.eventListeners = v.savepointListeners
+	v.savepointListeners = nil
+	return queue
+}
+
+// eventQueue is an EventListener that stores the events, to be sent later to
+// the actual event listeners.
+type eventQueue struct {
+	events []func(l EventListener)
+}
+
+func (q *eventQueue) add(event func(l EventListener)) {
+	q.events = append(q.events, event)
+}
+
+func (q *eventQueue) OnVote(vote *Vote, txIndex int32) {
+	q.add(func(l EventListener) { l.OnVote(vote, txIndex) })
+}
+
+func (q *eventQueue) OnNewTx(tx *vochaintx.VochainTx, blockHeight uint32, txIndex int32) {
+	q.add(func(l EventListener) { l.OnNewTx(tx, blockHeight, txIndex) })
+}
+
+func (q *eventQueue) OnProcess(pid, eid []byte, censusRoot, censusURI string, txIndex int32) {
+	q.add(func(l EventListener) { l.OnProcess(pid, eid, censusRoot, censusURI, txIndex) })
+}
+
+func (q *eventQueue) OnProcessStatusChange(pid []byte, status models.ProcessStatus,
+	txIndex int32) {
+	q.add(func(l EventListener) { l.OnProcessStatusChange(pid, status, txIndex) })
+}
+
+func (q *eventQueue) OnCancel(pid []byte, txIndex int32) {
+	q.add(func(l EventListener) { l.OnCancel(pid, txIndex) })
+}
+
+func (q *eventQueue) OnProcessKeys(pid []byte, encryptionPub string, txIndex int32) {
+	q.add(func(l EventListener) { l.OnProcessKeys(pid, encryptionPub, txIndex) })
+}
+
+func (q *eventQueue) OnRevealKeys(pid []byte, encryptionPriv string, txIndex int32) {
+	q.add(func(l EventListener) { l.OnRevealKeys(pid, encryptionPriv, txIndex) })
+}
+
+func (q *eventQueue) OnProcessResults(pid []byte, results *models.ProcessResult,
+	txIndex int32) {
+	q.add(func(l EventListener) { l.OnProcessResults(pid, results, txIndex) })
+}
+
+func (q *eventQueue) OnProcessesStart(pids [][]byte) {
+	q.add(func(l EventListener) { l.OnProcessesStart(pids) })
+}
+
+func (q *eventQueue) OnSetAccount(addr []byte, account *Account) {
+	q.add(func(l EventListener) { l.OnSetAccount(addr, account) })
+}
+
+func (q *eventQueue) OnTransferTokens(tx *vochaintx.TokenTransfer) {
+	q.add(func(l EventListener) { l.OnTransferTokens(tx) })
+}
+
+func (q *eventQueue) OnRotateAccount(rotation *vochaintx.AccountRotation) {
+	q.add(func(l EventListener) { l.OnRotateAccount(rotation) })
+}
+
+func (q *eventQueue) OnVoteDelegation(delegation *vochaintx.VoteDelegation, txIndex int32) {
+	q.add(func(l EventListener) { l.OnVoteDelegation(delegation, txIndex) })
+}
+
+func (q *eventQueue) OnVoteWeight(vote *Vote, previousWeight *big.Int, txIndex int32) {
+	q.add(func(l EventListener) { l.OnVoteWeight(vote, previousWeight, txIndex) })
+}
+
+// Commit is never called on the queue, since the savepoint is always released
+// or rolled back within the same block.
+func (*eventQueue) Commit(_ uint32) error { return nil }
+
+// Rollback is never called on the queue, see Commit.
+func (*eventQueue) Rollback() {}
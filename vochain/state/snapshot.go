@@ -16,11 +16,15 @@ import (
 	"github.com/vocdoni/arbo"
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/statedb"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
 )
 
 const (
 	snapshotHeaderVersion = 1
 	snapshotHeaderLenSize = 32
+	// snapshotMainTree is the name given to the main tree in the snapshot.
+	snapshotMainTree = "Main"
 )
 
 // A StateSnapshot is a copy in a specific point in time of the blockchain state.
@@ -54,14 +58,22 @@ type SnapshotHeader struct {
 	Root    []byte
 	ChainID string
 	Height  uint32
-	Trees   []SnapshotHeaderTree
+	// Timestamp is the time of the block at Height, as unix seconds
+	Timestamp int64
+	Trees     []SnapshotHeaderTree
+	// PrunedVotes is the vote count of the processes whose votes were
+	// pruned, which are not part of the snapshot, by process ID.
+	PrunedVotes map[string]uint32
 }
 
 // SnapshotHeaderTree represents a merkle tree of the StateSnapshot.
+// Key is the leaf key of the parent tree where the tree root is found, only
+// used by the non-singleton trees (i.e. the process child trees).
 type SnapshotHeaderTree struct {
 	Name   string
 	Size   uint32
 	Parent string
+	Key    []byte
 	Root   []byte
 }
 
@@ -75,11 +87,24 @@ func (s *StateSnapshot) SetHeight(height uint32) {
 	s.header.Height = height
 }
 
+// SetTimestamp sets the time of the block at the snapshot height.
+func (s *StateSnapshot) SetTimestamp(timestamp int64) {
+	s.header.Timestamp = timestamp
+}
+
 // SetChainID sets the blockchain identifier for the snapshot.
 func (s *StateSnapshot) SetChainID(chainID string) {
 	s.header.ChainID = chainID
 }
 
+// AddPrunedVotes sets the vote count of a process whose votes were pruned.
+func (s *StateSnapshot) AddPrunedVotes(processID []byte, count uint32) {
+	if s.header.PrunedVotes == nil {
+		s.header.PrunedVotes = make(map[string]uint32)
+	}
+	s.header.PrunedVotes[string(processID)] = count
+}
+
 // Open reads an existing snapshot file and decodes the header.
 // After calling this method everything is ready for reading the first
 // merkle tree. No need to execute `FetchNextTree` until io.EOF is reached.
@@ -180,6 +205,13 @@ func (s *StateSnapshot) Path() string {
 	return s.path
 }
 
+// Close closes the snapshot file opened with `Open`.
+func (s *StateSnapshot) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Close()
+}
+
 // Create starts the creation of a new snapshot as a disk file.
 // This method must be called only once and its operation is oposed to `Open`.
 func (s *StateSnapshot) Create(filePath string) error {
@@ -193,11 +225,12 @@ func (s *StateSnapshot) Create(filePath string) error {
 }
 
 // AddTree adds a new tree to the snapshot. `Create` needs to be called first.
-func (s *StateSnapshot) AddTree(name, parent string, root []byte) {
+func (s *StateSnapshot) AddTree(name, parent string, key, root []byte) {
 	s.lock.Lock() // only 1 tree at time is allowed
 	s.header.Trees = append(s.header.Trees, SnapshotHeaderTree{
 		Name:   name,
 		Parent: parent,
+		Key:    key,
 		Root:   root,
 		Size:   0,
 	})
@@ -216,8 +249,9 @@ func (s *StateSnapshot) Save() error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	// create the final file
-	finalFile, err := os.Create(s.path)
+	// create the final file, which is only moved to its path once complete,
+	// so the snapshots being taken are never listed
+	finalFile, err := os.Create(s.path + ".part")
 	if err != nil {
 		return err
 	}
@@ -262,6 +296,9 @@ func (s *StateSnapshot) Save() error {
 	if err := finalFile.Close(); err != nil {
 		return err
 	}
+	if err := os.Rename(finalFile.Name(), s.path); err != nil {
+		return err
+	}
 	return os.Remove(s.file.Name())
 }
 
@@ -273,14 +310,19 @@ func (s *StateSnapshot) Write(b []byte) (int, error) {
 	return n, err
 }
 
-// Snapshot performs a snapshot of the last committed state for all trees.
-// The snapshot is stored in disk and the file path is returned.
+// Snapshot performs a snapshot of the last committed state for all trees, or
+// of the version of a read-only view, see StateAt.  The block time of the
+// snapshot is the current timestamp, see SetTimestamp.  The snapshot is stored
+// in disk and the file path is returned.
 func (v *State) Snapshot() (string, error) {
 	t := v.MainTreeView()
 	height, err := v.LastHeight()
 	if err != nil {
 		return "", err
 	}
+	if v.readOnly {
+		height = v.CurrentHeight()
+	}
 	root, err := t.Root()
 	if err != nil {
 		return "", err
@@ -294,24 +336,20 @@ func (v *State) Snapshot() (string, error) {
 	}
 
 	var snap StateSnapshot
-	if err := snap.Create(filepath.Join(
-		v.dataDir,
-		storageDirectory,
-		snapshotsDirectory,
-		fmt.Sprintf("%d", height),
-	)); err != nil {
+	if err := snap.Create(v.SnapshotPath(height)); err != nil {
 		return "", err
 	}
 	snap.SetMainRoot(root)
 	snap.SetHeight(height)
+	snap.SetTimestamp(v.CurrentTimestamp())
 	snap.SetChainID(v.chainID)
 
-	dumpTree := func(name, parent string, tr statedb.TreeViewer) error {
+	dumpTree := func(name, parent string, key []byte, tr statedb.TreeViewer) error {
 		root, err := tr.Root()
 		if err != nil {
 			return err
 		}
-		snap.AddTree(name, parent, root)
+		snap.AddTree(name, parent, key, root)
 		if err := tr.Dump(&snap); err != nil {
 			return fmt.Errorf("cannot dump tree: %w", err)
 		}
@@ -320,7 +358,7 @@ func (v *State) Snapshot() (string, error) {
 	}
 
 	// dump main tree
-	if err := dumpTree("Main", "", v.mainTreeViewer(true)); err != nil {
+	if err := dumpTree(snapshotMainTree, "", nil, v.mainTreeViewer(true)); err != nil {
 		return "", err
 	}
 
@@ -330,7 +368,7 @@ func (v *State) Snapshot() (string, error) {
 		if err != nil {
 			return "", err
 		}
-		if err := dumpTree(k, "", t); err != nil {
+		if err := dumpTree(k, "", nil, t); err != nil {
 			return "", err
 		}
 	}
@@ -343,6 +381,18 @@ func (v *State) Snapshot() (string, error) {
 	log.Debugf("found %d processes", len(pids))
 	for name := range ChildTrees {
 		for _, p := range pids {
+			// the pruned votes are not part of the snapshot, only their
+			// count, see SetVotesPruning
+			if name == ChildTreeVotes {
+				pruned, count, err := votesPruned(v.mainTreeViewer(true).NoState(), p)
+				if err != nil {
+					return "", err
+				}
+				if pruned {
+					snap.AddPrunedVotes(p, count)
+					continue
+				}
+			}
 			childTreeCfg := StateChildTreeCfg(name)
 			processTree, err := v.mainTreeViewer(true).SubTree(StateTreeCfg(TreeProcess))
 			if err != nil {
@@ -358,7 +408,7 @@ func (v *State) Snapshot() (string, error) {
 				}
 				continue
 			}
-			if err := dumpTree(name, TreeProcess, childTree); err != nil {
+			if err := dumpTree(name, TreeProcess, p, childTree); err != nil {
 				return "", err
 			}
 		}
@@ -366,27 +416,182 @@ func (v *State) Snapshot() (string, error) {
 	return snap.Path(), snap.Save()
 }
 
-/*
-// TODO install Snapshot
-func (v *State) installSnapshot(height uint32) error {
-	var snap StateSnapshot
-	if err := snap.Open(filepath.Join(
+// SnapshotPath returns the file path where the snapshot for height is stored.
+func (v *State) SnapshotPath(height uint32) string {
+	return filepath.Join(
 		v.dataDir,
 		storageDirectory,
 		snapshotsDirectory,
 		fmt.Sprintf("%d", height),
-	)); err != nil {
+	)
+}
+
+// InstallSnapshot replaces the current state with the one found in the
+// snapshot file at filePath, rebuilding all the merkle trees and committing
+// them at the snapshot height.  Every imported subtree root is checked against
+// its parent leaf, and the resulting main root against the snapshot header,
+// so an inconsistent snapshot is never committed.  It is meant to be used on a
+// fresh state, before any block has been processed.
+func (v *State) InstallSnapshot(filePath string) error {
+	var snap StateSnapshot
+	if err := snap.Open(filePath); err != nil {
 		return err
 	}
-	log.Infof("installing snapshot %+v", snap.Header())
-	return fmt.Errorf("unimplemented")
+	defer snap.Close()
+	header := snap.Header()
+	if header.ChainID != v.chainID {
+		return fmt.Errorf("snapshot chainID %q does not match %q", header.ChainID, v.chainID)
+	}
+	log.Infow("installing state snapshot", map[string]interface{}{
+		"height": header.Height,
+		"root":   fmt.Sprintf("%x", header.Root),
+		"trees":  len(header.Trees),
+	})
+
+	v.Tx.Lock()
+	defer v.Tx.Unlock()
+	v.Tx.Discard()
+	var err error
+	if v.Tx.TreeTx, err = v.Store.BeginTx(); err != nil {
+		return fmt.Errorf("cannot begin statedb tx: %w", err)
+	}
+	var voteCount uint64
+	importedVotes := make(map[string]bool)
+	for {
+		th := snap.TreeHeader()
+		switch {
+		case th.Name == snapshotMainTree:
+			if err := v.Tx.Import(&snap); err != nil {
+				return fmt.Errorf("cannot import main tree: %w", err)
+			}
+			root, err := v.Tx.Root()
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(root, header.Root) {
+				return fmt.Errorf("imported main tree root %x does not match snapshot root %x",
+					root, header.Root)
+			}
+		case th.Parent == "":
+			cfg, ok := MainTrees[th.Name]
+			if !ok {
+				return fmt.Errorf("unknown snapshot tree %s", th.Name)
+			}
+			if err := v.Tx.ImportSubTree(cfg, &snap); err != nil {
+				return fmt.Errorf("cannot import tree %s: %w", th.Name, err)
+			}
+		case th.Parent == TreeProcess:
+			childCfg, ok := ChildTrees[th.Name]
+			if !ok {
+				return fmt.Errorf("unknown snapshot child tree %s", th.Name)
+			}
+			processTree, err := v.Tx.SubTree(StateTreeCfg(TreeProcess))
+			if err != nil {
+				return err
+			}
+			if err := processTree.ImportSubTree(childCfg.WithKey(th.Key), &snap); err != nil {
+				return fmt.Errorf("cannot import child tree %s for %x: %w", th.Name, th.Key, err)
+			}
+			// restore the data kept outside of the state trees
+			childTree, err := processTree.SubTree(childCfg.WithKey(th.Key))
+			if err != nil {
+				return err
+			}
+			leafs := uint64(0)
+			if err := childTree.Iterate(func(_, _ []byte) bool {
+				leafs++
+				return false
+			}); err != nil {
+				return err
+			}
+			switch th.Name {
+			case ChildTreeCensusPoseidon:
+				if err := statedb.SetUint64(childTree.NoState(), keyCensusLen, leafs); err != nil {
+					return err
+				}
+			case ChildTreeVotes:
+				voteCount += leafs
+				importedVotes[string(th.Key)] = true
+			}
+		default:
+			return fmt.Errorf("unknown snapshot tree parent %s", th.Parent)
+		}
+		if err := snap.FetchNextTree(); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// rebuild the index of processes by startBlock for the ones not started yet
+	processTree, err := v.Tx.SubTree(StateTreeCfg(TreeProcess))
+	if err != nil {
+		return err
+	}
+	var pendingProcesses []*models.Process
+	var prunedProcesses [][]byte
+	var iterErr error
+	if err := processTree.Iterate(func(_, value []byte) bool {
+		var p models.StateDBProcess
+		if iterErr = proto.Unmarshal(value, &p); iterErr != nil {
+			return true
+		}
+		if p.Process.StartBlock > header.Height {
+			pendingProcesses = append(pendingProcesses, p.Process)
+		}
+		// the votes pruned by the snapshot node are not imported
+		if !bytes.Equal(p.VotesRoot, emptyVotesRoot) && !importedVotes[string(p.Process.ProcessId)] {
+			prunedProcesses = append(prunedProcesses, p.Process.ProcessId)
+		}
+		return false
+	}); err != nil {
+		return err
+	}
+	if iterErr != nil {
+		return fmt.Errorf("cannot unmarshal process: %w", iterErr)
+	}
+	for _, p := range pendingProcesses {
+		if err := v.setProcessIDByStartBlock(p.ProcessId, p.StartBlock); err != nil {
+			return err
+		}
+	}
+	// the vote count of the pruned processes is kept in the snapshot header
+	for _, pid := range prunedProcesses {
+		count := header.PrunedVotes[string(pid)]
+		countLE := make([]byte, 4)
+		binary.LittleEndian.PutUint32(countLE, count)
+		if err := v.Tx.NoState().Set(keyPrunedVotes(pid), countLE); err != nil {
+			return err
+		}
+		voteCount += uint64(count)
+	}
+	voteCountLE := make([]byte, 8)
+	binary.LittleEndian.PutUint64(voteCountLE, voteCount)
+	if err := v.Tx.NoState().Set(voteCountKey, voteCountLE); err != nil {
+		return err
+	}
+
+	if err := v.Tx.Commit(header.Height); err != nil {
+		return fmt.Errorf("cannot commit statedb tx: %w", err)
+	}
+	if v.Tx.TreeTx, err = v.Store.BeginTx(); err != nil {
+		return fmt.Errorf("cannot begin statedb tx: %w", err)
+	}
+	mainTreeView, err := v.Store.TreeView(nil)
+	if err != nil {
+		return fmt.Errorf("cannot get statdeb mainTreeView: %w", err)
+	}
+	v.setMainTreeView(mainTreeView)
+	v.SetHeight(header.Height)
+	log.Infof("snapshot installed successfully at height %d", header.Height)
+	return nil
 }
-*/
 
 type diskSnapshotInfo struct {
 	ModTime time.Time
 	Height  uint32
 	Size    int64
+	Path    string
 }
 
 // ListSnapshots returns the list of the current state snapshots stored in disk.
@@ -400,7 +605,8 @@ func (v *State) ListSnapshots() []diskSnapshotInfo {
 	}
 	var list []diskSnapshotInfo
 	for _, file := range files {
-		if !file.IsDir() {
+		// the files with an extension are the snapshots being written
+		if !file.IsDir() && filepath.Ext(file.Name()) == "" {
 			height, err := strconv.Atoi(file.Name())
 			if err != nil {
 				log.Errorw(err, "could not list snapshot file height")
@@ -415,6 +621,7 @@ func (v *State) ListSnapshots() []diskSnapshotInfo {
 				Size:    fileInfo.Size(),
 				ModTime: fileInfo.ModTime(),
 				Height:  uint32(height),
+				Path:    v.SnapshotPath(uint32(height)),
 			})
 		}
 	}
@@ -61,6 +61,7 @@ var CensusOrigins = map[models.CensusOrigin]CensusProperties{
 		WeightedSupport: true, NeedsIndexSlot: true},
 	models.CensusOrigin_OFF_CHAIN_CA: {Name: "ca", WeightedSupport: true,
 		NeedsURI: true, AllowCensusUpdate: true},
+	models.CensusOrigin_EVM_STORAGE: {Name: "evm storage", WeightedSupport: true},
 }
 
 type ErrHaltVochain struct {
@@ -80,6 +81,9 @@ type State struct {
 	// with the block height.
 	Store          *statedb.StateDB
 	eventListeners []EventListener
+	// savepointListeners are the event listeners while a savepoint is
+	// active, see Savepoint.
+	savepointListeners []EventListener
 	// Tx must always be accessed via mutex because there will be
 	// concurrent operations on it.  In particular, while the Tx is being
 	// written serially via Tendermint DeliverTx (which updates the StateDB
@@ -93,8 +97,17 @@ type State struct {
 	txCounter         int32
 	// currentHeight is the height of the current started block
 	currentHeight uint32
+	// currentTimestamp is the header time of the current started block
+	currentTimestamp int64
 	// chainID identifies the blockchain
 	chainID string
+	// versionRetention is the number of past versions available, see StateAt
+	versionRetention uint32
+	// readOnly is set on the views of a past version, see StateAt
+	readOnly bool
+	// votesPruning is the number of blocks after the results when the votes
+	// of a process are pruned, see SetVotesPruning
+	votesPruning uint32
 }
 
 // NewState creates a new State
@@ -383,7 +396,9 @@ func (v *State) AddProcessKeys(tx *models.AdminTx) error {
 	return nil
 }
 
-// RevealProcessKeys reveals the keys of a process
+// RevealProcessKeys reveals the keys of a process.  If a share of the key is
+// revealed instead, it is stored and the key is revealed once it can be
+// reconstructed from the known shares.
 func (v *State) RevealProcessKeys(tx *models.AdminTx) error {
 	if tx.ProcessId == nil || tx.KeyIndex == nil {
 		return fmt.Errorf("no processId or keyIndex provided on AddProcessKeys")
@@ -395,12 +410,24 @@ func (v *State) RevealProcessKeys(tx *models.AdminTx) error {
 	if process.KeyIndex == nil || *process.KeyIndex < 1 {
 		return fmt.Errorf("no keys to reveal, keyIndex is < 1")
 	}
+	privKey := tx.EncryptionPrivateKey
+	if tx.EncryptionKeyShare != nil {
+		// a share of the key, which is revealed only once it can be reconstructed
+		privKey, err = v.addProcessKeyShare(tx.ProcessId, tx.GetKeyIndex(),
+			tx.EncryptionKeyShare, process.EncryptionPublicKeys[tx.GetKeyIndex()])
+		if err != nil {
+			return err
+		}
+		if privKey == nil {
+			return nil
+		}
+	}
 	ekey := ""
-	if tx.EncryptionPrivateKey != nil {
-		ekey = fmt.Sprintf("%x", tx.EncryptionPrivateKey)
+	if privKey != nil {
+		ekey = fmt.Sprintf("%x", privKey)
 		process.EncryptionPrivateKeys[tx.GetKeyIndex()] = ekey
 		log.Debugf("revealed encryption key %d for process %x: %x",
-			tx.GetKeyIndex(), tx.ProcessId, tx.EncryptionPrivateKey)
+			tx.GetKeyIndex(), tx.ProcessId, privKey)
 	}
 	*process.KeyIndex--
 	if err := v.UpdateProcess(process, tx.ProcessId); err != nil {
@@ -424,9 +451,15 @@ func keyProcessIDsByStartBlock(startBlock uint32) []byte {
 	return []byte(path.Join(pathProcessIDsByStartBlock, string(key)))
 }
 
-// processIDsByStartBlock returns the ProcessIDs of processes with startBlock.
-func (v *State) processIDsByStartBlock(startBlock uint32) ([][]byte, error) {
-	noState := v.Tx.NoState()
+// ProcessIDsByStartBlock returns the ProcessIDs of the committed processes
+// with startBlock.
+func (v *State) ProcessIDsByStartBlock(startBlock uint32) ([][]byte, error) {
+	return processIDsByStartBlock(v.mainTreeViewer(true).NoState(), startBlock)
+}
+
+// processIDsByStartBlock returns the ProcessIDs of processes with startBlock,
+// reading from the mainTree noState.
+func processIDsByStartBlock(noState statedb.Viewer, startBlock uint32) ([][]byte, error) {
 	pidsBytes, err := noState.Get(keyProcessIDsByStartBlock(startBlock))
 	if err == db.ErrKeyNotFound {
 		return [][]byte{}, nil
@@ -494,13 +527,16 @@ func (v *State) Save() ([]byte, error) {
 	v.Tx.Lock()
 	err := func() error {
 		var err error
-		pidsStartNextBlock, err = v.processIDsByStartBlock(height + 1)
+		pidsStartNextBlock, err = processIDsByStartBlock(v.Tx.NoState(), height+1)
 		if err != nil {
 			return fmt.Errorf("cannot get processIDs by StartBlock: %w", err)
 		}
 		if err = v.setRollingCensusSize(pidsStartNextBlock); err != nil {
 			return fmt.Errorf("cannot set rollingCensusSize for processes")
 		}
+		if err := v.pruneVotes(height); err != nil {
+			return fmt.Errorf("cannot prune votes: %w", err)
+		}
 
 		if err := v.Tx.Commit(height); err != nil {
 			return fmt.Errorf("cannot commit statedb tx: %w", err)
@@ -582,6 +618,17 @@ func (v *State) SetHeight(height uint32) {
 	atomic.StoreUint32(&v.currentHeight, height)
 }
 
+// CurrentTimestamp returns the header time of the current block, as unix
+// seconds.
+func (v *State) CurrentTimestamp() int64 {
+	return atomic.LoadInt64(&v.currentTimestamp)
+}
+
+// SetTimestamp sets the header time of the current block, as unix seconds.
+func (v *State) SetTimestamp(timestamp int64) {
+	atomic.StoreInt64(&v.currentTimestamp, timestamp)
+}
+
 // WorkingHash returns the hash of the vochain StateDB (mainTree.Root)
 func (v *State) WorkingHash() []byte {
 	v.Tx.RLock()
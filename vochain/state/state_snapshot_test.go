@@ -25,7 +25,7 @@ func TestStateSnapshot(t *testing.T) {
 	tree1 := newTreeForTest(t, 0)
 	root1, err := tree1.Root(tree1.DB().ReadTx())
 	qt.Assert(t, err, qt.IsNil)
-	snap.AddTree("Tree1", "", root1)
+	snap.AddTree("Tree1", "", nil, root1)
 	err = tree1.DumpWriter(&snap)
 	qt.Assert(t, err, qt.IsNil)
 	snap.EndTree()
@@ -33,7 +33,7 @@ func TestStateSnapshot(t *testing.T) {
 	tree2 := newTreeForTest(t, 1)
 	root2, err := tree2.Root(tree2.DB().ReadTx())
 	qt.Assert(t, err, qt.IsNil)
-	snap.AddTree("Tree2", "", root2)
+	snap.AddTree("Tree2", "", nil, root2)
 	err = tree2.DumpWriter(&snap)
 	qt.Assert(t, err, qt.IsNil)
 	snap.EndTree()
@@ -41,7 +41,7 @@ func TestStateSnapshot(t *testing.T) {
 	tree3 := newTreeForTest(t, 2)
 	root3, err := tree3.Root(tree3.DB().ReadTx())
 	qt.Assert(t, err, qt.IsNil)
-	snap.AddTree("Tree3", "Tree1", root3)
+	snap.AddTree("Tree3", "Tree1", nil, root3)
 	err = tree3.DumpWriter(&snap)
 	qt.Assert(t, err, qt.IsNil)
 	snap.EndTree()
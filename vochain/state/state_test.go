@@ -2,6 +2,7 @@ package state
 
 import (
 	"fmt"
+	"math/big"
 	"runtime"
 	"testing"
 
@@ -11,6 +12,7 @@ import (
 	"go.vocdoni.io/dvote/db"
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/test/testcommon/testutil"
+	"go.vocdoni.io/dvote/util"
 	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
 	models "go.vocdoni.io/proto/build/go/models"
 )
@@ -181,6 +183,12 @@ func (l *Listener) OnSetAccount(addr []byte, account *Account) {
 }
 func (l *Listener) OnTransferTokens(tx *vochaintx.TokenTransfer) {
 }
+func (l *Listener) OnRotateAccount(rotation *vochaintx.AccountRotation) {
+}
+func (l *Listener) OnVoteDelegation(delegation *vochaintx.VoteDelegation, txIndex int32) {
+}
+func (l *Listener) OnVoteWeight(vote *Vote, previousWeight *big.Int, txIndex int32) {
+}
 func (l *Listener) OnProcessesStart(pids [][]byte) {
 	l.processStart = append(l.processStart, pids)
 }
@@ -398,3 +406,259 @@ func TestStateSetGetTxCostByTxType(t *testing.T) {
 	qt.Assert(t, err, qt.IsNil)
 	qt.Assert(t, cost, qt.Equals, uint64(100))
 }
+
+func TestVoteProof(t *testing.T) {
+	rng := testutil.NewRandom(0)
+	s, err := NewState(db.TypePebble, t.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+	defer s.Close()
+
+	s.Rollback()
+	s.SetHeight(1)
+	pid := rng.RandomBytes(32)
+	censusURI := "ipfs://foobar"
+	qt.Assert(t, s.AddProcess(&models.Process{
+		EntityId:  rng.RandomBytes(32),
+		CensusURI: &censusURI,
+		ProcessId: pid,
+	}), qt.IsNil)
+	var nullifiers [][]byte
+	for i := 0; i < 10; i++ {
+		nullifiers = append(nullifiers, rng.RandomBytes(32))
+		qt.Assert(t, s.AddVote(&Vote{
+			ProcessID:   pid,
+			Nullifier:   nullifiers[i],
+			VotePackage: rng.RandomBytes(64),
+		}), qt.IsNil)
+	}
+	root, err := s.Save()
+	qt.Assert(t, err, qt.IsNil)
+
+	proof, err := s.VoteProof(pid, nullifiers[3])
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, proof.Height, qt.Equals, uint32(1))
+	qt.Assert(t, proof.Root, qt.DeepEquals, root)
+	qt.Assert(t, proof.Verify(pid, nullifiers[3]), qt.IsNil)
+
+	// the proof does not hold for another vote nor another root
+	qt.Assert(t, proof.Verify(pid, nullifiers[4]), qt.ErrorMatches, ".*nullifier mismatch.*")
+	proof.Root = rng.RandomBytes(32)
+	qt.Assert(t, proof.Verify(pid, nullifiers[3]), qt.ErrorMatches, "invalid processes proof.*")
+
+	// unknown votes have no proof
+	_, err = s.VoteProof(pid, rng.RandomBytes(32))
+	qt.Assert(t, err, qt.ErrorIs, ErrVoteNotFound)
+	_, err = s.VoteProof(rng.RandomBytes(32), nullifiers[3])
+	qt.Assert(t, err, qt.ErrorIs, ErrProcessNotFound)
+}
+
+func TestVoteDelegationWeight(t *testing.T) {
+	rng := testutil.NewRandom(0)
+	s, err := NewState(db.TypePebble, t.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+	defer s.Close()
+
+	s.Rollback()
+	s.SetHeight(1)
+	pid := rng.RandomBytes(32)
+	censusURI := "ipfs://foobar"
+	qt.Assert(t, s.AddProcess(&models.Process{
+		EntityId:  rng.RandomBytes(32),
+		CensusURI: &censusURI,
+		ProcessId: pid,
+	}), qt.IsNil)
+	keys := util.CreateEthRandomKeysBatch(3)
+	vote := func(key int, weight int64) {
+		qt.Assert(t, s.AddVote(&Vote{
+			ProcessID:   pid,
+			Nullifier:   GenerateNullifier(keys[key].Address(), pid),
+			VotePackage: rng.RandomBytes(64),
+			Weight:      big.NewInt(weight),
+			VoterID:     append([]byte{VoterIDTypeECDSA}, keys[key].PublicKey()...),
+		}), qt.IsNil)
+	}
+	weight := func(key int) int64 {
+		vote, err := s.Vote(pid, GenerateNullifier(keys[key].Address(), pid), false)
+		qt.Assert(t, err, qt.IsNil)
+		return new(big.Int).SetBytes(vote.Weight).Int64()
+	}
+
+	// 2 -> 1 -> 0, where only the delegate of the chain votes
+	qt.Assert(t, s.AddVoteDelegation(&vochaintx.VoteDelegation{ProcessID: pid,
+		Delegator: keys[1].Address(), Delegate: keys[0].Address(), Weight: big.NewInt(5)}), qt.IsNil)
+	vote(0, 2)
+	qt.Assert(t, weight(0), qt.Equals, int64(7))
+	qt.Assert(t, s.AddVoteDelegation(&vochaintx.VoteDelegation{ProcessID: pid,
+		Delegator: keys[2].Address(), Delegate: keys[1].Address(), Weight: big.NewInt(4)}), qt.IsNil)
+	qt.Assert(t, weight(0), qt.Equals, int64(11))
+
+	// the delegator votes with a weight other than the delegated one, and the
+	// weight moved to the delegate by the delegations is removed from it
+	vote(1, 3)
+	qt.Assert(t, weight(1), qt.Equals, int64(7))
+	qt.Assert(t, weight(0), qt.Equals, int64(2))
+
+	// two chains of 8 delegations, 1 -> 0 and 10 -> 9, can only be joined
+	// while the resulting chain is not longer than MaxVoteDelegationDepth
+	keys = util.CreateEthRandomKeysBatch(18)
+	delegate := func(delegator, delegate int) error {
+		return s.AddVoteDelegation(&vochaintx.VoteDelegation{ProcessID: pid,
+			Delegator: keys[delegator].Address(), Delegate: keys[delegate].Address(),
+			Weight: big.NewInt(1)})
+	}
+	for i := 1; i <= 8; i++ {
+		qt.Assert(t, delegate(i, i-1), qt.IsNil)
+		qt.Assert(t, delegate(9+i, 9+i-1), qt.IsNil)
+	}
+	qt.Assert(t, delegate(0, 17), qt.ErrorMatches, "vote delegation chain longer than 16")
+	qt.Assert(t, delegate(0, 10), qt.IsNil)
+	qt.Assert(t, delegate(9, 0), qt.ErrorMatches, "vote delegation cycle.*")
+	vote(9, 1)
+	qt.Assert(t, weight(9), qt.Equals, int64(18))
+	vote(0, 1)
+	qt.Assert(t, weight(0), qt.Equals, int64(9))
+	qt.Assert(t, weight(9), qt.Equals, int64(9))
+}
+
+func TestStateAt(t *testing.T) {
+	s, err := NewState(db.TypePebble, t.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+	defer s.Close()
+	addr1 := ethereum.SignKeys{}
+	qt.Assert(t, addr1.Generate(), qt.IsNil)
+	addr2 := ethereum.SignKeys{}
+	qt.Assert(t, addr2.Generate(), qt.IsNil)
+
+	// block 1 creates the accounts, and each next block transfers 10 tokens
+	for height := uint32(1); height <= 4; height++ {
+		s.Rollback()
+		s.SetHeight(height)
+		if height == 1 {
+			qt.Assert(t, s.CreateAccount(addr1.Address(), "ipfs://", nil, 50), qt.IsNil)
+			qt.Assert(t, s.CreateAccount(addr2.Address(), "ipfs://", nil, 0), qt.IsNil)
+		} else {
+			qt.Assert(t, s.TransferBalance(&vochaintx.TokenTransfer{
+				FromAddress: addr1.Address(),
+				ToAddress:   addr2.Address(),
+				Amount:      10,
+			}, false), qt.IsNil)
+		}
+		_, err := s.Save()
+		qt.Assert(t, err, qt.IsNil)
+	}
+
+	for height := uint32(1); height <= 4; height++ {
+		view, err := s.StateAt(height)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, view.ReadOnly(), qt.IsTrue)
+		for _, committed := range []bool{true, false} {
+			acc, err := view.GetAccount(addr2.Address(), committed)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Assert(t, acc.Balance, qt.Equals, uint64(10*(height-1)))
+		}
+		proof, err := view.LeafProof(TreeAccounts, addr1.Address().Bytes())
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, proof.Height, qt.Equals, height)
+		root, err := s.Store.VersionRoot(height)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, []byte(proof.Root), qt.DeepEquals, root)
+	}
+
+	// the heights not committed yet, or out of the retention, are not available
+	_, err = s.StateAt(5)
+	qt.Assert(t, err, qt.ErrorIs, ErrVersionNotAvailable)
+	s.SetVersionRetention(2)
+	_, err = s.StateAt(1)
+	qt.Assert(t, err, qt.ErrorIs, ErrVersionNotAvailable)
+	_, err = s.StateAt(2)
+	qt.Assert(t, err, qt.IsNil)
+}
+
+func TestPruneVotes(t *testing.T) {
+	s, err := NewState(db.TypePebble, t.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+	defer s.Close()
+	rng := testutil.NewRandom(0)
+	s.SetVotesPruning(2)
+
+	doBlock := func(height uint32, fn func()) {
+		s.Rollback()
+		s.SetHeight(height)
+		fn()
+		_, err := s.Save()
+		qt.Assert(t, err, qt.IsNil)
+	}
+
+	oracle := common.HexToAddress("0x309Bd6959bf4289CDf9c7198cF9f4494e0244b7d")
+	pid := rng.RandomBytes(32)
+	eid := rng.RandomBytes(32)
+	var nullifiers [][]byte
+	doBlock(1, func() {
+		qt.Assert(t, s.AddOracle(oracle), qt.IsNil)
+		censusURI := "ipfs://foobar"
+		qt.Assert(t, s.AddProcess(&models.Process{
+			ProcessId:  pid,
+			EntityId:   eid,
+			CensusURI:  &censusURI,
+			StartBlock: 1,
+			BlockCount: 2,
+			Status:     models.ProcessStatus_READY,
+		}), qt.IsNil)
+		for i := 0; i < 10; i++ {
+			nullifiers = append(nullifiers, rng.RandomBytes(32))
+			qt.Assert(t, s.AddVote(&Vote{
+				ProcessID:   pid,
+				Nullifier:   nullifiers[i],
+				VotePackage: []byte(fmt.Sprintf("vote%d", i)),
+			}), qt.IsNil)
+		}
+	})
+	doBlock(2, func() {})
+	// the results are set at height 3, so the votes are pruned at height 5
+	doBlock(3, func() {
+		qt.Assert(t, s.SetProcessResults(pid, &models.ProcessResult{
+			ProcessId:     pid,
+			EntityId:      eid,
+			OracleAddress: oracle.Bytes(),
+		}, true), qt.IsNil)
+	})
+	doBlock(4, func() {})
+	_, err = s.Vote(pid, nullifiers[0], true)
+	qt.Assert(t, err, qt.IsNil)
+	hash, err := s.Store.Hash()
+	qt.Assert(t, err, qt.IsNil)
+
+	doBlock(5, func() {})
+	prunedHash, err := s.Store.Hash()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, prunedHash, qt.DeepEquals, hash)
+	for _, committed := range []bool{true, false} {
+		_, err = s.Vote(pid, nullifiers[0], committed)
+		qt.Assert(t, err, qt.ErrorIs, ErrVotesPruned)
+		pruned, count, err := s.VotesPruned(pid, committed)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, pruned, qt.IsTrue)
+		qt.Assert(t, count, qt.Equals, uint32(10))
+		qt.Assert(t, s.CountVotes(pid, committed), qt.Equals, uint32(10))
+	}
+	_, err = s.VoteProof(pid, nullifiers[0])
+	qt.Assert(t, err, qt.ErrorIs, ErrVotesPruned)
+	// the process keeps its results
+	process, err := s.Process(pid, true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, process.Status, qt.Equals, models.ProcessStatus_RESULTS)
+	doBlock(6, func() {})
+
+	// the vote count of the pruned process is kept by the snapshots
+	snapshotPath, err := s.Snapshot()
+	qt.Assert(t, err, qt.IsNil)
+	s2, err := NewState(db.TypePebble, t.TempDir())
+	qt.Assert(t, err, qt.IsNil)
+	defer s2.Close()
+	qt.Assert(t, s2.InstallSnapshot(snapshotPath), qt.IsNil)
+	pruned, count, err := s2.VotesPruned(pid, true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, pruned, qt.IsTrue)
+	qt.Assert(t, count, qt.Equals, uint32(10))
+	qt.Assert(t, s2.CountVotes(pid, true), qt.Equals, uint32(10))
+}
@@ -61,8 +61,9 @@ func (v *State) setMainTreeView(treeView *statedb.TreeView) {
 // When committed is false, the mainTree returned is the not yet commited one
 // from the currently open StateDB transaction.
 // When committed is true, the mainTree returned is the last commited version.
+// The read-only views always return the mainTree of their version.
 func (v *State) mainTreeViewer(committed bool) statedb.TreeViewer {
-	if committed {
+	if committed || v.readOnly {
 		return v.MainTreeView()
 	}
 	return v.Tx.AsTreeView()
@@ -0,0 +1,133 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/vocdoni/arbo"
+	"go.vocdoni.io/dvote/tree"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+// VoteProof is the proof of a vote being part of the state at a given height.
+// It chains the merkle proof of the vote in the Votes tree of its process, the
+// proof of the process in the Processes tree and the proof of the Processes
+// tree in the mainTree, whose root is the block AppHash.
+type VoteProof struct {
+	// Height is the state version of the proof.  The Root is the AppHash of
+	// the header of the next block.
+	Height uint32
+	// Root is the mainTree root at Height.
+	Root []byte
+	// Vote is the models.StateDBVote leaf of the Votes tree, with key
+	// hash(processID+nullifier).
+	Vote         []byte
+	VoteSiblings []byte
+	// Process is the models.StateDBProcess leaf of the Processes tree, which
+	// contains the Votes tree root.
+	Process         []byte
+	ProcessSiblings []byte
+	// Processes is the Processes tree root, the leaf of the mainTree.
+	Processes         []byte
+	ProcessesSiblings []byte
+}
+
+// VoteProof returns the proof of the vote in the last committed state.
+func (v *State) VoteProof(processID, nullifier []byte) (*VoteProof, error) {
+	vid, err := voteID(processID, nullifier)
+	if err != nil {
+		return nil, err
+	}
+	height, root, mainTree, err := v.committedMainTree()
+	if err != nil {
+		return nil, err
+	}
+	pruned, _, err := votesPruned(mainTree.NoState(), processID)
+	if err != nil {
+		return nil, err
+	}
+	if pruned {
+		return nil, ErrVotesPruned
+	}
+	proof := &VoteProof{Height: height, Root: root}
+	processCfg := StateTreeCfg(TreeProcess)
+	if proof.Processes, proof.ProcessesSiblings, err = mainTree.GenProof(
+		processCfg.Key()); err != nil {
+		return nil, fmt.Errorf("cannot generate processes proof: %w", err)
+	}
+	processTree, err := mainTree.SubTree(processCfg)
+	if err != nil {
+		return nil, err
+	}
+	proof.Process, proof.ProcessSiblings, err = processTree.GenProof(processID)
+	if errors.Is(err, arbo.ErrKeyNotFound) {
+		return nil, ErrProcessNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot generate process proof: %w", err)
+	}
+	votesTree, err := processTree.SubTree(StateChildTreeCfg(ChildTreeVotes).WithKey(processID))
+	if err != nil {
+		return nil, err
+	}
+	proof.Vote, proof.VoteSiblings, err = votesTree.GenProof(vid)
+	if errors.Is(err, arbo.ErrKeyNotFound) {
+		return nil, ErrVoteNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot generate vote proof: %w", err)
+	}
+	return proof, nil
+}
+
+// Verify checks the proof chain of the vote of processID with the given
+// nullifier, up to the proof Root.  The caller must still check the Root
+// against the AppHash of the block Height+1.
+func (p *VoteProof) Verify(processID, nullifier []byte) error {
+	vid, err := voteID(processID, nullifier)
+	if err != nil {
+		return err
+	}
+	vote := &models.StateDBVote{}
+	if err := proto.Unmarshal(p.Vote, vote); err != nil {
+		return fmt.Errorf("cannot unmarshal vote: %w", err)
+	}
+	if !bytes.Equal(vote.Nullifier, nullifier) {
+		return fmt.Errorf("vote nullifier mismatch")
+	}
+	votesRoot, err := processGetVotesRoot(p.Process)
+	if err != nil {
+		return err
+	}
+	votesCfg := StateChildTreeCfg(ChildTreeVotes)
+	if err := checkProof(votesCfg.HashFunc(), vid, p.Vote, votesRoot, p.VoteSiblings); err != nil {
+		return fmt.Errorf("invalid vote proof: %w", err)
+	}
+	processCfg := StateTreeCfg(TreeProcess)
+	processesRoot, err := rootLeafGetRoot(p.Processes)
+	if err != nil {
+		return err
+	}
+	if err := checkProof(processCfg.HashFunc(), processID, p.Process, processesRoot,
+		p.ProcessSiblings); err != nil {
+		return fmt.Errorf("invalid process proof: %w", err)
+	}
+	// the mainTree uses the same hash function as its singleton subTrees
+	if err := checkProof(processCfg.HashFunc(), processCfg.Key(), p.Processes, p.Root,
+		p.ProcessesSiblings); err != nil {
+		return fmt.Errorf("invalid processes proof: %w", err)
+	}
+	return nil
+}
+
+// checkProof checks a merkle proof of the key and value under root.
+func checkProof(hashFunc arbo.HashFunction, key, value, root, siblings []byte) error {
+	valid, err := tree.VerifyProof(hashFunc, key, value, siblings, root)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return fmt.Errorf("proof does not match root %x", root)
+	}
+	return nil
+}
@@ -12,6 +12,7 @@ import (
 	"go.vocdoni.io/dvote/crypto/ethereum"
 	"go.vocdoni.io/dvote/db"
 	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/statedb"
 	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/proto/build/go/models"
 	"google.golang.org/protobuf/proto"
@@ -103,10 +104,11 @@ func (v *State) voteCountInc() error {
 
 // AddVote adds a new vote to a process and call the even listeners to OnVote.
 // If the vote already exists it will be overwritten and overwrite counter will be increased.
+// The weight of the vote is increased by the weight delegated to the voter, see AddVoteDelegation.
 // Note that the vote is not committed to the StateDB until the StateDB transaction is committed.
 // Note that the vote is not verified, so it is the caller responsibility to verify the vote.
 func (s *State) AddVote(vote *Vote) error {
-	vid, err := s.voteID(vote.ProcessID, vote.Nullifier)
+	vid, err := voteID(vote.ProcessID, vote.Nullifier)
 	if err != nil {
 		return err
 	}
@@ -115,17 +117,20 @@ func (s *State) AddVote(vote *Vote) error {
 
 	// get the vote from state database
 	sdbVote, err := s.Vote(vote.ProcessID, vote.Nullifier, false)
-	if err != nil {
-		if errors.Is(err, ErrVoteNotFound) {
-			sdbVote = &models.StateDBVote{
-				VoteHash:             vote.Hash(),
-				Nullifier:            vote.Nullifier,
-				Weight:               vote.WeightBytes(),
-				VotePackage:          vote.VotePackage,
-				EncryptionKeyIndexes: vote.EncryptionKeyIndexes,
-			}
-		} else {
-			return err
+	if err != nil && !errors.Is(err, ErrVoteNotFound) {
+		return err
+	}
+	// add the weight delegated to the voter, see AddVoteDelegation
+	if err := s.delegateVote(vote, sdbVote != nil); err != nil {
+		return fmt.Errorf("cannot delegate vote: %w", err)
+	}
+	if sdbVote == nil {
+		sdbVote = &models.StateDBVote{
+			VoteHash:             vote.Hash(),
+			Nullifier:            vote.Nullifier,
+			Weight:               vote.WeightBytes(),
+			VotePackage:          vote.VotePackage,
+			EncryptionKeyIndexes: vote.EncryptionKeyIndexes,
 		}
 	} else {
 		// overwrite vote if it already exists
@@ -169,7 +174,7 @@ func (s *State) AddVote(vote *Vote) error {
 // NOTE(Edu): Changed this from byte(processID+nullifier) to
 // hash(processID+nullifier) to allow using it as a key in Arbo tree.
 // voteID = hash(processID+nullifier)
-func (v *State) voteID(pid, nullifier []byte) ([]byte, error) {
+func voteID(pid, nullifier []byte) ([]byte, error) {
 	if len(pid) != types.ProcessIDsize {
 		return nil, fmt.Errorf("wrong processID size %d", len(pid))
 	}
@@ -182,13 +187,30 @@ func (v *State) voteID(pid, nullifier []byte) ([]byte, error) {
 	return vid.Sum(nil), nil
 }
 
+// votesTreeViewer opens the Votes tree of the process, or returns
+// ErrVotesPruned if its votes were pruned, see SetVotesPruning.  The Tx lock
+// must be held by the caller when committed is false.
+func (v *State) votesTreeViewer(processID []byte, committed bool) (statedb.TreeViewer, error) {
+	mainTree := v.mainTreeViewer(committed)
+	pruned, _, err := votesPruned(mainTree.NoState(), processID)
+	if err != nil {
+		return nil, err
+	}
+	if pruned {
+		return nil, ErrVotesPruned
+	}
+	treeCfg := StateChildTreeCfg(ChildTreeVotes)
+	return mainTree.DeepSubTree(StateTreeCfg(TreeProcess), treeCfg.WithKey(processID))
+}
+
 // Vote returns the stored vote if exists. Returns ErrProcessNotFound if the
-// process does not exist, ErrVoteNotFound if the vote does not exist.
+// process does not exist, ErrVoteNotFound if the vote does not exist, and
+// ErrVotesPruned if the votes of the process were pruned.
 // When committed is false, the operation is executed also on not yet commited
 // data from the currently open StateDB transaction.
 // When committed is true, the operation is executed on the last commited version.
 func (v *State) Vote(processID, nullifier []byte, committed bool) (*models.StateDBVote, error) {
-	vid, err := v.voteID(processID, nullifier)
+	vid, err := voteID(processID, nullifier)
 	if err != nil {
 		return nil, err
 	}
@@ -199,9 +221,7 @@ func (v *State) Vote(processID, nullifier []byte, committed bool) (*models.State
 		v.Tx.Lock()
 		defer v.Tx.Unlock()
 	}
-	treeCfg := StateChildTreeCfg(ChildTreeVotes)
-	votesTree, err := v.mainTreeViewer(committed).DeepSubTree(
-		StateTreeCfg(TreeProcess), treeCfg.WithKey(processID))
+	votesTree, err := v.votesTreeViewer(processID, committed)
 	if errors.Is(err, arbo.ErrKeyNotFound) {
 		return nil, ErrProcessNotFound
 	} else if err != nil {
@@ -227,9 +247,7 @@ func (v *State) IterateVotes(processID []byte, committed bool, callback func(vot
 		v.Tx.Lock()
 		defer v.Tx.Unlock()
 	}
-	treeCfg := StateChildTreeCfg(ChildTreeVotes)
-	votesTree, err := v.mainTreeViewer(committed).DeepSubTree(
-		StateTreeCfg(TreeProcess), treeCfg.WithKey(processID))
+	votesTree, err := v.votesTreeViewer(processID, committed)
 	if errors.Is(err, arbo.ErrKeyNotFound) {
 		return ErrProcessNotFound
 	} else if err != nil {
@@ -272,9 +290,7 @@ func (v *State) iterateVotes(processID []byte,
 		v.Tx.RLock()
 		defer v.Tx.RUnlock()
 	}
-	treeCfg := StateChildTreeCfg(ChildTreeVotes)
-	votesTree, err := v.mainTreeViewer(committed).DeepSubTree(
-		StateTreeCfg(TreeProcess), treeCfg.WithKey(processID))
+	votesTree, err := v.votesTreeViewer(processID, committed)
 	if err != nil {
 		return err
 	}
@@ -295,11 +311,15 @@ func (v *State) iterateVotes(processID []byte,
 	return nil
 }
 
-// CountVotes returns the number of votes registered for a given process id
+// CountVotes returns the number of votes registered for a given process id,
+// which is kept when the votes are pruned.
 // When committed is false, the operation is executed also on not yet commited
 // data from the currently open StateDB transaction.
 // When committed is true, the operation is executed on the last commited version.
 func (v *State) CountVotes(processID []byte, committed bool) uint32 {
+	if pruned, count, err := v.VotesPruned(processID, committed); err == nil && pruned {
+		return count
+	}
 	var count uint32
 	// TODO: Once statedb.TreeView.Size() works, replace this by that.
 	v.iterateVotes(processID, func(vid []byte, sdbVote *models.StateDBVote) bool {
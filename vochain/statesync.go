@@ -0,0 +1,292 @@
+package vochain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"go.vocdoni.io/dvote/log"
+	vstate "go.vocdoni.io/dvote/vochain/state"
+)
+
+const (
+	// snapshotFormat is the ABCI snapshot format version.  It must be increased
+	// if the chunking or the state snapshot encoding changes.
+	snapshotFormat = 2
+	// snapshotChunkSize is the size in bytes of each ABCI snapshot chunk
+	// (the last chunk of a snapshot might be smaller).
+	snapshotChunkSize = 4 << 20
+)
+
+// snapshotRestore holds the information of a snapshot being restored via
+// state sync, between OfferSnapshot and the last ApplySnapshotChunk.
+type snapshotRestore struct {
+	snapshot    *abcitypes.Snapshot
+	appHash     []byte
+	chunkHashes [][]byte
+	applied     []bool
+	file        *os.File
+}
+
+// snapshot takes a state snapshot of the block committed at height, with the
+// given block time, in the background.  It reads a read-only view of that
+// state version, so the blocks committed meanwhile do not change it and
+// Commit does not wait for it.  The snapshot is skipped if the previous one is
+// still being taken.  Once taken, the oldest snapshots are pruned.
+func (app *BaseApplication) snapshot(height uint32, timestamp int64) {
+	if !app.snapshotting.CompareAndSwap(false, true) {
+		log.Warnf("skipping state snapshot on block %d, the previous one is not finished", height)
+		return
+	}
+	view, err := app.State.StateAt(height)
+	if err != nil {
+		app.snapshotting.Store(false)
+		log.Errorf("cannot make state snapshot: %v", err)
+		return
+	}
+	view.SetTimestamp(timestamp)
+	go func() {
+		defer app.snapshotting.Store(false)
+		startTime := time.Now()
+		log.Infof("performing a state snapshot on block %d", height)
+		if _, err := view.Snapshot(); err != nil {
+			log.Errorf("cannot make state snapshot: %v", err)
+			return
+		}
+		log.Infof("snapshot created successfully, took %s", time.Since(startTime))
+		app.pruneSnapshots()
+	}()
+}
+
+// pruneSnapshots removes the oldest state snapshots from disk, keeping the
+// last snapshotKeep ones (all of them if zero).
+func (app *BaseApplication) pruneSnapshots() {
+	if app.snapshotKeep == 0 {
+		return
+	}
+	snapshots := app.State.ListSnapshots()
+	if len(snapshots) <= int(app.snapshotKeep) {
+		return
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Height < snapshots[j].Height
+	})
+	app.snapshotsLock.Lock()
+	defer app.snapshotsLock.Unlock()
+	for _, s := range snapshots[:len(snapshots)-int(app.snapshotKeep)] {
+		if err := os.Remove(s.Path); err != nil {
+			log.Warnf("cannot remove snapshot %d: %v", s.Height, err)
+			continue
+		}
+		delete(app.snapshots, s.Height)
+		log.Infof("removed state snapshot %d", s.Height)
+	}
+}
+
+// snapshotChunkHashes splits the snapshot file found at path in chunks of
+// snapshotChunkSize and returns the sha256 hash of each one.
+func snapshotChunkHashes(path string) ([][]byte, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	var hashes [][]byte
+	buf := make([]byte, snapshotChunkSize)
+	for {
+		n, err := io.ReadFull(fd, buf)
+		if n > 0 {
+			h := sha256.Sum256(buf[:n])
+			hashes = append(hashes, h[:])
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return hashes, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// snapshotMetadata returns the ABCI snapshot metadata (the concatenation of
+// all chunk hashes) and the snapshot hash, which is the hash of the metadata.
+func snapshotMetadata(chunkHashes [][]byte) ([]byte, []byte) {
+	metadata := bytes.Join(chunkHashes, nil)
+	hash := sha256.Sum256(metadata)
+	return metadata, hash[:]
+}
+
+// ListSnapshots returns the state snapshots available in disk, so they can be
+// served to other nodes performing state sync.
+func (app *BaseApplication) ListSnapshots(
+	req abcitypes.RequestListSnapshots) abcitypes.ResponseListSnapshots {
+	app.snapshotsLock.Lock()
+	defer app.snapshotsLock.Unlock()
+	var snapshots []*abcitypes.Snapshot
+	for _, s := range app.State.ListSnapshots() {
+		snapshot, ok := app.snapshots[s.Height]
+		if !ok {
+			// snapshots are immutable once stored, so their chunk hashes
+			// can be computed only once
+			chunkHashes, err := snapshotChunkHashes(s.Path)
+			if err != nil {
+				log.Warnf("cannot read snapshot %d: %v", s.Height, err)
+				continue
+			}
+			metadata, hash := snapshotMetadata(chunkHashes)
+			snapshot = &abcitypes.Snapshot{
+				Height:   uint64(s.Height),
+				Format:   snapshotFormat,
+				Chunks:   uint32(len(chunkHashes)),
+				Hash:     hash,
+				Metadata: metadata,
+			}
+			app.snapshots[s.Height] = snapshot
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return abcitypes.ResponseListSnapshots{Snapshots: snapshots}
+}
+
+// LoadSnapshotChunk returns the requested chunk of a local state snapshot.
+func (app *BaseApplication) LoadSnapshotChunk(
+	req abcitypes.RequestLoadSnapshotChunk) abcitypes.ResponseLoadSnapshotChunk {
+	if req.Format != snapshotFormat {
+		return abcitypes.ResponseLoadSnapshotChunk{}
+	}
+	fd, err := os.Open(app.State.SnapshotPath(uint32(req.Height)))
+	if err != nil {
+		log.Warnf("cannot open snapshot %d: %v", req.Height, err)
+		return abcitypes.ResponseLoadSnapshotChunk{}
+	}
+	defer fd.Close()
+	chunk := make([]byte, snapshotChunkSize)
+	n, err := fd.ReadAt(chunk, int64(req.Chunk)*snapshotChunkSize)
+	if err != nil && err != io.EOF {
+		log.Warnf("cannot read chunk %d of snapshot %d: %v", req.Chunk, req.Height, err)
+		return abcitypes.ResponseLoadSnapshotChunk{}
+	}
+	return abcitypes.ResponseLoadSnapshotChunk{Chunk: chunk[:n]}
+}
+
+// OfferSnapshot is called by state sync when a snapshot is discovered.  The
+// snapshot is accepted if its format is known and its metadata contains the
+// hashes of all the chunks, so each chunk can be verified once received.
+func (app *BaseApplication) OfferSnapshot(
+	req abcitypes.RequestOfferSnapshot) abcitypes.ResponseOfferSnapshot {
+	snapshot := req.Snapshot
+	if snapshot == nil {
+		return abcitypes.ResponseOfferSnapshot{Result: abcitypes.ResponseOfferSnapshot_REJECT}
+	}
+	if snapshot.Format != snapshotFormat {
+		return abcitypes.ResponseOfferSnapshot{Result: abcitypes.ResponseOfferSnapshot_REJECT_FORMAT}
+	}
+	if snapshot.Chunks == 0 || len(snapshot.Metadata) != int(snapshot.Chunks)*sha256.Size {
+		return abcitypes.ResponseOfferSnapshot{Result: abcitypes.ResponseOfferSnapshot_REJECT}
+	}
+	var chunkHashes [][]byte
+	for i := 0; i < len(snapshot.Metadata); i += sha256.Size {
+		chunkHashes = append(chunkHashes, snapshot.Metadata[i:i+sha256.Size])
+	}
+	if _, hash := snapshotMetadata(chunkHashes); !bytes.Equal(hash, snapshot.Hash) {
+		return abcitypes.ResponseOfferSnapshot{Result: abcitypes.ResponseOfferSnapshot_REJECT}
+	}
+	app.snapshotsLock.Lock()
+	defer app.snapshotsLock.Unlock()
+	app.closeSnapshotRestore()
+	fd, err := os.Create(app.State.SnapshotPath(uint32(snapshot.Height)) + ".tmp")
+	if err != nil {
+		log.Warnf("cannot create snapshot file: %v", err)
+		return abcitypes.ResponseOfferSnapshot{Result: abcitypes.ResponseOfferSnapshot_ABORT}
+	}
+	log.Infow("accepted state snapshot", map[string]interface{}{
+		"height": snapshot.Height,
+		"chunks": snapshot.Chunks,
+		"hash":   fmt.Sprintf("%x", snapshot.Hash),
+	})
+	app.restore = &snapshotRestore{
+		snapshot:    snapshot,
+		appHash:     req.AppHash,
+		chunkHashes: chunkHashes,
+		applied:     make([]bool, snapshot.Chunks),
+		file:        fd,
+	}
+	return abcitypes.ResponseOfferSnapshot{Result: abcitypes.ResponseOfferSnapshot_ACCEPT}
+}
+
+// ApplySnapshotChunk verifies the hash of a received chunk and stores it.
+// Once all the chunks are received, the snapshot root is checked against the
+// trusted application hash and the snapshot is installed into the State.
+func (app *BaseApplication) ApplySnapshotChunk(
+	req abcitypes.RequestApplySnapshotChunk) abcitypes.ResponseApplySnapshotChunk {
+	app.snapshotsLock.Lock()
+	defer app.snapshotsLock.Unlock()
+	restore := app.restore
+	if restore == nil || int(req.Index) >= len(restore.chunkHashes) {
+		return abcitypes.ResponseApplySnapshotChunk{Result: abcitypes.ResponseApplySnapshotChunk_ABORT}
+	}
+	if hash := sha256.Sum256(req.Chunk); !bytes.Equal(hash[:], restore.chunkHashes[req.Index]) {
+		log.Warnf("wrong hash for snapshot chunk %d sent by %s", req.Index, req.Sender)
+		return abcitypes.ResponseApplySnapshotChunk{
+			Result:        abcitypes.ResponseApplySnapshotChunk_RETRY,
+			RefetchChunks: []uint32{req.Index},
+			RejectSenders: []string{req.Sender},
+		}
+	}
+	if _, err := restore.file.WriteAt(req.Chunk, int64(req.Index)*snapshotChunkSize); err != nil {
+		log.Warnf("cannot write snapshot chunk %d: %v", req.Index, err)
+		return abcitypes.ResponseApplySnapshotChunk{Result: abcitypes.ResponseApplySnapshotChunk_ABORT}
+	}
+	restore.applied[req.Index] = true
+	for _, applied := range restore.applied {
+		if !applied {
+			return abcitypes.ResponseApplySnapshotChunk{Result: abcitypes.ResponseApplySnapshotChunk_ACCEPT}
+		}
+	}
+
+	// all chunks received, check the snapshot root against the trusted
+	// application hash and install it
+	height := uint32(restore.snapshot.Height)
+	tmpPath := restore.file.Name()
+	app.closeSnapshotRestore()
+	var snap vstate.StateSnapshot
+	if err := snap.Open(tmpPath); err != nil {
+		log.Warnf("cannot open snapshot %d: %v", height, err)
+		return abcitypes.ResponseApplySnapshotChunk{Result: abcitypes.ResponseApplySnapshotChunk_REJECT_SNAPSHOT}
+	}
+	header := snap.Header()
+	if err := snap.Close(); err != nil {
+		log.Warnf("cannot close snapshot file: %v", err)
+	}
+	if header.Height != height || !bytes.Equal(header.Root, restore.appHash) {
+		log.Warnf("snapshot %d root %x does not match the trusted app hash %x",
+			header.Height, header.Root, restore.appHash)
+		return abcitypes.ResponseApplySnapshotChunk{Result: abcitypes.ResponseApplySnapshotChunk_REJECT_SNAPSHOT}
+	}
+	if err := os.Rename(tmpPath, app.State.SnapshotPath(height)); err != nil {
+		log.Warnf("cannot store snapshot file: %v", err)
+		return abcitypes.ResponseApplySnapshotChunk{Result: abcitypes.ResponseApplySnapshotChunk_ABORT}
+	}
+	if err := app.State.InstallSnapshot(app.State.SnapshotPath(height)); err != nil {
+		log.Warnf("cannot install snapshot %d: %v", height, err)
+		return abcitypes.ResponseApplySnapshotChunk{Result: abcitypes.ResponseApplySnapshotChunk_REJECT_SNAPSHOT}
+	}
+	app.endBlock(int64(height), time.Unix(header.Timestamp, 0))
+	return abcitypes.ResponseApplySnapshotChunk{Result: abcitypes.ResponseApplySnapshotChunk_ACCEPT}
+}
+
+// closeSnapshotRestore closes the temporary file of the snapshot being
+// restored, if any.  Must be called with snapshotsLock held.
+func (app *BaseApplication) closeSnapshotRestore() {
+	if app.restore == nil {
+		return
+	}
+	if err := app.restore.file.Close(); err != nil {
+		log.Warnf("cannot close snapshot file: %v", err)
+	}
+	app.restore = nil
+}
@@ -0,0 +1,203 @@
+package vochain
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"go.vocdoni.io/dvote/types"
+	"go.vocdoni.io/dvote/util"
+	vstate "go.vocdoni.io/dvote/vochain/state"
+	"go.vocdoni.io/proto/build/go/models"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestStateSyncSnapshot(t *testing.T) {
+	app := TestBaseApplication(t)
+	keys, root, proofs := testCreateKeysAndBuildCensus(t, 10)
+	censusURI := ipfsUrl
+	pid := util.RandomBytes(types.ProcessIDsize)
+	err := app.State.AddProcess(&models.Process{
+		ProcessId:    pid,
+		StartBlock:   0,
+		EnvelopeType: &models.EnvelopeType{},
+		Mode:         &models.ProcessMode{AutoStart: true},
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 3, MaxValue: 3},
+		Status:       models.ProcessStatus_READY,
+		EntityId:     util.RandomBytes(types.EthereumAddressSize),
+		CensusRoot:   root,
+		CensusURI:    &censusURI,
+		CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+		BlockCount:   1024,
+	})
+	qt.Assert(t, err, qt.IsNil)
+	app.AdvanceTestBlock()
+
+	for i := range keys {
+		stx := testBuildSignedVote(t, pid, keys[i], proofs[i], []int{1, 2, 3}, app.ChainID())
+		txBytes, err := proto.Marshal(stx)
+		qt.Assert(t, err, qt.IsNil)
+		resp, err := app.SendTx(txBytes)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, resp.Code, qt.Equals, uint32(0), qt.Commentf("%s", resp.Data))
+	}
+	app.AdvanceTestBlock()
+
+	blockTime := time.Now().Add(-time.Hour).Unix()
+	app.State.SetTimestamp(blockTime)
+	_, err = app.State.Snapshot()
+	qt.Assert(t, err, qt.IsNil)
+	appHash, err := app.State.Store.Hash()
+	qt.Assert(t, err, qt.IsNil)
+
+	snapshots := app.ListSnapshots(abcitypes.RequestListSnapshots{}).Snapshots
+	qt.Assert(t, snapshots, qt.HasLen, 1)
+	snapshot := snapshots[0]
+
+	// sync a second app from the snapshot of the first one
+	app2 := TestBaseApplication(t)
+	offer := app2.OfferSnapshot(abcitypes.RequestOfferSnapshot{Snapshot: snapshot, AppHash: appHash})
+	qt.Assert(t, offer.Result, qt.Equals, abcitypes.ResponseOfferSnapshot_ACCEPT)
+
+	for i := uint32(0); i < snapshot.Chunks; i++ {
+		chunk := app.LoadSnapshotChunk(abcitypes.RequestLoadSnapshotChunk{
+			Height: snapshot.Height,
+			Format: snapshot.Format,
+			Chunk:  i,
+		}).Chunk
+		qt.Assert(t, chunk, qt.Not(qt.HasLen), 0)
+
+		// a tampered chunk must be refetched
+		tampered := append([]byte{}, chunk...)
+		tampered[0]++
+		resp := app2.ApplySnapshotChunk(abcitypes.RequestApplySnapshotChunk{
+			Index: i, Chunk: tampered, Sender: "evil",
+		})
+		qt.Assert(t, resp.Result, qt.Equals, abcitypes.ResponseApplySnapshotChunk_RETRY)
+		qt.Assert(t, resp.RejectSenders, qt.DeepEquals, []string{"evil"})
+
+		resp = app2.ApplySnapshotChunk(abcitypes.RequestApplySnapshotChunk{
+			Index: i, Chunk: chunk, Sender: "good",
+		})
+		qt.Assert(t, resp.Result, qt.Equals, abcitypes.ResponseApplySnapshotChunk_ACCEPT)
+	}
+
+	// the synced state must match the original one
+	appHash2, err := app2.State.Store.Hash()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, appHash2, qt.DeepEquals, appHash)
+	height2, err := app2.State.LastHeight()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, uint64(height2), qt.Equals, snapshot.Height)
+	qt.Assert(t, app2.Height(), qt.Equals, height2)
+	// the block time is the one of the snapshot block
+	qt.Assert(t, app2.Timestamp(), qt.Equals, blockTime)
+
+	process, err := app2.State.Process(pid, true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, process.CensusRoot, qt.DeepEquals, []byte(root))
+	qt.Assert(t, app2.State.CountVotes(pid, true), qt.Equals, uint32(len(keys)))
+	voteCount, err := app2.State.VoteCount(true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, voteCount, qt.Equals, uint64(len(keys)))
+
+	// the synced app must be able to keep committing blocks
+	app2.State.SetHeight(height2 + 1)
+	qt.Assert(t, app2.Commit().Data, qt.DeepEquals, appHash)
+	height3, err := app2.State.LastHeight()
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, height3, qt.Equals, height2+1)
+}
+
+func TestStateSyncSnapshotWrongAppHash(t *testing.T) {
+	app := TestBaseApplication(t)
+	app.AdvanceTestBlock()
+	_, err := app.State.Snapshot()
+	qt.Assert(t, err, qt.IsNil)
+	snapshot := app.ListSnapshots(abcitypes.RequestListSnapshots{}).Snapshots[0]
+
+	app2 := TestBaseApplication(t)
+	offer := app2.OfferSnapshot(abcitypes.RequestOfferSnapshot{
+		Snapshot: snapshot,
+		AppHash:  util.RandomBytes(32),
+	})
+	qt.Assert(t, offer.Result, qt.Equals, abcitypes.ResponseOfferSnapshot_ACCEPT)
+	var resp abcitypes.ResponseApplySnapshotChunk
+	for i := uint32(0); i < snapshot.Chunks; i++ {
+		resp = app2.ApplySnapshotChunk(abcitypes.RequestApplySnapshotChunk{
+			Index: i,
+			Chunk: app.LoadSnapshotChunk(abcitypes.RequestLoadSnapshotChunk{
+				Height: snapshot.Height, Format: snapshot.Format, Chunk: i,
+			}).Chunk,
+		})
+	}
+	qt.Assert(t, resp.Result, qt.Equals, abcitypes.ResponseApplySnapshotChunk_REJECT_SNAPSHOT)
+}
+
+func TestStateSyncSnapshotCommit(t *testing.T) {
+	app := TestBaseApplication(t)
+	addProcess := func() {
+		qt.Assert(t, app.State.AddProcess(&models.Process{
+			ProcessId:    util.RandomBytes(types.ProcessIDsize),
+			EnvelopeType: &models.EnvelopeType{},
+			Mode:         &models.ProcessMode{AutoStart: true},
+			VoteOptions:  &models.ProcessVoteOptions{MaxCount: 1, MaxValue: 1},
+			Status:       models.ProcessStatus_READY,
+			EntityId:     util.RandomBytes(types.EthereumAddressSize),
+			CensusRoot:   util.RandomBytes(32),
+			CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+			BlockCount:   1024,
+		}), qt.IsNil)
+	}
+	addProcess()
+	app.AdvanceTestBlock()
+	height, err := app.State.LastHeight()
+	qt.Assert(t, err, qt.IsNil)
+	appHash, err := app.State.Store.Hash()
+	qt.Assert(t, err, qt.IsNil)
+
+	// the blocks committed while the snapshot is taken are not included
+	app.snapshot(height, 1234)
+	addProcess()
+	app.AdvanceTestBlock()
+	for app.snapshotting.Load() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	snapshots := app.State.ListSnapshots()
+	qt.Assert(t, snapshots, qt.HasLen, 1)
+	qt.Assert(t, snapshots[0].Height, qt.Equals, height)
+	var snap vstate.StateSnapshot
+	qt.Assert(t, snap.Open(snapshots[0].Path), qt.IsNil)
+	defer snap.Close()
+	qt.Assert(t, snap.Header().Root, qt.DeepEquals, appHash)
+	qt.Assert(t, snap.Header().Timestamp, qt.Equals, int64(1234))
+}
+
+func TestStateSyncSnapshotPrune(t *testing.T) {
+	app := TestBaseApplication(t)
+	app.snapshotKeep = 2
+	var heights []uint32
+	for i := 0; i < 4; i++ {
+		app.AdvanceTestBlock()
+		height, err := app.State.LastHeight()
+		qt.Assert(t, err, qt.IsNil)
+		heights = append(heights, height)
+		app.snapshot(height, 1234)
+		for app.snapshotting.Load() {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// only the two most recent snapshots are kept
+	snapshots := app.State.ListSnapshots()
+	qt.Assert(t, snapshots, qt.HasLen, 2)
+	var kept []uint32
+	for _, s := range snapshots {
+		kept = append(kept, s.Height)
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i] < kept[j] })
+	qt.Assert(t, kept, qt.DeepEquals, heights[2:])
+}
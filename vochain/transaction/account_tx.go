@@ -193,6 +193,9 @@ func (t *TransactionHandler) SetAccountInfoTxCheck(vtx *vochaintx.VochainTx) err
 	if txSenderAccount == nil {
 		return vstate.ErrAccountNotExist
 	}
+	if err := t.state.CheckNotRotated(txSenderAddress); err != nil {
+		return err
+	}
 	// check txSender nonce
 	if tx.GetNonce() != txSenderAccount.Nonce {
 		return fmt.Errorf(
@@ -239,3 +242,141 @@ func (t *TransactionHandler) SetAccountInfoTxCheck(vtx *vochaintx.VochainTx) err
 	}
 	return nil
 }
+
+// SetAccountMultisigTxCheck checks if a set account multisig tx is valid and
+// returns the new policy of the tx sender account, which is nil if the policy
+// is removed.  Setting the policy costs the same as setting the account info.
+// If the account already has a policy, changing it requires its approval.
+func (t *TransactionHandler) SetAccountMultisigTxCheck(
+	vtx *vochaintx.VochainTx) (*vstate.MultisigPolicy, common.Address, error) {
+	if vtx == nil || vtx.Signature == nil || vtx.SignedBody == nil || vtx.Tx == nil {
+		return nil, common.Address{}, ErrNilTx
+	}
+	tx := vtx.Tx.GetSetAccount()
+	if tx == nil || tx.Txtype != models.TxType_SET_ACCOUNT_MULTISIG {
+		return nil, common.Address{}, fmt.Errorf("invalid tx")
+	}
+	if tx.Nonce == nil {
+		return nil, common.Address{}, fmt.Errorf("invalid nonce")
+	}
+	txSenderAddress, txSenderAccount, err := t.state.AccountFromSignature(
+		vtx.SignedBody, vtx.Signature)
+	if err != nil {
+		return nil, common.Address{}, err
+	}
+	if tx.GetNonce() != txSenderAccount.Nonce {
+		return nil, common.Address{}, fmt.Errorf("invalid nonce, expected %d got %d",
+			txSenderAccount.Nonce, tx.GetNonce())
+	}
+	cost, err := t.state.TxCost(models.TxType_SET_ACCOUNT_MULTISIG, false)
+	if err != nil {
+		return nil, common.Address{}, fmt.Errorf("cannot get tx cost: %w", err)
+	}
+	if txSenderAccount.Balance < cost {
+		return nil, common.Address{}, vstate.ErrNotEnoughBalance
+	}
+	var policy *vstate.MultisigPolicy
+	threshold := tx.GetThreshold()
+	if threshold > 0 || len(tx.Delegates) > 0 {
+		policy = &vstate.MultisigPolicy{Threshold: threshold}
+		for _, signer := range tx.Delegates {
+			if len(signer) != common.AddressLength {
+				return nil, common.Address{}, fmt.Errorf("invalid signer address %x", signer)
+			}
+			policy.Signers = append(policy.Signers, common.BytesToAddress(signer))
+		}
+		if err := policy.Check(); err != nil {
+			return nil, common.Address{}, err
+		}
+	}
+	if err := t.checkMultisig(vtx, *txSenderAddress); err != nil {
+		return nil, common.Address{}, err
+	}
+	return policy, *txSenderAddress, nil
+}
+
+// checkMultisig checks that the transaction is approved by the multisig
+// policy of the account, if it has one.
+func (t *TransactionHandler) checkMultisig(vtx *vochaintx.VochainTx, account common.Address) error {
+	policy, err := t.state.MultisigPolicy(account, false)
+	if err != nil {
+		return fmt.Errorf("cannot get multisig policy: %w", err)
+	}
+	if policy == nil {
+		return nil
+	}
+	signers, err := vtx.Signers()
+	if err != nil {
+		return err
+	}
+	if approvals, ok := policy.Approved(signers); !ok {
+		return fmt.Errorf("account %s requires %d multisig approvals, got %d",
+			account.Hex(), policy.Threshold, approvals)
+	}
+	return nil
+}
+
+// RotateAccountTxCheck checks if a rotate account tx is valid and returns the
+// current and the new address of the tx sender account.  The new address is
+// the tx Account field, and it must co-sign the transaction to prove it is
+// controlled by the new key.  Rotating costs the same as setting the account
+// info, and it requires the approval of the multisig policy, if any.
+func (t *TransactionHandler) RotateAccountTxCheck(
+	vtx *vochaintx.VochainTx) (common.Address, common.Address, error) {
+	if vtx == nil || vtx.Signature == nil || vtx.SignedBody == nil || vtx.Tx == nil {
+		return common.Address{}, common.Address{}, ErrNilTx
+	}
+	tx := vtx.Tx.GetSetAccount()
+	if tx == nil || tx.Txtype != models.TxType_ROTATE_ACCOUNT {
+		return common.Address{}, common.Address{}, fmt.Errorf("invalid tx")
+	}
+	if tx.Nonce == nil {
+		return common.Address{}, common.Address{}, fmt.Errorf("invalid nonce")
+	}
+	if len(tx.Account) != common.AddressLength {
+		return common.Address{}, common.Address{}, fmt.Errorf("invalid new address")
+	}
+	txSenderAddress, txSenderAccount, err := t.state.AccountFromSignature(
+		vtx.SignedBody, vtx.Signature)
+	if err != nil {
+		return common.Address{}, common.Address{}, err
+	}
+	if tx.GetNonce() != txSenderAccount.Nonce {
+		return common.Address{}, common.Address{}, fmt.Errorf(
+			"invalid nonce, expected %d got %d", txSenderAccount.Nonce, tx.GetNonce())
+	}
+	cost, err := t.state.TxCost(models.TxType_ROTATE_ACCOUNT, false)
+	if err != nil {
+		return common.Address{}, common.Address{}, fmt.Errorf("cannot get tx cost: %w", err)
+	}
+	if txSenderAccount.Balance < cost {
+		return common.Address{}, common.Address{}, vstate.ErrNotEnoughBalance
+	}
+	newAddress := common.BytesToAddress(tx.Account)
+	newAccount, err := t.state.GetAccount(newAddress, false)
+	if err != nil {
+		return common.Address{}, common.Address{}, fmt.Errorf("cannot get new account: %w", err)
+	}
+	if newAccount != nil {
+		return common.Address{}, common.Address{}, fmt.Errorf("%w: %s",
+			vstate.ErrAccountAlreadyExists, newAddress.Hex())
+	}
+	signers, err := vtx.Signers()
+	if err != nil {
+		return common.Address{}, common.Address{}, err
+	}
+	signed := false
+	for _, signer := range signers[1:] {
+		if signer == newAddress {
+			signed = true
+		}
+	}
+	if !signed {
+		return common.Address{}, common.Address{}, fmt.Errorf(
+			"the new address %s must co-sign the transaction", newAddress.Hex())
+	}
+	if err := t.checkMultisig(vtx, *txSenderAddress); err != nil {
+		return common.Address{}, common.Address{}, err
+	}
+	return *txSenderAddress, newAddress, nil
+}
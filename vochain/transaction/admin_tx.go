@@ -110,12 +110,26 @@ func (t *TransactionHandler) AdminTxCheck(vtx *vochaintx.VochainTx) (common.Addr
 			if err := checkAddProcessKeys(tx, process); err != nil {
 				return common.Address{}, err
 			}
+			// each keykeeper adds a single key, whose index is the coordinate
+			// of the shares it reveals of the other keys
+			index, err := t.state.ProcessKeyKeeperIndex(tx.ProcessId, addr, false)
+			if err != nil {
+				return common.Address{}, err
+			}
+			if index != 0 {
+				return common.Address{}, fmt.Errorf("keykeeper %s already added the keys %d",
+					addr.Hex(), index)
+			}
 		case models.TxType_REVEAL_PROCESS_KEYS:
 			if tx.KeyIndex == nil {
 				return common.Address{}, fmt.Errorf("missing keyIndex on AdminTxCheck")
 			}
 			// check process is finished
-			if height < process.StartBlock+process.BlockCount &&
+			finished := height >= process.StartBlock+process.BlockCount
+			if _, _, ok := vstate.ProcessTimeBounds(process.Mode); ok {
+				finished = vstate.ProcessEndedAt(process, t.state.CurrentTimestamp())
+			}
+			if !finished &&
 				!(process.Status == models.ProcessStatus_ENDED ||
 					process.Status == models.ProcessStatus_CANCELED) {
 				return common.Address{}, fmt.Errorf("cannot reveal keys before the process is finished")
@@ -127,6 +141,22 @@ func (t *TransactionHandler) AdminTxCheck(vtx *vochaintx.VochainTx) (common.Addr
 			if err := checkRevealProcessKeys(tx, process); err != nil {
 				return common.Address{}, err
 			}
+			if tx.EncryptionKeyShare != nil {
+				if err := t.checkProcessKeyShare(tx, addr); err != nil {
+					return common.Address{}, err
+				}
+			}
+			// verify the partial decryption of homomorphic processes
+			if process.EnvelopeType.GetHomomorphic() {
+				tally, err := t.homomorphicTally(process)
+				if err != nil {
+					return common.Address{}, err
+				}
+				if err := tally.VerifyPartialDecryption(process, int(tx.GetKeyIndex()),
+					tx.EncryptionPrivateKey); err != nil {
+					return common.Address{}, err
+				}
+			}
 		}
 	case models.TxType_ADD_ORACLE:
 		err := t.state.VerifyTreasurer(addr, tx.Nonce)
@@ -0,0 +1,69 @@
+package transaction
+
+import (
+	"fmt"
+
+	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// BatchTxCheck checks a batch transaction and, if forCommit is true, executes
+// its sub-transactions in order.  If any of them fails, the changes made by
+// the previous ones are discarded and the whole batch fails.
+//
+// Since the checks of a sub-transaction depend on the changes made by the
+// previous ones, the mempool check also executes all of them, on a savepoint
+// which is always discarded.  So a batch is only accepted in the mempool if
+// every sub-transaction would succeed, and it cannot take block space without
+// paying for it.  The response data is the one of the last sub-transaction.
+func (t *TransactionHandler) BatchTxCheck(vtx *vochaintx.VochainTx,
+	forCommit bool) (*TransactionResponse, error) {
+	if vtx.Signature == nil || vtx.SignedBody == nil || vtx.Tx == nil {
+		return nil, ErrNilTx
+	}
+	txs, err := vochaintx.BatchTxs(vtx.Tx)
+	if err != nil {
+		return nil, err
+	}
+	subTxs := make([]*vochaintx.VochainTx, len(txs))
+	for i, tx := range txs {
+		if !batchTxAllowed(tx) {
+			return nil, fmt.Errorf("batch tx %d: transaction type not allowed in a batch", i)
+		}
+		subTxs[i] = vtx.BatchSubTx(tx)
+	}
+
+	if err := t.state.Savepoint(); err != nil {
+		return nil, err
+	}
+	var response *TransactionResponse
+	for i, subTx := range subTxs {
+		if response, err = t.CheckTx(subTx, true); err != nil {
+			if rerr := t.state.RollbackSavepoint(); rerr != nil {
+				return nil, fmt.Errorf("batch tx %d: %v: cannot rollback: %w", i, err, rerr)
+			}
+			return nil, fmt.Errorf("batch tx %d: %w", i, err)
+		}
+	}
+	if !forCommit {
+		if err := t.state.RollbackSavepoint(); err != nil {
+			return nil, err
+		}
+		return response, nil
+	}
+	if err := t.state.ReleaseSavepoint(); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// batchTxAllowed returns whether the transaction can be part of a batch.
+// Votes, admin transactions and nested batches are not allowed.
+func batchTxAllowed(tx *models.Tx) bool {
+	switch tx.Payload.(type) {
+	case *models.Tx_SetAccount, *models.Tx_NewProcess, *models.Tx_SetProcess,
+		*models.Tx_SendTokens:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package transaction
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// VoteDelegationTxCheck checks a vote delegation transaction, which is a vote
+// envelope without vote package carrying the address of the delegate.  Both
+// the delegator and the delegate must prove they belong to the census, and the
+// delegation cannot close a cycle.  Delegations are not supported by anonymous
+// elections, since the voters are unknown.
+func (t *TransactionHandler) VoteDelegationTxCheck(
+	vtx *vochaintx.VochainTx) (*vochaintx.VoteDelegation, error) {
+	voteEnvelope := vtx.Tx.GetVote()
+	if voteEnvelope == nil {
+		return nil, fmt.Errorf("vote envelope is nil")
+	}
+	delegate, err := vochaintx.VoteDelegate(voteEnvelope)
+	if err != nil {
+		return nil, err
+	}
+	if delegate == nil {
+		return nil, fmt.Errorf("vote delegate not found")
+	}
+	if len(voteEnvelope.VotePackage) > 0 {
+		return nil, fmt.Errorf("vote delegation cannot include a vote package")
+	}
+	process, err := t.checkVoteProcess(voteEnvelope.ProcessId)
+	if err != nil {
+		return nil, err
+	}
+	if process.EnvelopeType.Anonymous {
+		return nil, fmt.Errorf("vote delegation not supported by anonymous elections")
+	}
+	if process.EnvelopeType.GetHomomorphic() {
+		return nil, fmt.Errorf("vote delegation not supported by homomorphic elections")
+	}
+	if vtx.Signature == nil {
+		return nil, fmt.Errorf("signature missing on voteTx")
+	}
+	if voteEnvelope.Proof == nil || voteEnvelope.Proof.Payload == nil {
+		return nil, fmt.Errorf("proof not found on transaction")
+	}
+	pubKey, err := ethereum.PubKeyFromSignature(vtx.SignedBody, vtx.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("cannot extract public key from signature: %w", err)
+	}
+	addr, err := ethereum.AddrFromPublicKey(pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot extract address from public key: %w", err)
+	}
+	if err := t.state.CheckVoteDelegation(process.ProcessId, addr, *delegate); err != nil {
+		return nil, err
+	}
+	valid, weight, err := VerifyProof(process, voteEnvelope.Proof,
+		process.CensusOrigin, process.CensusRoot, process.ProcessId,
+		pubKey, addr)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, fmt.Errorf("proof not valid")
+	}
+	if err := VerifyVoteDelegate(process, voteEnvelope, *delegate); err != nil {
+		return nil, err
+	}
+	log.Infow("new vote delegation", map[string]interface{}{
+		"address":    addr.Hex(),
+		"delegate":   delegate.Hex(),
+		"electionID": fmt.Sprintf("%x", voteEnvelope.ProcessId),
+	})
+	return &vochaintx.VoteDelegation{
+		ProcessID: process.ProcessId,
+		Delegator: addr,
+		Delegate:  *delegate,
+		Weight:    weight,
+	}, nil
+}
+
+// VerifyVoteDelegate checks the delegate of a vote delegation belongs to the
+// census of the process, using the delegate proof of the envelope.
+func VerifyVoteDelegate(process *models.Process, voteEnvelope *models.VoteEnvelope,
+	delegate common.Address) error {
+	if voteEnvelope.DelegateProof == nil || voteEnvelope.DelegateProof.Payload == nil {
+		return fmt.Errorf("delegate proof not found on transaction")
+	}
+	pubKey := voteEnvelope.DelegatePublicKey
+	if len(pubKey) > 0 {
+		addr, err := ethereum.AddrFromPublicKey(pubKey)
+		if err != nil {
+			return fmt.Errorf("cannot extract address from delegate public key: %w", err)
+		}
+		if addr != delegate {
+			return fmt.Errorf("delegate public key does not match the delegate %s", delegate.Hex())
+		}
+	}
+	valid, _, err := VerifyProof(process, voteEnvelope.DelegateProof,
+		process.CensusOrigin, process.CensusRoot, process.ProcessId,
+		pubKey, delegate)
+	if err != nil {
+		return fmt.Errorf("delegate %w", err)
+	}
+	if !valid {
+		return fmt.Errorf("delegate %s is not a census member", delegate.Hex())
+	}
+	return nil
+}
@@ -8,10 +8,12 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"go.vocdoni.io/dvote/crypto/ethereum"
 	"go.vocdoni.io/dvote/crypto/nacl"
+	"go.vocdoni.io/dvote/crypto/shamir"
 	"go.vocdoni.io/dvote/crypto/zk/artifacts"
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/types"
 	vocdoniGenesis "go.vocdoni.io/dvote/vochain/genesis"
+	"go.vocdoni.io/dvote/vochain/homomorphic"
 	"go.vocdoni.io/dvote/vochain/processid"
 	vstate "go.vocdoni.io/dvote/vochain/state"
 	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
@@ -42,6 +44,12 @@ func (t *TransactionHandler) NewProcessTxCheck(vtx *vochaintx.VochainTx,
 	if vtx.Signature == nil || tx == nil || vtx.SignedBody == nil {
 		return nil, common.Address{}, fmt.Errorf("missing vtx.Signature or new process transaction")
 	}
+	// EVM storage census origins require a valid storage layout
+	if tx.Process.CensusOrigin == models.CensusOrigin_EVM_STORAGE {
+		if _, err := EVMStorageLayout(tx.Process); err != nil {
+			return nil, common.Address{}, fmt.Errorf("invalid census storage layout: %w", err)
+		}
+	}
 	// start and block count sanity check
 	// if startBlock is zero or one, the process will be enabled on the next block
 	if tx.Process.StartBlock == 0 || tx.Process.StartBlock == 1 {
@@ -54,6 +62,17 @@ func (t *TransactionHandler) NewProcessTxCheck(vtx *vochaintx.VochainTx,
 		return nil, common.Address{}, fmt.Errorf(
 			"cannot add process with duration lower than or equal to the current height")
 	}
+	// time-bounded processes must end after they start, and in the future
+	if start, end, ok := vstate.ProcessTimeBounds(tx.Process.Mode); ok {
+		if !start.IsZero() && !end.After(start) {
+			return nil, common.Address{}, fmt.Errorf(
+				"cannot add process with end time lower than or equal to the start time")
+		}
+		if vstate.ProcessEndedAt(tx.Process, t.state.CurrentTimestamp()) {
+			return nil, common.Address{}, fmt.Errorf(
+				"cannot add process with end time lower than or equal to the current time")
+		}
+	}
 	// check tx cost
 	cost, err := t.state.TxCost(models.TxType_NEW_PROCESS, false)
 	if err != nil {
@@ -78,6 +97,12 @@ func (t *TransactionHandler) NewProcessTxCheck(vtx *vochaintx.VochainTx,
 	if tx.Process.EntityId == nil {
 		tx.Process.EntityId = addr.Bytes()
 	}
+	// the elections of a rotated organization are created by its new address
+	if len(tx.Process.EntityId) == common.AddressLength {
+		if err := t.state.CheckNotRotated(common.BytesToAddress(tx.Process.EntityId)); err != nil {
+			return nil, common.Address{}, err
+		}
+	}
 
 	// check if the sender is an Oracle or a Delegate of the organization
 	isOracle, err := t.state.IsOracle(*addr)
@@ -103,6 +128,11 @@ func (t *TransactionHandler) NewProcessTxCheck(vtx *vochaintx.VochainTx,
 				"account %s unauthorized to create a new election on this organization", addr.Hex())
 		}
 	}
+	if !isOracle {
+		if err := t.checkMultisig(vtx, common.BytesToAddress(tx.Process.EntityId)); err != nil {
+			return nil, common.Address{}, err
+		}
+	}
 
 	// if no Oracle, build the processID (Oracles are allowed to use any processID)
 	if !isOracle || tx.Process.ProcessId == nil {
@@ -167,6 +197,16 @@ func (t *TransactionHandler) NewProcessTxCheck(vtx *vochaintx.VochainTx,
 		return nil, common.Address{}, fmt.Errorf("serial process not yet implemented")
 	}
 
+	if tx.Process.EnvelopeType.GetHomomorphic() {
+		if err := homomorphic.CheckProcess(tx.Process); err != nil {
+			return nil, common.Address{}, err
+		}
+	}
+
+	if err := checkRankedChoice(tx.Process); err != nil {
+		return nil, common.Address{}, err
+	}
+
 	if tx.Process.EnvelopeType.EncryptedVotes {
 		// We consider the zero value as nil for security
 		tx.Process.EncryptionPublicKeys = make([]string, types.KeyKeeperMaxKeyIndex)
@@ -175,6 +215,36 @@ func (t *TransactionHandler) NewProcessTxCheck(vtx *vochaintx.VochainTx,
 	return tx.Process, *addr, nil
 }
 
+// checkRankedChoice checks the ballot options of a ranked choice process. A
+// ranked ballot has one field per preference position holding the candidate
+// ranked in that position, so values cannot repeat and there cannot be more
+// positions than candidates.
+func checkRankedChoice(process *models.Process) error {
+	opts := process.GetVoteOptions()
+	if !process.EnvelopeType.GetRankedChoice() {
+		if opts.GetSeats() > 0 {
+			return fmt.Errorf("seats are only supported on ranked choice elections")
+		}
+		return nil
+	}
+	if !process.EnvelopeType.GetUniqueValues() {
+		return fmt.Errorf("ranked choice elections require unique values")
+	}
+	if process.EnvelopeType.GetCostFromWeight() || process.EnvelopeType.GetHomomorphic() {
+		return fmt.Errorf("ranked choice elections cannot use cost from weight nor homomorphic tally")
+	}
+	candidates := opts.GetMaxValue() + 1
+	if opts.GetMaxCount() > candidates {
+		return fmt.Errorf("ranked choice maxCount (%d) is bigger than the number of candidates (%d)",
+			opts.GetMaxCount(), candidates)
+	}
+	if opts.GetSeats() > candidates {
+		return fmt.Errorf("ranked choice seats (%d) is bigger than the number of candidates (%d)",
+			opts.GetSeats(), candidates)
+	}
+	return nil
+}
+
 // SetProcessTxCheck is an abstraction of ABCI checkTx for canceling an existing process
 func (t *TransactionHandler) SetProcessTxCheck(vtx *vochaintx.VochainTx, forCommit bool) (common.Address, error) {
 	// check vtx.Signature available
@@ -203,9 +273,14 @@ func (t *TransactionHandler) SetProcessTxCheck(vtx *vochaintx.VochainTx, forComm
 	if err != nil {
 		return common.Address{}, fmt.Errorf("cannot get process %x: %w", tx.ProcessId, err)
 	}
+	// the organization may have rotated its account to a new address
+	entityID, err := t.processOwner(process)
+	if err != nil {
+		return common.Address{}, err
+	}
 	// check process entityID matches tx sender
 	isOracle := false
-	if !bytes.Equal(process.EntityId, addr.Bytes()) {
+	if !bytes.Equal(entityID, addr.Bytes()) {
 		// Check if the transaction comes from an oracle
 		// Oracles can create processes with any entityID
 		isOracle, err = t.state.IsOracle(*addr)
@@ -214,7 +289,7 @@ func (t *TransactionHandler) SetProcessTxCheck(vtx *vochaintx.VochainTx, forComm
 		}
 		if !isOracle {
 			// check if delegate
-			entityIDAddress := common.BytesToAddress(process.EntityId)
+			entityIDAddress := common.BytesToAddress(entityID)
 			entityIDAccount, err := t.state.GetAccount(entityIDAddress, true)
 			if err != nil {
 				return common.Address{}, fmt.Errorf(
@@ -228,6 +303,11 @@ func (t *TransactionHandler) SetProcessTxCheck(vtx *vochaintx.VochainTx, forComm
 			} // is delegate
 		} // is oracle
 	}
+	if !isOracle {
+		if err := t.checkMultisig(vtx, common.BytesToAddress(entityID)); err != nil {
+			return common.Address{}, err
+		}
+	}
 	switch tx.Txtype {
 	case models.TxType_SET_PROCESS_RESULTS:
 		if !isOracle {
@@ -253,6 +333,20 @@ func (t *TransactionHandler) SetProcessTxCheck(vtx *vochaintx.VochainTx, forComm
 	}
 }
 
+// processOwner returns the current address of the organization of the
+// process, which differs from the process EntityId if the organization rotated
+// its account.
+func (t *TransactionHandler) processOwner(process *models.Process) ([]byte, error) {
+	if len(process.EntityId) != common.AddressLength {
+		return process.EntityId, nil
+	}
+	owner, err := t.state.ResolveAccount(common.BytesToAddress(process.EntityId), false)
+	if err != nil {
+		return nil, err
+	}
+	return owner.Bytes(), nil
+}
+
 // RegisterKeyTxCheck validates a registerKeyTx transaction against the state
 func (t *TransactionHandler) RegisterKeyTxCheck(vtx *vochaintx.VochainTx, forCommit bool) error {
 	if vtx.Signature == nil || vtx.Tx == nil || vtx.SignedBody == nil {
@@ -380,6 +474,26 @@ func checkAddProcessKeys(tx *models.AdminTx, process *models.Process) error {
 	if len(process.EncryptionPublicKeys[tx.GetKeyIndex()]) > 0 {
 		return fmt.Errorf("key index %d already exists", tx.KeyIndex)
 	}
+	// homomorphic elections use ElGamal keys, which must be valid curve points
+	// and include the proof of their private key, since they are added to
+	// the joint key of the election
+	if process.EnvelopeType.GetHomomorphic() {
+		if err := homomorphic.VerifyKey(process.ProcessId, int(tx.GetKeyIndex()),
+			tx.EncryptionPublicKey, tx.EncryptionKeyProof); err != nil {
+			return fmt.Errorf("invalid homomorphic encryption key: %w", err)
+		}
+	}
+	// the key shared in threshold mode needs less shares than keykeepers,
+	// so that it can be reconstructed without its owner
+	if tx.KeyShareCommitments != nil {
+		threshold, err := shamir.Threshold(tx.KeyShareCommitments)
+		if err != nil {
+			return fmt.Errorf("invalid key share commitments: %w", err)
+		}
+		if threshold >= types.KeyKeeperMaxKeyIndex {
+			return fmt.Errorf("invalid key share threshold %d", threshold)
+		}
+	}
 	// TBD check that provided keys are correct (ed25519 for encryption and size for Commitment)
 	return nil
 }
@@ -395,14 +509,39 @@ func checkRevealProcessKeys(tx *models.AdminTx, process *models.Process) error {
 		return fmt.Errorf("key index is nil")
 	}
 	// check if at leat 1 key is provided and the keyIndex do not over/under flow
-	if (tx.EncryptionPrivateKey == nil) ||
+	if (tx.EncryptionPrivateKey == nil && tx.EncryptionKeyShare == nil) ||
 		tx.GetKeyIndex() < 1 || tx.GetKeyIndex() > types.KeyKeeperMaxKeyIndex {
 		return fmt.Errorf("no keys provided or invalid key index")
 	}
+	if tx.EncryptionPrivateKey != nil && tx.EncryptionKeyShare != nil {
+		return fmt.Errorf("cannot reveal a key and a key share at once")
+	}
 	// check if provided keyIndex exists
 	if len(process.EncryptionPublicKeys[tx.GetKeyIndex()]) < 1 {
 		return fmt.Errorf("key index %d does not exist", tx.GetKeyIndex())
 	}
+	// the keykeepers of homomorphic elections reveal the partial decryption of
+	// the results instead of the key, whose proofs are verified by AdminTxCheck
+	if process.EnvelopeType.GetHomomorphic() {
+		if tx.EncryptionKeyShare != nil {
+			return fmt.Errorf("the keys of homomorphic elections cannot be shared")
+		}
+		size := homomorphic.Cells(process.VoteOptions) * homomorphic.PartialDecryptionSize
+		if len(tx.EncryptionPrivateKey) != size {
+			return fmt.Errorf("invalid partial decryption size")
+		}
+		return nil
+	}
+	// the shares of the key are verified against its commitments
+	if tx.EncryptionKeyShare != nil {
+		if len(tx.EncryptionKeyShare) != vstate.ProcessKeyShareSize {
+			return fmt.Errorf("invalid key share size")
+		}
+		if x := tx.EncryptionKeyShare[0]; x < 1 || x > types.KeyKeeperMaxKeyIndex {
+			return fmt.Errorf("invalid key share coordinate")
+		}
+		return nil
+	}
 	// check keys actually work
 	if tx.EncryptionPrivateKey != nil {
 		if priv, err := nacl.DecodePrivate(fmt.Sprintf("%x", tx.EncryptionPrivateKey)); err == nil {
@@ -418,3 +557,58 @@ func checkRevealProcessKeys(tx *models.AdminTx, process *models.Process) error {
 	}
 	return nil
 }
+
+// checkProcessKeyShare checks that a share of a process key is revealed by the
+// keykeeper of its coordinate, the one which added the key with that index,
+// and that it matches the commitments of the key and can be added to the
+// known shares, see state.AddProcessKeyShare.
+func (t *TransactionHandler) checkProcessKeyShare(tx *models.AdminTx, addr common.Address) error {
+	x := uint32(tx.EncryptionKeyShare[0])
+	if x == tx.GetKeyIndex() {
+		return fmt.Errorf("the owner of key %d cannot reveal a share of it", x)
+	}
+	keykeeper, err := t.state.ProcessKeyKeeper(tx.ProcessId, x, false)
+	if err != nil {
+		return err
+	}
+	if keykeeper == nil || *keykeeper != addr {
+		return fmt.Errorf("key share %d can only be revealed by the keykeeper %d", x, x)
+	}
+	commitments, err := t.state.ProcessKeyShareCommitments(tx.ProcessId, tx.GetKeyIndex(), false)
+	if err != nil {
+		return err
+	}
+	shares, err := t.state.ProcessKeyShares(tx.ProcessId, tx.GetKeyIndex(), false)
+	if err != nil {
+		return err
+	}
+	_, err = vstate.AddProcessKeyShare(shares, tx.EncryptionKeyShare, commitments)
+	return err
+}
+
+// homomorphicTally returns the encrypted results of a finished homomorphic
+// process, the sum of its ballots stored on the state.  Since no more votes are
+// accepted once the process is finished, the tally is cached.
+func (t *TransactionHandler) homomorphicTally(process *models.Process) (*homomorphic.Tally, error) {
+	var err error
+	tally := t.tallies.GetAndUpdate(string(process.ProcessId), func(prev interface{}) interface{} {
+		if prev != nil {
+			return prev
+		}
+		tally := homomorphic.NewTally(process.VoteOptions)
+		if err = t.state.IterateVotes(process.ProcessId, false,
+			func(vote *models.StateDBVote) bool {
+				if err := tally.AddVote(vote); err != nil {
+					log.Warnf("cannot add vote %x to the tally: (%s)", vote.Nullifier, err)
+				}
+				return false
+			}); err != nil {
+			return nil
+		}
+		return tally
+	})
+	if tally == nil {
+		return nil, fmt.Errorf("cannot compute the homomorphic tally: %w", err)
+	}
+	return tally.(*homomorphic.Tally), nil
+}
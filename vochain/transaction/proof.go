@@ -6,6 +6,7 @@ import (
 	"math/big"
 
 	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/crypto/ethereum/storagelayout"
 	"go.vocdoni.io/dvote/crypto/saltedkey"
 	"go.vocdoni.io/dvote/log"
 	"go.vocdoni.io/dvote/tree"
@@ -56,6 +57,8 @@ func VerifyProof(process *models.Process, proof *models.Proof,
 		verifyProof = VerifyProofERC20
 	case models.CensusOrigin_MINI_ME:
 		verifyProof = VerifyProofMiniMe
+	case models.CensusOrigin_EVM_STORAGE:
+		verifyProof = VerifyProofEVMStorage
 	default:
 		return false, nil, fmt.Errorf("census origin not compatible")
 	}
@@ -272,3 +275,42 @@ func VerifyProofMiniMe(process *models.Process, proof *models.Proof,
 		new(big.Int).SetUint64(*process.SourceBlockHeight))
 	return err == nil, proof0Balance, err
 }
+
+// EVMStorageLayout returns the storage layout of the census weights of a
+// process with the EVM storage census origin.
+func EVMStorageLayout(process *models.Process) (*storagelayout.Layout, error) {
+	if process.StorageLayout == nil {
+		return nil, fmt.Errorf("storage layout not found for process %x", process.ProcessId)
+	}
+	return storagelayout.FromProto(process.StorageLayout)
+}
+
+// VerifyProofEVMStorage verifies a proof with an EVM storage census origin,
+// using the storage layout of the process to find the holder weight.
+// Returns verification result and weight.
+func VerifyProofEVMStorage(process *models.Process, proof *models.Proof,
+	censusOrigin models.CensusOrigin,
+	censusRoot, processID, pubKey []byte, addr ethcommon.Address) (bool, *big.Int, error) {
+	layout, err := EVMStorageLayout(process)
+	if err != nil {
+		return false, nil, err
+	}
+	p := proof.GetEthereumStorage()
+	if p == nil {
+		return false, nil, fmt.Errorf("ethereum proof is empty")
+	}
+	log.Debugf("validating evm storage proof for key %x", p.Key)
+	weight, err := layout.VerifyProof(addr, ethcommon.BytesToHash(censusRoot),
+		&ethstorageproof.StorageResult{
+			Key:   p.Key,
+			Proof: p.Siblings,
+			Value: p.Value,
+		})
+	if err != nil {
+		return false, nil, err
+	}
+	if weight.Cmp(bigZero) == 0 {
+		return false, nil, fmt.Errorf("weight at proof is 0")
+	}
+	return true, weight, nil
+}
@@ -141,6 +141,9 @@ func (t *TransactionHandler) SendTokensTxCheck(vtx *vochaintx.VochainTx) error {
 	if toTxAccount == nil {
 		return vstate.ErrAccountNotExist
 	}
+	if err := t.state.CheckNotRotated(txToAddress); err != nil {
+		return err
+	}
 	acc, err := t.state.GetAccount(txSenderAddress, false)
 	if err != nil {
 		return fmt.Errorf("cannot get from account: %w", err)
@@ -7,12 +7,16 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	snarkTypes "github.com/vocdoni/go-snark/types"
 	"go.vocdoni.io/dvote/crypto/ethereum"
+	"go.vocdoni.io/dvote/db/lru"
 	vstate "go.vocdoni.io/dvote/vochain/state"
 	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
 	models "go.vocdoni.io/proto/build/go/models"
 	"google.golang.org/protobuf/proto"
 )
 
+// talliesCacheSize is the number of homomorphic tallies kept in memory.
+const talliesCacheSize = 8
+
 var (
 	// ErrNilTx is returned if the transaction is nil.
 	ErrNilTx = fmt.Errorf("nil transaction")
@@ -38,6 +42,9 @@ type TransactionHandler struct {
 	dataDir string
 	// ZkVKs contains the VerificationKey for each circuit parameters index
 	ZkVKs []*snarkTypes.Vk
+	// tallies caches the encrypted results of the finished homomorphic
+	// processes, used to verify the partial decryptions of the keykeepers
+	tallies *lru.AtomicCache
 }
 
 // NewTransactionHandler creates a new TransactionHandler.
@@ -45,9 +52,22 @@ func NewTransactionHandler(state *vstate.State, dataDir string) (*TransactionHan
 	return &TransactionHandler{
 		state:   state,
 		dataDir: dataDir,
+		tallies: lru.NewAtomic(talliesCacheSize),
 	}, nil
 }
 
+// WithState returns a TransactionHandler checking the transactions against
+// state, with the zk verification keys of t.  It allows delivering the
+// transactions again on a fork of the state, see State.ForkAt.
+func (t *TransactionHandler) WithState(state *vstate.State) *TransactionHandler {
+	return &TransactionHandler{
+		state:   state,
+		dataDir: t.dataDir,
+		ZkVKs:   t.ZkVKs,
+		tallies: lru.NewAtomic(talliesCacheSize),
+	}
+}
+
 // LoadZkVerificationKeys loads or downloads the zk Circuits VerificationKey files.
 // It is required for veirying zkSnark proofs.
 func (t *TransactionHandler) LoadZkVerificationKeys() error {
@@ -63,9 +83,17 @@ func (t *TransactionHandler) LoadZkVerificationKeys() error {
 // CheckTx check the validity of a transaction and adds it to the state if forCommit=true.
 // It returns a bytes value which depends on the transaction type:
 //
-//	Tx_Vote: vote nullifier
+//	Tx_Vote: vote nullifier, the one of the delegator for vote delegations
+//	batch: the value of its last transaction
 //	default: []byte{}
 func (t *TransactionHandler) CheckTx(vtx *vochaintx.VochainTx, forCommit bool) (*TransactionResponse, error) {
+	if vtx.TxModelType == vochaintx.TxModelTypeBatch {
+		response, err := t.BatchTxCheck(vtx, forCommit)
+		if err != nil {
+			return nil, fmt.Errorf("batchTx: %w", err)
+		}
+		return response, nil
+	}
 	if vtx.Tx == nil || vtx.Tx.Payload == nil {
 		return nil, fmt.Errorf("transaction is empty")
 	}
@@ -74,6 +102,17 @@ func (t *TransactionHandler) CheckTx(vtx *vochaintx.VochainTx, forCommit bool) (
 	}
 	switch vtx.Tx.Payload.(type) {
 	case *models.Tx_Vote:
+		if delegate, err := vochaintx.VoteDelegate(vtx.Tx.GetVote()); err != nil || delegate != nil {
+			d, err := t.VoteDelegationTxCheck(vtx)
+			if err != nil {
+				return nil, fmt.Errorf("voteDelegationTx: %w", err)
+			}
+			response.Data = vstate.GenerateNullifier(d.Delegator, d.ProcessID)
+			if forCommit {
+				return response, t.state.AddVoteDelegation(d)
+			}
+			break
+		}
 		v, err := t.VoteTxCheck(vtx, forCommit)
 		if err != nil || v == nil {
 			return nil, fmt.Errorf("voteTx: %w", err)
@@ -84,7 +123,7 @@ func (t *TransactionHandler) CheckTx(vtx *vochaintx.VochainTx, forCommit bool) (
 		}
 
 	case *models.Tx_Admin:
-		_, err := t.AdminTxCheck(vtx)
+		addr, err := t.AdminTxCheck(vtx)
 		if err != nil {
 			return nil, fmt.Errorf("adminTx: %w", err)
 		}
@@ -106,6 +145,15 @@ func (t *TransactionHandler) CheckTx(vtx *vochaintx.VochainTx, forCommit bool) (
 				if err := t.state.AddProcessKeys(tx); err != nil {
 					return nil, fmt.Errorf("addProcessKeys: %w", err)
 				}
+				if err := t.state.SetProcessKeyKeeper(tx.ProcessId, tx.GetKeyIndex(), addr); err != nil {
+					return nil, fmt.Errorf("addProcessKeys: %w", err)
+				}
+				if tx.KeyShareCommitments != nil {
+					if err := t.state.SetProcessKeyShareCommitments(tx.ProcessId,
+						tx.GetKeyIndex(), tx.KeyShareCommitments); err != nil {
+						return nil, fmt.Errorf("addProcessKeys: %w", err)
+					}
+				}
 			// TODO: @jordipainan No cost applied, no nonce increased
 			case models.TxType_REVEAL_PROCESS_KEYS:
 				if err := t.state.RevealProcessKeys(tx); err != nil {
@@ -206,6 +254,38 @@ func (t *TransactionHandler) CheckTx(vtx *vochaintx.VochainTx, forCommit bool) (
 				return nil, fmt.Errorf("setAccountDelegateTx: %w", err)
 			}
 
+		case models.TxType_SET_ACCOUNT_MULTISIG:
+			policy, txSenderAddress, err := t.SetAccountMultisigTxCheck(vtx)
+			if err != nil {
+				return nil, fmt.Errorf("setAccountMultisigTx: %w", err)
+			}
+			if forCommit {
+				if err := t.state.BurnTxCostIncrementNonce(
+					txSenderAddress,
+					models.TxType_SET_ACCOUNT_MULTISIG,
+				); err != nil {
+					return nil, fmt.Errorf("setAccountMultisigTx: burnTxCostIncrementNonce %w", err)
+				}
+				return response, t.state.SetMultisigPolicy(txSenderAddress, policy)
+			}
+			return response, nil
+
+		case models.TxType_ROTATE_ACCOUNT:
+			from, to, err := t.RotateAccountTxCheck(vtx)
+			if err != nil {
+				return nil, fmt.Errorf("rotateAccountTx: %w", err)
+			}
+			if forCommit {
+				if err := t.state.BurnTxCostIncrementNonce(
+					from,
+					models.TxType_ROTATE_ACCOUNT,
+				); err != nil {
+					return nil, fmt.Errorf("rotateAccountTx: burnTxCostIncrementNonce %w", err)
+				}
+				return response, t.state.RotateAccount(from, to, vtx.TxID[:])
+			}
+			return response, nil
+
 		default:
 			return nil, fmt.Errorf("setAccount: invalid transaction type")
 		}
@@ -0,0 +1,70 @@
+package vochaintx
+
+import (
+	"fmt"
+
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// A batch transaction carries several sub-transactions from the same signer,
+// which are executed in order with all-or-nothing semantics.
+const (
+	// MaxBatchTxs is the maximum number of sub-transactions of a batch.
+	MaxBatchTxs = 16
+	// TxModelTypeBatch is the TxModelType of batch transactions.
+	TxModelTypeBatch = "batch"
+)
+
+// NewBatchTx returns a batch transaction with the given sub-transactions.
+// The batch is signed as any other transaction, and the signature applies
+// to all the sub-transactions.
+func NewBatchTx(txs ...*models.Tx) (*models.Tx, error) {
+	batch := &models.Tx{Payload: &models.Tx_Batch{Batch: &models.BatchTx{Txs: txs}}}
+	if _, err := BatchTxs(batch); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// BatchTxs returns the sub-transactions of a batch transaction, checking
+// they are not empty nor batches themselves.
+func BatchTxs(tx *models.Tx) ([]*models.Tx, error) {
+	batch := tx.GetBatch()
+	if batch == nil {
+		return nil, fmt.Errorf("not a batch transaction")
+	}
+	if len(batch.Txs) == 0 || len(batch.Txs) > MaxBatchTxs {
+		return nil, fmt.Errorf("batch requires between 1 and %d transactions", MaxBatchTxs)
+	}
+	for i, subTx := range batch.Txs {
+		if subTx.GetPayload() == nil {
+			return nil, fmt.Errorf("batch transaction %d is empty", i)
+		}
+		if subTx.GetBatch() != nil {
+			return nil, fmt.Errorf("batch transaction %d is a batch", i)
+		}
+	}
+	return batch.Txs, nil
+}
+
+// BatchSubTx returns the VochainTx of a sub-transaction of the batch, which
+// shares the signatures and the transaction ID of the batch.
+func (tx *VochainTx) BatchSubTx(subTx *models.Tx) *VochainTx {
+	return &VochainTx{
+		Tx:          subTx,
+		SignedBody:  tx.SignedBody,
+		Signature:   tx.Signature,
+		Signatures:  tx.Signatures,
+		TxID:        tx.TxID,
+		TxModelType: txModelType(subTx),
+	}
+}
+
+// txModelType returns the name of the payload type of the transaction.
+func txModelType(tx *models.Tx) string {
+	field := tx.ProtoReflect().WhichOneof(tx.ProtoReflect().Descriptor().Oneofs().Get(0))
+	if field == nil {
+		return ""
+	}
+	return string(field.Name())
+}
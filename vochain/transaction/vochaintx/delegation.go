@@ -0,0 +1,34 @@
+package vochaintx
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.vocdoni.io/proto/build/go/models"
+)
+
+// VoteDelegation wraps information about the vote of a census member
+// delegated to another one in an election.
+type VoteDelegation struct {
+	ProcessID []byte
+	Delegator common.Address
+	Delegate  common.Address
+	// Weight is the census weight of the delegator
+	Weight *big.Int
+}
+
+// VoteDelegate returns the delegate address of the vote envelope, or nil if
+// the envelope is not a delegation.  A vote delegation is a VoteEnvelope
+// without vote package, proven against the census as any other vote, which
+// carries the address of the census member the vote is delegated to.
+func VoteDelegate(envelope *models.VoteEnvelope) (*common.Address, error) {
+	if len(envelope.GetDelegate()) == 0 {
+		return nil, nil
+	}
+	if len(envelope.Delegate) != common.AddressLength {
+		return nil, fmt.Errorf("invalid delegate address length %d", len(envelope.Delegate))
+	}
+	delegate := common.BytesToAddress(envelope.Delegate)
+	return &delegate, nil
+}
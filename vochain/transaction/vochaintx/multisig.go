@@ -0,0 +1,23 @@
+package vochaintx
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.vocdoni.io/dvote/crypto/ethereum"
+)
+
+// Signers returns the addresses of all the signers of the transaction, the
+// main signer first followed by the co-signers.
+func (tx *VochainTx) Signers() ([]common.Address, error) {
+	addrs := []common.Address{}
+	for _, signature := range append([][]byte{tx.Signature}, tx.Signatures...) {
+		// AddrFromSignature modifies the recovery byte of the signature
+		addr, err := ethereum.AddrFromSignature(tx.SignedBody, append([]byte{}, signature...))
+		if err != nil {
+			return nil, fmt.Errorf("cannot recover signer: %w", err)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
@@ -13,9 +13,11 @@ import (
 
 // VochainTx is a wrapper around a protobuf transaction with some helpers
 type VochainTx struct {
-	Tx          *models.Tx
-	SignedBody  []byte
-	Signature   []byte
+	Tx         *models.Tx
+	SignedBody []byte
+	Signature  []byte
+	// Signatures are the signatures of the co-signers of multisig accounts
+	Signatures  [][]byte
 	TxID        [32]byte
 	TxModelType string
 }
@@ -33,8 +35,9 @@ func (tx *VochainTx) Unmarshal(content []byte, chainID string) error {
 		return err
 	}
 
-	tx.TxModelType = string(tx.Tx.ProtoReflect().WhichOneof(tx.Tx.ProtoReflect().Descriptor().Oneofs().Get(0)).Name())
+	tx.TxModelType = txModelType(tx.Tx)
 	tx.Signature = stx.GetSignature()
+	tx.Signatures = stx.GetSignatures()
 	tx.TxID = TxKey(content)
 	tx.SignedBody = ethereum.BuildVocdoniTransaction(stx.GetTx(), chainID)
 	return nil
@@ -52,3 +55,10 @@ type TokenTransfer struct {
 	Amount      uint64
 	TxHash      []byte
 }
+
+// AccountRotation wraps information about an account moved to a new address.
+type AccountRotation struct {
+	FromAddress common.Address
+	ToAddress   common.Address
+	TxHash      []byte
+}
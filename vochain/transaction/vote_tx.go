@@ -5,12 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"time"
 
 	"github.com/vocdoni/arbo"
 	"github.com/vocdoni/go-snark/verifier"
 	"go.vocdoni.io/dvote/crypto/ethereum"
 	"go.vocdoni.io/dvote/crypto/zk"
 	"go.vocdoni.io/dvote/log"
+	"go.vocdoni.io/dvote/vochain/homomorphic"
 	vstate "go.vocdoni.io/dvote/vochain/state"
 	"go.vocdoni.io/dvote/vochain/transaction/vochaintx"
 	"go.vocdoni.io/proto/build/go/models"
@@ -23,42 +25,13 @@ func (t *TransactionHandler) VoteTxCheck(vtx *vochaintx.VochainTx, forCommit boo
 		return nil, fmt.Errorf("vote envelope is nil")
 	}
 
-	// Perform basic checks
-	if voteEnvelope == nil {
-		return nil, fmt.Errorf("vote envelope is nil")
-	}
-	process, err := t.state.Process(voteEnvelope.ProcessId, false)
+	process, err := t.checkVoteProcess(voteEnvelope.ProcessId)
 	if err != nil {
-		return nil, fmt.Errorf("cannot fetch processId: %w", err)
-	}
-	if process == nil || process.EnvelopeType == nil || process.Mode == nil {
-		return nil, fmt.Errorf("process %x malformed", voteEnvelope.ProcessId)
+		return nil, err
 	}
 	height := t.state.CurrentHeight()
 	endBlock := process.StartBlock + process.BlockCount
-
-	if height < process.StartBlock {
-		return nil, fmt.Errorf(
-			"process %x starts at height %d, current height is %d",
-			voteEnvelope.ProcessId, process.StartBlock, height)
-	} else if height > endBlock {
-		return nil, fmt.Errorf(
-			"process %x finished at height %d, current height is %d",
-			voteEnvelope.ProcessId, endBlock, height)
-	}
-
-	if process.Status != models.ProcessStatus_READY {
-		return nil, fmt.Errorf(
-			"process %x not in READY state - current state: %s",
-			voteEnvelope.ProcessId, process.Status.String())
-	}
-
-	// Check in case of keys required, they have been sent by some keykeeper
-	if process.EnvelopeType.EncryptedVotes &&
-		process.KeyIndex != nil &&
-		*process.KeyIndex < 1 {
-		return nil, fmt.Errorf("no keys available, voting is not possible")
-	}
+	_, _, timeBounded := vstate.ProcessTimeBounds(process.Mode)
 
 	var vote *vstate.Vote
 	if process.EnvelopeType.Anonymous {
@@ -199,7 +172,7 @@ func (t *TransactionHandler) VoteTxCheck(vtx *vochaintx.VochainTx, forCommit boo
 			if err := t.checkVoteAlreadyExists(vote.Nullifier, process); err != nil {
 				return nil, err
 			}
-			if height > process.GetStartBlock()+process.GetBlockCount() ||
+			if (!timeBounded && height > endBlock) ||
 				process.GetStatus() != models.ProcessStatus_READY {
 				return nil, fmt.Errorf("vote %x is not longer valid", vote.Nullifier)
 			}
@@ -269,6 +242,16 @@ func (t *TransactionHandler) VoteTxCheck(vtx *vochaintx.VochainTx, forCommit boo
 		}
 		vote.Weight = weight
 	}
+	// Homomorphic ballots are tallied without being decrypted, so they must be
+	// proven to be well formed before being accepted.
+	if process.EnvelopeType.GetHomomorphic() {
+		if err := homomorphic.CheckVoteWeight(vote.Weight); err != nil {
+			return nil, err
+		}
+		if err := homomorphic.VerifyVotePackage(process, vote.VotePackage, vote.Nullifier); err != nil {
+			return nil, fmt.Errorf("invalid homomorphic ballot: %w", err)
+		}
+	}
 	if !forCommit {
 		// add the vote to cache
 		t.state.CacheAdd(vtx.TxID, vote)
@@ -276,6 +259,112 @@ func (t *TransactionHandler) VoteTxCheck(vtx *vochaintx.VochainTx, forCommit boo
 	return vote, nil
 }
 
+// PrunedVote returns the vote of a vote transaction whose process votes were
+// pruned, so it can't be checked again by VoteTxCheck.  The vote is built from
+// the envelope, without weight nor overwrites, and it is nil for the vote
+// delegations.
+func (t *TransactionHandler) PrunedVote(vtx *vochaintx.VochainTx) (*vstate.Vote, error) {
+	voteEnvelope := vtx.Tx.GetVote()
+	if voteEnvelope == nil {
+		return nil, fmt.Errorf("vote envelope is nil")
+	}
+	if delegate, err := vochaintx.VoteDelegate(voteEnvelope); err != nil || delegate != nil {
+		return nil, err
+	}
+	process, err := t.state.Process(voteEnvelope.ProcessId, false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch processId: %w", err)
+	}
+	vote := &vstate.Vote{
+		ProcessID:   voteEnvelope.ProcessId,
+		Height:      t.state.CurrentHeight(),
+		VotePackage: voteEnvelope.VotePackage,
+	}
+	if process.EnvelopeType.EncryptedVotes {
+		vote.EncryptionKeyIndexes = voteEnvelope.EncryptionKeyIndexes
+	}
+	if process.EnvelopeType.Anonymous {
+		vote.Nullifier = voteEnvelope.Nullifier
+		return vote, nil
+	}
+	// PubKeyFromSignature modifies the recovery byte of the signature
+	pubKey, err := ethereum.PubKeyFromSignature(vtx.SignedBody,
+		append([]byte{}, vtx.Signature...))
+	if err != nil {
+		return nil, fmt.Errorf("cannot extract public key from signature: %w", err)
+	}
+	addr, err := ethereum.AddrFromPublicKey(pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot extract address from public key: %w", err)
+	}
+	vote.VoterID = append([]byte{vstate.VoterIDTypeECDSA}, pubKey...)
+	vote.Nullifier = vstate.GenerateNullifier(addr, vote.ProcessID)
+	return vote, nil
+}
+
+// CheckVotingPeriod checks the process accepts votes on a block with the given
+// height and header time (as unix seconds).  Time-bounded processes are checked
+// against the block time, the rest against their block range.  Time-bounded
+// processes without a start time start at their StartBlock.
+func CheckVotingPeriod(process *models.Process, height uint32, timestamp int64) error {
+	start, end, timeBounded := vstate.ProcessTimeBounds(process.Mode)
+	endBlock := process.StartBlock + process.BlockCount
+	if timeBounded {
+		if start.IsZero() && height < process.StartBlock {
+			return fmt.Errorf(
+				"process %x starts at height %d, current height is %d",
+				process.ProcessId, process.StartBlock, height)
+		} else if !vstate.ProcessStartedAt(process, timestamp) {
+			return fmt.Errorf(
+				"process %x starts at %s, current block time is %s",
+				process.ProcessId, start.UTC(), time.Unix(timestamp, 0).UTC())
+		} else if vstate.ProcessEndedAt(process, timestamp) {
+			return fmt.Errorf(
+				"process %x finished at %s, current block time is %s",
+				process.ProcessId, end.UTC(), time.Unix(timestamp, 0).UTC())
+		}
+	} else if height < process.StartBlock {
+		return fmt.Errorf(
+			"process %x starts at height %d, current height is %d",
+			process.ProcessId, process.StartBlock, height)
+	} else if height > endBlock {
+		return fmt.Errorf(
+			"process %x finished at height %d, current height is %d",
+			process.ProcessId, endBlock, height)
+	}
+	return nil
+}
+
+// checkVoteProcess returns the process of a vote, after checking it accepts
+// votes at the current block.
+func (t *TransactionHandler) checkVoteProcess(processID []byte) (*models.Process, error) {
+	process, err := t.state.Process(processID, false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch processId: %w", err)
+	}
+	if process == nil || process.EnvelopeType == nil || process.Mode == nil {
+		return nil, fmt.Errorf("process %x malformed", processID)
+	}
+	height, timestamp := t.state.CurrentHeight(), t.state.CurrentTimestamp()
+	if err := CheckVotingPeriod(process, height, timestamp); err != nil {
+		return nil, err
+	}
+
+	if process.Status != models.ProcessStatus_READY {
+		return nil, fmt.Errorf(
+			"process %x not in READY state - current state: %s",
+			processID, process.Status.String())
+	}
+
+	// Check in case of keys required, they have been sent by some keykeeper
+	if process.EnvelopeType.EncryptedVotes &&
+		process.KeyIndex != nil &&
+		*process.KeyIndex < 1 {
+		return nil, fmt.Errorf("no keys available, voting is not possible")
+	}
+	return process, nil
+}
+
 // checkVoteAlreadyExists checks if a vote can be added to a process, either because it is new or
 // because it is a valid overwrite.
 func (t *TransactionHandler) checkVoteAlreadyExists(nullifier []byte, process *models.Process) error {
@@ -51,6 +51,8 @@ type TransactionCosts struct {
 	AddDelegateForAccount   uint32 `json:"Tx_AddDelegateForAccount"`
 	DelDelegateForAccount   uint32 `json:"Tx_DelDelegateForAccount"`
 	CollectFaucet           uint32 `json:"Tx_CollectFaucet"`
+	SetAccountMultisig      uint32 `json:"Tx_SetAccountMultisig"`
+	RotateAccount           uint32 `json:"Tx_RotateAccount"`
 }
 
 // AsMap returns the contents of TransactionCosts as a map. Its purpose
@@ -82,6 +84,8 @@ var TxCostNameToTxTypeMap = map[string]models.TxType{
 	"AddDelegateForAccount":   models.TxType_ADD_DELEGATE_FOR_ACCOUNT,
 	"DelDelegateForAccount":   models.TxType_DEL_DELEGATE_FOR_ACCOUNT,
 	"CollectFaucet":           models.TxType_COLLECT_FAUCET,
+	"SetAccountMultisig":      models.TxType_SET_ACCOUNT_MULTISIG,
+	"RotateAccount":           models.TxType_ROTATE_ACCOUNT,
 }
 
 // TxCostNameToTxType converts a valid string to a txType
@@ -106,6 +110,8 @@ var TxTypeToCostNameMap = map[models.TxType]string{
 	models.TxType_ADD_DELEGATE_FOR_ACCOUNT:   "AddDelegateForAccount",
 	models.TxType_DEL_DELEGATE_FOR_ACCOUNT:   "DelDelegateForAccount",
 	models.TxType_COLLECT_FAUCET:             "CollectFaucet",
+	models.TxType_SET_ACCOUNT_MULTISIG:       "SetAccountMultisig",
+	models.TxType_ROTATE_ACCOUNT:             "RotateAccount",
 }
 
 // TxTypeToCostName converts a valid txType to a string
@@ -22,6 +22,8 @@ func TestTransactionCostsAsMap(t *testing.T) {
 		DelDelegateForAccount:   1000,
 		CollectFaucet:           1100,
 		CreateAccount:           1200,
+		SetAccountMultisig:      1300,
+		RotateAccount:           1400,
 	}
 	txCostsBytes := txCosts.AsMap()
 
@@ -38,6 +40,8 @@ func TestTransactionCostsAsMap(t *testing.T) {
 		models.TxType_DEL_DELEGATE_FOR_ACCOUNT:   1000,
 		models.TxType_COLLECT_FAUCET:             1100,
 		models.TxType_CREATE_ACCOUNT:             1200,
+		models.TxType_SET_ACCOUNT_MULTISIG:       1300,
+		models.TxType_ROTATE_ACCOUNT:             1400,
 	}
 	qt.Assert(t, txCostsBytes, qt.DeepEquals, expected)
 }
@@ -55,6 +59,8 @@ func TestTxCostNameToTxType(t *testing.T) {
 		"DelDelegateForAccount":   models.TxType_DEL_DELEGATE_FOR_ACCOUNT,
 		"CollectFaucet":           models.TxType_COLLECT_FAUCET,
 		"CreateAccount":           models.TxType_CREATE_ACCOUNT,
+		"SetAccountMultisig":      models.TxType_SET_ACCOUNT_MULTISIG,
+		"RotateAccount":           models.TxType_ROTATE_ACCOUNT,
 	}
 	for k, v := range fields {
 		qt.Assert(t, TxCostNameToTxType(k), qt.Equals, v)
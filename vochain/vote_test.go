@@ -2,7 +2,10 @@ package vochain
 
 import (
 	"encoding/json"
+	"fmt"
+	"math/big"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 	abcitypes "github.com/tendermint/tendermint/abci/types"
@@ -11,6 +14,7 @@ import (
 	"go.vocdoni.io/dvote/db/metadb"
 	"go.vocdoni.io/dvote/types"
 	"go.vocdoni.io/dvote/util"
+	"go.vocdoni.io/dvote/vochain/state"
 	"go.vocdoni.io/proto/build/go/models"
 	"google.golang.org/protobuf/proto"
 )
@@ -168,3 +172,220 @@ func TestVoteOverwrite(t *testing.T) {
 
 	// TODO: add an indexer and check that the vote conent has actually been overwritten
 }
+
+func TestTimeBoundedVote(t *testing.T) {
+	app := TestBaseApplication(t)
+	keys, root, proofs := testCreateKeysAndBuildCensus(t, 10)
+	censusURI := ipfsUrl
+	pid := util.RandomBytes(types.ProcessIDsize)
+	now := time.Now().Unix()
+	process := &models.Process{
+		ProcessId:    pid,
+		StartBlock:   0,
+		EnvelopeType: &models.EnvelopeType{},
+		Mode:         &models.ProcessMode{AutoStart: true},
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 3, MaxValue: 3},
+		Status:       models.ProcessStatus_READY,
+		EntityId:     util.RandomBytes(types.EthereumAddressSize),
+		CensusRoot:   root,
+		CensusURI:    &censusURI,
+		CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+		// the estimated end block is reached before the end time
+		BlockCount: 1,
+	}
+	state.SetProcessTimeBounds(process.Mode, time.Unix(now+100, 0), time.Unix(now+200, 0))
+	qt.Assert(t, app.State.AddProcess(process), qt.IsNil)
+	app.AdvanceTestBlock()
+
+	checkVote := func(key int) error {
+		stx := testBuildSignedVote(t, pid, keys[key], proofs[key], []int{1, 2, 3}, app.ChainID())
+		txBytes, err := proto.Marshal(stx)
+		qt.Assert(t, err, qt.IsNil)
+		if resp := app.CheckTx(abcitypes.RequestCheckTx{Tx: txBytes}); resp.Code != 0 {
+			return fmt.Errorf("%s", resp.Data)
+		}
+		return nil
+	}
+
+	// the process has not started yet
+	qt.Assert(t, checkVote(0), qt.ErrorMatches, ".*starts at.*")
+
+	// the process is open after its estimated end block
+	for i := 0; i < 3; i++ {
+		app.AdvanceTestBlock()
+	}
+	app.State.SetTimestamp(now + 150)
+	qt.Assert(t, checkVote(1), qt.IsNil)
+	qt.Assert(t, app.State.SetProcessStatus(pid, models.ProcessStatus_ENDED, false),
+		qt.ErrorMatches, ".*not interruptible.*")
+
+	// the process is finished once the end time is reached
+	app.State.SetTimestamp(now + 200)
+	qt.Assert(t, checkVote(2), qt.ErrorMatches, ".*finished at.*")
+	qt.Assert(t, app.State.SetProcessStatus(pid, models.ProcessStatus_ENDED, false), qt.IsNil)
+}
+
+func TestTimeBoundedVoteStartBlock(t *testing.T) {
+	app := TestBaseApplication(t)
+	keys, root, proofs := testCreateKeysAndBuildCensus(t, 10)
+	censusURI := ipfsUrl
+	pid := util.RandomBytes(types.ProcessIDsize)
+	now := time.Now().Unix()
+	app.AdvanceTestBlock()
+	process := &models.Process{
+		ProcessId:    pid,
+		StartBlock:   app.State.CurrentHeight() + 2,
+		BlockCount:   10,
+		EnvelopeType: &models.EnvelopeType{},
+		Mode:         &models.ProcessMode{AutoStart: true},
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 3, MaxValue: 3},
+		Status:       models.ProcessStatus_READY,
+		EntityId:     util.RandomBytes(types.EthereumAddressSize),
+		CensusRoot:   root,
+		CensusURI:    &censusURI,
+		CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+	}
+	// without a start time, the process starts at its StartBlock
+	state.SetProcessTimeBounds(process.Mode, time.Time{}, time.Unix(now+200, 0))
+	qt.Assert(t, app.State.AddProcess(process), qt.IsNil)
+	app.State.SetTimestamp(now)
+
+	checkVote := func(key int) error {
+		stx := testBuildSignedVote(t, pid, keys[key], proofs[key], []int{1, 2, 3}, app.ChainID())
+		txBytes, err := proto.Marshal(stx)
+		qt.Assert(t, err, qt.IsNil)
+		if resp := app.CheckTx(abcitypes.RequestCheckTx{Tx: txBytes}); resp.Code != 0 {
+			return fmt.Errorf("%s", resp.Data)
+		}
+		return nil
+	}
+
+	// the process has not reached its StartBlock yet
+	qt.Assert(t, checkVote(0), qt.ErrorMatches, ".*starts at height.*")
+
+	for i := 0; i < 2; i++ {
+		app.AdvanceTestBlock()
+	}
+	app.State.SetTimestamp(now + 10)
+	qt.Assert(t, checkVote(1), qt.IsNil)
+
+	// the process is finished once the end time is reached
+	app.State.SetTimestamp(now + 200)
+	qt.Assert(t, checkVote(2), qt.ErrorMatches, ".*finished at.*")
+}
+
+func TestVoteDelegation(t *testing.T) {
+	app := TestBaseApplication(t)
+	keys, root, proofs := testCreateKeysAndBuildCensus(t, 5)
+	censusURI := ipfsUrl
+	pid := util.RandomBytes(types.ProcessIDsize)
+	process := &models.Process{
+		ProcessId:    pid,
+		StartBlock:   0,
+		EnvelopeType: &models.EnvelopeType{},
+		Mode:         &models.ProcessMode{AutoStart: true},
+		VoteOptions:  &models.ProcessVoteOptions{MaxCount: 3, MaxValue: 3, MaxVoteOverwrites: 1},
+		Status:       models.ProcessStatus_READY,
+		EntityId:     util.RandomBytes(types.EthereumAddressSize),
+		CensusRoot:   root,
+		CensusURI:    &censusURI,
+		CensusOrigin: models.CensusOrigin_OFF_CHAIN_TREE,
+		BlockCount:   1024,
+	}
+	qt.Assert(t, app.State.AddProcess(process), qt.IsNil)
+	app.AdvanceTestBlock()
+
+	delegate := func(from, to int) error {
+		stx := testBuildSignedDelegation(t, pid, keys[from], proofs[from],
+			keys[to], proofs[to], app.ChainID())
+		return testCheckTxDeliverTxCommit(t, app, stx)
+	}
+	vote := func(key int) error {
+		stx := testBuildSignedVote(t, pid, keys[key], proofs[key], []int{1, 2, 3}, app.ChainID())
+		return testCheckTxDeliverTxCommit(t, app, stx)
+	}
+	weight := func(key int) int64 {
+		vote, err := app.State.Vote(pid, state.GenerateNullifier(keys[key].Address(), pid), true)
+		qt.Assert(t, err, qt.IsNil)
+		return new(big.Int).SetBytes(vote.Weight).Int64()
+	}
+
+	// the delegation chain 2 -> 1 -> 0 cannot be closed
+	qt.Assert(t, delegate(1, 0), qt.IsNil)
+	qt.Assert(t, delegate(2, 1), qt.IsNil)
+	qt.Assert(t, delegate(0, 2), qt.ErrorMatches, ".*vote delegation cycle.*")
+	qt.Assert(t, delegate(3, 3), qt.ErrorMatches, ".*vote delegation cycle.*")
+	qt.Assert(t, delegate(1, 3), qt.ErrorMatches, ".*vote already delegated.*")
+
+	// the delegate must belong to the census
+	outsider := util.CreateEthRandomKeysBatch(1)[0]
+	qt.Assert(t, testCheckTxDeliverTxCommit(t, app, testBuildSignedDelegation(t, pid,
+		keys[3], proofs[3], outsider, proofs[4], app.ChainID())),
+		qt.ErrorMatches, ".*not a census member.*")
+
+	// the delegate votes with the weight of the chain
+	qt.Assert(t, vote(0), qt.IsNil)
+	qt.Assert(t, weight(0), qt.Equals, int64(3))
+
+	// a delegator overrides the delegation by voting
+	qt.Assert(t, vote(2), qt.IsNil)
+	qt.Assert(t, weight(2), qt.Equals, int64(1))
+	qt.Assert(t, weight(0), qt.Equals, int64(2))
+
+	// a new delegation is added to the vote already cast
+	qt.Assert(t, delegate(3, 1), qt.IsNil)
+	qt.Assert(t, weight(0), qt.Equals, int64(3))
+
+	// an intermediate delegate overrides, keeping the weight delegated to it
+	qt.Assert(t, vote(1), qt.IsNil)
+	qt.Assert(t, weight(1), qt.Equals, int64(2))
+	qt.Assert(t, weight(0), qt.Equals, int64(1))
+
+	// vote overwrites keep the delegated weight
+	qt.Assert(t, vote(1), qt.IsNil)
+	qt.Assert(t, weight(1), qt.Equals, int64(2))
+
+	// voters cannot delegate
+	qt.Assert(t, vote(4), qt.IsNil)
+	qt.Assert(t, delegate(4, 0), qt.ErrorMatches, ".*already voted.*")
+
+	// the census weight is counted once
+	total := int64(0)
+	for _, key := range []int{0, 1, 2, 4} {
+		total += weight(key)
+	}
+	qt.Assert(t, total, qt.Equals, int64(5))
+}
+
+// testBuildSignedDelegation builds a vote envelope which delegates the vote of
+// key to delegate.
+func testBuildSignedDelegation(t *testing.T, electionID []byte, key *ethereum.SignKeys,
+	proof []byte, delegate *ethereum.SignKeys, delegateProof []byte,
+	chainID string) *models.SignedTx {
+	arboProof := func(siblings []byte) *models.Proof {
+		return &models.Proof{
+			Payload: &models.Proof_Arbo{
+				Arbo: &models.ProofArbo{
+					Type:     models.ProofArbo_BLAKE2B,
+					Siblings: siblings,
+					KeyType:  models.ProofArbo_PUBKEY,
+				},
+			},
+		}
+	}
+	vote := &models.VoteEnvelope{
+		Nonce:             util.RandomBytes(32),
+		ProcessId:         electionID,
+		Proof:             arboProof(proof),
+		Delegate:          delegate.Address().Bytes(),
+		DelegateProof:     arboProof(delegateProof),
+		DelegatePublicKey: delegate.PublicKey(),
+	}
+	stx := &models.SignedTx{}
+	var err error
+	stx.Tx, err = proto.Marshal(&models.Tx{Payload: &models.Tx_Vote{Vote: vote}})
+	qt.Assert(t, err, qt.IsNil)
+	stx.Signature, err = key.SignVocdoniTx(stx.Tx, chainID)
+	qt.Assert(t, err, qt.IsNil)
+	return stx
+}
@@ -147,7 +147,7 @@ func NewVocone(dataDir string, keymanager *ethereum.SignKeys) (*Vocone, error) {
 		downloader.NewDownloader(vc.storage),
 		vc.censusdb,
 		false,
-	)
+	).SetIndexer(vc.sc)
 
 	return vc, err
 }
@@ -176,6 +176,7 @@ func (vc *Vocone) EnableAPI(host string, port int, URLpath string) (*api.API, er
 		api.WalletHandler,
 		api.AccountHandler,
 		api.CensusHandler,
+		api.EventsHandler,
 	)
 }
 